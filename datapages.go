@@ -2,10 +2,16 @@ package datapages
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"time"
+
+	"github.com/romshark/datapages/modules/messaging"
+	"github.com/romshark/datapages/runtime/deferred"
+	"github.com/romshark/datapages/runtime/tabs"
 )
 
 // Component is anything that renders itself, such as a templ.Component.
@@ -14,6 +20,66 @@ type Component interface {
 	Render(ctx context.Context, w io.Writer) error
 }
 
+// Deferred returns a component that renders placeholder first and
+// the component fn resolves to once it's ready, for the part of a page
+// that is slow to load:
+//
+//	templ PageDashboard(stats StatsLoader) {
+//		<h1>Dashboard</h1>
+//		@datapages.Deferred(spinner(), stats.Load)
+//	}
+//
+// The GET handler of the page sends the whole page with the placeholders
+// right away and resolves every deferred component of it concurrently after
+// that, on the same response. Each one replaces its placeholder as it arrives,
+// before or after the page stream opened. The client sees the page
+// immediately instead of waiting for its slowest part.
+//
+// fn receives the context of the request, which ends after the timeout set by
+// [WithDeferredTimeout]. An error fn returns, or the timeout, leaves
+// the placeholder in place and is handed to RecoverError as a
+// [DeferredError] when the App declares it, and logged otherwise.
+//
+// Where nothing follows the page to resolve it, such as in a page
+// re-rendered by an event handler, fn is called as the component is rendered
+// with the same timeout, and placeholder is rendered only when it fails,
+// which is reported like any other.
+func Deferred(
+	placeholder Component,
+	fn func(ctx context.Context) (Component, error),
+) Component {
+	return deferred.New(placeholder, func(ctx context.Context) (deferred.Component, error) {
+		c, err := fn(ctx)
+		if c == nil {
+			return nil, err // A nil Component must stay a nil interface.
+		}
+		return c, err
+	})
+}
+
+// DeferredError is the error RecoverError receives for a [Deferred]
+// component that failed to resolve. Its SSE patches the page as it's streamed,
+// the placeholder of the component is the element Selector matches:
+//
+//	func (*App) RecoverError(err error, sse datapages.SSE) error {
+//		var d datapages.DeferredError
+//		if errors.As(err, &d) {
+//			return sse.PatchElementAt(loadFailed(), d.Selector, datapages.PatchModeInner)
+//		}
+//		return sse.PatchElement(errorToast(err))
+//	}
+type DeferredError struct {
+	// Selector is the CSS selector of the placeholder.
+	Selector string
+
+	// Err is the error the component failed with.
+	Err error
+}
+
+func (e DeferredError) Error() string { return "resolving deferred component: " + e.Err.Error() }
+
+func (e DeferredError) Unwrap() error { return e.Err }
+
 // Signals carries the client-side Datastar signals.
 // GET, action (POST/PUT/PATCH/DELETE) and StreamOpen handlers may
 // receive it as a parameter.
@@ -102,7 +168,9 @@ type Query[Values any] struct{ Values Values }
 // It pairs the two hooks for the same stream. One session can hold several streams,
 // one per open tab, and the ID is what tells them apart: register per-tab state under
 // it in StreamOpen, read that state in the OnXXX handlers,
-// and drop it in StreamClose. It also ties the log lines of one stream together.
+// and drop it in StreamClose. Actions never see it, state they share with the
+// stream of their tab belongs in a [TabState]. It also ties the log lines of
+// one stream together.
 //
 // Keep it server-side and never hand it to clients.
 type StreamID uint64
@@ -139,6 +207,61 @@ type Head Component
 // response headers the cookie would travel in.
 type CloseSession bool
 
+// DeferDispatch is returned by action handlers to hold their events back
+// until the handler has succeeded. Every [Dispatcher] of a handler declaring it
+// collects the events instead of publishing them, and Datapages publishes them
+// in dispatch order once the handler has returned:
+//
+//	func (p PageOrders) POSTPlace(
+//		r *http.Request,
+//		placed datapages.Dispatcher[EventOrderPlaced],
+//	) (deferDispatch datapages.DeferDispatch, err error) {
+//		tx, err := p.App.DB.BeginTx(r.Context(), nil)
+//		if err != nil {
+//			return false, err
+//		}
+//		defer tx.Rollback()
+//		id, err := insertOrder(tx)
+//		if err != nil {
+//			return false, err
+//		}
+//		if err := placed.Dispatch(EventOrderPlaced{ID: id}); err != nil {
+//			return false, err
+//		}
+//		return true, tx.Commit()
+//	}
+//
+// The events are dropped when the handler returns an error or panics,
+// so no stream hears of a change that was rolled back.
+// The zero value drops them too, which lets a handler back out without failing
+// the request. The server logs how many it dropped at debug level.
+// Dispatch only reports errors of the event itself then,
+// a broker refusing it surfaces as the error of the request after the handler returned.
+// Once the handler has succeeded, the events are published even if the client
+// has gone, within the deadline of the request.
+type DeferDispatch bool
+
+// Rerender is returned by event (OnXXX) handlers to re-render the page
+// as its GET handler would, instead of patching the changed parts by hand:
+//
+//	func (p PageOrders) OnOrderPlaced(
+//		event EventOrderPlaced, sse datapages.SSE,
+//	) (rerender datapages.Rerender, err error) {
+//		return true, nil
+//	}
+//
+// Datapages runs the GET handler of the page once the event handler has returned,
+// with the request of the stream: its path, its session and the query of the
+// page URL the stream was opened from, as the Referer header carries it.
+// GET receives no signals. The body it renders is morphed into the <body> of the page.
+//
+// A redirect GET returns navigates the client. A session GET creates or closes
+// can't be set on a stream, the page reloads instead and its GET sets it.
+// A GET that fails leaves the page as it is and its error is logged.
+//
+// The zero value leaves the page to what the handler patched.
+type Rerender bool
+
 // EnableBackgroundStreaming is returned by GET handlers to keep the page's SSE
 // stream open while its browser tab sits in the background.
 // The zero value lets the browser close the stream with the tab.
@@ -234,6 +357,95 @@ type NewSession[Data any] struct {
 	Data Data
 }
 
+// TabState is the state one browser tab keeps on the server while it shows
+// a page, passed to the GET, action and event (OnXXX) handlers of the page
+// as the tab parameter:
+//
+//	type Draft struct{ Lines []string }
+//
+//	func (p PageEditor) POSTAddLine(
+//		r *http.Request, tab datapages.TabState[Draft],
+//		signals datapages.Signals[struct{ Line string `json:"line"` }],
+//	) error {
+//		return tab.Update(func(d *Draft) error {
+//			d.Lines = append(d.Lines, signals.Values.Line)
+//			return nil
+//		})
+//	}
+//
+// GET gives every tab that loads the page an instance ID, which the page sends
+// with its stream and its actions. Two tabs of the same session have separate
+// states. A page load starts over with the zero value, which includes a reload
+// and the refresh of a page coming back from the background unless its GET
+// returns [DisableRefreshAfterHidden].
+//
+// The state lives as long as the stream of the tab, and for the TTL of
+// [WithTabState] after it closed so a tab that reconnects finds it,
+// 30 seconds by default. The state of a tab whose stream never opened
+// expires the TTL after it last changed.
+//
+// The state is JSON-encoded. It's kept in memory, the store of
+// [TabStateConfig] lets it live elsewhere, such as in NATS KV for a server
+// running on several instances.
+//
+// The instance ID comes from the client and identifies nothing but the tab.
+// Authorize with the session, not with what the state holds.
+type TabState[T any] struct {
+	ctx context.Context
+	tab tabs.Tab
+}
+
+// MakeTabState returns the state of tab. It's called by generated code.
+func MakeTabState[T any](ctx context.Context, tab tabs.Tab) TabState[T] {
+	return TabState[T]{ctx: ctx, tab: tab}
+}
+
+// ID is the instance ID of the tab.
+func (s TabState[T]) ID() string { return s.tab.ID }
+
+// Get returns the state of the tab, the zero value when it has none.
+func (s TabState[T]) Get() (v T, err error) {
+	b, err := s.tab.Load(s.ctx)
+	if err != nil || b == nil {
+		return v, err
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return v, fmt.Errorf("decoding tab state: %w", err)
+	}
+	return v, nil
+}
+
+// Set replaces the state of the tab with v.
+func (s TabState[T]) Set(v T) error {
+	return s.Update(func(p *T) error {
+		*p = v
+		return nil
+	})
+}
+
+// Update changes the state of the tab with fn, which receives the current one.
+// An error fn returns leaves the state as it was. Another handler changing the
+// state of the same tab at the same time makes fn run again on what that
+// one left, fn must not have other effects.
+func (s TabState[T]) Update(fn func(*T) error) error {
+	return s.tab.Update(s.ctx, func(b []byte) ([]byte, error) {
+		var v T
+		if b != nil {
+			if err := json.Unmarshal(b, &v); err != nil {
+				return nil, fmt.Errorf("decoding tab state: %w", err)
+			}
+		}
+		if err := fn(&v); err != nil {
+			return nil, err
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("encoding tab state: %w", err)
+		}
+		return b, nil
+	})
+}
+
 // Redirect is returned by handlers as the redirect return value to navigate the
 // client to another URL:
 //
@@ -266,16 +478,25 @@ type SSE interface {
 	Context() context.Context
 
 	// PatchElement patches the elements rendered by c into the DOM.
-	// Each element is morphed into the element carrying its id.
-	// Use [SSE.PatchElementAt] to name a target or to patch in another mode.
-	PatchElement(c Component) error
+	// Each element is morphed into the element carrying its id
+	// unless opts name a target, a mode or a view transition:
+	//
+	//	sse.PatchElement(toast(msg),
+	//		datapages.PatchSelector("#toaster"),
+	//		datapages.PatchModeAppend,
+	//		datapages.PatchViewTransition)
+	//
+	// Of repeated options the last one applies.
+	PatchElement(c Component, opts ...PatchOption) error
 
 	// PatchElementAt patches the elements rendered by c into the element(s)
 	// matching the CSS selector, applying them in the given mode.
+	// It's PatchElement(c, PatchSelector(selectorCSS), mode, opts...).
 	// An empty selector targets by element id, like [SSE.PatchElement] does.
 	// The zero PatchMode morphs using [PatchModeOuter], like [SSE.PatchElement] does.
-	// Any value that is not a [PatchMode] constant is ignored.
-	PatchElementAt(c Component, selectorCSS string, mode PatchMode) error
+	PatchElementAt(
+		c Component, selectorCSS string, mode PatchMode, opts ...PatchOption,
+	) error
 
 	// RemoveElement removes the elements matching the CSS selector from the DOM.
 	RemoveElement(selectorCSS string) error
@@ -296,11 +517,23 @@ type SSE interface {
 
 	// Redirect navigates the client to url by assigning window.location.href,
 	// which pushes a new browser history entry.
-	// To replace the current entry instead, navigate with [SSE.ExecuteScript]:
-	//
-	//	sse.ExecuteScript(fmt.Sprintf("window.location.replace(%q)", url))
+	// Use [SSE.RedirectReplace] to replace the current entry instead.
 	Redirect(url string) error
 
+	// RedirectReplace navigates the client to url with window.location.replace,
+	// which replaces the current browser history entry,
+	// so going back skips the page the client leaves.
+	RedirectReplace(url string) error
+
+	// PushURL shows url in the address bar without navigating,
+	// adding a browser history entry. Going back to the entry reverts
+	// the address bar only, the page stays as it is.
+	PushURL(url string) error
+
+	// ReplaceURL shows url in the address bar without navigating,
+	// replacing the current browser history entry.
+	ReplaceURL(url string) error
+
 	// Prefetch asks the browser to prefetch urls through the speculation rules API.
 	// Browsers without support for it ignore the request.
 	//
@@ -308,11 +541,65 @@ type SSE interface {
 	Prefetch(urls ...string) error
 }
 
+// PatchOption configures how [SSE.PatchElement] applies elements.
+// [PatchMode], [PatchSelector] and [PatchViewTransition] are patch options.
+type PatchOption interface{ applyPatch(*PatchOptions) }
+
+// PatchOptions is what a list of [PatchOption] amounts to.
+type PatchOptions struct {
+	// Selector is the CSS selector of the target element(s),
+	// empty to target each element by its id.
+	Selector string
+
+	// Mode is one of the [PatchMode] constants or empty for [PatchModeOuter].
+	Mode PatchMode
+
+	// ViewTransition is true for a patch applied in a view transition.
+	ViewTransition bool
+}
+
+// NewPatchOptions applies opts in order. A [PatchMode] that isn't
+// one of the constants is ignored. It's called by the SSE implementation.
+func NewPatchOptions(opts ...PatchOption) PatchOptions {
+	var o PatchOptions
+	for _, opt := range opts {
+		if opt != nil {
+			opt.applyPatch(&o)
+		}
+	}
+	return o
+}
+
+// PatchSelector is a [PatchOption] naming the CSS selector of the element(s)
+// to patch instead of targeting each element by its id.
+type PatchSelector string
+
+func (s PatchSelector) applyPatch(o *PatchOptions) { o.Selector = string(s) }
+
+// PatchViewTransition is a [PatchOption] applying the patch in a view transition,
+// in browsers supporting the View Transition API. Others apply it as usual.
+//
+// https://developer.mozilla.org/en-US/docs/Web/API/View_Transition_API
+const PatchViewTransition patchViewTransition = true
+
+type patchViewTransition bool
+
+func (v patchViewTransition) applyPatch(o *PatchOptions) { o.ViewTransition = bool(v) }
+
 // PatchMode determines how patched elements are applied to the DOM.
+// It's a [PatchOption].
 // Removal has no mode, use [SSE.RemoveElement] instead.
 // Zero value is equivalent to [PatchModeOuter].
 type PatchMode string
 
+func (m PatchMode) applyPatch(o *PatchOptions) {
+	switch m {
+	case "", PatchModeOuter, PatchModeInner, PatchModeReplace,
+		PatchModePrepend, PatchModeAppend, PatchModeBefore, PatchModeAfter:
+		o.Mode = m
+	}
+}
+
 const (
 	// PatchModeOuter (default) morphs the element into the existing element.
 	PatchModeOuter PatchMode = "outer"
@@ -473,4 +760,60 @@ type Dispatcher[Event any] interface {
 	//		return nil // Return OK immediately, dispatch event asynchronously.
 	//	}
 	DispatchCtx(ctx context.Context, event Event) error
+
+	// DispatchAt publishes the event at t, or right away when t isn't
+	// in the future. The subject is checked at the call, an event that
+	// can't be published fails here rather than when it's due.
+	// The message broker must implement messaging.Scheduler,
+	// otherwise DispatchAt returns messaging.ErrNoScheduler.
+	// Whether the event survives a restart is up to the broker:
+	// inmem keeps timers and loses them, natscore.ScheduledBroker keeps
+	// the schedule in NATS KV.
+	//
+	// ctx is used for the scheduling. The publish itself happens
+	// after the handler returned, without a context of its own:
+	//
+	//	// POSTDelete is /mail/{id}/delete
+	//	func (p PageMail) POSTDelete(
+	//		r *http.Request,
+	//		path struct {
+	//			ID string `path:"id"`
+	//		},
+	//		purge datapages.Dispatcher[EventMailPurgeDue],
+	//	) error {
+	//		if err := p.App.MoveToTrash(r.Context(), path.ID); err != nil {
+	//			return err
+	//		}
+	//		// Undo is possible until the event is handled.
+	//		return purge.DispatchAfter(
+	//			r.Context(), 10*time.Second, EventMailPurgeDue{ID: path.ID},
+	//		)
+	//	}
+	DispatchAt(ctx context.Context, t time.Time, event Event) error
+
+	// DispatchAfter is [Dispatcher.DispatchAt] at delay from now.
+	DispatchAfter(ctx context.Context, delay time.Duration, event Event) error
+}
+
+// DispatchTo publishes event the way d does, but to p and right away.
+// The broker interceptors of the server are installed on p.
+// It ties an event to a transaction with a publisher writing into it,
+// see outbox.Broker.Tx:
+//
+//	err = datapages.DispatchTo(
+//		r.Context(), p.App.Outbox.Tx(tx), placed, EventOrderPlaced{ID: id},
+//	)
+//
+// A handler returning [DeferDispatch] doesn't hold the event back,
+// what holds it back is p.
+func DispatchTo[Event any](
+	ctx context.Context, p messaging.Publisher, d Dispatcher[Event], event Event,
+) error {
+	dt, ok := d.(interface {
+		DispatchTo(ctx context.Context, p messaging.Publisher, event Event) error
+	})
+	if !ok {
+		return fmt.Errorf("DispatchTo: %T isn't a generated dispatcher", d)
+	}
+	return dt.DispatchTo(ctx, p, event)
 }
@@ -4,7 +4,6 @@ package datapagesgen
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -17,14 +16,21 @@ import (
 	"time"
 
 	"github.com/romshark/datapages"
+	"github.com/romshark/datapages/modules/codec"
 	"github.com/romshark/datapages/modules/messaging"
 	"github.com/romshark/datapages/modules/sessions"
 	"github.com/romshark/datapages/runtime/auth"
+	"github.com/romshark/datapages/runtime/deferred"
+	"github.com/romshark/datapages/runtime/dispatch"
+	"github.com/romshark/datapages/runtime/eventcache"
 	"github.com/romshark/datapages/runtime/htmlattr"
 	"github.com/romshark/datapages/runtime/httpread"
 	"github.com/romshark/datapages/runtime/httpserve"
+	"github.com/romshark/datapages/runtime/jsonenc"
+	"github.com/romshark/datapages/runtime/reqlog"
 	dpsse "github.com/romshark/datapages/runtime/sse"
 	"github.com/romshark/datapages/runtime/subject"
+	"github.com/romshark/datapages/runtime/tracing"
 
 	"github.com/romshark/datapages/example/classifieds/app"
 	"github.com/romshark/datapages/example/classifieds/app/datapagesgen/assets"
@@ -66,10 +72,13 @@ func assetsFileSystem(cfg datapages.ServerConfig) (http.FileSystem, error) {
 }
 
 // brokerMetrics implements messaging.Metrics using the built-in Prometheus counters.
-type brokerMetrics struct{}
+type brokerMetrics struct {
+	// prefix is stripped from a subject before it's counted by its kind.
+	prefix string
+}
 
 func (m brokerMetrics) OnPublish(subject string) {
-	prom.BrokerPublish(brokerSubjectKind(subject))
+	prom.BrokerPublish(brokerSubjectKind(strings.TrimPrefix(subject, m.prefix)))
 }
 
 func (m brokerMetrics) OnDeliveryDropped() {
@@ -121,13 +130,18 @@ func (s *Server) writeHTML(
 	if err != nil {
 		return err
 	}
+	// The deferred components of the body follow it on the same response,
+	// the ones of the head are resolved as it's rendered.
+	ctx, deferreds := deferred.Start(r.Context(), s.DeferredTimeout(),
+		func(w io.Writer, id string, err error) { s.recoverDeferred(w, r, id, err) })
+	headCtx := deferred.InPlace(ctx)
 	if headGeneric != nil {
-		if err := headGeneric.Render(r.Context(), w); err != nil {
+		if err := headGeneric.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
 	if head != nil {
-		if err := head.Render(r.Context(), w); err != nil {
+		if err := head.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
@@ -171,7 +185,7 @@ func (s *Server) writeHTML(
 		return err
 	}
 	if body != nil {
-		if err := body.Render(r.Context(), w); err != nil {
+		if err := body.Render(ctx, w); err != nil {
 			return err
 		}
 	}
@@ -184,12 +198,15 @@ func (s *Server) writeHTML(
 			return err
 		}
 	}
+	if deferreds.Len() > 0 {
+		deferreds.Resolve(r.Context(), w)
+	}
 	_, err = io.WriteString(w, "</body></html>")
 	return err
 }
 
 func (s *Server) handleStreamRequest(
-	w http.ResponseWriter, r *http.Request, sessKey string, sess datapages.Session[struct{}],
+	w http.ResponseWriter, r *http.Request, page string, sessKey string, sess datapages.Session[struct{}],
 	subjects []string,
 	onOpen func(
 		streamID datapages.StreamID,
@@ -205,23 +222,43 @@ func (s *Server) handleStreamRequest(
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
+	// A server shutting down opens no streams, the client reconnects
+	// to another instance.
+	if s.Draining() {
+		http.Error(w,
+			http.StatusText(http.StatusServiceUnavailable),
+			http.StatusServiceUnavailable)
+		return
+	}
 
 	streamID := datapages.StreamID(s.streamSeq.Add(1))
+	reqlog.SetStream(r.Context(), uint64(streamID))
+
+	// A stream would wait for events that can't arrive while the broker is
+	// down. It's refused, and closed once the broker goes down later, so the
+	// client reconnects and renders what it missed when the broker is back.
+	brokerUp, brokerChanged := messaging.StatusOf(s.messageBroker)
+	if !brokerUp {
+		s.httpErrIntern(w, r, nil, "opening stream", messaging.ErrBrokerUnavailable)
+		return
+	}
 
 	// The subscription is established before the response head goes out.
 	// A client learns the stream is open by reading that head and may dispatch
 	// immediately after, which must not reach the broker before this.
 	ctx := r.Context()
-	sub, err := s.messageBroker.Subscribe(ctx, s.messageBrokerMetrics, subjects...)
+	sub, err := s.messageBroker.Subscribe(ctx, s.messageBrokerMetrics,
+		subject.InNamespace(s.subjectPrefix, subjects)...)
 	if err != nil {
 		// Nothing has been written yet, so the error can still carry a status.
 		s.httpErrIntern(w, r, nil, "subscribing to message broker", err)
 		return
 	}
 
-	sse := datastar.NewSSE(w, r, datastar.WithCompression())
-	prom.SSEConnectionOpened()
-	defer prom.SSEConnectionClosed()
+	// The heartbeats and the retry hint go out beside the events of sse.
+	sse, sseW := dpsse.NewSSE(w, r)
+	prom.SSEConnectionOpened(page)
+	defer prom.SSEConnectionClosed(page)
 	start := time.Now()
 
 	subC := sub.C()
@@ -246,13 +283,34 @@ func (s *Server) handleStreamRequest(
 	}
 
 	go func() {
-		select {
-		case <-sessionClosed:
-			prom.SSEDisconnect("close")
-		case <-r.Context().Done():
-			prom.SSEDisconnect("client")
-		case <-s.ShutdownCh():
-			prom.SSEDisconnect("shutdown")
+		heartbeat, expired, stopTimers := s.StreamTimers()
+		defer stopTimers()
+		shutdown := s.ShutdownCh()
+		var drained <-chan time.Time
+		// A heartbeat goes out on the stream and the wait goes on, so does
+		// the drain the shutdown begins. Any other case ends the stream.
+		for {
+			select {
+			case <-heartbeat:
+				_ = dpsse.Heartbeat(sseW)
+				continue
+			case <-shutdown:
+				shutdown, drained = nil, s.DrainTimer()
+				continue
+			case <-drained:
+				_ = dpsse.Expire(sseW, s.DrainRetry())
+				prom.SSEDisconnect("shutdown")
+			case <-expired:
+				_ = dpsse.Expire(sseW, s.StreamRetry())
+				prom.SSEDisconnect("ttl")
+			case <-sessionClosed:
+				prom.SSEDisconnect("close")
+			case <-r.Context().Done():
+				prom.SSEDisconnect("client")
+			case <-brokerChanged:
+				prom.SSEDisconnect("broker")
+			}
+			break
 		}
 		prom.SSEConnectionDuration(start)
 		sub.Close()
@@ -269,7 +327,20 @@ type Server struct {
 	messageBroker        messaging.Broker
 	messageBrokerMetrics brokerMetrics
 	streamSeq            atomic.Uint64
-	app                  *app.App
+	// subjectPrefix is what every subject starts with on the wire,
+	// empty without datapages.WithSubjectPrefix.
+	subjectPrefix string
+	// tracer is nil without datapages.WithTracerProvider.
+	tracer     tracing.Tracer
+	app        *app.App
+	eventCodec codec.Codec
+	eventCache *eventcache.Cache
+	// jsonEvents is true when eventCodec is codec.JSON, which the generated
+	// encoders produce the payload of without reflection.
+	jsonEvents bool
+	// brokerInterceptors are those of datapages.WithBrokerInterceptors,
+	// which datapages.DispatchTo installs on the publisher it's given.
+	brokerInterceptors []messaging.Interceptor
 	*auth.Manager[struct{}]
 }
 
@@ -285,6 +356,10 @@ type Server struct {
 //   - datapages.WithHTTPServer
 //   - datapages.WithDatastarJS
 //   - datapages.WithAssets
+//   - datapages.WithSubjectPrefix
+//   - datapages.WithBrokerInterceptors
+//   - datapages.WithTracerProvider
+//   - datapages.WithEventCodec
 //   - datapages.WithSessionManager (required)
 //   - datapages.WithSessions
 //   - datapages.WithCSRFProtection
@@ -300,6 +375,10 @@ func (s *Server) Init(
 		// hence the metrics it counts must be served.
 		return errors.New("missing option WithPrometheus")
 	}
+	if cfg.MeterProvider != nil {
+		return errors.New("unexpected option WithMeterProvider: " +
+			"the server is generated with datapages.EnablePrometheus")
+	}
 	if sessionManager == nil {
 		return errors.New("missing option WithSessionManager")
 	}
@@ -313,15 +392,31 @@ func (s *Server) Init(
 	s.Core = httpserve.NewCore(cfg, assets.URLPrefix)
 	s.app = app
 	s.messageBroker = messageBroker
-	s.messageBrokerMetrics = brokerMetrics{}
+	s.tracer = tracing.NewTracer(cfg.TracerProvider)
+	if cfg.SubjectPrefix != "" {
+		s.subjectPrefix = subject.Prefix(cfg.SubjectPrefix)
+	}
+	s.messageBrokerMetrics = brokerMetrics{prefix: s.subjectPrefix}
+	s.eventCodec = cfg.EventCodec
+	s.eventCache = eventcache.New(eventcache.DefaultSize)
+	_, s.jsonEvents = cfg.EventCodec.(codec.JSON)
+	s.brokerInterceptors = cfg.BrokerInterceptors
 
 	if si, ok := s.messageBroker.(messaging.StreamInitializer); ok {
-		if err := si.InitStreams(MessageBrokerStreamSubjects()); err != nil {
+		subjects := subject.InNamespace(s.subjectPrefix, MessageBrokerStreamSubjects())
+		if err := si.InitStreams(subjects); err != nil {
 			return fmt.Errorf("initializing message broker streams: %w", err)
 		}
 	}
 	s.Manager = auth.NewManager(s.Core, sessionManager, cfg, prom.AuthMetrics{})
 
+	s.AddReadinessCheck("broker", func(ctx context.Context) error {
+		return messaging.Ping(ctx, s.messageBroker)
+	})
+	if p, ok := sessionManager.(sessions.Pinger); ok {
+		s.AddReadinessCheck("sessions", p.Ping)
+	}
+
 	setupHandlers(s)
 	if assetsFS != nil {
 		h := http.StripPrefix(assets.URLPrefix, http.FileServer(assetsFS))
@@ -582,7 +677,8 @@ func (s *Server) httpErrIntern(
 	w http.ResponseWriter, r *http.Request,
 	sse *datastar.ServerSentEventGenerator, msg string, err error,
 ) {
-	s.LogErr(msg, err)
+	s.LogErrCtx(r.Context(), msg, err)
+	tracing.FailRequest(r, err)
 	if !httpserve.IsDatastarRequest(r) {
 		// A page load gets the app's own 500 page, with the status that
 		// says what happened. The page's own route serves 200;
@@ -605,7 +701,7 @@ func (s *Server) httpErrIntern(
 	}
 	// RecoverError failed — fall back to HTTP error response.
 	prom.InternalErrorNotRecovered()
-	s.Logger().Error("recovering error",
+	s.RequestLogger(r.Context()).Error("recovering error",
 		slog.Any("orig.msg", msg),
 		slog.Any("orig.err", err),
 		slog.Any("err", errRecover))
@@ -628,6 +724,20 @@ func (s *Server) httpErrIntern(
 	}
 }
 
+func (s *Server) recoverDeferred(
+	w io.Writer, r *http.Request, id string, err error,
+) {
+	err = datapages.DeferredError{Selector: "#" + id, Err: err}
+	errRecover := s.app.RecoverError(err, dpsse.NewInline(r.Context(), w))
+	if errRecover == nil {
+		return // Feedback delivered gracefully.
+	}
+	s.RequestLogger(r.Context()).Error("recovering error",
+		slog.Any("orig.msg", "resolving deferred component"),
+		slog.Any("orig.err", err),
+		slog.Any("err", errRecover))
+}
+
 func (s *Server) render404(w http.ResponseWriter, r *http.Request) {
 	// The URL is claimed by no page. Whatever the app renders for it,
 	// the response says so: a cache that stores it and a crawler that
@@ -658,12 +768,17 @@ func (s *Server) render404(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, datapages.Session[struct{}]{}, genericHead, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageError404", err)
+		s.LogErrCtx(r.Context(), "rendering PageError404", err)
 		return
 	}
 }
 
 func (s *Server) handlePOSTSignOut(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "App.POSTSignOut")
+	defer span.End()
+	reqlog.SetHandler(r, "App.POSTSignOut")
+	defer prom.HandlerDuration("App", "POSTSignOut", time.Now())
+
 	sess, sessToken, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -685,6 +800,11 @@ func (s *Server) handlePOSTSignOut(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handlePOSTCause500(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "App.POSTCause500")
+	defer span.End()
+	reqlog.SetHandler(r, "App.POSTCause500")
+	defer prom.HandlerDuration("App", "POSTCause500", time.Now())
+
 	// CSRF protection covers every state-changing action, including
 	// the ones that read nothing of the session.
 	if _, _, ok := s.ReadSession(w, r); !ok {
@@ -698,6 +818,11 @@ func (s *Server) handlePOSTCause500(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handlePageError404GET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageError404.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageError404.GET")
+	defer prom.HandlerDuration("PageError404", "GET", time.Now())
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -730,12 +855,13 @@ func (s *Server) handlePageError404GET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, genericHead, nil, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageError404", err)
+		s.LogErrCtx(r.Context(), "rendering PageError404", err)
 		return
 	}
 }
 
 func (s *Server) handlePageError404GETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageError404.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -758,41 +884,67 @@ func (s *Server) handlePageError404GETStream(w http.ResponseWriter, r *http.Requ
 			App: s.app,
 		},
 	}
-	s.handleStreamRequest(w, r, sessToken, sess, evSubjPageError404(sess.UserID()),
+	s.handleStreamRequest(w, r, "PageError404", sessToken, sess, evSubjPageError404(sess.UserID()),
 		nil,
 		nil,
 		func(
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventMessagingSent app.EventMessagingSent
-			var eventMessagingRead app.EventMessagingRead
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch {
 				case strings.HasPrefix(msg.Subject, EvSubjPrefMessagingSent):
-					eventMessagingSent = app.EventMessagingSent{}
-					if err := json.Unmarshal(msg.Data, &eventMessagingSent); err != nil {
-						s.LogErr("unmarshaling EventMessagingSent JSON", err)
+					eventMessagingSent, err := eventcache.Decode[app.EventMessagingSent](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventMessagingSent", err)
 						continue
 					}
-					if err := p.OnMessagingSent(eventMessagingSent, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageError404.OnMessagingSent", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageError404.OnMessagingSent", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageError404.OnMessagingSent", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnMessagingSent(eventMessagingSent, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageError404", "OnMessagingSent", "EventMessagingSent")
+							s.LogErrCtx(ctx, "handling PageError404.OnMessagingSent", err)
+						}
+						prom.EventHandlerDuration("PageError404", "OnMessagingSent", "EventMessagingSent", start)
+					}()
 				case strings.HasPrefix(msg.Subject, EvSubjPrefMessagingRead):
-					eventMessagingRead = app.EventMessagingRead{}
-					if err := json.Unmarshal(msg.Data, &eventMessagingRead); err != nil {
-						s.LogErr("unmarshaling EventMessagingRead JSON", err)
+					eventMessagingRead, err := eventcache.Decode[app.EventMessagingRead](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventMessagingRead", err)
 						continue
 					}
-					if err := p.OnMessagingRead(eventMessagingRead, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageError404.OnMessagingRead", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageError404.OnMessagingRead", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageError404.OnMessagingRead", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnMessagingRead(eventMessagingRead, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageError404", "OnMessagingRead", "EventMessagingRead")
+							s.LogErrCtx(ctx, "handling PageError404.OnMessagingRead", err)
+						}
+						prom.EventHandlerDuration("PageError404", "OnMessagingRead", "EventMessagingRead", start)
+					}()
 				}
 			}
 		})
 }
 
 func (s *Server) handlePageError500GET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageError500.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageError500.GET")
+	defer prom.HandlerDuration("PageError500", "GET", time.Now())
+
 	p := app.PageError500{
 		App: s.app,
 	}
@@ -812,12 +964,17 @@ func (s *Server) handlePageError500GET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, datapages.Session[struct{}]{}, genericHead, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageError500", err)
+		s.LogErrCtx(r.Context(), "rendering PageError500", err)
 		return
 	}
 }
 
 func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.GET")
+	defer prom.HandlerDuration("PageIndex", "GET", time.Now())
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -855,12 +1012,13 @@ func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, genericHead, nil, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageIndex", err)
+		s.LogErrCtx(r.Context(), "rendering PageIndex", err)
 		return
 	}
 }
 
 func (s *Server) handlePageIndexGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageIndex.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -883,41 +1041,67 @@ func (s *Server) handlePageIndexGETStream(w http.ResponseWriter, r *http.Request
 			App: s.app,
 		},
 	}
-	s.handleStreamRequest(w, r, sessToken, sess, evSubjPageIndex(sess.UserID()),
+	s.handleStreamRequest(w, r, "PageIndex", sessToken, sess, evSubjPageIndex(sess.UserID()),
 		nil,
 		nil,
 		func(
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventMessagingSent app.EventMessagingSent
-			var eventMessagingRead app.EventMessagingRead
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch {
 				case strings.HasPrefix(msg.Subject, EvSubjPrefMessagingSent):
-					eventMessagingSent = app.EventMessagingSent{}
-					if err := json.Unmarshal(msg.Data, &eventMessagingSent); err != nil {
-						s.LogErr("unmarshaling EventMessagingSent JSON", err)
+					eventMessagingSent, err := eventcache.Decode[app.EventMessagingSent](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventMessagingSent", err)
 						continue
 					}
-					if err := p.OnMessagingSent(eventMessagingSent, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageIndex.OnMessagingSent", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageIndex.OnMessagingSent", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageIndex.OnMessagingSent", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnMessagingSent(eventMessagingSent, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageIndex", "OnMessagingSent", "EventMessagingSent")
+							s.LogErrCtx(ctx, "handling PageIndex.OnMessagingSent", err)
+						}
+						prom.EventHandlerDuration("PageIndex", "OnMessagingSent", "EventMessagingSent", start)
+					}()
 				case strings.HasPrefix(msg.Subject, EvSubjPrefMessagingRead):
-					eventMessagingRead = app.EventMessagingRead{}
-					if err := json.Unmarshal(msg.Data, &eventMessagingRead); err != nil {
-						s.LogErr("unmarshaling EventMessagingRead JSON", err)
+					eventMessagingRead, err := eventcache.Decode[app.EventMessagingRead](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventMessagingRead", err)
 						continue
 					}
-					if err := p.OnMessagingRead(eventMessagingRead, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageIndex.OnMessagingRead", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageIndex.OnMessagingRead", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageIndex.OnMessagingRead", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnMessagingRead(eventMessagingRead, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageIndex", "OnMessagingRead", "EventMessagingRead")
+							s.LogErrCtx(ctx, "handling PageIndex.OnMessagingRead", err)
+						}
+						prom.EventHandlerDuration("PageIndex", "OnMessagingRead", "EventMessagingRead", start)
+					}()
 				}
 			}
 		})
 }
 
 func (s *Server) handlePageLoginGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageLogin.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageLogin.GET")
+	defer prom.HandlerDuration("PageLogin", "GET", time.Now())
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -945,7 +1129,7 @@ func (s *Server) handlePageLoginGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, genericHead, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageLogin", err)
+		s.LogErrCtx(r.Context(), "rendering PageLogin", err)
 		return
 	}
 }
@@ -953,6 +1137,11 @@ func (s *Server) handlePageLoginGET(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handlePageLoginPOSTSubmit(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageLogin.POSTSubmit")
+	defer span.End()
+	reqlog.SetHandler(r, "PageLogin.POSTSubmit")
+	defer prom.HandlerDuration("PageLogin", "POSTSubmit", time.Now())
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -990,12 +1179,17 @@ func (s *Server) handlePageLoginPOSTSubmit(
 	if err := s.writeHTML(
 		w, r, sess, genericHead, nil, body, nil, nil,
 	); err != nil {
-		s.LogErr("rendering response of PageLogin.POSTSubmit", err)
+		s.LogErrCtx(r.Context(), "rendering response of PageLogin.POSTSubmit", err)
 		return
 	}
 }
 
 func (s *Server) handlePageMessagesGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageMessages.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageMessages.GET")
+	defer prom.HandlerDuration("PageMessages", "GET", time.Now())
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -1053,12 +1247,13 @@ func (s *Server) handlePageMessagesGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, genericHead, nil, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageMessages", err)
+		s.LogErrCtx(r.Context(), "rendering PageMessages", err)
 		return
 	}
 }
 
 func (s *Server) handlePageMessagesGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageMessages.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -1081,55 +1276,96 @@ func (s *Server) handlePageMessagesGETStream(w http.ResponseWriter, r *http.Requ
 			App: s.app,
 		},
 	}
-	s.handleStreamRequest(w, r, sessToken, sess, evSubjPageMessages(sess.UserID()),
+	s.handleStreamRequest(w, r, "PageMessages", sessToken, sess, evSubjPageMessages(sess.UserID()),
 		nil,
 		nil,
 		func(
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventMessagingRead app.EventMessagingRead
-			var eventMessagingWriting app.EventMessagingWriting
-			var eventMessagingWritingStopped app.EventMessagingWritingStopped
-			var eventMessagingSent app.EventMessagingSent
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch {
 				case strings.HasPrefix(msg.Subject, EvSubjPrefMessagingRead):
-					eventMessagingRead = app.EventMessagingRead{}
-					if err := json.Unmarshal(msg.Data, &eventMessagingRead); err != nil {
-						s.LogErr("unmarshaling EventMessagingRead JSON", err)
+					eventMessagingRead, err := eventcache.Decode[app.EventMessagingRead](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventMessagingRead", err)
 						continue
 					}
-					if err := p.OnMessagingRead(eventMessagingRead, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageMessages.OnMessagingRead", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageMessages.OnMessagingRead", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageMessages.OnMessagingRead", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnMessagingRead(eventMessagingRead, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageMessages", "OnMessagingRead", "EventMessagingRead")
+							s.LogErrCtx(ctx, "handling PageMessages.OnMessagingRead", err)
+						}
+						prom.EventHandlerDuration("PageMessages", "OnMessagingRead", "EventMessagingRead", start)
+					}()
 				case strings.HasPrefix(msg.Subject, EvSubjPrefMessagingWriting):
-					eventMessagingWriting = app.EventMessagingWriting{}
-					if err := json.Unmarshal(msg.Data, &eventMessagingWriting); err != nil {
-						s.LogErr("unmarshaling EventMessagingWriting JSON", err)
+					eventMessagingWriting, err := eventcache.Decode[app.EventMessagingWriting](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventMessagingWriting", err)
 						continue
 					}
-					if err := p.OnMessagingWriting(eventMessagingWriting, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageMessages.OnMessagingWriting", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageMessages.OnMessagingWriting", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageMessages.OnMessagingWriting", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnMessagingWriting(eventMessagingWriting, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageMessages", "OnMessagingWriting", "EventMessagingWriting")
+							s.LogErrCtx(ctx, "handling PageMessages.OnMessagingWriting", err)
+						}
+						prom.EventHandlerDuration("PageMessages", "OnMessagingWriting", "EventMessagingWriting", start)
+					}()
 				case strings.HasPrefix(msg.Subject, EvSubjPrefMessagingWritingStopped):
-					eventMessagingWritingStopped = app.EventMessagingWritingStopped{}
-					if err := json.Unmarshal(msg.Data, &eventMessagingWritingStopped); err != nil {
-						s.LogErr("unmarshaling EventMessagingWritingStopped JSON", err)
+					eventMessagingWritingStopped, err := eventcache.Decode[app.EventMessagingWritingStopped](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventMessagingWritingStopped", err)
 						continue
 					}
-					if err := p.OnMessagingWritingStopped(eventMessagingWritingStopped, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageMessages.OnMessagingWritingStopped", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageMessages.OnMessagingWritingStopped", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageMessages.OnMessagingWritingStopped", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnMessagingWritingStopped(eventMessagingWritingStopped, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageMessages", "OnMessagingWritingStopped", "EventMessagingWritingStopped")
+							s.LogErrCtx(ctx, "handling PageMessages.OnMessagingWritingStopped", err)
+						}
+						prom.EventHandlerDuration("PageMessages", "OnMessagingWritingStopped", "EventMessagingWritingStopped", start)
+					}()
 				case strings.HasPrefix(msg.Subject, EvSubjPrefMessagingSent):
-					eventMessagingSent = app.EventMessagingSent{}
-					if err := json.Unmarshal(msg.Data, &eventMessagingSent); err != nil {
-						s.LogErr("unmarshaling EventMessagingSent JSON", err)
+					eventMessagingSent, err := eventcache.Decode[app.EventMessagingSent](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventMessagingSent", err)
 						continue
 					}
-					if err := p.OnMessagingSent(eventMessagingSent, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageMessages.OnMessagingSent", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageMessages.OnMessagingSent", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageMessages.OnMessagingSent", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnMessagingSent(eventMessagingSent, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageMessages", "OnMessagingSent", "EventMessagingSent")
+							s.LogErrCtx(ctx, "handling PageMessages.OnMessagingSent", err)
+						}
+						prom.EventHandlerDuration("PageMessages", "OnMessagingSent", "EventMessagingSent", start)
+					}()
 				}
 			}
 		})
@@ -1138,6 +1374,11 @@ func (s *Server) handlePageMessagesGETStream(w http.ResponseWriter, r *http.Requ
 func (s *Server) handlePageMessagesPOSTRead(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageMessages.POSTRead")
+	defer span.End()
+	reqlog.SetHandler(r, "PageMessages.POSTRead")
+	defer prom.HandlerDuration("PageMessages", "POSTRead", time.Now())
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -1176,6 +1417,11 @@ func (s *Server) handlePageMessagesPOSTRead(
 func (s *Server) handlePageMessagesPOSTWriting(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageMessages.POSTWriting")
+	defer span.End()
+	reqlog.SetHandler(r, "PageMessages.POSTWriting")
+	defer prom.HandlerDuration("PageMessages", "POSTWriting", time.Now())
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -1209,6 +1455,11 @@ func (s *Server) handlePageMessagesPOSTWriting(
 func (s *Server) handlePageMessagesPOSTWritingStopped(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageMessages.POSTWritingStopped")
+	defer span.End()
+	reqlog.SetHandler(r, "PageMessages.POSTWritingStopped")
+	defer prom.HandlerDuration("PageMessages", "POSTWritingStopped", time.Now())
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -1242,6 +1493,11 @@ func (s *Server) handlePageMessagesPOSTWritingStopped(
 func (s *Server) handlePageMessagesPOSTSendMessage(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageMessages.POSTSendMessage")
+	defer span.End()
+	reqlog.SetHandler(r, "PageMessages.POSTSendMessage")
+	defer prom.HandlerDuration("PageMessages", "POSTSendMessage", time.Now())
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -1276,6 +1532,11 @@ func (s *Server) handlePageMessagesPOSTSendMessage(
 }
 
 func (s *Server) handlePageMyPostsGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageMyPosts.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageMyPosts.GET")
+	defer prom.HandlerDuration("PageMyPosts", "GET", time.Now())
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -1311,12 +1572,13 @@ func (s *Server) handlePageMyPostsGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, genericHead, head, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageMyPosts", err)
+		s.LogErrCtx(r.Context(), "rendering PageMyPosts", err)
 		return
 	}
 }
 
 func (s *Server) handlePageMyPostsGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageMyPosts.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -1339,41 +1601,67 @@ func (s *Server) handlePageMyPostsGETStream(w http.ResponseWriter, r *http.Reque
 			App: s.app,
 		},
 	}
-	s.handleStreamRequest(w, r, sessToken, sess, evSubjPageMyPosts(sess.UserID()),
+	s.handleStreamRequest(w, r, "PageMyPosts", sessToken, sess, evSubjPageMyPosts(sess.UserID()),
 		nil,
 		nil,
 		func(
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventMessagingSent app.EventMessagingSent
-			var eventMessagingRead app.EventMessagingRead
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch {
 				case strings.HasPrefix(msg.Subject, EvSubjPrefMessagingSent):
-					eventMessagingSent = app.EventMessagingSent{}
-					if err := json.Unmarshal(msg.Data, &eventMessagingSent); err != nil {
-						s.LogErr("unmarshaling EventMessagingSent JSON", err)
+					eventMessagingSent, err := eventcache.Decode[app.EventMessagingSent](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventMessagingSent", err)
 						continue
 					}
-					if err := p.OnMessagingSent(eventMessagingSent, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageMyPosts.OnMessagingSent", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageMyPosts.OnMessagingSent", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageMyPosts.OnMessagingSent", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnMessagingSent(eventMessagingSent, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageMyPosts", "OnMessagingSent", "EventMessagingSent")
+							s.LogErrCtx(ctx, "handling PageMyPosts.OnMessagingSent", err)
+						}
+						prom.EventHandlerDuration("PageMyPosts", "OnMessagingSent", "EventMessagingSent", start)
+					}()
 				case strings.HasPrefix(msg.Subject, EvSubjPrefMessagingRead):
-					eventMessagingRead = app.EventMessagingRead{}
-					if err := json.Unmarshal(msg.Data, &eventMessagingRead); err != nil {
-						s.LogErr("unmarshaling EventMessagingRead JSON", err)
+					eventMessagingRead, err := eventcache.Decode[app.EventMessagingRead](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventMessagingRead", err)
 						continue
 					}
-					if err := p.OnMessagingRead(eventMessagingRead, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageMyPosts.OnMessagingRead", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageMyPosts.OnMessagingRead", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageMyPosts.OnMessagingRead", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnMessagingRead(eventMessagingRead, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageMyPosts", "OnMessagingRead", "EventMessagingRead")
+							s.LogErrCtx(ctx, "handling PageMyPosts.OnMessagingRead", err)
+						}
+						prom.EventHandlerDuration("PageMyPosts", "OnMessagingRead", "EventMessagingRead", start)
+					}()
 				}
 			}
 		})
 }
 
 func (s *Server) handlePagePostGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PagePost.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PagePost.GET")
+	defer prom.HandlerDuration("PagePost", "GET", time.Now())
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -1420,12 +1708,13 @@ func (s *Server) handlePagePostGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, genericHead, head, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PagePost", err)
+		s.LogErrCtx(r.Context(), "rendering PagePost", err)
 		return
 	}
 }
 
 func (s *Server) handlePagePostGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PagePost.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -1454,51 +1743,83 @@ func (s *Server) handlePagePostGETStream(w http.ResponseWriter, r *http.Request)
 			App: s.app,
 		},
 	}
-	s.handleStreamRequest(w, r, sessToken, sess, evSubjPagePost(sess.UserID()),
+	s.handleStreamRequest(w, r, "PagePost", sessToken, sess, evSubjPagePost(sess.UserID()),
 		nil,
 		nil,
 		func(
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventPostArchived app.EventPostArchived
-			var eventMessagingSent app.EventMessagingSent
-			var eventMessagingRead app.EventMessagingRead
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch {
 				case msg.Subject == EvSubjPostArchived:
-					eventPostArchived = app.EventPostArchived{}
-					if err := json.Unmarshal(msg.Data, &eventPostArchived); err != nil {
-						s.LogErr("unmarshaling EventPostArchived JSON", err)
+					eventPostArchived, err := eventcache.Decode[app.EventPostArchived](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventPostArchived", err)
 						continue
 					}
-					if err := p.OnPostArchived(eventPostArchived, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PagePost.OnPostArchived", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PagePost.OnPostArchived", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PagePost.OnPostArchived", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnPostArchived(eventPostArchived, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PagePost", "OnPostArchived", "EventPostArchived")
+							s.LogErrCtx(ctx, "handling PagePost.OnPostArchived", err)
+						}
+						prom.EventHandlerDuration("PagePost", "OnPostArchived", "EventPostArchived", start)
+					}()
 				case strings.HasPrefix(msg.Subject, EvSubjPrefMessagingSent):
-					eventMessagingSent = app.EventMessagingSent{}
-					if err := json.Unmarshal(msg.Data, &eventMessagingSent); err != nil {
-						s.LogErr("unmarshaling EventMessagingSent JSON", err)
+					eventMessagingSent, err := eventcache.Decode[app.EventMessagingSent](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventMessagingSent", err)
 						continue
 					}
-					if err := p.OnMessagingSent(eventMessagingSent, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PagePost.OnMessagingSent", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PagePost.OnMessagingSent", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PagePost.OnMessagingSent", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnMessagingSent(eventMessagingSent, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PagePost", "OnMessagingSent", "EventMessagingSent")
+							s.LogErrCtx(ctx, "handling PagePost.OnMessagingSent", err)
+						}
+						prom.EventHandlerDuration("PagePost", "OnMessagingSent", "EventMessagingSent", start)
+					}()
 				case strings.HasPrefix(msg.Subject, EvSubjPrefMessagingRead):
-					eventMessagingRead = app.EventMessagingRead{}
-					if err := json.Unmarshal(msg.Data, &eventMessagingRead); err != nil {
-						s.LogErr("unmarshaling EventMessagingRead JSON", err)
+					eventMessagingRead, err := eventcache.Decode[app.EventMessagingRead](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventMessagingRead", err)
 						continue
 					}
-					if err := p.OnMessagingRead(eventMessagingRead, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PagePost.OnMessagingRead", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PagePost.OnMessagingRead", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PagePost.OnMessagingRead", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnMessagingRead(eventMessagingRead, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PagePost", "OnMessagingRead", "EventMessagingRead")
+							s.LogErrCtx(ctx, "handling PagePost.OnMessagingRead", err)
+						}
+						prom.EventHandlerDuration("PagePost", "OnMessagingRead", "EventMessagingRead", start)
+					}()
 				}
 			}
 		})
 }
 
 func (s *Server) handlePagePostGETStreamAnon(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PagePost.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -1518,25 +1839,36 @@ func (s *Server) handlePagePostGETStreamAnon(w http.ResponseWriter, r *http.Requ
 			App: s.app,
 		},
 	}
-	s.handleStreamRequest(w, r, sessToken, sess, evSubjPagePost(sess.UserID()),
+	s.handleStreamRequest(w, r, "PagePost", sessToken, sess, evSubjPagePost(sess.UserID()),
 		nil,
 		nil,
 		func(
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventPostArchived app.EventPostArchived
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch msg.Subject {
 				case EvSubjPostArchived:
-					eventPostArchived = app.EventPostArchived{}
-					if err := json.Unmarshal(msg.Data, &eventPostArchived); err != nil {
-						s.LogErr("unmarshaling EventPostArchived JSON", err)
+					eventPostArchived, err := eventcache.Decode[app.EventPostArchived](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventPostArchived", err)
 						continue
 					}
-					if err := p.OnPostArchived(eventPostArchived, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PagePost.OnPostArchived", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PagePost.OnPostArchived", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PagePost.OnPostArchived", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnPostArchived(eventPostArchived, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PagePost", "OnPostArchived", "EventPostArchived")
+							s.LogErrCtx(ctx, "handling PagePost.OnPostArchived", err)
+						}
+						prom.EventHandlerDuration("PagePost", "OnPostArchived", "EventPostArchived", start)
+					}()
 				}
 			}
 		})
@@ -1545,6 +1877,11 @@ func (s *Server) handlePagePostGETStreamAnon(w http.ResponseWriter, r *http.Requ
 func (s *Server) handlePagePostPOSTSendMessage(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PagePost.POSTSendMessage")
+	defer span.End()
+	reqlog.SetHandler(r, "PagePost.POSTSendMessage")
+	defer prom.HandlerDuration("PagePost", "POSTSendMessage", time.Now())
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -1582,6 +1919,11 @@ func (s *Server) handlePagePostPOSTSendMessage(
 }
 
 func (s *Server) handlePageSearchGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageSearch.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageSearch.GET")
+	defer prom.HandlerDuration("PageSearch", "GET", time.Now())
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -1669,12 +2011,13 @@ func (s *Server) handlePageSearchGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, genericHead, nil, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageSearch", err)
+		s.LogErrCtx(r.Context(), "rendering PageSearch", err)
 		return
 	}
 }
 
 func (s *Server) handlePageSearchGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageSearch.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -1697,35 +2040,56 @@ func (s *Server) handlePageSearchGETStream(w http.ResponseWriter, r *http.Reques
 			App: s.app,
 		},
 	}
-	s.handleStreamRequest(w, r, sessToken, sess, evSubjPageSearch(sess.UserID()),
+	s.handleStreamRequest(w, r, "PageSearch", sessToken, sess, evSubjPageSearch(sess.UserID()),
 		nil,
 		nil,
 		func(
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventMessagingSent app.EventMessagingSent
-			var eventMessagingRead app.EventMessagingRead
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch {
 				case strings.HasPrefix(msg.Subject, EvSubjPrefMessagingSent):
-					eventMessagingSent = app.EventMessagingSent{}
-					if err := json.Unmarshal(msg.Data, &eventMessagingSent); err != nil {
-						s.LogErr("unmarshaling EventMessagingSent JSON", err)
+					eventMessagingSent, err := eventcache.Decode[app.EventMessagingSent](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventMessagingSent", err)
 						continue
 					}
-					if err := p.OnMessagingSent(eventMessagingSent, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageSearch.OnMessagingSent", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageSearch.OnMessagingSent", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageSearch.OnMessagingSent", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnMessagingSent(eventMessagingSent, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageSearch", "OnMessagingSent", "EventMessagingSent")
+							s.LogErrCtx(ctx, "handling PageSearch.OnMessagingSent", err)
+						}
+						prom.EventHandlerDuration("PageSearch", "OnMessagingSent", "EventMessagingSent", start)
+					}()
 				case strings.HasPrefix(msg.Subject, EvSubjPrefMessagingRead):
-					eventMessagingRead = app.EventMessagingRead{}
-					if err := json.Unmarshal(msg.Data, &eventMessagingRead); err != nil {
-						s.LogErr("unmarshaling EventMessagingRead JSON", err)
+					eventMessagingRead, err := eventcache.Decode[app.EventMessagingRead](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventMessagingRead", err)
 						continue
 					}
-					if err := p.OnMessagingRead(eventMessagingRead, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageSearch.OnMessagingRead", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageSearch.OnMessagingRead", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageSearch.OnMessagingRead", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnMessagingRead(eventMessagingRead, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageSearch", "OnMessagingRead", "EventMessagingRead")
+							s.LogErrCtx(ctx, "handling PageSearch.OnMessagingRead", err)
+						}
+						prom.EventHandlerDuration("PageSearch", "OnMessagingRead", "EventMessagingRead", start)
+					}()
 				}
 			}
 		})
@@ -1734,6 +2098,11 @@ func (s *Server) handlePageSearchGETStream(w http.ResponseWriter, r *http.Reques
 func (s *Server) handlePageSearchPOSTParamChange(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageSearch.POSTParamChange")
+	defer span.End()
+	reqlog.SetHandler(r, "PageSearch.POSTParamChange")
+	defer prom.HandlerDuration("PageSearch", "POSTParamChange", time.Now())
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -1762,6 +2131,11 @@ func (s *Server) handlePageSearchPOSTParamChange(
 }
 
 func (s *Server) handlePageSettingsGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageSettings.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageSettings.GET")
+	defer prom.HandlerDuration("PageSettings", "GET", time.Now())
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -1797,12 +2171,13 @@ func (s *Server) handlePageSettingsGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, genericHead, nil, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageSettings", err)
+		s.LogErrCtx(r.Context(), "rendering PageSettings", err)
 		return
 	}
 }
 
 func (s *Server) handlePageSettingsGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageSettings.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -1825,45 +2200,76 @@ func (s *Server) handlePageSettingsGETStream(w http.ResponseWriter, r *http.Requ
 			App: s.app,
 		},
 	}
-	s.handleStreamRequest(w, r, sessToken, sess, evSubjPageSettings(sess.UserID()),
+	s.handleStreamRequest(w, r, "PageSettings", sessToken, sess, evSubjPageSettings(sess.UserID()),
 		nil,
 		nil,
 		func(
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventSessionClosed app.EventSessionClosed
-			var eventMessagingSent app.EventMessagingSent
-			var eventMessagingRead app.EventMessagingRead
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch {
 				case strings.HasPrefix(msg.Subject, EvSubjPrefSessionClosed):
-					eventSessionClosed = app.EventSessionClosed{}
-					if err := json.Unmarshal(msg.Data, &eventSessionClosed); err != nil {
-						s.LogErr("unmarshaling EventSessionClosed JSON", err)
+					eventSessionClosed, err := eventcache.Decode[app.EventSessionClosed](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventSessionClosed", err)
 						continue
 					}
-					if err := p.OnSessionClosed(eventSessionClosed, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageSettings.OnSessionClosed", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageSettings.OnSessionClosed", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageSettings.OnSessionClosed", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnSessionClosed(eventSessionClosed, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageSettings", "OnSessionClosed", "EventSessionClosed")
+							s.LogErrCtx(ctx, "handling PageSettings.OnSessionClosed", err)
+						}
+						prom.EventHandlerDuration("PageSettings", "OnSessionClosed", "EventSessionClosed", start)
+					}()
 				case strings.HasPrefix(msg.Subject, EvSubjPrefMessagingSent):
-					eventMessagingSent = app.EventMessagingSent{}
-					if err := json.Unmarshal(msg.Data, &eventMessagingSent); err != nil {
-						s.LogErr("unmarshaling EventMessagingSent JSON", err)
+					eventMessagingSent, err := eventcache.Decode[app.EventMessagingSent](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventMessagingSent", err)
 						continue
 					}
-					if err := p.OnMessagingSent(eventMessagingSent, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageSettings.OnMessagingSent", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageSettings.OnMessagingSent", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageSettings.OnMessagingSent", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnMessagingSent(eventMessagingSent, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageSettings", "OnMessagingSent", "EventMessagingSent")
+							s.LogErrCtx(ctx, "handling PageSettings.OnMessagingSent", err)
+						}
+						prom.EventHandlerDuration("PageSettings", "OnMessagingSent", "EventMessagingSent", start)
+					}()
 				case strings.HasPrefix(msg.Subject, EvSubjPrefMessagingRead):
-					eventMessagingRead = app.EventMessagingRead{}
-					if err := json.Unmarshal(msg.Data, &eventMessagingRead); err != nil {
-						s.LogErr("unmarshaling EventMessagingRead JSON", err)
+					eventMessagingRead, err := eventcache.Decode[app.EventMessagingRead](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventMessagingRead", err)
 						continue
 					}
-					if err := p.OnMessagingRead(eventMessagingRead, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageSettings.OnMessagingRead", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageSettings.OnMessagingRead", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageSettings.OnMessagingRead", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnMessagingRead(eventMessagingRead, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageSettings", "OnMessagingRead", "EventMessagingRead")
+							s.LogErrCtx(ctx, "handling PageSettings.OnMessagingRead", err)
+						}
+						prom.EventHandlerDuration("PageSettings", "OnMessagingRead", "EventMessagingRead", start)
+					}()
 				}
 			}
 		})
@@ -1872,6 +2278,11 @@ func (s *Server) handlePageSettingsGETStream(w http.ResponseWriter, r *http.Requ
 func (s *Server) handlePageSettingsPOSTSave(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageSettings.POSTSave")
+	defer span.End()
+	reqlog.SetHandler(r, "PageSettings.POSTSave")
+	defer prom.HandlerDuration("PageSettings", "POSTSave", time.Now())
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -1907,6 +2318,11 @@ func (s *Server) handlePageSettingsPOSTSave(
 func (s *Server) handlePageSettingsPOSTCloseSession(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageSettings.POSTCloseSession")
+	defer span.End()
+	reqlog.SetHandler(r, "PageSettings.POSTCloseSession")
+	defer prom.HandlerDuration("PageSettings", "POSTCloseSession", time.Now())
+
 	sess, sessToken, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -1943,6 +2359,11 @@ func (s *Server) handlePageSettingsPOSTCloseSession(
 func (s *Server) handlePageSettingsPOSTCloseAllSessions(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageSettings.POSTCloseAllSessions")
+	defer span.End()
+	reqlog.SetHandler(r, "PageSettings.POSTCloseAllSessions")
+	defer prom.HandlerDuration("PageSettings", "POSTCloseAllSessions", time.Now())
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -1966,6 +2387,11 @@ func (s *Server) handlePageSettingsPOSTCloseAllSessions(
 }
 
 func (s *Server) handlePageUserGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageUser.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageUser.GET")
+	defer prom.HandlerDuration("PageUser", "GET", time.Now())
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -2012,12 +2438,13 @@ func (s *Server) handlePageUserGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, genericHead, head, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageUser", err)
+		s.LogErrCtx(r.Context(), "rendering PageUser", err)
 		return
 	}
 }
 
 func (s *Server) handlePageUserGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageUser.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -2046,51 +2473,83 @@ func (s *Server) handlePageUserGETStream(w http.ResponseWriter, r *http.Request)
 			App: s.app,
 		},
 	}
-	s.handleStreamRequest(w, r, sessToken, sess, evSubjPageUser(sess.UserID()),
+	s.handleStreamRequest(w, r, "PageUser", sessToken, sess, evSubjPageUser(sess.UserID()),
 		nil,
 		nil,
 		func(
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventPostArchived app.EventPostArchived
-			var eventMessagingSent app.EventMessagingSent
-			var eventMessagingRead app.EventMessagingRead
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch {
 				case msg.Subject == EvSubjPostArchived:
-					eventPostArchived = app.EventPostArchived{}
-					if err := json.Unmarshal(msg.Data, &eventPostArchived); err != nil {
-						s.LogErr("unmarshaling EventPostArchived JSON", err)
+					eventPostArchived, err := eventcache.Decode[app.EventPostArchived](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventPostArchived", err)
 						continue
 					}
-					if err := p.OnPostArchived(eventPostArchived, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageUser.OnPostArchived", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageUser.OnPostArchived", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageUser.OnPostArchived", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnPostArchived(eventPostArchived, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageUser", "OnPostArchived", "EventPostArchived")
+							s.LogErrCtx(ctx, "handling PageUser.OnPostArchived", err)
+						}
+						prom.EventHandlerDuration("PageUser", "OnPostArchived", "EventPostArchived", start)
+					}()
 				case strings.HasPrefix(msg.Subject, EvSubjPrefMessagingSent):
-					eventMessagingSent = app.EventMessagingSent{}
-					if err := json.Unmarshal(msg.Data, &eventMessagingSent); err != nil {
-						s.LogErr("unmarshaling EventMessagingSent JSON", err)
+					eventMessagingSent, err := eventcache.Decode[app.EventMessagingSent](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventMessagingSent", err)
 						continue
 					}
-					if err := p.OnMessagingSent(eventMessagingSent, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageUser.OnMessagingSent", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageUser.OnMessagingSent", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageUser.OnMessagingSent", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnMessagingSent(eventMessagingSent, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageUser", "OnMessagingSent", "EventMessagingSent")
+							s.LogErrCtx(ctx, "handling PageUser.OnMessagingSent", err)
+						}
+						prom.EventHandlerDuration("PageUser", "OnMessagingSent", "EventMessagingSent", start)
+					}()
 				case strings.HasPrefix(msg.Subject, EvSubjPrefMessagingRead):
-					eventMessagingRead = app.EventMessagingRead{}
-					if err := json.Unmarshal(msg.Data, &eventMessagingRead); err != nil {
-						s.LogErr("unmarshaling EventMessagingRead JSON", err)
+					eventMessagingRead, err := eventcache.Decode[app.EventMessagingRead](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventMessagingRead", err)
 						continue
 					}
-					if err := p.OnMessagingRead(eventMessagingRead, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageUser.OnMessagingRead", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageUser.OnMessagingRead", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageUser.OnMessagingRead", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnMessagingRead(eventMessagingRead, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageUser", "OnMessagingRead", "EventMessagingRead")
+							s.LogErrCtx(ctx, "handling PageUser.OnMessagingRead", err)
+						}
+						prom.EventHandlerDuration("PageUser", "OnMessagingRead", "EventMessagingRead", start)
+					}()
 				}
 			}
 		})
 }
 
 func (s *Server) handlePageUserGETStreamAnon(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageUser.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -2110,25 +2569,36 @@ func (s *Server) handlePageUserGETStreamAnon(w http.ResponseWriter, r *http.Requ
 			App: s.app,
 		},
 	}
-	s.handleStreamRequest(w, r, sessToken, sess, evSubjPageUser(sess.UserID()),
+	s.handleStreamRequest(w, r, "PageUser", sessToken, sess, evSubjPageUser(sess.UserID()),
 		nil,
 		nil,
 		func(
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventPostArchived app.EventPostArchived
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch msg.Subject {
 				case EvSubjPostArchived:
-					eventPostArchived = app.EventPostArchived{}
-					if err := json.Unmarshal(msg.Data, &eventPostArchived); err != nil {
-						s.LogErr("unmarshaling EventPostArchived JSON", err)
+					eventPostArchived, err := eventcache.Decode[app.EventPostArchived](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventPostArchived", err)
 						continue
 					}
-					if err := p.OnPostArchived(eventPostArchived, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageUser.OnPostArchived", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageUser.OnPostArchived", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageUser.OnPostArchived", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnPostArchived(eventPostArchived, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageUser", "OnPostArchived", "EventPostArchived")
+							s.LogErrCtx(ctx, "handling PageUser.OnPostArchived", err)
+						}
+						prom.EventHandlerDuration("PageUser", "OnPostArchived", "EventPostArchived", start)
+					}()
 				}
 			}
 		})
@@ -2146,23 +2616,76 @@ func (d dispatcherEventMessagingRead) Dispatch(e app.EventMessagingRead) error {
 func (d dispatcherEventMessagingRead) DispatchCtx(
 	ctx context.Context, e app.EventMessagingRead,
 ) error {
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventMessagingRead) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventMessagingRead,
+) error {
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventMessagingRead) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventMessagingRead,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventMessagingRead: %w", messaging.ErrNoScheduler)
+	}
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventMessagingRead) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventMessagingRead,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventMessagingRead) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventMessagingRead,
+) error {
+	defer prom.DispatchDuration("EventMessagingRead", time.Now())
 	if !subject.IsToken(string(e.Recipient)) {
 		return fmt.Errorf(
 			"EventMessagingRead.Recipient must be a non-empty subject token, received %q",
 			e.Recipient)
 	}
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventMessagingRead JSON: %w", err)
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventMessagingReadJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventMessagingRead: %w", err)
 	}
 	subj := "messaging.read." + string(e.Recipient)
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, subj, j)
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at, d.s.subjectPrefix+subj, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", subj, err)
 	}
 	return nil
 }
 
+// appendEventMessagingReadJSON appends e encoded the way encoding/json encodes it.
+func appendEventMessagingReadJSON(b []byte, e app.EventMessagingRead) []byte {
+	start := len(b)
+	b = append(b, `,"Recipient":`...)
+	b = jsonenc.AppendString(b, string(e.Recipient))
+	b = append(b, `,"chat-id":`...)
+	b = jsonenc.AppendString(b, string(e.ChatID))
+	b = append(b, `,"user-id":`...)
+	b = jsonenc.AppendString(b, string(e.UserID))
+	b = append(b, `,"message-id":`...)
+	b = jsonenc.AppendString(b, string(e.MessageID))
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
+
 type dispatcherEventMessagingWriting struct {
 	s   *Server
 	ctx context.Context
@@ -2175,23 +2698,74 @@ func (d dispatcherEventMessagingWriting) Dispatch(e app.EventMessagingWriting) e
 func (d dispatcherEventMessagingWriting) DispatchCtx(
 	ctx context.Context, e app.EventMessagingWriting,
 ) error {
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventMessagingWriting) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventMessagingWriting,
+) error {
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventMessagingWriting) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventMessagingWriting,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventMessagingWriting: %w", messaging.ErrNoScheduler)
+	}
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventMessagingWriting) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventMessagingWriting,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventMessagingWriting) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventMessagingWriting,
+) error {
+	defer prom.DispatchDuration("EventMessagingWriting", time.Now())
 	if !subject.IsToken(string(e.Recipient)) {
 		return fmt.Errorf(
 			"EventMessagingWriting.Recipient must be a non-empty subject token, received %q",
 			e.Recipient)
 	}
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventMessagingWriting JSON: %w", err)
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventMessagingWritingJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventMessagingWriting: %w", err)
 	}
 	subj := "messaging.writing." + string(e.Recipient)
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, subj, j)
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at, d.s.subjectPrefix+subj, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", subj, err)
 	}
 	return nil
 }
 
+// appendEventMessagingWritingJSON appends e encoded the way encoding/json encodes it.
+func appendEventMessagingWritingJSON(b []byte, e app.EventMessagingWriting) []byte {
+	start := len(b)
+	b = append(b, `,"Recipient":`...)
+	b = jsonenc.AppendString(b, string(e.Recipient))
+	b = append(b, `,"chat-id":`...)
+	b = jsonenc.AppendString(b, string(e.ChatID))
+	b = append(b, `,"user-id":`...)
+	b = jsonenc.AppendString(b, string(e.UserID))
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
+
 type dispatcherEventMessagingWritingStopped struct {
 	s   *Server
 	ctx context.Context
@@ -2204,23 +2778,74 @@ func (d dispatcherEventMessagingWritingStopped) Dispatch(e app.EventMessagingWri
 func (d dispatcherEventMessagingWritingStopped) DispatchCtx(
 	ctx context.Context, e app.EventMessagingWritingStopped,
 ) error {
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventMessagingWritingStopped) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventMessagingWritingStopped,
+) error {
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventMessagingWritingStopped) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventMessagingWritingStopped,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventMessagingWritingStopped: %w", messaging.ErrNoScheduler)
+	}
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventMessagingWritingStopped) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventMessagingWritingStopped,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventMessagingWritingStopped) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventMessagingWritingStopped,
+) error {
+	defer prom.DispatchDuration("EventMessagingWritingStopped", time.Now())
 	if !subject.IsToken(string(e.Recipient)) {
 		return fmt.Errorf(
 			"EventMessagingWritingStopped.Recipient must be a non-empty subject token, received %q",
 			e.Recipient)
 	}
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventMessagingWritingStopped JSON: %w", err)
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventMessagingWritingStoppedJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventMessagingWritingStopped: %w", err)
 	}
 	subj := "messaging.writing-stopped." + string(e.Recipient)
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, subj, j)
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at, d.s.subjectPrefix+subj, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", subj, err)
 	}
 	return nil
 }
 
+// appendEventMessagingWritingStoppedJSON appends e encoded the way encoding/json encodes it.
+func appendEventMessagingWritingStoppedJSON(b []byte, e app.EventMessagingWritingStopped) []byte {
+	start := len(b)
+	b = append(b, `,"Recipient":`...)
+	b = jsonenc.AppendString(b, string(e.Recipient))
+	b = append(b, `,"chat-id":`...)
+	b = jsonenc.AppendString(b, string(e.ChatID))
+	b = append(b, `,"user-id":`...)
+	b = jsonenc.AppendString(b, string(e.UserID))
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
+
 type dispatcherEventMessagingSent struct {
 	s   *Server
 	ctx context.Context
@@ -2233,23 +2858,74 @@ func (d dispatcherEventMessagingSent) Dispatch(e app.EventMessagingSent) error {
 func (d dispatcherEventMessagingSent) DispatchCtx(
 	ctx context.Context, e app.EventMessagingSent,
 ) error {
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventMessagingSent) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventMessagingSent,
+) error {
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventMessagingSent) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventMessagingSent,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventMessagingSent: %w", messaging.ErrNoScheduler)
+	}
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventMessagingSent) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventMessagingSent,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventMessagingSent) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventMessagingSent,
+) error {
+	defer prom.DispatchDuration("EventMessagingSent", time.Now())
 	if !subject.IsToken(string(e.Recipient)) {
 		return fmt.Errorf(
 			"EventMessagingSent.Recipient must be a non-empty subject token, received %q",
 			e.Recipient)
 	}
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventMessagingSent JSON: %w", err)
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventMessagingSentJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventMessagingSent: %w", err)
 	}
 	subj := "messaging.sent." + string(e.Recipient)
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, subj, j)
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at, d.s.subjectPrefix+subj, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", subj, err)
 	}
 	return nil
 }
 
+// appendEventMessagingSentJSON appends e encoded the way encoding/json encodes it.
+func appendEventMessagingSentJSON(b []byte, e app.EventMessagingSent) []byte {
+	start := len(b)
+	b = append(b, `,"Recipient":`...)
+	b = jsonenc.AppendString(b, string(e.Recipient))
+	b = append(b, `,"chat-id":`...)
+	b = jsonenc.AppendString(b, string(e.ChatID))
+	b = append(b, `,"user-id":`...)
+	b = jsonenc.AppendString(b, string(e.UserID))
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
+
 type dispatcherEventSessionClosed struct {
 	s   *Server
 	ctx context.Context
@@ -2262,19 +2938,68 @@ func (d dispatcherEventSessionClosed) Dispatch(e app.EventSessionClosed) error {
 func (d dispatcherEventSessionClosed) DispatchCtx(
 	ctx context.Context, e app.EventSessionClosed,
 ) error {
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventSessionClosed) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventSessionClosed,
+) error {
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventSessionClosed) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventSessionClosed,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventSessionClosed: %w", messaging.ErrNoScheduler)
+	}
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventSessionClosed) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventSessionClosed,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventSessionClosed) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventSessionClosed,
+) error {
+	defer prom.DispatchDuration("EventSessionClosed", time.Now())
 	if !subject.IsToken(string(e.Recipient)) {
 		return fmt.Errorf(
 			"EventSessionClosed.Recipient must be a non-empty subject token, received %q",
 			e.Recipient)
 	}
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventSessionClosed JSON: %w", err)
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventSessionClosedJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventSessionClosed: %w", err)
 	}
 	subj := "sessions.closed." + string(e.Recipient)
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, subj, j)
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at, d.s.subjectPrefix+subj, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", subj, err)
 	}
 	return nil
 }
+
+// appendEventSessionClosedJSON appends e encoded the way encoding/json encodes it.
+func appendEventSessionClosedJSON(b []byte, e app.EventSessionClosed) []byte {
+	start := len(b)
+	b = append(b, `,"Recipient":`...)
+	b = jsonenc.AppendString(b, string(e.Recipient))
+	b = append(b, `,"token":`...)
+	b = jsonenc.AppendString(b, string(e.Token))
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
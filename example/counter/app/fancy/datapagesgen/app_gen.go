@@ -4,21 +4,29 @@ package datapagesgen
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/romshark/datapages"
+	"github.com/romshark/datapages/modules/codec"
 	"github.com/romshark/datapages/modules/messaging"
 	"github.com/romshark/datapages/modules/sessions"
+	"github.com/romshark/datapages/runtime/deferred"
+	"github.com/romshark/datapages/runtime/dispatch"
+	"github.com/romshark/datapages/runtime/eventcache"
 	"github.com/romshark/datapages/runtime/httpread"
 	"github.com/romshark/datapages/runtime/httpserve"
+	"github.com/romshark/datapages/runtime/reqlog"
 	dpsse "github.com/romshark/datapages/runtime/sse"
+	"github.com/romshark/datapages/runtime/subject"
+	"github.com/romshark/datapages/runtime/tracing"
 
 	"github.com/romshark/datapages/example/counter/app/fancy"
 	"github.com/romshark/datapages/example/counter/app/fancy/datapagesgen/href"
@@ -89,13 +97,18 @@ func (s *Server) writeHTML(
 	if err != nil {
 		return err
 	}
+	// The deferred components of the body follow it on the same response,
+	// the ones of the head are resolved as it's rendered.
+	ctx, deferreds := deferred.Start(r.Context(), s.DeferredTimeout(),
+		func(w io.Writer, id string, err error) { s.recoverDeferred(w, r, id, err) })
+	headCtx := deferred.InPlace(ctx)
 	if headGeneric != nil {
-		if err := headGeneric.Render(r.Context(), w); err != nil {
+		if err := headGeneric.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
 	if head != nil {
-		if err := head.Render(r.Context(), w); err != nil {
+		if err := head.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
@@ -109,7 +122,7 @@ func (s *Server) writeHTML(
 		return err
 	}
 	if body != nil {
-		if err := body.Render(r.Context(), w); err != nil {
+		if err := body.Render(ctx, w); err != nil {
 			return err
 		}
 	}
@@ -122,6 +135,9 @@ func (s *Server) writeHTML(
 			return err
 		}
 	}
+	if deferreds.Len() > 0 {
+		deferreds.Resolve(r.Context(), w)
+	}
 	_, err = io.WriteString(w, "</body></html>")
 	return err
 }
@@ -143,21 +159,41 @@ func (s *Server) handleStreamRequest(
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
+	// A server shutting down opens no streams, the client reconnects
+	// to another instance.
+	if s.Draining() {
+		http.Error(w,
+			http.StatusText(http.StatusServiceUnavailable),
+			http.StatusServiceUnavailable)
+		return
+	}
 
 	streamID := datapages.StreamID(s.streamSeq.Add(1))
+	reqlog.SetStream(r.Context(), uint64(streamID))
+
+	// A stream would wait for events that can't arrive while the broker is
+	// down. It's refused, and closed once the broker goes down later, so the
+	// client reconnects and renders what it missed when the broker is back.
+	brokerUp, brokerChanged := messaging.StatusOf(s.messageBroker)
+	if !brokerUp {
+		s.httpErrIntern(w, r, nil, "opening stream", messaging.ErrBrokerUnavailable)
+		return
+	}
 
 	// The subscription is established before the response head goes out.
 	// A client learns the stream is open by reading that head and may dispatch
 	// immediately after, which must not reach the broker before this.
 	ctx := r.Context()
-	sub, err := s.messageBroker.Subscribe(ctx, s.messageBrokerMetrics, subjects...)
+	sub, err := s.messageBroker.Subscribe(ctx, s.messageBrokerMetrics,
+		subject.InNamespace(s.subjectPrefix, subjects)...)
 	if err != nil {
 		// Nothing has been written yet, so the error can still carry a status.
 		s.httpErrIntern(w, r, nil, "subscribing to message broker", err)
 		return
 	}
 
-	sse := datastar.NewSSE(w, r, datastar.WithCompression())
+	// The heartbeats and the retry hint go out beside the events of sse.
+	sse, sseW := dpsse.NewSSE(w, r)
 
 	subC := sub.C()
 	if onOpen != nil {
@@ -168,9 +204,28 @@ func (s *Server) handleStreamRequest(
 		}
 	}
 	go func() {
-		select {
-		case <-r.Context().Done():
-		case <-s.ShutdownCh():
+		heartbeat, expired, stopTimers := s.StreamTimers()
+		defer stopTimers()
+		shutdown := s.ShutdownCh()
+		var drained <-chan time.Time
+		// A heartbeat goes out on the stream and the wait goes on, so does
+		// the drain the shutdown begins. Any other case ends the stream.
+		for {
+			select {
+			case <-heartbeat:
+				_ = dpsse.Heartbeat(sseW)
+				continue
+			case <-shutdown:
+				shutdown, drained = nil, s.DrainTimer()
+				continue
+			case <-drained:
+				_ = dpsse.Expire(sseW, s.DrainRetry())
+			case <-expired:
+				_ = dpsse.Expire(sseW, s.StreamRetry())
+			case <-r.Context().Done():
+			case <-brokerChanged:
+			}
+			break
 		}
 		sub.Close()
 		if onClose != nil {
@@ -186,7 +241,20 @@ type Server struct {
 	messageBroker        messaging.Broker
 	messageBrokerMetrics brokerMetrics
 	streamSeq            atomic.Uint64
-	app                  *fancy.App
+	// subjectPrefix is what every subject starts with on the wire,
+	// empty without datapages.WithSubjectPrefix.
+	subjectPrefix string
+	// tracer is nil without datapages.WithTracerProvider.
+	tracer     tracing.Tracer
+	app        *fancy.App
+	eventCodec codec.Codec
+	eventCache *eventcache.Cache
+	// jsonEvents is true when eventCodec is codec.JSON, which the generated
+	// encoders produce the payload of without reflection.
+	jsonEvents bool
+	// brokerInterceptors are those of datapages.WithBrokerInterceptors,
+	// which datapages.DispatchTo installs on the publisher it's given.
+	brokerInterceptors []messaging.Interceptor
 }
 
 // Init wires the server. It is called by datapages.NewServer,
@@ -201,6 +269,10 @@ type Server struct {
 //   - datapages.WithHTTPServer
 //   - datapages.WithDatastarJS
 //   - datapages.WithAssets
+//   - datapages.WithSubjectPrefix
+//   - datapages.WithBrokerInterceptors
+//   - datapages.WithTracerProvider
+//   - datapages.WithEventCodec
 func (s *Server) Init(
 	cfg datapages.ServerConfig,
 	app *fancy.App,
@@ -216,6 +288,10 @@ func (s *Server) Init(
 		return errors.New("unexpected option WithPrometheus: " +
 			"the server is generated with datapages.DisablePrometheus")
 	}
+	if cfg.MeterProvider != nil {
+		return errors.New("unexpected option WithMeterProvider: " +
+			"the server is generated with datapages.DisablePrometheus")
+	}
 
 	assetsFS, err := assetsFileSystem(cfg)
 	if err != nil {
@@ -226,14 +302,27 @@ func (s *Server) Init(
 	s.Core = httpserve.NewCore(cfg, "")
 	s.app = app
 	s.messageBroker = messageBroker
+	s.tracer = tracing.NewTracer(cfg.TracerProvider)
+	if cfg.SubjectPrefix != "" {
+		s.subjectPrefix = subject.Prefix(cfg.SubjectPrefix)
+	}
 	s.messageBrokerMetrics = brokerMetrics{}
+	s.eventCodec = cfg.EventCodec
+	s.eventCache = eventcache.New(eventcache.DefaultSize)
+	_, s.jsonEvents = cfg.EventCodec.(codec.JSON)
+	s.brokerInterceptors = cfg.BrokerInterceptors
 
 	if si, ok := s.messageBroker.(messaging.StreamInitializer); ok {
-		if err := si.InitStreams(MessageBrokerStreamSubjects()); err != nil {
+		subjects := subject.InNamespace(s.subjectPrefix, MessageBrokerStreamSubjects())
+		if err := si.InitStreams(subjects); err != nil {
 			return fmt.Errorf("initializing message broker streams: %w", err)
 		}
 	}
 
+	s.AddReadinessCheck("broker", func(ctx context.Context) error {
+		return messaging.Ping(ctx, s.messageBroker)
+	})
+
 	setupHandlers(s)
 
 	s.Build()
@@ -276,10 +365,11 @@ func setupHandlers(s *Server) {
 }
 
 func (s *Server) httpErrIntern(
-	w http.ResponseWriter, _ *http.Request,
+	w http.ResponseWriter, r *http.Request,
 	_ *datastar.ServerSentEventGenerator, msg string, err error,
 ) {
-	s.LogErr(msg, err)
+	s.LogErrCtx(r.Context(), msg, err)
+	tracing.FailRequest(r, err)
 	switch {
 	case errors.Is(err, datapages.ErrBadRequest):
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
@@ -294,7 +384,18 @@ func (s *Server) httpErrIntern(
 	}
 }
 
+func (s *Server) recoverDeferred(
+	w io.Writer, r *http.Request, id string, err error,
+) {
+	err = datapages.DeferredError{Selector: "#" + id, Err: err}
+	s.LogErrCtx(r.Context(), "resolving deferred component", err)
+}
+
 func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.GET")
+
 	if r.URL.Path != "/" {
 		http.NotFound(w, r)
 		return
@@ -322,12 +423,13 @@ func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, genericHead, nil, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageIndex", err)
+		s.LogErrCtx(r.Context(), "rendering PageIndex", err)
 		return
 	}
 }
 
 func (s *Server) handlePageIndexGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageIndex.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -342,18 +444,26 @@ func (s *Server) handlePageIndexGETStream(w http.ResponseWriter, r *http.Request
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventCounterUpdated fancy.EventCounterUpdated
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch msg.Subject {
 				case EvSubjCounterUpdated:
-					eventCounterUpdated = fancy.EventCounterUpdated{}
-					if err := json.Unmarshal(msg.Data, &eventCounterUpdated); err != nil {
-						s.LogErr("unmarshaling EventCounterUpdated JSON", err)
+					eventCounterUpdated, err := eventcache.Decode[fancy.EventCounterUpdated](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventCounterUpdated", err)
 						continue
 					}
-					if err := p.OnCounterUpdated(eventCounterUpdated, dpsse.New(sse)); err != nil {
-						s.LogErr("handling PageIndex.OnCounterUpdated", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageIndex.OnCounterUpdated", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageIndex.OnCounterUpdated", msg)
+						defer span.End()
+						if err := p.OnCounterUpdated(eventCounterUpdated, dpsse.NewContext(ctx, sse)); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageIndex.OnCounterUpdated", err)
+						}
+					}()
 				}
 			}
 		})
@@ -362,6 +472,9 @@ func (s *Server) handlePageIndexGETStream(w http.ResponseWriter, r *http.Request
 func (s *Server) handlePageIndexPOSTAdd(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.POSTAdd")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.POSTAdd")
 
 	var query datapages.Query[struct {
 		Delta int32 `query:"delta"`
@@ -391,6 +504,10 @@ func (s *Server) handlePageIndexPOSTAdd(
 func (s *Server) handlePageIndexPOSTSet(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.POSTSet")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.POSTSet")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -439,13 +556,58 @@ func (d dispatcherEventCounterUpdated) Dispatch(e fancy.EventCounterUpdated) err
 func (d dispatcherEventCounterUpdated) DispatchCtx(
 	ctx context.Context, e fancy.EventCounterUpdated,
 ) error {
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventCounterUpdated JSON: %w", err)
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventCounterUpdated) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e fancy.EventCounterUpdated,
+) error {
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventCounterUpdated) DispatchAt(
+	ctx context.Context, t time.Time, e fancy.EventCounterUpdated,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventCounterUpdated: %w", messaging.ErrNoScheduler)
 	}
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, EvSubjCounterUpdated, j)
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventCounterUpdated) DispatchAfter(
+	ctx context.Context, delay time.Duration, e fancy.EventCounterUpdated,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventCounterUpdated) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e fancy.EventCounterUpdated,
+) error {
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventCounterUpdatedJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventCounterUpdated: %w", err)
+	}
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at,
+		d.s.subjectPrefix+EvSubjCounterUpdated, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", EvSubjCounterUpdated, err)
 	}
 	return nil
 }
+
+// appendEventCounterUpdatedJSON appends e encoded the way encoding/json encodes it.
+func appendEventCounterUpdatedJSON(b []byte, e fancy.EventCounterUpdated) []byte {
+	start := len(b)
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
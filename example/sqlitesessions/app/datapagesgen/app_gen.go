@@ -4,7 +4,6 @@ package datapagesgen
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -12,14 +11,22 @@ import (
 	"net/http"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/romshark/datapages"
+	"github.com/romshark/datapages/modules/codec"
 	"github.com/romshark/datapages/modules/messaging"
 	"github.com/romshark/datapages/modules/sessions"
 	"github.com/romshark/datapages/runtime/auth"
+	"github.com/romshark/datapages/runtime/deferred"
+	"github.com/romshark/datapages/runtime/dispatch"
+	"github.com/romshark/datapages/runtime/eventcache"
 	"github.com/romshark/datapages/runtime/httpserve"
+	"github.com/romshark/datapages/runtime/jsonenc"
+	"github.com/romshark/datapages/runtime/reqlog"
 	dpsse "github.com/romshark/datapages/runtime/sse"
 	"github.com/romshark/datapages/runtime/subject"
+	"github.com/romshark/datapages/runtime/tracing"
 
 	"github.com/romshark/datapages/example/sqlitesessions/app"
 	"github.com/romshark/datapages/example/sqlitesessions/app/datapagesgen/href"
@@ -103,13 +110,18 @@ func (s *Server) writeHTML(
 	if err != nil {
 		return err
 	}
+	// The deferred components of the body follow it on the same response,
+	// the ones of the head are resolved as it's rendered.
+	ctx, deferreds := deferred.Start(r.Context(), s.DeferredTimeout(),
+		func(w io.Writer, id string, err error) { s.recoverDeferred(w, r, id, err) })
+	headCtx := deferred.InPlace(ctx)
 	if headGeneric != nil {
-		if err := headGeneric.Render(r.Context(), w); err != nil {
+		if err := headGeneric.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
 	if head != nil {
-		if err := head.Render(r.Context(), w); err != nil {
+		if err := head.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
@@ -153,7 +165,7 @@ func (s *Server) writeHTML(
 		return err
 	}
 	if body != nil {
-		if err := body.Render(r.Context(), w); err != nil {
+		if err := body.Render(ctx, w); err != nil {
 			return err
 		}
 	}
@@ -166,6 +178,9 @@ func (s *Server) writeHTML(
 			return err
 		}
 	}
+	if deferreds.Len() > 0 {
+		deferreds.Resolve(r.Context(), w)
+	}
 	_, err = io.WriteString(w, "</body></html>")
 	return err
 }
@@ -187,21 +202,41 @@ func (s *Server) handleStreamRequest(
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
+	// A server shutting down opens no streams, the client reconnects
+	// to another instance.
+	if s.Draining() {
+		http.Error(w,
+			http.StatusText(http.StatusServiceUnavailable),
+			http.StatusServiceUnavailable)
+		return
+	}
 
 	streamID := datapages.StreamID(s.streamSeq.Add(1))
+	reqlog.SetStream(r.Context(), uint64(streamID))
+
+	// A stream would wait for events that can't arrive while the broker is
+	// down. It's refused, and closed once the broker goes down later, so the
+	// client reconnects and renders what it missed when the broker is back.
+	brokerUp, brokerChanged := messaging.StatusOf(s.messageBroker)
+	if !brokerUp {
+		s.httpErrIntern(w, r, nil, "opening stream", messaging.ErrBrokerUnavailable)
+		return
+	}
 
 	// The subscription is established before the response head goes out.
 	// A client learns the stream is open by reading that head and may dispatch
 	// immediately after, which must not reach the broker before this.
 	ctx := r.Context()
-	sub, err := s.messageBroker.Subscribe(ctx, s.messageBrokerMetrics, subjects...)
+	sub, err := s.messageBroker.Subscribe(ctx, s.messageBrokerMetrics,
+		subject.InNamespace(s.subjectPrefix, subjects)...)
 	if err != nil {
 		// Nothing has been written yet, so the error can still carry a status.
 		s.httpErrIntern(w, r, nil, "subscribing to message broker", err)
 		return
 	}
 
-	sse := datastar.NewSSE(w, r, datastar.WithCompression())
+	// The heartbeats and the retry hint go out beside the events of sse.
+	sse, sseW := dpsse.NewSSE(w, r)
 
 	subC := sub.C()
 	if onOpen != nil {
@@ -225,10 +260,29 @@ func (s *Server) handleStreamRequest(
 	}
 
 	go func() {
-		select {
-		case <-sessionClosed:
-		case <-r.Context().Done():
-		case <-s.ShutdownCh():
+		heartbeat, expired, stopTimers := s.StreamTimers()
+		defer stopTimers()
+		shutdown := s.ShutdownCh()
+		var drained <-chan time.Time
+		// A heartbeat goes out on the stream and the wait goes on, so does
+		// the drain the shutdown begins. Any other case ends the stream.
+		for {
+			select {
+			case <-heartbeat:
+				_ = dpsse.Heartbeat(sseW)
+				continue
+			case <-shutdown:
+				shutdown, drained = nil, s.DrainTimer()
+				continue
+			case <-drained:
+				_ = dpsse.Expire(sseW, s.DrainRetry())
+			case <-expired:
+				_ = dpsse.Expire(sseW, s.StreamRetry())
+			case <-sessionClosed:
+			case <-r.Context().Done():
+			case <-brokerChanged:
+			}
+			break
 		}
 		sub.Close()
 		if onClose != nil {
@@ -244,7 +298,20 @@ type Server struct {
 	messageBroker        messaging.Broker
 	messageBrokerMetrics brokerMetrics
 	streamSeq            atomic.Uint64
-	app                  *app.App
+	// subjectPrefix is what every subject starts with on the wire,
+	// empty without datapages.WithSubjectPrefix.
+	subjectPrefix string
+	// tracer is nil without datapages.WithTracerProvider.
+	tracer     tracing.Tracer
+	app        *app.App
+	eventCodec codec.Codec
+	eventCache *eventcache.Cache
+	// jsonEvents is true when eventCodec is codec.JSON, which the generated
+	// encoders produce the payload of without reflection.
+	jsonEvents bool
+	// brokerInterceptors are those of datapages.WithBrokerInterceptors,
+	// which datapages.DispatchTo installs on the publisher it's given.
+	brokerInterceptors []messaging.Interceptor
 	*auth.Manager[app.SessionData]
 }
 
@@ -260,6 +327,10 @@ type Server struct {
 //   - datapages.WithHTTPServer
 //   - datapages.WithDatastarJS
 //   - datapages.WithAssets
+//   - datapages.WithSubjectPrefix
+//   - datapages.WithBrokerInterceptors
+//   - datapages.WithTracerProvider
+//   - datapages.WithEventCodec
 //   - datapages.WithSessionManager (required)
 //   - datapages.WithSessions
 //   - datapages.WithCSRFProtection
@@ -275,6 +346,10 @@ func (s *Server) Init(
 		return errors.New("unexpected option WithPrometheus: " +
 			"the server is generated with datapages.DisablePrometheus")
 	}
+	if cfg.MeterProvider != nil {
+		return errors.New("unexpected option WithMeterProvider: " +
+			"the server is generated with datapages.DisablePrometheus")
+	}
 	if sessionManager == nil {
 		return errors.New("missing option WithSessionManager")
 	}
@@ -288,15 +363,31 @@ func (s *Server) Init(
 	s.Core = httpserve.NewCore(cfg, "")
 	s.app = app
 	s.messageBroker = messageBroker
+	s.tracer = tracing.NewTracer(cfg.TracerProvider)
+	if cfg.SubjectPrefix != "" {
+		s.subjectPrefix = subject.Prefix(cfg.SubjectPrefix)
+	}
 	s.messageBrokerMetrics = brokerMetrics{}
+	s.eventCodec = cfg.EventCodec
+	s.eventCache = eventcache.New(eventcache.DefaultSize)
+	_, s.jsonEvents = cfg.EventCodec.(codec.JSON)
+	s.brokerInterceptors = cfg.BrokerInterceptors
 
 	if si, ok := s.messageBroker.(messaging.StreamInitializer); ok {
-		if err := si.InitStreams(MessageBrokerStreamSubjects()); err != nil {
+		subjects := subject.InNamespace(s.subjectPrefix, MessageBrokerStreamSubjects())
+		if err := si.InitStreams(subjects); err != nil {
 			return fmt.Errorf("initializing message broker streams: %w", err)
 		}
 	}
 	s.Manager = auth.NewManager(s.Core, sessionManager, cfg, nil)
 
+	s.AddReadinessCheck("broker", func(ctx context.Context) error {
+		return messaging.Ping(ctx, s.messageBroker)
+	})
+	if p, ok := sessionManager.(sessions.Pinger); ok {
+		s.AddReadinessCheck("sessions", p.Ping)
+	}
+
 	setupHandlers(s)
 
 	s.Build()
@@ -360,10 +451,11 @@ func setupHandlers(s *Server) {
 }
 
 func (s *Server) httpErrIntern(
-	w http.ResponseWriter, _ *http.Request,
+	w http.ResponseWriter, r *http.Request,
 	_ *datastar.ServerSentEventGenerator, msg string, err error,
 ) {
-	s.LogErr(msg, err)
+	s.LogErrCtx(r.Context(), msg, err)
+	tracing.FailRequest(r, err)
 	switch {
 	case errors.Is(err, datapages.ErrBadRequest):
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
@@ -378,7 +470,18 @@ func (s *Server) httpErrIntern(
 	}
 }
 
+func (s *Server) recoverDeferred(
+	w io.Writer, r *http.Request, id string, err error,
+) {
+	err = datapages.DeferredError{Selector: "#" + id, Err: err}
+	s.LogErrCtx(r.Context(), "resolving deferred component", err)
+}
+
 func (s *Server) handlePOSTSignOut(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "App.POSTSignOut")
+	defer span.End()
+	reqlog.SetHandler(r, "App.POSTSignOut")
+
 	sess, sessToken, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -402,6 +505,10 @@ func (s *Server) handlePOSTSignOut(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.GET")
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -436,12 +543,13 @@ func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, genericHead, head, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageIndex", err)
+		s.LogErrCtx(r.Context(), "rendering PageIndex", err)
 		return
 	}
 }
 
 func (s *Server) handlePageIndexGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageIndex.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -468,24 +576,36 @@ func (s *Server) handlePageIndexGETStream(w http.ResponseWriter, r *http.Request
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventSessionClosed app.EventSessionClosed
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch {
 				case strings.HasPrefix(msg.Subject, EvSubjPrefSessionClosed):
-					eventSessionClosed = app.EventSessionClosed{}
-					if err := json.Unmarshal(msg.Data, &eventSessionClosed); err != nil {
-						s.LogErr("unmarshaling EventSessionClosed JSON", err)
+					eventSessionClosed, err := eventcache.Decode[app.EventSessionClosed](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventSessionClosed", err)
 						continue
 					}
-					if err := p.OnSessionClosed(eventSessionClosed, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageIndex.OnSessionClosed", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageIndex.OnSessionClosed", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageIndex.OnSessionClosed", msg)
+						defer span.End()
+						if err := p.OnSessionClosed(eventSessionClosed, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageIndex.OnSessionClosed", err)
+						}
+					}()
 				}
 			}
 		})
 }
 
 func (s *Server) handlePageLoginGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageLogin.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageLogin.GET")
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -511,7 +631,7 @@ func (s *Server) handlePageLoginGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, genericHead, head, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageLogin", err)
+		s.LogErrCtx(r.Context(), "rendering PageLogin", err)
 		return
 	}
 }
@@ -519,6 +639,10 @@ func (s *Server) handlePageLoginGET(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handlePageLoginPOSTValidate(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageLogin.POSTValidate")
+	defer span.End()
+	reqlog.SetHandler(r, "PageLogin.POSTValidate")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -547,7 +671,7 @@ func (s *Server) handlePageLoginPOSTValidate(
 	if err := s.writeHTML(
 		w, r, sess, genericHead, nil, body, nil, nil,
 	); err != nil {
-		s.LogErr("rendering response of PageLogin.POSTValidate", err)
+		s.LogErrCtx(r.Context(), "rendering response of PageLogin.POSTValidate", err)
 		return
 	}
 }
@@ -555,6 +679,10 @@ func (s *Server) handlePageLoginPOSTValidate(
 func (s *Server) handlePageLoginPOSTSubmit(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageLogin.POSTSubmit")
+	defer span.End()
+	reqlog.SetHandler(r, "PageLogin.POSTSubmit")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -592,12 +720,16 @@ func (s *Server) handlePageLoginPOSTSubmit(
 	if err := s.writeHTML(
 		w, r, sess, genericHead, nil, body, nil, nil,
 	); err != nil {
-		s.LogErr("rendering response of PageLogin.POSTSubmit", err)
+		s.LogErrCtx(r.Context(), "rendering response of PageLogin.POSTSubmit", err)
 		return
 	}
 }
 
 func (s *Server) handlePageRegisterGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageRegister.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageRegister.GET")
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -623,7 +755,7 @@ func (s *Server) handlePageRegisterGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, genericHead, head, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageRegister", err)
+		s.LogErrCtx(r.Context(), "rendering PageRegister", err)
 		return
 	}
 }
@@ -631,6 +763,10 @@ func (s *Server) handlePageRegisterGET(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handlePageRegisterPOSTValidate(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageRegister.POSTValidate")
+	defer span.End()
+	reqlog.SetHandler(r, "PageRegister.POSTValidate")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -660,7 +796,7 @@ func (s *Server) handlePageRegisterPOSTValidate(
 	if err := s.writeHTML(
 		w, r, sess, genericHead, nil, body, nil, nil,
 	); err != nil {
-		s.LogErr("rendering response of PageRegister.POSTValidate", err)
+		s.LogErrCtx(r.Context(), "rendering response of PageRegister.POSTValidate", err)
 		return
 	}
 }
@@ -668,6 +804,10 @@ func (s *Server) handlePageRegisterPOSTValidate(
 func (s *Server) handlePageRegisterPOSTSubmit(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageRegister.POSTSubmit")
+	defer span.End()
+	reqlog.SetHandler(r, "PageRegister.POSTSubmit")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -706,7 +846,7 @@ func (s *Server) handlePageRegisterPOSTSubmit(
 	if err := s.writeHTML(
 		w, r, sess, genericHead, nil, body, nil, nil,
 	); err != nil {
-		s.LogErr("rendering response of PageRegister.POSTSubmit", err)
+		s.LogErrCtx(r.Context(), "rendering response of PageRegister.POSTSubmit", err)
 		return
 	}
 }
@@ -722,20 +862,68 @@ func (d dispatcherEventSessionClosed) Dispatch(e app.EventSessionClosed) error {
 
 func (d dispatcherEventSessionClosed) DispatchCtx(
 	ctx context.Context, e app.EventSessionClosed,
+) error {
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventSessionClosed) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventSessionClosed,
+) error {
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventSessionClosed) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventSessionClosed,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventSessionClosed: %w", messaging.ErrNoScheduler)
+	}
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventSessionClosed) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventSessionClosed,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventSessionClosed) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventSessionClosed,
 ) error {
 	if !subject.IsToken(string(e.Recipient)) {
 		return fmt.Errorf(
 			"EventSessionClosed.Recipient must be a non-empty subject token, received %q",
 			e.Recipient)
 	}
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventSessionClosed JSON: %w", err)
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventSessionClosedJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventSessionClosed: %w", err)
 	}
 	subj := "sessions.closed." + string(e.Recipient)
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, subj, j)
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at, d.s.subjectPrefix+subj, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", subj, err)
 	}
 	return nil
 }
+
+// appendEventSessionClosedJSON appends e encoded the way encoding/json encodes it.
+func appendEventSessionClosedJSON(b []byte, e app.EventSessionClosed) []byte {
+	start := len(b)
+	b = append(b, `,"Recipient":`...)
+	b = jsonenc.AppendString(b, string(e.Recipient))
+	b = append(b, `,"token":`...)
+	b = jsonenc.AppendString(b, string(e.Token))
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
@@ -4,7 +4,6 @@ package datapagesgen
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -12,19 +11,30 @@ import (
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/romshark/datapages"
+	"github.com/romshark/datapages/modules/codec"
 	"github.com/romshark/datapages/modules/messaging"
 	"github.com/romshark/datapages/modules/sessions"
+	"github.com/romshark/datapages/runtime/deferred"
+	"github.com/romshark/datapages/runtime/dispatch"
+	"github.com/romshark/datapages/runtime/eventcache"
 	"github.com/romshark/datapages/runtime/htmlattr"
 	"github.com/romshark/datapages/runtime/httpread"
 	"github.com/romshark/datapages/runtime/httpserve"
+	"github.com/romshark/datapages/runtime/reqlog"
 	dpsse "github.com/romshark/datapages/runtime/sse"
+	"github.com/romshark/datapages/runtime/subject"
+	"github.com/romshark/datapages/runtime/tabs"
+	"github.com/romshark/datapages/runtime/tracing"
 
 	"github.com/romshark/datapages/example/todolist/app"
 	"github.com/romshark/datapages/example/todolist/app/datapagesgen/assets"
 	"github.com/romshark/datapages/example/todolist/app/datapagesgen/href"
+	"github.com/romshark/datapages/example/todolist/list"
 
 	"github.com/starfederation/datastar-go/datastar"
 )
@@ -86,6 +96,25 @@ func (s *Server) checkIsDSReq(w http.ResponseWriter, r *http.Request) (ok bool)
 	return true
 }
 
+// readTab returns the tab whose instance ID the stream or an action of
+// a stateful page carries. Without a valid one it answers a bad request.
+func (s *Server) readTab(w http.ResponseWriter, r *http.Request) (tabs.Tab, bool) {
+	tabID, err := tabs.FromRequest(r)
+	if err == nil && tabID == "" {
+		err = errors.New("missing " + tabs.Header + " header")
+	}
+	if err != nil {
+		s.httpErrBad(w, "reading tab instance", err)
+		return tabs.Tab{}, false
+	}
+	return s.tab(w, r, tabID)
+}
+
+// tab returns the tab with the instance ID tabID.
+func (s *Server) tab(_ http.ResponseWriter, _ *http.Request, tabID string) (tabs.Tab, bool) {
+	return s.Tab(tabID, ""), true
+}
+
 func (s *Server) writeHTML(
 	w http.ResponseWriter,
 	r *http.Request,
@@ -98,13 +127,37 @@ func (s *Server) writeHTML(
 	if err != nil {
 		return err
 	}
+	// The deferred components of the body follow it on the same response,
+	// the ones of the head are resolved as it's rendered.
+	ctx, deferreds := deferred.Start(r.Context(), s.DeferredTimeout(),
+		func(w io.Writer, id string, err error) { s.recoverDeferred(w, r, id, err) })
+	headCtx := deferred.InPlace(ctx)
 	if headGeneric != nil {
-		if err := headGeneric.Render(r.Context(), w); err != nil {
+		if err := headGeneric.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
 	if head != nil {
-		if err := head.Render(r.Context(), w); err != nil {
+		if err := head.Render(headCtx, w); err != nil {
+			return err
+		}
+	}
+	if tabID := w.Header().Get(tabs.Header); tabID != "" {
+		// Write the fetch Datapages-Instance header injector.
+		if _, err := io.WriteString(w, `
+	<script>{
+		const o = globalThis.fetch.bind(globalThis)
+		globalThis.fetch=(i,init={}) => {
+			const isReq=i instanceof Request
+			const r=isReq ? i:new Request(i,init)
+			if (r.headers.get("Datastar-Request")!=="true" ||
+				new URL(r.url).origin!==location.origin
+			) return isReq ? o(r,init):o(r)
+			const h=new Headers(r.headers)
+			h.set("`+tabs.Header+`","`+tabID+`")
+			return o(new Request(r,{...init,headers:h}))
+		}
+	}</script>`); err != nil {
 			return err
 		}
 	}
@@ -118,7 +171,7 @@ func (s *Server) writeHTML(
 		return err
 	}
 	if body != nil {
-		if err := body.Render(r.Context(), w); err != nil {
+		if err := body.Render(ctx, w); err != nil {
 			return err
 		}
 	}
@@ -131,6 +184,9 @@ func (s *Server) writeHTML(
 			return err
 		}
 	}
+	if deferreds.Len() > 0 {
+		deferreds.Resolve(r.Context(), w)
+	}
 	_, err = io.WriteString(w, "</body></html>")
 	return err
 }
@@ -152,21 +208,41 @@ func (s *Server) handleStreamRequest(
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
+	// A server shutting down opens no streams, the client reconnects
+	// to another instance.
+	if s.Draining() {
+		http.Error(w,
+			http.StatusText(http.StatusServiceUnavailable),
+			http.StatusServiceUnavailable)
+		return
+	}
 
 	streamID := datapages.StreamID(s.streamSeq.Add(1))
+	reqlog.SetStream(r.Context(), uint64(streamID))
+
+	// A stream would wait for events that can't arrive while the broker is
+	// down. It's refused, and closed once the broker goes down later, so the
+	// client reconnects and renders what it missed when the broker is back.
+	brokerUp, brokerChanged := messaging.StatusOf(s.messageBroker)
+	if !brokerUp {
+		s.httpErrIntern(w, r, nil, "opening stream", messaging.ErrBrokerUnavailable)
+		return
+	}
 
 	// The subscription is established before the response head goes out.
 	// A client learns the stream is open by reading that head and may dispatch
 	// immediately after, which must not reach the broker before this.
 	ctx := r.Context()
-	sub, err := s.messageBroker.Subscribe(ctx, s.messageBrokerMetrics, subjects...)
+	sub, err := s.messageBroker.Subscribe(ctx, s.messageBrokerMetrics,
+		subject.InNamespace(s.subjectPrefix, subjects)...)
 	if err != nil {
 		// Nothing has been written yet, so the error can still carry a status.
 		s.httpErrIntern(w, r, nil, "subscribing to message broker", err)
 		return
 	}
 
-	sse := datastar.NewSSE(w, r, datastar.WithCompression())
+	// The heartbeats and the retry hint go out beside the events of sse.
+	sse, sseW := dpsse.NewSSE(w, r)
 
 	subC := sub.C()
 	if onOpen != nil {
@@ -177,9 +253,28 @@ func (s *Server) handleStreamRequest(
 		}
 	}
 	go func() {
-		select {
-		case <-r.Context().Done():
-		case <-s.ShutdownCh():
+		heartbeat, expired, stopTimers := s.StreamTimers()
+		defer stopTimers()
+		shutdown := s.ShutdownCh()
+		var drained <-chan time.Time
+		// A heartbeat goes out on the stream and the wait goes on, so does
+		// the drain the shutdown begins. Any other case ends the stream.
+		for {
+			select {
+			case <-heartbeat:
+				_ = dpsse.Heartbeat(sseW)
+				continue
+			case <-shutdown:
+				shutdown, drained = nil, s.DrainTimer()
+				continue
+			case <-drained:
+				_ = dpsse.Expire(sseW, s.DrainRetry())
+			case <-expired:
+				_ = dpsse.Expire(sseW, s.StreamRetry())
+			case <-r.Context().Done():
+			case <-brokerChanged:
+			}
+			break
 		}
 		sub.Close()
 		if onClose != nil {
@@ -195,7 +290,20 @@ type Server struct {
 	messageBroker        messaging.Broker
 	messageBrokerMetrics brokerMetrics
 	streamSeq            atomic.Uint64
-	app                  *app.App
+	// subjectPrefix is what every subject starts with on the wire,
+	// empty without datapages.WithSubjectPrefix.
+	subjectPrefix string
+	// tracer is nil without datapages.WithTracerProvider.
+	tracer     tracing.Tracer
+	app        *app.App
+	eventCodec codec.Codec
+	eventCache *eventcache.Cache
+	// jsonEvents is true when eventCodec is codec.JSON, which the generated
+	// encoders produce the payload of without reflection.
+	jsonEvents bool
+	// brokerInterceptors are those of datapages.WithBrokerInterceptors,
+	// which datapages.DispatchTo installs on the publisher it's given.
+	brokerInterceptors []messaging.Interceptor
 }
 
 // Init wires the server. It is called by datapages.NewServer,
@@ -210,6 +318,10 @@ type Server struct {
 //   - datapages.WithHTTPServer
 //   - datapages.WithDatastarJS
 //   - datapages.WithAssets
+//   - datapages.WithSubjectPrefix
+//   - datapages.WithBrokerInterceptors
+//   - datapages.WithTracerProvider
+//   - datapages.WithEventCodec
 func (s *Server) Init(
 	cfg datapages.ServerConfig,
 	app *app.App,
@@ -225,6 +337,10 @@ func (s *Server) Init(
 		return errors.New("unexpected option WithPrometheus: " +
 			"the server is generated with datapages.DisablePrometheus")
 	}
+	if cfg.MeterProvider != nil {
+		return errors.New("unexpected option WithMeterProvider: " +
+			"the server is generated with datapages.DisablePrometheus")
+	}
 
 	assetsFS, err := assetsFileSystem(cfg)
 	if err != nil {
@@ -235,14 +351,27 @@ func (s *Server) Init(
 	s.Core = httpserve.NewCore(cfg, assets.URLPrefix)
 	s.app = app
 	s.messageBroker = messageBroker
+	s.tracer = tracing.NewTracer(cfg.TracerProvider)
+	if cfg.SubjectPrefix != "" {
+		s.subjectPrefix = subject.Prefix(cfg.SubjectPrefix)
+	}
 	s.messageBrokerMetrics = brokerMetrics{}
+	s.eventCodec = cfg.EventCodec
+	s.eventCache = eventcache.New(eventcache.DefaultSize)
+	_, s.jsonEvents = cfg.EventCodec.(codec.JSON)
+	s.brokerInterceptors = cfg.BrokerInterceptors
 
 	if si, ok := s.messageBroker.(messaging.StreamInitializer); ok {
-		if err := si.InitStreams(MessageBrokerStreamSubjects()); err != nil {
+		subjects := subject.InNamespace(s.subjectPrefix, MessageBrokerStreamSubjects())
+		if err := si.InitStreams(subjects); err != nil {
 			return fmt.Errorf("initializing message broker streams: %w", err)
 		}
 	}
 
+	s.AddReadinessCheck("broker", func(ctx context.Context) error {
+		return messaging.Ping(ctx, s.messageBroker)
+	})
+
 	setupHandlers(s)
 	if assetsFS != nil {
 		h := http.StripPrefix(assets.URLPrefix, http.FileServer(assetsFS))
@@ -311,10 +440,11 @@ func setupHandlers(s *Server) {
 }
 
 func (s *Server) httpErrIntern(
-	w http.ResponseWriter, _ *http.Request,
+	w http.ResponseWriter, r *http.Request,
 	_ *datastar.ServerSentEventGenerator, msg string, err error,
 ) {
-	s.LogErr(msg, err)
+	s.LogErrCtx(r.Context(), msg, err)
+	tracing.FailRequest(r, err)
 	switch {
 	case errors.Is(err, datapages.ErrBadRequest):
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
@@ -329,6 +459,13 @@ func (s *Server) httpErrIntern(
 	}
 }
 
+func (s *Server) recoverDeferred(
+	w io.Writer, r *http.Request, id string, err error,
+) {
+	err = datapages.DeferredError{Selector: "#" + id, Err: err}
+	s.LogErrCtx(r.Context(), "resolving deferred component", err)
+}
+
 func (s *Server) render404(w http.ResponseWriter, r *http.Request) {
 	// The URL is claimed by no page. Whatever the app renders for it,
 	// the response says so: a cache that stores it and a crawler that
@@ -350,19 +487,22 @@ func (s *Server) render404(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, genericHead, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageError404", err)
+		s.LogErrCtx(r.Context(), "rendering PageError404", err)
 		return
 	}
 }
 
 func (s *Server) handlePUTEdit(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "App.PUTEdit")
+	defer span.End()
+	reqlog.SetHandler(r, "App.PUTEdit")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
 
 	r.Body = http.MaxBytesReader(w, r.Body, DefaultBodySizeLimit)
 	var signals datapages.Signals[struct {
-		TabID       string `json:"tab_id"`
 		Title       string `json:"title"`
 		Description string `json:"description"`
 		Done        bool   `json:"done"`
@@ -401,6 +541,10 @@ func (s *Server) handlePUTEdit(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handlePageError404GET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageError404.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageError404.GET")
+
 	p := app.PageError404{
 		App: s.app,
 	}
@@ -417,12 +561,16 @@ func (s *Server) handlePageError404GET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, genericHead, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageError404", err)
+		s.LogErrCtx(r.Context(), "rendering PageError404", err)
 		return
 	}
 }
 
 func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.GET")
+
 	if r.URL.Path != "/" {
 		s.render404(w, r)
 		return
@@ -430,17 +578,28 @@ func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
 
 	var query datapages.Query[struct {
 		Search string `query:"q" reflectsignal:"search"`
-		Filter string `query:"filter" reflectsignal:"filter"`
-		Sort   string `query:"sort" reflectsignal:"sort"`
+		Filter string `query:"filter" reflectsignal:"filter,push"`
+		Sort   string `query:"sort" reflectsignal:"sort,push"`
 	}]
 	query.Values.Search = httpread.QueryValue(r.URL.RawQuery, "q")
 	query.Values.Filter = httpread.QueryValue(r.URL.RawQuery, "filter")
 	query.Values.Sort = httpread.QueryValue(r.URL.RawQuery, "sort")
 
+	tabID, ok := tabs.RerenderID(r.Context())
+	if !ok {
+		tabID = tabs.NewID()
+	}
+	w.Header().Set(tabs.Header, tabID)
+	tabRef, ok := s.tab(w, r, tabID)
+	if !ok {
+		return
+	}
+	tab := datapages.MakeTabState[list.ViewParameters](r.Context(), tabRef)
+
 	p := app.PageIndex{
 		App: s.app,
 	}
-	body, err := p.GET(r, query)
+	body, err := p.GET(r, query, tab)
 	if err != nil {
 		s.httpErrIntern(w, r, nil, "handling PageIndex.GET", err)
 		return
@@ -472,62 +631,78 @@ func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
 			if ($filter) params.set('filter', $filter);
 			if ($sort) params.set('sort', $sort);
 			const query = params.toString();
-			window.history.replaceState(null, '', query ? '/?' + query : '/');
+			const url = query ? '/?' + query : '/';
+			const pushed = JSON.stringify([$filter, $sort]);
+			if (el.dpPushed === undefined || el.dpPushed === pushed) window.history.replaceState(null, '', url);
+			else window.history.pushState(null, '', url);
+			el.dpPushed = pushed;
 		"`)
+
+		_, _ = io.WriteString(w, ` data-on:popstate__window="window.location.reload()"`)
 	}
 
 	if err := s.writeHTML(
 		w, r, genericHead, nil, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageIndex", err)
+		s.LogErrCtx(r.Context(), "rendering PageIndex", err)
 		return
 	}
 }
 
 func (s *Server) handlePageIndexGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageIndex.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
 
-	var signals datapages.Signals[struct {
-		Search string `json:"search"`
-		Filter string `json:"filter"`
-		Sort   string `json:"sort"`
-	}]
-	if err := datastar.ReadSignals(r, &signals.Values); err != nil {
-		s.httpErrBad(w, "reading signals", err)
+	tabRef, ok := s.readTab(w, r)
+	if !ok {
 		return
 	}
 
 	p := app.PageIndex{
 		App: s.app,
 	}
+
+	tabOwner, err := tabRef.Keep(r.Context())
+	if err != nil {
+		s.httpErrIntern(w, r, nil, "keeping tab state", err)
+		return
+	}
+	defer func() {
+		ctx := context.WithoutCancel(r.Context())
+		if err := tabRef.Release(ctx, tabOwner); err != nil {
+			s.LogErrCtx(ctx, "releasing tab state", err)
+		}
+	}()
 	s.handleStreamRequest(w, r, evSubjPageIndex,
-		func(
-			streamID datapages.StreamID,
-			sse *datastar.ServerSentEventGenerator,
-		) error {
-			return p.StreamOpen(r, streamID, dpsse.New(sse), signals)
-		},
-		func(streamID datapages.StreamID) {
-			p.StreamClose(r, streamID)
-		},
+		nil,
+		nil,
 		func(
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventTodoUpdated app.EventTodoUpdated
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch msg.Subject {
 				case EvSubjTodoUpdated:
-					eventTodoUpdated = app.EventTodoUpdated{}
-					if err := json.Unmarshal(msg.Data, &eventTodoUpdated); err != nil {
-						s.LogErr("unmarshaling EventTodoUpdated JSON", err)
+					eventTodoUpdated, err := eventcache.Decode[app.EventTodoUpdated](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventTodoUpdated", err)
 						continue
 					}
-					if err := p.OnTodoUpdated(eventTodoUpdated, dpsse.New(sse), streamID); err != nil {
-						s.LogErr("handling PageIndex.OnTodoUpdated", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageIndex.OnTodoUpdated", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageIndex.OnTodoUpdated", msg)
+						defer span.End()
+						tab := datapages.MakeTabState[list.ViewParameters](ctx, tabRef)
+						if err := p.OnTodoUpdated(eventTodoUpdated, dpsse.NewContext(ctx, sse), tab); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageIndex.OnTodoUpdated", err)
+						}
+					}()
 				}
 			}
 		})
@@ -536,12 +711,15 @@ func (s *Server) handlePageIndexGETStream(w http.ResponseWriter, r *http.Request
 func (s *Server) handlePageIndexPOSTCreate(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.POSTCreate")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.POSTCreate")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
 	r.Body = http.MaxBytesReader(w, r.Body, DefaultBodySizeLimit)
 	var signals datapages.Signals[struct {
-		TabID    string `json:"tab_id"`
 		NewTitle string `json:"newTitle"`
 		NewDesc  string `json:"newDesc"`
 		NewDue   string `json:"newDue"`
@@ -565,11 +743,19 @@ func (s *Server) handlePageIndexPOSTCreate(
 func (s *Server) handlePageIndexPOSTFilter(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.POSTFilter")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.POSTFilter")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
+	tabRef, ok := s.readTab(w, r)
+	if !ok {
+		return
+	}
+	tab := datapages.MakeTabState[list.ViewParameters](r.Context(), tabRef)
 	var signals datapages.Signals[struct {
-		TabID  string `json:"tab_id"`
 		Search string `json:"search"`
 		Filter string `json:"filter"`
 		Sort   string `json:"sort"`
@@ -583,7 +769,7 @@ func (s *Server) handlePageIndexPOSTFilter(
 	p := app.PageIndex{
 		App: s.app,
 	}
-	err := p.POSTFilter(r, dpsse.New(sse), signals)
+	err := p.POSTFilter(r, dpsse.New(sse), signals, tab)
 	if err != nil {
 		s.httpErrIntern(w, r, sse, "handling action PageIndex.Filter", err)
 		return
@@ -591,16 +777,30 @@ func (s *Server) handlePageIndexPOSTFilter(
 }
 
 func (s *Server) handlePageItemGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageItem.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageItem.GET")
 
 	var path datapages.Path[struct {
 		ID string `path:"id"`
 	}]
 	path.Values.ID = r.PathValue("id")
 
+	tabID, ok := tabs.RerenderID(r.Context())
+	if !ok {
+		tabID = tabs.NewID()
+	}
+	w.Header().Set(tabs.Header, tabID)
+	tabRef, ok := s.tab(w, r, tabID)
+	if !ok {
+		return
+	}
+	tab := datapages.MakeTabState[string](r.Context(), tabRef)
+
 	p := app.PageItem{
 		App: s.app,
 	}
-	body, redirect, err := p.GET(r, path)
+	body, redirect, err := p.GET(r, path, tab)
 	if err != nil {
 		s.httpErrIntern(w, r, nil, "handling PageItem.GET", err)
 		return
@@ -626,53 +826,65 @@ func (s *Server) handlePageItemGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, genericHead, nil, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageItem", err)
+		s.LogErrCtx(r.Context(), "rendering PageItem", err)
 		return
 	}
 }
 
 func (s *Server) handlePageItemGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageItem.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
 
-	var signals datapages.Signals[struct {
-		ItemID string `json:"itemId"`
-	}]
-	if err := datastar.ReadSignals(r, &signals.Values); err != nil {
-		s.httpErrBad(w, "reading signals", err)
+	tabRef, ok := s.readTab(w, r)
+	if !ok {
 		return
 	}
 
 	p := app.PageItem{
 		App: s.app,
 	}
+
+	tabOwner, err := tabRef.Keep(r.Context())
+	if err != nil {
+		s.httpErrIntern(w, r, nil, "keeping tab state", err)
+		return
+	}
+	defer func() {
+		ctx := context.WithoutCancel(r.Context())
+		if err := tabRef.Release(ctx, tabOwner); err != nil {
+			s.LogErrCtx(ctx, "releasing tab state", err)
+		}
+	}()
 	s.handleStreamRequest(w, r, evSubjPageItem,
-		func(
-			streamID datapages.StreamID,
-			sse *datastar.ServerSentEventGenerator,
-		) error {
-			return p.StreamOpen(r, streamID, dpsse.New(sse), signals)
-		},
-		func(streamID datapages.StreamID) {
-			p.StreamClose(r, streamID)
-		},
+		nil,
+		nil,
 		func(
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventTodoUpdated app.EventTodoUpdated
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch msg.Subject {
 				case EvSubjTodoUpdated:
-					eventTodoUpdated = app.EventTodoUpdated{}
-					if err := json.Unmarshal(msg.Data, &eventTodoUpdated); err != nil {
-						s.LogErr("unmarshaling EventTodoUpdated JSON", err)
+					eventTodoUpdated, err := eventcache.Decode[app.EventTodoUpdated](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventTodoUpdated", err)
 						continue
 					}
-					if err := p.OnTodoUpdated(eventTodoUpdated, dpsse.New(sse), streamID); err != nil {
-						s.LogErr("handling PageItem.OnTodoUpdated", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageItem.OnTodoUpdated", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageItem.OnTodoUpdated", msg)
+						defer span.End()
+						tab := datapages.MakeTabState[string](ctx, tabRef)
+						if err := p.OnTodoUpdated(eventTodoUpdated, dpsse.NewContext(ctx, sse), tab); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageItem.OnTodoUpdated", err)
+						}
+					}()
 				}
 			}
 		})
@@ -681,17 +893,9 @@ func (s *Server) handlePageItemGETStream(w http.ResponseWriter, r *http.Request)
 func (s *Server) handlePageItemDELETEItem(
 	w http.ResponseWriter, r *http.Request,
 ) {
-	if !s.checkIsDSReq(w, r) {
-		return
-	}
-	r.Body = http.MaxBytesReader(w, r.Body, DefaultBodySizeLimit)
-	var signals datapages.Signals[struct {
-		TabID string `json:"tab_id"`
-	}]
-	if err := datastar.ReadSignals(r, &signals.Values); err != nil {
-		s.httpErrBad(w, "reading signals", err)
-		return
-	}
+	r, span := tracing.StartRequest(s.tracer, r, "PageItem.DELETEItem")
+	defer span.End()
+	reqlog.SetHandler(r, "PageItem.DELETEItem")
 
 	var path datapages.Path[struct {
 		ID string `path:"id"`
@@ -702,7 +906,7 @@ func (s *Server) handlePageItemDELETEItem(
 	p := app.PageItem{
 		App: s.app,
 	}
-	redirect, err := p.DELETEItem(r, path, signals, dispatchTodoUpdated)
+	redirect, err := p.DELETEItem(r, path, dispatchTodoUpdated)
 	if err != nil {
 		s.httpErrIntern(w, r, nil, "handling action PageItem.Item", err)
 		return
@@ -724,13 +928,58 @@ func (d dispatcherEventTodoUpdated) Dispatch(e app.EventTodoUpdated) error {
 func (d dispatcherEventTodoUpdated) DispatchCtx(
 	ctx context.Context, e app.EventTodoUpdated,
 ) error {
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventTodoUpdated JSON: %w", err)
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventTodoUpdated) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventTodoUpdated,
+) error {
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventTodoUpdated) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventTodoUpdated,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventTodoUpdated: %w", messaging.ErrNoScheduler)
 	}
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, EvSubjTodoUpdated, j)
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventTodoUpdated) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventTodoUpdated,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventTodoUpdated) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventTodoUpdated,
+) error {
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventTodoUpdatedJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventTodoUpdated: %w", err)
+	}
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at,
+		d.s.subjectPrefix+EvSubjTodoUpdated, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", EvSubjTodoUpdated, err)
 	}
 	return nil
 }
+
+// appendEventTodoUpdatedJSON appends e encoded the way encoding/json encodes it.
+func appendEventTodoUpdatedJSON(b []byte, e app.EventTodoUpdated) []byte {
+	start := len(b)
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
@@ -17,9 +17,10 @@ func (p PageIndex) GET(
 	r *http.Request,
 	query datapages.Query[struct {
 		Search string `query:"q" reflectsignal:"search"`
-		Filter string `query:"filter" reflectsignal:"filter"`
-		Sort   string `query:"sort" reflectsignal:"sort"`
+		Filter string `query:"filter" reflectsignal:"filter,push"`
+		Sort   string `query:"sort" reflectsignal:"sort,push"`
 	}],
+	tab datapages.TabState[list.ViewParameters],
 ) (body datapages.Component, err error) {
 	filter := query.Values.Filter
 	if filter == "" {
@@ -34,58 +35,23 @@ func (p PageIndex) GET(
 		Filter: filter,
 		Sort:   sortMode,
 	}
+	if err := tab.Set(vp); err != nil {
+		return nil, err
+	}
 	todos := p.App.list.GetItems(vp)
 	return pageIndex(todos, query.Values.Search, filter, sortMode), nil
 }
 
-func (p PageIndex) StreamOpen(
-	r *http.Request,
-	streamID datapages.StreamID,
-	sse datapages.SSE,
-	signals datapages.Signals[struct {
-		Search string `json:"search"`
-		Filter string `json:"filter"`
-		Sort   string `json:"sort"`
-	}],
-) error {
-	filter := signals.Values.Filter
-	if filter == "" {
-		filter = "all"
-	}
-	sortMode := signals.Values.Sort
-	if sortMode == "" {
-		sortMode = "created"
-	}
-	p.App.lockTabs.Lock()
-	p.App.streamIDToTabState[streamID] = &tabState{
-		Search: signals.Values.Search,
-		Filter: filter,
-		Sort:   sortMode,
-	}
-	p.App.lockTabs.Unlock()
-	return p.App.patchTabID(streamID, sse)
-}
-
-func (p PageIndex) StreamClose(r *http.Request, streamID datapages.StreamID) {
-	p.App.lockTabs.Lock()
-	delete(p.App.streamIDToTabState, streamID)
-	p.App.lockTabs.Unlock()
-}
-
 // POSTCreate is /
 func (p PageIndex) POSTCreate(
 	r *http.Request,
 	signals datapages.Signals[struct {
-		TabID    string `json:"tab_id"`
 		NewTitle string `json:"newTitle"`
 		NewDesc  string `json:"newDesc"`
 		NewDue   string `json:"newDue"`
 	}],
 	todoUpdated datapages.Dispatcher[EventTodoUpdated],
 ) error {
-	if _, err := p.App.verifyTabID(signals.Values.TabID); err != nil {
-		return fmt.Errorf("%w: %w", datapages.ErrBadRequest, err)
-	}
 	title := strings.TrimSpace(signals.Values.NewTitle)
 	if title == "" {
 		return fmt.Errorf("%w: title is required", datapages.ErrBadRequest)
@@ -107,31 +73,21 @@ func (p PageIndex) POSTFilter(
 	r *http.Request,
 	sse datapages.SSE,
 	signals datapages.Signals[struct {
-		TabID  string `json:"tab_id"`
 		Search string `json:"search"`
 		Filter string `json:"filter"`
 		Sort   string `json:"sort"`
 	}],
+	tab datapages.TabState[list.ViewParameters],
 ) error {
-	streamID, err := p.App.verifyTabID(signals.Values.TabID)
-	if err != nil {
-		return fmt.Errorf("%w: %w", datapages.ErrBadRequest, err)
-	}
-
-	// Update server-side tab state so event handlers use current filters.
-	p.App.lockTabs.Lock()
-	if ts := p.App.streamIDToTabState[streamID]; ts != nil {
-		ts.Search = signals.Values.Search
-		ts.Filter = signals.Values.Filter
-		ts.Sort = signals.Values.Sort
-	}
-	p.App.lockTabs.Unlock()
-
 	vp := list.ViewParameters{
 		Search: signals.Values.Search,
 		Filter: signals.Values.Filter,
 		Sort:   signals.Values.Sort,
 	}
+	// Remember the filters so event handlers render this tab's view.
+	if err := tab.Set(vp); err != nil {
+		return err
+	}
 	todos := p.App.list.GetItems(vp)
 	return sse.PatchElement(todoList(todos))
 }
@@ -139,9 +95,12 @@ func (p PageIndex) POSTFilter(
 func (p PageIndex) OnTodoUpdated(
 	event EventTodoUpdated,
 	sse datapages.SSE,
-	streamID datapages.StreamID,
+	tab datapages.TabState[list.ViewParameters],
 ) error {
-	s := p.App.streamState(streamID)
-	todos := p.App.list.GetItems(s.ViewParameters)
+	vp, err := tab.Get()
+	if err != nil {
+		return err
+	}
+	todos := p.App.list.GetItems(vp)
 	return sse.PatchElement(todoList(todos))
 }
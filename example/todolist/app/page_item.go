@@ -15,34 +15,17 @@ func (p PageItem) GET(
 	path datapages.Path[struct {
 		ID string `path:"id"`
 	}],
+	tab datapages.TabState[string],
 ) (body datapages.Component, redirect datapages.Redirect, err error) {
 	todo, ok := p.App.list.GetItem(path.Values.ID)
 	if !ok {
 		return nil, datapages.Redirect{URL: "/"}, nil
 	}
-	return pageItem(todo), redirect, nil
-}
-
-func (p PageItem) StreamOpen(
-	r *http.Request,
-	streamID datapages.StreamID,
-	sse datapages.SSE,
-	signals datapages.Signals[struct {
-		ItemID string `json:"itemId"`
-	}],
-) error {
-	p.App.lockTabs.Lock()
-	p.App.streamIDToTabState[streamID] = &tabState{
-		ItemID: signals.Values.ItemID,
+	// Remember the item so event handlers know what this tab shows.
+	if err := tab.Set(todo.ID); err != nil {
+		return nil, redirect, err
 	}
-	p.App.lockTabs.Unlock()
-	return p.App.patchTabID(streamID, sse)
-}
-
-func (p PageItem) StreamClose(r *http.Request, streamID datapages.StreamID) {
-	p.App.lockTabs.Lock()
-	delete(p.App.streamIDToTabState, streamID)
-	p.App.lockTabs.Unlock()
+	return pageItem(todo), redirect, nil
 }
 
 // DELETEItem is /item/{id}/
@@ -51,14 +34,8 @@ func (p PageItem) DELETEItem(
 	path datapages.Path[struct {
 		ID string `path:"id"`
 	}],
-	signals datapages.Signals[struct {
-		TabID string `json:"tab_id"`
-	}],
 	todoUpdated datapages.Dispatcher[EventTodoUpdated],
 ) (redirect datapages.Redirect, err error) {
-	if _, err := p.App.verifyTabID(signals.Values.TabID); err != nil {
-		return redirect, fmt.Errorf("%w: %w", datapages.ErrBadRequest, err)
-	}
 	if !p.App.list.DeleteItem(path.Values.ID) {
 		return redirect, fmt.Errorf("%w: todo not found", datapages.ErrNotFound)
 	}
@@ -71,13 +48,13 @@ func (p PageItem) DELETEItem(
 func (p PageItem) OnTodoUpdated(
 	event EventTodoUpdated,
 	sse datapages.SSE,
-	streamID datapages.StreamID,
+	tab datapages.TabState[string],
 ) error {
-	ts := p.App.streamState(streamID)
-	if ts == nil || ts.ItemID == "" {
-		return nil
+	itemID, err := tab.Get()
+	if err != nil || itemID == "" {
+		return err
 	}
-	todo, ok := p.App.list.GetItem(ts.ItemID)
+	todo, ok := p.App.list.GetItem(itemID)
 	if !ok {
 		return sse.Redirect("/")
 	}
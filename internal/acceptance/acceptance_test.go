@@ -149,6 +149,7 @@ func generateInto(t *testing.T, name, dst string) serverscan.App {
 		filepath.Join(dst, app.GenDir), serverscan.GenSubdir,
 		m, 0o644, generator.Options{
 			Prometheus:      app.Prometheus,
+			OTelMetrics:     app.OTelMetrics,
 			AssetsURLPrefix: m.Assets.URLPrefix,
 			AssetsDir:       m.Assets.Dir,
 			AppDir:          app.Dir,
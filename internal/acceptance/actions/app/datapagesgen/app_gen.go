@@ -3,6 +3,7 @@
 package datapagesgen
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -14,9 +15,13 @@ import (
 	"github.com/romshark/datapages"
 	"github.com/romshark/datapages/modules/messaging"
 	"github.com/romshark/datapages/modules/sessions"
+	"github.com/romshark/datapages/runtime/deferred"
 	"github.com/romshark/datapages/runtime/httpread"
 	"github.com/romshark/datapages/runtime/httpserve"
+	"github.com/romshark/datapages/runtime/reqlog"
 	dpsse "github.com/romshark/datapages/runtime/sse"
+	"github.com/romshark/datapages/runtime/subject"
+	"github.com/romshark/datapages/runtime/tracing"
 
 	"github.com/romshark/datapages/internal/acceptance/actions/app"
 	"github.com/romshark/datapages/internal/acceptance/actions/app/datapagesgen/href"
@@ -87,13 +92,18 @@ func (s *Server) writeHTML(
 	if err != nil {
 		return err
 	}
+	// The deferred components of the body follow it on the same response,
+	// the ones of the head are resolved as it's rendered.
+	ctx, deferreds := deferred.Start(r.Context(), s.DeferredTimeout(),
+		func(w io.Writer, id string, err error) { s.recoverDeferred(w, r, id, err) })
+	headCtx := deferred.InPlace(ctx)
 	if headGeneric != nil {
-		if err := headGeneric.Render(r.Context(), w); err != nil {
+		if err := headGeneric.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
 	if head != nil {
-		if err := head.Render(r.Context(), w); err != nil {
+		if err := head.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
@@ -107,7 +117,7 @@ func (s *Server) writeHTML(
 		return err
 	}
 	if body != nil {
-		if err := body.Render(r.Context(), w); err != nil {
+		if err := body.Render(ctx, w); err != nil {
 			return err
 		}
 	}
@@ -120,6 +130,9 @@ func (s *Server) writeHTML(
 			return err
 		}
 	}
+	if deferreds.Len() > 0 {
+		deferreds.Resolve(r.Context(), w)
+	}
 	_, err = io.WriteString(w, "</body></html>")
 	return err
 }
@@ -129,7 +142,12 @@ type Server struct {
 	messageBroker        messaging.Broker
 	messageBrokerMetrics brokerMetrics
 	streamSeq            atomic.Uint64
-	app                  *app.App
+	// subjectPrefix is what every subject starts with on the wire,
+	// empty without datapages.WithSubjectPrefix.
+	subjectPrefix string
+	// tracer is nil without datapages.WithTracerProvider.
+	tracer tracing.Tracer
+	app    *app.App
 }
 
 // Init wires the server. It is called by datapages.NewServer,
@@ -144,6 +162,9 @@ type Server struct {
 //   - datapages.WithHTTPServer
 //   - datapages.WithDatastarJS
 //   - datapages.WithAssets
+//   - datapages.WithSubjectPrefix
+//   - datapages.WithBrokerInterceptors
+//   - datapages.WithTracerProvider
 func (s *Server) Init(
 	cfg datapages.ServerConfig,
 	app *app.App,
@@ -159,6 +180,10 @@ func (s *Server) Init(
 		return errors.New("unexpected option WithPrometheus: " +
 			"the server is generated with datapages.DisablePrometheus")
 	}
+	if cfg.MeterProvider != nil {
+		return errors.New("unexpected option WithMeterProvider: " +
+			"the server is generated with datapages.DisablePrometheus")
+	}
 
 	assetsFS, err := assetsFileSystem(cfg)
 	if err != nil {
@@ -169,14 +194,23 @@ func (s *Server) Init(
 	s.Core = httpserve.NewCore(cfg, "")
 	s.app = app
 	s.messageBroker = messageBroker
+	s.tracer = tracing.NewTracer(cfg.TracerProvider)
+	if cfg.SubjectPrefix != "" {
+		s.subjectPrefix = subject.Prefix(cfg.SubjectPrefix)
+	}
 	s.messageBrokerMetrics = brokerMetrics{}
 
 	if si, ok := s.messageBroker.(messaging.StreamInitializer); ok {
-		if err := si.InitStreams(MessageBrokerStreamSubjects()); err != nil {
+		subjects := subject.InNamespace(s.subjectPrefix, MessageBrokerStreamSubjects())
+		if err := si.InitStreams(subjects); err != nil {
 			return fmt.Errorf("initializing message broker streams: %w", err)
 		}
 	}
 
+	s.AddReadinessCheck("broker", func(ctx context.Context) error {
+		return messaging.Ping(ctx, s.messageBroker)
+	})
+
 	setupHandlers(s)
 
 	s.Build()
@@ -254,10 +288,11 @@ func setupHandlers(s *Server) {
 }
 
 func (s *Server) httpErrIntern(
-	w http.ResponseWriter, _ *http.Request,
+	w http.ResponseWriter, r *http.Request,
 	_ *datastar.ServerSentEventGenerator, msg string, err error,
 ) {
-	s.LogErr(msg, err)
+	s.LogErrCtx(r.Context(), msg, err)
+	tracing.FailRequest(r, err)
 	switch {
 	case errors.Is(err, datapages.ErrBadRequest):
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
@@ -272,7 +307,18 @@ func (s *Server) httpErrIntern(
 	}
 }
 
+func (s *Server) recoverDeferred(
+	w io.Writer, r *http.Request, id string, err error,
+) {
+	err = datapages.DeferredError{Selector: "#" + id, Err: err}
+	s.LogErrCtx(r.Context(), "resolving deferred component", err)
+}
+
 func (s *Server) handlePOSTPing(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "App.POSTPing")
+	defer span.End()
+	reqlog.SetHandler(r, "App.POSTPing")
+
 	err := s.app.POSTPing(r)
 	if err != nil {
 		s.httpErrIntern(w, r, nil, "handling action App.Ping", err)
@@ -281,6 +327,10 @@ func (s *Server) handlePOSTPing(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleDELETEAll(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "App.DELETEAll")
+	defer span.End()
+	reqlog.SetHandler(r, "App.DELETEAll")
+
 	err := s.app.DELETEAll(r)
 	if err != nil {
 		s.httpErrIntern(w, r, nil, "handling action App.All", err)
@@ -289,6 +339,10 @@ func (s *Server) handleDELETEAll(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handlePageFormGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageForm.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageForm.GET")
+
 	p := app.PageForm{
 		App: s.app,
 	}
@@ -306,7 +360,7 @@ func (s *Server) handlePageFormGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, genericHead, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageForm", err)
+		s.LogErrCtx(r.Context(), "rendering PageForm", err)
 		return
 	}
 }
@@ -314,6 +368,10 @@ func (s *Server) handlePageFormGET(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handlePageFormPOSTSubmit(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageForm.POSTSubmit")
+	defer span.End()
+	reqlog.SetHandler(r, "PageForm.POSTSubmit")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -339,6 +397,10 @@ func (s *Server) handlePageFormPOSTSubmit(
 func (s *Server) handlePageFormPUTReplace(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageForm.PUTReplace")
+	defer span.End()
+	reqlog.SetHandler(r, "PageForm.PUTReplace")
+
 	p := app.PageForm{
 		App: s.app,
 	}
@@ -352,6 +414,10 @@ func (s *Server) handlePageFormPUTReplace(
 func (s *Server) handlePageFormPATCHTouch(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageForm.PATCHTouch")
+	defer span.End()
+	reqlog.SetHandler(r, "PageForm.PATCHTouch")
+
 	p := app.PageForm{
 		App: s.app,
 	}
@@ -365,6 +431,10 @@ func (s *Server) handlePageFormPATCHTouch(
 func (s *Server) handlePageFormDELETERemove(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageForm.DELETERemove")
+	defer span.End()
+	reqlog.SetHandler(r, "PageForm.DELETERemove")
+
 	p := app.PageForm{
 		App: s.app,
 	}
@@ -378,6 +448,9 @@ func (s *Server) handlePageFormDELETERemove(
 func (s *Server) handlePageFormPOSTBump(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageForm.POSTBump")
+	defer span.End()
+	reqlog.SetHandler(r, "PageForm.POSTBump")
 
 	var query datapages.Query[struct {
 		By int `query:"by"`
@@ -418,6 +491,10 @@ func (s *Server) handlePageFormPOSTBump(
 func (s *Server) handlePageFormPOSTRender(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageForm.POSTRender")
+	defer span.End()
+	reqlog.SetHandler(r, "PageForm.POSTRender")
+
 	p := app.PageForm{
 		App: s.app,
 	}
@@ -430,7 +507,7 @@ func (s *Server) handlePageFormPOSTRender(
 	if err := s.writeHTML(
 		w, r, genericHead, nil, body, nil, nil,
 	); err != nil {
-		s.LogErr("rendering response of PageForm.POSTRender", err)
+		s.LogErrCtx(r.Context(), "rendering response of PageForm.POSTRender", err)
 		return
 	}
 }
@@ -438,6 +515,10 @@ func (s *Server) handlePageFormPOSTRender(
 func (s *Server) handlePageFormPOSTGo(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageForm.POSTGo")
+	defer span.End()
+	reqlog.SetHandler(r, "PageForm.POSTGo")
+
 	p := app.PageForm{
 		App: s.app,
 	}
@@ -454,6 +535,10 @@ func (s *Server) handlePageFormPOSTGo(
 func (s *Server) handlePageFormPOSTPatch(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageForm.POSTPatch")
+	defer span.End()
+	reqlog.SetHandler(r, "PageForm.POSTPatch")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -479,6 +564,10 @@ func (s *Server) handlePageFormPOSTPatch(
 func (s *Server) handlePageFormPOSTPatchAt(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageForm.POSTPatchAt")
+	defer span.End()
+	reqlog.SetHandler(r, "PageForm.POSTPatchAt")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -505,6 +594,10 @@ func (s *Server) handlePageFormPOSTPatchAt(
 func (s *Server) handlePageFormPOSTSignalsRaw(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageForm.POSTSignalsRaw")
+	defer span.End()
+	reqlog.SetHandler(r, "PageForm.POSTSignalsRaw")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -523,6 +616,10 @@ func (s *Server) handlePageFormPOSTSignalsRaw(
 func (s *Server) handlePageFormPOSTSignalsMissing(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageForm.POSTSignalsMissing")
+	defer span.End()
+	reqlog.SetHandler(r, "PageForm.POSTSignalsMissing")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -541,6 +638,10 @@ func (s *Server) handlePageFormPOSTSignalsMissing(
 func (s *Server) handlePageFormPOSTSignalsBad(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageForm.POSTSignalsBad")
+	defer span.End()
+	reqlog.SetHandler(r, "PageForm.POSTSignalsBad")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -559,6 +660,10 @@ func (s *Server) handlePageFormPOSTSignalsBad(
 func (s *Server) handlePageFormPOSTRemove(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageForm.POSTRemove")
+	defer span.End()
+	reqlog.SetHandler(r, "PageForm.POSTRemove")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -575,6 +680,10 @@ func (s *Server) handlePageFormPOSTRemove(
 }
 
 func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.GET")
+
 	if r.URL.Path != "/" {
 		http.NotFound(w, r)
 		return
@@ -597,12 +706,16 @@ func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, genericHead, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageIndex", err)
+		s.LogErrCtx(r.Context(), "rendering PageIndex", err)
 		return
 	}
 }
 
 func (s *Server) handlePageLogGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageLog.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageLog.GET")
+
 	p := app.PageLog{
 		App: s.app,
 	}
@@ -620,7 +733,7 @@ func (s *Server) handlePageLogGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, genericHead, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageLog", err)
+		s.LogErrCtx(r.Context(), "rendering PageLog", err)
 		return
 	}
 }
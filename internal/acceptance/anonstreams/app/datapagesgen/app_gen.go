@@ -4,7 +4,6 @@ package datapagesgen
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -12,14 +11,22 @@ import (
 	"net/http"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/romshark/datapages"
+	"github.com/romshark/datapages/modules/codec"
 	"github.com/romshark/datapages/modules/messaging"
 	"github.com/romshark/datapages/modules/sessions"
 	"github.com/romshark/datapages/runtime/auth"
+	"github.com/romshark/datapages/runtime/deferred"
+	"github.com/romshark/datapages/runtime/dispatch"
+	"github.com/romshark/datapages/runtime/eventcache"
 	"github.com/romshark/datapages/runtime/httpserve"
+	"github.com/romshark/datapages/runtime/jsonenc"
+	"github.com/romshark/datapages/runtime/reqlog"
 	dpsse "github.com/romshark/datapages/runtime/sse"
 	"github.com/romshark/datapages/runtime/subject"
+	"github.com/romshark/datapages/runtime/tracing"
 
 	"github.com/romshark/datapages/internal/acceptance/anonstreams/app"
 	"github.com/romshark/datapages/internal/acceptance/anonstreams/app/datapagesgen/href"
@@ -103,13 +110,18 @@ func (s *Server) writeHTML(
 	if err != nil {
 		return err
 	}
+	// The deferred components of the body follow it on the same response,
+	// the ones of the head are resolved as it's rendered.
+	ctx, deferreds := deferred.Start(r.Context(), s.DeferredTimeout(),
+		func(w io.Writer, id string, err error) { s.recoverDeferred(w, r, id, err) })
+	headCtx := deferred.InPlace(ctx)
 	if headGeneric != nil {
-		if err := headGeneric.Render(r.Context(), w); err != nil {
+		if err := headGeneric.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
 	if head != nil {
-		if err := head.Render(r.Context(), w); err != nil {
+		if err := head.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
@@ -153,7 +165,7 @@ func (s *Server) writeHTML(
 		return err
 	}
 	if body != nil {
-		if err := body.Render(r.Context(), w); err != nil {
+		if err := body.Render(ctx, w); err != nil {
 			return err
 		}
 	}
@@ -166,6 +178,9 @@ func (s *Server) writeHTML(
 			return err
 		}
 	}
+	if deferreds.Len() > 0 {
+		deferreds.Resolve(r.Context(), w)
+	}
 	_, err = io.WriteString(w, "</body></html>")
 	return err
 }
@@ -187,21 +202,41 @@ func (s *Server) handleStreamRequest(
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
+	// A server shutting down opens no streams, the client reconnects
+	// to another instance.
+	if s.Draining() {
+		http.Error(w,
+			http.StatusText(http.StatusServiceUnavailable),
+			http.StatusServiceUnavailable)
+		return
+	}
 
 	streamID := datapages.StreamID(s.streamSeq.Add(1))
+	reqlog.SetStream(r.Context(), uint64(streamID))
+
+	// A stream would wait for events that can't arrive while the broker is
+	// down. It's refused, and closed once the broker goes down later, so the
+	// client reconnects and renders what it missed when the broker is back.
+	brokerUp, brokerChanged := messaging.StatusOf(s.messageBroker)
+	if !brokerUp {
+		s.httpErrIntern(w, r, nil, "opening stream", messaging.ErrBrokerUnavailable)
+		return
+	}
 
 	// The subscription is established before the response head goes out.
 	// A client learns the stream is open by reading that head and may dispatch
 	// immediately after, which must not reach the broker before this.
 	ctx := r.Context()
-	sub, err := s.messageBroker.Subscribe(ctx, s.messageBrokerMetrics, subjects...)
+	sub, err := s.messageBroker.Subscribe(ctx, s.messageBrokerMetrics,
+		subject.InNamespace(s.subjectPrefix, subjects)...)
 	if err != nil {
 		// Nothing has been written yet, so the error can still carry a status.
 		s.httpErrIntern(w, r, nil, "subscribing to message broker", err)
 		return
 	}
 
-	sse := datastar.NewSSE(w, r, datastar.WithCompression())
+	// The heartbeats and the retry hint go out beside the events of sse.
+	sse, sseW := dpsse.NewSSE(w, r)
 
 	subC := sub.C()
 	if onOpen != nil {
@@ -225,10 +260,29 @@ func (s *Server) handleStreamRequest(
 	}
 
 	go func() {
-		select {
-		case <-sessionClosed:
-		case <-r.Context().Done():
-		case <-s.ShutdownCh():
+		heartbeat, expired, stopTimers := s.StreamTimers()
+		defer stopTimers()
+		shutdown := s.ShutdownCh()
+		var drained <-chan time.Time
+		// A heartbeat goes out on the stream and the wait goes on, so does
+		// the drain the shutdown begins. Any other case ends the stream.
+		for {
+			select {
+			case <-heartbeat:
+				_ = dpsse.Heartbeat(sseW)
+				continue
+			case <-shutdown:
+				shutdown, drained = nil, s.DrainTimer()
+				continue
+			case <-drained:
+				_ = dpsse.Expire(sseW, s.DrainRetry())
+			case <-expired:
+				_ = dpsse.Expire(sseW, s.StreamRetry())
+			case <-sessionClosed:
+			case <-r.Context().Done():
+			case <-brokerChanged:
+			}
+			break
 		}
 		sub.Close()
 		if onClose != nil {
@@ -244,7 +298,20 @@ type Server struct {
 	messageBroker        messaging.Broker
 	messageBrokerMetrics brokerMetrics
 	streamSeq            atomic.Uint64
-	app                  *app.App
+	// subjectPrefix is what every subject starts with on the wire,
+	// empty without datapages.WithSubjectPrefix.
+	subjectPrefix string
+	// tracer is nil without datapages.WithTracerProvider.
+	tracer     tracing.Tracer
+	app        *app.App
+	eventCodec codec.Codec
+	eventCache *eventcache.Cache
+	// jsonEvents is true when eventCodec is codec.JSON, which the generated
+	// encoders produce the payload of without reflection.
+	jsonEvents bool
+	// brokerInterceptors are those of datapages.WithBrokerInterceptors,
+	// which datapages.DispatchTo installs on the publisher it's given.
+	brokerInterceptors []messaging.Interceptor
 	*auth.Manager[struct{}]
 }
 
@@ -260,6 +327,10 @@ type Server struct {
 //   - datapages.WithHTTPServer
 //   - datapages.WithDatastarJS
 //   - datapages.WithAssets
+//   - datapages.WithSubjectPrefix
+//   - datapages.WithBrokerInterceptors
+//   - datapages.WithTracerProvider
+//   - datapages.WithEventCodec
 //   - datapages.WithSessionManager (required)
 //   - datapages.WithSessions
 //   - datapages.WithCSRFProtection
@@ -275,6 +346,10 @@ func (s *Server) Init(
 		return errors.New("unexpected option WithPrometheus: " +
 			"the server is generated with datapages.DisablePrometheus")
 	}
+	if cfg.MeterProvider != nil {
+		return errors.New("unexpected option WithMeterProvider: " +
+			"the server is generated with datapages.DisablePrometheus")
+	}
 	if sessionManager == nil {
 		return errors.New("missing option WithSessionManager")
 	}
@@ -288,15 +363,31 @@ func (s *Server) Init(
 	s.Core = httpserve.NewCore(cfg, "")
 	s.app = app
 	s.messageBroker = messageBroker
+	s.tracer = tracing.NewTracer(cfg.TracerProvider)
+	if cfg.SubjectPrefix != "" {
+		s.subjectPrefix = subject.Prefix(cfg.SubjectPrefix)
+	}
 	s.messageBrokerMetrics = brokerMetrics{}
+	s.eventCodec = cfg.EventCodec
+	s.eventCache = eventcache.New(eventcache.DefaultSize)
+	_, s.jsonEvents = cfg.EventCodec.(codec.JSON)
+	s.brokerInterceptors = cfg.BrokerInterceptors
 
 	if si, ok := s.messageBroker.(messaging.StreamInitializer); ok {
-		if err := si.InitStreams(MessageBrokerStreamSubjects()); err != nil {
+		subjects := subject.InNamespace(s.subjectPrefix, MessageBrokerStreamSubjects())
+		if err := si.InitStreams(subjects); err != nil {
 			return fmt.Errorf("initializing message broker streams: %w", err)
 		}
 	}
 	s.Manager = auth.NewManager(s.Core, sessionManager, cfg, nil)
 
+	s.AddReadinessCheck("broker", func(ctx context.Context) error {
+		return messaging.Ping(ctx, s.messageBroker)
+	})
+	if p, ok := sessionManager.(sessions.Pinger); ok {
+		s.AddReadinessCheck("sessions", p.Ping)
+	}
+
 	setupHandlers(s)
 
 	s.Build()
@@ -386,10 +477,11 @@ func setupHandlers(s *Server) {
 }
 
 func (s *Server) httpErrIntern(
-	w http.ResponseWriter, _ *http.Request,
+	w http.ResponseWriter, r *http.Request,
 	_ *datastar.ServerSentEventGenerator, msg string, err error,
 ) {
-	s.LogErr(msg, err)
+	s.LogErrCtx(r.Context(), msg, err)
+	tracing.FailRequest(r, err)
 	switch {
 	case errors.Is(err, datapages.ErrBadRequest):
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
@@ -404,7 +496,18 @@ func (s *Server) httpErrIntern(
 	}
 }
 
+func (s *Server) recoverDeferred(
+	w io.Writer, r *http.Request, id string, err error,
+) {
+	err = datapages.DeferredError{Selector: "#" + id, Err: err}
+	s.LogErrCtx(r.Context(), "resolving deferred component", err)
+}
+
 func (s *Server) handlePageFeedGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageFeed.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageFeed.GET")
+
 	p := app.PageFeed{
 		App: s.app,
 	}
@@ -425,12 +528,13 @@ func (s *Server) handlePageFeedGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, datapages.Session[struct{}]{}, genericHead, nil, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageFeed", err)
+		s.LogErrCtx(r.Context(), "rendering PageFeed", err)
 		return
 	}
 }
 
 func (s *Server) handlePageFeedGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageFeed.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -463,34 +567,50 @@ func (s *Server) handlePageFeedGETStream(w http.ResponseWriter, r *http.Request)
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventTicked app.EventTicked
-			var eventNoticed app.EventNoticed
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch {
 				case msg.Subject == EvSubjTicked:
-					eventTicked = app.EventTicked{}
-					if err := json.Unmarshal(msg.Data, &eventTicked); err != nil {
-						s.LogErr("unmarshaling EventTicked JSON", err)
+					eventTicked, err := eventcache.Decode[app.EventTicked](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventTicked", err)
 						continue
 					}
-					if err := p.OnTicked(eventTicked, dpsse.New(sse)); err != nil {
-						s.LogErr("handling PageFeed.OnTicked", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageFeed.OnTicked", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageFeed.OnTicked", msg)
+						defer span.End()
+						if err := p.OnTicked(eventTicked, dpsse.NewContext(ctx, sse)); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageFeed.OnTicked", err)
+						}
+					}()
 				case strings.HasPrefix(msg.Subject, EvSubjPrefNoticed):
-					eventNoticed = app.EventNoticed{}
-					if err := json.Unmarshal(msg.Data, &eventNoticed); err != nil {
-						s.LogErr("unmarshaling EventNoticed JSON", err)
+					eventNoticed, err := eventcache.Decode[app.EventNoticed](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventNoticed", err)
 						continue
 					}
-					if err := p.OnNoticed(eventNoticed, dpsse.New(sse)); err != nil {
-						s.LogErr("handling PageFeed.OnNoticed", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageFeed.OnNoticed", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageFeed.OnNoticed", msg)
+						defer span.End()
+						if err := p.OnNoticed(eventNoticed, dpsse.NewContext(ctx, sse)); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageFeed.OnNoticed", err)
+						}
+					}()
 				}
 			}
 		})
 }
 
 func (s *Server) handlePageFeedGETStreamAnon(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageFeed.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -514,18 +634,26 @@ func (s *Server) handlePageFeedGETStreamAnon(w http.ResponseWriter, r *http.Requ
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventTicked app.EventTicked
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch msg.Subject {
 				case EvSubjTicked:
-					eventTicked = app.EventTicked{}
-					if err := json.Unmarshal(msg.Data, &eventTicked); err != nil {
-						s.LogErr("unmarshaling EventTicked JSON", err)
+					eventTicked, err := eventcache.Decode[app.EventTicked](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventTicked", err)
 						continue
 					}
-					if err := p.OnTicked(eventTicked, dpsse.New(sse)); err != nil {
-						s.LogErr("handling PageFeed.OnTicked", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageFeed.OnTicked", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageFeed.OnTicked", msg)
+						defer span.End()
+						if err := p.OnTicked(eventTicked, dpsse.NewContext(ctx, sse)); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageFeed.OnTicked", err)
+						}
+					}()
 				}
 			}
 		})
@@ -534,6 +662,10 @@ func (s *Server) handlePageFeedGETStreamAnon(w http.ResponseWriter, r *http.Requ
 func (s *Server) handlePageFeedPOSTTick(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageFeed.POSTTick")
+	defer span.End()
+	reqlog.SetHandler(r, "PageFeed.POSTTick")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -563,6 +695,10 @@ func (s *Server) handlePageFeedPOSTTick(
 }
 
 func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.GET")
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -590,12 +726,16 @@ func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, genericHead, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageIndex", err)
+		s.LogErrCtx(r.Context(), "rendering PageIndex", err)
 		return
 	}
 }
 
 func (s *Server) handlePageRoomsGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageRooms.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageRooms.GET")
+
 	p := app.PageRooms{
 		App: s.app,
 	}
@@ -616,12 +756,13 @@ func (s *Server) handlePageRoomsGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, datapages.Session[struct{}]{}, genericHead, nil, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageRooms", err)
+		s.LogErrCtx(r.Context(), "rendering PageRooms", err)
 		return
 	}
 }
 
 func (s *Server) handlePageRoomsGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageRooms.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -667,34 +808,50 @@ func (s *Server) handlePageRoomsGETStream(w http.ResponseWriter, r *http.Request
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventRoomPosted app.EventRoomPosted
-			var eventNoticed app.EventNoticed
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch {
 				case strings.HasPrefix(msg.Subject, EvSubjPrefRoomPosted):
-					eventRoomPosted = app.EventRoomPosted{}
-					if err := json.Unmarshal(msg.Data, &eventRoomPosted); err != nil {
-						s.LogErr("unmarshaling EventRoomPosted JSON", err)
+					eventRoomPosted, err := eventcache.Decode[app.EventRoomPosted](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventRoomPosted", err)
 						continue
 					}
-					if err := p.OnRoomPosted(eventRoomPosted, dpsse.New(sse)); err != nil {
-						s.LogErr("handling PageRooms.OnRoomPosted", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageRooms.OnRoomPosted", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageRooms.OnRoomPosted", msg)
+						defer span.End()
+						if err := p.OnRoomPosted(eventRoomPosted, dpsse.NewContext(ctx, sse)); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageRooms.OnRoomPosted", err)
+						}
+					}()
 				case strings.HasPrefix(msg.Subject, EvSubjPrefNoticed):
-					eventNoticed = app.EventNoticed{}
-					if err := json.Unmarshal(msg.Data, &eventNoticed); err != nil {
-						s.LogErr("unmarshaling EventNoticed JSON", err)
+					eventNoticed, err := eventcache.Decode[app.EventNoticed](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventNoticed", err)
 						continue
 					}
-					if err := p.OnNoticed(eventNoticed, dpsse.New(sse)); err != nil {
-						s.LogErr("handling PageRooms.OnNoticed", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageRooms.OnNoticed", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageRooms.OnNoticed", msg)
+						defer span.End()
+						if err := p.OnNoticed(eventNoticed, dpsse.NewContext(ctx, sse)); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageRooms.OnNoticed", err)
+						}
+					}()
 				}
 			}
 		})
 }
 
 func (s *Server) handlePageRoomsGETStreamAnon(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageRooms.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -731,18 +888,26 @@ func (s *Server) handlePageRoomsGETStreamAnon(w http.ResponseWriter, r *http.Req
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventRoomPosted app.EventRoomPosted
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch {
 				case strings.HasPrefix(msg.Subject, EvSubjPrefRoomPosted):
-					eventRoomPosted = app.EventRoomPosted{}
-					if err := json.Unmarshal(msg.Data, &eventRoomPosted); err != nil {
-						s.LogErr("unmarshaling EventRoomPosted JSON", err)
+					eventRoomPosted, err := eventcache.Decode[app.EventRoomPosted](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventRoomPosted", err)
 						continue
 					}
-					if err := p.OnRoomPosted(eventRoomPosted, dpsse.New(sse)); err != nil {
-						s.LogErr("handling PageRooms.OnRoomPosted", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageRooms.OnRoomPosted", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageRooms.OnRoomPosted", msg)
+						defer span.End()
+						if err := p.OnRoomPosted(eventRoomPosted, dpsse.NewContext(ctx, sse)); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageRooms.OnRoomPosted", err)
+						}
+					}()
 				}
 			}
 		})
@@ -751,6 +916,10 @@ func (s *Server) handlePageRoomsGETStreamAnon(w http.ResponseWriter, r *http.Req
 func (s *Server) handlePageRoomsPOSTPost(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageRooms.POSTPost")
+	defer span.End()
+	reqlog.SetHandler(r, "PageRooms.POSTPost")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -783,6 +952,10 @@ func (s *Server) handlePageRoomsPOSTPost(
 func (s *Server) handlePageRoomsPOSTNotice(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageRooms.POSTNotice")
+	defer span.End()
+	reqlog.SetHandler(r, "PageRooms.POSTNotice")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -824,17 +997,64 @@ func (d dispatcherEventTicked) Dispatch(e app.EventTicked) error {
 func (d dispatcherEventTicked) DispatchCtx(
 	ctx context.Context, e app.EventTicked,
 ) error {
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventTicked JSON: %w", err)
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventTicked) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventTicked,
+) error {
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventTicked) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventTicked,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventTicked: %w", messaging.ErrNoScheduler)
 	}
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, EvSubjTicked, j)
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventTicked) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventTicked,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventTicked) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventTicked,
+) error {
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventTickedJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventTicked: %w", err)
+	}
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at,
+		d.s.subjectPrefix+EvSubjTicked, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", EvSubjTicked, err)
 	}
 	return nil
 }
 
+// appendEventTickedJSON appends e encoded the way encoding/json encodes it.
+func appendEventTickedJSON(b []byte, e app.EventTicked) []byte {
+	start := len(b)
+	b = append(b, `,"n":`...)
+	b = jsonenc.AppendInt(b, int64(e.N))
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
+
 type dispatcherEventRoomPosted struct {
 	s   *Server
 	ctx context.Context
@@ -846,24 +1066,72 @@ func (d dispatcherEventRoomPosted) Dispatch(e app.EventRoomPosted) error {
 
 func (d dispatcherEventRoomPosted) DispatchCtx(
 	ctx context.Context, e app.EventRoomPosted,
+) error {
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventRoomPosted) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventRoomPosted,
+) error {
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventRoomPosted) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventRoomPosted,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventRoomPosted: %w", messaging.ErrNoScheduler)
+	}
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventRoomPosted) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventRoomPosted,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventRoomPosted) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventRoomPosted,
 ) error {
 	if !subject.IsToken(string(e.Room)) {
 		return fmt.Errorf(
 			"EventRoomPosted.Room must be a non-empty subject token, received %q",
 			e.Room)
 	}
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventRoomPosted JSON: %w", err)
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventRoomPostedJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventRoomPosted: %w", err)
 	}
 	subj := "room.posted." + string(e.Room)
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, subj, j)
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at, d.s.subjectPrefix+subj, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", subj, err)
 	}
 	return nil
 }
 
+// appendEventRoomPostedJSON appends e encoded the way encoding/json encodes it.
+func appendEventRoomPostedJSON(b []byte, e app.EventRoomPosted) []byte {
+	start := len(b)
+	b = append(b, `,"Room":`...)
+	b = jsonenc.AppendString(b, string(e.Room))
+	b = append(b, `,"text":`...)
+	b = jsonenc.AppendString(b, string(e.Text))
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
+
 type dispatcherEventNoticed struct {
 	s   *Server
 	ctx context.Context
@@ -875,20 +1143,68 @@ func (d dispatcherEventNoticed) Dispatch(e app.EventNoticed) error {
 
 func (d dispatcherEventNoticed) DispatchCtx(
 	ctx context.Context, e app.EventNoticed,
+) error {
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventNoticed) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventNoticed,
+) error {
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventNoticed) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventNoticed,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventNoticed: %w", messaging.ErrNoScheduler)
+	}
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventNoticed) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventNoticed,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventNoticed) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventNoticed,
 ) error {
 	if !subject.IsToken(string(e.Recipient)) {
 		return fmt.Errorf(
 			"EventNoticed.Recipient must be a non-empty subject token, received %q",
 			e.Recipient)
 	}
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventNoticed JSON: %w", err)
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventNoticedJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventNoticed: %w", err)
 	}
 	subj := "noticed." + string(e.Recipient)
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, subj, j)
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at, d.s.subjectPrefix+subj, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", subj, err)
 	}
 	return nil
 }
+
+// appendEventNoticedJSON appends e encoded the way encoding/json encodes it.
+func appendEventNoticedJSON(b []byte, e app.EventNoticed) []byte {
+	start := len(b)
+	b = append(b, `,"Recipient":`...)
+	b = jsonenc.AppendString(b, string(e.Recipient))
+	b = append(b, `,"text":`...)
+	b = jsonenc.AppendString(b, string(e.Text))
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
@@ -4,21 +4,29 @@ package datapagesgen
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
 	"net/http"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/romshark/datapages"
+	"github.com/romshark/datapages/modules/codec"
 	"github.com/romshark/datapages/modules/messaging"
 	"github.com/romshark/datapages/modules/sessions"
+	"github.com/romshark/datapages/runtime/deferred"
+	"github.com/romshark/datapages/runtime/dispatch"
+	"github.com/romshark/datapages/runtime/eventcache"
 	"github.com/romshark/datapages/runtime/httpserve"
+	"github.com/romshark/datapages/runtime/jsonenc"
+	"github.com/romshark/datapages/runtime/reqlog"
 	dpsse "github.com/romshark/datapages/runtime/sse"
+	"github.com/romshark/datapages/runtime/subject"
+	"github.com/romshark/datapages/runtime/tracing"
 
 	"github.com/romshark/datapages/internal/acceptance/assetsmetrics/app"
 	"github.com/romshark/datapages/internal/acceptance/assetsmetrics/app/datapagesgen/assets"
@@ -60,10 +68,13 @@ func assetsFileSystem(cfg datapages.ServerConfig) (http.FileSystem, error) {
 }
 
 // brokerMetrics implements messaging.Metrics using the built-in Prometheus counters.
-type brokerMetrics struct{}
+type brokerMetrics struct {
+	// prefix is stripped from a subject before it's counted by its kind.
+	prefix string
+}
 
 func (m brokerMetrics) OnPublish(subject string) {
-	prom.BrokerPublish(brokerSubjectKind(subject))
+	prom.BrokerPublish(brokerSubjectKind(strings.TrimPrefix(subject, m.prefix)))
 }
 
 func (m brokerMetrics) OnDeliveryDropped() {
@@ -102,8 +113,13 @@ func (s *Server) writeHTML(
 	if err != nil {
 		return err
 	}
+	// The deferred components of the body follow it on the same response,
+	// the ones of the head are resolved as it's rendered.
+	ctx, deferreds := deferred.Start(r.Context(), s.DeferredTimeout(),
+		func(w io.Writer, id string, err error) { s.recoverDeferred(w, r, id, err) })
+	headCtx := deferred.InPlace(ctx)
 	if head != nil {
-		if err := head.Render(r.Context(), w); err != nil {
+		if err := head.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
@@ -117,7 +133,7 @@ func (s *Server) writeHTML(
 		return err
 	}
 	if body != nil {
-		if err := body.Render(r.Context(), w); err != nil {
+		if err := body.Render(ctx, w); err != nil {
 			return err
 		}
 	}
@@ -130,12 +146,15 @@ func (s *Server) writeHTML(
 			return err
 		}
 	}
+	if deferreds.Len() > 0 {
+		deferreds.Resolve(r.Context(), w)
+	}
 	_, err = io.WriteString(w, "</body></html>")
 	return err
 }
 
 func (s *Server) handleStreamRequest(
-	w http.ResponseWriter, r *http.Request,
+	w http.ResponseWriter, r *http.Request, page string,
 	subjects []string,
 	onOpen func(
 		streamID datapages.StreamID,
@@ -151,23 +170,43 @@ func (s *Server) handleStreamRequest(
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
+	// A server shutting down opens no streams, the client reconnects
+	// to another instance.
+	if s.Draining() {
+		http.Error(w,
+			http.StatusText(http.StatusServiceUnavailable),
+			http.StatusServiceUnavailable)
+		return
+	}
 
 	streamID := datapages.StreamID(s.streamSeq.Add(1))
+	reqlog.SetStream(r.Context(), uint64(streamID))
+
+	// A stream would wait for events that can't arrive while the broker is
+	// down. It's refused, and closed once the broker goes down later, so the
+	// client reconnects and renders what it missed when the broker is back.
+	brokerUp, brokerChanged := messaging.StatusOf(s.messageBroker)
+	if !brokerUp {
+		s.httpErrIntern(w, r, nil, "opening stream", messaging.ErrBrokerUnavailable)
+		return
+	}
 
 	// The subscription is established before the response head goes out.
 	// A client learns the stream is open by reading that head and may dispatch
 	// immediately after, which must not reach the broker before this.
 	ctx := r.Context()
-	sub, err := s.messageBroker.Subscribe(ctx, s.messageBrokerMetrics, subjects...)
+	sub, err := s.messageBroker.Subscribe(ctx, s.messageBrokerMetrics,
+		subject.InNamespace(s.subjectPrefix, subjects)...)
 	if err != nil {
 		// Nothing has been written yet, so the error can still carry a status.
 		s.httpErrIntern(w, r, nil, "subscribing to message broker", err)
 		return
 	}
 
-	sse := datastar.NewSSE(w, r, datastar.WithCompression())
-	prom.SSEConnectionOpened()
-	defer prom.SSEConnectionClosed()
+	// The heartbeats and the retry hint go out beside the events of sse.
+	sse, sseW := dpsse.NewSSE(w, r)
+	prom.SSEConnectionOpened(page)
+	defer prom.SSEConnectionClosed(page)
 	start := time.Now()
 
 	subC := sub.C()
@@ -179,11 +218,32 @@ func (s *Server) handleStreamRequest(
 		}
 	}
 	go func() {
-		select {
-		case <-r.Context().Done():
-			prom.SSEDisconnect("client")
-		case <-s.ShutdownCh():
-			prom.SSEDisconnect("shutdown")
+		heartbeat, expired, stopTimers := s.StreamTimers()
+		defer stopTimers()
+		shutdown := s.ShutdownCh()
+		var drained <-chan time.Time
+		// A heartbeat goes out on the stream and the wait goes on, so does
+		// the drain the shutdown begins. Any other case ends the stream.
+		for {
+			select {
+			case <-heartbeat:
+				_ = dpsse.Heartbeat(sseW)
+				continue
+			case <-shutdown:
+				shutdown, drained = nil, s.DrainTimer()
+				continue
+			case <-drained:
+				_ = dpsse.Expire(sseW, s.DrainRetry())
+				prom.SSEDisconnect("shutdown")
+			case <-expired:
+				_ = dpsse.Expire(sseW, s.StreamRetry())
+				prom.SSEDisconnect("ttl")
+			case <-r.Context().Done():
+				prom.SSEDisconnect("client")
+			case <-brokerChanged:
+				prom.SSEDisconnect("broker")
+			}
+			break
 		}
 		prom.SSEConnectionDuration(start)
 		sub.Close()
@@ -200,7 +260,20 @@ type Server struct {
 	messageBroker        messaging.Broker
 	messageBrokerMetrics brokerMetrics
 	streamSeq            atomic.Uint64
-	app                  *app.App
+	// subjectPrefix is what every subject starts with on the wire,
+	// empty without datapages.WithSubjectPrefix.
+	subjectPrefix string
+	// tracer is nil without datapages.WithTracerProvider.
+	tracer     tracing.Tracer
+	app        *app.App
+	eventCodec codec.Codec
+	eventCache *eventcache.Cache
+	// jsonEvents is true when eventCodec is codec.JSON, which the generated
+	// encoders produce the payload of without reflection.
+	jsonEvents bool
+	// brokerInterceptors are those of datapages.WithBrokerInterceptors,
+	// which datapages.DispatchTo installs on the publisher it's given.
+	brokerInterceptors []messaging.Interceptor
 }
 
 // Init wires the server. It is called by datapages.NewServer,
@@ -215,6 +288,10 @@ type Server struct {
 //   - datapages.WithHTTPServer
 //   - datapages.WithDatastarJS
 //   - datapages.WithAssets
+//   - datapages.WithSubjectPrefix
+//   - datapages.WithBrokerInterceptors
+//   - datapages.WithTracerProvider
+//   - datapages.WithEventCodec
 //   - datapages.WithPrometheus (required)
 func (s *Server) Init(
 	cfg datapages.ServerConfig,
@@ -230,6 +307,10 @@ func (s *Server) Init(
 		// hence the metrics it counts must be served.
 		return errors.New("missing option WithPrometheus")
 	}
+	if cfg.MeterProvider != nil {
+		return errors.New("unexpected option WithMeterProvider: " +
+			"the server is generated with datapages.EnablePrometheus")
+	}
 
 	assetsFS, err := assetsFileSystem(cfg)
 	if err != nil {
@@ -240,14 +321,27 @@ func (s *Server) Init(
 	s.Core = httpserve.NewCore(cfg, assets.URLPrefix)
 	s.app = app
 	s.messageBroker = messageBroker
-	s.messageBrokerMetrics = brokerMetrics{}
+	s.tracer = tracing.NewTracer(cfg.TracerProvider)
+	if cfg.SubjectPrefix != "" {
+		s.subjectPrefix = subject.Prefix(cfg.SubjectPrefix)
+	}
+	s.messageBrokerMetrics = brokerMetrics{prefix: s.subjectPrefix}
+	s.eventCodec = cfg.EventCodec
+	s.eventCache = eventcache.New(eventcache.DefaultSize)
+	_, s.jsonEvents = cfg.EventCodec.(codec.JSON)
+	s.brokerInterceptors = cfg.BrokerInterceptors
 
 	if si, ok := s.messageBroker.(messaging.StreamInitializer); ok {
-		if err := si.InitStreams(MessageBrokerStreamSubjects()); err != nil {
+		subjects := subject.InNamespace(s.subjectPrefix, MessageBrokerStreamSubjects())
+		if err := si.InitStreams(subjects); err != nil {
 			return fmt.Errorf("initializing message broker streams: %w", err)
 		}
 	}
 
+	s.AddReadinessCheck("broker", func(ctx context.Context) error {
+		return messaging.Ping(ctx, s.messageBroker)
+	})
+
 	setupHandlers(s)
 	if assetsFS != nil {
 		h := http.StripPrefix(assets.URLPrefix, http.FileServer(assetsFS))
@@ -308,10 +402,11 @@ func setupHandlers(s *Server) {
 }
 
 func (s *Server) httpErrIntern(
-	w http.ResponseWriter, _ *http.Request,
+	w http.ResponseWriter, r *http.Request,
 	_ *datastar.ServerSentEventGenerator, msg string, err error,
 ) {
-	s.LogErr(msg, err)
+	s.LogErrCtx(r.Context(), msg, err)
+	tracing.FailRequest(r, err)
 	switch {
 	case errors.Is(err, datapages.ErrBadRequest):
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
@@ -326,7 +421,19 @@ func (s *Server) httpErrIntern(
 	}
 }
 
+func (s *Server) recoverDeferred(
+	w io.Writer, r *http.Request, id string, err error,
+) {
+	err = datapages.DeferredError{Selector: "#" + id, Err: err}
+	s.LogErrCtx(r.Context(), "resolving deferred component", err)
+}
+
 func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.GET")
+	defer prom.HandlerDuration("PageIndex", "GET", time.Now())
+
 	if r.URL.Path != "/" {
 		http.NotFound(w, r)
 		return
@@ -353,12 +460,13 @@ func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, nil, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageIndex", err)
+		s.LogErrCtx(r.Context(), "rendering PageIndex", err)
 		return
 	}
 }
 
 func (s *Server) handlePageIndexGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageIndex.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -366,25 +474,36 @@ func (s *Server) handlePageIndexGETStream(w http.ResponseWriter, r *http.Request
 	p := app.PageIndex{
 		App: s.app,
 	}
-	s.handleStreamRequest(w, r, evSubjPageIndex,
+	s.handleStreamRequest(w, r, "PageIndex", evSubjPageIndex,
 		nil,
 		nil,
 		func(
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventAnnounced app.EventAnnounced
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch msg.Subject {
 				case EvSubjAnnounced:
-					eventAnnounced = app.EventAnnounced{}
-					if err := json.Unmarshal(msg.Data, &eventAnnounced); err != nil {
-						s.LogErr("unmarshaling EventAnnounced JSON", err)
+					eventAnnounced, err := eventcache.Decode[app.EventAnnounced](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventAnnounced", err)
 						continue
 					}
-					if err := p.OnAnnounced(eventAnnounced, dpsse.New(sse)); err != nil {
-						s.LogErr("handling PageIndex.OnAnnounced", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageIndex.OnAnnounced", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageIndex.OnAnnounced", msg)
+						defer span.End()
+						start := time.Now()
+						if err := p.OnAnnounced(eventAnnounced, dpsse.NewContext(ctx, sse)); err != nil {
+							tracing.Fail(span, err)
+							prom.EventHandlerError("PageIndex", "OnAnnounced", "EventAnnounced")
+							s.LogErrCtx(ctx, "handling PageIndex.OnAnnounced", err)
+						}
+						prom.EventHandlerDuration("PageIndex", "OnAnnounced", "EventAnnounced", start)
+					}()
 				}
 			}
 		})
@@ -393,6 +512,11 @@ func (s *Server) handlePageIndexGETStream(w http.ResponseWriter, r *http.Request
 func (s *Server) handlePageIndexPOSTAnnounce(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.POSTAnnounce")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.POSTAnnounce")
+	defer prom.HandlerDuration("PageIndex", "POSTAnnounce", time.Now())
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -419,6 +543,11 @@ func (s *Server) handlePageIndexPOSTAnnounce(
 func (s *Server) handlePageIndexPOSTFail(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.POSTFail")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.POSTFail")
+	defer prom.HandlerDuration("PageIndex", "POSTFail", time.Now())
+
 	p := app.PageIndex{
 		App: s.app,
 	}
@@ -441,13 +570,61 @@ func (d dispatcherEventAnnounced) Dispatch(e app.EventAnnounced) error {
 func (d dispatcherEventAnnounced) DispatchCtx(
 	ctx context.Context, e app.EventAnnounced,
 ) error {
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventAnnounced JSON: %w", err)
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventAnnounced) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventAnnounced,
+) error {
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventAnnounced) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventAnnounced,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventAnnounced: %w", messaging.ErrNoScheduler)
 	}
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, EvSubjAnnounced, j)
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventAnnounced) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventAnnounced,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventAnnounced) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventAnnounced,
+) error {
+	defer prom.DispatchDuration("EventAnnounced", time.Now())
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventAnnouncedJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventAnnounced: %w", err)
+	}
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at,
+		d.s.subjectPrefix+EvSubjAnnounced, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", EvSubjAnnounced, err)
 	}
 	return nil
 }
+
+// appendEventAnnouncedJSON appends e encoded the way encoding/json encodes it.
+func appendEventAnnouncedJSON(b []byte, e app.EventAnnounced) []byte {
+	start := len(b)
+	b = append(b, `,"text":`...)
+	b = jsonenc.AppendString(b, string(e.Text))
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
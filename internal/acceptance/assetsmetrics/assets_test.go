@@ -4,11 +4,13 @@ package acceptance_test
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"path"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -260,6 +262,29 @@ func TestMetrics(t *testing.T) {
 	if _, ok := byName["datapages_http_request_duration_seconds"]; !ok {
 		t.Error("the request duration histogram was not registered")
 	}
+
+	handlers, ok := byName["datapages_handler_duration_seconds"]
+	if !ok {
+		t.Fatal("the handler duration histogram was not registered")
+	}
+	observed := map[string]uint64{}
+	for _, m := range handlers.GetMetric() {
+		var page, handler string
+		for _, l := range m.GetLabel() {
+			switch l.GetName() {
+			case "page":
+				page = l.GetValue()
+			case "handler":
+				handler = l.GetValue()
+			}
+		}
+		observed[page+"."+handler] += m.GetHistogram().GetSampleCount()
+	}
+	for _, h := range []string{"PageIndex.GET", "PageIndex.POSTFail"} {
+		if observed[h] == 0 {
+			t.Errorf("the handler duration of %s was not observed", h)
+		}
+	}
 }
 
 // TestBrokerMetrics covers the counters the generated code hands the message broker.
@@ -351,3 +376,92 @@ func TestMetricsWithoutOption(t *testing.T) {
 		t.Fatalf("error is %v, want missing option WithPrometheus", err)
 	}
 }
+
+// downBroker is a broker whose connection is down.
+type downBroker struct {
+	messaging.Broker
+	messaging.ConnStatus
+}
+
+// TestProbes covers the liveness and readiness probes of the metrics server.
+// Readiness follows the message broker and the checks the application
+// registers, and fails for good once the shutdown begins.
+func TestProbes(t *testing.T) {
+	broker := &downBroker{Broker: inmem.New(messaging.DefaultBrokerChanBuffer)}
+	var dbDown atomic.Bool
+	var metrics http.Handler
+	s := mustNewServer(
+		t, &app.App{}, broker,
+		datapages.WithAssets(app.StaticFS),
+		datapages.WithPrometheus(datapages.PrometheusConfig{
+			Host:       "127.0.0.1:0",
+			Registerer: registry,
+			Gatherer:   registry,
+			Pprof:      true,
+			ReadinessChecks: []datapages.ReadinessCheck{{
+				Name: "database",
+				Check: func(context.Context) error {
+					if dbDown.Load() {
+						return errors.New("connection refused")
+					}
+					return nil
+				},
+			}},
+		}),
+		func(c *datapages.ServerConfig) error {
+			metrics = c.MetricsServer.Handler
+			return nil
+		},
+	)
+
+	probe := func(path string) (int, string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		metrics.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code, w.Body.String()
+	}
+
+	code, body := probe("/readyz")
+	if code != http.StatusOK {
+		t.Fatalf("readiness: status %d, body %q", code, body)
+	}
+	for _, line := range []string{"broker: ok", "database: ok"} {
+		if !strings.Contains(body, line) {
+			t.Errorf("readiness body %q lacks %q", body, line)
+		}
+	}
+
+	broker.Set(false)
+	if code, body = probe("/readyz"); code != http.StatusServiceUnavailable ||
+		!strings.Contains(body, "broker: "+messaging.ErrBrokerUnavailable.Error()) {
+		t.Errorf("readiness with the broker down: status %d, body %q", code, body)
+	}
+	broker.Set(true)
+
+	dbDown.Store(true)
+	if code, body = probe("/readyz"); code != http.StatusServiceUnavailable ||
+		!strings.Contains(body, "database: connection refused") {
+		t.Errorf("readiness with the database down: status %d, body %q", code, body)
+	}
+	dbDown.Store(false)
+
+	if code, _ = probe("/debug/pprof/"); code != http.StatusOK {
+		t.Errorf("pprof index: status %d", code)
+	}
+	// The profiles stay off the application's own listeners.
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	if w.Code == http.StatusOK {
+		t.Errorf("the application server serves the profiles")
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutting down: %v", err)
+	}
+	if code, _ = probe("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("readiness after the shutdown began: status %d", code)
+	}
+	if code, _ = probe("/healthz"); code != http.StatusOK {
+		t.Errorf("liveness after the shutdown began: status %d", code)
+	}
+}
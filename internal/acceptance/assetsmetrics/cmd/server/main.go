@@ -89,18 +89,15 @@ func loadEnvFile(path string) {
 	}
 }
 
+// withAccessLogger logs a line per request in JSON, carrying its request ID.
+// Handlers log with the same attributes through datapages.Logger(ctx).
 func withAccessLogger(opts *[]datapages.ServerOption) {
 	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
 	}))
-	*opts = append(*opts, datapages.WithMiddleware(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			logger.Info("access",
-				slog.String("method", r.Method),
-				slog.String("path", r.URL.Path))
-			next.ServeHTTP(w, r)
-		})
-	}))
+	*opts = append(*opts,
+		datapages.WithLogger(logger),
+		datapages.WithAccessLog(datapages.AccessLogConfig{}))
 }
 
 func connectNATS() *natscore.MessageBroker {
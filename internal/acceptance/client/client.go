@@ -159,6 +159,7 @@ func (c *Client) Do(t *testing.T, req *http.Request) Response {
 type Stream struct {
 	t      *testing.T
 	cancel context.CancelFunc
+	done   chan struct{} // Closed when the read ends.
 
 	mu     sync.Mutex
 	lines  []string
@@ -203,8 +204,9 @@ func (c *Client) openStream(
 		require.Equal(t, http.StatusOK, resp.StatusCode, "opening stream %s", path)
 	}
 
-	s := &Stream{t: t, cancel: cancel}
+	s := &Stream{t: t, cancel: cancel, done: make(chan struct{})}
 	go func() {
+		defer close(s.done)
 		defer func() { _ = resp.Body.Close() }()
 		sc := bufio.NewScanner(resp.Body)
 		// A patch of a whole page is one SSE event and outgrows the default 64KiB token.
@@ -257,6 +259,16 @@ func (s *Stream) Never(sub string) bool {
 	return !s.has(sub)
 }
 
+// Ended reports whether the server ended the stream within a short window.
+func (s *Stream) Ended() bool {
+	select {
+	case <-s.done:
+		return true
+	case <-time.After(Await):
+		return false
+	}
+}
+
 // Lines is everything the stream has carried so far.
 func (s *Stream) Lines() []string {
 	s.mu.Lock()
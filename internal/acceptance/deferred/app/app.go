@@ -0,0 +1,93 @@
+// Package app exercises datapages.Deferred: the parts of a page that are
+// slow to load follow the rest of it on the same response, and a part that
+// fails reaches RecoverError with the selector of its placeholder.
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/a-h/templ"
+
+	"github.com/romshark/datapages"
+)
+
+type App struct {
+	// Release, when not nil, holds the slow part of the page back until
+	// it's closed, which lets a test read the page before it.
+	// Nil resolves it right away.
+	Release chan struct{}
+}
+
+var (
+	errFailed        = errors.New("failed")
+	errUnrecoverable = errors.New("unrecoverable")
+)
+
+// RecoverError replaces the placeholder of a part that failed with a notice.
+func (a *App) RecoverError(err error, sse datapages.SSE) error {
+	var d datapages.DeferredError
+	if !errors.As(err, &d) {
+		return sse.PatchElement(templ.Raw(`<div id="toast">` + err.Error() + `</div>`))
+	}
+	if errors.Is(err, errUnrecoverable) {
+		return errors.New("cannot render a notice for this")
+	}
+	kind := "failed"
+	if errors.Is(err, context.DeadlineExceeded) {
+		kind = "timed out"
+	}
+	return sse.PatchElementAt(
+		templ.Raw(`<p class="notice">`+kind+`</p>`), d.Selector, datapages.PatchModeInner,
+	)
+}
+
+func resolved(html string, err error) func(context.Context) (datapages.Component, error) {
+	return func(context.Context) (datapages.Component, error) {
+		if err != nil {
+			return nil, err
+		}
+		return templ.Raw(html), nil
+	}
+}
+
+// PageIndex is /
+type PageIndex struct{ App *App }
+
+func (p PageIndex) GET(_ *http.Request) (body datapages.Component, err error) {
+	return templ.Join(
+		templ.Raw(`<main id="main">index</main>`),
+		datapages.Deferred(templ.Raw("loading fast"), resolved(`<p id="fast">fast</p>`, nil)),
+		datapages.Deferred(templ.Raw("loading failing"), resolved("", errFailed)),
+		datapages.Deferred(templ.Raw("loading slow"),
+			func(ctx context.Context) (datapages.Component, error) {
+				if p.App.Release == nil {
+					return templ.Raw(`<p id="slow">slow</p>`), nil
+				}
+				select {
+				case <-p.App.Release:
+					return templ.Raw(`<p id="slow">slow</p>`), nil
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}),
+		datapages.Deferred(templ.Raw("loading lost"), resolved("", errUnrecoverable)),
+	), nil
+}
+
+// PageNested is /nested
+type PageNested struct{ App *App }
+
+func (PageNested) GET(_ *http.Request) (body datapages.Component, err error) {
+	return datapages.Deferred(templ.Raw("loading outer"),
+		func(context.Context) (datapages.Component, error) {
+			return templ.Join(
+				templ.Raw(`<p id="outer">outer</p>`),
+				datapages.Deferred(templ.Raw("loading inner"),
+					resolved(`<p id="inner">inner</p>`, nil)),
+				datapages.Deferred(templ.Raw("loading inner failing"),
+					resolved("", errFailed)),
+			), nil
+		}), nil
+}
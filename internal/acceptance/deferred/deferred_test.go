@@ -0,0 +1,114 @@
+// Drives the deferred components of ./app: the page arrives with their
+// placeholders first and each component follows it as it's resolved.
+
+package acceptance_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages"
+	"github.com/romshark/datapages/internal/acceptance/client"
+	"github.com/romshark/datapages/internal/acceptance/deferred/app"
+	"github.com/romshark/datapages/modules/messaging"
+	"github.com/romshark/datapages/modules/messaging/inmem"
+)
+
+func newClient(t *testing.T, a *app.App, opts ...datapages.ServerOption) *client.Client {
+	t.Helper()
+	return client.New(t, mustNewServer(t, a, inmem.New(messaging.DefaultBrokerChanBuffer), opts...))
+}
+
+// TestDeferred covers the placeholders rendered in the page, the components
+// following the page and the failures handed to RecoverError.
+func TestDeferred(t *testing.T) {
+	c := newClient(t, &app.App{})
+	resp := c.Get(t, "/")
+	require.Equal(t, http.StatusOK, resp.Status)
+	body := resp.Body
+
+	main := strings.Index(body, `<main id="main">index</main>`+
+		`<div id="datapages-deferred-1" style="display:contents">loading fast</div>`+
+		`<div id="datapages-deferred-2" style="display:contents">loading failing</div>`+
+		`<div id="datapages-deferred-3" style="display:contents">loading slow</div>`+
+		`<div id="datapages-deferred-4" style="display:contents">loading lost</div>`)
+	require.GreaterOrEqual(t, main, 0, "the page renders the placeholders:\n%s", body)
+
+	fast := strings.Index(body, `<template><p id="fast">fast</p></template><script>`)
+	require.Greater(t, fast, main, "the component follows the page")
+	require.Contains(t, body, `getElementById("datapages-deferred-1")?.replaceWith(t.content)`)
+	require.Contains(t, body, `<template><p id="slow">slow</p></template>`)
+
+	require.Contains(t, body, `<template><p class="notice">failed</p></template>`+
+		`<script>{const m="inner",q="#datapages-deferred-2",v=false;`,
+		"RecoverError patches the placeholder of a failed component")
+	require.NotContains(t, body, "unrecoverable",
+		"a failure RecoverError can't handle leaves the placeholder")
+	require.True(t, strings.HasSuffix(body, "</body></html>"))
+}
+
+// TestDeferredTimeout covers a component that takes longer than
+// the timeout, which fails with context.DeadlineExceeded.
+func TestDeferredTimeout(t *testing.T) {
+	c := newClient(t, &app.App{Release: make(chan struct{})},
+		datapages.WithDeferredTimeout(50*time.Millisecond))
+	body := c.Get(t, "/").Body
+	require.Contains(t, body, `<template><p class="notice">timed out</p></template>`+
+		`<script>{const m="inner",q="#datapages-deferred-3",v=false;`)
+	require.NotContains(t, body, `<p id="slow">`)
+}
+
+// TestDeferredStreamed covers the page arriving before its slowest
+// component resolved.
+func TestDeferredStreamed(t *testing.T) {
+	a := &app.App{Release: make(chan struct{})}
+	c := newClient(t, a)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, c.URL()+"/", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	// Everything up to the fast component arrives while the slow one waits.
+	r := bufio.NewReader(resp.Body)
+	var got strings.Builder
+	for !strings.Contains(got.String(), `<template><p id="fast">`) {
+		b, err := r.ReadByte()
+		require.NoError(t, err)
+		got.WriteByte(b)
+	}
+	require.Contains(t, got.String(), "loading slow")
+	require.NotContains(t, got.String(), `<p id="slow">`)
+
+	close(a.Release)
+	rest, err := r.ReadString(0)
+	require.ErrorContains(t, err, "EOF")
+	require.Contains(t, rest, `<template><p id="slow">slow</p></template>`)
+}
+
+// TestDeferredNested covers a deferred component inside a deferred component,
+// which is resolved with it.
+func TestDeferredNested(t *testing.T) {
+	body := newClient(t, &app.App{}).Get(t, "/nested").Body
+	require.Contains(t, body, `<template><p id="outer">outer</p><p id="inner">inner</p>`)
+	require.NotContains(t, body, "loading inner<")
+}
+
+// TestDeferredNestedError covers a deferred component inside a deferred
+// component that fails, which leaves its placeholder for RecoverError
+// to patch instead of failing the one it's in.
+func TestDeferredNestedError(t *testing.T) {
+	body := newClient(t, &app.App{}).Get(t, "/nested").Body
+	require.Contains(t, body, `<p id="inner">inner</p>`+
+		`<div id="datapages-deferred-2" style="display:contents">loading inner failing</div>`+
+		`<template><p class="notice">failed</p></template>`+
+		`<script>{const m="inner",q="#datapages-deferred-2",v=false;`)
+}
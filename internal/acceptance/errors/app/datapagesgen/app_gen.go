@@ -3,6 +3,7 @@
 package datapagesgen
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -12,7 +13,11 @@ import (
 	"github.com/romshark/datapages"
 	"github.com/romshark/datapages/modules/messaging"
 	"github.com/romshark/datapages/modules/sessions"
+	"github.com/romshark/datapages/runtime/deferred"
 	"github.com/romshark/datapages/runtime/httpserve"
+	"github.com/romshark/datapages/runtime/reqlog"
+	"github.com/romshark/datapages/runtime/subject"
+	"github.com/romshark/datapages/runtime/tracing"
 
 	"github.com/romshark/datapages/internal/acceptance/errors/app"
 	"github.com/romshark/datapages/internal/acceptance/errors/app/datapagesgen/href"
@@ -67,8 +72,13 @@ func (s *Server) writeHTML(
 	if err != nil {
 		return err
 	}
+	// The deferred components of the body follow it on the same response,
+	// the ones of the head are resolved as it's rendered.
+	ctx, deferreds := deferred.Start(r.Context(), s.DeferredTimeout(),
+		func(w io.Writer, id string, err error) { s.recoverDeferred(w, r, id, err) })
+	headCtx := deferred.InPlace(ctx)
 	if head != nil {
-		if err := head.Render(r.Context(), w); err != nil {
+		if err := head.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
@@ -82,7 +92,7 @@ func (s *Server) writeHTML(
 		return err
 	}
 	if body != nil {
-		if err := body.Render(r.Context(), w); err != nil {
+		if err := body.Render(ctx, w); err != nil {
 			return err
 		}
 	}
@@ -95,6 +105,9 @@ func (s *Server) writeHTML(
 			return err
 		}
 	}
+	if deferreds.Len() > 0 {
+		deferreds.Resolve(r.Context(), w)
+	}
 	_, err = io.WriteString(w, "</body></html>")
 	return err
 }
@@ -104,7 +117,12 @@ type Server struct {
 	messageBroker        messaging.Broker
 	messageBrokerMetrics brokerMetrics
 	streamSeq            atomic.Uint64
-	app                  *app.App
+	// subjectPrefix is what every subject starts with on the wire,
+	// empty without datapages.WithSubjectPrefix.
+	subjectPrefix string
+	// tracer is nil without datapages.WithTracerProvider.
+	tracer tracing.Tracer
+	app    *app.App
 }
 
 // Init wires the server. It is called by datapages.NewServer,
@@ -119,6 +137,9 @@ type Server struct {
 //   - datapages.WithHTTPServer
 //   - datapages.WithDatastarJS
 //   - datapages.WithAssets
+//   - datapages.WithSubjectPrefix
+//   - datapages.WithBrokerInterceptors
+//   - datapages.WithTracerProvider
 func (s *Server) Init(
 	cfg datapages.ServerConfig,
 	app *app.App,
@@ -134,6 +155,10 @@ func (s *Server) Init(
 		return errors.New("unexpected option WithPrometheus: " +
 			"the server is generated with datapages.DisablePrometheus")
 	}
+	if cfg.MeterProvider != nil {
+		return errors.New("unexpected option WithMeterProvider: " +
+			"the server is generated with datapages.DisablePrometheus")
+	}
 
 	assetsFS, err := assetsFileSystem(cfg)
 	if err != nil {
@@ -144,14 +169,23 @@ func (s *Server) Init(
 	s.Core = httpserve.NewCore(cfg, "")
 	s.app = app
 	s.messageBroker = messageBroker
+	s.tracer = tracing.NewTracer(cfg.TracerProvider)
+	if cfg.SubjectPrefix != "" {
+		s.subjectPrefix = subject.Prefix(cfg.SubjectPrefix)
+	}
 	s.messageBrokerMetrics = brokerMetrics{}
 
 	if si, ok := s.messageBroker.(messaging.StreamInitializer); ok {
-		if err := si.InitStreams(MessageBrokerStreamSubjects()); err != nil {
+		subjects := subject.InNamespace(s.subjectPrefix, MessageBrokerStreamSubjects())
+		if err := si.InitStreams(subjects); err != nil {
 			return fmt.Errorf("initializing message broker streams: %w", err)
 		}
 	}
 
+	s.AddReadinessCheck("broker", func(ctx context.Context) error {
+		return messaging.Ping(ctx, s.messageBroker)
+	})
+
 	setupHandlers(s)
 
 	s.Build()
@@ -226,7 +260,8 @@ func (s *Server) httpErrIntern(
 	w http.ResponseWriter, r *http.Request,
 	sse *datastar.ServerSentEventGenerator, msg string, err error,
 ) {
-	s.LogErr(msg, err)
+	s.LogErrCtx(r.Context(), msg, err)
+	tracing.FailRequest(r, err)
 	if !httpserve.IsDatastarRequest(r) {
 		// A page load gets the app's own 500 page, with the status that
 		// says what happened. The page's own route serves 200;
@@ -249,6 +284,13 @@ func (s *Server) httpErrIntern(
 	}
 }
 
+func (s *Server) recoverDeferred(
+	w io.Writer, r *http.Request, id string, err error,
+) {
+	err = datapages.DeferredError{Selector: "#" + id, Err: err}
+	s.LogErrCtx(r.Context(), "resolving deferred component", err)
+}
+
 func (s *Server) render404(w http.ResponseWriter, r *http.Request) {
 	// The URL is claimed by no page. Whatever the app renders for it,
 	// the response says so: a cache that stores it and a crawler that
@@ -270,12 +312,16 @@ func (s *Server) render404(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageError404", err)
+		s.LogErrCtx(r.Context(), "rendering PageError404", err)
 		return
 	}
 }
 
 func (s *Server) handlePageBoomGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageBoom.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageBoom.GET")
+
 	p := app.PageBoom{
 		App: s.app,
 	}
@@ -292,7 +338,7 @@ func (s *Server) handlePageBoomGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageBoom", err)
+		s.LogErrCtx(r.Context(), "rendering PageBoom", err)
 		return
 	}
 }
@@ -300,6 +346,10 @@ func (s *Server) handlePageBoomGET(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handlePageBoomPOSTPlain(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageBoom.POSTPlain")
+	defer span.End()
+	reqlog.SetHandler(r, "PageBoom.POSTPlain")
+
 	p := app.PageBoom{
 		App: s.app,
 	}
@@ -313,6 +363,10 @@ func (s *Server) handlePageBoomPOSTPlain(
 func (s *Server) handlePageBoomPOSTBad(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageBoom.POSTBad")
+	defer span.End()
+	reqlog.SetHandler(r, "PageBoom.POSTBad")
+
 	p := app.PageBoom{
 		App: s.app,
 	}
@@ -326,6 +380,10 @@ func (s *Server) handlePageBoomPOSTBad(
 func (s *Server) handlePageBoomPOSTForbidden(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageBoom.POSTForbidden")
+	defer span.End()
+	reqlog.SetHandler(r, "PageBoom.POSTForbidden")
+
 	p := app.PageBoom{
 		App: s.app,
 	}
@@ -339,6 +397,10 @@ func (s *Server) handlePageBoomPOSTForbidden(
 func (s *Server) handlePageBoomPOSTNotFound(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageBoom.POSTNotFound")
+	defer span.End()
+	reqlog.SetHandler(r, "PageBoom.POSTNotFound")
+
 	p := app.PageBoom{
 		App: s.app,
 	}
@@ -352,6 +414,10 @@ func (s *Server) handlePageBoomPOSTNotFound(
 func (s *Server) handlePageBoomPOSTConflict(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageBoom.POSTConflict")
+	defer span.End()
+	reqlog.SetHandler(r, "PageBoom.POSTConflict")
+
 	p := app.PageBoom{
 		App: s.app,
 	}
@@ -365,6 +431,10 @@ func (s *Server) handlePageBoomPOSTConflict(
 func (s *Server) handlePageBoomPOSTWrapped(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageBoom.POSTWrapped")
+	defer span.End()
+	reqlog.SetHandler(r, "PageBoom.POSTWrapped")
+
 	p := app.PageBoom{
 		App: s.app,
 	}
@@ -376,6 +446,10 @@ func (s *Server) handlePageBoomPOSTWrapped(
 }
 
 func (s *Server) handlePageError404GET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageError404.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageError404.GET")
+
 	p := app.PageError404{
 		App: s.app,
 	}
@@ -392,12 +466,16 @@ func (s *Server) handlePageError404GET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageError404", err)
+		s.LogErrCtx(r.Context(), "rendering PageError404", err)
 		return
 	}
 }
 
 func (s *Server) handlePageError500GET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageError500.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageError500.GET")
+
 	p := app.PageError500{
 		App: s.app,
 	}
@@ -414,12 +492,16 @@ func (s *Server) handlePageError500GET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageError500", err)
+		s.LogErrCtx(r.Context(), "rendering PageError500", err)
 		return
 	}
 }
 
 func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.GET")
+
 	if r.URL.Path != "/" {
 		s.render404(w, r)
 		return
@@ -441,7 +523,7 @@ func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageIndex", err)
+		s.LogErrCtx(r.Context(), "rendering PageIndex", err)
 		return
 	}
 }
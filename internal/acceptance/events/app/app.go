@@ -12,13 +12,20 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/a-h/templ"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/romshark/datapages"
+	"github.com/romshark/datapages/modules/messaging"
 )
 
 type App struct {
+	// Tx is what POSTDeferredTo dispatches to, a stand-in for
+	// a publisher writing into a transaction.
+	Tx messaging.Publisher
+
 	mu  sync.Mutex
 	log []string
 }
@@ -143,6 +150,8 @@ func (p PageIndex) OnTick(
 }
 
 func (p PageIndex) OnNote(note EventNote, sse datapages.SSE) error {
+	// The span of the handler, which what it dispatches would link to.
+	p.App.record("note in span %s", trace.SpanContextFromContext(sse.Context()).SpanID())
 	return sse.PatchElement(templ.Raw(
 		fmt.Sprintf(`<div id="note">note=%s</div>`, note.Text),
 	))
@@ -204,6 +213,69 @@ func (p PageIndex) POSTCanceled(
 	return tick.DispatchCtx(ctx, EventTick{N: signals.Values.N})
 }
 
+// errDeferredFailed is what POSTDeferred returns when asked to fail.
+var errDeferredFailed = errors.New("deferred action failed")
+
+// POSTDeferred is /deferred
+//
+// Dispatches two events and holds both back until it has returned.
+// The outcome signal picks how it returns: "commit" lets the events through,
+// "rollback" backs out without an error and "fail" returns one.
+func (p PageIndex) POSTDeferred(
+	_ *http.Request,
+	signals datapages.Signals[struct {
+		N       int    `json:"n"`
+		Outcome string `json:"outcome"`
+	}],
+	tick datapages.Dispatcher[EventTick],
+	pong datapages.Dispatcher[EventPong],
+) (deferDispatch datapages.DeferDispatch, err error) {
+	n := signals.Values.N
+	if err := tick.Dispatch(EventTick{N: n}); err != nil {
+		return false, err
+	}
+	if err := pong.Dispatch(EventPong{N: n}); err != nil {
+		return false, err
+	}
+	switch signals.Values.Outcome {
+	case "rollback":
+		return false, nil
+	case "fail":
+		return true, errDeferredFailed
+	}
+	return true, nil
+}
+
+// POSTDeferredTo is /deferred-to
+//
+// Dispatches to App.Tx and backs out. DeferDispatch doesn't hold back
+// an event dispatched to a publisher of the handler.
+func (p PageIndex) POSTDeferredTo(
+	r *http.Request,
+	signals datapages.Signals[struct {
+		N int `json:"n"`
+	}],
+	tick datapages.Dispatcher[EventTick],
+) (deferDispatch datapages.DeferDispatch, err error) {
+	err = datapages.DispatchTo(r.Context(), p.App.Tx, tick, EventTick{N: signals.Values.N})
+	return false, err
+}
+
+// POSTLater is /later
+//
+// Schedules a tick for delay_ms from now.
+func (p PageIndex) POSTLater(
+	r *http.Request,
+	signals datapages.Signals[struct {
+		N       int `json:"n"`
+		DelayMS int `json:"delay_ms"`
+	}],
+	tick datapages.Dispatcher[EventTick],
+) error {
+	delay := time.Duration(signals.Values.DelayMS) * time.Millisecond
+	return tick.DispatchAfter(r.Context(), delay, EventTick{N: signals.Values.N})
+}
+
 // PageLog is /log
 type PageLog struct{ App *App }
 
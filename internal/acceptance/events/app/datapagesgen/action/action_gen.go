@@ -359,6 +359,54 @@ func POSTPageIndexCanceled(options ...option) string {
 	return b.String()
 }
 
+// POSTPageIndexDeferred references /deferred/
+func POSTPageIndexDeferred(options ...option) string {
+	if len(options) == 0 {
+		return "@post('/deferred/')"
+	}
+	var b strings.Builder
+	bl, al := beforeAfterLen(options)
+	b.Grow(bl + len("@post('/deferred/'") + optionsLen(options) + len(")") + al)
+	writeBefore(&b, options)
+	b.WriteString("@post('/deferred/'")
+	writeOptions(&b, options)
+	b.WriteByte(')')
+	writeAfter(&b, options)
+	return b.String()
+}
+
+// POSTPageIndexDeferredTo references /deferred-to/
+func POSTPageIndexDeferredTo(options ...option) string {
+	if len(options) == 0 {
+		return "@post('/deferred-to/')"
+	}
+	var b strings.Builder
+	bl, al := beforeAfterLen(options)
+	b.Grow(bl + len("@post('/deferred-to/'") + optionsLen(options) + len(")") + al)
+	writeBefore(&b, options)
+	b.WriteString("@post('/deferred-to/'")
+	writeOptions(&b, options)
+	b.WriteByte(')')
+	writeAfter(&b, options)
+	return b.String()
+}
+
+// POSTPageIndexLater references /later/
+func POSTPageIndexLater(options ...option) string {
+	if len(options) == 0 {
+		return "@post('/later/')"
+	}
+	var b strings.Builder
+	bl, al := beforeAfterLen(options)
+	b.Grow(bl + len("@post('/later/'") + optionsLen(options) + len(")") + al)
+	writeBefore(&b, options)
+	b.WriteString("@post('/later/'")
+	writeOptions(&b, options)
+	b.WriteByte(')')
+	writeAfter(&b, options)
+	return b.String()
+}
+
 // POSTPageIndexNote references /note/
 func POSTPageIndexNote(options ...option) string {
 	if len(options) == 0 {
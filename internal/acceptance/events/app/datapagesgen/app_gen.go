@@ -4,7 +4,6 @@ package datapagesgen
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -12,13 +11,21 @@ import (
 	"net/http"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/romshark/datapages"
+	"github.com/romshark/datapages/modules/codec"
 	"github.com/romshark/datapages/modules/messaging"
 	"github.com/romshark/datapages/modules/sessions"
+	"github.com/romshark/datapages/runtime/deferred"
+	"github.com/romshark/datapages/runtime/dispatch"
+	"github.com/romshark/datapages/runtime/eventcache"
 	"github.com/romshark/datapages/runtime/httpserve"
+	"github.com/romshark/datapages/runtime/jsonenc"
+	"github.com/romshark/datapages/runtime/reqlog"
 	dpsse "github.com/romshark/datapages/runtime/sse"
 	"github.com/romshark/datapages/runtime/subject"
+	"github.com/romshark/datapages/runtime/tracing"
 
 	"github.com/romshark/datapages/internal/acceptance/events/app"
 	"github.com/romshark/datapages/internal/acceptance/events/app/datapagesgen/href"
@@ -89,8 +96,13 @@ func (s *Server) writeHTML(
 	if err != nil {
 		return err
 	}
+	// The deferred components of the body follow it on the same response,
+	// the ones of the head are resolved as it's rendered.
+	ctx, deferreds := deferred.Start(r.Context(), s.DeferredTimeout(),
+		func(w io.Writer, id string, err error) { s.recoverDeferred(w, r, id, err) })
+	headCtx := deferred.InPlace(ctx)
 	if head != nil {
-		if err := head.Render(r.Context(), w); err != nil {
+		if err := head.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
@@ -104,7 +116,7 @@ func (s *Server) writeHTML(
 		return err
 	}
 	if body != nil {
-		if err := body.Render(r.Context(), w); err != nil {
+		if err := body.Render(ctx, w); err != nil {
 			return err
 		}
 	}
@@ -117,6 +129,9 @@ func (s *Server) writeHTML(
 			return err
 		}
 	}
+	if deferreds.Len() > 0 {
+		deferreds.Resolve(r.Context(), w)
+	}
 	_, err = io.WriteString(w, "</body></html>")
 	return err
 }
@@ -138,21 +153,41 @@ func (s *Server) handleStreamRequest(
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
+	// A server shutting down opens no streams, the client reconnects
+	// to another instance.
+	if s.Draining() {
+		http.Error(w,
+			http.StatusText(http.StatusServiceUnavailable),
+			http.StatusServiceUnavailable)
+		return
+	}
 
 	streamID := datapages.StreamID(s.streamSeq.Add(1))
+	reqlog.SetStream(r.Context(), uint64(streamID))
+
+	// A stream would wait for events that can't arrive while the broker is
+	// down. It's refused, and closed once the broker goes down later, so the
+	// client reconnects and renders what it missed when the broker is back.
+	brokerUp, brokerChanged := messaging.StatusOf(s.messageBroker)
+	if !brokerUp {
+		s.httpErrIntern(w, r, nil, "opening stream", messaging.ErrBrokerUnavailable)
+		return
+	}
 
 	// The subscription is established before the response head goes out.
 	// A client learns the stream is open by reading that head and may dispatch
 	// immediately after, which must not reach the broker before this.
 	ctx := r.Context()
-	sub, err := s.messageBroker.Subscribe(ctx, s.messageBrokerMetrics, subjects...)
+	sub, err := s.messageBroker.Subscribe(ctx, s.messageBrokerMetrics,
+		subject.InNamespace(s.subjectPrefix, subjects)...)
 	if err != nil {
 		// Nothing has been written yet, so the error can still carry a status.
 		s.httpErrIntern(w, r, nil, "subscribing to message broker", err)
 		return
 	}
 
-	sse := datastar.NewSSE(w, r, datastar.WithCompression())
+	// The heartbeats and the retry hint go out beside the events of sse.
+	sse, sseW := dpsse.NewSSE(w, r)
 
 	subC := sub.C()
 	if onOpen != nil {
@@ -163,9 +198,28 @@ func (s *Server) handleStreamRequest(
 		}
 	}
 	go func() {
-		select {
-		case <-r.Context().Done():
-		case <-s.ShutdownCh():
+		heartbeat, expired, stopTimers := s.StreamTimers()
+		defer stopTimers()
+		shutdown := s.ShutdownCh()
+		var drained <-chan time.Time
+		// A heartbeat goes out on the stream and the wait goes on, so does
+		// the drain the shutdown begins. Any other case ends the stream.
+		for {
+			select {
+			case <-heartbeat:
+				_ = dpsse.Heartbeat(sseW)
+				continue
+			case <-shutdown:
+				shutdown, drained = nil, s.DrainTimer()
+				continue
+			case <-drained:
+				_ = dpsse.Expire(sseW, s.DrainRetry())
+			case <-expired:
+				_ = dpsse.Expire(sseW, s.StreamRetry())
+			case <-r.Context().Done():
+			case <-brokerChanged:
+			}
+			break
 		}
 		sub.Close()
 		if onClose != nil {
@@ -181,7 +235,20 @@ type Server struct {
 	messageBroker        messaging.Broker
 	messageBrokerMetrics brokerMetrics
 	streamSeq            atomic.Uint64
-	app                  *app.App
+	// subjectPrefix is what every subject starts with on the wire,
+	// empty without datapages.WithSubjectPrefix.
+	subjectPrefix string
+	// tracer is nil without datapages.WithTracerProvider.
+	tracer     tracing.Tracer
+	app        *app.App
+	eventCodec codec.Codec
+	eventCache *eventcache.Cache
+	// jsonEvents is true when eventCodec is codec.JSON, which the generated
+	// encoders produce the payload of without reflection.
+	jsonEvents bool
+	// brokerInterceptors are those of datapages.WithBrokerInterceptors,
+	// which datapages.DispatchTo installs on the publisher it's given.
+	brokerInterceptors []messaging.Interceptor
 }
 
 // Init wires the server. It is called by datapages.NewServer,
@@ -196,6 +263,10 @@ type Server struct {
 //   - datapages.WithHTTPServer
 //   - datapages.WithDatastarJS
 //   - datapages.WithAssets
+//   - datapages.WithSubjectPrefix
+//   - datapages.WithBrokerInterceptors
+//   - datapages.WithTracerProvider
+//   - datapages.WithEventCodec
 func (s *Server) Init(
 	cfg datapages.ServerConfig,
 	app *app.App,
@@ -211,6 +282,10 @@ func (s *Server) Init(
 		return errors.New("unexpected option WithPrometheus: " +
 			"the server is generated with datapages.DisablePrometheus")
 	}
+	if cfg.MeterProvider != nil {
+		return errors.New("unexpected option WithMeterProvider: " +
+			"the server is generated with datapages.DisablePrometheus")
+	}
 
 	assetsFS, err := assetsFileSystem(cfg)
 	if err != nil {
@@ -221,14 +296,27 @@ func (s *Server) Init(
 	s.Core = httpserve.NewCore(cfg, "")
 	s.app = app
 	s.messageBroker = messageBroker
+	s.tracer = tracing.NewTracer(cfg.TracerProvider)
+	if cfg.SubjectPrefix != "" {
+		s.subjectPrefix = subject.Prefix(cfg.SubjectPrefix)
+	}
 	s.messageBrokerMetrics = brokerMetrics{}
+	s.eventCodec = cfg.EventCodec
+	s.eventCache = eventcache.New(eventcache.DefaultSize)
+	_, s.jsonEvents = cfg.EventCodec.(codec.JSON)
+	s.brokerInterceptors = cfg.BrokerInterceptors
 
 	if si, ok := s.messageBroker.(messaging.StreamInitializer); ok {
-		if err := si.InitStreams(MessageBrokerStreamSubjects()); err != nil {
+		subjects := subject.InNamespace(s.subjectPrefix, MessageBrokerStreamSubjects())
+		if err := si.InitStreams(subjects); err != nil {
 			return fmt.Errorf("initializing message broker streams: %w", err)
 		}
 	}
 
+	s.AddReadinessCheck("broker", func(ctx context.Context) error {
+		return messaging.Ping(ctx, s.messageBroker)
+	})
+
 	setupHandlers(s)
 
 	s.Build()
@@ -318,6 +406,15 @@ func setupHandlers(s *Server) {
 	s.Mux().HandleFunc(
 		"POST /canceled/{$}",
 		s.handlePageIndexPOSTCanceled)
+	s.Mux().HandleFunc(
+		"POST /deferred/{$}",
+		s.handlePageIndexPOSTDeferred)
+	s.Mux().HandleFunc(
+		"POST /deferred-to/{$}",
+		s.handlePageIndexPOSTDeferredTo)
+	s.Mux().HandleFunc(
+		"POST /later/{$}",
+		s.handlePageIndexPOSTLater)
 	s.Mux().HandleFunc(
 		"POST /room/say/{$}",
 		s.handlePageRoomPOSTSay)
@@ -327,10 +424,11 @@ func setupHandlers(s *Server) {
 }
 
 func (s *Server) httpErrIntern(
-	w http.ResponseWriter, _ *http.Request,
+	w http.ResponseWriter, r *http.Request,
 	_ *datastar.ServerSentEventGenerator, msg string, err error,
 ) {
-	s.LogErr(msg, err)
+	s.LogErrCtx(r.Context(), msg, err)
+	tracing.FailRequest(r, err)
 	switch {
 	case errors.Is(err, datapages.ErrBadRequest):
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
@@ -345,7 +443,18 @@ func (s *Server) httpErrIntern(
 	}
 }
 
+func (s *Server) recoverDeferred(
+	w io.Writer, r *http.Request, id string, err error,
+) {
+	err = datapages.DeferredError{Selector: "#" + id, Err: err}
+	s.LogErrCtx(r.Context(), "resolving deferred component", err)
+}
+
 func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.GET")
+
 	if r.URL.Path != "/" {
 		http.NotFound(w, r)
 		return
@@ -372,18 +481,17 @@ func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, nil, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageIndex", err)
+		s.LogErrCtx(r.Context(), "rendering PageIndex", err)
 		return
 	}
 }
 
 func (s *Server) handlePageIndexGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageIndex.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
 
-	dispatchClosedStreamGone := dispatcherEventStreamGone{s: s, ctx: context.WithoutCancel(r.Context())}
-
 	p := app.PageIndex{
 		App: s.app,
 	}
@@ -392,59 +500,101 @@ func (s *Server) handlePageIndexGETStream(w http.ResponseWriter, r *http.Request
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator,
 		) error {
-			return p.StreamOpen(r, streamID)
+			r, span := tracing.StartRequest(s.tracer, r, "PageIndex.StreamOpen")
+			defer span.End()
+
+			err := p.StreamOpen(r, streamID)
+			if err != nil {
+				tracing.Fail(span, err)
+			}
+			return err
 		},
 		func(streamID datapages.StreamID) {
+			r, span := tracing.StartRequest(s.tracer, r, "PageIndex.StreamClose")
+			defer span.End()
+
+			dispatchClosedStreamGone := dispatcherEventStreamGone{s: s, ctx: context.WithoutCancel(r.Context())}
+
 			if err := p.StreamClose(r, streamID, dispatchClosedStreamGone); err != nil {
-				s.LogErr("handling PageIndex.StreamClose", err)
+				tracing.Fail(span, err)
+				s.LogErrCtx(r.Context(), "handling PageIndex.StreamClose", err)
 			}
 		},
 		func(
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventStreamGone app.EventStreamGone
-			var eventPong app.EventPong
-			var eventTick app.EventTick
-			var eventNote app.EventNote
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch msg.Subject {
 				case EvSubjStreamGone:
-					eventStreamGone = app.EventStreamGone{}
-					if err := json.Unmarshal(msg.Data, &eventStreamGone); err != nil {
-						s.LogErr("unmarshaling EventStreamGone JSON", err)
+					eventStreamGone, err := eventcache.Decode[app.EventStreamGone](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventStreamGone", err)
 						continue
 					}
-					if err := p.OnStreamGone(eventStreamGone, dpsse.New(sse)); err != nil {
-						s.LogErr("handling PageIndex.OnStreamGone", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageIndex.OnStreamGone", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageIndex.OnStreamGone", msg)
+						defer span.End()
+						if err := p.OnStreamGone(eventStreamGone, dpsse.NewContext(ctx, sse)); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageIndex.OnStreamGone", err)
+						}
+					}()
 				case EvSubjPong:
-					eventPong = app.EventPong{}
-					if err := json.Unmarshal(msg.Data, &eventPong); err != nil {
-						s.LogErr("unmarshaling EventPong JSON", err)
+					eventPong, err := eventcache.Decode[app.EventPong](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventPong", err)
 						continue
 					}
-					if err := p.OnPong(eventPong, dpsse.New(sse)); err != nil {
-						s.LogErr("handling PageIndex.OnPong", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageIndex.OnPong", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageIndex.OnPong", msg)
+						defer span.End()
+						if err := p.OnPong(eventPong, dpsse.NewContext(ctx, sse)); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageIndex.OnPong", err)
+						}
+					}()
 				case EvSubjTick:
-					eventTick = app.EventTick{}
-					if err := json.Unmarshal(msg.Data, &eventTick); err != nil {
-						s.LogErr("unmarshaling EventTick JSON", err)
+					eventTick, err := eventcache.Decode[app.EventTick](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventTick", err)
 						continue
 					}
-					if err := p.OnTick(eventTick, dpsse.New(sse), streamID); err != nil {
-						s.LogErr("handling PageIndex.OnTick", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageIndex.OnTick", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageIndex.OnTick", msg)
+						defer span.End()
+						if err := p.OnTick(eventTick, dpsse.NewContext(ctx, sse), streamID); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageIndex.OnTick", err)
+						}
+					}()
 				case EvSubjNote:
-					eventNote = app.EventNote{}
-					if err := json.Unmarshal(msg.Data, &eventNote); err != nil {
-						s.LogErr("unmarshaling EventNote JSON", err)
+					eventNote, err := eventcache.Decode[app.EventNote](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventNote", err)
 						continue
 					}
-					if err := p.OnNote(eventNote, dpsse.New(sse)); err != nil {
-						s.LogErr("handling PageIndex.OnNote", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageIndex.OnNote", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageIndex.OnNote", msg)
+						defer span.End()
+						if err := p.OnNote(eventNote, dpsse.NewContext(ctx, sse)); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageIndex.OnNote", err)
+						}
+					}()
 				}
 			}
 		})
@@ -453,6 +603,10 @@ func (s *Server) handlePageIndexGETStream(w http.ResponseWriter, r *http.Request
 func (s *Server) handlePageIndexPOSTNote(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.POSTNote")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.POSTNote")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -479,6 +633,10 @@ func (s *Server) handlePageIndexPOSTNote(
 func (s *Server) handlePageIndexPOSTTick(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.POSTTick")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.POSTTick")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -505,6 +663,10 @@ func (s *Server) handlePageIndexPOSTTick(
 func (s *Server) handlePageIndexPOSTBoth(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.POSTBoth")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.POSTBoth")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -533,6 +695,10 @@ func (s *Server) handlePageIndexPOSTBoth(
 func (s *Server) handlePageIndexPOSTCanceled(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.POSTCanceled")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.POSTCanceled")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -556,7 +722,131 @@ func (s *Server) handlePageIndexPOSTCanceled(
 	}
 }
 
+func (s *Server) handlePageIndexPOSTDeferred(
+	w http.ResponseWriter, r *http.Request,
+) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.POSTDeferred")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.POSTDeferred")
+
+	if !s.checkIsDSReq(w, r) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, DefaultBodySizeLimit)
+	var signals datapages.Signals[struct {
+		N       int    `json:"n"`
+		Outcome string `json:"outcome"`
+	}]
+	if err := datastar.ReadSignals(r, &signals.Values); err != nil {
+		s.httpErrBad(w, "reading signals", err)
+		return
+	}
+
+	deferred := new(dispatch.Deferred)
+
+	dispatchTick := dispatcherEventTick{s: s, ctx: r.Context(), deferred: deferred}
+
+	dispatchPong := dispatcherEventPong{s: s, ctx: r.Context(), deferred: deferred}
+	p := app.PageIndex{
+		App: s.app,
+	}
+	deferDispatch, err := p.POSTDeferred(r, signals, dispatchTick, dispatchPong)
+	if err != nil {
+		s.httpErrIntern(w, r, nil, "handling action PageIndex.Deferred", err)
+		return
+	}
+	if deferDispatch {
+		if err := deferred.Publish(
+			s.messageBroker, s.messageBrokerMetrics,
+		); err != nil {
+			s.httpErrIntern(w, r, nil, "publishing deferred events of PageIndex.Deferred", err)
+			return
+		}
+	} else if n := deferred.Drop(); n > 0 {
+		s.RequestLogger(r.Context()).DebugContext(r.Context(),
+			"dropping deferred events of PageIndex.Deferred", slog.Int("events", n))
+	}
+}
+
+func (s *Server) handlePageIndexPOSTDeferredTo(
+	w http.ResponseWriter, r *http.Request,
+) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.POSTDeferredTo")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.POSTDeferredTo")
+
+	if !s.checkIsDSReq(w, r) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, DefaultBodySizeLimit)
+	var signals datapages.Signals[struct {
+		N int `json:"n"`
+	}]
+	if err := datastar.ReadSignals(r, &signals.Values); err != nil {
+		s.httpErrBad(w, "reading signals", err)
+		return
+	}
+
+	deferred := new(dispatch.Deferred)
+
+	dispatchTick := dispatcherEventTick{s: s, ctx: r.Context(), deferred: deferred}
+	p := app.PageIndex{
+		App: s.app,
+	}
+	deferDispatch, err := p.POSTDeferredTo(r, signals, dispatchTick)
+	if err != nil {
+		s.httpErrIntern(w, r, nil, "handling action PageIndex.DeferredTo", err)
+		return
+	}
+	if deferDispatch {
+		if err := deferred.Publish(
+			s.messageBroker, s.messageBrokerMetrics,
+		); err != nil {
+			s.httpErrIntern(w, r, nil, "publishing deferred events of PageIndex.DeferredTo", err)
+			return
+		}
+	} else if n := deferred.Drop(); n > 0 {
+		s.RequestLogger(r.Context()).DebugContext(r.Context(),
+			"dropping deferred events of PageIndex.DeferredTo", slog.Int("events", n))
+	}
+}
+
+func (s *Server) handlePageIndexPOSTLater(
+	w http.ResponseWriter, r *http.Request,
+) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.POSTLater")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.POSTLater")
+
+	if !s.checkIsDSReq(w, r) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, DefaultBodySizeLimit)
+	var signals datapages.Signals[struct {
+		N       int `json:"n"`
+		DelayMS int `json:"delay_ms"`
+	}]
+	if err := datastar.ReadSignals(r, &signals.Values); err != nil {
+		s.httpErrBad(w, "reading signals", err)
+		return
+	}
+
+	dispatchTick := dispatcherEventTick{s: s, ctx: r.Context()}
+	p := app.PageIndex{
+		App: s.app,
+	}
+	err := p.POSTLater(r, signals, dispatchTick)
+	if err != nil {
+		s.httpErrIntern(w, r, nil, "handling action PageIndex.Later", err)
+		return
+	}
+}
+
 func (s *Server) handlePageLogGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageLog.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageLog.GET")
+
 	p := app.PageLog{
 		App: s.app,
 	}
@@ -573,12 +863,16 @@ func (s *Server) handlePageLogGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageLog", err)
+		s.LogErrCtx(r.Context(), "rendering PageLog", err)
 		return
 	}
 }
 
 func (s *Server) handlePageOtherGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageOther.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageOther.GET")
+
 	p := app.PageOther{
 		App: s.app,
 		Notifier: app.Notifier{
@@ -603,12 +897,13 @@ func (s *Server) handlePageOtherGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, nil, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageOther", err)
+		s.LogErrCtx(r.Context(), "rendering PageOther", err)
 		return
 	}
 }
 
 func (s *Server) handlePageOtherGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageOther.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -626,24 +921,36 @@ func (s *Server) handlePageOtherGETStream(w http.ResponseWriter, r *http.Request
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventTick app.EventTick
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch msg.Subject {
 				case EvSubjTick:
-					eventTick = app.EventTick{}
-					if err := json.Unmarshal(msg.Data, &eventTick); err != nil {
-						s.LogErr("unmarshaling EventTick JSON", err)
+					eventTick, err := eventcache.Decode[app.EventTick](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventTick", err)
 						continue
 					}
-					if err := p.OnTick(eventTick, dpsse.New(sse)); err != nil {
-						s.LogErr("handling PageOther.OnTick", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageOther.OnTick", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageOther.OnTick", msg)
+						defer span.End()
+						if err := p.OnTick(eventTick, dpsse.NewContext(ctx, sse)); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageOther.OnTick", err)
+						}
+					}()
 				}
 			}
 		})
 }
 
 func (s *Server) handlePageRoomGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageRoom.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageRoom.GET")
+
 	p := app.PageRoom{
 		App: s.app,
 	}
@@ -665,12 +972,13 @@ func (s *Server) handlePageRoomGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, nil, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageRoom", err)
+		s.LogErrCtx(r.Context(), "rendering PageRoom", err)
 		return
 	}
 }
 
 func (s *Server) handlePageRoomGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageRoom.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -698,28 +1006,43 @@ func (s *Server) handlePageRoomGETStream(w http.ResponseWriter, r *http.Request)
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventRoomSaid app.EventRoomSaid
-			var eventRoomBroadcast app.EventRoomBroadcast
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch {
 				case strings.HasPrefix(msg.Subject, EvSubjPrefRoomSaid):
-					eventRoomSaid = app.EventRoomSaid{}
-					if err := json.Unmarshal(msg.Data, &eventRoomSaid); err != nil {
-						s.LogErr("unmarshaling EventRoomSaid JSON", err)
+					eventRoomSaid, err := eventcache.Decode[app.EventRoomSaid](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventRoomSaid", err)
 						continue
 					}
-					if err := p.OnRoomSaid(eventRoomSaid, dpsse.New(sse)); err != nil {
-						s.LogErr("handling PageRoom.OnRoomSaid", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageRoom.OnRoomSaid", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageRoom.OnRoomSaid", msg)
+						defer span.End()
+						if err := p.OnRoomSaid(eventRoomSaid, dpsse.NewContext(ctx, sse)); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageRoom.OnRoomSaid", err)
+						}
+					}()
 				case strings.HasPrefix(msg.Subject, EvSubjPrefRoomBroadcast):
-					eventRoomBroadcast = app.EventRoomBroadcast{}
-					if err := json.Unmarshal(msg.Data, &eventRoomBroadcast); err != nil {
-						s.LogErr("unmarshaling EventRoomBroadcast JSON", err)
+					eventRoomBroadcast, err := eventcache.Decode[app.EventRoomBroadcast](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventRoomBroadcast", err)
 						continue
 					}
-					if err := p.OnRoomBroadcast(eventRoomBroadcast, dpsse.New(sse)); err != nil {
-						s.LogErr("handling PageRoom.OnRoomBroadcast", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageRoom.OnRoomBroadcast", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageRoom.OnRoomBroadcast", msg)
+						defer span.End()
+						if err := p.OnRoomBroadcast(eventRoomBroadcast, dpsse.NewContext(ctx, sse)); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageRoom.OnRoomBroadcast", err)
+						}
+					}()
 				}
 			}
 		})
@@ -728,6 +1051,10 @@ func (s *Server) handlePageRoomGETStream(w http.ResponseWriter, r *http.Request)
 func (s *Server) handlePageRoomPOSTSay(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageRoom.POSTSay")
+	defer span.End()
+	reqlog.SetHandler(r, "PageRoom.POSTSay")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -755,6 +1082,10 @@ func (s *Server) handlePageRoomPOSTSay(
 func (s *Server) handlePageRoomPOSTBroadcast(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageRoom.POSTBroadcast")
+	defer span.End()
+	reqlog.SetHandler(r, "PageRoom.POSTBroadcast")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -782,6 +1113,8 @@ func (s *Server) handlePageRoomPOSTBroadcast(
 type dispatcherEventStreamGone struct {
 	s   *Server
 	ctx context.Context
+	// deferred holds the events back when not nil.
+	deferred *dispatch.Deferred
 }
 
 func (d dispatcherEventStreamGone) Dispatch(e app.EventStreamGone) error {
@@ -791,20 +1124,74 @@ func (d dispatcherEventStreamGone) Dispatch(e app.EventStreamGone) error {
 func (d dispatcherEventStreamGone) DispatchCtx(
 	ctx context.Context, e app.EventStreamGone,
 ) error {
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventStreamGone JSON: %w", err)
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventStreamGone) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventStreamGone,
+) error {
+	d.deferred = nil
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventStreamGone) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventStreamGone,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventStreamGone: %w", messaging.ErrNoScheduler)
 	}
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, EvSubjStreamGone, j)
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventStreamGone) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventStreamGone,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventStreamGone) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventStreamGone,
+) error {
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventStreamGoneJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventStreamGone: %w", err)
+	}
+	if d.deferred != nil {
+		d.deferred.Add(ctx, at, d.s.subjectPrefix+EvSubjStreamGone, j)
+		return nil
+	}
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at,
+		d.s.subjectPrefix+EvSubjStreamGone, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", EvSubjStreamGone, err)
 	}
 	return nil
 }
 
+// appendEventStreamGoneJSON appends e encoded the way encoding/json encodes it.
+func appendEventStreamGoneJSON(b []byte, e app.EventStreamGone) []byte {
+	start := len(b)
+	b = append(b, `,"stream_id":`...)
+	b = jsonenc.AppendUint(b, uint64(e.StreamID))
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
+
 type dispatcherEventNote struct {
 	s   *Server
 	ctx context.Context
+	// deferred holds the events back when not nil.
+	deferred *dispatch.Deferred
 }
 
 func (d dispatcherEventNote) Dispatch(e app.EventNote) error {
@@ -814,20 +1201,76 @@ func (d dispatcherEventNote) Dispatch(e app.EventNote) error {
 func (d dispatcherEventNote) DispatchCtx(
 	ctx context.Context, e app.EventNote,
 ) error {
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventNote JSON: %w", err)
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventNote) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventNote,
+) error {
+	d.deferred = nil
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventNote) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventNote,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventNote: %w", messaging.ErrNoScheduler)
 	}
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, EvSubjNote, j)
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventNote) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventNote,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventNote) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventNote,
+) error {
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventNoteJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventNote: %w", err)
+	}
+	if d.deferred != nil {
+		d.deferred.Add(ctx, at, d.s.subjectPrefix+EvSubjNote, j)
+		return nil
+	}
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at,
+		d.s.subjectPrefix+EvSubjNote, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", EvSubjNote, err)
 	}
 	return nil
 }
 
+// appendEventNoteJSON appends e encoded the way encoding/json encodes it.
+func appendEventNoteJSON(b []byte, e app.EventNote) []byte {
+	start := len(b)
+	if e.Text != "" {
+		b = append(b, `,"text":`...)
+		b = jsonenc.AppendString(b, string(e.Text))
+	}
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
+
 type dispatcherEventTick struct {
 	s   *Server
 	ctx context.Context
+	// deferred holds the events back when not nil.
+	deferred *dispatch.Deferred
 }
 
 func (d dispatcherEventTick) Dispatch(e app.EventTick) error {
@@ -837,20 +1280,74 @@ func (d dispatcherEventTick) Dispatch(e app.EventTick) error {
 func (d dispatcherEventTick) DispatchCtx(
 	ctx context.Context, e app.EventTick,
 ) error {
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventTick JSON: %w", err)
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventTick) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventTick,
+) error {
+	d.deferred = nil
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventTick) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventTick,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventTick: %w", messaging.ErrNoScheduler)
 	}
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, EvSubjTick, j)
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventTick) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventTick,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventTick) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventTick,
+) error {
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventTickJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventTick: %w", err)
+	}
+	if d.deferred != nil {
+		d.deferred.Add(ctx, at, d.s.subjectPrefix+EvSubjTick, j)
+		return nil
+	}
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at,
+		d.s.subjectPrefix+EvSubjTick, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", EvSubjTick, err)
 	}
 	return nil
 }
 
+// appendEventTickJSON appends e encoded the way encoding/json encodes it.
+func appendEventTickJSON(b []byte, e app.EventTick) []byte {
+	start := len(b)
+	b = append(b, `,"n":`...)
+	b = jsonenc.AppendInt(b, int64(e.N))
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
+
 type dispatcherEventPong struct {
 	s   *Server
 	ctx context.Context
+	// deferred holds the events back when not nil.
+	deferred *dispatch.Deferred
 }
 
 func (d dispatcherEventPong) Dispatch(e app.EventPong) error {
@@ -860,20 +1357,74 @@ func (d dispatcherEventPong) Dispatch(e app.EventPong) error {
 func (d dispatcherEventPong) DispatchCtx(
 	ctx context.Context, e app.EventPong,
 ) error {
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventPong JSON: %w", err)
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventPong) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventPong,
+) error {
+	d.deferred = nil
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventPong) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventPong,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventPong: %w", messaging.ErrNoScheduler)
 	}
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, EvSubjPong, j)
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventPong) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventPong,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventPong) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventPong,
+) error {
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventPongJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventPong: %w", err)
+	}
+	if d.deferred != nil {
+		d.deferred.Add(ctx, at, d.s.subjectPrefix+EvSubjPong, j)
+		return nil
+	}
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at,
+		d.s.subjectPrefix+EvSubjPong, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", EvSubjPong, err)
 	}
 	return nil
 }
 
+// appendEventPongJSON appends e encoded the way encoding/json encodes it.
+func appendEventPongJSON(b []byte, e app.EventPong) []byte {
+	start := len(b)
+	b = append(b, `,"n":`...)
+	b = jsonenc.AppendInt(b, int64(e.N))
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
+
 type dispatcherEventRoomSaid struct {
 	s   *Server
 	ctx context.Context
+	// deferred holds the events back when not nil.
+	deferred *dispatch.Deferred
 }
 
 func (d dispatcherEventRoomSaid) Dispatch(e app.EventRoomSaid) error {
@@ -882,27 +1433,82 @@ func (d dispatcherEventRoomSaid) Dispatch(e app.EventRoomSaid) error {
 
 func (d dispatcherEventRoomSaid) DispatchCtx(
 	ctx context.Context, e app.EventRoomSaid,
+) error {
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventRoomSaid) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventRoomSaid,
+) error {
+	d.deferred = nil
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventRoomSaid) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventRoomSaid,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventRoomSaid: %w", messaging.ErrNoScheduler)
+	}
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventRoomSaid) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventRoomSaid,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventRoomSaid) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventRoomSaid,
 ) error {
 	if !subject.IsToken(string(e.Room)) {
 		return fmt.Errorf(
 			"EventRoomSaid.Room must be a non-empty subject token, received %q",
 			e.Room)
 	}
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventRoomSaid JSON: %w", err)
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventRoomSaidJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventRoomSaid: %w", err)
 	}
 	subj := "room.said." + string(e.Room)
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, subj, j)
+	if d.deferred != nil {
+		d.deferred.Add(ctx, at, d.s.subjectPrefix+subj, j)
+		return nil
+	}
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at, d.s.subjectPrefix+subj, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", subj, err)
 	}
 	return nil
 }
 
+// appendEventRoomSaidJSON appends e encoded the way encoding/json encodes it.
+func appendEventRoomSaidJSON(b []byte, e app.EventRoomSaid) []byte {
+	start := len(b)
+	b = append(b, `,"Room":`...)
+	b = jsonenc.AppendString(b, string(e.Room))
+	b = append(b, `,"text":`...)
+	b = jsonenc.AppendString(b, string(e.Text))
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
+
 type dispatcherEventRoomBroadcast struct {
 	s   *Server
 	ctx context.Context
+	// deferred holds the events back when not nil.
+	deferred *dispatch.Deferred
 }
 
 func (d dispatcherEventRoomBroadcast) Dispatch(e app.EventRoomBroadcast) error {
@@ -911,20 +1517,73 @@ func (d dispatcherEventRoomBroadcast) Dispatch(e app.EventRoomBroadcast) error {
 
 func (d dispatcherEventRoomBroadcast) DispatchCtx(
 	ctx context.Context, e app.EventRoomBroadcast,
+) error {
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventRoomBroadcast) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventRoomBroadcast,
+) error {
+	d.deferred = nil
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventRoomBroadcast) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventRoomBroadcast,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventRoomBroadcast: %w", messaging.ErrNoScheduler)
+	}
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventRoomBroadcast) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventRoomBroadcast,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventRoomBroadcast) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventRoomBroadcast,
 ) error {
 	if !subject.IsToken(string(e.Room)) {
 		return fmt.Errorf(
 			"EventRoomBroadcast.Room must be a non-empty subject token, received %q",
 			e.Room)
 	}
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventRoomBroadcast JSON: %w", err)
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventRoomBroadcastJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventRoomBroadcast: %w", err)
 	}
 	subj := "room.broadcast." + string(e.Room)
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, subj, j)
+	if d.deferred != nil {
+		d.deferred.Add(ctx, at, d.s.subjectPrefix+subj, j)
+		return nil
+	}
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at, d.s.subjectPrefix+subj, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", subj, err)
 	}
 	return nil
 }
+
+// appendEventRoomBroadcastJSON appends e encoded the way encoding/json encodes it.
+func appendEventRoomBroadcastJSON(b []byte, e app.EventRoomBroadcast) []byte {
+	start := len(b)
+	b = append(b, `,"Room":`...)
+	b = jsonenc.AppendString(b, string(e.Room))
+	b = append(b, `,"text":`...)
+	b = jsonenc.AppendString(b, string(e.Text))
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
@@ -3,26 +3,35 @@
 package acceptance_test
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/romshark/datapages"
 	"github.com/romshark/datapages/internal/acceptance/brokers"
 	"github.com/romshark/datapages/internal/acceptance/client"
 	"github.com/romshark/datapages/internal/acceptance/events/app"
 	"github.com/romshark/datapages/internal/acceptance/events/app/datapagesgen"
+	"github.com/romshark/datapages/modules/codec"
 	"github.com/romshark/datapages/modules/messaging"
 	"github.com/romshark/datapages/modules/messaging/inmem"
+	"github.com/romshark/datapages/modules/messaging/natscore"
 )
 
 // postOK sends an action and requires the server to accept it.
@@ -128,6 +137,149 @@ func TestTwoDispatchers(t *testing.T) {
 	})
 }
 
+// TestDeferDispatch covers an action returning datapages.DeferDispatch.
+// Its events reach the broker only after it returned true without an error,
+// and then in the order it dispatched them. Backing out or failing must leave
+// the broker untouched, which is the point of holding them back.
+func TestDeferDispatch(t *testing.T) {
+	brokers.Each(t, func(t *testing.T, broker messaging.Broker) {
+		rec := &recordingBroker{Broker: broker}
+		c := client.New(t, mustNewServer(t, &app.App{}, rec))
+
+		s := c.OpenStream(t, "/_$/", nil)
+
+		resp := c.Action(t, http.MethodPost, "/deferred/",
+			`{"n":1,"outcome":"fail"}`)
+		require.Equal(t, http.StatusInternalServerError, resp.Status)
+		postOK(t, c, "/deferred/", `{"n":2,"outcome":"rollback"}`)
+		require.Empty(t, rec.published(),
+			"a handler that did not succeed published its events")
+
+		postOK(t, c, "/deferred/", `{"n":3,"outcome":"commit"}`)
+		require.True(t, s.Saw(`<div id="out">tick 3</div>`),
+			"the first deferred event never arrived")
+		require.True(t, s.Saw(`<div id="pong">pong 3</div>`),
+			"the second deferred event never arrived")
+		require.Equal(t, []string{"tick", "pong"}, rec.published(),
+			"the deferred events were published out of dispatch order")
+
+		require.True(t, s.Never("tick 1"), "a failed handler's event arrived")
+		require.True(t, s.Never("tick 2"), "a rolled back handler's event arrived")
+	})
+}
+
+// TestDeferDispatchDropLogged covers a handler backing out with the zero value,
+// which drops its events without failing the request: the debug log tells.
+func TestDeferDispatchDropLogged(t *testing.T) {
+	logs := new(syncBuffer)
+	c := client.New(t, mustNewServer(t, &app.App{},
+		inmem.New(messaging.DefaultBrokerChanBuffer),
+		datapages.WithLogger(slog.New(slog.NewJSONHandler(logs,
+			&slog.HandlerOptions{Level: slog.LevelDebug})))))
+
+	postOK(t, c, "/deferred/", `{"n":1,"outcome":"rollback"}`)
+	require.Contains(t, logs.String(),
+		`"msg":"dropping deferred events of PageIndex.Deferred"`)
+	require.Contains(t, logs.String(), `"events":2`)
+}
+
+// syncBuffer is a buffer the server may log to while the test reads it.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+// TestDispatchTo covers datapages.DispatchTo. The event goes to the publisher
+// of the handler, through the interceptors and under the subject prefix of
+// the server, and isn't held back by DeferDispatch.
+func TestDispatchTo(t *testing.T) {
+	rec := &recordingBroker{Broker: inmem.New(messaging.DefaultBrokerChanBuffer)}
+	tx := inmem.New(messaging.DefaultBrokerChanBuffer)
+	sub, err := tx.Subscribe(t.Context(), noMetrics{}, "shop.tick")
+	require.NoError(t, err)
+	defer sub.Close()
+	c := client.New(t, mustNewServer(t, &app.App{Tx: tx}, rec,
+		datapages.WithSubjectPrefix("shop"),
+		datapages.WithBrokerInterceptors(messaging.Interceptor{
+			Publish: func(
+				ctx context.Context, msg messaging.Message, next messaging.PublishFunc,
+			) error {
+				msg.Header = messaging.Header{"tenant": "t1"}
+				return next(ctx, msg)
+			},
+		})))
+
+	postOK(t, c, "/deferred-to/", `{"n":1}`)
+	select {
+	case msg := <-sub.C():
+		require.JSONEq(t, `{"n":1}`, string(msg.Data))
+		require.Equal(t, "t1", msg.Header["tenant"], "the interceptors didn't run")
+	case <-time.After(time.Second):
+		t.Fatal("the event wasn't published")
+	}
+	require.Empty(t, rec.published(), "the event went to the broker of the server")
+}
+
+// TestDispatchAfter covers a scheduled dispatch on the brokers that schedule.
+func TestDispatchAfter(t *testing.T) {
+	build := map[string]func(t *testing.T) messaging.Broker{
+		"inmem": func(*testing.T) messaging.Broker {
+			return inmem.New(brokers.ChanBuffer)
+		},
+		"nats": func(t *testing.T) messaging.Broker {
+			b, err := natscore.NewScheduled(brokers.Conn(t),
+				natscore.Config{ChanBuffer: brokers.ChanBuffer},
+				natscore.ScheduleConfig{})
+			require.NoError(t, err)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- b.Run(ctx) }()
+			t.Cleanup(func() {
+				cancel()
+				require.NoError(t, <-done)
+			})
+			return b
+		},
+	}
+	for name, newBroker := range build {
+		t.Run(name, func(t *testing.T) {
+			c := client.New(t, mustNewServer(t, &app.App{}, newBroker(t)))
+			s := c.OpenStream(t, "/_$/", nil)
+
+			start := time.Now()
+			postOK(t, c, "/later/", `{"n":1,"delay_ms":300}`)
+			require.True(t, s.Saw(`<div id="out">tick 1</div>`),
+				"the scheduled event never arrived")
+			require.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond,
+				"the scheduled event arrived early")
+		})
+	}
+}
+
+// TestDispatchAfterWithoutScheduler covers a broker that can't schedule.
+// The action fails at the call instead of publishing right away.
+func TestDispatchAfterWithoutScheduler(t *testing.T) {
+	// recordingBroker hides the Scheduler of the broker it wraps.
+	rec := &recordingBroker{Broker: inmem.New(messaging.DefaultBrokerChanBuffer)}
+	c := client.New(t, mustNewServer(t, &app.App{}, rec))
+
+	resp := c.Action(t, http.MethodPost, "/later/", `{"n":1,"delay_ms":0}`)
+	require.Equal(t, http.StatusInternalServerError, resp.Status)
+	require.Empty(t, rec.published(), "the event was published unscheduled")
+}
+
 // TestStreamCloseDispatches covers dispatching from StreamClose. The hook runs
 // while the closing stream is torn down, so its request context is already
 // done and the dispatcher must publish with that cancelation stripped.
@@ -163,6 +315,84 @@ func TestEventDecodeIsNotCarriedOver(t *testing.T) {
 		"the empty note kept the text of the one before it")
 }
 
+// TestGeneratedJSONEncoding covers the encoders generated for events whose
+// fields are all strings, booleans and integers. What they publish must be
+// what encoding/json encodes, down to the escaping and the omitted fields.
+func TestGeneratedJSONEncoding(t *testing.T) {
+	rec := &recordingBroker{Broker: inmem.New(messaging.DefaultBrokerChanBuffer)}
+	c := client.New(t, mustNewServer(t, &app.App{}, rec))
+
+	postOK(t, c, "/note/", `{"text":"<a href=\"x\">\u2028&\u00e9</a>"}`)
+	postOK(t, c, "/note/", `{"text":""}`)
+	postOK(t, c, "/tick/", `{"n":-7}`)
+
+	want := make([][]byte, 0, 3)
+	for _, e := range []any{
+		app.EventNote{Text: "<a href=\"x\">\u2028&\u00e9</a>"},
+		app.EventNote{},
+		app.EventTick{N: -7},
+	} {
+		j, err := json.Marshal(e)
+		require.NoError(t, err)
+		want = append(want, j)
+	}
+	require.Equal(t, want, rec.publishedData())
+}
+
+// TestEventCodec covers datapages.WithEventCodec. The codec encodes every
+// dispatch, and a message received by several streams is decoded once.
+func TestEventCodec(t *testing.T) {
+	cdc := new(countingCodec)
+	c := client.New(t, mustNewServer(t, &app.App{},
+		inmem.New(messaging.DefaultBrokerChanBuffer),
+		datapages.WithEventCodec(cdc)))
+
+	streams := []*client.Stream{
+		c.OpenStream(t, "/_$/", nil),
+		c.OpenStream(t, "/_$/", nil),
+		c.OpenStream(t, "/_$/", nil),
+	}
+
+	postOK(t, c, "/tick/", `{"n":5}`)
+	for i, s := range streams {
+		require.True(t, s.Saw(`<div id="out">tick 5</div>`),
+			"stream %d received no patch", i)
+	}
+	require.Equal(t, int32(1), cdc.marshaled.Load())
+	require.Equal(t, int32(1), cdc.unmarshaled.Load(),
+		"the message was decoded once per stream")
+}
+
+// TestSubjectPrefix covers two deployments sharing a broker under different
+// datapages.WithSubjectPrefix namespaces. Each one receives its own events
+// only, whether routed by a constant or by a subject field.
+func TestSubjectPrefix(t *testing.T) {
+	rec := &recordingBroker{Broker: inmem.New(messaging.DefaultBrokerChanBuffer)}
+	staging := client.New(t, mustNewServer(t, &app.App{}, rec,
+		datapages.WithSubjectPrefix("shop.staging")))
+	prod := client.New(t, mustNewServer(t, &app.App{}, rec,
+		datapages.WithSubjectPrefix("shop.prod")))
+
+	stagingIndex := staging.OpenStream(t, "/_$/", nil)
+	prodIndex := prod.OpenStream(t, "/_$/", nil)
+	stagingRoom := staging.OpenStream(t, "/room/_$/", map[string]string{"room": "r1"})
+	prodRoom := prod.OpenStream(t, "/room/_$/", map[string]string{"room": "r1"})
+
+	postOK(t, staging, "/tick/", `{"n":3}`)
+	postOK(t, staging, "/room/say/", `{"room":"r1","text":"hi"}`)
+
+	require.True(t, stagingIndex.Saw(`<div id="out">tick 3</div>`),
+		"the event never reached its own deployment")
+	require.True(t, stagingRoom.Saw(`<div id="said">hi</div>`),
+		"the subject field event never reached its own deployment")
+	require.Equal(t,
+		[]string{"shop.staging.tick", "shop.staging.room.said.r1"},
+		rec.published())
+
+	require.True(t, prodIndex.Never("tick 3"), "the event crossed deployments")
+	require.True(t, prodRoom.Never("hi"), "the subject field event crossed deployments")
+}
+
 // TestDispatchCtx covers Dispatcher.DispatchCtx.
 // The action dispatches with a context that is already done.
 // A broker that honors the context refuses to publish and the action fails.
@@ -184,6 +414,43 @@ func TestDispatchCtx(t *testing.T) {
 		"the event was published with a canceled context")
 }
 
+// TestTracing covers the spans of an action and of the event handler receiving
+// what it dispatched, which carry the action's span context over the broker.
+func TestTracing(t *testing.T) {
+	brokers.Each(t, func(t *testing.T, broker messaging.Broker) {
+		rec := tracetest.NewSpanRecorder()
+		tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+		c := client.New(t, mustNewServer(t, &app.App{}, broker,
+			datapages.WithTracerProvider(tp)))
+
+		s := c.OpenStream(t, "/_$/", nil)
+		postOK(t, c, "/note/", `{"text":"hi"}`)
+		require.True(t, s.Saw(`<div id="note">note=hi</div>`))
+
+		spans := make(map[string]sdktrace.ReadOnlySpan)
+		require.Eventually(t, func() bool {
+			for _, span := range rec.Ended() {
+				spans[span.Name()] = span
+			}
+			return spans["PageIndex.OnNote"] != nil
+		}, time.Second, time.Millisecond)
+		require.Contains(t, spans, "PageIndex.StreamOpen")
+
+		action := spans["PageIndex.POSTNote"]
+		require.NotNil(t, action)
+		require.Equal(t, trace.SpanKindServer, action.SpanKind())
+
+		handler := spans["PageIndex.OnNote"]
+		require.Equal(t, trace.SpanKindConsumer, handler.SpanKind())
+		require.Len(t, handler.Links(), 1)
+		require.Equal(t, action.SpanContext().SpanID(),
+			handler.Links()[0].SpanContext.SpanID())
+		require.Contains(t, logOf(t, c),
+			"note in span "+handler.SpanContext().SpanID().String(),
+			"the handler runs in the context of its span")
+	})
+}
+
 // ctxBroker publishes only while the context it is given is alive.
 // The in-memory broker ignores the context, which would hide what
 // TestDispatchCtx is after.
@@ -326,6 +593,37 @@ func TestStreamOpenRefuses(t *testing.T) {
 	})
 }
 
+// TestBrokerDown covers a broker that loses its connection. The open stream
+// is ended so the client reconnects, and until the broker is back
+// a new stream is refused and dispatching fails.
+func TestBrokerDown(t *testing.T) {
+	broker := &statusBroker{Broker: inmem.New(messaging.DefaultBrokerChanBuffer)}
+	c := client.New(t, mustNewServer(t, &app.App{}, broker))
+
+	s := c.OpenStream(t, "/_$/", nil)
+	broker.Set(false)
+	require.True(t, s.Ended(), "the stream outlived the broker connection")
+
+	resp := c.Action(t, http.MethodGet, "/_$/", "")
+	require.Equal(t, http.StatusInternalServerError, resp.Status,
+		"a stream opened while the broker was down")
+	resp = c.Action(t, http.MethodPost, "/tick/", `{"n":1}`)
+	require.Equal(t, http.StatusInternalServerError, resp.Status,
+		"dispatched while the broker was down")
+
+	broker.Set(true)
+	s = c.OpenStream(t, "/_$/", nil)
+	postOK(t, c, "/tick/", `{"n":2}`)
+	require.True(t, s.Saw(`<div id="out">tick 2</div>`),
+		"the stream reopened after the broker came back received nothing")
+}
+
+// statusBroker is a broker whose connection the test takes down and back up.
+type statusBroker struct {
+	messaging.Broker
+	messaging.ConnStatus
+}
+
 // TestStreamRequiresDatastar covers a stream route reached by a plain client.
 func TestStreamRequiresDatastar(t *testing.T) {
 	brokers.Each(t, func(t *testing.T, broker messaging.Broker) {
@@ -480,3 +778,56 @@ func TestPageWithoutStream(t *testing.T) {
 		}
 	})
 }
+
+// recordingBroker records the subjects it published, in publish order.
+type noMetrics struct{}
+
+func (noMetrics) OnPublish(string)   {}
+func (noMetrics) OnDeliveryDropped() {}
+
+type recordingBroker struct {
+	messaging.Broker
+
+	mu       sync.Mutex
+	subjects []string
+	payloads [][]byte
+}
+
+func (b *recordingBroker) Publish(
+	ctx context.Context, metrics messaging.Metrics, subject string, data []byte,
+) error {
+	b.mu.Lock()
+	b.subjects = append(b.subjects, subject)
+	b.payloads = append(b.payloads, slices.Clone(data))
+	b.mu.Unlock()
+	return b.Broker.Publish(ctx, metrics, subject, data)
+}
+
+func (b *recordingBroker) published() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.subjects)
+}
+
+func (b *recordingBroker) publishedData() [][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.payloads)
+}
+
+// countingCodec is codec.JSON counting its calls. Being another type, it
+// also makes the server encode with it instead of the generated encoders.
+type countingCodec struct {
+	codec.JSON
+	marshaled, unmarshaled atomic.Int32
+}
+
+func (c *countingCodec) Marshal(v any) ([]byte, error) {
+	c.marshaled.Add(1)
+	return c.JSON.Marshal(v)
+}
+
+func (c *countingCodec) Unmarshal(data []byte, v any) error {
+	c.unmarshaled.Add(1)
+	return c.JSON.Unmarshal(data, v)
+}
@@ -3,12 +3,16 @@
 package app
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 
 	"github.com/a-h/templ"
 
 	"github.com/romshark/datapages"
+	"github.com/romshark/datapages/internal/acceptance/getsignals/app/datapagesgen/signals"
+	imperial "github.com/romshark/datapages/internal/acceptance/getsignals/app/imperial"
+	si "github.com/romshark/datapages/internal/acceptance/getsignals/app/si"
 )
 
 type App struct{}
@@ -60,3 +64,69 @@ func (PageIndex) POSTLeave(_ *http.Request, session Session) (
 ) {
 	return session.Token() != "", nil
 }
+
+// POSTReset is /reset
+//
+// An action that sends the page back to its first page through the generated
+// patcher, leaving the search term as it is.
+func (PageIndex) POSTReset(_ *http.Request, sse datapages.SSE) error {
+	return signals.PatchPageIndex(sse, signals.PageIndex{Page: new(1)})
+}
+
+// PageKinds is /kinds
+//
+// Its handlers declare signals of the types the generated signals package
+// spells out differently than the app does.
+type PageKinds struct{ App *App }
+
+// Level is declared in the app package, which the signals package can't import.
+type Level int
+
+// Stamp encodes itself, as a string and not as the struct it is.
+type Stamp struct{ Year, Month, Day int }
+
+func (s Stamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%04d-%02d-%02d", s.Year, s.Month, s.Day))
+}
+
+// KindsSignals are the signals PageKinds.GET reads.
+type KindsSignals struct {
+	Level  Level         `json:"level"`
+	Stamp  Stamp         `json:"stamp"`
+	Height si.Meters     `json:"height"`
+	Reach  imperial.Feet `json:"reach"`
+	Mode   string        `json:"mode"`
+}
+
+func (PageKinds) GET(
+	_ *http.Request, _ datapages.Signals[KindsSignals],
+) (body datapages.Component, err error) {
+	return templ.Raw(`<pre id="echo">kinds</pre>`), nil
+}
+
+// POSTSet is /kinds/set
+//
+// An action declaring mode with another type than GET does, and a signal
+// of its own under a field name GET took already. It patches them all.
+func (PageKinds) POSTSet(
+	_ *http.Request,
+	sse datapages.SSE,
+	_ datapages.Signals[struct {
+		Mode  int    `json:"mode"`
+		Level string `json:"level_name"`
+	}],
+) error {
+	stamp, err := json.Marshal(Stamp{Year: 2026, Month: 10, Day: 19})
+	if err != nil {
+		return err
+	}
+	var mode any = 2
+	return signals.PatchPageKinds(sse, signals.PageKinds{
+		Level:  new(3),
+		Stamp:  new(json.RawMessage(stamp)),
+		Height: new(si.Meters(1.8)),
+		Reach:  new(imperial.Feet(6)),
+		Mode:   &mode,
+		Level2: new("high"),
+	})
+}
@@ -342,3 +342,35 @@ func POSTPageIndexLeave(options ...option) string {
 	writeAfter(&b, options)
 	return b.String()
 }
+
+// POSTPageIndexReset references /reset/
+func POSTPageIndexReset(options ...option) string {
+	if len(options) == 0 {
+		return "@post('/reset/')"
+	}
+	var b strings.Builder
+	bl, al := beforeAfterLen(options)
+	b.Grow(bl + len("@post('/reset/'") + optionsLen(options) + len(")") + al)
+	writeBefore(&b, options)
+	b.WriteString("@post('/reset/'")
+	writeOptions(&b, options)
+	b.WriteByte(')')
+	writeAfter(&b, options)
+	return b.String()
+}
+
+// POSTPageKindsSet references /kinds/set/
+func POSTPageKindsSet(options ...option) string {
+	if len(options) == 0 {
+		return "@post('/kinds/set/')"
+	}
+	var b strings.Builder
+	bl, al := beforeAfterLen(options)
+	b.Grow(bl + len("@post('/kinds/set/'") + optionsLen(options) + len(")") + al)
+	writeBefore(&b, options)
+	b.WriteString("@post('/kinds/set/'")
+	writeOptions(&b, options)
+	b.WriteByte(')')
+	writeAfter(&b, options)
+	return b.String()
+}
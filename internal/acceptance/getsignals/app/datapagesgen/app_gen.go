@@ -3,6 +3,7 @@
 package datapagesgen
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -14,8 +15,13 @@ import (
 	"github.com/romshark/datapages/modules/messaging"
 	"github.com/romshark/datapages/modules/sessions"
 	"github.com/romshark/datapages/runtime/auth"
+	"github.com/romshark/datapages/runtime/deferred"
 	"github.com/romshark/datapages/runtime/httpread"
 	"github.com/romshark/datapages/runtime/httpserve"
+	"github.com/romshark/datapages/runtime/reqlog"
+	dpsse "github.com/romshark/datapages/runtime/sse"
+	"github.com/romshark/datapages/runtime/subject"
+	"github.com/romshark/datapages/runtime/tracing"
 
 	"github.com/romshark/datapages/internal/acceptance/getsignals/app"
 	"github.com/romshark/datapages/internal/acceptance/getsignals/app/datapagesgen/href"
@@ -87,8 +93,13 @@ func (s *Server) writeHTML(
 	if err != nil {
 		return err
 	}
+	// The deferred components of the body follow it on the same response,
+	// the ones of the head are resolved as it's rendered.
+	ctx, deferreds := deferred.Start(r.Context(), s.DeferredTimeout(),
+		func(w io.Writer, id string, err error) { s.recoverDeferred(w, r, id, err) })
+	headCtx := deferred.InPlace(ctx)
 	if head != nil {
-		if err := head.Render(r.Context(), w); err != nil {
+		if err := head.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
@@ -132,7 +143,7 @@ func (s *Server) writeHTML(
 		return err
 	}
 	if body != nil {
-		if err := body.Render(r.Context(), w); err != nil {
+		if err := body.Render(ctx, w); err != nil {
 			return err
 		}
 	}
@@ -145,6 +156,9 @@ func (s *Server) writeHTML(
 			return err
 		}
 	}
+	if deferreds.Len() > 0 {
+		deferreds.Resolve(r.Context(), w)
+	}
 	_, err = io.WriteString(w, "</body></html>")
 	return err
 }
@@ -154,7 +168,12 @@ type Server struct {
 	messageBroker        messaging.Broker
 	messageBrokerMetrics brokerMetrics
 	streamSeq            atomic.Uint64
-	app                  *app.App
+	// subjectPrefix is what every subject starts with on the wire,
+	// empty without datapages.WithSubjectPrefix.
+	subjectPrefix string
+	// tracer is nil without datapages.WithTracerProvider.
+	tracer tracing.Tracer
+	app    *app.App
 	*auth.Manager[struct{}]
 }
 
@@ -170,6 +189,9 @@ type Server struct {
 //   - datapages.WithHTTPServer
 //   - datapages.WithDatastarJS
 //   - datapages.WithAssets
+//   - datapages.WithSubjectPrefix
+//   - datapages.WithBrokerInterceptors
+//   - datapages.WithTracerProvider
 //   - datapages.WithSessionManager (required)
 //   - datapages.WithSessions
 //   - datapages.WithCSRFProtection
@@ -185,6 +207,10 @@ func (s *Server) Init(
 		return errors.New("unexpected option WithPrometheus: " +
 			"the server is generated with datapages.DisablePrometheus")
 	}
+	if cfg.MeterProvider != nil {
+		return errors.New("unexpected option WithMeterProvider: " +
+			"the server is generated with datapages.DisablePrometheus")
+	}
 	if sessionManager == nil {
 		return errors.New("missing option WithSessionManager")
 	}
@@ -198,15 +224,27 @@ func (s *Server) Init(
 	s.Core = httpserve.NewCore(cfg, "")
 	s.app = app
 	s.messageBroker = messageBroker
+	s.tracer = tracing.NewTracer(cfg.TracerProvider)
+	if cfg.SubjectPrefix != "" {
+		s.subjectPrefix = subject.Prefix(cfg.SubjectPrefix)
+	}
 	s.messageBrokerMetrics = brokerMetrics{}
 
 	if si, ok := s.messageBroker.(messaging.StreamInitializer); ok {
-		if err := si.InitStreams(MessageBrokerStreamSubjects()); err != nil {
+		subjects := subject.InNamespace(s.subjectPrefix, MessageBrokerStreamSubjects())
+		if err := si.InitStreams(subjects); err != nil {
 			return fmt.Errorf("initializing message broker streams: %w", err)
 		}
 	}
 	s.Manager = auth.NewManager(s.Core, sessionManager, cfg, nil)
 
+	s.AddReadinessCheck("broker", func(ctx context.Context) error {
+		return messaging.Ping(ctx, s.messageBroker)
+	})
+	if p, ok := sessionManager.(sessions.Pinger); ok {
+		s.AddReadinessCheck("sessions", p.Ping)
+	}
+
 	setupHandlers(s)
 
 	s.Build()
@@ -233,16 +271,26 @@ func setupHandlers(s *Server) {
 	s.Mux().HandleFunc(
 		"GET /",
 		s.handlePageIndexGET)
+	s.Mux().HandleFunc(
+		"GET /kinds/{$}",
+		s.handlePageKindsGET)
 	s.Mux().HandleFunc(
 		"POST /leave/{$}",
 		s.handlePageIndexPOSTLeave)
+	s.Mux().HandleFunc(
+		"POST /reset/{$}",
+		s.handlePageIndexPOSTReset)
+	s.Mux().HandleFunc(
+		"POST /kinds/set/{$}",
+		s.handlePageKindsPOSTSet)
 }
 
 func (s *Server) httpErrIntern(
-	w http.ResponseWriter, _ *http.Request,
+	w http.ResponseWriter, r *http.Request,
 	_ *datastar.ServerSentEventGenerator, msg string, err error,
 ) {
-	s.LogErr(msg, err)
+	s.LogErrCtx(r.Context(), msg, err)
+	tracing.FailRequest(r, err)
 	switch {
 	case errors.Is(err, datapages.ErrBadRequest):
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
@@ -257,7 +305,18 @@ func (s *Server) httpErrIntern(
 	}
 }
 
+func (s *Server) recoverDeferred(
+	w io.Writer, r *http.Request, id string, err error,
+) {
+	err = datapages.DeferredError{Selector: "#" + id, Err: err}
+	s.LogErrCtx(r.Context(), "resolving deferred component", err)
+}
+
 func (s *Server) handlePageEnterGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageEnter.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageEnter.GET")
+
 	p := app.PageEnter{
 		App: s.app,
 	}
@@ -280,12 +339,16 @@ func (s *Server) handlePageEnterGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, datapages.Session[struct{}]{}, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageEnter", err)
+		s.LogErrCtx(r.Context(), "rendering PageEnter", err)
 		return
 	}
 }
 
 func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.GET")
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -323,7 +386,7 @@ func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageIndex", err)
+		s.LogErrCtx(r.Context(), "rendering PageIndex", err)
 		return
 	}
 }
@@ -331,6 +394,10 @@ func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handlePageIndexPOSTLeave(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.POSTLeave")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.POSTLeave")
+
 	sess, sessToken, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -350,3 +417,99 @@ func (s *Server) handlePageIndexPOSTLeave(
 		}
 	}
 }
+
+func (s *Server) handlePageIndexPOSTReset(
+	w http.ResponseWriter, r *http.Request,
+) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.POSTReset")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.POSTReset")
+
+	if !s.checkIsDSReq(w, r) {
+		return
+	}
+	// CSRF protection covers every state-changing action, including
+	// the ones that read nothing of the session.
+	if _, _, ok := s.ReadSession(w, r); !ok {
+		return
+	}
+
+	sse := datastar.NewSSE(w, r, datastar.WithCompression())
+	p := app.PageIndex{
+		App: s.app,
+	}
+	err := p.POSTReset(r, dpsse.New(sse))
+	if err != nil {
+		s.httpErrIntern(w, r, sse, "handling action PageIndex.Reset", err)
+		return
+	}
+}
+
+func (s *Server) handlePageKindsGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageKinds.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageKinds.GET")
+
+	var signals datapages.Signals[app.KindsSignals]
+	if httpread.QueryHas(r.URL.RawQuery, "datastar") {
+		if err := datastar.ReadSignals(r, &signals.Values); err != nil {
+			s.httpErrBad(w, "reading signals", err)
+			return
+		}
+	}
+
+	p := app.PageKinds{
+		App: s.app,
+	}
+	body, err := p.GET(r, signals)
+	if err != nil {
+		s.httpErrIntern(w, r, nil, "handling PageKinds.GET", err)
+		return
+	}
+
+	bodyAttrs := func(w http.ResponseWriter) {
+		httpserve.WriteReloadOnVisibility(w)
+	}
+
+	if err := s.writeHTML(
+		w, r, datapages.Session[struct{}]{}, nil, body, bodyAttrs, nil,
+	); err != nil {
+		s.LogErrCtx(r.Context(), "rendering PageKinds", err)
+		return
+	}
+}
+
+func (s *Server) handlePageKindsPOSTSet(
+	w http.ResponseWriter, r *http.Request,
+) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageKinds.POSTSet")
+	defer span.End()
+	reqlog.SetHandler(r, "PageKinds.POSTSet")
+
+	if !s.checkIsDSReq(w, r) {
+		return
+	}
+	// CSRF protection covers every state-changing action, including
+	// the ones that read nothing of the session.
+	if _, _, ok := s.ReadSession(w, r); !ok {
+		return
+	}
+	var signals datapages.Signals[struct {
+		Mode  int    `json:"mode"`
+		Level string `json:"level_name"`
+	}]
+	if err := datastar.ReadSignals(r, &signals.Values); err != nil {
+		s.httpErrBad(w, "reading signals", err)
+		return
+	}
+
+	sse := datastar.NewSSE(w, r, datastar.WithCompression())
+	p := app.PageKinds{
+		App: s.app,
+	}
+	err := p.POSTSet(r, dpsse.New(sse), signals)
+	if err != nil {
+		s.httpErrIntern(w, r, sse, "handling action PageKinds.Set", err)
+		return
+	}
+}
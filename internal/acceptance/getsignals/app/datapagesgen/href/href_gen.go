@@ -41,3 +41,6 @@ func PageEnter() string { return "/enter/" }
 
 // PageIndex references /{$}
 func PageIndex() string { return "/" }
+
+// PageKinds references /kinds/{$}
+func PageKinds() string { return "/kinds/" }
@@ -0,0 +1,6 @@
+// Package units of the directory imperial holds imperial units,
+// see the package of the directory si.
+package units
+
+// Feet is a length in feet.
+type Feet float64
@@ -0,0 +1,7 @@
+// Package units of the directory si holds metric units. The package of the
+// directory imperial has the same name, so a page declaring signals of both
+// makes the generated signals package import one of them under another name.
+package units
+
+// Meters is a length in meters.
+type Meters float64
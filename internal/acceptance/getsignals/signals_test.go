@@ -3,6 +3,7 @@
 package acceptance_test
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/url"
 	"strings"
@@ -12,6 +13,9 @@ import (
 
 	"github.com/romshark/datapages/internal/acceptance/client"
 	"github.com/romshark/datapages/internal/acceptance/getsignals/app"
+	"github.com/romshark/datapages/internal/acceptance/getsignals/app/datapagesgen/signals"
+	imperial "github.com/romshark/datapages/internal/acceptance/getsignals/app/imperial"
+	si "github.com/romshark/datapages/internal/acceptance/getsignals/app/si"
 	"github.com/romshark/datapages/modules/csrf"
 	"github.com/romshark/datapages/modules/messaging"
 	"github.com/romshark/datapages/modules/messaging/inmem"
@@ -120,3 +124,50 @@ func TestActionClosesTheSession(t *testing.T) {
 	require.Equal(t, "term= page=0 user=", c.Get(t, "/").Element(t, "echo"),
 		"the session outlived the action that closed it")
 }
+
+// TestActionPatchesTypedSignals covers the generated signals package:
+// an action patches only the signals it sets.
+func TestActionPatchesTypedSignals(t *testing.T) {
+	c := newClient(t)
+
+	token := csrfToken(t, c.Get(t, "/enter/"))
+	req := c.Request(t, http.MethodPost, "/reset/", "")
+	req.Header.Set("X-CSRF-Token", token)
+	resp := c.Do(t, req)
+
+	require.Equal(t, http.StatusOK, resp.Status)
+	require.Contains(t, resp.Body, "event: datastar-patch-signals")
+	require.Contains(t, resp.Body, `data: signals {"page":1}`,
+		"a signal left nil was patched")
+}
+
+// The types the generated signals package gives the signals of PageKinds,
+// which stop the build when they change.
+var (
+	// Declared in the app package, which the signals package can't import.
+	_ *int = signals.PageKinds{}.Level
+	// Encodes itself, unlike its underlying type.
+	_ *json.RawMessage = signals.PageKinds{}.Stamp
+	// Of two packages named the same, imported under two names.
+	_ *si.Meters     = signals.PageKinds{}.Height
+	_ *imperial.Feet = signals.PageKinds{}.Reach
+	// Declared with another type by each handler.
+	_ *any = signals.PageKinds{}.Mode
+	// Named Level by both handlers.
+	_ *string = signals.PageKinds{}.Level2
+)
+
+// TestActionPatchesSignalsOfEveryKind covers what the signals of PageKinds
+// encode to: the JSON the app's own types have.
+func TestActionPatchesSignalsOfEveryKind(t *testing.T) {
+	c := newClient(t)
+
+	token := csrfToken(t, c.Get(t, "/enter/"))
+	req := c.Request(t, http.MethodPost, "/kinds/set/", `{"mode":1,"level_name":"low"}`)
+	req.Header.Set("X-CSRF-Token", token)
+	resp := c.Do(t, req)
+
+	require.Equal(t, http.StatusOK, resp.Status)
+	require.Contains(t, resp.Body, `data: signals {"level":3,"stamp":"2026-10-19",`+
+		`"height":1.8,"reach":6,"mode":2,"level_name":"high"}`)
+}
@@ -0,0 +1,271 @@
+// Drives the OpenTelemetry metrics of ./app.
+
+package acceptance_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/romshark/datapages"
+	"github.com/romshark/datapages/internal/acceptance/otelmetrics/app"
+	"github.com/romshark/datapages/internal/acceptance/otelmetrics/app/datapagesgen"
+	"github.com/romshark/datapages/modules/messaging"
+	"github.com/romshark/datapages/modules/messaging/inmem"
+)
+
+// newMeterProvider returns a meter provider of its own,
+// and the reader the test collects what it recorded with.
+func newMeterProvider(t *testing.T) (*sdkmetric.MeterProvider, *sdkmetric.ManualReader) {
+	t.Helper()
+	r := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(r))
+	t.Cleanup(func() { _ = mp.Shutdown(context.Background()) })
+	return mp, r
+}
+
+func newServer(
+	t *testing.T, opts ...datapages.ServerOption,
+) (*httptest.Server, *sdkmetric.ManualReader) {
+	t.Helper()
+	mp, r := newMeterProvider(t)
+	srv := httptest.NewServer(mustNewServer(
+		t,
+		&app.App{}, inmem.New(messaging.DefaultBrokerChanBuffer),
+		append(opts, datapages.WithMeterProvider(mp))...,
+	))
+	t.Cleanup(srv.Close)
+	return srv, r
+}
+
+func do(t *testing.T, srv *httptest.Server, method, path, body string) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(
+		context.Background(), method, srv.URL+path, strings.NewReader(body),
+	)
+	require.NoError(t, err)
+	if method != http.MethodGet {
+		req.Header.Set("Datastar-Request", "true")
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+}
+
+// count returns what the instrument name recorded with attrs:
+// the sum of a counter, the number of samples of a histogram.
+// Without attrs it adds up every data point.
+func count(
+	t *testing.T, r *sdkmetric.ManualReader, name string, attrs ...attribute.KeyValue,
+) float64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, r.Collect(context.Background(), &rm))
+	want := attribute.NewSet(attrs...)
+	match := func(s attribute.Set) bool { return len(attrs) == 0 || s.Equals(&want) }
+	var total float64
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			switch d := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, p := range d.DataPoints {
+					if match(p.Attributes) {
+						total += float64(p.Value)
+					}
+				}
+			case metricdata.Histogram[float64]:
+				for _, p := range d.DataPoints {
+					if match(p.Attributes) {
+						total += float64(p.Count)
+					}
+				}
+			}
+		}
+	}
+	return total
+}
+
+// TestMetrics covers the instruments the HTTP handlers record on.
+func TestMetrics(t *testing.T) {
+	srv, r := newServer(t)
+
+	do(t, srv, http.MethodGet, "/", "")
+	do(t, srv, http.MethodPost, "/fail/", "")
+
+	require.Equal(t, 2.0, count(t, r, "datapages.http.requests"))
+	require.Equal(t, 2.0, count(t, r, "datapages.http.request.duration"))
+	require.Zero(t, count(t, r, "datapages.http.in_flight_requests"))
+	require.Equal(t, 1.0, count(t, r, "datapages.handler.duration",
+		attribute.String("page", "PageIndex"),
+		attribute.String("handler", "GET")))
+	require.Equal(t, 1.0, count(t, r, "datapages.handler.duration",
+		attribute.String("page", "PageIndex"),
+		attribute.String("handler", "POSTFail")))
+}
+
+// TestStreamAndBrokerMetrics covers the instruments of a stream receiving
+// an event another request dispatched.
+func TestStreamAndBrokerMetrics(t *testing.T) {
+	srv, r := newServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/_$/", nil)
+	require.NoError(t, err)
+	req.Header.Set("Datastar-Request", "true")
+	req.Header.Set("Accept-Encoding", "identity")
+	stream, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = stream.Body.Close() })
+	require.Equal(t, http.StatusOK, stream.StatusCode)
+
+	page := attribute.String("page", "PageIndex")
+	require.Eventually(t, func() bool {
+		return count(t, r, "datapages.sse.streams", page) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	do(t, srv, http.MethodPost, "/announce/", `{"text":"hi"}`)
+
+	require.Equal(t, 1.0, count(t, r, "datapages.event_broker.publishes"))
+	require.Equal(t, 1.0, count(t, r, "datapages.dispatch.duration",
+		attribute.String("event", "EventAnnounced")))
+	require.Eventually(t, func() bool {
+		return count(t, r, "datapages.event_handler.duration",
+			page,
+			attribute.String("handler", "OnAnnounced"),
+			attribute.String("event", "EventAnnounced")) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.Eventually(t, func() bool {
+		return count(t, r, "datapages.sse.streams", page) == 0 &&
+			count(t, r, "datapages.sse.disconnects",
+				attribute.String("reason", "client")) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+// TestStreamLifetime covers a stream sending heartbeats while idle and
+// closing with a retry hint once it reached its lifetime.
+func TestStreamLifetime(t *testing.T) {
+	srv, r := newServer(t, datapages.WithStreams(datapages.StreamsConfig{
+		Heartbeat:   10 * time.Millisecond,
+		MaxLifetime: 200 * time.Millisecond,
+		Retry:       2 * time.Second,
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/_$/", nil)
+	require.NoError(t, err)
+	req.Header.Set("Datastar-Request", "true")
+	req.Header.Set("Accept-Encoding", "identity")
+	stream, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = stream.Body.Close() })
+
+	// The server ends the stream, not the client.
+	body, err := io.ReadAll(stream.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "\n: heartbeat\n\n")
+	require.Contains(t, string(body), "\nretry: 2000\n\n")
+
+	require.Eventually(t, func() bool {
+		return count(t, r, "datapages.sse.disconnects",
+			attribute.String("reason", "ttl")) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	require.Zero(t, count(t, r, "datapages.sse.disconnects",
+		attribute.String("reason", "client")))
+}
+
+// TestStreamDrain covers the streams told the server is restarting and
+// disconnected over the drain window, and no stream opening after.
+func TestStreamDrain(t *testing.T) {
+	mp, r := newMeterProvider(t)
+	s := mustNewServer(
+		t,
+		&app.App{}, inmem.New(messaging.DefaultBrokerChanBuffer),
+		datapages.WithMeterProvider(mp),
+		datapages.WithStreams(datapages.StreamsConfig{
+			DrainWindow: 200 * time.Millisecond,
+			Retry:       time.Second,
+		}),
+	)
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	openStream := func() *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/_$/", nil)
+		require.NoError(t, err)
+		req.Header.Set("Datastar-Request", "true")
+		req.Header.Set("Accept-Encoding", "identity")
+		resp, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		return resp
+	}
+	streams := []*http.Response{openStream(), openStream()}
+
+	require.NoError(t, s.Shutdown(ctx))
+	for _, stream := range streams {
+		body, err := io.ReadAll(stream.Body)
+		require.NoError(t, err)
+		require.Contains(t, string(body), `<div id="out">restarting</div>`)
+		require.Contains(t, string(body), "\n\nretry: ")
+	}
+	require.Eventually(t, func() bool {
+		return count(t, r, "datapages.sse.disconnects",
+			attribute.String("reason", "shutdown")) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, http.StatusServiceUnavailable, openStream().StatusCode)
+}
+
+// TestServersDontShareInstruments covers two servers in one process,
+// each recording on the meter provider it was given and nowhere else.
+func TestServersDontShareInstruments(t *testing.T) {
+	a, ra := newServer(t)
+	_, rb := newServer(t)
+
+	do(t, a, http.MethodGet, "/", "")
+
+	require.Equal(t, 1.0, count(t, ra, "datapages.http.requests"))
+	require.Zero(t, count(t, rb, "datapages.http.requests"))
+}
+
+// TestMetricsOptions covers the options a server generated with
+// datapages.EnableOTelMetrics requires and rejects.
+func TestMetricsOptions(t *testing.T) {
+	newServer := func(opts ...datapages.ServerOption) error {
+		_, err := datapages.NewServer[
+			app.App,
+			datapages.DisableSessions,
+			datapages.EnableOTelMetrics,
+			datapagesgen.Server,
+		](&app.App{}, inmem.New(messaging.DefaultBrokerChanBuffer), opts...)
+		return err
+	}
+
+	require.EqualError(t, newServer(), "missing option WithMeterProvider")
+
+	mp, _ := newMeterProvider(t)
+	err := newServer(
+		datapages.WithMeterProvider(mp),
+		datapages.WithPrometheus(datapages.PrometheusConfig{Host: "127.0.0.1:0"}),
+	)
+	require.ErrorContains(t, err, "unexpected option WithPrometheus")
+}
@@ -0,0 +1,49 @@
+// Package app exercises the access log and the request IDs that join
+// the log lines of an action with those of the event handlers its
+// dispatch runs on other streams.
+package app
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/a-h/templ"
+
+	"github.com/romshark/datapages"
+)
+
+type App struct{}
+
+// EventAnnounced is "announced"
+type EventAnnounced struct {
+	Text string `json:"text"`
+}
+
+// PageIndex is /
+type PageIndex struct{ App *App }
+
+func (PageIndex) GET(r *http.Request) (body datapages.Component, err error) {
+	datapages.Logger(r.Context()).Info("rendering index")
+	return templ.Raw(`<pre id="echo">index</pre>`), nil
+}
+
+func (PageIndex) OnAnnounced(
+	event EventAnnounced,
+	sse datapages.SSE,
+) error {
+	datapages.Logger(sse.Context()).Info("announced", slog.String("text", event.Text))
+	return sse.PatchElement(
+		templ.Raw(`<div id="out">` + event.Text + `</div>`))
+}
+
+// POSTAnnounce is /announce
+func (PageIndex) POSTAnnounce(
+	r *http.Request,
+	signals datapages.Signals[struct {
+		Text string `json:"text"`
+	}],
+	announced datapages.Dispatcher[EventAnnounced],
+) error {
+	datapages.Logger(r.Context()).Info("announcing")
+	return announced.Dispatch(EventAnnounced{Text: signals.Values.Text})
+}
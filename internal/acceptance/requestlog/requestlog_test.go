@@ -0,0 +1,150 @@
+// Drives the access log of ./app and the request IDs joining an action's
+// log lines with those of the event handlers its dispatch runs.
+
+package acceptance_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages"
+	"github.com/romshark/datapages/internal/acceptance/requestlog/app"
+	"github.com/romshark/datapages/modules/messaging"
+	"github.com/romshark/datapages/modules/messaging/inmem"
+)
+
+// logBuffer collects the JSON log lines of a server,
+// which the stream goroutines write concurrently.
+type logBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+// find returns the first line logged with msg, nil when there's none.
+func (b *logBuffer) find(t *testing.T, msg string) map[string]any {
+	t.Helper()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for l := range strings.SplitSeq(strings.TrimSpace(b.buf.String()), "\n") {
+		if l == "" {
+			continue
+		}
+		var line map[string]any
+		require.NoError(t, json.Unmarshal([]byte(l), &line))
+		if line["msg"] == msg {
+			return line
+		}
+	}
+	return nil
+}
+
+func newServer(t *testing.T) (*httptest.Server, *logBuffer) {
+	t.Helper()
+	logs := new(logBuffer)
+	srv := httptest.NewServer(mustNewServer(
+		t,
+		&app.App{}, inmem.New(messaging.DefaultBrokerChanBuffer),
+		datapages.WithLogger(slog.New(slog.NewJSONHandler(logs, nil))),
+		datapages.WithAccessLog(datapages.AccessLogConfig{}),
+	))
+	t.Cleanup(srv.Close)
+	return srv, logs
+}
+
+func do(
+	t *testing.T, srv *httptest.Server, method, path, requestID, body string,
+) *http.Response {
+	t.Helper()
+	req, err := http.NewRequestWithContext(
+		context.Background(), method, srv.URL+path, strings.NewReader(body),
+	)
+	require.NoError(t, err)
+	if method != http.MethodGet {
+		req.Header.Set("Datastar-Request", "true")
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if requestID != "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	return resp
+}
+
+// TestAccessLog covers the line logged per request and the logger
+// datapages.Logger returns in a handler.
+func TestAccessLog(t *testing.T) {
+	srv, logs := newServer(t)
+
+	resp := do(t, srv, http.MethodGet, "/", "", "")
+	id := resp.Header.Get("X-Request-ID")
+	require.Len(t, id, 32, "a request without an ID is given one")
+
+	line := logs.find(t, "rendering index")
+	require.NotNil(t, line)
+	require.Equal(t, id, line["request_id"])
+	require.Equal(t, "PageIndex.GET", line["handler"])
+
+	line = logs.find(t, "request")
+	require.NotNil(t, line)
+	require.Equal(t, id, line["request_id"])
+	require.Equal(t, "PageIndex.GET", line["handler"])
+	require.Equal(t, http.MethodGet, line["method"])
+	require.Equal(t, "GET /", line["route"])
+	require.Equal(t, float64(http.StatusOK), line["status"])
+	require.Contains(t, line, "duration")
+}
+
+// TestEventCorrelation covers an event handler logging the request ID
+// of the action whose dispatch it handles, on a stream of its own.
+func TestEventCorrelation(t *testing.T) {
+	srv, logs := newServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/_$/", nil)
+	require.NoError(t, err)
+	req.Header.Set("Datastar-Request", "true")
+	req.Header.Set("Accept-Encoding", "identity")
+	stream, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = stream.Body.Close() })
+	require.Equal(t, http.StatusOK, stream.StatusCode)
+	streamID := stream.Header.Get("X-Request-ID")
+	require.NotEmpty(t, streamID)
+
+	// The stream subscribes once it answered, which a dispatch racing it misses.
+	require.Eventually(t, func() bool {
+		do(t, srv, http.MethodPost, "/announce/", "announce-1", `{"text":"hi"}`)
+		return logs.find(t, "announced") != nil
+	}, 2*time.Second, 20*time.Millisecond)
+
+	line := logs.find(t, "announcing")
+	require.NotNil(t, line)
+	require.Equal(t, "announce-1", line["request_id"])
+	require.Equal(t, "PageIndex.POSTAnnounce", line["handler"])
+
+	line = logs.find(t, "announced")
+	require.Equal(t, "announce-1", line["origin_request_id"])
+	require.Equal(t, streamID, line["request_id"])
+	require.Equal(t, "PageIndex.OnAnnounced", line["handler"])
+	require.Equal(t, "hi", line["text"])
+	require.NotZero(t, line["stream_id"])
+}
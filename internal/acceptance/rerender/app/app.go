@@ -0,0 +1,80 @@
+// Package app exercises the page re-render an event handler asks for
+// with datapages.Rerender, which runs the GET handler of the page for
+// the request its stream was opened with.
+package app
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/a-h/templ"
+
+	"github.com/romshark/datapages"
+)
+
+type App struct {
+	bumps   atomic.Int64
+	removed atomic.Bool
+}
+
+// EventBumped is "bumped"
+type EventBumped struct{}
+
+// PageIndex is /
+type PageIndex struct{ App *App }
+
+func (PageIndex) GET(_ *http.Request) (body datapages.Component, err error) {
+	return templ.Raw(`<main id="main">index</main>`), nil
+}
+
+// PageItem is /item/{id}
+type PageItem struct{ App *App }
+
+func (p PageItem) GET(
+	_ *http.Request,
+	path datapages.Path[struct {
+		ID string `path:"id"`
+	}],
+	query datapages.Query[struct {
+		Tab string `query:"tab"`
+	}],
+) (body datapages.Component, redirect datapages.Redirect, err error) {
+	if p.App.removed.Load() {
+		return nil, datapages.Redirect{URL: "/"}, nil
+	}
+	return templ.Raw(fmt.Sprintf(`<main id="main">item %s tab %s bumps %d</main>`,
+		html.EscapeString(path.Values.ID), html.EscapeString(query.Values.Tab),
+		p.App.bumps.Load())), redirect, nil
+}
+
+func (PageItem) OnBumped(
+	event EventBumped, sse datapages.SSE,
+) (rerender datapages.Rerender, err error) {
+	return true, nil
+}
+
+// POSTBump is /item/{id}/bump
+func (p PageItem) POSTBump(
+	_ *http.Request,
+	path datapages.Path[struct {
+		ID string `path:"id"`
+	}],
+	bumped datapages.Dispatcher[EventBumped],
+) error {
+	p.App.bumps.Add(1)
+	return bumped.Dispatch(EventBumped{})
+}
+
+// POSTRemove is /item/{id}/remove
+func (p PageItem) POSTRemove(
+	_ *http.Request,
+	path datapages.Path[struct {
+		ID string `path:"id"`
+	}],
+	bumped datapages.Dispatcher[EventBumped],
+) error {
+	p.App.removed.Store(true)
+	return bumped.Dispatch(EventBumped{})
+}
@@ -0,0 +1,102 @@
+// Code generated by github.com/romshark/datapages; DO NOT EDIT.
+
+// Package href provides generators for datastar anchor hypertext references.
+// Use these in templates instead of hardcoding URLs.
+package href
+
+import (
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/romshark/datapages/runtime/hrefcheck"
+)
+
+var logger atomic.Pointer[slog.Logger]
+
+func init() { logger.Store(slog.Default()) }
+
+// SetLogger sets the logger used by External to log invalid URLs.
+// Passing nil resets to the default logger. It is safe for concurrent use.
+func SetLogger(l *slog.Logger) {
+	if l == nil {
+		l = slog.Default()
+	}
+	logger.Store(l)
+}
+
+func getLogger() *slog.Logger { return logger.Load() }
+
+// External returns url as-is for use in href attributes.
+// It logs a warning at runtime if the URL is not an allowed
+// non-relative href (e.g. app-internal paths, javascript:, relative URLs).
+func External(url string) string {
+	if !hrefcheck.IsAllowedNonRelativeHref(url) {
+		getLogger().Warn("href.External called with app-internal URL", "url", url)
+	}
+	return url
+}
+
+// PageIndex references /{$}
+func PageIndex() string { return "/" }
+
+// PageItem references /item/{id}/{$}
+func PageItem(id string, query QueryPageItem) string {
+	s_id := url.PathEscape(id)
+	var (
+		tabStr string
+	)
+
+	if query.Tab != "" {
+		tabStr = url.QueryEscape(query.Tab)
+	}
+
+	anyQuery := query.Tab != ""
+
+	var b strings.Builder
+	l := len("/item/") +
+		len(s_id) +
+		len("/")
+	if anyQuery {
+		l += len("?")
+	}
+
+	// n = number of query params already accounted for (for '&')
+	n := 0
+
+	if query.Tab != "" {
+		if n > 0 {
+			l += len("&")
+		}
+		n++
+		l += len("tab=") + len(tabStr)
+	}
+	_ = n
+
+	b.Grow(l)
+
+	b.WriteString("/item/")
+	b.WriteString(s_id)
+	b.WriteString("/")
+	if anyQuery {
+		b.WriteString("?")
+	}
+
+	n = 0
+
+	if query.Tab != "" {
+		if n > 0 {
+			b.WriteString("&")
+		}
+		b.WriteString("tab=")
+		b.WriteString(tabStr)
+	}
+
+	return b.String()
+}
+
+// QueryPageItem is the query parameters for PageItem
+type QueryPageItem struct {
+	Tab string `query:"tab"`
+}
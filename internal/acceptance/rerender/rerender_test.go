@@ -0,0 +1,119 @@
+// Drives the re-render of ./app: an event handler returning datapages.Rerender
+// has the page rendered by its GET handler for the request of the stream.
+
+package acceptance_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages/internal/acceptance/rerender/app"
+	"github.com/romshark/datapages/modules/messaging"
+	"github.com/romshark/datapages/modules/messaging/inmem"
+)
+
+// streamBuffer collects what a stream carries, which a goroutine reads.
+type streamBuffer struct {
+	lock sync.Mutex
+	buf  strings.Builder
+}
+
+func (b *streamBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+// openStream opens the stream of the page at path,
+// as the browser does from the page the Referer names.
+func openStream(t *testing.T, srv *httptest.Server, path string) *streamBuffer {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+path+"/_$/", nil)
+	require.NoError(t, err)
+	req.Header.Set("Datastar-Request", "true")
+	req.Header.Set("Accept-Encoding", "identity")
+	req.Header.Set("Referer", srv.URL+path+"?tab=specs")
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	b := new(streamBuffer)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := resp.Body.Read(buf)
+			b.lock.Lock()
+			b.buf.Write(buf[:n])
+			b.lock.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return b
+}
+
+func post(t *testing.T, srv *httptest.Server, path string) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(
+		context.Background(), http.MethodPost, srv.URL+path, nil,
+	)
+	require.NoError(t, err)
+	req.Header.Set("Datastar-Request", "true")
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(mustNewServer(
+		t, &app.App{}, inmem.New(messaging.DefaultBrokerChanBuffer),
+	))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// TestRerender covers the body GET renders for the path and the
+// query of the page reaching the stream as a morph of <body>.
+func TestRerender(t *testing.T) {
+	srv := newServer(t)
+	stream := openStream(t, srv, "/item/a")
+
+	// The stream subscribes once it answered, which a dispatch racing it misses.
+	require.Eventually(t, func() bool {
+		post(t, srv, "/item/a/bump/")
+		return strings.Contains(stream.String(), "data: selector body")
+	}, 2*time.Second, 20*time.Millisecond)
+
+	got := stream.String()
+	require.Contains(t, got, "event: datastar-patch-elements")
+	require.Contains(t, got, `data: elements <body `)
+	require.Contains(t, got, `<main id="main">item a tab specs bumps `)
+	require.Contains(t, got, `data-init="@get('/item/a`, "the morph keeps the stream")
+}
+
+// TestRerenderRedirect covers a GET that redirects during a re-render,
+// which navigates the client.
+func TestRerenderRedirect(t *testing.T) {
+	srv := newServer(t)
+	stream := openStream(t, srv, "/item/a")
+
+	require.Eventually(t, func() bool {
+		post(t, srv, "/item/a/remove/")
+		return strings.Contains(stream.String(), "window.location.href")
+	}, 2*time.Second, 20*time.Millisecond)
+	require.Contains(t, stream.String(), `window.location.href = "/"`)
+	require.NotContains(t, stream.String(), `<main id="main">`, "the page is not morphed")
+}
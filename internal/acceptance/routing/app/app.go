@@ -111,14 +111,15 @@ func (PageTitled) GET(
 //
 // A query parameter bound to a Datastar signal. The value in the URL becomes
 // the signal's value on load, and the page carries the code that writes the
-// signal back into the URL when it changes.
+// signal back into the URL when it changes. A change of the page
+// adds a history entry, one of the term replaces it.
 type PageReflect struct{ App *App }
 
 func (PageReflect) GET(
 	_ *http.Request,
 	query datapages.Query[struct {
 		Term string `query:"t" reflectsignal:"term"`
-		Page int    `query:"p" reflectsignal:"page"`
+		Page int    `query:"p" reflectsignal:"page,push"`
 	}],
 ) (body datapages.Component, err error) {
 	return echo("term=%q page=%d", query.Values.Term, query.Values.Page), nil
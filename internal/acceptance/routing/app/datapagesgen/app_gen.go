@@ -3,6 +3,7 @@
 package datapagesgen
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -14,9 +15,13 @@ import (
 	"github.com/romshark/datapages"
 	"github.com/romshark/datapages/modules/messaging"
 	"github.com/romshark/datapages/modules/sessions"
+	"github.com/romshark/datapages/runtime/deferred"
 	"github.com/romshark/datapages/runtime/htmlattr"
 	"github.com/romshark/datapages/runtime/httpread"
 	"github.com/romshark/datapages/runtime/httpserve"
+	"github.com/romshark/datapages/runtime/reqlog"
+	"github.com/romshark/datapages/runtime/subject"
+	"github.com/romshark/datapages/runtime/tracing"
 
 	"github.com/romshark/datapages/internal/acceptance/routing/app"
 	"github.com/romshark/datapages/internal/acceptance/routing/app/datapagesgen/href"
@@ -76,8 +81,13 @@ func (s *Server) writeHTML(
 	if err != nil {
 		return err
 	}
+	// The deferred components of the body follow it on the same response,
+	// the ones of the head are resolved as it's rendered.
+	ctx, deferreds := deferred.Start(r.Context(), s.DeferredTimeout(),
+		func(w io.Writer, id string, err error) { s.recoverDeferred(w, r, id, err) })
+	headCtx := deferred.InPlace(ctx)
 	if head != nil {
-		if err := head.Render(r.Context(), w); err != nil {
+		if err := head.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
@@ -91,7 +101,7 @@ func (s *Server) writeHTML(
 		return err
 	}
 	if body != nil {
-		if err := body.Render(r.Context(), w); err != nil {
+		if err := body.Render(ctx, w); err != nil {
 			return err
 		}
 	}
@@ -104,6 +114,9 @@ func (s *Server) writeHTML(
 			return err
 		}
 	}
+	if deferreds.Len() > 0 {
+		deferreds.Resolve(r.Context(), w)
+	}
 	_, err = io.WriteString(w, "</body></html>")
 	return err
 }
@@ -113,7 +126,12 @@ type Server struct {
 	messageBroker        messaging.Broker
 	messageBrokerMetrics brokerMetrics
 	streamSeq            atomic.Uint64
-	app                  *app.App
+	// subjectPrefix is what every subject starts with on the wire,
+	// empty without datapages.WithSubjectPrefix.
+	subjectPrefix string
+	// tracer is nil without datapages.WithTracerProvider.
+	tracer tracing.Tracer
+	app    *app.App
 }
 
 // Init wires the server. It is called by datapages.NewServer,
@@ -128,6 +146,9 @@ type Server struct {
 //   - datapages.WithHTTPServer
 //   - datapages.WithDatastarJS
 //   - datapages.WithAssets
+//   - datapages.WithSubjectPrefix
+//   - datapages.WithBrokerInterceptors
+//   - datapages.WithTracerProvider
 func (s *Server) Init(
 	cfg datapages.ServerConfig,
 	app *app.App,
@@ -143,6 +164,10 @@ func (s *Server) Init(
 		return errors.New("unexpected option WithPrometheus: " +
 			"the server is generated with datapages.DisablePrometheus")
 	}
+	if cfg.MeterProvider != nil {
+		return errors.New("unexpected option WithMeterProvider: " +
+			"the server is generated with datapages.DisablePrometheus")
+	}
 
 	assetsFS, err := assetsFileSystem(cfg)
 	if err != nil {
@@ -153,14 +178,23 @@ func (s *Server) Init(
 	s.Core = httpserve.NewCore(cfg, "")
 	s.app = app
 	s.messageBroker = messageBroker
+	s.tracer = tracing.NewTracer(cfg.TracerProvider)
+	if cfg.SubjectPrefix != "" {
+		s.subjectPrefix = subject.Prefix(cfg.SubjectPrefix)
+	}
 	s.messageBrokerMetrics = brokerMetrics{}
 
 	if si, ok := s.messageBroker.(messaging.StreamInitializer); ok {
-		if err := si.InitStreams(MessageBrokerStreamSubjects()); err != nil {
+		subjects := subject.InNamespace(s.subjectPrefix, MessageBrokerStreamSubjects())
+		if err := si.InitStreams(subjects); err != nil {
 			return fmt.Errorf("initializing message broker streams: %w", err)
 		}
 	}
 
+	s.AddReadinessCheck("broker", func(ctx context.Context) error {
+		return messaging.Ping(ctx, s.messageBroker)
+	})
+
 	setupHandlers(s)
 
 	s.Build()
@@ -211,15 +245,26 @@ func setupHandlers(s *Server) {
 }
 
 func (s *Server) httpErrIntern(
-	w http.ResponseWriter, _ *http.Request,
+	w http.ResponseWriter, r *http.Request,
 	_ *datastar.ServerSentEventGenerator, msg string, err error,
 ) {
-	s.LogErr(msg, err)
+	s.LogErrCtx(r.Context(), msg, err)
+	tracing.FailRequest(r, err)
 	const code = http.StatusInternalServerError
 	http.Error(w, http.StatusText(code), code)
 }
 
+func (s *Server) recoverDeferred(
+	w io.Writer, r *http.Request, id string, err error,
+) {
+	err = datapages.DeferredError{Selector: "#" + id, Err: err}
+	s.LogErrCtx(r.Context(), "resolving deferred component", err)
+}
+
 func (s *Server) handlePageConflictGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageConflict.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageConflict.GET")
 
 	var path datapages.Path[struct {
 		Value   int32  `path:"value"`
@@ -262,12 +307,15 @@ func (s *Server) handlePageConflictGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageConflict", err)
+		s.LogErrCtx(r.Context(), "rendering PageConflict", err)
 		return
 	}
 }
 
 func (s *Server) handlePageFilesGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageFiles.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageFiles.GET")
 
 	var path datapages.Path[struct {
 		Rest string `path:"rest"`
@@ -290,12 +338,16 @@ func (s *Server) handlePageFilesGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageFiles", err)
+		s.LogErrCtx(r.Context(), "rendering PageFiles", err)
 		return
 	}
 }
 
 func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.GET")
+
 	if r.URL.Path != "/" {
 		http.NotFound(w, r)
 		return
@@ -317,12 +369,15 @@ func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageIndex", err)
+		s.LogErrCtx(r.Context(), "rendering PageIndex", err)
 		return
 	}
 }
 
 func (s *Server) handlePageIntsGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageInts.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageInts.GET")
 
 	var path datapages.Path[struct {
 		I8  int8   `path:"i8"`
@@ -413,12 +468,15 @@ func (s *Server) handlePageIntsGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageInts", err)
+		s.LogErrCtx(r.Context(), "rendering PageInts", err)
 		return
 	}
 }
 
 func (s *Server) handlePageMixedGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageMixed.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageMixed.GET")
 
 	var query datapages.Query[struct {
 		Tab  string `query:"tab"`
@@ -467,12 +525,15 @@ func (s *Server) handlePageMixedGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageMixed", err)
+		s.LogErrCtx(r.Context(), "rendering PageMixed", err)
 		return
 	}
 }
 
 func (s *Server) handlePagePathGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PagePath.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PagePath.GET")
 
 	var path datapages.Path[struct {
 		S string  `path:"str"`
@@ -535,12 +596,15 @@ func (s *Server) handlePagePathGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PagePath", err)
+		s.LogErrCtx(r.Context(), "rendering PagePath", err)
 		return
 	}
 }
 
 func (s *Server) handlePageQueryGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageQuery.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageQuery.GET")
 
 	var query datapages.Query[struct {
 		Term  string  `query:"term"`
@@ -629,16 +693,19 @@ func (s *Server) handlePageQueryGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageQuery", err)
+		s.LogErrCtx(r.Context(), "rendering PageQuery", err)
 		return
 	}
 }
 
 func (s *Server) handlePageReflectGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageReflect.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageReflect.GET")
 
 	var query datapages.Query[struct {
 		Term string `query:"t" reflectsignal:"term"`
-		Page int    `query:"p" reflectsignal:"page"`
+		Page int    `query:"p" reflectsignal:"page,push"`
 	}]
 	query.Values.Term = httpread.QueryValue(r.URL.RawQuery, "t")
 	{
@@ -679,19 +746,28 @@ func (s *Server) handlePageReflectGET(w http.ResponseWriter, r *http.Request) {
 			if ($term) params.set('t', $term);
 			if ($page) params.set('p', $page);
 			const query = params.toString();
-			window.history.replaceState(null, '', query ? '/reflect?' + query : '/reflect');
+			const url = query ? '/reflect?' + query : '/reflect';
+			const pushed = JSON.stringify([$page]);
+			if (el.dpPushed === undefined || el.dpPushed === pushed) window.history.replaceState(null, '', url);
+			else window.history.pushState(null, '', url);
+			el.dpPushed = pushed;
 		"`)
+
+		_, _ = io.WriteString(w, ` data-on:popstate__window="window.location.reload()"`)
 	}
 
 	if err := s.writeHTML(
 		w, r, nil, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageReflect", err)
+		s.LogErrCtx(r.Context(), "rendering PageReflect", err)
 		return
 	}
 }
 
 func (s *Server) handlePageTitledGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageTitled.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageTitled.GET")
 
 	var path datapages.Path[app.TitledPath]
 	path.Values.Name = r.PathValue("name")
@@ -712,7 +788,7 @@ func (s *Server) handlePageTitledGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, head, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageTitled", err)
+		s.LogErrCtx(r.Context(), "rendering PageTitled", err)
 		return
 	}
 }
@@ -206,6 +206,9 @@ func TestReflectedSignals(t *testing.T) {
 		`data-signals:term="'shoes'"`,
 		`data-signals:page="3"`,
 		"window.history.replaceState",
+		"window.history.pushState",
+		"JSON.stringify([$page])",
+		`data-on:popstate__window="window.location.reload()"`,
 		"params.set('t'",
 		"params.set('p'",
 	} {
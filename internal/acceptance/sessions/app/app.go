@@ -133,6 +133,68 @@ func (PageSecret) GET(_ *http.Request, session Session) (
 	return echo("secret for " + session.UserID()), nil
 }
 
+// PageNotes is /notes
+//
+// A stateful page. The state of a tab belongs to the user who opened it,
+// the ID of the tab sent with another session finds none of it.
+type PageNotes struct{ App *App }
+
+func (PageNotes) GET(
+	_ *http.Request, tab datapages.TabState[string],
+) (body datapages.Component, err error) {
+	note, err := tab.Get()
+	if err != nil {
+		return nil, err
+	}
+	return echo("note=" + note), nil
+}
+
+// POSTWrite is /notes/write
+//
+// Replaces the note of the tab and answers with the one it had.
+func (PageNotes) POSTWrite(
+	_ *http.Request,
+	signals datapages.Signals[struct {
+		Text string `json:"text"`
+	}],
+	tab datapages.TabState[string],
+	sse datapages.SSE,
+) error {
+	prev, err := tab.Get()
+	if err != nil {
+		return err
+	}
+	if err := tab.Set(signals.Values.Text); err != nil {
+		return err
+	}
+	return sse.PatchElement(echo("was=" + prev))
+}
+
+// PageMonitor is /monitor
+//
+// A dashboard that receives the private notices of every user.
+// Only the stream of the user "admin" subscribes to them.
+type PageMonitor struct{ App *App }
+
+func (PageMonitor) GET(_ *http.Request) (body datapages.Component, err error) {
+	return echo("monitor"), nil
+}
+
+func (PageMonitor) AuthorizeWildcard(
+	_ *http.Request, session Session,
+) (bool, error) {
+	return session.UserID() == "admin", nil
+}
+
+// OnNotice receives the notices addressed to any user.
+//
+//datapages:wildcard
+func (PageMonitor) OnNotice(event EventNotice, sse datapages.SSE) error {
+	return sse.PatchElement(templ.Raw(
+		`<div id="monitor">` + string(event.Recipient) + ": " + event.Text + `</div>`,
+	))
+}
+
 // PageLogin is /login
 type PageLogin struct{ App *App }
 
@@ -406,3 +406,19 @@ func POSTPageLoginSubmit(options ...option) string {
 	writeAfter(&b, options)
 	return b.String()
 }
+
+// POSTPageNotesWrite references /notes/write/
+func POSTPageNotesWrite(options ...option) string {
+	if len(options) == 0 {
+		return "@post('/notes/write/')"
+	}
+	var b strings.Builder
+	bl, al := beforeAfterLen(options)
+	b.Grow(bl + len("@post('/notes/write/'") + optionsLen(options) + len(")") + al)
+	writeBefore(&b, options)
+	b.WriteString("@post('/notes/write/'")
+	writeOptions(&b, options)
+	b.WriteByte(')')
+	writeAfter(&b, options)
+	return b.String()
+}
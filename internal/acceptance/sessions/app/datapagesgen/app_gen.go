@@ -4,22 +4,31 @@ package datapagesgen
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/romshark/datapages"
+	"github.com/romshark/datapages/modules/codec"
 	"github.com/romshark/datapages/modules/messaging"
 	"github.com/romshark/datapages/modules/sessions"
 	"github.com/romshark/datapages/runtime/auth"
+	"github.com/romshark/datapages/runtime/deferred"
+	"github.com/romshark/datapages/runtime/dispatch"
+	"github.com/romshark/datapages/runtime/eventcache"
 	"github.com/romshark/datapages/runtime/httpserve"
+	"github.com/romshark/datapages/runtime/jsonenc"
+	"github.com/romshark/datapages/runtime/reqlog"
 	dpsse "github.com/romshark/datapages/runtime/sse"
 	"github.com/romshark/datapages/runtime/subject"
+	"github.com/romshark/datapages/runtime/tabs"
+	"github.com/romshark/datapages/runtime/tracing"
 
 	"github.com/romshark/datapages/internal/acceptance/sessions/app"
 	"github.com/romshark/datapages/internal/acceptance/sessions/app/datapagesgen/href"
@@ -78,6 +87,28 @@ func (s *Server) checkIsDSReq(w http.ResponseWriter, r *http.Request) (ok bool)
 	return true
 }
 
+// readTab returns the tab whose instance ID the stream or an action of
+// a stateful page carries. Without a valid one it answers a bad request.
+func (s *Server) readTab(w http.ResponseWriter, r *http.Request) (tabs.Tab, bool) {
+	tabID, err := tabs.FromRequest(r)
+	if err == nil && tabID == "" {
+		err = errors.New("missing " + tabs.Header + " header")
+	}
+	if err != nil {
+		s.httpErrBad(w, "reading tab instance", err)
+		return tabs.Tab{}, false
+	}
+	return s.tab(w, r, tabID)
+}
+
+// tab returns the tab with the instance ID tabID, which belongs to the user
+// of the session r carries. The ID of a tab reaching the server with another
+// session finds none of its state.
+func (s *Server) tab(w http.ResponseWriter, r *http.Request, tabID string) (tabs.Tab, bool) {
+	userID, ok := s.SessionUserID(w, r)
+	return s.Tab(tabID, userID), ok
+}
+
 func (s *Server) checkUserSubject(w http.ResponseWriter, userID string) (ok bool) {
 	if subject.IsToken(userID) {
 		return true
@@ -103,13 +134,37 @@ func (s *Server) writeHTML(
 	if err != nil {
 		return err
 	}
+	// The deferred components of the body follow it on the same response,
+	// the ones of the head are resolved as it's rendered.
+	ctx, deferreds := deferred.Start(r.Context(), s.DeferredTimeout(),
+		func(w io.Writer, id string, err error) { s.recoverDeferred(w, r, id, err) })
+	headCtx := deferred.InPlace(ctx)
 	if headGeneric != nil {
-		if err := headGeneric.Render(r.Context(), w); err != nil {
+		if err := headGeneric.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
 	if head != nil {
-		if err := head.Render(r.Context(), w); err != nil {
+		if err := head.Render(headCtx, w); err != nil {
+			return err
+		}
+	}
+	if tabID := w.Header().Get(tabs.Header); tabID != "" {
+		// Write the fetch Datapages-Instance header injector.
+		if _, err := io.WriteString(w, `
+	<script>{
+		const o = globalThis.fetch.bind(globalThis)
+		globalThis.fetch=(i,init={}) => {
+			const isReq=i instanceof Request
+			const r=isReq ? i:new Request(i,init)
+			if (r.headers.get("Datastar-Request")!=="true" ||
+				new URL(r.url).origin!==location.origin
+			) return isReq ? o(r,init):o(r)
+			const h=new Headers(r.headers)
+			h.set("`+tabs.Header+`","`+tabID+`")
+			return o(new Request(r,{...init,headers:h}))
+		}
+	}</script>`); err != nil {
 			return err
 		}
 	}
@@ -153,7 +208,7 @@ func (s *Server) writeHTML(
 		return err
 	}
 	if body != nil {
-		if err := body.Render(r.Context(), w); err != nil {
+		if err := body.Render(ctx, w); err != nil {
 			return err
 		}
 	}
@@ -166,6 +221,9 @@ func (s *Server) writeHTML(
 			return err
 		}
 	}
+	if deferreds.Len() > 0 {
+		deferreds.Resolve(r.Context(), w)
+	}
 	_, err = io.WriteString(w, "</body></html>")
 	return err
 }
@@ -187,21 +245,41 @@ func (s *Server) handleStreamRequest(
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
+	// A server shutting down opens no streams, the client reconnects
+	// to another instance.
+	if s.Draining() {
+		http.Error(w,
+			http.StatusText(http.StatusServiceUnavailable),
+			http.StatusServiceUnavailable)
+		return
+	}
 
 	streamID := datapages.StreamID(s.streamSeq.Add(1))
+	reqlog.SetStream(r.Context(), uint64(streamID))
+
+	// A stream would wait for events that can't arrive while the broker is
+	// down. It's refused, and closed once the broker goes down later, so the
+	// client reconnects and renders what it missed when the broker is back.
+	brokerUp, brokerChanged := messaging.StatusOf(s.messageBroker)
+	if !brokerUp {
+		s.httpErrIntern(w, r, nil, "opening stream", messaging.ErrBrokerUnavailable)
+		return
+	}
 
 	// The subscription is established before the response head goes out.
 	// A client learns the stream is open by reading that head and may dispatch
 	// immediately after, which must not reach the broker before this.
 	ctx := r.Context()
-	sub, err := s.messageBroker.Subscribe(ctx, s.messageBrokerMetrics, subjects...)
+	sub, err := s.messageBroker.Subscribe(ctx, s.messageBrokerMetrics,
+		subject.InNamespace(s.subjectPrefix, subjects)...)
 	if err != nil {
 		// Nothing has been written yet, so the error can still carry a status.
 		s.httpErrIntern(w, r, nil, "subscribing to message broker", err)
 		return
 	}
 
-	sse := datastar.NewSSE(w, r, datastar.WithCompression())
+	// The heartbeats and the retry hint go out beside the events of sse.
+	sse, sseW := dpsse.NewSSE(w, r)
 
 	subC := sub.C()
 	if onOpen != nil {
@@ -225,10 +303,29 @@ func (s *Server) handleStreamRequest(
 	}
 
 	go func() {
-		select {
-		case <-sessionClosed:
-		case <-r.Context().Done():
-		case <-s.ShutdownCh():
+		heartbeat, expired, stopTimers := s.StreamTimers()
+		defer stopTimers()
+		shutdown := s.ShutdownCh()
+		var drained <-chan time.Time
+		// A heartbeat goes out on the stream and the wait goes on, so does
+		// the drain the shutdown begins. Any other case ends the stream.
+		for {
+			select {
+			case <-heartbeat:
+				_ = dpsse.Heartbeat(sseW)
+				continue
+			case <-shutdown:
+				shutdown, drained = nil, s.DrainTimer()
+				continue
+			case <-drained:
+				_ = dpsse.Expire(sseW, s.DrainRetry())
+			case <-expired:
+				_ = dpsse.Expire(sseW, s.StreamRetry())
+			case <-sessionClosed:
+			case <-r.Context().Done():
+			case <-brokerChanged:
+			}
+			break
 		}
 		sub.Close()
 		if onClose != nil {
@@ -244,7 +341,20 @@ type Server struct {
 	messageBroker        messaging.Broker
 	messageBrokerMetrics brokerMetrics
 	streamSeq            atomic.Uint64
-	app                  *app.App
+	// subjectPrefix is what every subject starts with on the wire,
+	// empty without datapages.WithSubjectPrefix.
+	subjectPrefix string
+	// tracer is nil without datapages.WithTracerProvider.
+	tracer     tracing.Tracer
+	app        *app.App
+	eventCodec codec.Codec
+	eventCache *eventcache.Cache
+	// jsonEvents is true when eventCodec is codec.JSON, which the generated
+	// encoders produce the payload of without reflection.
+	jsonEvents bool
+	// brokerInterceptors are those of datapages.WithBrokerInterceptors,
+	// which datapages.DispatchTo installs on the publisher it's given.
+	brokerInterceptors []messaging.Interceptor
 	*auth.Manager[app.SessionData]
 }
 
@@ -260,6 +370,10 @@ type Server struct {
 //   - datapages.WithHTTPServer
 //   - datapages.WithDatastarJS
 //   - datapages.WithAssets
+//   - datapages.WithSubjectPrefix
+//   - datapages.WithBrokerInterceptors
+//   - datapages.WithTracerProvider
+//   - datapages.WithEventCodec
 //   - datapages.WithSessionManager (required)
 //   - datapages.WithSessions
 //   - datapages.WithCSRFProtection
@@ -275,6 +389,10 @@ func (s *Server) Init(
 		return errors.New("unexpected option WithPrometheus: " +
 			"the server is generated with datapages.DisablePrometheus")
 	}
+	if cfg.MeterProvider != nil {
+		return errors.New("unexpected option WithMeterProvider: " +
+			"the server is generated with datapages.DisablePrometheus")
+	}
 	if sessionManager == nil {
 		return errors.New("missing option WithSessionManager")
 	}
@@ -288,15 +406,31 @@ func (s *Server) Init(
 	s.Core = httpserve.NewCore(cfg, "")
 	s.app = app
 	s.messageBroker = messageBroker
+	s.tracer = tracing.NewTracer(cfg.TracerProvider)
+	if cfg.SubjectPrefix != "" {
+		s.subjectPrefix = subject.Prefix(cfg.SubjectPrefix)
+	}
 	s.messageBrokerMetrics = brokerMetrics{}
+	s.eventCodec = cfg.EventCodec
+	s.eventCache = eventcache.New(eventcache.DefaultSize)
+	_, s.jsonEvents = cfg.EventCodec.(codec.JSON)
+	s.brokerInterceptors = cfg.BrokerInterceptors
 
 	if si, ok := s.messageBroker.(messaging.StreamInitializer); ok {
-		if err := si.InitStreams(MessageBrokerStreamSubjects()); err != nil {
+		subjects := subject.InNamespace(s.subjectPrefix, MessageBrokerStreamSubjects())
+		if err := si.InitStreams(subjects); err != nil {
 			return fmt.Errorf("initializing message broker streams: %w", err)
 		}
 	}
 	s.Manager = auth.NewManager(s.Core, sessionManager, cfg, nil)
 
+	s.AddReadinessCheck("broker", func(ctx context.Context) error {
+		return messaging.Ping(ctx, s.messageBroker)
+	})
+	if p, ok := sessionManager.(sessions.Pinger); ok {
+		s.AddReadinessCheck("sessions", p.Ping)
+	}
+
 	setupHandlers(s)
 
 	s.Build()
@@ -336,6 +470,10 @@ func evSubjPageIndex(userID string) []string {
 	}
 }
 
+var evSubjPageMonitor = []string{}
+
+var evSubjPageNotes = []string{}
+
 func setupHandlers(s *Server) {
 	// Pages
 	s.Mux().HandleFunc(
@@ -353,6 +491,18 @@ func setupHandlers(s *Server) {
 	s.Mux().HandleFunc(
 		"GET /login/{$}",
 		s.handlePageLoginGET)
+	s.Mux().HandleFunc(
+		"GET /monitor/{$}",
+		s.handlePageMonitorGET)
+	s.Mux().HandleFunc(
+		"GET /monitor/_$/{$}",
+		s.handlePageMonitorGETStream)
+	s.Mux().HandleFunc(
+		"GET /notes/{$}",
+		s.handlePageNotesGET)
+	s.Mux().HandleFunc(
+		"GET /notes/_$/{$}",
+		s.handlePageNotesGETStream)
 	s.Mux().HandleFunc(
 		"GET /secret/{$}",
 		s.handlePageSecretGET)
@@ -374,13 +524,17 @@ func setupHandlers(s *Server) {
 	s.Mux().HandleFunc(
 		"POST /login/rename/{$}",
 		s.handlePageLoginPOSTRename)
+	s.Mux().HandleFunc(
+		"POST /notes/write/{$}",
+		s.handlePageNotesPOSTWrite)
 }
 
 func (s *Server) httpErrIntern(
-	w http.ResponseWriter, _ *http.Request,
+	w http.ResponseWriter, r *http.Request,
 	_ *datastar.ServerSentEventGenerator, msg string, err error,
 ) {
-	s.LogErr(msg, err)
+	s.LogErrCtx(r.Context(), msg, err)
+	tracing.FailRequest(r, err)
 	switch {
 	case errors.Is(err, datapages.ErrBadRequest):
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
@@ -395,7 +549,18 @@ func (s *Server) httpErrIntern(
 	}
 }
 
+func (s *Server) recoverDeferred(
+	w io.Writer, r *http.Request, id string, err error,
+) {
+	err = datapages.DeferredError{Selector: "#" + id, Err: err}
+	s.LogErrCtx(r.Context(), "resolving deferred component", err)
+}
+
 func (s *Server) handlePOSTSignOut(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "App.POSTSignOut")
+	defer span.End()
+	reqlog.SetHandler(r, "App.POSTSignOut")
+
 	sess, sessToken, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -417,6 +582,10 @@ func (s *Server) handlePOSTSignOut(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageIndex.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageIndex.GET")
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -454,12 +623,13 @@ func (s *Server) handlePageIndexGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, genericHead, nil, body, bodyAttrs, bodySuffix,
 	); err != nil {
-		s.LogErr("rendering PageIndex", err)
+		s.LogErrCtx(r.Context(), "rendering PageIndex", err)
 		return
 	}
 }
 
 func (s *Server) handlePageIndexGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageIndex.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -492,34 +662,50 @@ func (s *Server) handlePageIndexGETStream(w http.ResponseWriter, r *http.Request
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventNotice app.EventNotice
-			var eventBroadcast app.EventBroadcast
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch {
 				case strings.HasPrefix(msg.Subject, EvSubjPrefNotice):
-					eventNotice = app.EventNotice{}
-					if err := json.Unmarshal(msg.Data, &eventNotice); err != nil {
-						s.LogErr("unmarshaling EventNotice JSON", err)
+					eventNotice, err := eventcache.Decode[app.EventNotice](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventNotice", err)
 						continue
 					}
-					if err := p.OnNotice(eventNotice, dpsse.New(sse), sess); err != nil {
-						s.LogErr("handling PageIndex.OnNotice", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageIndex.OnNotice", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageIndex.OnNotice", msg)
+						defer span.End()
+						if err := p.OnNotice(eventNotice, dpsse.NewContext(ctx, sse), sess); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageIndex.OnNotice", err)
+						}
+					}()
 				case msg.Subject == EvSubjBroadcast:
-					eventBroadcast = app.EventBroadcast{}
-					if err := json.Unmarshal(msg.Data, &eventBroadcast); err != nil {
-						s.LogErr("unmarshaling EventBroadcast JSON", err)
+					eventBroadcast, err := eventcache.Decode[app.EventBroadcast](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventBroadcast", err)
 						continue
 					}
-					if err := p.OnBroadcast(eventBroadcast, dpsse.New(sse)); err != nil {
-						s.LogErr("handling PageIndex.OnBroadcast", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageIndex.OnBroadcast", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageIndex.OnBroadcast", msg)
+						defer span.End()
+						if err := p.OnBroadcast(eventBroadcast, dpsse.NewContext(ctx, sse)); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageIndex.OnBroadcast", err)
+						}
+					}()
 				}
 			}
 		})
 }
 
 func (s *Server) handlePageIndexGETStreamAnon(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageIndex.Stream")
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -543,24 +729,36 @@ func (s *Server) handlePageIndexGETStreamAnon(w http.ResponseWriter, r *http.Req
 			streamID datapages.StreamID,
 			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
 		) {
-			var eventBroadcast app.EventBroadcast
 			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
 				switch msg.Subject {
 				case EvSubjBroadcast:
-					eventBroadcast = app.EventBroadcast{}
-					if err := json.Unmarshal(msg.Data, &eventBroadcast); err != nil {
-						s.LogErr("unmarshaling EventBroadcast JSON", err)
+					eventBroadcast, err := eventcache.Decode[app.EventBroadcast](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventBroadcast", err)
 						continue
 					}
-					if err := p.OnBroadcast(eventBroadcast, dpsse.New(sse)); err != nil {
-						s.LogErr("handling PageIndex.OnBroadcast", err)
-					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageIndex.OnBroadcast", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageIndex.OnBroadcast", msg)
+						defer span.End()
+						if err := p.OnBroadcast(eventBroadcast, dpsse.NewContext(ctx, sse)); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageIndex.OnBroadcast", err)
+						}
+					}()
 				}
 			}
 		})
 }
 
 func (s *Server) handlePageLogGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageLog.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageLog.GET")
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -583,12 +781,16 @@ func (s *Server) handlePageLogGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, genericHead, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageLog", err)
+		s.LogErrCtx(r.Context(), "rendering PageLog", err)
 		return
 	}
 }
 
 func (s *Server) handlePageLoginGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageLogin.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageLogin.GET")
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -611,7 +813,7 @@ func (s *Server) handlePageLoginGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, genericHead, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageLogin", err)
+		s.LogErrCtx(r.Context(), "rendering PageLogin", err)
 		return
 	}
 }
@@ -619,6 +821,10 @@ func (s *Server) handlePageLoginGET(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handlePageLoginPOSTSubmit(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageLogin.POSTSubmit")
+	defer span.End()
+	reqlog.SetHandler(r, "PageLogin.POSTSubmit")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -658,6 +864,10 @@ func (s *Server) handlePageLoginPOSTSubmit(
 func (s *Server) handlePageLoginPOSTNotify(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageLogin.POSTNotify")
+	defer span.End()
+	reqlog.SetHandler(r, "PageLogin.POSTNotify")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -690,6 +900,10 @@ func (s *Server) handlePageLoginPOSTNotify(
 func (s *Server) handlePageLoginPOSTBroadcast(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageLogin.POSTBroadcast")
+	defer span.End()
+	reqlog.SetHandler(r, "PageLogin.POSTBroadcast")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -721,6 +935,10 @@ func (s *Server) handlePageLoginPOSTBroadcast(
 func (s *Server) handlePageLoginPOSTRename(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageLogin.POSTRename")
+	defer span.End()
+	reqlog.SetHandler(r, "PageLogin.POSTRename")
+
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
@@ -746,7 +964,229 @@ func (s *Server) handlePageLoginPOSTRename(
 	}
 }
 
+func (s *Server) handlePageMonitorGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageMonitor.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageMonitor.GET")
+
+	sess, _, ok := s.ReadSession(w, r)
+	if !ok {
+		return
+	}
+
+	p := app.PageMonitor{
+		App: s.app,
+	}
+	body, err := p.GET(r)
+	if err != nil {
+		s.httpErrIntern(w, r, nil, "handling PageMonitor.GET", err)
+		return
+	}
+	genericHead := s.app.Head(sess, r)
+
+	bodyAttrs := func(w http.ResponseWriter) {
+		httpserve.WriteReloadOnVisibility(w)
+	}
+
+	bodySuffix := func(w http.ResponseWriter) {
+
+		_, _ = io.WriteString(w, `data-init="@get('/monitor/_$/')"`)
+	}
+
+	if err := s.writeHTML(
+		w, r, sess, genericHead, nil, body, bodyAttrs, bodySuffix,
+	); err != nil {
+		s.LogErrCtx(r.Context(), "rendering PageMonitor", err)
+		return
+	}
+}
+
+func (s *Server) handlePageMonitorGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageMonitor.Stream")
+	if !s.checkIsDSReq(w, r) {
+		return
+	}
+	sess, sessToken, ok := s.ReadSession(w, r)
+	if !ok {
+		return
+	}
+
+	p := app.PageMonitor{
+		App: s.app,
+	}
+	subjects := evSubjPageMonitor
+	allowed, err := p.AuthorizeWildcard(r, sess)
+	if err != nil {
+		s.httpErrIntern(w, r, nil, "authorizing wildcard subscriptions", err)
+		return
+	}
+	if allowed {
+		// The wildcard handlers receive their events for every value.
+		subjects = append(slices.Clip(subjects),
+			EvSubjNotice,
+		)
+	}
+	s.handleStreamRequest(w, r, sessToken, sess, subjects,
+		nil,
+		nil,
+		func(
+			streamID datapages.StreamID,
+			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
+		) {
+			for msg := range ch {
+				msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)
+				switch {
+				case strings.HasPrefix(msg.Subject, EvSubjPrefNotice):
+					eventNotice, err := eventcache.Decode[app.EventNotice](
+						s.eventCache, s.eventCodec, msg,
+					)
+					if err != nil {
+						s.LogErrCtx(sse.Context(), "decoding EventNotice", err)
+						continue
+					}
+					if v, ok := subject.Values(msg.Subject, EvSubjPrefNotice, 1); ok {
+						eventNotice.Recipient = datapages.SubjectUser(v[0])
+					}
+					func() {
+						ctx := reqlog.Event(sse.Context(), "PageMonitor.OnNotice", msg)
+						ctx, span := tracing.StartEvent(s.tracer, ctx, "PageMonitor.OnNotice", msg)
+						defer span.End()
+						if err := p.OnNotice(eventNotice, dpsse.NewContext(ctx, sse)); err != nil {
+							tracing.Fail(span, err)
+							s.LogErrCtx(ctx, "handling PageMonitor.OnNotice", err)
+						}
+					}()
+				}
+			}
+		})
+}
+
+func (s *Server) handlePageNotesGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageNotes.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageNotes.GET")
+
+	sess, _, ok := s.ReadSession(w, r)
+	if !ok {
+		return
+	}
+
+	tabID, ok := tabs.RerenderID(r.Context())
+	if !ok {
+		tabID = tabs.NewID()
+	}
+	w.Header().Set(tabs.Header, tabID)
+	tabRef, ok := s.tab(w, r, tabID)
+	if !ok {
+		return
+	}
+	tab := datapages.MakeTabState[string](r.Context(), tabRef)
+
+	p := app.PageNotes{
+		App: s.app,
+	}
+	body, err := p.GET(r, tab)
+	if err != nil {
+		s.httpErrIntern(w, r, nil, "handling PageNotes.GET", err)
+		return
+	}
+	genericHead := s.app.Head(sess, r)
+
+	bodyAttrs := func(w http.ResponseWriter) {
+		httpserve.WriteReloadOnVisibility(w)
+	}
+
+	bodySuffix := func(w http.ResponseWriter) {
+
+		_, _ = io.WriteString(w, `data-init="@get('/notes/_$/')"`)
+	}
+
+	if err := s.writeHTML(
+		w, r, sess, genericHead, nil, body, bodyAttrs, bodySuffix,
+	); err != nil {
+		s.LogErrCtx(r.Context(), "rendering PageNotes", err)
+		return
+	}
+}
+
+func (s *Server) handlePageNotesGETStream(w http.ResponseWriter, r *http.Request) {
+	reqlog.SetHandler(r, "PageNotes.Stream")
+	if !s.checkIsDSReq(w, r) {
+		return
+	}
+
+	tabRef, ok := s.readTab(w, r)
+	if !ok {
+		return
+	}
+
+	tabOwner, err := tabRef.Keep(r.Context())
+	if err != nil {
+		s.httpErrIntern(w, r, nil, "keeping tab state", err)
+		return
+	}
+	defer func() {
+		ctx := context.WithoutCancel(r.Context())
+		if err := tabRef.Release(ctx, tabOwner); err != nil {
+			s.LogErrCtx(ctx, "releasing tab state", err)
+		}
+	}()
+	s.handleStreamRequest(w, r, "", datapages.Session[app.SessionData]{}, evSubjPageNotes,
+		nil,
+		nil,
+		func(
+			streamID datapages.StreamID,
+			sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,
+		) {
+			for range ch {
+			}
+		})
+}
+
+func (s *Server) handlePageNotesPOSTWrite(
+	w http.ResponseWriter, r *http.Request,
+) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageNotes.POSTWrite")
+	defer span.End()
+	reqlog.SetHandler(r, "PageNotes.POSTWrite")
+
+	if !s.checkIsDSReq(w, r) {
+		return
+	}
+	// CSRF protection covers every state-changing action, including
+	// the ones that read nothing of the session.
+	if _, _, ok := s.ReadSession(w, r); !ok {
+		return
+	}
+	tabRef, ok := s.readTab(w, r)
+	if !ok {
+		return
+	}
+	tab := datapages.MakeTabState[string](r.Context(), tabRef)
+	var signals datapages.Signals[struct {
+		Text string `json:"text"`
+	}]
+	if err := datastar.ReadSignals(r, &signals.Values); err != nil {
+		s.httpErrBad(w, "reading signals", err)
+		return
+	}
+
+	sse := datastar.NewSSE(w, r, datastar.WithCompression())
+	p := app.PageNotes{
+		App: s.app,
+	}
+	err := p.POSTWrite(r, signals, tab, dpsse.New(sse))
+	if err != nil {
+		s.httpErrIntern(w, r, sse, "handling action PageNotes.Write", err)
+		return
+	}
+}
+
 func (s *Server) handlePageSecretGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageSecret.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageSecret.GET")
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -769,12 +1209,16 @@ func (s *Server) handlePageSecretGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, genericHead, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageSecret", err)
+		s.LogErrCtx(r.Context(), "rendering PageSecret", err)
 		return
 	}
 }
 
 func (s *Server) handlePageTokenGET(w http.ResponseWriter, r *http.Request) {
+	r, span := tracing.StartRequest(s.tracer, r, "PageToken.GET")
+	defer span.End()
+	reqlog.SetHandler(r, "PageToken.GET")
+
 	sess, _, ok := s.ReadSession(w, r)
 	if !ok {
 		return
@@ -797,7 +1241,7 @@ func (s *Server) handlePageTokenGET(w http.ResponseWriter, r *http.Request) {
 	if err := s.writeHTML(
 		w, r, sess, genericHead, nil, body, bodyAttrs, nil,
 	); err != nil {
-		s.LogErr("rendering PageToken", err)
+		s.LogErrCtx(r.Context(), "rendering PageToken", err)
 		return
 	}
 }
@@ -813,24 +1257,72 @@ func (d dispatcherEventNotice) Dispatch(e app.EventNotice) error {
 
 func (d dispatcherEventNotice) DispatchCtx(
 	ctx context.Context, e app.EventNotice,
+) error {
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventNotice) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventNotice,
+) error {
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventNotice) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventNotice,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventNotice: %w", messaging.ErrNoScheduler)
+	}
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventNotice) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventNotice,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventNotice) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventNotice,
 ) error {
 	if !subject.IsToken(string(e.Recipient)) {
 		return fmt.Errorf(
 			"EventNotice.Recipient must be a non-empty subject token, received %q",
 			e.Recipient)
 	}
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventNotice JSON: %w", err)
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventNoticeJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventNotice: %w", err)
 	}
 	subj := "notice." + string(e.Recipient)
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, subj, j)
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at, d.s.subjectPrefix+subj, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", subj, err)
 	}
 	return nil
 }
 
+// appendEventNoticeJSON appends e encoded the way encoding/json encodes it.
+func appendEventNoticeJSON(b []byte, e app.EventNotice) []byte {
+	start := len(b)
+	b = append(b, `,"Recipient":`...)
+	b = jsonenc.AppendString(b, string(e.Recipient))
+	b = append(b, `,"text":`...)
+	b = jsonenc.AppendString(b, string(e.Text))
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
+
 type dispatcherEventBroadcast struct {
 	s   *Server
 	ctx context.Context
@@ -843,13 +1335,60 @@ func (d dispatcherEventBroadcast) Dispatch(e app.EventBroadcast) error {
 func (d dispatcherEventBroadcast) DispatchCtx(
 	ctx context.Context, e app.EventBroadcast,
 ) error {
-	j, err := json.Marshal(e)
-	if err != nil {
-		return fmt.Errorf("marshaling EventBroadcast JSON: %w", err)
+	return d.publish(ctx, d.s.messageBroker, time.Time{}, e)
+}
+
+func (d dispatcherEventBroadcast) DispatchTo(
+	ctx context.Context, p messaging.Publisher, e app.EventBroadcast,
+) error {
+	p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)
+	return d.publish(ctx, p, time.Time{}, e)
+}
+
+func (d dispatcherEventBroadcast) DispatchAt(
+	ctx context.Context, t time.Time, e app.EventBroadcast,
+) error {
+	if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {
+		return fmt.Errorf("scheduling EventBroadcast: %w", messaging.ErrNoScheduler)
 	}
-	err = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, EvSubjBroadcast, j)
+	return d.publish(ctx, d.s.messageBroker, t, e)
+}
+
+func (d dispatcherEventBroadcast) DispatchAfter(
+	ctx context.Context, delay time.Duration, e app.EventBroadcast,
+) error {
+	return d.DispatchAt(ctx, time.Now().Add(delay), e)
+}
+
+// publish publishes e to p, or schedules it for at when at isn't zero.
+func (d dispatcherEventBroadcast) publish(
+	ctx context.Context, p messaging.Publisher, at time.Time, e app.EventBroadcast,
+) error {
+	var j []byte
+	var err error
+	if d.s.jsonEvents {
+		j = appendEventBroadcastJSON(nil, e)
+	} else if j, err = d.s.eventCodec.Marshal(e); err != nil {
+		return fmt.Errorf("encoding EventBroadcast: %w", err)
+	}
+	err = dispatch.Publish(
+		ctx, p, d.s.messageBrokerMetrics, at,
+		d.s.subjectPrefix+EvSubjBroadcast, j,
+	)
 	if err != nil {
 		return fmt.Errorf("publishing subject %q: %w", EvSubjBroadcast, err)
 	}
 	return nil
 }
+
+// appendEventBroadcastJSON appends e encoded the way encoding/json encodes it.
+func appendEventBroadcastJSON(b []byte, e app.EventBroadcast) []byte {
+	start := len(b)
+	b = append(b, `,"text":`...)
+	b = jsonenc.AppendString(b, string(e.Text))
+	if len(b) == start {
+		return append(b, "{}"...)
+	}
+	b[start] = '{'
+	return append(b, '}')
+}
@@ -45,6 +45,12 @@ func PageLog() string { return "/log/" }
 // PageLogin references /login/{$}
 func PageLogin() string { return "/login/" }
 
+// PageMonitor references /monitor/{$}
+func PageMonitor() string { return "/monitor/" }
+
+// PageNotes references /notes/{$}
+func PageNotes() string { return "/notes/" }
+
 // PageSecret references /secret/{$}
 func PageSecret() string { return "/secret/" }
 
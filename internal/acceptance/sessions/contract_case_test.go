@@ -47,6 +47,7 @@ func TestContract(t *testing.T) {
 			href.PageLog(),
 			href.PageToken(),
 			href.PageSecret(),
+			href.PageMonitor(),
 		},
 		Actions: []string{
 			action.POSTPageLoginSubmit(),
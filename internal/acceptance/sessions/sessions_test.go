@@ -103,6 +103,31 @@ func (c *client) get(t *testing.T, path string) (int, string) {
 	return resp.StatusCode, string(b)
 }
 
+// openTab loads the stateful page at path and returns the instance ID
+// of the tab it opened.
+func (c *client) openTab(t *testing.T, path string) string {
+	t.Helper()
+	req, err := http.NewRequestWithContext(
+		context.Background(), http.MethodGet, c.srv.URL+path, nil,
+	)
+	if err != nil {
+		t.Fatalf("building GET %s: %v", path, err)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: status = %d", path, resp.StatusCode)
+	}
+	instance := resp.Header.Get("Datapages-Instance")
+	if instance == "" {
+		t.Fatalf("GET %s opened no tab", path)
+	}
+	return instance
+}
+
 // post sends an action request carrying the visitor's CSRF token.
 func (c *client) post(t *testing.T, path, body string) (int, string) {
 	t.Helper()
@@ -113,6 +138,15 @@ func (c *client) post(t *testing.T, path, body string) (int, string) {
 // or with none when it is empty.
 func (c *client) postWithToken(
 	t *testing.T, path, body, csrfToken string,
+) (int, string) {
+	t.Helper()
+	return c.postInTab(t, path, body, csrfToken, "")
+}
+
+// postInTab is postWithToken sent by the tab with the instance ID instance,
+// by none when it is empty.
+func (c *client) postInTab(
+	t *testing.T, path, body, csrfToken, instance string,
 ) (int, string) {
 	t.Helper()
 	req, err := http.NewRequestWithContext(context.Background(),
@@ -126,6 +160,9 @@ func (c *client) postWithToken(
 	if csrfToken != "" {
 		req.Header.Set("X-CSRF-Token", csrfToken)
 	}
+	if instance != "" {
+		req.Header.Set("Datapages-Instance", instance)
+	}
 	resp, err := c.http.Do(req)
 	if err != nil {
 		t.Fatalf("POST %s: %v", path, err)
@@ -371,6 +408,44 @@ func TestSignInAndOut(t *testing.T) {
 	})
 }
 
+// TestTabStateOfUser covers the ID of a signed-in visitor's tab sent
+// with another session, which reads and changes none of its state.
+func TestTabStateOfUser(t *testing.T) {
+	brokers.Each(t, func(t *testing.T, broker messaging.Broker) {
+		testTabStateOfUser(t, newServer(t, broker))
+	})
+}
+
+func testTabStateOfUser(t *testing.T, srv server) {
+	alice := srv.client(t)
+	alice.signIn(t, "alice", "Al")
+	instance := alice.openTab(t, "/notes/")
+	write := func(c *client, text string) string {
+		t.Helper()
+		status, body := c.postInTab(t, "/notes/write/",
+			`{"text":"`+text+`"}`, c.token, instance)
+		if status != http.StatusOK {
+			t.Fatalf("writing the note: status = %d\n%s", status, body)
+		}
+		return echoed(t, body)
+	}
+	if got := write(alice, "secret"); got != "was=" {
+		t.Fatalf("alice got: %s, want: was=", got)
+	}
+
+	bob := srv.client(t)
+	bob.signIn(t, "bob", "Bo")
+	if got := write(bob, "bob"); got != "was=" {
+		t.Errorf("bob read the note of alice: %s", got)
+	}
+	if got := write(srv.client(t), "guest"); got != "was=" {
+		t.Errorf("a guest read the note of alice: %s", got)
+	}
+	if got := write(alice, "again"); got != "was=secret" {
+		t.Errorf("alice got: %s, want: was=secret", got)
+	}
+}
+
 // TestSessionToken covers the handler parameter that asks for the token
 // instead of the session.
 func TestSessionToken(t *testing.T) {
@@ -668,6 +743,40 @@ func TestAnonymousStream(t *testing.T) {
 	})
 }
 
+// TestWildcardHandler covers a page handling a private event for every user.
+// The stream AuthorizeWildcard allows receives the notice addressed to
+// someone else, with the recipient filled in. Any other stream of the page,
+// signed in or not, must not.
+func TestWildcardHandler(t *testing.T) {
+	brokers.Each(t, func(t *testing.T, broker messaging.Broker) {
+		srv := newServer(t, broker)
+
+		admin := srv.client(t)
+		admin.signIn(t, "admin", "")
+		adminStream := admin.openStreamAt(t, "/monitor/_$/")
+
+		alice := srv.client(t)
+		alice.signIn(t, "alice", "")
+		aliceStream := alice.openStreamAt(t, "/monitor/_$/")
+
+		anonStream := srv.client(t).openStreamAt(t, "/monitor/_$/")
+
+		if status, body := alice.post(t, "/login/notify/",
+			`{"user":"bob","text":"for bob"}`); status != http.StatusOK {
+			t.Fatalf("notifying: status = %d\n%s", status, body)
+		}
+		if !adminStream.saw(`<div id="monitor">bob: for bob</div>`) {
+			t.Error("the authorized stream received nothing")
+		}
+		if !aliceStream.never("for bob") {
+			t.Error("a stream AuthorizeWildcard refused received the event")
+		}
+		if !anonStream.never("for bob") {
+			t.Error("a stream with no session received the event")
+		}
+	})
+}
+
 // --- helpers ---------------------------------------------------------------
 
 type stream struct {
@@ -692,12 +801,18 @@ func (s *stream) requireHealthy() {
 }
 
 func (c *client) openStream(t *testing.T) *stream {
+	t.Helper()
+	return c.openStreamAt(t, "/_$/")
+}
+
+// openStreamAt opens the stream of the page whose stream route is path.
+func (c *client) openStreamAt(t *testing.T, path string) *stream {
 	t.Helper()
 	ctx, cancel := context.WithCancel(context.Background())
 	t.Cleanup(cancel)
 
 	req, err := http.NewRequestWithContext(
-		ctx, http.MethodGet, c.srv.URL+"/_$/", nil,
+		ctx, http.MethodGet, c.srv.URL+path, nil,
 	)
 	if err != nil {
 		t.Fatalf("building stream request: %v", err)
@@ -0,0 +1,100 @@
+// Package app exercises datapages.TabState: every tab showing the counter
+// page counts on its own, in the GET, action and event handlers of the page,
+// and keeps its count for as long as its stream is open.
+package app
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/a-h/templ"
+
+	"github.com/romshark/datapages"
+)
+
+type App struct{}
+
+// Counter is the state of a tab showing PageCounter.
+type Counter struct {
+	N int `json:"n"`
+}
+
+// EventPinged is "pinged"
+type EventPinged struct{}
+
+// EventRefreshed is "refreshed"
+type EventRefreshed struct{}
+
+func count(n int) datapages.Component {
+	return templ.Raw(fmt.Sprintf(`<p id="count">%d</p>`, n))
+}
+
+// PageIndex is /
+type PageIndex struct{ App *App }
+
+func (PageIndex) GET(_ *http.Request) (body datapages.Component, err error) {
+	return templ.Raw(`<main id="main">index</main>`), nil
+}
+
+// PageCounter is /counter
+type PageCounter struct{ App *App }
+
+func (PageCounter) GET(
+	_ *http.Request, tab datapages.TabState[Counter],
+) (body datapages.Component, err error) {
+	c, err := tab.Get()
+	if err != nil {
+		return nil, err
+	}
+	return count(c.N), nil
+}
+
+// POSTIncrement is /counter/increment
+func (PageCounter) POSTIncrement(
+	_ *http.Request, tab datapages.TabState[Counter], sse datapages.SSE,
+) error {
+	var n int
+	if err := tab.Update(func(c *Counter) error {
+		c.N++
+		n = c.N
+		return nil
+	}); err != nil {
+		return err
+	}
+	return sse.PatchElement(count(n))
+}
+
+// POSTPing is /counter/ping
+func (PageCounter) POSTPing(
+	_ *http.Request, pinged datapages.Dispatcher[EventPinged],
+) error {
+	return pinged.Dispatch(EventPinged{})
+}
+
+// OnPinged shows every tab its own count.
+func (PageCounter) OnPinged(
+	event EventPinged, sse datapages.SSE, tab datapages.TabState[Counter],
+) error {
+	c, err := tab.Get()
+	if err != nil {
+		return err
+	}
+	return sse.PatchElementAt(
+		templ.Raw(fmt.Sprintf(`<p id="pinged">%s %d</p>`, tab.ID(), c.N)),
+		"", datapages.PatchModeOuter,
+	)
+}
+
+// POSTRefresh is /counter/refresh
+func (PageCounter) POSTRefresh(
+	_ *http.Request, refreshed datapages.Dispatcher[EventRefreshed],
+) error {
+	return refreshed.Dispatch(EventRefreshed{})
+}
+
+// OnRefreshed re-renders every tab, each with its own count.
+func (PageCounter) OnRefreshed(
+	event EventRefreshed, sse datapages.SSE,
+) (rerender datapages.Rerender, err error) {
+	return true, nil
+}
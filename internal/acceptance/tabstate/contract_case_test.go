@@ -0,0 +1,41 @@
+// Wires the tabstate case into the shared contract suite.
+
+package acceptance_test
+
+import (
+	"testing"
+
+	"github.com/romshark/datapages"
+	"github.com/romshark/datapages/internal/acceptance/contract"
+	"github.com/romshark/datapages/internal/acceptance/tabstate/app"
+	"github.com/romshark/datapages/internal/acceptance/tabstate/app/datapagesgen"
+	"github.com/romshark/datapages/internal/acceptance/tabstate/app/datapagesgen/action"
+	"github.com/romshark/datapages/internal/acceptance/tabstate/app/datapagesgen/href"
+	"github.com/romshark/datapages/modules/messaging"
+	"github.com/romshark/datapages/modules/messaging/inmem"
+)
+
+func TestContract(t *testing.T) {
+	contract.Run(t, contract.Case{
+		NewServer: func(t *testing.T, opts ...any) contract.Server {
+			t.Helper()
+			return mustNewServer(t, &app.App{}, inmem.New(messaging.DefaultBrokerChanBuffer),
+				contract.Options[datapages.ServerOption](opts)...)
+		},
+		WithMiddleware: contract.OptVariadic(datapages.WithMiddleware),
+		WithDatastarJS: contract.Opt(datapages.WithDatastarJS),
+		WithHTTPServer: contract.Opt(datapages.WithHTTPServer),
+		WithLogger:     contract.Opt(datapages.WithLogger),
+		StreamSubjects: datapagesgen.MessageBrokerStreamSubjects,
+		HrefExternal:   href.External,
+		HrefSetLogger:  href.SetLogger,
+		Index:          href.PageCounter(),
+		Links:          []string{href.PageIndex(), href.PageCounter()},
+		StreamPath:     "/counter/_$/",
+		DispatchAction: action.POSTPageCounterPing(),
+		Actions: []string{
+			action.POSTPageCounterIncrement(),
+			action.POSTPageCounterPing(),
+		},
+	})
+}
@@ -0,0 +1,83 @@
+// Drives the streams of ./app over one shared connection: the leader adds
+// the stream of each tab, which keeps the state of its tab and sees its events,
+// and removes it again.
+
+package acceptance_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages"
+	"github.com/romshark/datapages/internal/acceptance/client"
+)
+
+// jsonText is s the way it appears inside a JSON string.
+func jsonText(t *testing.T, s string) string {
+	t.Helper()
+	b, err := json.Marshal(s)
+	require.NoError(t, err)
+	return strings.Trim(string(b), `"`)
+}
+
+// control posts a control request to the connection conn.
+func control(t *testing.T, c *client.Client, conn, op, tab, url, instance string) {
+	t.Helper()
+	body := fmt.Sprintf(`{"conn":%q,"op":%q,"tab":%q,"url":%q,"instance":%q}`,
+		conn, op, tab, url, instance)
+	resp := c.Do(t, c.Request(t, http.MethodPost, "/_$/mux/", body))
+	require.Equal(t, http.StatusNoContent, resp.Status, resp.Body)
+}
+
+func TestSharedStreams(t *testing.T) {
+	c := newClient(t, datapages.WithStreams(datapages.StreamsConfig{Shared: true}))
+
+	pageA, pageB := c.Get(t, "/counter/"), c.Get(t, "/counter/")
+	require.Contains(t, pageA.Body, `"/_$/mux/worker/"`,
+		"the page shell routes its streams through the leader")
+	a, b := pageA.Instance(), pageB.Instance()
+
+	mux := c.OpenStream(t, "/_$/mux/", nil)
+	var conn string
+	require.True(t, client.WaitFor(func() bool {
+		for _, l := range mux.Lines() {
+			if m := regexp.MustCompile(`^data: {"conn":"([^"]+)"}$`).FindStringSubmatch(l); m != nil {
+				conn = m[1]
+				return true
+			}
+		}
+		return false
+	}, client.Await), "the connection has no ID")
+
+	control(t, c, conn, "add", "a", "/counter/_$/", a)
+	control(t, c, conn, "add", "b", "/counter/_$/", b)
+	require.True(t, mux.Saw(`{"tab":"a","status":200,"type":"text/event-stream"}`))
+	require.True(t, mux.Saw(`{"tab":"b","status":200,"type":"text/event-stream"}`))
+
+	req := c.Request(t, http.MethodPost, "/counter/increment/", "{}")
+	req.Header.Set("Datapages-Instance", a)
+	require.Equal(t, http.StatusOK, c.Do(t, req).Status)
+
+	// Each stream sees the state of its own tab.
+	require.Equal(t, http.StatusOK, c.Action(t, http.MethodPost, "/counter/ping/", "{}").Status)
+	require.True(t, mux.Saw(jsonText(t, `<p id="pinged">`+a+` 1</p>`)))
+	require.True(t, mux.Saw(jsonText(t, `<p id="pinged">`+b+` 0</p>`)))
+
+	control(t, c, conn, "remove", "a", "", "")
+	require.True(t, mux.Saw(`{"tab":"a","end":true}`))
+	require.True(t, mux.Never(`{"tab":"b","end":true}`))
+}
+
+func TestSharedStreamsOff(t *testing.T) {
+	c := newClient(t)
+
+	require.NotContains(t, c.Get(t, "/counter/").Body, "/_$/mux/")
+	resp := c.Get(t, "/_$/mux/")
+	require.Equal(t, http.StatusNotFound, resp.Status)
+}
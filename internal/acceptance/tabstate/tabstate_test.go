@@ -0,0 +1,154 @@
+// Drives the tab state of ./app: each tab of the counter page keeps its own
+// count across its GET, actions and event handlers, for as long as its stream
+// is open and the TTL after.
+
+package acceptance_test
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages"
+	"github.com/romshark/datapages/internal/acceptance/client"
+	"github.com/romshark/datapages/internal/acceptance/tabstate/app"
+	"github.com/romshark/datapages/modules/messaging"
+	"github.com/romshark/datapages/modules/messaging/inmem"
+	tabinmem "github.com/romshark/datapages/modules/tabstate/inmem"
+)
+
+func newClient(t *testing.T, opts ...datapages.ServerOption) *client.Client {
+	t.Helper()
+	return client.New(t, mustNewServer(
+		t, &app.App{}, inmem.New(messaging.DefaultBrokerChanBuffer), opts...,
+	))
+}
+
+// increment counts one up in tab and returns the count it answered with.
+func increment(t *testing.T, tab *client.Tab) string {
+	t.Helper()
+	resp := tab.Act(t, http.MethodPost, "/counter/increment/", "{}")
+	require.Equal(t, http.StatusOK, resp.Status, resp.Body)
+	m := regexp.MustCompile(`<p id="count">(\d+)</p>`).FindStringSubmatch(resp.Body)
+	require.NotNil(t, m, "no count in %q", resp.Body)
+	return m[1]
+}
+
+func TestTabsCountApart(t *testing.T) {
+	c := newClient(t)
+
+	a := c.OpenTab(t, "/counter/", "")
+	b := c.OpenTab(t, "/counter/", "")
+	require.NotEqual(t, a.InstanceID(), b.InstanceID())
+
+	require.Equal(t, "1", increment(t, a))
+	require.Equal(t, "2", increment(t, a))
+	require.Equal(t, "1", increment(t, b), "each tab has a state of its own")
+
+	// Event handlers see the state of the tab of their stream.
+	resp := c.Action(t, http.MethodPost, "/counter/ping/", "{}")
+	require.Equal(t, http.StatusOK, resp.Status)
+	require.True(t, a.Saw(`<p id="pinged">`+a.InstanceID()+` 2</p>`))
+	require.True(t, b.Saw(`<p id="pinged">`+b.InstanceID()+` 1</p>`))
+}
+
+func TestPageLoad(t *testing.T) {
+	c := newClient(t)
+
+	resp := c.Get(t, "/counter/")
+	require.Equal(t, http.StatusOK, resp.Status)
+	id := resp.Instance()
+	require.NotEmpty(t, id)
+	require.Contains(t, resp.Body, `"`+id+`"`, "the page hands its fetches the ID")
+	require.Contains(t, resp.Body, `<p id="count">0</p>`)
+	require.NotEqual(t, id, c.Get(t, "/counter/").Instance(),
+		"every page load is a new tab")
+
+	resp = c.Get(t, "/")
+	require.Empty(t, resp.Instance(), "a page without tab state has no tabs")
+	require.NotContains(t, resp.Body, "Datapages-Instance")
+}
+
+func TestGETOfTab(t *testing.T) {
+	c := newClient(t)
+	tab := c.OpenTab(t, "/counter/", "")
+	increment(t, tab)
+
+	// A GET carrying the ID of a tab is a new tab all the same,
+	// it never reads the state of another one.
+	req := c.Request(t, http.MethodGet, "/counter/", "")
+	req.Header.Set("Datapages-Instance", tab.InstanceID())
+	resp := c.Do(t, req)
+	require.NotEqual(t, tab.InstanceID(), resp.Instance())
+	require.Contains(t, resp.Body, `<p id="count">0</p>`)
+	require.Equal(t, "2", increment(t, tab), "the tab kept its state")
+}
+
+// TestRerenderOfTab covers the GET re-rendering the page of a stream:
+// it renders the tab of the stream, not a new one.
+func TestRerenderOfTab(t *testing.T) {
+	c := newClient(t)
+	a := c.OpenTab(t, "/counter/", "")
+	b := c.OpenTab(t, "/counter/", "")
+	increment(t, a)
+	increment(t, a)
+	increment(t, b)
+
+	resp := c.Action(t, http.MethodPost, "/counter/refresh/", "{}")
+	require.Equal(t, http.StatusOK, resp.Status)
+	require.True(t, a.Saw(`<p id="count">2</p>`), "the re-render lost the count")
+	require.True(t, b.Saw(`<p id="count">1</p>`), "the re-render lost the count")
+	require.Equal(t, "3", increment(t, a), "the re-render replaced the state")
+}
+
+func TestMissingInstance(t *testing.T) {
+	c := newClient(t)
+
+	resp := c.Action(t, http.MethodPost, "/counter/increment/", "{}")
+	require.Equal(t, http.StatusBadRequest, resp.Status)
+
+	req := c.Request(t, http.MethodPost, "/counter/increment/", "{}")
+	req.Header.Set("Datapages-Instance", "not-an-instance-id")
+	resp = c.Do(t, req)
+	require.Equal(t, http.StatusBadRequest, resp.Status)
+
+	// An action that takes no state doesn't need the ID.
+	resp = c.Action(t, http.MethodPost, "/counter/ping/", "{}")
+	require.Equal(t, http.StatusOK, resp.Status)
+
+	req = c.Request(t, http.MethodGet, "/counter/_$/", "")
+	req.Header.Set("Datastar-Request", "true")
+	resp = c.Do(t, req)
+	require.Equal(t, http.StatusBadRequest, resp.Status)
+}
+
+func TestReconnectKeepsState(t *testing.T) {
+	c := newClient(t)
+	tab := c.OpenTab(t, "/counter/", "")
+	increment(t, tab)
+
+	tab.Close()
+	tab.Reopen(t)
+	require.Equal(t, "2", increment(t, tab), "the state outlived the stream")
+}
+
+func TestStateExpires(t *testing.T) {
+	c := newClient(t, datapages.WithTabState(datapages.TabStateConfig{
+		TTL: 50 * time.Millisecond, Store: tabinmem.New(),
+	}))
+	tab := c.OpenTab(t, "/counter/", "")
+	increment(t, tab)
+
+	// The open stream keeps the state past the TTL.
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, "2", increment(t, tab))
+
+	// Each count refreshes the TTL, which the polling outlasts.
+	tab.Close()
+	require.Eventually(t, func() bool {
+		return increment(t, tab) == "1"
+	}, time.Second, 60*time.Millisecond, "the state outlived the TTL")
+}
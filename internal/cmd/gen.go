@@ -3,6 +3,7 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"go/token"
 	"io"
 	"os/exec"
 	"path/filepath"
@@ -115,6 +116,7 @@ func genApp(
 	if err := generator.Generate(
 		genDir, serverscan.GenSubdir, m, 0o644, generator.Options{
 			Prometheus:      app.Prometheus,
+			OTelMetrics:     app.OTelMetrics,
 			AssetsURLPrefix: assets.URLPrefix,
 			AssetsDir:       assets.Dir,
 			AppDir:          app.Dir,
@@ -151,36 +153,40 @@ func genApp(
 
 func parseApp(appDir string, stderr io.Writer) (*model.App, error) {
 	app, errs := datapagesparser.Parse(appDir)
-	if errs.Len() == 0 {
+	if errs.Len() == 0 && errs.WarnLen() == 0 {
 		return app, nil
 	}
 	loc := color.New(color.FgCyan)
 	msg := color.New(color.FgRed, color.Bold)
+	warn := color.New(color.FgYellow, color.Bold)
 	fix := color.New(color.FgGreen)
 	fixLabel := color.New(color.FgGreen, color.Bold)
 	count := color.New(color.FgRed, color.Bold)
 	if wantColorFor(stderr) {
 		loc.EnableColor()
 		msg.EnableColor()
+		warn.EnableColor()
 		fix.EnableColor()
 		fixLabel.EnableColor()
 		count.EnableColor()
 	} else {
 		loc.DisableColor()
 		msg.DisableColor()
+		warn.DisableColor()
 		fix.DisableColor()
 		fixLabel.DisableColor()
 		count.DisableColor()
 	}
-	for i := 0; i < errs.Len(); i++ {
-		pos, innerErr := errs.Entry(i)
-		if i > 0 {
+	printed := 0
+	report := func(pos token.Position, innerErr error, text string) {
+		if printed > 0 {
 			_, _ = fmt.Fprintln(stderr)
 		}
+		printed++
 		_, _ = fmt.Fprintf(
 			stderr, "%s %s\n",
 			loc.Sprintf("at %s:%d:%d:", pos.Filename, pos.Line, pos.Column),
-			msg.Sprint(innerErr.Error()),
+			text,
 		)
 		if hint := errsuggest.Suggest(innerErr); hint != "" {
 			if label, rest, ok := strings.Cut(hint, " "); ok && label == "fix:" {
@@ -190,7 +196,19 @@ func parseApp(appDir string, stderr io.Writer) (*model.App, error) {
 			}
 		}
 	}
+	// Warnings don't fail parsing, they're printed ahead of the errors.
+	for i := 0; i < errs.WarnLen(); i++ {
+		pos, innerErr := errs.WarnEntry(i)
+		report(pos, innerErr, warn.Sprint("warning: "+innerErr.Error()))
+	}
+	for i := 0; i < errs.Len(); i++ {
+		pos, innerErr := errs.Entry(i)
+		report(pos, innerErr, msg.Sprint(innerErr.Error()))
+	}
 	_, _ = fmt.Fprintln(stderr)
+	if errs.Len() == 0 {
+		return app, nil
+	}
 	// Return the partial model alongside the error: callers may still
 	// generate code from whatever was successfully parsed.
 	return app, fmt.Errorf("parsing app package: %s",
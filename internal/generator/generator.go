@@ -18,6 +18,9 @@ const GeneratedHeader = "// Code generated by github.com/romshark/datapages; DO
 type Options struct {
 	// Prometheus enables generation of Prometheus metrics instrumentation.
 	Prometheus bool
+	// OTelMetrics enables generation of the same instrumentation recording
+	// through OpenTelemetry. It's exclusive with Prometheus.
+	OTelMetrics bool
 	// AssetsURLPrefix is the URL path prefix for serving static asset files.
 	// When non-empty, the generator emits an assets subpackage with a URLPrefix
 	// constant and enables WithAssets. When empty, asset serving is disabled.
@@ -74,6 +77,7 @@ func Generate(
 
 	w.Reset()
 	w.prometheus = opts.Prometheus
+	w.otelMetrics = opts.OTelMetrics
 	w.assetsURLPrefix = opts.AssetsURLPrefix
 	w.assetsDir = opts.AssetsDir
 	w.appDir = opts.AppDir
@@ -91,6 +95,7 @@ func Generate(
 	if err := render("app_gen.go", func() {
 		w.Reset()
 		w.prometheus = opts.Prometheus
+		w.otelMetrics = opts.OTelMetrics
 		w.assetsURLPrefix = opts.AssetsURLPrefix
 		w.assetsDir = opts.AssetsDir
 		w.appDir = opts.AppDir
@@ -111,6 +116,12 @@ func Generate(
 	}); err != nil {
 		return err
 	}
+	if err := render(filepath.Join("signals", "signals_gen.go"), func() {
+		w.Reset()
+		w.WritePkgSignals(m)
+	}); err != nil {
+		return err
+	}
 
 	for _, f := range rendered {
 		if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
@@ -144,6 +155,7 @@ func generateStubs(dstDir, pkgName string, perm os.FileMode, hasAssets bool) err
 		{dstDir, pkgName, "app_gen.go"},
 		{filepath.Join(dstDir, "action"), "action", "action_gen.go"},
 		{filepath.Join(dstDir, "href"), "href", "href_gen.go"},
+		{filepath.Join(dstDir, "signals"), "signals", "signals_gen.go"},
 	}
 	assetsDir := filepath.Join(dstDir, "assets")
 	if hasAssets {
@@ -65,6 +65,7 @@ func TestExamplesAreUpToDate(t *testing.T) {
 				require.NoError(t, generator.Generate(
 					got, serverscan.GenSubdir, app, 0o644, generator.Options{
 						Prometheus:      a.Prometheus,
+						OTelMetrics:     a.OTelMetrics,
 						AssetsURLPrefix: app.Assets.URLPrefix,
 						AssetsDir:       app.Assets.Dir,
 						AppDir:          a.Dir,
@@ -28,6 +28,12 @@ func dispatcherTypeName(eventTypeName string) string {
 	return "dispatcher" + eventTypeName
 }
 
+// eventJSONAppenderName is the generated function that encodes
+// an event to JSON without reflection.
+func eventJSONAppenderName(eventTypeName string) string {
+	return "append" + eventTypeName + "JSON"
+}
+
 // dispatchVarName is the generated variable name of a dispatcher.
 // The prefix keeps the closures of different handlers apart inside one
 // generated function; the event name keeps a handler's own closures apart.
@@ -97,9 +103,17 @@ func stripPagePrefix(typeName string) string {
 	return strings.TrimPrefix(typeName, "Page")
 }
 
-// pageHasStream returns true if the page has event handlers and needs a stream.
+// pageHasStream returns true if the page needs a stream: it has event
+// handlers or stream hooks, or keeps tab state alive for as long as it's open.
 func pageHasStream(p *model.Page) bool {
-	return len(p.EventHandlers) > 0 || p.StreamOpen != nil || p.StreamClose != nil
+	return len(p.EventHandlers) > 0 || p.StreamOpen != nil || p.StreamClose != nil ||
+		p.TabState != nil
+}
+
+// pageStreamUsesPage returns true if the stream of the page calls its handlers.
+func pageStreamUsesPage(p *model.Page) bool {
+	return len(p.EventHandlers) > 0 || p.StreamOpen != nil || p.StreamClose != nil ||
+		p.AuthorizeWildcard != nil
 }
 
 // pageStreamNeedsAuth returns true if handling the page's SSE stream requires
@@ -115,7 +129,9 @@ func pageStreamNeedsAuth(
 			return true
 		}
 	}
-	for _, h := range []*model.Handler{p.StreamOpen, p.StreamClose} {
+	for _, h := range []*model.Handler{
+		p.StreamOpen, p.StreamClose, p.AuthorizeWildcard,
+	} {
 		if h == nil {
 			continue
 		}
@@ -127,9 +143,13 @@ func pageStreamNeedsAuth(
 }
 
 // pageHasPrivateEvent returns true if any event handler on the page
-// handles a private event (has SubjectUser).
+// handles a private event (has SubjectUser). This and the helpers below leave
+// out wildcard handlers, which subscribe by neither the user nor the signals.
 func pageHasPrivateEvent(p *model.Page, eventByName map[string]*model.Event) bool {
 	for _, eh := range p.EventHandlers {
+		if eh.Wildcard {
+			continue
+		}
 		if e, ok := eventByName[eh.EventTypeName]; ok && e.IsPrivate() {
 			return true
 		}
@@ -141,6 +161,9 @@ func pageHasPrivateEvent(p *model.Page, eventByName map[string]*model.Event) boo
 // handles a signal-scoped event (has subject fields with signal tags).
 func pageHasSignalScopedEvent(p *model.Page, eventByName map[string]*model.Event) bool {
 	for _, eh := range p.EventHandlers {
+		if eh.Wildcard {
+			continue
+		}
 		if e, ok := eventByName[eh.EventTypeName]; ok && e.IsSignalScoped() {
 			return true
 		}
@@ -155,7 +178,7 @@ func pageSignalSubjectFields(p *model.Page, eventByName map[string]*model.Event)
 	var fields []model.SubjectField
 	for _, eh := range p.EventHandlers {
 		e, ok := eventByName[eh.EventTypeName]
-		if !ok {
+		if !ok || eh.Wildcard {
 			continue
 		}
 		for _, sf := range e.SubjectFields {
@@ -175,7 +198,7 @@ func pageHasAnonStream(p *model.Page, eventByName map[string]*model.Event) bool
 	hasPrivate := false
 	for _, eh := range p.EventHandlers {
 		e, ok := eventByName[eh.EventTypeName]
-		if !ok {
+		if !ok || eh.Wildcard {
 			continue
 		}
 		if e.IsPrivate() {
@@ -187,6 +210,30 @@ func pageHasAnonStream(p *model.Page, eventByName map[string]*model.Event) bool
 	return hasPublic && hasPrivate
 }
 
+// pageWildcardHandlers returns the event handlers of the page marked
+// //datapages:wildcard, which a stream gets once AuthorizeWildcard allows it.
+func pageWildcardHandlers(p *model.Page) []*model.EventHandler {
+	var hs []*model.EventHandler
+	for _, eh := range p.EventHandlers {
+		if eh.Wildcard {
+			hs = append(hs, eh)
+		}
+	}
+	return hs
+}
+
+// withoutWildcards returns p without its wildcard event handlers,
+// whose subjects the evSubj builders leave to the stream handler.
+func withoutWildcards(p *model.Page) *model.Page {
+	if len(pageWildcardHandlers(p)) == 0 {
+		return p
+	}
+	scoped := *p
+	scoped.EventHandlers = slices.DeleteFunc(slices.Clone(p.EventHandlers),
+		func(eh *model.EventHandler) bool { return eh.Wildcard })
+	return &scoped
+}
+
 // routeStreamPath returns the SSE stream path for a page route.
 //   - "/settings/" -> "/settings/_$/"
 //   - "/post/{slug}/" -> "/post/{slug}/_$/"
@@ -299,12 +346,18 @@ type appUsage struct {
 	createSession bool
 	// closeSession: func (s *Server) closeSession(...)
 	closeSession bool
+	// deferDispatch: whether any handler returns datapages.DeferDispatch,
+	// which gives every dispatcher the buffer it holds events back in.
+	deferDispatch bool
 	// httpRedirect: func httpRedirect(...)
 	httpRedirect bool
 	// stream: func (s *Server) handleStreamRequest(...)
 	stream bool
 	// streamAuth: whether any stream handler needs auth (page has private events).
 	streamAuth bool
+	// streamDrain: whether the App declares the StreamDrain hook,
+	// which handleStreamRequest calls as the shutdown begins.
+	streamDrain bool
 	// dsRequest: func (s *Server) checkIsDSReq(...)
 	dsRequest bool
 	// recoverError: httpErrIntern asks httpserve.IsDatastarRequest for an app that has
@@ -328,9 +381,15 @@ type appUsage struct {
 	// userSubjects: whether any event addresses a user, which makes the ID of
 	// the session owner name a subject.
 	userSubjects bool
+	// events: whether any handler dispatches or handles an event,
+	// which gives the server its event codec and decode cache.
+	events bool
 	// privateStreams: func (s *Server) checkUserSubject(...), needed by any
 	// page that subscribes to an event addressed to the session owner.
 	privateStreams bool
+	// tabState: whether any page keeps tab state, which makes writeHTML
+	// hand the tab instance ID to the fetches of the page.
+	tabState bool
 }
 
 // needsCheckIsDSReq returns true if the checkIsDSReq method must be emitted.
@@ -398,6 +457,11 @@ func computeAppUsage(m *model.App) appUsage {
 		// RecoverError always receives a datapages.SSE.
 		u.datapagesSSE = true
 	}
+	if m.StreamDrain != nil {
+		// StreamDrain always receives a datapages.SSE.
+		u.streamDrain = true
+		u.datapagesSSE = true
+	}
 
 	checkHandler := func(h *model.Handler) {
 		if h.InputSession != nil {
@@ -413,6 +477,9 @@ func computeAppUsage(m *model.App) appUsage {
 		if h.OutputCloseSession != nil {
 			u.closeSession = true
 		}
+		if h.OutputDeferDispatch != nil {
+			u.deferDispatch = true
+		}
 		if h.OutputRedirect != nil {
 			u.httpRedirect = true
 		}
@@ -439,6 +506,7 @@ func computeAppUsage(m *model.App) appUsage {
 		eventByName[e.TypeName] = e
 	}
 
+	u.events = usesEvents(m)
 	u.dispatchSubjects = dispatchesSubjectFields(m, eventByName)
 	for _, e := range m.Events {
 		if e.HasSubjectUser() {
@@ -457,6 +525,11 @@ func computeAppUsage(m *model.App) appUsage {
 		if p.GET != nil {
 			checkHandler(p.GET.Handler)
 		}
+		if p.TabState != nil {
+			u.tabState = true
+			// A stream or an action without a valid tab ID is a bad request.
+			u.httpErrBad = true
+		}
 		if pageHasStream(p) {
 			u.stream = true
 			// Event handlers and stream hooks receive a datapages.SSE.
@@ -514,6 +587,8 @@ type Writer struct {
 	fields []structFieldInfo
 	// prometheus defines whether to generate Prometheus metrics code
 	prometheus bool
+	// otelMetrics defines whether to generate OpenTelemetry metrics code
+	otelMetrics bool
 	// assetsURLPrefix is the URL path prefix for static files (empty = disabled)
 	assetsURLPrefix string
 	// assetsDir is the subdirectory within the app package for
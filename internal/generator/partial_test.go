@@ -60,6 +60,7 @@ func TestGeneratePartialModels(t *testing.T) {
 					"app_gen.go",
 					filepath.Join("action", "action_gen.go"),
 					filepath.Join("href", "href_gen.go"),
+					filepath.Join("signals", "signals_gen.go"),
 				} {
 					_, err := os.Stat(filepath.Join(dst, f))
 					require.NoError(t, err, "no stub written for %s", f)
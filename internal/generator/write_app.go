@@ -15,6 +15,9 @@ var appStaticContent string
 //go:embed app_static_prom.go.txt
 var appStaticPromContent string
 
+//go:embed app_static_otel.go.txt
+var appStaticOTelContent string
+
 //go:embed app_static_noprom.go.txt
 var appStaticNoPromContent string
 
@@ -30,12 +33,15 @@ func (w *Writer) WriteApp(pkgName string, m *model.App) {
 	w.usage = computeAppUsage(m)
 	w.setSessionType(m)
 
-	w.writeAppHeader(pkgName, m.PkgPath, needsJSON(m))
+	w.writeAppHeader(pkgName, m.PkgPath)
 	w.Raw(appStaticContent)
 	w.writeAssetsFileSystem()
-	if w.prometheus {
+	switch {
+	case w.prometheus:
 		w.Raw(appStaticPromContent)
-	} else {
+	case w.otelMetrics:
+		w.Raw(appStaticOTelContent)
+	default:
 		w.Raw(appStaticNoPromContent)
 	}
 	w.Raw(appStaticContent2)
@@ -50,6 +56,41 @@ func (s *Server) httpErrBad(w http.ResponseWriter, msg string, err error) {
 	if w.usage.needsCheckIsDSReq() {
 		w.writeCheckIsDSReq()
 	}
+	if w.usage.tabState {
+		w.Raw(`
+// readTab returns the tab whose instance ID the stream or an action of
+// a stateful page carries. Without a valid one it answers a bad request.
+func (s *Server) readTab(w http.ResponseWriter, r *http.Request) (tabs.Tab, bool) {
+	tabID, err := tabs.FromRequest(r)
+	if err == nil && tabID == "" {
+		err = errors.New("missing " + tabs.Header + " header")
+	}
+	if err != nil {
+		s.httpErrBad(w, "reading tab instance", err)
+		return tabs.Tab{}, false
+	}
+	return s.tab(w, r, tabID)
+}
+`)
+		if w.usage.hasSession {
+			w.Raw(`
+// tab returns the tab with the instance ID tabID, which belongs to the user
+// of the session r carries. The ID of a tab reaching the server with another
+// session finds none of its state.
+func (s *Server) tab(w http.ResponseWriter, r *http.Request, tabID string) (tabs.Tab, bool) {
+	userID, ok := s.SessionUserID(w, r)
+	return s.Tab(tabID, userID), ok
+}
+`)
+		} else {
+			w.Raw(`
+// tab returns the tab with the instance ID tabID.
+func (s *Server) tab(_ http.ResponseWriter, _ *http.Request, tabID string) (tabs.Tab, bool) {
+	return s.Tab(tabID, ""), true
+}
+`)
+		}
+	}
 	if w.usage.privateStreams {
 		w.writeCheckUserSubject()
 	}
@@ -65,11 +106,12 @@ func (s *Server) httpErrBad(w http.ResponseWriter, msg string, err error) {
 	w.writeEventSubjectConsts(m.Events)
 	w.writeMessageBrokerStreamSubjects(m.Events)
 	w.writeEvSubjPageFuncs(m.Pages)
-	if w.prometheus {
+	if w.metrics() {
 		w.writeBrokerSubjectKind(m.Events)
 	}
 	w.writeSetupHandlers(m)
 	w.writeAppErrHelpers(m, appPkg)
+	w.writeAppRecoverDeferred(m, appPkg)
 
 	if m.PageError404 != nil &&
 		m.PageError404.GET != nil && m.PageError404.GET.OutputBody != nil {
@@ -100,31 +142,38 @@ func (s *Server) httpErrBad(w http.ResponseWriter, msg string, err error) {
 	w.writeDispatcherTypes(appPkg)
 }
 
-func needsJSON(m *model.App) bool {
-	for _, h := range m.Actions {
-		if len(h.InputDispatches) > 0 {
-			return true
-		}
+// usesEvents reports whether any handler dispatches or handles an event,
+// which gives the server the codec and the decode cache of its events.
+func usesEvents(m *model.App) bool {
+	dispatches := func(h *model.Handler) bool {
+		return h != nil && len(h.InputDispatches) > 0
+	}
+	if slices.ContainsFunc(m.Actions, dispatches) {
+		return true
 	}
 	for _, p := range m.Pages {
 		if len(p.EventHandlers) > 0 {
 			return true
 		}
-		for _, h := range []*model.Handler{p.StreamOpen, p.StreamClose} {
-			if h != nil && len(h.InputDispatches) > 0 {
-				return true
-			}
+		if p.GET != nil && dispatches(p.GET.Handler) {
+			return true
 		}
-		for _, h := range p.Actions {
-			if len(h.InputDispatches) > 0 {
-				return true
-			}
+		if dispatches(p.StreamOpen) || dispatches(p.StreamClose) {
+			return true
+		}
+		if slices.ContainsFunc(p.Actions, dispatches) {
+			return true
+		}
+	}
+	for _, p := range []*model.Page{m.PageError404, m.PageError500} {
+		if p != nil && p.GET != nil && dispatches(p.GET.Handler) {
+			return true
 		}
 	}
 	return false
 }
 
-func (w *Writer) writeAppHeader(pkgName string, appPkgPath string, jsonImport bool) {
+func (w *Writer) writeAppHeader(pkgName string, appPkgPath string) {
 	w.Line(0, "// Code generated by github.com/romshark/datapages; DO NOT EDIT.")
 	w.Line(0, "")
 	w.Linef(0, "package %s", pkgName)
@@ -132,9 +181,6 @@ func (w *Writer) writeAppHeader(pkgName string, appPkgPath string, jsonImport bo
 	w.Line(0, "import (")
 	w.Line(1, `"bufio"`)
 	w.Line(1, `"context"`)
-	if jsonImport {
-		w.Line(1, `"encoding/json"`)
-	}
 	w.Line(1, `"errors"`)
 	w.Line(1, `"fmt"`)
 	w.Line(1, `"io"`)
@@ -154,15 +200,23 @@ func (w *Writer) writeAppHeader(pkgName string, appPkgPath string, jsonImport bo
 	w.Line(1, `"github.com/a-h/templ"`)
 	// Always needed: writeHTML renders datapages.Component values.
 	w.Line(1, `"github.com/romshark/datapages"`)
+	w.Line(1, `"github.com/romshark/datapages/modules/codec"`)
 	w.Line(1, `"github.com/romshark/datapages/modules/csrf"`)
 	w.Line(1, `"github.com/romshark/datapages/modules/messaging"`)
 	w.Line(1, `"github.com/romshark/datapages/modules/sessions"`)
 	w.Line(1, `"github.com/romshark/datapages/runtime/auth"`)
+	w.Line(1, `"github.com/romshark/datapages/runtime/deferred"`)
+	w.Line(1, `"github.com/romshark/datapages/runtime/dispatch"`)
+	w.Line(1, `"github.com/romshark/datapages/runtime/eventcache"`)
 	w.Line(1, `"github.com/romshark/datapages/runtime/htmlattr"`)
 	w.Line(1, `"github.com/romshark/datapages/runtime/httpread"`)
 	w.Line(1, `"github.com/romshark/datapages/runtime/httpserve"`)
+	w.Line(1, `"github.com/romshark/datapages/runtime/jsonenc"`)
+	w.Line(1, `"github.com/romshark/datapages/runtime/reqlog"`)
 	w.Line(1, `dpsse "github.com/romshark/datapages/runtime/sse"`)
 	w.Line(1, `"github.com/romshark/datapages/runtime/subject"`)
+	w.Line(1, `"github.com/romshark/datapages/runtime/tabs"`)
+	w.Line(1, `"github.com/romshark/datapages/runtime/tracing"`)
 	w.Line(1, `"golang.org/x/sync/errgroup"`)
 	w.Line(0, "")
 	w.Byte('\t')
@@ -191,12 +245,33 @@ func (w *Writer) writeAppHeader(pkgName string, appPkgPath string, jsonImport bo
 		w.Line(1, `"github.com/romshark/datapages/runtime/prom"`)
 		w.Line(1, `"github.com/prometheus/client_golang/prometheus/promhttp"`)
 	}
+	if w.otelMetrics {
+		w.Line(1, `"github.com/romshark/datapages/runtime/otelmetrics"`)
+	}
 	w.Line(1, `"github.com/starfederation/datastar-go/datastar"`)
 	w.Line(0, ")")
 }
 
 func (w *Writer) hasAssets() bool { return w.assetsURLPrefix != "" }
 
+// metrics reports whether to generate metrics code, of either kind.
+func (w *Writer) metrics() bool { return w.prometheus || w.otelMetrics }
+
+// metricsRecv returns what a metrics call is qualified with: package prom,
+// or with OpenTelemetry the instruments of the server s names.
+func (w *Writer) metricsRecv(s string) string {
+	if w.otelMetrics {
+		return s + ".metrics."
+	}
+	return "prom."
+}
+
+// rawMetrics emits code, which is written against package prom,
+// with its calls qualified by metricsRecv(s).
+func (w *Writer) rawMetrics(s, code string) {
+	w.Raw(strings.ReplaceAll(code, "prom.", w.metricsRecv(s)))
+}
+
 // writeAssetsFileSystem emits what turns the embed.FS of
 // datapages.WithAssets into the file system the routes are served from.
 // Only the generated code knows the subdirectory and the dev-mode path,
@@ -274,11 +349,16 @@ func (s *Server) writeHTML(
 	if err != nil {
 		return err
 	}
+	// The deferred components of the body follow it on the same response,
+	// the ones of the head are resolved as it's rendered.
+	ctx, deferreds := deferred.Start(r.Context(), s.DeferredTimeout(),
+		func(w io.Writer, id string, err error) { s.recoverDeferred(w, r, id, err) })
+	headCtx := deferred.InPlace(ctx)
 `)
 
 	if m.GlobalHeadGenerator != nil {
 		w.Raw(`	if headGeneric != nil {
-		if err := headGeneric.Render(r.Context(), w); err != nil {
+		if err := headGeneric.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
@@ -286,11 +366,35 @@ func (s *Server) writeHTML(
 	}
 
 	w.Raw(`	if head != nil {
-		if err := head.Render(r.Context(), w); err != nil {
+		if err := head.Render(headCtx, w); err != nil {
 			return err
 		}
 	}
 `)
+	if w.usage.tabState {
+		// Classic scripts run before the Datastar module,
+		// which sends the first request of the page.
+		w.Raw(`	if tabID := w.Header().Get(tabs.Header); tabID != "" {
+		// Write the fetch Datapages-Instance header injector.
+		if _, err := io.WriteString(w, ` + "`" + `
+	<script>{
+		const o = globalThis.fetch.bind(globalThis)
+		globalThis.fetch=(i,init={}) => {
+			const isReq=i instanceof Request
+			const r=isReq ? i:new Request(i,init)
+			if (r.headers.get("Datastar-Request")!=="true" ||
+				new URL(r.url).origin!==location.origin
+			) return isReq ? o(r,init):o(r)
+			const h=new Headers(r.headers)
+			h.set("` + "` + tabs.Header + `" + `","` + "` + tabID + `" + `")
+			return o(new Request(r,{...init,headers:h}))
+		}
+	}</script>` + "`" + `); err != nil {
+			return err
+		}
+	}
+`)
+	}
 	if m.Session != nil {
 		w.Raw(`	if sess.UserID() != "" && s.CSRFEnabled() {
 		// Write the fetch X-CSRF-Token header injector.
@@ -334,7 +438,7 @@ func (s *Server) writeHTML(
 		return err
 	}
 	if body != nil {
-		if err := body.Render(r.Context(), w); err != nil {
+		if err := body.Render(ctx, w); err != nil {
 			return err
 		}
 	}
@@ -347,6 +451,9 @@ func (s *Server) writeHTML(
 			return err
 		}
 	}
+	if deferreds.Len() > 0 {
+		deferreds.Resolve(r.Context(), w)
+	}
 	_, err = io.WriteString(w, "</body></html>")
 	return err
 }
@@ -391,6 +498,9 @@ func (w *Writer) writeAppHandleStreamRequest() {
 	w.Raw(`
 func (s *Server) handleStreamRequest(
 	w http.ResponseWriter, r *http.Request,`)
+	if w.metrics() {
+		w.Raw(` page string,`)
+	}
 	if w.usage.streamAuth {
 		w.Raw(` sessKey string, sess `)
 		w.Raw(w.sessionType)
@@ -412,25 +522,45 @@ func (s *Server) handleStreamRequest(
 	if !s.checkIsDSReq(w, r) {
 		return
 	}
+	// A server shutting down opens no streams, the client reconnects
+	// to another instance.
+	if s.Draining() {
+		http.Error(w,
+			http.StatusText(http.StatusServiceUnavailable),
+			http.StatusServiceUnavailable)
+		return
+	}
 
 	streamID := datapages.StreamID(s.streamSeq.Add(1))
+	reqlog.SetStream(r.Context(), uint64(streamID))
+
+	// A stream would wait for events that can't arrive while the broker is
+	// down. It's refused, and closed once the broker goes down later, so the
+	// client reconnects and renders what it missed when the broker is back.
+	brokerUp, brokerChanged := messaging.StatusOf(s.messageBroker)
+	if !brokerUp {
+		s.httpErrIntern(w, r, nil, "opening stream", messaging.ErrBrokerUnavailable)
+		return
+	}
 
 	// The subscription is established before the response head goes out.
 	// A client learns the stream is open by reading that head and may dispatch
 	// immediately after, which must not reach the broker before this.
 	ctx := r.Context()
-	sub, err := s.messageBroker.Subscribe(ctx, s.messageBrokerMetrics, subjects...)
+	sub, err := s.messageBroker.Subscribe(ctx, s.messageBrokerMetrics,
+		subject.InNamespace(s.subjectPrefix, subjects)...)
 	if err != nil {
 		// Nothing has been written yet, so the error can still carry a status.
 		s.httpErrIntern(w, r, nil, "subscribing to message broker", err)
 		return
 	}
 
-	sse := datastar.NewSSE(w, r, datastar.WithCompression())
+	// The heartbeats and the retry hint go out beside the events of sse.
+	sse, sseW := dpsse.NewSSE(w, r)
 `)
-	if w.prometheus {
-		w.Raw(`	prom.SSEConnectionOpened()
-	defer prom.SSEConnectionClosed()
+	if w.metrics() {
+		w.rawMetrics("s", `	prom.SSEConnectionOpened(page)
+	defer prom.SSEConnectionClosed(page)
 	start := time.Now()
 `)
 	}
@@ -461,32 +591,67 @@ func (s *Server) handleStreamRequest(
 `)
 	}
 	w.Raw(`	go func() {
-		select {
+		heartbeat, expired, stopTimers := s.StreamTimers()
+		defer stopTimers()
+		shutdown := s.ShutdownCh()
+		var drained <-chan time.Time
+		// A heartbeat goes out on the stream and the wait goes on, so does
+		// the drain the shutdown begins. Any other case ends the stream.
+		for {
+			select {
+			case <-heartbeat:
+				_ = dpsse.Heartbeat(sseW)
+				continue
+			case <-shutdown:
+				shutdown, drained = nil, s.DrainTimer()
 `)
+	if w.usage.streamDrain {
+		w.Raw(`				if err := s.app.StreamDrain(dpsse.New(sse)); err != nil {
+					s.LogErrCtx(r.Context(), "handling App.StreamDrain", err)
+				}
+`)
+	}
+	w.Raw(`				continue
+			case <-drained:
+				_ = dpsse.Expire(sseW, s.DrainRetry())
+`)
+	if w.metrics() {
+		w.rawMetrics("s", `				prom.SSEDisconnect("shutdown")
+`)
+	}
+	w.Raw(`			case <-expired:
+				_ = dpsse.Expire(sseW, s.StreamRetry())
+`)
+	if w.metrics() {
+		w.rawMetrics("s", `				prom.SSEDisconnect("ttl")
+`)
+	}
 	if w.usage.streamAuth {
-		w.Raw(`		case <-sessionClosed:
+		w.Raw(`			case <-sessionClosed:
 `)
-		if w.prometheus {
-			w.Raw(`			prom.SSEDisconnect("close")
+		if w.metrics() {
+			w.rawMetrics("s", `				prom.SSEDisconnect("close")
 `)
 		}
 	}
-	w.Raw(`		case <-r.Context().Done():
+	w.Raw(`			case <-r.Context().Done():
 `)
-	if w.prometheus {
-		w.Raw(`			prom.SSEDisconnect("client")
+	if w.metrics() {
+		w.rawMetrics("s", `				prom.SSEDisconnect("client")
 `)
 	}
-	w.Raw(`		case <-s.ShutdownCh():
+	w.Raw(`			case <-brokerChanged:
 `)
-	if w.prometheus {
-		w.Raw(`			prom.SSEDisconnect("shutdown")
+	if w.metrics() {
+		w.rawMetrics("s", `				prom.SSEDisconnect("broker")
 `)
 	}
-	w.Raw(`		}
+	w.Raw(`			}
+			break
+		}
 `)
-	if w.prometheus {
-		w.Raw(`		prom.SSEConnectionDuration(start)
+	if w.metrics() {
+		w.rawMetrics("s", `		prom.SSEConnectionDuration(start)
 `)
 	}
 	w.Raw(`		sub.Close()
@@ -507,10 +672,32 @@ type Server struct {
 	messageBroker        messaging.Broker
 	messageBrokerMetrics brokerMetrics
 	streamSeq            atomic.Uint64
-	app                  *`)
+	// subjectPrefix is what every subject starts with on the wire,
+	// empty without datapages.WithSubjectPrefix.
+	subjectPrefix string
+	// tracer is nil without datapages.WithTracerProvider.
+	tracer tracing.Tracer
+	app    *`)
 	w.Raw(appPkg)
 	w.Raw(`.App
 `)
+	if w.otelMetrics {
+		w.Raw(`	// metrics are the instruments of this server, created from the
+	// meter provider of datapages.WithMeterProvider.
+	metrics *otelmetrics.Metrics
+`)
+	}
+	if w.usage.events {
+		w.Raw(`	eventCodec           codec.Codec
+	eventCache           *eventcache.Cache
+	// jsonEvents is true when eventCodec is codec.JSON, which the generated
+	// encoders produce the payload of without reflection.
+	jsonEvents bool
+	// brokerInterceptors are those of datapages.WithBrokerInterceptors,
+	// which datapages.DispatchTo installs on the publisher it's given.
+	brokerInterceptors []messaging.Interceptor
+`)
+	}
 	if w.usage.hasSession {
 		w.Raw(`	*auth.Manager[`)
 		w.Raw(w.sessionDataType)
@@ -535,9 +722,12 @@ func (w *Writer) writeAppInit(appPkg string) {
 		w.Raw(`datapages.DisableSessions`)
 	}
 	w.Raw(`, `)
-	if w.prometheus {
+	switch {
+	case w.prometheus:
 		w.Raw(`datapages.EnablePrometheus`)
-	} else {
+	case w.otelMetrics:
+		w.Raw(`datapages.EnableOTelMetrics`)
+	default:
 		w.Raw(`datapages.DisablePrometheus`)
 	}
 	w.Raw(`, Server](app, broker, opts...)
@@ -548,7 +738,14 @@ func (w *Writer) writeAppInit(appPkg string) {
 //   - datapages.WithMiddleware
 //   - datapages.WithHTTPServer
 //   - datapages.WithDatastarJS
-//   - datapages.WithAssets`)
+//   - datapages.WithAssets
+//   - datapages.WithSubjectPrefix
+//   - datapages.WithBrokerInterceptors
+//   - datapages.WithTracerProvider`)
+	if w.usage.events {
+		w.Raw(`
+//   - datapages.WithEventCodec`)
+	}
 	if w.usage.hasSession {
 		w.Raw(`
 //   - datapages.WithSessionManager (required)
@@ -558,6 +755,10 @@ func (w *Writer) writeAppInit(appPkg string) {
 	if w.prometheus {
 		w.Raw(`
 //   - datapages.WithPrometheus (required)`)
+	}
+	if w.otelMetrics {
+		w.Raw(`
+//   - datapages.WithMeterProvider (required)`)
 	}
 	w.Raw(`
 func (s *Server) Init(
@@ -583,20 +784,40 @@ func (s *Server) Init(
 	}
 `)
 	}
-	if w.prometheus {
+	switch {
+	case w.prometheus:
 		w.Raw(`	if cfg.MetricsServer == nil {
 		// This server is generated with datapages.EnablePrometheus,
 		// hence the metrics it counts must be served.
 		return errors.New("missing option WithPrometheus")
 	}
+	if cfg.MeterProvider != nil {
+		return errors.New("unexpected option WithMeterProvider: " +
+			"the server is generated with datapages.EnablePrometheus")
+	}
 `)
-	} else {
+	case w.otelMetrics:
+		w.Raw(`	if cfg.MeterProvider == nil {
+		// This server is generated with datapages.EnableOTelMetrics,
+		// hence the metrics it records need a meter provider.
+		return errors.New("missing option WithMeterProvider")
+	}
+	if cfg.MetricsServer != nil {
+		return errors.New("unexpected option WithPrometheus: " +
+			"the server is generated with datapages.EnableOTelMetrics")
+	}
+`)
+	default:
 		w.Raw(`	if cfg.MetricsServer != nil {
 		// This server is generated with datapages.DisablePrometheus,
 		// hence there is no instrumentation for the metrics to count.
 		return errors.New("unexpected option WithPrometheus: " +
 			"the server is generated with datapages.DisablePrometheus")
 	}
+	if cfg.MeterProvider != nil {
+		return errors.New("unexpected option WithMeterProvider: " +
+			"the server is generated with datapages.DisablePrometheus")
+	}
 `)
 	}
 	if w.usage.hasSession {
@@ -611,6 +832,16 @@ func (s *Server) Init(
 		return err
 	}
 	cfg.AssetsFS = assetsFS
+`)
+	if w.otelMetrics {
+		w.Raw(`
+	if s.metrics, err = otelmetrics.New(cfg.MeterProvider); err != nil {
+		return fmt.Errorf("creating metrics instruments: %w", err)
+	}
+	cfg.OutermostMiddleware = s.metrics.Middleware
+`)
+	}
+	w.Raw(`
 
 	s.Core = httpserve.NewCore(cfg, `)
 	if w.hasAssets() {
@@ -621,22 +852,62 @@ func (s *Server) Init(
 	w.Raw(`)
 	s.app = app
 	s.messageBroker = messageBroker
-	s.messageBrokerMetrics = brokerMetrics{}
-
+	s.tracer = tracing.NewTracer(cfg.TracerProvider)
+	if cfg.SubjectPrefix != "" {
+		s.subjectPrefix = subject.Prefix(cfg.SubjectPrefix)
+	}
+`)
+	switch {
+	case w.prometheus:
+		w.Raw(`	s.messageBrokerMetrics = brokerMetrics{prefix: s.subjectPrefix}
+`)
+	case w.otelMetrics:
+		w.Raw(`	s.messageBrokerMetrics = brokerMetrics{
+		prefix: s.subjectPrefix, metrics: s.metrics,
+	}
+`)
+	default:
+		w.Raw(`	s.messageBrokerMetrics = brokerMetrics{}
+`)
+	}
+	if w.usage.events {
+		w.Raw(`	s.eventCodec = cfg.EventCodec
+	s.eventCache = eventcache.New(eventcache.DefaultSize)
+	_, s.jsonEvents = cfg.EventCodec.(codec.JSON)
+	s.brokerInterceptors = cfg.BrokerInterceptors
+`)
+	}
+	w.Raw(`
 	if si, ok := s.messageBroker.(messaging.StreamInitializer); ok {
-		if err := si.InitStreams(MessageBrokerStreamSubjects()); err != nil {
+		subjects := subject.InNamespace(s.subjectPrefix, MessageBrokerStreamSubjects())
+		if err := si.InitStreams(subjects); err != nil {
 			return fmt.Errorf("initializing message broker streams: %w", err)
 		}
 	}
 `)
 	if w.usage.hasSession {
 		w.Raw(`	s.Manager = auth.NewManager(s.Core, sessionManager, cfg, `)
-		if w.prometheus {
+		switch {
+		case w.prometheus:
 			w.Raw(`prom.AuthMetrics{}`)
-		} else {
+		case w.otelMetrics:
+			w.Raw(`s.metrics`)
+		default:
 			w.Raw(`nil`)
 		}
 		w.Raw(`)
+`)
+	}
+	// The probes may be served in any metrics mode, see datapages.WithHealth.
+	w.Raw(`
+	s.AddReadinessCheck("broker", func(ctx context.Context) error {
+		return messaging.Ping(ctx, s.messageBroker)
+	})
+`)
+	if w.usage.hasSession {
+		w.Raw(`	if p, ok := sessionManager.(sessions.Pinger); ok {
+		s.AddReadinessCheck("sessions", p.Ping)
+	}
 `)
 	}
 	w.Raw(`
@@ -730,6 +1001,7 @@ func (w *Writer) writeEvSubjPageFuncs(pages []*model.Page) {
 		if !pageHasStream(p) {
 			continue
 		}
+		p = withoutWildcards(p)
 		if len(p.EventHandlers) == 0 {
 			w.Line(0, "")
 			w.Raw("var evSubj")
@@ -1240,10 +1512,11 @@ func (w *Writer) writeAppErrHelpers(m *model.App, appPkg string) {
 	if !hasPage && !hasRecover {
 		w.Raw(`
 func (s *Server) httpErrIntern(
-	w http.ResponseWriter, _ *http.Request,
+	w http.ResponseWriter, r *http.Request,
 	_ *datastar.ServerSentEventGenerator, msg string, err error,
 ) {
-	s.LogErr(msg, err)
+	s.LogErrCtx(r.Context(), msg, err)
+	tracing.FailRequest(r, err)
 `)
 		w.writeHTTPErrFallback()
 		w.Raw(`}
@@ -1270,7 +1543,8 @@ func (s *Server) httpErrIntern(
 	w http.ResponseWriter, r *http.Request,
 	sse *datastar.ServerSentEventGenerator, msg string, err error,
 ) {
-	s.LogErr(msg, err)
+	s.LogErrCtx(r.Context(), msg, err)
+	tracing.FailRequest(r, err)
 `)
 	if hasPage {
 		w.Raw(`	if !httpserve.IsDatastarRequest(r) {
@@ -1307,19 +1581,19 @@ func (s *Server) httpErrIntern(
 		w.Raw(`)
 	if errRecover == nil {
 `)
-		if w.prometheus {
-			w.Raw(`		prom.InternalErrorRecovered()
+		if w.metrics() {
+			w.rawMetrics("s", `		prom.InternalErrorRecovered()
 `)
 		}
 		w.Raw(`		return // Feedback delivered gracefully.
 	}
 	// RecoverError failed — fall back to HTTP error response.
 `)
-		if w.prometheus {
-			w.Raw(`	prom.InternalErrorNotRecovered()
+		if w.metrics() {
+			w.rawMetrics("s", `	prom.InternalErrorNotRecovered()
 `)
 		}
-		w.Raw(`	s.Logger().Error("recovering error",
+		w.Raw(`	s.RequestLogger(r.Context()).Error("recovering error",
 		slog.Any("orig.msg", msg),
 		slog.Any("orig.err", err),
 		slog.Any("err", errRecover))
@@ -1335,6 +1609,47 @@ func (s *Server) httpErrIntern(
 `)
 }
 
+// writeAppRecoverDeferred writes recoverDeferred, which reports a deferred
+// component that failed to resolve to RecoverError, writing its feedback
+// into the page that is being streamed.
+func (w *Writer) writeAppRecoverDeferred(m *model.App, appPkg string) {
+	w.Raw(`
+func (s *Server) recoverDeferred(
+	w io.Writer, r *http.Request, id string, err error,
+) {
+	err = datapages.DeferredError{Selector: "#" + id, Err: err}
+`)
+	if m.RecoverError == nil {
+		w.Raw(`	s.LogErrCtx(r.Context(), "resolving deferred component", err)
+}
+`)
+		return
+	}
+	w.Raw(`	errRecover := s.`)
+	w.Raw(appPkg)
+	w.Raw(`.RecoverError(`)
+	for i, kind := range m.RecoverError.OrderedInputs {
+		if i > 0 {
+			w.Raw(", ")
+		}
+		if kind == model.InputKindSSE {
+			w.Raw("dpsse.NewInline(r.Context(), w)")
+		} else {
+			w.Raw("err")
+		}
+	}
+	w.Raw(`)
+	if errRecover == nil {
+		return // Feedback delivered gracefully.
+	}
+	s.RequestLogger(r.Context()).Error("recovering error",
+		slog.Any("orig.msg", "resolving deferred component"),
+		slog.Any("orig.err", err),
+		slog.Any("err", errRecover))
+}
+`)
+}
+
 func (w *Writer) writeRender404(m *model.App, appPkg string) {
 	p := m.PageError404
 
@@ -1404,6 +1719,7 @@ func (w *Writer) writeAppActionHandler(h *model.Handler, m *model.App, appPkg st
 	w.Raw(strings.ToUpper(h.HTTPMethod))
 	w.Raw(h.Name)
 	w.Raw("(w http.ResponseWriter, r *http.Request) {\n")
+	w.writeHandlerStart(1, "App", actionMethodName(h))
 
 	if h.InputSSE != nil || h.InputSignals != nil {
 		w.Line(1, "if !s.checkIsDSReq(w, r) {")
@@ -1471,7 +1787,7 @@ func (w *Writer) writeHandlerCallAndOutputs(
 	}
 
 	// Dispatch closures.
-	w.writeDispatchers(h, "dispatch", "r.Context()")
+	w.writeDispatchers(1, h, "dispatch", "r.Context()")
 
 	// SSE for actions that take it.
 	if h.InputSSE != nil && !isAppLevel {
@@ -1551,6 +1867,9 @@ func (w *Writer) writeMethodCall(
 		w.Line(1, "}")
 	}
 
+	// Deferred events.
+	w.writeDeferredPublish(h, actionOwnerName(p, isAppLevel))
+
 	// Close session.
 	if h.OutputCloseSession != nil {
 		w.Raw("\tif ")
@@ -1613,7 +1932,7 @@ func (w *Writer) writeMethodCall(
 		w.Raw(outputVar(h.OutputBody.Output))
 		w.Raw(", nil, nil,\n")
 		w.Line(1, "); err != nil {")
-		w.Raw("\t\ts.LogErr(\"rendering response of ")
+		w.Raw("\t\ts.LogErrCtx(r.Context(), \"rendering response of ")
 		w.Raw(ownerName)
 		w.Byte('.')
 		w.Raw(h.HTTPMethod)
@@ -1625,28 +1944,40 @@ func (w *Writer) writeMethodCall(
 }
 
 // writeDispatchers emits one dispatcher value per datapages.Dispatcher parameter
-// of the handler. prefix names them apart when a generated function holds the
-// dispatchers of more than one handler, as the stream handler does.
+// of the handler at indent. prefix names them apart when a generated function
+// holds the dispatchers of more than one handler, as the stream handler does.
 // ctxExpr is the context Dispatch publishes with.
-func (w *Writer) writeDispatchers(h *model.Handler, prefix, ctxExpr string) {
+//
+// The dispatchers of a handler returning datapages.DeferDispatch share one
+// buffer, which keeps the events in dispatch order across event types.
+func (w *Writer) writeDispatchers(
+	indent int, h *model.Handler, prefix, ctxExpr string,
+) {
+	if h.OutputDeferDispatch != nil {
+		w.Line(0, "")
+		w.Line(indent, "deferred := new(dispatch.Deferred)")
+	}
 	for _, d := range h.InputDispatches {
 		if !slices.Contains(w.dispatchedEvents, d.EventTypeName) {
 			w.dispatchedEvents = append(w.dispatchedEvents, d.EventTypeName)
 		}
 		w.Line(0, "")
-		w.Byte('\t')
+		w.Raw(strings.Repeat("\t", indent))
 		w.Raw(dispatchVarName(prefix, d.EventTypeName))
 		w.Raw(" := ")
 		w.Raw(dispatcherTypeName(d.EventTypeName))
 		w.Raw("{s: s, ctx: ")
 		w.Raw(ctxExpr)
+		if h.OutputDeferDispatch != nil {
+			w.Raw(", deferred: deferred")
+		}
 		w.Raw("}\n")
 	}
 }
 
 // writeDispatcherTypes emits the datapages.Dispatcher implementation of every
-// event a handler dispatches. It marshals the event and publishes it to every
-// subject its subject fields expand into.
+// event a handler dispatches. It marshals the event and publishes or schedules
+// it to the subject its subject fields expand into.
 func (w *Writer) writeDispatcherTypes(appPkg string) {
 	for _, evName := range w.dispatchedEvents {
 		w.writeDispatcherType(evName, appPkg)
@@ -1662,8 +1993,15 @@ func (w *Writer) writeDispatcherType(evName, appPkg string) {
 	w.Raw("type ")
 	w.Raw(typeName)
 	w.Raw(" struct {\n")
-	w.Line(1, "s   *Server")
-	w.Line(1, "ctx context.Context")
+	if w.usage.deferDispatch {
+		w.Line(1, "s   *Server")
+		w.Line(1, "ctx context.Context")
+		w.Line(1, "// deferred holds the events back when not nil.")
+		w.Line(1, "deferred *dispatch.Deferred")
+	} else {
+		w.Line(1, "s   *Server")
+		w.Line(1, "ctx context.Context")
+	}
 	w.Line(0, "}")
 
 	w.Line(0, "")
@@ -1681,6 +2019,61 @@ func (w *Writer) writeDispatcherType(evName, appPkg string) {
 	w.Raw(") DispatchCtx(\n")
 	w.Line(1, "ctx context.Context, e "+eventType+",")
 	w.Line(0, ") error {")
+	w.Line(1, "return d.publish(ctx, d.s.messageBroker, time.Time{}, e)")
+	w.Line(0, "}")
+
+	// Publishing to a publisher of the caller isn't held back,
+	// see datapages.DispatchTo.
+	w.Line(0, "")
+	w.Raw("func (d ")
+	w.Raw(typeName)
+	w.Raw(") DispatchTo(\n")
+	w.Line(1, "ctx context.Context, p messaging.Publisher, e "+eventType+",")
+	w.Line(0, ") error {")
+	if w.usage.deferDispatch {
+		w.Line(1, "d.deferred = nil")
+	}
+	w.Line(1, "p = messaging.InterceptPublisher(p, d.s.brokerInterceptors...)")
+	w.Line(1, "return d.publish(ctx, p, time.Time{}, e)")
+	w.Line(0, "}")
+
+	// The broker is checked at the call, the scheduled publish may be
+	// held back by datapages.DeferDispatch and go out after the handler returned.
+	w.Line(0, "")
+	w.Raw("func (d ")
+	w.Raw(typeName)
+	w.Raw(") DispatchAt(\n")
+	w.Line(1, "ctx context.Context, t time.Time, e "+eventType+",")
+	w.Line(0, ") error {")
+	w.Line(1, "if _, ok := d.s.messageBroker.(messaging.Scheduler); !ok {")
+	w.Raw("\t\treturn fmt.Errorf(\"scheduling ")
+	w.Raw(evName)
+	w.Raw(": %w\", messaging.ErrNoScheduler)\n")
+	w.Line(1, "}")
+	w.Line(1, "return d.publish(ctx, d.s.messageBroker, t, e)")
+	w.Line(0, "}")
+
+	w.Line(0, "")
+	w.Raw("func (d ")
+	w.Raw(typeName)
+	w.Raw(") DispatchAfter(\n")
+	w.Line(1, "ctx context.Context, delay time.Duration, e "+eventType+",")
+	w.Line(0, ") error {")
+	w.Line(1, "return d.DispatchAt(ctx, time.Now().Add(delay), e)")
+	w.Line(0, "}")
+
+	w.Line(0, "")
+	w.Line(0, "// publish publishes e to p, or schedules it for at when at isn't zero.")
+	w.Raw("func (d ")
+	w.Raw(typeName)
+	w.Raw(") publish(\n")
+	w.Line(1, "ctx context.Context, p messaging.Publisher, at time.Time, e "+eventType+",")
+	w.Line(0, ") error {")
+	if w.metrics() {
+		w.Raw("\tdefer " + w.metricsRecv("d.s") + "DispatchDuration(")
+		w.writeQuoted(evName)
+		w.Raw(", time.Now())\n")
+	}
 
 	if ev != nil && ev.HasSubjectFields() {
 		// Guard before any work: a value carrying a separator or a wildcard
@@ -1703,11 +2096,21 @@ func (w *Writer) writeDispatcherType(evName, appPkg string) {
 		}
 	}
 
-	w.Line(1, "j, err := json.Marshal(e)")
-	w.Line(1, "if err != nil {")
-	w.Raw("\t\treturn fmt.Errorf(\"marshaling ")
+	if ev != nil && ev.DirectJSON {
+		w.Line(1, "var j []byte")
+		w.Line(1, "var err error")
+		w.Line(1, "if d.s.jsonEvents {")
+		w.Raw("\t\tj = ")
+		w.Raw(eventJSONAppenderName(evName))
+		w.Raw("(nil, e)\n")
+		w.Line(1, "} else if j, err = d.s.eventCodec.Marshal(e); err != nil {")
+	} else {
+		w.Line(1, "j, err := d.s.eventCodec.Marshal(e)")
+		w.Line(1, "if err != nil {")
+	}
+	w.Raw("\t\treturn fmt.Errorf(\"encoding ")
 	w.Raw(evName)
-	w.Raw(" JSON: %w\", err)\n")
+	w.Raw(": %w\", err)\n")
 	w.Line(1, "}")
 
 	if ev != nil && ev.HasSubjectFields() {
@@ -1726,15 +2129,21 @@ func (w *Writer) writeDispatcherType(evName, appPkg string) {
 			w.Byte(')')
 		}
 		w.Byte('\n')
-		w.Raw("\terr = d.s.messageBroker.Publish(")
-		w.Raw("ctx, d.s.messageBrokerMetrics, subj, j)\n")
+		w.writeDeferredAdd("subj")
+		w.Raw("\terr = dispatch.Publish(\n")
+		w.Raw("\t\tctx, p, d.s.messageBrokerMetrics, at, d.s.subjectPrefix+subj, j,\n")
+		w.Line(1, ")")
 		w.Line(1, "if err != nil {")
 		w.Raw("\t\treturn fmt.Errorf(\"publishing subject %q: %w\", subj, err)\n")
 		w.Line(1, "}")
 	} else if ev != nil {
-		w.Raw("\terr = d.s.messageBroker.Publish(ctx, d.s.messageBrokerMetrics, ")
+		w.writeDeferredAdd(evSubjConst(ev))
+		w.Raw("\terr = dispatch.Publish(\n")
+		w.Raw("\t\tctx, p, d.s.messageBrokerMetrics, at,\n")
+		w.Raw("\t\td.s.subjectPrefix+")
 		w.Raw(evSubjConst(ev))
-		w.Raw(", j)\n")
+		w.Raw(", j,\n")
+		w.Line(1, ")")
 		w.Line(1, "if err != nil {")
 		w.Raw("\t\treturn fmt.Errorf(\"publishing subject %q: %w\", ")
 		w.Raw(evSubjConst(ev))
@@ -1744,6 +2153,79 @@ func (w *Writer) writeDispatcherType(evName, appPkg string) {
 
 	w.Line(1, "return nil")
 	w.Line(0, "}")
+
+	if ev != nil && ev.DirectJSON {
+		w.writeEventJSONAppender(ev, eventType)
+	}
+}
+
+// writeEventJSONAppender emits the function that appends an event the way
+// encoding/json encodes it, for an event type whose fields are all encoded
+// by their kind. Every field is written with a leading comma, the first one
+// is overwritten by the opening brace.
+func (w *Writer) writeEventJSONAppender(ev *model.Event, eventType string) {
+	name := eventJSONAppenderName(ev.TypeName)
+	w.Line(0, "")
+	w.Raw("// ")
+	w.Raw(name)
+	w.Raw(" appends e encoded the way encoding/json encodes it.\n")
+	w.Raw("func ")
+	w.Raw(name)
+	w.Raw("(b []byte, e ")
+	w.Raw(eventType)
+	w.Raw(") []byte {\n")
+	w.Line(1, "start := len(b)")
+	for _, f := range ev.JSONFields {
+		indent := 1
+		if f.OmitEmpty {
+			w.Raw("\tif ")
+			switch f.Kind {
+			case model.JSONKindString:
+				w.Raw("e." + f.FieldName + ` != ""`)
+			case model.JSONKindBool:
+				w.Raw("e." + f.FieldName)
+			default:
+				w.Raw("e." + f.FieldName + " != 0")
+			}
+			w.Raw(" {\n")
+			indent = 2
+		}
+		w.Line(indent, "b = append(b, `,\""+f.Key+"\":`...)")
+		switch f.Kind {
+		case model.JSONKindString:
+			w.Line(indent, "b = jsonenc.AppendString(b, string(e."+f.FieldName+"))")
+		case model.JSONKindBool:
+			w.Line(indent, "b = jsonenc.AppendBool(b, bool(e."+f.FieldName+"))")
+		case model.JSONKindInt:
+			w.Line(indent, "b = jsonenc.AppendInt(b, int64(e."+f.FieldName+"))")
+		case model.JSONKindUint:
+			w.Line(indent, "b = jsonenc.AppendUint(b, uint64(e."+f.FieldName+"))")
+		}
+		if f.OmitEmpty {
+			w.Line(1, "}")
+		}
+	}
+	w.Line(1, "if len(b) == start {")
+	w.Line(2, `return append(b, "{}"...)`)
+	w.Line(1, "}")
+	w.Line(1, "b[start] = '{'")
+	w.Line(1, "return append(b, '}')")
+	w.Line(0, "}")
+}
+
+// writeDeferredAdd emits the branch of publish that holds the publish of
+// subjExpr back instead of sending it, for an app where any handler returns
+// datapages.DeferDispatch.
+func (w *Writer) writeDeferredAdd(subjExpr string) {
+	if !w.usage.deferDispatch {
+		return
+	}
+	w.Line(1, "if d.deferred != nil {")
+	w.Raw("\t\td.deferred.Add(ctx, at, d.s.subjectPrefix+")
+	w.Raw(subjExpr)
+	w.Raw(", j)\n")
+	w.Line(2, "return nil")
+	w.Line(1, "}")
 }
 
 // Handler inputs are carried by datapages.Path, datapages.Query and
@@ -1883,7 +2365,7 @@ func (w *Writer) writeGETCall(p *model.Page, m *model.App, context string) {
 	w.Raw(headArg)
 	w.Raw(", body, bodyAttrs, nil,\n")
 	w.Line(1, "); err != nil {")
-	w.Raw("\t\ts.LogErr(\"rendering ")
+	w.Raw("\t\ts.LogErrCtx(r.Context(), \"rendering ")
 	w.Raw(p.TypeName)
 	w.Raw("\", err)\n")
 	w.Line(2, "return")
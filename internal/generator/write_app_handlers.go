@@ -27,6 +27,8 @@ func handlerArgVar(kind string, skipSSE bool) string {
 		return "dpsse.New(sse)"
 	case model.InputKindSession:
 		return "sess"
+	case model.InputKindTabState:
+		return "tab"
 	case model.InputKindPath:
 		return "path"
 	case model.InputKindQuery:
@@ -59,6 +61,8 @@ func outputVar(out *model.Output) string {
 		return "newSession"
 	case model.OutputKindCloseSession:
 		return "closeSession"
+	case model.OutputKindDeferDispatch:
+		return "deferDispatch"
 	case model.OutputKindEnableBgStream:
 		return "enableBackgroundStreaming"
 	case model.OutputKindDisableRefresh:
@@ -122,6 +126,9 @@ func handlerOutputVars(h *model.Handler) []string {
 	if h.OutputCloseSession != nil {
 		outs = append(outs, outputVar(h.OutputCloseSession))
 	}
+	if h.OutputDeferDispatch != nil {
+		outs = append(outs, outputVar(h.OutputDeferDispatch))
+	}
 	if h.OutputRedirect != nil {
 		outs = append(outs, outputVar(h.OutputRedirect))
 	}
@@ -173,6 +180,9 @@ func handlerInputArgs(
 	if h.InputSession != nil {
 		args = append(args, "sess")
 	}
+	if h.InputTabState != nil {
+		args = append(args, "tab")
+	}
 	if h.InputPath != nil {
 		args = append(args, "path")
 	}
@@ -205,8 +215,11 @@ func eventHandlerInputArgs(eh *model.EventHandler, eventVar string) []string {
 		args := make([]string, 0, len(eh.OrderedInputs))
 		for _, inp := range eh.OrderedInputs {
 			v := handlerArgVar(inp.Kind, false)
-			if inp.Kind == model.InputKindEvent {
+			switch inp.Kind {
+			case model.InputKindEvent:
 				v = eventVar
+			case model.InputKindSSE:
+				v = eventSSEArg
 			}
 			if v != "" {
 				args = append(args, v)
@@ -220,7 +233,7 @@ func eventHandlerInputArgs(eh *model.EventHandler, eventVar string) []string {
 		args = append(args, eventVar)
 	}
 	if eh.InputSSE != nil {
-		args = append(args, "dpsse.New(sse)")
+		args = append(args, eventSSEArg)
 	}
 	if eh.InputStreamID != nil {
 		args = append(args, "streamID")
@@ -228,25 +241,106 @@ func eventHandlerInputArgs(eh *model.EventHandler, eventVar string) []string {
 	if eh.InputSession != nil {
 		args = append(args, "sess")
 	}
+	if eh.InputTabState != nil {
+		args = append(args, "tab")
+	}
 	return args
 }
 
+// eventSSEArg is the SSE an event handler receives. Its context carries
+// the logger of the event, see writeEventHandlerCall.
+const eventSSEArg = "dpsse.NewContext(ctx, sse)"
+
+// writeMakeTabState emits the tab state of the tab tabRef,
+// bound to ctx.
+func (w *Writer) writeMakeTabState(indent int, p *model.Page, ctx string) {
+	w.Raw(strings.Repeat("\t", indent))
+	w.Raw("tab := datapages.MakeTabState[")
+	w.Raw(renderType(p.TabState.State))
+	w.Raw("](" + ctx + ", tabRef)\n")
+}
+
+// writeReadTab emits reading the tab whose instance ID the stream or
+// an action of a stateful page must carry.
+func (w *Writer) writeReadTab(indent int) {
+	w.Line(indent, "tabRef, ok := s.readTab(w, r)")
+	w.Line(indent, "if !ok {")
+	w.Line(indent+1, "return")
+	w.Line(indent, "}")
+}
+
+// writeKeepTab emits keeping the state of the tab from expiring for as long
+// as its stream is open. A tab reconnecting finds it for the TTL after.
+func (w *Writer) writeKeepTab() {
+	w.Line(0, "")
+	w.Line(1, "tabOwner, err := tabRef.Keep(r.Context())")
+	w.Line(1, "if err != nil {")
+	w.Line(2, `s.httpErrIntern(w, r, nil, "keeping tab state", err)`)
+	w.Line(2, "return")
+	w.Line(1, "}")
+	w.Line(1, "defer func() {")
+	w.Line(2, "ctx := context.WithoutCancel(r.Context())")
+	w.Line(2, "if err := tabRef.Release(ctx, tabOwner); err != nil {")
+	w.Line(3, `s.LogErrCtx(ctx, "releasing tab state", err)`)
+	w.Line(2, "}")
+	w.Line(1, "}()")
+}
+
+// writeHandlerStart emits the instrumentation of the handler of owner,
+// which ends with the function the statements are emitted into: the span,
+// which the request carries from there on, the handler name the request's
+// log lines carry, and with metrics the duration.
+func (w *Writer) writeHandlerStart(indent int, owner, handler string) {
+	w.Raw(strings.Repeat("\t", indent))
+	w.Raw("r, span := tracing.StartRequest(s.tracer, r, ")
+	w.writeQuoted(owner + "." + handler)
+	w.Raw(")\n")
+	w.Line(indent, "defer span.End()")
+	if handler != "StreamOpen" && handler != "StreamClose" {
+		// The stream hooks run in the request of the stream,
+		// whose log lines carry the name of the stream handler.
+		w.writeSetLogHandler(indent, owner+"."+handler)
+	}
+	if w.metrics() {
+		w.Raw(strings.Repeat("\t", indent))
+		w.Raw("defer " + w.metricsRecv("s") + "HandlerDuration(")
+		w.writeQuoted(owner)
+		w.Raw(", ")
+		w.writeQuoted(handler)
+		w.Raw(", time.Now())\n")
+	}
+	w.Line(0, "")
+}
+
+// writeSetLogHandler emits the statement naming the handler of r in its
+// log lines, "PageIndex.GET" for example.
+func (w *Writer) writeSetLogHandler(indent int, name string) {
+	w.Raw(strings.Repeat("\t", indent))
+	w.Raw("reqlog.SetHandler(r, ")
+	w.writeQuoted(name)
+	w.Raw(")\n")
+}
+
+// actionMethodName returns the name of the method an action is declared by,
+// "POSTSave" for example.
+func actionMethodName(h *model.Handler) string {
+	return strings.ToUpper(h.HTTPMethod) + h.Name
+}
+
 // writePageGETHandler generates the GET handler for a page.
 func (w *Writer) writePageGETHandler(p *model.Page, m *model.App, appPkg string) {
 	w.Line(0, "")
 	w.Raw("func (s *Server) handle")
 	w.Raw(p.TypeName)
 	w.Raw("GET(w http.ResponseWriter, r *http.Request) {\n")
+	w.writeHandlerStart(1, p.TypeName, "GET")
 
 	h := p.GET.Handler
 
-	hasBody := false
-
 	// Auth.
 	needsSession := h.InputSession != nil ||
 		(m.GlobalHeadGenerator != nil && m.GlobalHeadGenerator.InputSession)
 	if needsSession {
-		hasBody = true
 		w.Line(1, "sess, _, ok := s.ReadSession(w, r)")
 		w.Line(1, "if !ok {")
 		w.Line(2, "return")
@@ -255,10 +349,7 @@ func (w *Writer) writePageGETHandler(p *model.Page, m *model.App, appPkg string)
 
 	// Index page: 404 fallback for non-root paths.
 	if p.PageSpecialization == model.PageTypeIndex {
-		if hasBody {
-			w.Line(0, "")
-		}
-		hasBody = true
+		w.Line(0, "")
 		w.Line(1, `if r.URL.Path != "/" {`)
 		if m.PageError404 != nil {
 			w.Line(2, "s.render404(w, r)")
@@ -271,13 +362,11 @@ func (w *Writer) writePageGETHandler(p *model.Page, m *model.App, appPkg string)
 
 	// Read query params.
 	if h.InputQuery != nil {
-		hasBody = true
 		w.writeReadQuery(h.InputQuery, m)
 	}
 
 	// Read path params.
 	if h.InputPath != nil {
-		hasBody = true
 		w.writeReadPath(h.InputPath, m)
 	}
 
@@ -288,7 +377,6 @@ func (w *Writer) writePageGETHandler(p *model.Page, m *model.App, appPkg string)
 	// Their absence is the ordinary case and leaves the handler the zero value;
 	// only a malformed value is an error.
 	if h.InputSignals != nil {
-		hasBody = true
 		w.Line(0, "")
 		w.Raw("\tvar signals ")
 		w.Raw(renderSignalsType(h.InputSignals, m))
@@ -301,18 +389,35 @@ func (w *Writer) writePageGETHandler(p *model.Page, m *model.App, appPkg string)
 		w.Line(1, "}")
 	}
 
+	// Tab instance.
+	//
+	// Every page load is a new tab. An ID the request carries is never taken
+	// over, whoever knows it would otherwise read the state of that tab.
+	// A re-render renders the tab of the stream, whose ID the stream read
+	// from its own request and passes on in the context.
+	if p.TabState != nil {
+		w.Line(0, "")
+		w.Line(1, "tabID, ok := tabs.RerenderID(r.Context())")
+		w.Line(1, "if !ok {")
+		w.Line(2, "tabID = tabs.NewID()")
+		w.Line(1, "}")
+		w.Line(1, "w.Header().Set(tabs.Header, tabID)")
+		if h.InputTabState != nil {
+			w.Line(1, "tabRef, ok := s.tab(w, r, tabID)")
+			w.Line(1, "if !ok {")
+			w.Line(2, "return")
+			w.Line(1, "}")
+			w.writeMakeTabState(1, p, "r.Context()")
+		}
+	}
+
 	// Dispatch closures.
 	if len(h.InputDispatches) > 0 {
-		hasBody = true
-		w.writeDispatchers(h, "dispatch", "r.Context()")
+		w.writeDispatchers(1, h, "dispatch", "r.Context()")
 	}
 
 	// Page constructor.
-	if hasBody {
-		w.Raw("\n\tp := ")
-	} else {
-		w.Raw("\tp := ")
-	}
+	w.Raw("\n\tp := ")
 	w.writePageConstructor(p, appPkg)
 	w.Byte('\n')
 
@@ -423,7 +528,7 @@ func (w *Writer) writeGETMethodCall(p *model.Page, m *model.App) {
 		w.Raw("nil,\n")
 	}
 	w.Line(1, "); err != nil {")
-	w.Raw("\t\ts.LogErr(\"rendering ")
+	w.Raw("\t\ts.LogErrCtx(r.Context(), \"rendering ")
 	w.Raw(p.TypeName)
 	w.Raw("\", err)\n")
 	w.Line(2, "return")
@@ -505,6 +610,7 @@ func (w *Writer) writeGETBodyAttrs(p *model.Page) (hasBodySuffix bool) {
 					FieldName:  f.Name,
 					Type:       f.Type,
 					QueryTag:   structtag.QueryTagValue(f.Tag),
+					Push:       structtag.ReflectSignalTagOption(f.Tag) == "push",
 				})
 			}
 		}
@@ -736,19 +842,17 @@ func (w *Writer) writeGETBodyAttrs(p *model.Page) (hasBodySuffix bool) {
 					r = r[i+j+1:]
 				}
 			}
-			w.Raw("\t\t\twindow.history.replaceState(null, '', query ? '")
-			writeRoute(route)
-			w.Raw("?' + query : '")
-			writeRoute(route)
-			w.Raw("');\n")
-			w.Line(2, "\"`)")
+			w.writeReflectHistory(reflectFields, func() { writeRoute(route) })
 		} else {
-			w.Raw("\t\t\twindow.history.replaceState(null, '', query ? '")
-			w.Raw(route)
-			w.Raw("?' + query : '")
-			w.Raw(route)
-			w.Raw("');\n")
-			w.Line(2, "\"`)")
+			w.writeReflectHistory(reflectFields, func() { w.Raw(route) })
+		}
+		if slices.ContainsFunc(reflectFields, func(f reflectSignalField) bool {
+			return f.Push
+		}) {
+			// The page doesn't follow the query of the entry going back
+			// leads to, load it for the query.
+			w.Line(0, "")
+			w.Line(2, "_, _ = io.WriteString(w, ` data-on:popstate__window=\"window.location.reload()\"`)")
 		}
 	}
 
@@ -831,6 +935,7 @@ func (w *Writer) writePageGETStreamHandler(
 	w.Raw("func (s *Server) handle")
 	w.Raw(p.TypeName)
 	w.Raw("GETStream(w http.ResponseWriter, r *http.Request) {\n")
+	w.writeSetLogHandler(1, p.TypeName+".Stream")
 
 	w.Line(1, "if !s.checkIsDSReq(w, r) {")
 	w.Line(2, "return")
@@ -919,23 +1024,57 @@ func (w *Writer) writePageGETStreamHandler(
 		w.Line(1, "}")
 	}
 
-	if p.StreamOpen != nil {
-		w.writeDispatchers(p.StreamOpen, "dispatchOpen",
-			"context.WithoutCancel(r.Context())")
-	}
-	if p.StreamClose != nil {
-		w.writeDispatchers(p.StreamClose, "dispatchClosed",
-			"context.WithoutCancel(r.Context())")
+	// Tab instance.
+	if p.TabState != nil {
+		w.Line(0, "")
+		w.writeReadTab(1)
 	}
 
-	// Page constructor.
-	w.Raw("\n\tp := ")
-	w.writePageConstructor(p, appPkg)
-	w.Byte('\n')
+	// Page constructor. A page kept open for its tab state alone
+	// has nothing that calls it.
+	if pageStreamUsesPage(p) {
+		w.Raw("\n\tp := ")
+		w.writePageConstructor(p, appPkg)
+		w.Byte('\n')
+	}
 
 	// evSubj call.
 	evSubjName := "evSubj" + p.TypeName
+	writeEvSubj := func() {
+		w.Raw(evSubjName)
+		if hasPrivate && hasSignalScoped {
+			w.Raw("(sess.UserID()")
+			for _, ident := range signalIdents {
+				w.Raw(", subjSignals.")
+				w.Raw(ident)
+			}
+			w.Raw(")")
+		} else if hasPrivate {
+			w.Raw("(sess.UserID())")
+		} else if hasSignalScoped {
+			w.Raw("(")
+			for i, ident := range signalIdents {
+				if i > 0 {
+					w.Raw(", ")
+				}
+				w.Raw("subjSignals.")
+				w.Raw(ident)
+			}
+			w.Raw(")")
+		}
+	}
+	wildcards := pageWildcardHandlers(p)
+	if len(wildcards) > 0 {
+		w.Raw("\tsubjects := ")
+		writeEvSubj()
+		w.Byte('\n')
+		w.writeAuthorizeWildcard(p, wildcards)
+	}
+	if p.TabState != nil {
+		w.writeKeepTab()
+	}
 	w.Raw("\ts.handleStreamRequest(w, r,")
+	w.writeStreamPageArg(p)
 	if needsAuth {
 		w.Raw(" sessToken, sess,")
 	} else if w.usage.streamAuth {
@@ -944,29 +1083,12 @@ func (w *Writer) writePageGETStreamHandler(
 		w.Raw(`{},`)
 	}
 	w.Raw(" ")
-	w.Raw(evSubjName)
-	if hasPrivate && hasSignalScoped {
-		w.Raw("(sess.UserID()")
-		for _, ident := range signalIdents {
-			w.Raw(", subjSignals.")
-			w.Raw(ident)
-		}
-		w.Raw("),\n")
-	} else if hasPrivate {
-		w.Raw("(sess.UserID()),\n")
-	} else if hasSignalScoped {
-		w.Raw("(")
-		for i, ident := range signalIdents {
-			if i > 0 {
-				w.Raw(", ")
-			}
-			w.Raw("subjSignals.")
-			w.Raw(ident)
-		}
-		w.Raw("),\n")
+	if len(wildcards) > 0 {
+		w.Raw("subjects")
 	} else {
-		w.Raw(",\n")
+		writeEvSubj()
 	}
+	w.Raw(",\n")
 	w.writePageStreamOpenHook(p)
 	w.writePageStreamCloseHook(p)
 	w.Line(1, "func(")
@@ -977,8 +1099,8 @@ func (w *Writer) writePageGETStreamHandler(
 		w.Line(2, "for range ch {")
 		w.Line(2, "}")
 	} else {
-		w.writeStreamEventVars(p.EventHandlers, appPkg)
 		w.Line(2, "for msg := range ch {")
+		w.writeStripSubjectPrefix()
 		// An event matched by prefix cannot be compared against: its constant
 		// is the pattern the stream subscribed by, and a message carries the values.
 		needsPrefixMatch := false
@@ -1011,28 +1133,52 @@ func (w *Writer) writePageGETStreamHandler(
 	w.Line(0, "}")
 }
 
-// writeStreamEventVars declares the event of every case of the message loop.
-// One declaration per stream keeps the decode of every message off the heap.
-func (w *Writer) writeStreamEventVars(
-	handlers []*model.EventHandler, appPkg string,
+// writeAuthorizeWildcard emits the call of the AuthorizeWildcard hook, which
+// adds the subjects of the wildcard handlers to those of the stream.
+func (w *Writer) writeAuthorizeWildcard(
+	p *model.Page, wildcards []*model.EventHandler,
 ) {
-	var declared []string
-	for _, eh := range handlers {
-		ev := w.eventMap[eh.EventTypeName]
-		if ev == nil || slices.Contains(declared, ev.TypeName) {
-			continue
+	if p.AuthorizeWildcard == nil {
+		return // Reported by the parser, the stream never gets them.
+	}
+	w.Raw("\tallowed, err := ")
+	w.writeCallExpr("p", "AuthorizeWildcard",
+		handlerInputArgs(p.AuthorizeWildcard, false, ""))
+	w.Byte('\n')
+	w.Line(1, "if err != nil {")
+	w.Line(2, `s.httpErrIntern(w, r, nil, "authorizing wildcard subscriptions", err)`)
+	w.Line(2, "return")
+	w.Line(1, "}")
+	w.Line(1, "if allowed {")
+	w.Line(2, "// The wildcard handlers receive their events for every value.")
+	w.Line(2, "subjects = append(slices.Clip(subjects),")
+	for _, eh := range wildcards {
+		if ev := w.eventMap[eh.EventTypeName]; ev != nil {
+			w.Raw("\t\t\t")
+			w.Raw(evSubjConst(ev))
+			w.Raw(",\n")
 		}
-		declared = append(declared, ev.TypeName)
-		w.Raw("\t\tvar ")
-		w.Raw(eventVarName(ev.TypeName))
+	}
+	w.Line(2, ")")
+	w.Line(1, "}")
+}
+
+// writeStreamPageArg emits the page argument of handleStreamRequest,
+// which labels the stream metrics and only exists with metrics enabled.
+func (w *Writer) writeStreamPageArg(p *model.Page) {
+	if w.metrics() {
 		w.Byte(' ')
-		w.Raw(appPkg)
-		w.Byte('.')
-		w.Raw(ev.TypeName)
-		w.Byte('\n')
+		w.writeQuoted(p.TypeName)
+		w.Byte(',')
 	}
 }
 
+// writeStripSubjectPrefix emits the first statement of a message loop, which
+// hands the cases the subject without the datapages.WithSubjectPrefix namespace.
+func (w *Writer) writeStripSubjectPrefix() {
+	w.Line(3, "msg.Subject = strings.TrimPrefix(msg.Subject, s.subjectPrefix)")
+}
+
 func (w *Writer) writeStreamEventCase(
 	p *model.Page, eh *model.EventHandler, ev *model.Event,
 	appPkg string, tagged bool,
@@ -1053,50 +1199,127 @@ func (w *Writer) writeStreamEventCase(
 		w.Raw(":\n")
 	}
 
+	// Every stream receiving the message shares the one decoded value.
 	eventVar := eventVarName(ev.TypeName)
-	w.Byte('\t')
-	w.Raw("\t\t\t")
+	w.Raw("\t\t\t\t")
 	w.Raw(eventVar)
-	w.Raw(" = ")
+	w.Raw(", err := eventcache.Decode[")
 	w.Raw(appPkg)
 	w.Byte('.')
 	w.Raw(ev.TypeName)
-	w.Raw("{}\n")
-	w.Line(4, "if err := json.Unmarshal(msg.Data, &"+eventVar+"); err != nil {")
-	w.Raw("\t\t\t\t\ts.LogErr(\"unmarshaling ")
+	w.Raw("](\n")
+	w.Line(5, "s.eventCache, s.eventCodec, msg,")
+	w.Line(4, ")")
+	w.Line(4, "if err != nil {")
+	w.Raw("\t\t\t\t\ts.LogErrCtx(sse.Context(), \"decoding ")
 	w.Raw(ev.TypeName)
-	w.Raw(" JSON\", err)\n")
+	w.Raw("\", err)\n")
 	w.Line(5, "continue")
 	w.Line(4, "}")
+	if eh.Wildcard {
+		w.writeWildcardSubjectValues(ev, eventVar)
+	}
 
-	w.writeEventHandlerCall(p.TypeName, eh, "p", eventVar)
+	w.writeEventHandlerCall(p, eh, "p", eventVar)
+}
+
+// writeWildcardSubjectValues emits the assignment of the subject fields of
+// an event a wildcard handler receives. The subject names the values even for
+// fields the payload leaves out.
+func (w *Writer) writeWildcardSubjectValues(ev *model.Event, eventVar string) {
+	w.Linef(4, "if v, ok := subject.Values(msg.Subject, %s, %d); ok {",
+		evSubjPrefConst(ev), len(ev.SubjectFields))
+	for i, sf := range ev.SubjectFields {
+		w.Linef(5, "%s.%s = %s(v[%d])", eventVar, sf.FieldName, sf.Kind, i)
+	}
+	w.Line(4, "}")
 }
 
 func (w *Writer) writeEventHandlerCall(
-	ownerLabel string, eh *model.EventHandler, receiver, eventVar string,
+	p *model.Page, eh *model.EventHandler, receiver, eventVar string,
 ) {
+	ownerLabel := p.TypeName
+
 	// Build args in user-defined order.
 	args := eventHandlerInputArgs(eh, eventVar)
 
 	methodName := "On" + eh.Name
 
-	if eh.OutputErr != nil {
-		w.Raw("\t\t\t\tif err := ")
+	// The handler runs in a closure of its own so that the span ends
+	// however the handler returns. The log lines carry the request ID the
+	// event was dispatched in, the span links to the span it was
+	// dispatched in, and the handler gets the context of both.
+	w.Line(4, "func() {")
+	w.Raw("\t\t\t\t\tctx := reqlog.Event(sse.Context(), ")
+	w.writeQuoted(ownerLabel + "." + methodName)
+	w.Raw(", msg)\n")
+	w.Raw("\t\t\t\t\tctx, span := tracing.StartEvent(s.tracer, ctx, ")
+	w.writeQuoted(ownerLabel + "." + methodName)
+	w.Raw(", msg)\n")
+	w.Line(5, "defer span.End()")
+	// The labels of the event handler metrics.
+	promLabels := `"` + ownerLabel + `", "` + methodName + `", "` +
+		eh.EventTypeName + `"`
+	if w.metrics() {
+		w.Line(5, "start := time.Now()")
+	}
+	if eh.InputTabState != nil {
+		w.writeMakeTabState(5, p, "ctx")
+	}
+	if eh.OutputRerender != nil {
+		// The page is rendered as its GET would once the handler succeeded.
+		w.Raw("\t\t\t\t\tif rerender, err := ")
 		w.writeCallExpr(receiver, methodName, args)
 		w.Raw("; err != nil {\n")
-		w.Raw("\t\t\t\t\ts.LogErr(\"handling ")
+		w.writeEventHandlerErr(ownerLabel, methodName, promLabels)
+		w.Line(5, "} else if rerender {")
+		rerenderCtx := "ctx"
+		if p.TabState != nil {
+			// The page of the tab of the stream, not a new one.
+			rerenderCtx = "tabs.NewRerenderContext(ctx, tabRef.ID)"
+		}
+		w.Raw("\t\t\t\t\t\tif err := dpsse.Rerender(" + rerenderCtx + ", sse, r, s.handle")
+		w.Raw(ownerLabel)
+		w.Raw("GET); err != nil {\n")
+		w.Raw("\t\t\t\t\t\t\ts.LogErrCtx(ctx, \"rerendering ")
 		w.Raw(ownerLabel)
-		w.Byte('.')
-		w.Raw(methodName)
 		w.Raw("\", err)\n")
-		w.Line(4, "}")
+		w.Line(6, "}")
+		w.Line(5, "}")
+	} else if eh.OutputErr != nil {
+		w.Raw("\t\t\t\t\tif err := ")
+		w.writeCallExpr(receiver, methodName, args)
+		w.Raw("; err != nil {\n")
+		w.writeEventHandlerErr(ownerLabel, methodName, promLabels)
+		w.Line(5, "}")
 	} else {
-		w.Raw("\t\t\t\t")
+		w.Raw("\t\t\t\t\t")
 		w.writeCallExpr(receiver, methodName, args)
 		w.Byte('\n')
 	}
+	if w.metrics() {
+		w.Line(5, w.metricsRecv("s")+"EventHandlerDuration("+promLabels+", start)")
+	}
+	w.Line(4, "}()")
+}
+
+// writeEventHandlerErr emits the body of the branch taken
+// when an event handler returned an error.
+func (w *Writer) writeEventHandlerErr(ownerLabel, methodName, promLabels string) {
+	w.Line(6, "tracing.Fail(span, err)")
+	if w.metrics() {
+		w.Line(6, w.metricsRecv("s")+"EventHandlerError("+promLabels+")")
+	}
+	w.Raw("\t\t\t\t\t\ts.LogErrCtx(ctx, \"handling ")
+	w.Raw(ownerLabel)
+	w.Byte('.')
+	w.Raw(methodName)
+	w.Raw("\", err)\n")
 }
 
+// writePageStreamOpenHook emits the hook handleStreamRequest calls once the
+// stream is open. The dispatchers of StreamOpen are made in the hook, they
+// publish with the context of its span.
 func (w *Writer) writePageStreamOpenHook(p *model.Page) {
 	if p.StreamOpen == nil {
 		w.Line(1, "nil,")
@@ -1106,28 +1329,50 @@ func (w *Writer) writePageStreamOpenHook(p *model.Page) {
 	w.Line(2, "streamID datapages.StreamID,")
 	w.Line(2, "sse *datastar.ServerSentEventGenerator,")
 	w.Line(1, ") error {")
+	w.writeHandlerStart(2, p.TypeName, "StreamOpen")
+	w.writeDispatchers(2, p.StreamOpen, "dispatchOpen",
+		"context.WithoutCancel(r.Context())")
+	if len(p.StreamOpen.InputDispatches) > 0 {
+		w.Line(0, "")
+	}
 	if p.StreamOpen.OutputErr != nil {
-		w.Raw("\t\treturn ")
+		w.Raw("\t\terr := ")
+		w.writeCallExpr(
+			"p", "StreamOpen",
+			handlerInputArgs(p.StreamOpen, false, "dispatchOpen"),
+		)
+		w.Byte('\n')
+		w.Line(2, "if err != nil {")
+		w.Line(3, "tracing.Fail(span, err)")
+		w.Line(2, "}")
+		w.Line(2, "return err")
 	} else {
 		w.Raw("\t\t")
-	}
-	w.writeCallExpr(
-		"p", "StreamOpen",
-		handlerInputArgs(p.StreamOpen, false, "dispatchOpen"),
-	)
-	w.Byte('\n')
-	if p.StreamOpen.OutputErr == nil {
+		w.writeCallExpr(
+			"p", "StreamOpen",
+			handlerInputArgs(p.StreamOpen, false, "dispatchOpen"),
+		)
+		w.Byte('\n')
 		w.Line(2, "return nil")
 	}
 	w.Line(1, "},")
 }
 
+// writePageStreamCloseHook emits the hook handleStreamRequest calls once the
+// stream has ended. Its request is done by then, the dispatchers of
+// StreamClose publish with a context that outlives it.
 func (w *Writer) writePageStreamCloseHook(p *model.Page) {
 	if p.StreamClose == nil {
 		w.Line(1, "nil,")
 		return
 	}
 	w.Line(1, "func(streamID datapages.StreamID) {")
+	w.writeHandlerStart(2, p.TypeName, "StreamClose")
+	w.writeDispatchers(2, p.StreamClose, "dispatchClosed",
+		"context.WithoutCancel(r.Context())")
+	if len(p.StreamClose.InputDispatches) > 0 {
+		w.Line(0, "")
+	}
 	if p.StreamClose.OutputErr != nil {
 		w.Raw("\t\tif err := ")
 		w.writeCallExpr(
@@ -1135,7 +1380,8 @@ func (w *Writer) writePageStreamCloseHook(p *model.Page) {
 			handlerInputArgs(p.StreamClose, false, "dispatchClosed"),
 		)
 		w.Raw("; err != nil {\n")
-		w.Raw("\t\t\ts.LogErr(\"handling ")
+		w.Line(3, "tracing.Fail(span, err)")
+		w.Raw("\t\t\ts.LogErrCtx(r.Context(), \"handling ")
 		w.Raw(p.TypeName)
 		w.Raw(".StreamClose\", err)\n")
 		w.Line(2, "}")
@@ -1158,6 +1404,7 @@ func (w *Writer) writePageGETStreamAnonHandler(
 	w.Raw("func (s *Server) handle")
 	w.Raw(p.TypeName)
 	w.Raw("GETStreamAnon(w http.ResponseWriter, r *http.Request) {\n")
+	w.writeSetLogHandler(1, p.TypeName+".Stream")
 
 	w.Line(1, "if !s.checkIsDSReq(w, r) {")
 	w.Line(2, "return")
@@ -1219,41 +1466,64 @@ func (w *Writer) writePageGETStreamAnonHandler(
 		w.Line(2, "return")
 		w.Line(1, "}")
 	}
-	if p.StreamOpen != nil {
-		w.writeDispatchers(p.StreamOpen, "dispatchOpen",
-			"context.WithoutCancel(r.Context())")
-	}
-	if p.StreamClose != nil {
-		w.writeDispatchers(p.StreamClose, "dispatchClosed",
-			"context.WithoutCancel(r.Context())")
+
+	// Tab instance.
+	if p.TabState != nil {
+		w.Line(0, "")
+		w.writeReadTab(1)
 	}
 
-	// Page constructor.
-	w.Raw("\n\tp := ")
-	w.writePageConstructor(p, appPkg)
-	w.Byte('\n')
+	// Page constructor. A page kept open for its tab state alone
+	// has nothing that calls it.
+	if pageStreamUsesPage(p) {
+		w.Raw("\n\tp := ")
+		w.writePageConstructor(p, appPkg)
+		w.Byte('\n')
+	}
 
 	// evSubj call (for anon, pass empty userID to get public-only subjects).
-	w.Raw("\ts.handleStreamRequest(w, r, sessToken, sess, evSubj")
-	w.Raw(p.TypeName)
-	w.Raw("(sess.UserID()")
-	for _, ident := range signalIdents {
-		w.Raw(", subjSignals.")
-		w.Raw(ident)
+	writeEvSubj := func() {
+		w.Raw("evSubj")
+		w.Raw(p.TypeName)
+		w.Raw("(sess.UserID()")
+		for _, ident := range signalIdents {
+			w.Raw(", subjSignals.")
+			w.Raw(ident)
+		}
+		w.Raw(")")
+	}
+	wildcards := pageWildcardHandlers(p)
+	if len(wildcards) > 0 {
+		w.Raw("\tsubjects := ")
+		writeEvSubj()
+		w.Byte('\n')
+		w.writeAuthorizeWildcard(p, wildcards)
+	}
+	if p.TabState != nil {
+		w.writeKeepTab()
+	}
+	w.Raw("\ts.handleStreamRequest(w, r,")
+	w.writeStreamPageArg(p)
+	w.Raw(" sessToken, sess, ")
+	if len(wildcards) > 0 {
+		w.Raw("subjects")
+	} else {
+		writeEvSubj()
 	}
-	w.Raw("),\n")
+	w.Raw(",\n")
 	w.writePageStreamOpenHook(p)
 	w.writePageStreamCloseHook(p)
 	w.Line(1, "func(")
 	w.Line(2, "streamID datapages.StreamID,")
 	w.Line(2, "sse *datastar.ServerSentEventGenerator, ch <-chan messaging.Message,")
 	w.Line(1, ") {")
-	// Only public events reach an anonymous stream.
+	// Only public events reach an anonymous stream,
+	// and those of the wildcard handlers it is allowed.
 	var publicHandlers []*model.EventHandler
 	needsPrefixMatch := false
 	for _, eh := range p.EventHandlers {
 		ev := w.eventMap[eh.EventTypeName]
-		if ev == nil || ev.IsPrivate() {
+		if ev == nil || (ev.IsPrivate() && !eh.Wildcard) {
 			continue
 		}
 		publicHandlers = append(publicHandlers, eh)
@@ -1262,8 +1532,8 @@ func (w *Writer) writePageGETStreamAnonHandler(
 		}
 	}
 
-	w.writeStreamEventVars(publicHandlers, appPkg)
 	w.Line(2, "for msg := range ch {")
+	w.writeStripSubjectPrefix()
 
 	// An event matched by prefix cannot be compared against: its constant is
 	// the pattern the stream subscribed by, and a message carries the values.
@@ -1295,6 +1565,7 @@ func (w *Writer) writePageActionHandler(
 	w.Raw("(\n")
 	w.Line(1, "w http.ResponseWriter, r *http.Request,")
 	w.Line(0, ") {")
+	w.writeHandlerStart(1, p.TypeName, actionMethodName(h))
 
 	if h.InputSSE != nil || h.InputSignals != nil {
 		w.Line(1, "if !s.checkIsDSReq(w, r) {")
@@ -1325,6 +1596,12 @@ func (w *Writer) writePageActionHandler(
 		w.writeCSRFOnlyCheck()
 	}
 
+	// Tab instance.
+	if h.InputTabState != nil {
+		w.writeReadTab(1)
+		w.writeMakeTabState(1, p, "r.Context()")
+	}
+
 	// Body size limit.
 	if h.InputSignals != nil && h.InputSSE == nil {
 		w.Line(1, "r.Body = http.MaxBytesReader(w, r.Body, DefaultBodySizeLimit)")
@@ -1352,7 +1629,7 @@ func (w *Writer) writePageActionHandler(
 	}
 
 	// Dispatch closures.
-	w.writeDispatchers(h, "dispatch", "r.Context()")
+	w.writeDispatchers(1, h, "dispatch", "r.Context()")
 
 	// SSE for actions that take it.
 	if h.InputSSE != nil {
@@ -1417,6 +1694,9 @@ func (w *Writer) writeActionMethodCall(
 		w.Line(1, "}")
 	}
 
+	// Deferred events.
+	w.writeDeferredPublish(h, p.TypeName)
+
 	// Close and create session.
 	w.writeSessionOutputs(h)
 
@@ -1461,7 +1741,7 @@ func (w *Writer) writeActionMethodCall(
 		w.Raw(outputVar(h.OutputBody.Output))
 		w.Raw(", nil, nil,\n")
 		w.Line(1, "); err != nil {")
-		w.Raw("\t\ts.LogErr(\"rendering response of ")
+		w.Raw("\t\ts.LogErrCtx(r.Context(), \"rendering response of ")
 		w.Raw(p.TypeName)
 		w.Byte('.')
 		w.Raw(h.HTTPMethod)
@@ -1472,6 +1752,45 @@ func (w *Writer) writeActionMethodCall(
 	}
 }
 
+// writeDeferredPublish emits the publish of the events a handler returning
+// datapages.DeferDispatch held back. It runs once the error check has let the
+// handler's outcome through, so an error or a panic skips it and the events
+// die with the request. A handler returning false drops them.
+func (w *Writer) writeDeferredPublish(h *model.Handler, ownerName string) {
+	if h.OutputDeferDispatch == nil {
+		return
+	}
+	sseRef := "nil"
+	if h.InputSSE != nil {
+		sseRef = "sse"
+	}
+	w.Raw("\tif ")
+	w.Raw(outputVar(h.OutputDeferDispatch))
+	w.Raw(" {\n")
+	w.Line(2, "if err := deferred.Publish(")
+	w.Line(3, "s.messageBroker, s.messageBrokerMetrics,")
+	w.Line(2, "); err != nil {")
+	w.Raw("\t\t\ts.httpErrIntern(w, r, ")
+	w.Raw(sseRef)
+	w.Raw(", \"publishing deferred events of ")
+	w.Raw(ownerName)
+	w.Byte('.')
+	w.Raw(h.Name)
+	w.Raw("\", err)\n")
+	w.Line(3, "return")
+	w.Line(2, "}")
+	// The zero value backs out without failing the request,
+	// which the debug log tells apart from a lost event.
+	w.Line(1, "} else if n := deferred.Drop(); n > 0 {")
+	w.Line(2, "s.RequestLogger(r.Context()).DebugContext(r.Context(),")
+	w.Raw("\t\t\t\"dropping deferred events of ")
+	w.Raw(ownerName)
+	w.Byte('.')
+	w.Raw(h.Name)
+	w.Raw("\", slog.Int(\"events\", n))\n")
+	w.Line(1, "}")
+}
+
 // writeActionErrCheck emits the error-handling body for action handlers.
 // All errors are routed through httpErrIntern, which calls RecoverError
 // if available and falls back to the datapages error sentinels or 500.
@@ -1653,11 +1972,48 @@ func (w *Writer) writeParseField(
 	}
 }
 
+// writeReflectHistory writes the end of the data-effect syncing the URL with
+// the reflected signals, writeRoute writing the route mid-backtick.
+// Without push fields every change replaces the history entry.
+// Otherwise a change of a push field pushes one, which the first run of
+// the effect, on page load, never does.
+func (w *Writer) writeReflectHistory(fields []reflectSignalField, writeRoute func()) {
+	var push []string
+	for _, f := range fields {
+		if f.Push {
+			push = append(push, "$"+f.SignalName)
+		}
+	}
+	if len(push) == 0 {
+		w.Raw("\t\t\twindow.history.replaceState(null, '', query ? '")
+		writeRoute()
+		w.Raw("?' + query : '")
+		writeRoute()
+		w.Raw("');\n")
+		w.Line(2, "\"`)")
+		return
+	}
+	w.Raw("\t\t\tconst url = query ? '")
+	writeRoute()
+	w.Raw("?' + query : '")
+	writeRoute()
+	w.Raw("';\n")
+	w.Raw("\t\t\tconst pushed = JSON.stringify([")
+	w.Raw(strings.Join(push, ", "))
+	w.Raw("]);\n")
+	w.Line(3, "if (el.dpPushed === undefined || el.dpPushed === pushed) "+
+		"window.history.replaceState(null, '', url);")
+	w.Line(3, "else window.history.pushState(null, '', url);")
+	w.Line(3, "el.dpPushed = pushed;")
+	w.Line(2, "\"`)")
+}
+
 type reflectSignalField struct {
 	SignalName string
 	FieldName  string
 	Type       types.Type
 	QueryTag   string
+	Push       bool // Changes push a history entry instead of replacing it.
 }
 
 // writeStringConv writes inner, converted to t when t is
@@ -0,0 +1,319 @@
+package generator
+
+import (
+	"go/types"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/romshark/datapages/internal/parser/model"
+	"github.com/romshark/datapages/internal/structtag"
+)
+
+// WritePkgSignals generates code for the datapagesgen/signals package and appends it to buffer.
+func (w *Writer) WritePkgSignals(m *model.App) {
+	st := signalTypes{
+		appPkg:    m.PkgPath,
+		imports:   map[string]string{},
+		rendering: map[*types.TypeName]bool{},
+	}
+	type page struct {
+		name   string
+		fields []signalField
+	}
+	var pages []page
+	for _, p := range m.Pages {
+		if fields := pageSignals(p, &st); len(fields) > 0 {
+			pages = append(pages, page{p.TypeName, fields})
+		}
+	}
+
+	w.Line(0, "// Code generated by github.com/romshark/datapages; DO NOT EDIT.")
+	w.Line(0, "")
+	w.Line(0, "// Package signals provides the Datastar signals of each page, merged from")
+	w.Line(0, "// the Signals of the GET, action and StreamOpen handlers of the page.")
+	w.Line(0, "// Patch them with the PatchXXX functions and name them in templates with")
+	w.Line(0, "// the signal name constants, so renaming a signal breaks the build")
+	w.Line(0, "// instead of the page.")
+	w.Line(0, "package signals")
+	if len(pages) > 0 {
+		w.Line(0, "")
+		w.Line(0, "import (")
+		if st.json {
+			w.Line(1, `"encoding/json"`)
+		}
+		for _, path := range slices.Sorted(maps.Keys(st.imports)) {
+			// Named whenever the name isn't the last element of the path,
+			// goimports would take the import for unused otherwise.
+			if name := st.imports[path]; name != appPkgName(path) {
+				w.Linef(1, "%s %q", name, path)
+			} else {
+				w.Linef(1, "%q", path)
+			}
+		}
+		w.Line(0, "")
+		w.Line(1, `"github.com/romshark/datapages"`)
+		w.Line(0, ")")
+	}
+
+	for _, p := range pages {
+		w.Line(0, "")
+		w.Linef(0, "// %s holds the signals of %s.", p.name, p.name)
+		w.Line(0, "// A nil field leaves the signal as it is on the client.")
+		w.Linef(0, "type %s struct {", p.name)
+		for _, f := range p.fields {
+			if f.conflict {
+				w.Line(1, "// Declared with different types by the handlers of the page.")
+			}
+			w.Linef(1, "%s *%s `json:%q`", f.Name, f.Type, f.Signal+",omitempty")
+		}
+		w.Line(0, "}")
+
+		w.Line(0, "")
+		w.Linef(0, "// Signal names of %s.", p.name)
+		w.Line(0, "const (")
+		for _, f := range p.fields {
+			w.Linef(1, "%sSignal%s = %q", p.name, f.Name, f.Signal)
+		}
+		w.Line(0, ")")
+
+		w.Line(0, "")
+		w.Linef(0, "// Patch%s patches the signals set in s on the client,", p.name)
+		w.Line(0, "// leaving the others as they are.")
+		w.Linef(0, "func Patch%s(sse datapages.SSE, s %s) error {", p.name, p.name)
+		w.Line(1, "return sse.PatchSignals(s)")
+		w.Line(0, "}")
+
+		w.Line(0, "")
+		w.Linef(0, "// Patch%sIfMissing is Patch%s for the signals", p.name, p.name)
+		w.Line(0, "// the client doesn't have yet.")
+		w.Linef(0, "func Patch%sIfMissing(sse datapages.SSE, s %s) error {", p.name, p.name)
+		w.Line(1, "return sse.PatchSignalsIfMissing(s)")
+		w.Line(0, "}")
+	}
+}
+
+// signalField is a signal of a page.
+type signalField struct {
+	Name     string // Go field name.
+	Signal   string // Signal name, the json tag.
+	Type     string
+	conflict bool // Handlers declare the signal with different types.
+}
+
+// pageSignals merges the signals of the handlers of p
+// in the order they are first declared in.
+func pageSignals(p *model.Page, st *signalTypes) []signalField {
+	var handlers []*model.Handler
+	if p.GET != nil && p.GET.Handler != nil {
+		handlers = append(handlers, p.GET.Handler)
+	}
+	handlers = append(handlers, p.Actions...)
+	if p.StreamOpen != nil {
+		handlers = append(handlers, p.StreamOpen)
+	}
+
+	var fields []signalField
+	bySignal := map[string]int{}
+	names := map[string]bool{}
+	for _, h := range handlers {
+		if h.InputSignals == nil || h.InputSignals.Type.Resolved == nil {
+			continue
+		}
+		s, ok := h.InputSignals.Type.Resolved.Underlying().(*types.Struct)
+		if !ok {
+			continue
+		}
+		for i := range s.NumFields() {
+			f := s.Field(i)
+			signal := structtag.JSONTagValue(s.Tag(i))
+			if !f.Exported() || signal == "" || structtag.JSONTagExcluded(s.Tag(i)) {
+				continue
+			}
+			typ := st.render(f.Type())
+			if j, ok := bySignal[signal]; ok {
+				if fields[j].Type != typ {
+					fields[j].Type, fields[j].conflict = "any", true
+				}
+				continue
+			}
+			// Two signals of different handlers may share a field name.
+			name := f.Name()
+			for n := 2; names[name]; n++ {
+				name = f.Name() + strconv.Itoa(n)
+			}
+			names[name] = true
+			bySignal[signal] = len(fields)
+			fields = append(fields, signalField{Name: name, Signal: signal, Type: typ})
+		}
+	}
+	return fields
+}
+
+// signalTypes renders the types of signals for the signals package.
+// The app package imports it, so it can't import the app package:
+// types declared there are spelled out as their underlying types.
+type signalTypes struct {
+	appPkg  string
+	imports map[string]string // Import path to the name it's imported under.
+	json    bool              // A type is json.RawMessage.
+
+	// App types being spelled out, which a recursive type repeats.
+	rendering map[*types.TypeName]bool
+}
+
+func (st *signalTypes) render(t types.Type) string {
+	switch t := t.(type) {
+	case *types.Alias:
+		return st.render(types.Unalias(t))
+	case *types.Named:
+		obj := t.Obj()
+		if obj.Pkg() == nil {
+			return obj.Name() // error
+		}
+		if obj.Pkg().Path() == st.appPkg {
+			if marshalsItself(t) {
+				// Its JSON is not that of its underlying type.
+				st.json = true
+				return "json.RawMessage"
+			}
+			if st.rendering[obj] {
+				// Spelling it out again would never end.
+				return "any"
+			}
+			st.rendering[obj] = true
+			defer delete(st.rendering, obj)
+			return st.render(t.Underlying())
+		}
+		var b strings.Builder
+		b.WriteString(st.importName(obj.Pkg()))
+		b.WriteByte('.')
+		b.WriteString(obj.Name())
+		if args := t.TypeArgs(); args.Len() > 0 {
+			b.WriteByte('[')
+			for i := range args.Len() {
+				if i > 0 {
+					b.WriteString(", ")
+				}
+				b.WriteString(st.render(args.At(i)))
+			}
+			b.WriteByte(']')
+		}
+		return b.String()
+	case *types.Basic:
+		return t.Name()
+	case *types.Pointer:
+		return "*" + st.render(t.Elem())
+	case *types.Slice:
+		return "[]" + st.render(t.Elem())
+	case *types.Array:
+		return "[" + strconv.FormatInt(t.Len(), 10) + "]" + st.render(t.Elem())
+	case *types.Map:
+		return "map[" + st.render(t.Key()) + "]" + st.render(t.Elem())
+	case *types.Struct:
+		var b strings.Builder
+		b.WriteString("struct {")
+		st.writeFields(&b, t)
+		b.WriteString("}")
+		return b.String()
+	}
+	// Interfaces, and what JSON can't carry anyway.
+	return "any"
+}
+
+// writeFields writes the exported fields of s, the fields of an embedded
+// struct of the app package in place of it.
+func (st *signalTypes) writeFields(b *strings.Builder, s *types.Struct) {
+	for i := range s.NumFields() {
+		f := s.Field(i)
+		embedded := f.Embedded() && s.Tag(i) == ""
+		if embedded && st.isApp(f.Type()) {
+			if es, ok := deref(f.Type()).Underlying().(*types.Struct); ok {
+				// A struct embedding itself through a pointer adds no fields
+				// it doesn't have already.
+				if obj := deref(f.Type()).(*types.Named).Obj(); !st.rendering[obj] {
+					st.rendering[obj] = true
+					st.writeFields(b, es)
+					delete(st.rendering, obj)
+				}
+				continue
+			}
+			// Not a struct, encoded as a field of the name of its type.
+			embedded = false
+		}
+		if !f.Exported() {
+			continue
+		}
+		b.WriteString(" ")
+		if !embedded {
+			b.WriteString(f.Name())
+			b.WriteString(" ")
+		}
+		b.WriteString(st.render(f.Type()))
+		if tag := s.Tag(i); tag != "" {
+			b.WriteString(" ")
+			if strings.Contains(tag, "`") {
+				b.WriteString(strconv.Quote(tag))
+			} else {
+				b.WriteString("`" + tag + "`")
+			}
+		}
+		b.WriteString(";")
+	}
+}
+
+// importName returns the name pkg is imported under,
+// another one than its own if that's taken.
+func (st *signalTypes) importName(pkg *types.Package) string {
+	if name, ok := st.imports[pkg.Path()]; ok {
+		return name
+	}
+	name := pkg.Name()
+	for n := 2; st.nameTaken(name); n++ {
+		name = pkg.Name() + strconv.Itoa(n)
+	}
+	st.imports[pkg.Path()] = name
+	return name
+}
+
+func (st *signalTypes) nameTaken(name string) bool {
+	if name == "json" || name == "datapages" {
+		return true
+	}
+	for _, n := range st.imports {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isApp reports whether t, or what it points to, is declared in the app package.
+func (st *signalTypes) isApp(t types.Type) bool {
+	n, ok := deref(t).(*types.Named)
+	return ok && n.Obj().Pkg() != nil && n.Obj().Pkg().Path() == st.appPkg
+}
+
+func deref(t types.Type) types.Type {
+	if p, ok := types.Unalias(t).(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	return types.Unalias(t)
+}
+
+// marshalsItself reports whether t or *t implements
+// json.Marshaler or encoding.TextMarshaler.
+func marshalsItself(t *types.Named) bool {
+	for _, ms := range []*types.MethodSet{
+		types.NewMethodSet(t), types.NewMethodSet(types.NewPointer(t)),
+	} {
+		for i := range ms.Len() {
+			switch ms.At(i).Obj().Name() {
+			case "MarshalJSON", "MarshalText":
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -27,7 +27,7 @@ var (
 		"event handler must have a datapages.SSE parameter",
 	)
 	ErrSignatureEvHandReturnMustBeError = errors.New(
-		"event handler must return only error",
+		"event handler must return error or (datapages.Rerender, error)",
 	)
 	ErrSignatureEvHandMissingEvent = errors.New(
 		"event handler must have a parameter of an event type",
@@ -55,6 +55,10 @@ var (
 			`(error, datapages.SSE) error`,
 	)
 
+	ErrAppStreamDrainInvalidSignature = errors.New(
+		`"StreamDrain" must have signature (datapages.SSE) error`,
+	)
+
 	ErrPageMissingFieldApp     = errors.New(`page is missing the "App *App" field`)
 	ErrPageHasExtraFields      = errors.New(`page struct has unsupported fields`)
 	ErrPageMissingGET          = errors.New(`page is missing the GET handler`)
@@ -106,6 +110,7 @@ var (
 	ErrQueryFieldUnsupportedType = paramvalidation.ErrQueryFieldUnsupportedType
 
 	ErrQueryReflectSignalNotInSignals = paramvalidation.ErrQueryReflectSignalNotInSignals
+	ErrQueryReflectSignalOption       = paramvalidation.ErrQueryReflectSignalOption
 
 	ErrSignalsParamNotStruct    = paramvalidation.ErrSignalsParamNotStruct
 	ErrSignalsFieldUnexported   = paramvalidation.ErrSignalsFieldUnexported
@@ -119,6 +124,16 @@ var (
 		"all handlers must use the same datapages.Session[Data] instantiation",
 	)
 
+	ErrTabStateTypeConflict = errors.New(
+		"all handlers of a page must use the same datapages.TabState[T] instantiation",
+	)
+	ErrTabStateNotOnPage = errors.New(
+		"tab state can only be used in handlers of pages",
+	)
+	ErrTabStateErrorPage = errors.New(
+		"tab state cannot be used on error pages",
+	)
+
 	ErrNewSessionWithSSE = errors.New(
 		"newSession cannot be used together with sse parameter",
 	)
@@ -132,6 +147,12 @@ var (
 	ErrDisableRefreshNotGET = errors.New(
 		"disableRefreshAfterHidden can only be used in GET handlers",
 	)
+	ErrDeferDispatchNotAction = errors.New(
+		"deferDispatch can only be used in action handlers",
+	)
+	ErrRerenderNotEventHandler = errors.New(
+		"rerender can only be used in event handlers",
+	)
 
 	ErrSignatureUnsupportedOutput = errors.New(
 		"unsupported output return value",
@@ -143,6 +164,22 @@ var (
 		"conflicting stream hook in embedded",
 	)
 
+	ErrSignatureAuthorizeWildcard = errors.New(
+		"AuthorizeWildcard must return (bool, error)",
+	)
+	ErrAuthorizeWildcardDuplicateEmbed = errors.New(
+		"conflicting AuthorizeWildcard in embedded",
+	)
+	ErrAuthorizeWildcardUnused = errors.New(
+		"AuthorizeWildcard without a wildcard event handler",
+	)
+	ErrEvHandWildcardNoSubject = errors.New(
+		"wildcard event handler handles an event without subject fields",
+	)
+	ErrEvHandWildcardUnauthorized = errors.New(
+		"wildcard event handler on a page without AuthorizeWildcard",
+	)
+
 	ErrEventSubjectUserNoSession = errors.New(
 		"event addressing users requires a Session type",
 	)
@@ -196,6 +233,10 @@ var (
 	ErrTemplActionUnverifiableWithPrefix = templcheck.ErrActionUnverifiableWithPrefix
 	ErrTemplActionUnverifiableWithSuffix = templcheck.ErrActionUnverifiableWithSuffix
 	ErrTemplHrefExternalIsRelative       = templcheck.ErrHrefExternalIsRelative
+	ErrTemplSignalUnknown                = templcheck.ErrSignalUnknown
+	ErrTemplSignalUnused                 = templcheck.ErrSignalUnused
+	ErrTemplPatchElementNoID             = templcheck.ErrPatchElementNoID
+	ErrTemplPatchElementIDNotOnPage      = templcheck.ErrPatchElementIDNotOnPage
 )
 
 func normPos(pos token.Position) token.Position {
@@ -275,9 +316,12 @@ func (e errorEntry) Error() string {
 
 func (e errorEntry) Unwrap() error { return e.err }
 
+// Errors collects the errors found in the app package
+// and the warnings, which don't fail parsing.
 type Errors struct {
-	errs []errorEntry
-	seq  uint64
+	errs  []errorEntry
+	warns []errorEntry
+	seq   uint64
 }
 
 func (e *Errors) Error() string {
@@ -324,45 +368,73 @@ func (e *Errors) ErrAt(pos token.Position, err error) {
 	})
 }
 
+// WarnAt records a warning at pos. Warnings aren't counted by Len.
+func (e *Errors) WarnAt(pos token.Position, err error) {
+	if err == nil {
+		return
+	}
+	e.seq++
+	e.warns = append(e.warns, errorEntry{
+		pos: normPos(pos),
+		seq: e.seq,
+		err: err,
+	})
+}
+
+// WarnEntry returns the position and the error of the i-th warning.
+func (e *Errors) WarnEntry(i int) (token.Position, error) {
+	if i >= len(e.warns) {
+		return token.Position{}, nil
+	}
+	en := e.warns[i]
+	return en.pos, en.err
+}
+
+// WarnLen returns the number of warnings.
+func (e *Errors) WarnLen() int { return len(e.warns) }
+
 func sortErrors(e *Errors) {
 	if e == nil {
 		return
 	}
-	slices.SortFunc(e.errs, func(a, b errorEntry) int {
-		az, bz := a.pos.Filename == "", b.pos.Filename == ""
-		if az != bz {
-			if az {
-				return 1
-			}
-			return -1
-		}
-		if a.pos.Filename != b.pos.Filename {
-			if a.pos.Filename < b.pos.Filename {
-				return -1
-			}
-			return 1
-		}
-		if a.pos.Line != b.pos.Line {
-			if a.pos.Line < b.pos.Line {
-				return -1
-			}
+	slices.SortFunc(e.errs, compareEntries)
+	slices.SortFunc(e.warns, compareEntries)
+}
+
+func compareEntries(a, b errorEntry) int {
+	az, bz := a.pos.Filename == "", b.pos.Filename == ""
+	if az != bz {
+		if az {
 			return 1
 		}
-		if a.pos.Column != b.pos.Column {
-			if a.pos.Column < b.pos.Column {
-				return -1
-			}
-			return 1
+		return -1
+	}
+	if a.pos.Filename != b.pos.Filename {
+		if a.pos.Filename < b.pos.Filename {
+			return -1
 		}
-		// Two errors at one position keep the order they were reported in.
-		if a.seq < b.seq {
+		return 1
+	}
+	if a.pos.Line != b.pos.Line {
+		if a.pos.Line < b.pos.Line {
 			return -1
 		}
-		if a.seq > b.seq {
-			return 1
+		return 1
+	}
+	if a.pos.Column != b.pos.Column {
+		if a.pos.Column < b.pos.Column {
+			return -1
 		}
-		return 0
-	})
+		return 1
+	}
+	// Two errors at one position keep the order they were reported in.
+	if a.seq < b.seq {
+		return -1
+	}
+	if a.seq > b.seq {
+		return 1
+	}
+	return 0
 }
 
 // ErrorPageMissingFieldApp is ErrPageMissingFieldApp with suggestion context.
@@ -704,6 +776,10 @@ type (
 	ErrorTemplActionUnverifiableWithPrefix = templcheck.ErrorActionUnverifiableWithPrefix
 	ErrorTemplActionUnverifiableWithSuffix = templcheck.ErrorActionUnverifiableWithSuffix
 	ErrorTemplHrefExternalIsRelative       = templcheck.ErrorHrefExternalIsRelative
+	ErrorTemplSignalUnknown                = templcheck.ErrorSignalUnknown
+	ErrorTemplSignalUnused                 = templcheck.ErrorSignalUnused
+	ErrorTemplPatchElementNoID             = templcheck.ErrorPatchElementNoID
+	ErrorTemplPatchElementIDNotOnPage      = templcheck.ErrorPatchElementIDNotOnPage
 )
 
 // ErrorSignatureUnsupportedInput is ErrSignatureUnsupportedInput with context.
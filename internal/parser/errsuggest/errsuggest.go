@@ -75,6 +75,17 @@ func Suggest(err error) string {
 	case errors.Is(err, parser.ErrSignatureEvHandMultipleEvents):
 		return "fix: Keep one parameter of an EventXXX type and remove the rest"
 
+	case errors.Is(err, parser.ErrSignatureAuthorizeWildcard):
+		return "fix: Return `(allowed bool, err error)`"
+
+	case errors.Is(err, parser.ErrEvHandWildcardUnauthorized):
+		return "fix: Add `func (PageXXX) AuthorizeWildcard(" +
+			"r *http.Request, session Session) (bool, error)`"
+
+	case errors.Is(err, parser.ErrAuthorizeWildcardUnused):
+		return "fix: Mark an event handler `//datapages:wildcard` " +
+			"or remove AuthorizeWildcard"
+
 	case errors.Is(err, parser.ErrPageMissingFieldApp):
 		var d *parser.ErrorPageMissingFieldApp
 		if !errors.As(err, &d) {
@@ -424,6 +435,59 @@ func Suggest(err error) string {
 			d.ActionFunc,
 		)
 
+	case errors.Is(err, parser.ErrTemplSignalUnknown):
+		var d *parser.ErrorTemplSignalUnknown
+		if !errors.As(err, &d) {
+			return ""
+		}
+		if strings.EqualFold(d.Signal, d.Closest) {
+			return fmt.Sprintf(
+				"fix: Browsers lowercase attribute names, "+
+					"declare it as data-signals:%s=\"...\"",
+				signalAttrKey(d.Signal),
+			)
+		}
+		if d.Closest != "" {
+			return fmt.Sprintf("fix: Did you mean %s?", d.Closest)
+		}
+		return fmt.Sprintf(
+			"fix: Declare the signal in a template of %s, "+
+				"e.g. data-signals:%s=\"...\"",
+			d.PageType, signalAttrKey(d.Signal),
+		)
+
+	case errors.Is(err, parser.ErrTemplSignalUnused):
+		var d *parser.ErrorTemplSignalUnused
+		if !errors.As(err, &d) {
+			return ""
+		}
+		return fmt.Sprintf(
+			"fix: Declare it in a template of %s, e.g. data-signals:%s=\"...\", "+
+				"or remove it from %s",
+			d.PageType, signalAttrKey(d.Signal), d.Handler,
+		)
+
+	case errors.Is(err, parser.ErrTemplPatchElementNoID):
+		var d *parser.ErrorTemplPatchElementNoID
+		if !errors.As(err, &d) {
+			return ""
+		}
+		return fmt.Sprintf(
+			"fix: Give the <%s> of %s an id, or patch it with "+
+				"datapages.PatchSelector(\"...\")",
+			d.Element, d.Component,
+		)
+
+	case errors.Is(err, parser.ErrTemplPatchElementIDNotOnPage):
+		var d *parser.ErrorTemplPatchElementIDNotOnPage
+		if !errors.As(err, &d) {
+			return ""
+		}
+		return fmt.Sprintf(
+			"fix: Render an element with id=%q in a template of %s.GET",
+			d.ID, d.PageType,
+		)
+
 	case errors.Is(err, parser.ErrSignatureUnsupportedInput):
 		var d *parser.ErrorSignatureUnsupportedInput
 		if !errors.As(err, &d) {
@@ -478,6 +542,7 @@ func Suggest(err error) string {
 //   - ErrQueryFieldUnexported         — fix is obvious: capitalize the field name
 //   - ErrQueryFieldDuplicateTag       — message names the duplicate value
 //   - ErrQueryReflectSignalNotInSignals — message names the missing signal
+//   - ErrQueryReflectSignalOption     — message names the allowed options
 //   - ErrSignalsParamNotStruct        — type constraint is clear from message
 //   - ErrSignalsFieldUnexported       — fix is obvious: capitalize the field name
 //   - ErrSignalsFieldDuplicateTag     — message names the duplicate value
@@ -488,9 +553,25 @@ func Suggest(err error) string {
 //   - ErrCloseSessionWithSSE          — message states the mutual exclusion
 //   - ErrEnableBgStreamNotGET         — message states it must be in a GET handler
 //   - ErrDisableRefreshNotGET         — message states it must be in a GET handler
+//   - ErrDeferDispatchNotAction       — message states it must be in an action handler
+//   - ErrRerenderNotEventHandler      — message states it must be in an event handler
 //   - ErrEventSubjectUserNoSession  — has dedicated suggestion above
 //   - ErrEventSubjectAfterPayload   — has dedicated suggestion above
 
+// signalAttrKey returns the attribute key that declares signal,
+// which the browser lowercases: "firstName" -> "first-name".
+func signalAttrKey(signal string) string {
+	var b strings.Builder
+	for _, r := range signal {
+		if unicode.IsUpper(r) {
+			b.WriteByte('-')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
 // pageTypePath derives a suggested route path from a page type name.
 // "PageIndex" -> "/", "PageProfile" -> "/profile/", "PageFooBar" -> "/foobar/".
 func pageTypePath(typeName string) string {
@@ -67,6 +67,21 @@ func TestSuggest(t *testing.T) {
 			err:  parser.ErrSignatureEvHandMultipleEvents,
 			want: "fix: Keep one parameter of an EventXXX type and remove the rest",
 		},
+		"ErrSignatureAuthorizeWildcard": {
+			err:  parser.ErrSignatureAuthorizeWildcard,
+			want: "fix: Return `(allowed bool, err error)`",
+		},
+		"ErrEvHandWildcardUnauthorized": {
+			err: fmt.Errorf("%w: PageAdmin.OnRoomUpdate",
+				parser.ErrEvHandWildcardUnauthorized),
+			want: "fix: Add `func (PageXXX) AuthorizeWildcard(" +
+				"r *http.Request, session Session) (bool, error)`",
+		},
+		"ErrAuthorizeWildcardUnused": {
+			err: parser.ErrAuthorizeWildcardUnused,
+			want: "fix: Mark an event handler `//datapages:wildcard` " +
+				"or remove AuthorizeWildcard",
+		},
 		"ErrSignatureEvHandMissingEvent": {
 			err:  parser.ErrSignatureEvHandMissingEvent,
 			want: "fix: Add a parameter of an EventXXX type",
@@ -406,6 +421,53 @@ func TestSuggest(t *testing.T) {
 			want: "fix: Move this action reference to a template used by PageProfile, or use an action owned by PageSettings",
 		},
 
+		"ErrTemplSignalUnknown/closest": {
+			err: &parser.ErrorTemplSignalUnknown{
+				Signal:   "remeber",
+				PageType: "PageIndex",
+				Closest:  "remember",
+			},
+			want: "fix: Did you mean remember?",
+		},
+		"ErrTemplSignalUnknown/lowercased": {
+			err: &parser.ErrorTemplSignalUnknown{
+				Signal:   "showModal",
+				PageType: "PageIndex",
+				Closest:  "showmodal",
+			},
+			want: `fix: Browsers lowercase attribute names, declare it as data-signals:show-modal="..."`,
+		},
+		"ErrTemplSignalUnknown/declare": {
+			err: &parser.ErrorTemplSignalUnknown{
+				Signal:   "form.firstName",
+				PageType: "PageIndex",
+			},
+			want: `fix: Declare the signal in a template of PageIndex, e.g. data-signals:form.first-name="..."`,
+		},
+		"ErrTemplSignalUnused": {
+			err: &parser.ErrorTemplSignalUnused{
+				Signal:   "newTitle",
+				PageType: "PageIndex",
+				Handler:  "POSTCreate",
+			},
+			want: `fix: Declare it in a template of PageIndex, e.g. data-signals:new-title="...", or remove it from POSTCreate`,
+		},
+		"ErrTemplPatchElementNoID": {
+			err: &parser.ErrorTemplPatchElementNoID{
+				Component: "toast",
+				Element:   "div",
+			},
+			want: `fix: Give the <div> of toast an id, or patch it with datapages.PatchSelector("...")`,
+		},
+		"ErrTemplPatchElementIDNotOnPage": {
+			err: &parser.ErrorTemplPatchElementIDNotOnPage{
+				Component: "todoList",
+				ID:        "todo-list",
+				PageType:  "PageIndex",
+			},
+			want: `fix: Render an element with id="todo-list" in a template of PageIndex.GET`,
+		},
+
 		"ErrSignatureUnsupportedInput/remove": {
 			err: &parser.ErrorSignatureUnsupportedInput{
 				ParamName:  "b",
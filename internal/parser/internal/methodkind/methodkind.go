@@ -4,8 +4,8 @@ package methodkind
 
 import "strings"
 
-// Kind is what a method name makes of a method: an HTTP handler, a stream hook
-// or an event handler. The zero value is an ordinary method.
+// Kind is what a method name makes of a method: an HTTP handler, a stream hook,
+// the wildcard authorization hook or an event handler. The zero value is an ordinary method.
 type Kind int8
 
 const (
@@ -17,6 +17,7 @@ const (
 	ActionDELETEHandler
 	StreamOpenHook
 	StreamCloseHook
+	AuthorizeWildcardHook
 	EventHandler
 )
 
@@ -78,6 +79,8 @@ func Classify(name string) (kind Kind, suffix string) {
 		return StreamOpenHook, ""
 	case name == "StreamClose":
 		return StreamCloseHook, ""
+	case name == "AuthorizeWildcard":
+		return AuthorizeWildcardHook, ""
 	case strings.HasPrefix(name, "On"):
 		return EventHandler, name[len("On"):]
 	default:
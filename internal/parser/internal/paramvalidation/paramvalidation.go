@@ -67,6 +67,9 @@ var (
 	ErrQueryReflectSignalNotInSignals = errors.New(
 		"query reflectsignal tag references signal not in signals parameter",
 	)
+	ErrQueryReflectSignalOption = errors.New(
+		`query reflectsignal tag option must be "push" or "replace"`,
+	)
 )
 
 // Signals parameter errors.
@@ -103,6 +106,12 @@ func IsSessionParam(f *ast.Field, info *types.Info) bool {
 	return typecheck.IsSessionType(f.Type, info)
 }
 
+// IsTabStateParam reports whether the AST field is typed datapages.TabState[T].
+func IsTabStateParam(f *ast.Field, info *types.Info) bool {
+	_, ok := typecheck.TabStateType(f.Type, info)
+	return ok
+}
+
 // IsPathParam reports whether the AST field is typed datapages.Path[Values].
 func IsPathParam(f *ast.Field, info *types.Info) bool {
 	_, ok := typecheck.PathValuesType(f.Type, info)
@@ -555,7 +564,8 @@ func ValidatePathAgainstRoute(
 }
 
 // ValidateReflectSignal checks that every reflectsignal tag
-// on a query field references a json tag value in the signals struct.
+// on a query field references a json tag value in the signals struct
+// and has no option other than push or replace.
 func ValidateReflectSignal(
 	h *model.Handler, recv, method string,
 ) error {
@@ -591,6 +601,15 @@ func ValidateReflectSignal(
 				rs, recv, method,
 			)
 		}
+		switch opt := structtag.ReflectSignalTagOption(querySt.Tag(i)); opt {
+		case "", "push", "replace":
+		default:
+			return fmt.Errorf(
+				"%w: %q of %q in %s.%s",
+				ErrQueryReflectSignalOption,
+				opt, rs, recv, method,
+			)
+		}
 	}
 	return nil
 }
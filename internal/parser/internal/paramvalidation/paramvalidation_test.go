@@ -791,6 +791,17 @@ func TestValidateReflectSignal(t *testing.T) {
 			},
 			wantErr: ErrQueryReflectSignalNotInSignals,
 		},
+		"reflectsignal push": {handler: &model.Handler{
+			InputQuery:   input(queryType(`query:"search" reflectsignal:"count,push"`)),
+			InputSignals: input(sigType),
+		}},
+		"reflectsignal unknown option": {
+			handler: &model.Handler{
+				InputQuery:   input(queryType(`query:"search" reflectsignal:"count,pop"`)),
+				InputSignals: input(sigType),
+			},
+			wantErr: ErrQueryReflectSignalOption,
+		},
 	} {
 		t.Run(name, func(t *testing.T) {
 			err := ValidateReflectSignal(td.handler, "Recv", "Method")
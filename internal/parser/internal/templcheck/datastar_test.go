@@ -1,6 +1,9 @@
 package templcheck
 
-import "testing"
+import (
+	"slices"
+	"testing"
+)
 
 func TestIsDatastarActionAttr(t *testing.T) {
 	for name, tc := range map[string]struct {
@@ -90,3 +93,78 @@ func TestIsDatastarActionAttr(t *testing.T) {
 		})
 	}
 }
+
+func TestSignalKeyPath(t *testing.T) {
+	for name, tc := range map[string]struct {
+		input string
+		want  string
+	}{
+		"plain":          {input: "count", want: "count"},
+		"kebab to camel": {input: "first-name", want: "firstName"},
+		"nested":         {input: "form.first-name", want: "form.firstName"},
+		"lowercased":     {input: "showModal", want: "showmodal"},
+		"underscore":     {input: "instance_id", want: "instance_id"},
+		"local":          {input: "_wobble-tip", want: "_wobbleTip"},
+		"modifier":       {input: "count__ifmissing", want: "count"},
+		"case kebab":     {input: "my-signal__case.kebab", want: "my-signal"},
+		"case snake":     {input: "my-signal__case.snake", want: "my_signal"},
+		"case pascal":    {input: "my-signal__case.pascal", want: "MySignal"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got := signalKeyPath(tc.input); got != tc.want {
+				t.Errorf("signalKeyPath(%q) = %q, want %q", tc.input, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestObjectLiteralPaths(t *testing.T) {
+	for name, tc := range map[string]struct {
+		input string
+		want  []string
+		ok    bool
+	}{
+		"flat":         {input: "{a: 1, b: 'x'}", want: []string{"a", "b"}, ok: true},
+		"nested":       {input: "{form: {name: '', email: ''}}", want: []string{"form.name", "form.email"}, ok: true},
+		"quoted keys":  {input: `{"a": 1, 'b': 2}`, want: []string{"a", "b"}, ok: true},
+		"empty object": {input: "{a: {}}", want: []string{"a"}, ok: true},
+		"commas in values": {
+			input: "{a: 'x, y', b: [1, 2], c: f(1, 2)}", want: []string{"a", "b", "c"}, ok: true,
+		},
+		"trailing comma": {input: "{a: 1,}", want: []string{"a"}, ok: true},
+		"shorthand":      {input: "{a}"},
+		"not an object":  {input: "$a"},
+		"unterminated":   {input: "{a: 'x}"},
+		"trailing text":  {input: "{a: 1} + x"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, ok := objectLiteralPaths(tc.input)
+			if ok != tc.ok || !slices.Equal(got, tc.want) {
+				t.Errorf("objectLiteralPaths(%q) = %q, %v, want %q, %v",
+					tc.input, got, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+func TestSignalRefs(t *testing.T) {
+	for name, tc := range map[string]struct {
+		input string
+		want  []string
+	}{
+		"one":             {input: "$count + 1", want: []string{"count"}},
+		"nested":          {input: "$form.email.trim()", want: []string{"form.email.trim"}},
+		"several":         {input: "$a = $b", want: []string{"a", "b"}},
+		"quoted":          {input: `'$a' + "$b" + $c`, want: []string{"c"}},
+		"escaped quote":   {input: `'it\'s $a' + $b`, want: []string{"b"}},
+		"template string": {input: "`${$a}`", want: []string{"a"}},
+		"identifier":      {input: "a$b", want: nil},
+		"no signals":      {input: "el.value", want: nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got := signalRefs(tc.input); !slices.Equal(got, tc.want) {
+				t.Errorf("signalRefs(%q) = %q, want %q", tc.input, got, tc.want)
+			}
+		})
+	}
+}
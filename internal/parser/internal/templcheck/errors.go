@@ -17,6 +17,10 @@ var (
 	ErrActionUnverifiableWithPrefix = errors.New("action call must not be concatenated with a prefix")
 	ErrActionUnverifiableWithSuffix = errors.New("action call must not be concatenated with a suffix")
 	ErrHrefExternalIsRelative       = errors.New("href.External used with relative URL")
+	ErrSignalUnknown                = errors.New("template uses unknown signal")
+	ErrSignalUnused                 = errors.New("handler reads signal no template of the page declares")
+	ErrPatchElementNoID             = errors.New("patched component has root element without id")
+	ErrPatchElementIDNotOnPage      = errors.New("patched element id not found on page")
 )
 
 // ErrorHrefRelative is ErrHrefRelative with context.
@@ -152,3 +156,56 @@ func (e *ErrorHrefExternalIsRelative) Error() string {
 }
 
 func (e *ErrorHrefExternalIsRelative) Unwrap() error { return ErrHrefExternalIsRelative }
+
+// ErrorSignalUnknown is ErrSignalUnknown with context.
+type ErrorSignalUnknown struct {
+	Signal   string // e.g. "form.emial"
+	PageType string // e.g. "PageIndex" (the page whose template uses the signal)
+	Closest  string // e.g. "form.email", the known signal it's likely a typo of, if any
+}
+
+func (e *ErrorSignalUnknown) Error() string {
+	return fmt.Sprintf("%v: %s in %s", ErrSignalUnknown, e.Signal, e.PageType)
+}
+
+func (e *ErrorSignalUnknown) Unwrap() error { return ErrSignalUnknown }
+
+// ErrorSignalUnused is ErrSignalUnused with context.
+type ErrorSignalUnused struct {
+	Signal   string // e.g. "form.email"
+	PageType string // e.g. "PageIndex"
+	Handler  string // e.g. "POSTSubmit" or "OnCalcUpdated" (the first handler reading it)
+}
+
+func (e *ErrorSignalUnused) Error() string {
+	return fmt.Sprintf("%v: %s read by %s.%s",
+		ErrSignalUnused, e.Signal, e.PageType, e.Handler)
+}
+
+func (e *ErrorSignalUnused) Unwrap() error { return ErrSignalUnused }
+
+// ErrorPatchElementNoID is ErrPatchElementNoID with context.
+type ErrorPatchElementNoID struct {
+	Component string // e.g. "todoList"
+	Element   string // e.g. "div" (the root element without id)
+}
+
+func (e *ErrorPatchElementNoID) Error() string {
+	return fmt.Sprintf("%v: <%s> of %s", ErrPatchElementNoID, e.Element, e.Component)
+}
+
+func (e *ErrorPatchElementNoID) Unwrap() error { return ErrPatchElementNoID }
+
+// ErrorPatchElementIDNotOnPage is ErrPatchElementIDNotOnPage with context.
+type ErrorPatchElementIDNotOnPage struct {
+	Component string // e.g. "todoList"
+	ID        string // e.g. "todo-list"
+	PageType  string // e.g. "PageIndex" (the page whose handler patches it)
+}
+
+func (e *ErrorPatchElementIDNotOnPage) Error() string {
+	return fmt.Sprintf("%v: #%s of %s in %s",
+		ErrPatchElementIDNotOnPage, e.ID, e.Component, e.PageType)
+}
+
+func (e *ErrorPatchElementIDNotOnPage) Unwrap() error { return ErrPatchElementIDNotOnPage }
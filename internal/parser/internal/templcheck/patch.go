@@ -0,0 +1,292 @@
+package templcheck
+
+import (
+	"go/ast"
+	"go/constant"
+	goparser "go/parser"
+	"go/types"
+	"strings"
+
+	templparser "github.com/a-h/templ/parser/v2"
+	"golang.org/x/tools/go/packages"
+
+	"github.com/romshark/datapages/internal/parser/model"
+)
+
+// datapagesPkgPath is the import path of the package declaring SSE.
+const datapagesPkgPath = "github.com/romshark/datapages"
+
+// patchRoot is a root element of a patched component.
+type patchRoot struct {
+	element string // e.g. "div"
+	id      string // The constant id, empty if it's dynamic or missing.
+	hasID   bool
+}
+
+// checkPatchElements verifies that the templ components handlers patch
+// into the DOM by element id carry an id on each of their root elements,
+// since SSE.PatchElement morphs each element into the one carrying its id
+// and silently drops an element without.
+// A constant id that no template rendered by the GET handler of
+// the handler's page carries is reported as a warning.
+func (c *checker) checkPatchElements(
+	pkg *packages.Package,
+	app *model.App,
+	funcsByName map[string]*funcInfo,
+) {
+	pages := map[string]*model.Page{}
+	for _, p := range app.Pages {
+		pages[p.TypeName] = p
+	}
+	pageIDs := map[string]map[string]bool{} // Page type name -> ids, nil if unknown.
+
+	for _, f := range pkg.Syntax {
+		filename := pkg.Fset.Position(f.Pos()).Filename
+		if strings.HasSuffix(filename, "_templ.go") {
+			continue
+		}
+		for _, d := range f.Decls {
+			fd, ok := d.(*ast.FuncDecl)
+			if !ok || fd.Body == nil {
+				continue
+			}
+			var page *model.Page
+			if fd.Recv != nil && len(fd.Recv.List) > 0 {
+				page = pages[recvTypeName(fd.Recv.List[0].Type)]
+			}
+			ast.Inspect(fd.Body, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				fi := patchedComponent(pkg.TypesInfo, call, funcsByName)
+				if fi == nil {
+					return true
+				}
+				pos := pkg.Fset.Position(call.Args[0].Pos())
+				for _, r := range c.patchRoots(fi, funcsByName, map[string]bool{}) {
+					if !r.hasID {
+						c.errFn(pos, &ErrorPatchElementNoID{
+							Component: fi.name,
+							Element:   r.element,
+						})
+						continue
+					}
+					if r.id == "" || page == nil || page.GET == nil {
+						continue
+					}
+					ids, ok := pageIDs[page.TypeName]
+					if !ok {
+						ids = c.pageElementIDs(pkg, page, funcsByName)
+						pageIDs[page.TypeName] = ids
+					}
+					if ids != nil && !ids[r.id] {
+						c.warnFn(pos, &ErrorPatchElementIDNotOnPage{
+							Component: fi.name,
+							ID:        r.id,
+							PageType:  page.TypeName,
+						})
+					}
+				}
+				return true
+			})
+		}
+	}
+}
+
+// patchedComponent returns the local templ function whose component call
+// is patched by id: the first argument of SSE.PatchElement, or of
+// SSE.PatchElementAt with an empty selector.
+// It returns nil for any other call, for a component that isn't a call
+// to a templ function of the package, and for options that name a selector
+// or that the linter can't see through.
+func patchedComponent(
+	info *types.Info, call *ast.CallExpr, funcsByName map[string]*funcInfo,
+) *funcInfo {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || len(call.Args) == 0 || call.Ellipsis.IsValid() {
+		return nil
+	}
+	fn, ok := info.Uses[sel.Sel].(*types.Func)
+	if !ok || fn.Pkg() == nil || fn.Pkg().Path() != datapagesPkgPath {
+		return nil
+	}
+	opts := call.Args[1:]
+	switch fn.Name() {
+	case "PatchElement":
+	case "PatchElementAt":
+		if len(call.Args) < 2 || !isEmptyStringConst(info, call.Args[1]) {
+			return nil
+		}
+		opts = call.Args[2:]
+	default:
+		return nil
+	}
+	for _, o := range opts {
+		if !targetsByID(info, o) {
+			return nil
+		}
+	}
+	compCall, ok := call.Args[0].(*ast.CallExpr)
+	if !ok {
+		return nil
+	}
+	ident, ok := compCall.Fun.(*ast.Ident)
+	if !ok {
+		return nil
+	}
+	if _, ok := info.Uses[ident].(*types.Func); !ok {
+		return nil // A variable shadowing the templ function.
+	}
+	return funcsByName[ident.Name]
+}
+
+// targetsByID reports whether the patch option opt keeps targeting elements
+// by id. A PatchSelector does unless it's the empty constant, and an option
+// of interface type could be anything.
+func targetsByID(info *types.Info, opt ast.Expr) bool {
+	tv, ok := info.Types[opt]
+	if !ok || tv.Type == nil {
+		return false
+	}
+	if types.IsInterface(tv.Type) {
+		return false
+	}
+	named, ok := types.Unalias(tv.Type).(*types.Named)
+	if !ok {
+		return true
+	}
+	obj := named.Obj()
+	if obj.Pkg() == nil || obj.Pkg().Path() != datapagesPkgPath ||
+		obj.Name() != "PatchSelector" {
+		return true
+	}
+	return isEmptyStringConst(info, opt)
+}
+
+// isEmptyStringConst reports whether expr is a constant empty string.
+func isEmptyStringConst(info *types.Info, expr ast.Expr) bool {
+	tv, ok := info.Types[expr]
+	return ok && tv.Value != nil &&
+		tv.Value.Kind() == constant.String && constant.StringVal(tv.Value) == ""
+}
+
+// patchRoots returns the root elements fi renders, including those of
+// the templates of the package it calls at its top level.
+// What the linter can't see into, { children... } and the components
+// of other packages, contributes no roots.
+func (c *checker) patchRoots(
+	fi *funcInfo, funcsByName map[string]*funcInfo, visited map[string]bool,
+) []patchRoot {
+	if visited[fi.name] {
+		return nil
+	}
+	visited[fi.name] = true
+	var roots []patchRoot
+	var walk func(nodes []templparser.Node)
+	walk = func(nodes []templparser.Node) {
+		for _, node := range nodes {
+			switch n := node.(type) {
+			case *templparser.Element:
+				id, has := c.elementID(n.Attributes)
+				roots = append(roots, patchRoot{element: n.Name, id: id, hasID: has})
+			case *templparser.RawElement:
+				id, has := c.elementID(n.Attributes)
+				roots = append(roots, patchRoot{element: n.Name, id: id, hasID: has})
+			case *templparser.TemplElementExpression:
+				// The children are rendered inside the called template's roots.
+				if callee := funcsByName[templCallName(n.Expression.Value)]; callee != nil {
+					roots = append(roots, c.patchRoots(callee, funcsByName, visited)...)
+				}
+			case *templparser.CallTemplateExpression:
+				if callee := funcsByName[templCallName(n.Expression.Value)]; callee != nil {
+					roots = append(roots, c.patchRoots(callee, funcsByName, visited)...)
+				}
+			case templparser.CompositeNode:
+				walk(n.ChildNodes())
+			}
+		}
+	}
+	walk(fi.nodes)
+	return roots
+}
+
+// pageElementIDs returns the constant element ids of the templates
+// the GET handler of page renders, or nil if it renders none of the package.
+func (c *checker) pageElementIDs(
+	pkg *packages.Package, page *model.Page, funcsByName map[string]*funcInfo,
+) map[string]bool {
+	fd := findGETFuncDecl(pkg, page.TypeName)
+	if fd == nil {
+		return nil
+	}
+	reachable := bfsTemplFuncs(extractTemplCallsFromBody(fd.Body, funcsByName), funcsByName)
+	if len(reachable) == 0 {
+		return nil
+	}
+	ids := map[string]bool{}
+	var walk func(nodes []templparser.Node)
+	walk = func(nodes []templparser.Node) {
+		for _, node := range nodes {
+			switch n := node.(type) {
+			case *templparser.Element:
+				if id, _ := c.elementID(n.Attributes); id != "" {
+					ids[id] = true
+				}
+				walk(n.Children)
+			case *templparser.RawElement:
+				if id, _ := c.elementID(n.Attributes); id != "" {
+					ids[id] = true
+				}
+			case templparser.CompositeNode:
+				walk(n.ChildNodes())
+			}
+		}
+	}
+	for _, fi := range reachable {
+		walk(fi.nodes)
+	}
+	return ids
+}
+
+// elementID returns the id attribute of an element with the given attributes,
+// empty if it isn't constant, and whether it may have one at all.
+// An id set conditionally, by a spread or by a dynamic key counts as present.
+func (c *checker) elementID(attrs []templparser.Attribute) (id string, has bool) {
+	for _, attr := range attrs {
+		switch a := attr.(type) {
+		case *templparser.ConstantAttribute:
+			if isIDKey(a.Key) {
+				return a.Value, a.Value != ""
+			}
+		case *templparser.ExpressionAttribute:
+			if !isIDKey(a.Key) {
+				if _, ok := a.Key.(templparser.ExpressionAttributeKey); ok {
+					has = true
+				}
+				continue
+			}
+			exprAST, err := goparser.ParseExpr(a.Expression.Value)
+			if err != nil {
+				return "", true
+			}
+			val, _ := c.resolveSimpleExpr(exprAST)
+			return val, true
+		case *templparser.ConditionalAttribute:
+			if _, then := c.elementID(a.Then); then {
+				has = true
+			}
+			if _, els := c.elementID(a.Else); els {
+				has = true
+			}
+		case *templparser.SpreadAttributes:
+			has = true
+		}
+	}
+	return "", has
+}
+
+func isIDKey(k templparser.AttributeKey) bool {
+	ck, ok := k.(templparser.ConstantAttributeKey)
+	return ok && ck.Name == "id"
+}
@@ -0,0 +1,831 @@
+package templcheck
+
+import (
+	"go/ast"
+	"go/constant"
+	goparser "go/parser"
+	"go/token"
+	"go/types"
+	"regexp"
+	"slices"
+	"strings"
+
+	templparser "github.com/a-h/templ/parser/v2"
+	"golang.org/x/tools/go/packages"
+
+	"github.com/romshark/datapages/internal/parser/model"
+	"github.com/romshark/datapages/internal/structtag"
+)
+
+// signalUse is a signal a template declares or references.
+type signalUse struct {
+	path     string // e.g. "form.email"
+	declared bool   // Declared by data-signals, data-bind and the like, not a $path.
+	nolint   bool   // On an element suppressed with //datapages:nolint.
+	pos      token.Position
+}
+
+// serverSignal is a signal a handler of a page reads.
+type serverSignal struct {
+	path    string // e.g. "form.email"
+	handler string // e.g. "POSTSubmit"
+	pos     token.Position
+}
+
+// checkSignals cross-checks the signals the templates of each page declare
+// and reference against the signals the handlers of the page read:
+// the json tags of their Signals and the signal tags of the subjects
+// of the events they handle.
+func (c *checker) checkSignals(
+	pkg *packages.Package,
+	app *model.App,
+	funcsByName map[string]*funcInfo,
+) {
+	patches := patchedSignals(pkg, app)
+
+	// App-level actions can be called from any page.
+	var appKnown []string
+	for _, a := range app.Actions {
+		for _, s := range handlerSignals(pkg.Fset, a) {
+			appKnown = append(appKnown, s.path)
+		}
+	}
+
+	for _, page := range app.Pages {
+		if page.GET == nil {
+			continue
+		}
+		fd := findGETFuncDecl(pkg, page.TypeName)
+		if fd == nil {
+			continue
+		}
+		entries := extractTemplCallsFromBody(fd.Body, funcsByName)
+		reachable := bfsTemplFuncs(entries, funcsByName)
+		if len(reachable) == 0 {
+			continue // The page renders no templates of the package.
+		}
+		patched := slices.Concat(patches.paths[""], patches.paths[page.TypeName])
+		dynamic := patches.dynamic[""] || patches.dynamic[page.TypeName]
+		c.checkPageSignals(
+			page, pageServerSignals(pkg.Fset, app, page), appKnown,
+			patched, dynamic, reachable,
+		)
+	}
+}
+
+// checkPageSignals reports the signals the templates of page reference but
+// nothing declares, and those its handlers read but nothing declares.
+// The signals the handlers patch count as declared, dynamic is true when
+// they patch signals the linter can't tell.
+//
+// An unknown signal close to a known one is a typo and reported as an error.
+// Any other finding is a warning: a signal may come from what the linter
+// doesn't see, a script or a handler of another package.
+func (c *checker) checkPageSignals(
+	page *model.Page,
+	server []serverSignal,
+	appKnown, patched []string,
+	dynamic bool,
+	reachable []*funcInfo,
+) {
+	// The generated page declares the signals its query reflects.
+	declared := append(reflectedSignals(page), patched...)
+	for _, fi := range reachable {
+		dynamic = dynamic || fi.dynamicSignals
+		for _, u := range fi.signals {
+			if u.declared {
+				declared = append(declared, u.path)
+			}
+		}
+	}
+	known := appKnown
+	for _, s := range server {
+		known = append(known, s.path)
+	}
+
+	for _, fi := range reachable {
+		for _, u := range fi.signals {
+			if u.nolint {
+				continue
+			}
+			if u.declared {
+				// Signals under a root a handler reads must be among its fields.
+				if !sharesRoot(u.path, known) || relatedToAny(u.path, known) {
+					continue
+				}
+			} else if dynamic ||
+				relatedToAny(u.path, declared) || relatedToAny(u.path, known) {
+				continue
+			}
+			report := c.warnFn
+			closest := closestSignal(u.path, declared, known)
+			if closest != "" {
+				report = c.errFn
+			}
+			report(u.pos, &ErrorSignalUnknown{
+				Signal:   u.path,
+				PageType: page.TypeName,
+				Closest:  closest,
+			})
+		}
+	}
+
+	// The signals an expression declares could be any,
+	// none of the handlers' can be proven unused.
+	if dynamic {
+		return
+	}
+	reported := map[string]bool{}
+	for _, s := range server {
+		if reported[s.path] || relatedToAny(s.path, declared) {
+			continue
+		}
+		reported[s.path] = true
+		c.warnFn(s.pos, &ErrorSignalUnused{
+			Signal:   s.path,
+			PageType: page.TypeName,
+			Handler:  s.handler,
+		})
+	}
+}
+
+// pageServerSignals returns the signals the GET, action and StreamOpen
+// handlers of page read, followed by the subject signals of the events
+// its handlers subscribe to.
+func pageServerSignals(fset *token.FileSet, app *model.App, page *model.Page) []serverSignal {
+	var signals []serverSignal
+	if page.GET != nil && page.GET.Handler != nil {
+		signals = append(signals, handlerSignals(fset, page.GET.Handler)...)
+	}
+	for _, a := range page.Actions {
+		signals = append(signals, handlerSignals(fset, a)...)
+	}
+	if page.StreamOpen != nil {
+		signals = append(signals, handlerSignals(fset, page.StreamOpen)...)
+	}
+	for _, eh := range page.EventHandlers {
+		if eh.Wildcard {
+			continue // Subscribes to every value, reads no signals.
+		}
+		for _, e := range app.Events {
+			if e.TypeName != eh.EventTypeName {
+				continue
+			}
+			for _, sf := range e.SubjectFields {
+				if sf.SignalName == "" {
+					continue
+				}
+				signals = append(signals, serverSignal{
+					path:    sf.SignalName,
+					handler: eh.Name,
+					pos:     exprPosition(fset, eh.Expr),
+				})
+			}
+		}
+	}
+	return signals
+}
+
+// patches are the signals handlers patch with SSE.PatchSignals and
+// SSE.PatchSignalsIfMissing, by the type name of the page whose method
+// patches them. Those the App and plain functions patch apply to every page
+// and are kept under "".
+type patches struct {
+	paths map[string][]string
+	// dynamic holds the pages that patch signals the linter can't tell,
+	// a map or a value of interface type for example.
+	dynamic map[string]bool
+}
+
+// patchedSignals collects the signals the handlers in pkg patch.
+func patchedSignals(pkg *packages.Package, app *model.App) patches {
+	p := patches{paths: map[string][]string{}, dynamic: map[string]bool{}}
+	pages := map[string]bool{}
+	for _, page := range app.Pages {
+		pages[page.TypeName] = true
+	}
+	for _, f := range pkg.Syntax {
+		if strings.HasSuffix(pkg.Fset.Position(f.Pos()).Filename, "_templ.go") {
+			continue
+		}
+		for _, d := range f.Decls {
+			fd, ok := d.(*ast.FuncDecl)
+			if !ok || fd.Body == nil {
+				continue
+			}
+			owner := ""
+			if fd.Recv != nil && len(fd.Recv.List) > 0 {
+				if name := recvTypeName(fd.Recv.List[0].Type); pages[name] {
+					owner = name
+				}
+			}
+			ast.Inspect(fd.Body, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok || !isPatchSignals(pkg.TypesInfo, call) {
+					return true
+				}
+				paths, ok := patchedPaths(pkg.TypesInfo, call.Args[0])
+				if !ok {
+					p.dynamic[owner] = true
+					return true
+				}
+				p.paths[owner] = append(p.paths[owner], paths...)
+				return true
+			})
+		}
+	}
+	return p
+}
+
+// isPatchSignals reports whether call is one of SSE.PatchSignals
+// and SSE.PatchSignalsIfMissing.
+func isPatchSignals(info *types.Info, call *ast.CallExpr) bool {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || len(call.Args) != 1 {
+		return false
+	}
+	fn, ok := info.Uses[sel.Sel].(*types.Func)
+	if !ok || fn.Pkg() == nil || fn.Pkg().Path() != datapagesPkgPath {
+		return false
+	}
+	return fn.Name() == "PatchSignals" || fn.Name() == "PatchSignalsIfMissing"
+}
+
+// patchedPaths returns the signals the argument of a PatchSignals call
+// patches: the fields of a struct, or the keys of a constant JSON object
+// like json.RawMessage(`{"count":1}`). It returns false for anything else.
+func patchedPaths(info *types.Info, arg ast.Expr) ([]string, bool) {
+	if conv, ok := arg.(*ast.CallExpr); ok && len(conv.Args) == 1 {
+		if tv, ok := info.Types[conv.Fun]; ok && tv.IsType() {
+			arg = conv.Args[0] // A conversion, json.RawMessage(...) for example.
+		}
+	}
+	tv, ok := info.Types[arg]
+	if !ok || tv.Type == nil {
+		return nil, false
+	}
+	if tv.Value != nil {
+		if tv.Value.Kind() != constant.String {
+			return nil, false
+		}
+		return objectLiteralPaths(constant.StringVal(tv.Value))
+	}
+	if _, ok := jsonStruct(tv.Type); !ok {
+		return nil, false
+	}
+	var paths []string
+	signalPaths(tv.Type, "", func(path string) { paths = append(paths, path) })
+	return paths, true
+}
+
+// reflectedSignals returns the signals the reflectsignal tags
+// of the query of the GET handler of page name.
+func reflectedSignals(page *model.Page) []string {
+	if page.GET == nil || page.GET.Handler == nil || page.GET.InputQuery == nil {
+		return nil
+	}
+	s, ok := jsonStruct(page.GET.InputQuery.Type.Resolved)
+	if !ok {
+		return nil
+	}
+	var signals []string
+	for i := range s.NumFields() {
+		if name := structtag.ReflectSignalTagValue(s.Tag(i)); name != "" {
+			signals = append(signals, name)
+		}
+	}
+	return signals
+}
+
+// handlerSignals returns the signals the Signals parameter of h reads.
+func handlerSignals(fset *token.FileSet, h *model.Handler) []serverSignal {
+	if h == nil || h.InputSignals == nil || h.InputSignals.Type.Resolved == nil {
+		return nil
+	}
+	var signals []serverSignal
+	pos := exprPosition(fset, h.InputSignals.Expr)
+	signalPaths(h.InputSignals.Type.Resolved, "", func(path string) {
+		signals = append(signals, serverSignal{
+			path:    path,
+			handler: strings.ToUpper(h.HTTPMethod) + h.Name,
+			pos:     pos,
+		})
+	})
+	return signals
+}
+
+func exprPosition(fset *token.FileSet, expr ast.Expr) token.Position {
+	if fset == nil || expr == nil {
+		return token.Position{}
+	}
+	return fset.Position(expr.Pos())
+}
+
+// signalPaths calls add with the path of every signal t decodes,
+// the fields of nested structs as dot-separated paths.
+func signalPaths(t types.Type, prefix string, add func(path string)) {
+	walkSignalPaths(t, prefix, map[*types.Named]bool{}, add)
+}
+
+// walkSignalPaths is signalPaths for a t inside the named types of visiting.
+// A type containing itself through a pointer ends where it repeats,
+// as a signal holding what it holds.
+func walkSignalPaths(
+	t types.Type, prefix string, visiting map[*types.Named]bool, add func(path string),
+) {
+	s, ok := jsonStruct(t)
+	if named := namedType(t); ok && named != nil {
+		if visiting[named] {
+			ok = false
+		} else {
+			visiting[named] = true
+			defer delete(visiting, named)
+		}
+	}
+	if !ok {
+		if prefix != "" {
+			add(prefix)
+		}
+		return
+	}
+	var added bool
+	for i := range s.NumFields() {
+		f, tag := s.Field(i), s.Tag(i)
+		if !f.Exported() || structtag.JSONTagExcluded(tag) {
+			continue
+		}
+		name := structtag.JSONTagValue(tag)
+		if name == "" && f.Embedded() {
+			if n := namedType(f.Type()); n != nil && visiting[n] {
+				// A struct embedding itself adds no fields it doesn't have.
+				continue
+			}
+			if _, ok := jsonStruct(f.Type()); ok {
+				// encoding/json promotes the fields of an untagged embedded struct.
+				walkSignalPaths(f.Type(), prefix, visiting, func(path string) {
+					added = true
+					add(path)
+				})
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name()
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		added = true
+		walkSignalPaths(f.Type(), name, visiting, add)
+	}
+	if !added && prefix != "" {
+		add(prefix)
+	}
+}
+
+// jsonStruct returns the struct t encodes to JSON as,
+// false for types that encode themselves.
+func jsonStruct(t types.Type) (*types.Struct, bool) {
+	if t == nil {
+		return nil, false
+	}
+	t = types.Unalias(t)
+	if p, ok := t.(*types.Pointer); ok {
+		t = types.Unalias(p.Elem())
+	}
+	for _, ms := range []*types.MethodSet{
+		types.NewMethodSet(t), types.NewMethodSet(types.NewPointer(t)),
+	} {
+		for i := range ms.Len() {
+			switch ms.At(i).Obj().Name() {
+			case "MarshalJSON", "MarshalText":
+				return nil, false
+			}
+		}
+	}
+	s, ok := t.Underlying().(*types.Struct)
+	return s, ok
+}
+
+// namedType returns the named type t or what it points to is, nil for others.
+func namedType(t types.Type) *types.Named {
+	t = types.Unalias(t)
+	if p, ok := t.(*types.Pointer); ok {
+		t = types.Unalias(p.Elem())
+	}
+	n, _ := t.(*types.Named)
+	return n
+}
+
+// relatedToAny reports whether path is one of paths,
+// or a signal under or above one of them.
+func relatedToAny(path string, paths []string) bool {
+	for _, p := range paths {
+		if p == path || isUnder(path, p) || isUnder(p, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnder reports whether path is a signal nested under parent.
+func isUnder(path, parent string) bool {
+	return len(path) > len(parent) &&
+		strings.HasPrefix(path, parent) && path[len(parent)] == '.'
+}
+
+// sharesRoot reports whether the first segment of path
+// is the first segment of any of paths.
+func sharesRoot(path string, paths []string) bool {
+	root, _, _ := strings.Cut(path, ".")
+	for _, p := range paths {
+		if r, _, _ := strings.Cut(p, "."); r == root {
+			return true
+		}
+	}
+	return false
+}
+
+// closestSignal returns the candidate path is most likely a typo of,
+// empty if none is close.
+func closestSignal(path string, candidates ...[]string) string {
+	best, bestDist := "", 3 // Further than 2 edits is not a typo.
+	for _, paths := range candidates {
+		for _, p := range paths {
+			if p == path {
+				continue
+			}
+			d := editDistance(strings.ToLower(path), strings.ToLower(p))
+			if d < bestDist && d < len(path)/2+1 {
+				best, bestDist = p, d
+			}
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// collectAttrSignals records the signals attrs declare and reference.
+func (c *checker) collectAttrSignals(
+	attrs []templparser.Attribute, fi *funcInfo, nolint bool,
+) {
+	for _, attr := range attrs {
+		switch a := attr.(type) {
+		case *templparser.ConstantAttribute:
+			key, ok := a.Key.(templparser.ConstantAttributeKey)
+			if !ok {
+				continue
+			}
+			pos := posFromRange(fi.filename, a.Range)
+			fi.declareSignals(key.Name, a.Value, true, pos, nolint)
+			fi.referenceSignals(key.Name, a.Value, pos, nolint)
+		case *templparser.BoolConstantAttribute:
+			key, ok := a.Key.(templparser.ConstantAttributeKey)
+			if !ok {
+				continue
+			}
+			fi.declareSignals(key.Name, "", true, posFromRange(fi.filename, a.Range), nolint)
+		case *templparser.ExpressionAttribute:
+			key, ok := a.Key.(templparser.ConstantAttributeKey)
+			if !ok {
+				continue
+			}
+			pos := posFromRange(fi.filename, a.Expression.Range)
+			var value string
+			var resolved bool
+			if exprAST, err := goparser.ParseExpr(a.Expression.Value); err == nil {
+				value, resolved = c.resolveSimpleExpr(exprAST)
+			}
+			fi.declareSignals(key.Name, value, resolved, pos, nolint)
+			if resolved {
+				fi.referenceSignals(key.Name, value, pos, nolint)
+			}
+		case *templparser.ConditionalAttribute:
+			c.collectAttrSignals(a.Then, fi, nolint)
+			c.collectAttrSignals(a.Else, fi, nolint)
+		case *templparser.SpreadAttributes:
+			fi.dynamicSignals = true
+		}
+	}
+}
+
+// declareSignals records the signals attribute name declares.
+// resolved is false when value is an expression the linter can't resolve.
+//
+// A key, as in data-signals:form.first-name, names the signal the way
+// Datastar reads it: the browser lowercases attribute names and
+// Datastar camel-cases the key unless a __case modifier says otherwise.
+// A value, as in data-bind="firstName", names it as written.
+func (fi *funcInfo) declareSignals(
+	name, value string, resolved bool, pos token.Position, nolint bool,
+) {
+	plugin, key, hasKey := strings.Cut(name, ":")
+	if !hasKey {
+		plugin, _, _ = strings.Cut(name, "__")
+	}
+	declare := func(path string) {
+		fi.signals = append(fi.signals, signalUse{
+			path: path, declared: true, nolint: nolint, pos: pos,
+		})
+	}
+	switch plugin {
+	case "data-signals", "data-computed":
+		if hasKey {
+			declare(signalKeyPath(key))
+			return
+		}
+		if !resolved {
+			fi.dynamicSignals = true
+			return
+		}
+		paths, ok := objectLiteralPaths(value)
+		if !ok {
+			fi.dynamicSignals = true
+			return
+		}
+		for _, p := range paths {
+			declare(p)
+		}
+	case "data-bind", "data-ref", "data-indicator":
+		if hasKey {
+			declare(signalKeyPath(key))
+			return
+		}
+		if !resolved {
+			fi.dynamicSignals = true
+			return
+		}
+		if p := strings.TrimSpace(value); signalPathRE.MatchString(p) {
+			declare(p)
+		}
+	}
+}
+
+// referenceSignals records the $signal references in the value
+// of Datastar attribute name.
+func (fi *funcInfo) referenceSignals(name, value string, pos token.Position, nolint bool) {
+	if !strings.HasPrefix(name, "data-") {
+		return
+	}
+	seen := map[string]bool{}
+	for _, p := range signalRefs(value) {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		fi.signals = append(fi.signals, signalUse{path: p, nolint: nolint, pos: pos})
+	}
+}
+
+// signalKeyPath returns the signal an attribute key like
+// "form.first-name__case.kebab" declares.
+func signalKeyPath(key string) string {
+	name, mods, _ := strings.Cut(key, "__")
+	name = strings.ToLower(name)
+	casing := "camel"
+	for m := range strings.SplitSeq(mods, "__") {
+		if v, ok := strings.CutPrefix(m, "case."); ok {
+			casing = v
+		}
+	}
+	switch casing {
+	case "kebab":
+		return name
+	case "snake":
+		return strings.ReplaceAll(name, "-", "_")
+	case "pascal":
+		name = camelCase(name)
+		if name != "" {
+			name = strings.ToUpper(name[:1]) + name[1:]
+		}
+		return name
+	}
+	return camelCase(name)
+}
+
+// camelCase removes every hyphen and uppercases the letter after it.
+func camelCase(s string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range s {
+		if r == '-' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = []rune(strings.ToUpper(string(r)))[0]
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var (
+	signalPathRE = regexp.MustCompile(`^[A-Za-z_$][\w$]*(?:\.[A-Za-z_$][\w$]*)*$`)
+	signalRefRE  = regexp.MustCompile(`\$[A-Za-z_][\w]*(?:\.[A-Za-z_][\w]*)*`)
+)
+
+// signalRefs returns the signals a Datastar expression references,
+// $form.email as "form.email". Quoted strings are skipped,
+// template literals are not: their ${...} holds expressions.
+func signalRefs(expr string) []string {
+	b := []byte(expr)
+	for i := 0; i < len(b); i++ {
+		q := b[i]
+		if q != '\'' && q != '"' {
+			continue
+		}
+		for i++; i < len(b) && b[i] != q; i++ {
+			if b[i] == '\\' && i+1 < len(b) {
+				b[i] = ' '
+				i++
+			}
+			b[i] = ' '
+		}
+	}
+	var refs []string
+	for _, m := range signalRefRE.FindAllIndex(b, -1) {
+		if m[0] > 0 && isIdentByte(b[m[0]-1]) {
+			continue // Part of an identifier like a$b.
+		}
+		refs = append(refs, string(b[m[0]+1:m[1]]))
+	}
+	return refs
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' ||
+		'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// objectLiteralPaths returns the signals a data-signals object literal
+// like {form: {name: "", email: ""}} declares, false if s isn't one
+// the linter can read.
+func objectLiteralPaths(s string) ([]string, bool) {
+	sc := objScanner{s: s}
+	var paths []string
+	sc.space()
+	if !sc.object("", &paths) {
+		return nil, false
+	}
+	sc.space()
+	if sc.i != len(sc.s) {
+		return nil, false
+	}
+	return paths, true
+}
+
+// objScanner scans a JavaScript object literal.
+type objScanner struct {
+	s string
+	i int
+}
+
+func (sc *objScanner) peek() byte {
+	if sc.i < len(sc.s) {
+		return sc.s[sc.i]
+	}
+	return 0
+}
+
+func (sc *objScanner) eat(c byte) bool {
+	if sc.peek() == c {
+		sc.i++
+		return true
+	}
+	return false
+}
+
+func (sc *objScanner) space() {
+	for sc.i < len(sc.s) && strings.IndexByte(" \t\r\n", sc.s[sc.i]) >= 0 {
+		sc.i++
+	}
+}
+
+// object scans an object, appending the paths of its leaves.
+// An empty object is a leaf.
+func (sc *objScanner) object(prefix string, paths *[]string) bool {
+	if !sc.eat('{') {
+		return false
+	}
+	empty := true
+	for {
+		sc.space()
+		if sc.eat('}') {
+			if empty && prefix != "" {
+				*paths = append(*paths, prefix)
+			}
+			return true
+		}
+		key, ok := sc.key()
+		if !ok {
+			return false
+		}
+		sc.space()
+		if !sc.eat(':') {
+			return false
+		}
+		sc.space()
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if sc.peek() == '{' {
+			if !sc.object(key, paths) {
+				return false
+			}
+		} else {
+			*paths = append(*paths, key)
+			if !sc.value() {
+				return false
+			}
+		}
+		empty = false
+		sc.space()
+		if !sc.eat(',') && sc.peek() != '}' {
+			return false
+		}
+	}
+}
+
+func (sc *objScanner) key() (string, bool) {
+	if q := sc.peek(); q == '\'' || q == '"' {
+		sc.i++
+		start := sc.i
+		for sc.i < len(sc.s) && sc.s[sc.i] != q {
+			if sc.s[sc.i] == '\\' {
+				return "", false
+			}
+			sc.i++
+		}
+		if sc.i == len(sc.s) {
+			return "", false
+		}
+		sc.i++
+		return sc.s[start : sc.i-1], true
+	}
+	start := sc.i
+	for sc.i < len(sc.s) && isIdentByte(sc.s[sc.i]) {
+		sc.i++
+	}
+	return sc.s[start:sc.i], sc.i > start
+}
+
+// value skips a value up to the comma or brace that ends it.
+func (sc *objScanner) value() bool {
+	depth := 0
+	start := sc.i
+	for sc.i < len(sc.s) {
+		switch c := sc.s[sc.i]; c {
+		case '\'', '"', '`':
+			sc.i++
+			for sc.i < len(sc.s) && sc.s[sc.i] != c {
+				if sc.s[sc.i] == '\\' {
+					sc.i++
+				}
+				sc.i++
+			}
+			if sc.i >= len(sc.s) {
+				return false
+			}
+		case '(', '[', '{':
+			depth++
+		case ')', ']':
+			depth--
+		case '}':
+			if depth == 0 {
+				return sc.i > start
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				return sc.i > start
+			}
+		}
+		if depth < 0 {
+			return false
+		}
+		sc.i++
+	}
+	return false
+}
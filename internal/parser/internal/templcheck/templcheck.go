@@ -2,6 +2,8 @@
 //   - hardcoded app-internal href and action attributes
 //   - action helpers used outside Datastar action contexts
 //   - cross-page action references (action from page A used in page B's template)
+//   - signals a page's templates use that its handlers don't read, and the reverse
+//   - components patched by element id whose root elements have no id
 package templcheck
 
 import (
@@ -88,20 +90,24 @@ type parsedTempl struct {
 // checker holds resolved state shared across all checks.
 type checker struct {
 	errFn        ErrFunc
+	warnFn       ErrFunc
 	constValues  map[string]string
 	importConsts map[string]map[string]string // localName -> constName -> value
 	hrefPkg      *pkgMatcher
 	actionPkg    *pkgMatcher
 }
 
-// Check validates .templ files in pkg and reports errors via errFn.
+// Check validates .templ files in pkg and reports errors via errFn
+// and findings that may be false positives via warnFn.
 func Check(
 	pkg *packages.Package,
 	app *model.App,
 	errFn ErrFunc,
+	warnFn ErrFunc,
 ) {
 	c := checker{
 		errFn:        errFn,
+		warnFn:       warnFn,
 		constValues:  resolveConstValues(pkg),
 		importConsts: resolveImportConsts(pkg),
 		hrefPkg:      resolvePkgMatcher(pkg, "/href", "href"),
@@ -122,9 +128,21 @@ func Check(
 	for _, pt := range parsed {
 		c.checkParsedTemplFile(pt.filename, pt.file)
 	}
-	if app != nil {
-		c.checkActionOwnership(pkg, app, parsed)
+	if app == nil {
+		return
+	}
+	funcsByName := map[string]*funcInfo{}
+	for _, pt := range parsed {
+		for _, fi := range c.extractTemplFuncInfos(pt.filename, pt.file) {
+			funcsByName[fi.name] = fi
+		}
 	}
+	if len(funcsByName) == 0 {
+		return
+	}
+	c.checkActionOwnership(pkg, app, funcsByName)
+	c.checkSignals(pkg, app, funcsByName)
+	c.checkPatchElements(pkg, app, funcsByName)
 }
 
 // resolveConstValues builds a map from package-level constant names to their
@@ -755,8 +773,13 @@ func findPkgCalls(expr string, m *pkgMatcher, fn func(funcName string)) {
 type funcInfo struct {
 	name       string
 	filename   string // base filename
+	nodes      []templparser.Node
 	childCalls []string
 	actionRefs []actionRef
+	signals    []signalUse
+	// dynamicSignals is true when an attribute declares signals
+	// the linter can't resolve.
+	dynamicSignals bool
 }
 
 type actionRef struct {
@@ -770,7 +793,7 @@ type actionRef struct {
 func (c *checker) checkActionOwnership(
 	pkg *packages.Package,
 	app *model.App,
-	parsed []parsedTempl,
+	funcsByName map[string]*funcInfo,
 ) {
 	// Build action ownership map: generated func name -> page type name (or "App").
 	actionOwner := buildActionOwnerMap(app)
@@ -778,17 +801,6 @@ func (c *checker) checkActionOwnership(
 		return
 	}
 
-	// Extract function info from pre-parsed templ files.
-	funcsByName := map[string]*funcInfo{}
-	for _, pt := range parsed {
-		for _, fi := range c.extractTemplFuncInfos(pt.filename, pt.file) {
-			funcsByName[fi.name] = fi
-		}
-	}
-	if len(funcsByName) == 0 {
-		return
-	}
-
 	// For each page, find GET handler entry templ functions, BFS through
 	// the call graph, and check action ownership.
 	for _, page := range app.Pages {
@@ -859,7 +871,7 @@ func (c *checker) extractTemplFuncInfos(
 		if name == "" {
 			continue
 		}
-		fi := &funcInfo{name: name, filename: filename}
+		fi := &funcInfo{name: name, filename: filename, nodes: tmpl.Children}
 		c.collectTemplCalls(tmpl.Children, fi)
 		funcs = append(funcs, fi)
 	}
@@ -876,10 +888,16 @@ func templFuncName(expr string) string {
 }
 
 // collectTemplCalls recursively walks templ AST nodes collecting child
-// template calls and action.XXX() references.
+// template calls, action.XXX() references and signals.
 func (c *checker) collectTemplCalls(nodes []templparser.Node, fi *funcInfo) {
+	prevIsNolint := false
 	for _, node := range nodes {
 		switch n := node.(type) {
+		case *templparser.GoComment:
+			prevIsNolint = strings.HasPrefix(strings.TrimSpace(n.Contents), "datapages:nolint")
+			continue
+		case *templparser.Whitespace:
+			continue
 		case *templparser.TemplElementExpression:
 			if name := templCallName(n.Expression.Value); name != "" {
 				fi.childCalls = append(fi.childCalls, name)
@@ -893,12 +911,14 @@ func (c *checker) collectTemplCalls(nodes []templparser.Node, fi *funcInfo) {
 			c.collectActionRefs(n.Expression, fi)
 		case *templparser.Element:
 			c.collectElementActionRefs(n, fi)
+			c.collectAttrSignals(n.Attributes, fi, prevIsNolint)
 			c.collectTemplCalls(n.Children, fi)
 		case *templparser.StringExpression:
 			c.collectActionRefs(n.Expression, fi)
 		case templparser.CompositeNode:
 			c.collectTemplCalls(n.ChildNodes(), fi)
 		}
+		prevIsNolint = false
 	}
 }
 
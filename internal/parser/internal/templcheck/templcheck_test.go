@@ -1,6 +1,7 @@
 package templcheck_test
 
 import (
+	"go/ast"
 	"go/token"
 	"path/filepath"
 	"testing"
@@ -79,6 +80,14 @@ func derefErr(err error) any {
 		return *e
 	case *templcheck.ErrorActionWrongPage:
 		return *e
+	case *templcheck.ErrorSignalUnknown:
+		return *e
+	case *templcheck.ErrorSignalUnused:
+		return *e
+	case *templcheck.ErrorPatchElementNoID:
+		return *e
+	case *templcheck.ErrorPatchElementIDNotOnPage:
+		return *e
 	default:
 		return err
 	}
@@ -98,6 +107,8 @@ func check(t *testing.T, fixtureName string, app *model.App) []posErr {
 	var errs []posErr
 	templcheck.Check(pkg, app, func(pos token.Position, err error) {
 		errs = append(errs, posErr{pos: pos, err: err})
+	}, func(pos token.Position, err error) {
+		t.Errorf("unexpected warning at %s: %v", pos, err)
 	})
 	return errs
 }
@@ -202,6 +213,219 @@ func TestCheck_ErrActionWrongPage(t *testing.T) {
 	require.ElementsMatch(t, expect, toPosErrors(errs))
 }
 
+// funcDecl returns the declaration of method name of recv in pkg.
+func funcDecl(tb testing.TB, pkg *packages.Package, recv, name string) *ast.FuncDecl {
+	tb.Helper()
+	for _, f := range pkg.Syntax {
+		for _, d := range f.Decls {
+			fd, ok := d.(*ast.FuncDecl)
+			if !ok || fd.Recv == nil || fd.Name.Name != name {
+				continue
+			}
+			t := fd.Recv.List[0].Type
+			if s, ok := t.(*ast.StarExpr); ok {
+				t = s.X
+			}
+			if id, ok := t.(*ast.Ident); ok && id.Name == recv {
+				return fd
+			}
+		}
+	}
+	tb.Fatalf("no method %s.%s", recv, name)
+	return nil
+}
+
+// signalsHandler models method name of recv in pkg,
+// the type of its signals parameter resolved the way the parser does.
+func signalsHandler(
+	tb testing.TB, pkg *packages.Package, recv, method, name string,
+) *model.Handler {
+	tb.Helper()
+	fd := funcDecl(tb, pkg, recv, method+name)
+	h := &model.Handler{Expr: fd.Name, Name: name, HTTPMethod: method}
+	for _, f := range fd.Type.Params.List {
+		if len(f.Names) == 1 && f.Names[0].Name == "signals" {
+			h.InputSignals = &model.Input{
+				Expr: f.Type,
+				Kind: model.InputKindSignals,
+				Name: "signals",
+				Type: model.Type{
+					Resolved: pkg.TypesInfo.TypeOf(f.Type),
+					TypeExpr: f.Type,
+				},
+			}
+		}
+	}
+	return h
+}
+
+func TestCheck_ErrSignals(t *testing.T) {
+	pkg := loadPkg(t, "err_templ_signals")
+	get := &model.HandlerGET{Handler: &model.Handler{Name: "GET"}}
+	app := &model.App{
+		Actions: []*model.Handler{signalsHandler(t, pkg, "App", "POST", "Global")},
+		Events: []*model.Event{{
+			TypeName:      "EventRoom",
+			SubjectFields: []model.SubjectField{{FieldName: "Room", SignalName: "room"}},
+		}},
+		Pages: []*model.Page{
+			{
+				TypeName: "PageLogin",
+				GET:      get,
+				Actions: []*model.Handler{
+					signalsHandler(t, pkg, "PageLogin", "POST", "Submit"),
+				},
+				EventHandlers: []*model.EventHandler{{
+					Expr:          funcDecl(t, pkg, "PageLogin", "OnRoom").Name,
+					Name:          "OnRoom",
+					EventTypeName: "EventRoom",
+				}},
+			},
+			{
+				TypeName: "PageDynamic",
+				GET:      get,
+				Actions: []*model.Handler{
+					signalsHandler(t, pkg, "PageDynamic", "POST", "Save"),
+				},
+			},
+			{
+				TypeName: "PageOK",
+				GET:      get,
+				Actions: []*model.Handler{
+					signalsHandler(t, pkg, "PageOK", "POST", "Save"),
+				},
+			},
+		},
+	}
+
+	var errs, warns []posErr
+	templcheck.Check(pkg, app, func(pos token.Position, err error) {
+		errs = append(errs, posErr{pos: pos, err: err})
+	}, func(pos token.Position, err error) {
+		warns = append(warns, posErr{pos: pos, err: err})
+	})
+
+	unknown := func(signal, closest string) templcheck.ErrorSignalUnknown {
+		return templcheck.ErrorSignalUnknown{
+			Signal: signal, PageType: "PageLogin", Closest: closest,
+		}
+	}
+	unused := func(signal, handler string) templcheck.ErrorSignalUnused {
+		return templcheck.ErrorSignalUnused{
+			Signal: signal, PageType: "PageLogin", Handler: handler,
+		}
+	}
+	// A typo of a known signal is an error.
+	require.Equal(t, []posError{
+		{7, 36, unknown("form.emial", "form.email")},
+		{18, 6, unknown("remeber", "remember")},
+		{20, 39, unknown("showModal", "showmodal")},
+	}, toPosErrors(errs))
+
+	// Anything else may come from what the linter doesn't see.
+	require.Equal(t, []posError{
+		{16, 6, unknown("missing", "")},
+		{22, 27, unknown("_local", "")},
+		{41, 54, unused("form.email", "POSTSubmit")},
+		{41, 54, unused("inputvalue", "POSTSubmit")},
+		{43, 18, unused("room", "OnRoom")},
+	}, toPosErrors(warns))
+}
+
+func TestCheck_OKSignalsPatched(t *testing.T) {
+	pkg := loadPkg(t, "ok_templ_signals_patched")
+	get := &model.HandlerGET{Handler: &model.Handler{Name: "GET"}}
+	app := &model.App{
+		Actions: []*model.Handler{{HTTPMethod: "POST", Name: "Notify"}},
+		Pages: []*model.Page{
+			{
+				TypeName: "PageIndex",
+				GET:      get,
+				Actions: []*model.Handler{
+					signalsHandler(t, pkg, "PageIndex", "POST", "Save"),
+					{HTTPMethod: "POST", Name: "Count"},
+				},
+			},
+			{
+				TypeName: "PageDynamic",
+				GET:      get,
+				Actions:  []*model.Handler{{HTTPMethod: "POST", Name: "Patch"}},
+			},
+		},
+	}
+
+	var errs, warns []posErr
+	templcheck.Check(pkg, app, func(pos token.Position, err error) {
+		errs = append(errs, posErr{pos: pos, err: err})
+	}, func(pos token.Position, err error) {
+		warns = append(warns, posErr{pos: pos, err: err})
+	})
+	requireNoErrs(t, errs)
+	requireNoErrs(t, warns)
+}
+
+// TestCheck_OKSignalsRecursive covers signal types that contain themselves
+// through a pointer, which the lint follows only until they repeat.
+func TestCheck_OKSignalsRecursive(t *testing.T) {
+	pkg := loadPkg(t, "ok_templ_signals_recursive")
+	app := &model.App{
+		Pages: []*model.Page{{
+			TypeName: "PageIndex",
+			GET:      &model.HandlerGET{Handler: &model.Handler{Name: "GET"}},
+			Actions: []*model.Handler{
+				signalsHandler(t, pkg, "PageIndex", "POST", "Save"),
+			},
+		}},
+	}
+
+	var errs, warns []posErr
+	templcheck.Check(pkg, app, func(pos token.Position, err error) {
+		errs = append(errs, posErr{pos: pos, err: err})
+	}, func(pos token.Position, err error) {
+		warns = append(warns, posErr{pos: pos, err: err})
+	})
+	requireNoErrs(t, errs)
+	requireNoErrs(t, warns)
+}
+
+func TestCheck_ErrPatchElement(t *testing.T) {
+	pkg := loadPkg(t, "err_templ_patch")
+	get := &model.HandlerGET{Handler: &model.Handler{Name: "GET"}}
+	app := &model.App{
+		Actions: []*model.Handler{{HTTPMethod: "post", Name: "Global"}},
+		Pages: []*model.Page{{
+			TypeName: "PageIndex",
+			GET:      get,
+			Actions:  []*model.Handler{{HTTPMethod: "post", Name: "Refresh"}},
+		}},
+	}
+
+	var errs, warns []posErr
+	templcheck.Check(pkg, app, func(pos token.Position, err error) {
+		errs = append(errs, posErr{pos: pos, err: err})
+	}, func(pos token.Position, err error) {
+		warns = append(warns, posErr{pos: pos, err: err})
+	})
+
+	noID := func(component, element string) templcheck.ErrorPatchElementNoID {
+		return templcheck.ErrorPatchElementNoID{Component: component, Element: element}
+	}
+	require.Equal(t, []posError{
+		{23, 23, noID("noID", "div")},
+		{25, 23, noID("branches", "span")},
+		{26, 25, noID("noID", "div")},
+		{27, 23, noID("noID", "div")},
+	}, toPosErrors(errs))
+
+	// The App-level POSTGlobal patches missing() too
+	// but may patch it into any page.
+	require.Equal(t, []posError{
+		{24, 23, templcheck.ErrorPatchElementIDNotOnPage{
+			Component: "missing", ID: "missing", PageType: "PageIndex",
+		}},
+	}, toPosErrors(warns))
+}
+
 func TestCheck_ErrContext(t *testing.T) {
 	errs := check(t, "err_templ_context", nil)
 
@@ -318,7 +542,7 @@ func BenchmarkCheck_ErrHref(b *testing.B) {
 	noop := func(token.Position, error) {}
 
 	for b.Loop() {
-		templcheck.Check(pkg, nil, noop)
+		templcheck.Check(pkg, nil, noop, noop)
 	}
 }
 
@@ -327,6 +551,6 @@ func BenchmarkCheck_OKHref(b *testing.B) {
 	noop := func(token.Position, error) {}
 
 	for b.Loop() {
-		templcheck.Check(pkg, nil, noop)
+		templcheck.Check(pkg, nil, noop, noop)
 	}
 }
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/a-h/templ"
+
+	"github.com/romshark/datapages"
+)
+
+type App struct{}
+
+type PageIndex struct{ App *App }
+
+func (PageIndex) GET(r *http.Request) (body templ.Component, err error) {
+	return pageIndex(), nil
+}
+
+func (PageIndex) POSTRefresh(sse datapages.SSE) error {
+	_ = sse.PatchElement(list())
+	_ = sse.PatchElement(wrapped())
+	_ = sse.PatchElement(dynamic("item"))
+	_ = sse.PatchElement(noID())
+	_ = sse.PatchElement(missing(), datapages.PatchModeInner)
+	_ = sse.PatchElement(branches(true))
+	_ = sse.PatchElementAt(noID(), "", datapages.PatchModeAppend)
+	_ = sse.PatchElement(noID(), datapages.PatchSelector(""))
+	// Targeted by selector, no id needed.
+	_ = sse.PatchElement(noID(), datapages.PatchSelector("#toasts"))
+	_ = sse.PatchElementAt(noID(), "#toasts", datapages.PatchModeAppend)
+	var opts []datapages.PatchOption
+	_ = sse.PatchElement(noID(), opts...)
+	_ = sse.PatchElement(templ.Raw("<p>raw</p>"))
+	return nil
+}
+
+// App-level actions patch into any page.
+func (App) POSTGlobal(sse datapages.SSE) error {
+	return sse.PatchElement(missing())
+}
@@ -0,0 +1,79 @@
+//nolint:all
+package app
+
+import (
+	"net/http"
+
+	"github.com/a-h/templ"
+)
+
+type App struct{}
+
+// POSTGlobal is /global
+//
+// An app-level action, its signals are known on every page.
+func (*App) POSTGlobal(r *http.Request, signals struct {
+	Theme string `json:"theme"`
+}) error {
+	return nil
+}
+
+type loginSignals struct {
+	Form struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	} `json:"form"`
+	InputValue string `json:"inputvalue"`
+	Remember   bool   `json:"remember"`
+}
+
+// EventRoom is "room"
+type EventRoom struct{}
+
+// PageLogin is /login
+type PageLogin struct{ App *App }
+
+func (PageLogin) GET(r *http.Request) (body templ.Component, err error) {
+	return loginPage(), nil
+}
+
+// POSTSubmit is /login/submit
+func (PageLogin) POSTSubmit(r *http.Request, signals loginSignals) error { return nil }
+
+func (PageLogin) OnRoom(event EventRoom) error { return nil }
+
+// PageDynamic is /dynamic
+type PageDynamic struct{ App *App }
+
+func (PageDynamic) GET(r *http.Request) (body templ.Component, err error) {
+	return dynamicPage(`{"form":{"name":""}}`), nil
+}
+
+// POSTSave is /dynamic/save
+func (PageDynamic) POSTSave(r *http.Request, signals loginSignals) error { return nil }
+
+// PageOK is /ok
+type PageOK struct{ App *App }
+
+func (PageOK) GET(r *http.Request) (body templ.Component, err error) {
+	return okPage(), nil
+}
+
+// POSTSave is /ok/save
+func (PageOK) POSTSave(r *http.Request, signals struct {
+	FirstName string `json:"firstName"`
+	LastName  string `json:"last_name"`
+	Nested    struct {
+		A string `json:"a"`
+		B string `json:"b"`
+	} `json:"nested"`
+	Quoted string `json:"quoted"`
+	Keyed  string `json:"keyed"`
+	Obj    struct {
+		X int `json:"x"`
+	} `json:"obj"`
+	Bound string `json:"bound"`
+	Cond  string `json:"cond"`
+}) error {
+	return nil
+}
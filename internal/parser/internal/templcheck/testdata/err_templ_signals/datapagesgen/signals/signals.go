@@ -0,0 +1,4 @@
+// Stub of the generated signals package for templcheck tests.
+package signals
+
+const PageOKSignalBound = "bound"
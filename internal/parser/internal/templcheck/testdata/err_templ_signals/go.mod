@@ -0,0 +1,5 @@
+module datapagestest/fixture/err_templ_signals
+
+go 1.27
+
+require github.com/a-h/templ v0.3.1020
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/a-h/templ"
+
+	"github.com/romshark/datapages"
+)
+
+type App struct{}
+
+// POSTNotify is /notify
+//
+// An app-level action, what it patches is known on every page.
+func (*App) POSTNotify(sse datapages.SSE) error {
+	return sse.PatchSignals(json.RawMessage(`{"notice": {"text": "", "level": 1}}`))
+}
+
+type toast struct {
+	Text string `json:"text"`
+}
+
+// PageIndex is /
+type PageIndex struct{ App *App }
+
+func (PageIndex) GET(r *http.Request) (body templ.Component, err error) {
+	return pageIndex(), nil
+}
+
+// POSTSave is /save
+//
+// Reads flash, which no template declares but the page patches.
+func (PageIndex) POSTSave(
+	r *http.Request,
+	sse datapages.SSE,
+	signals struct {
+		Flash string `json:"flash"`
+	},
+) error {
+	return sse.PatchSignals(json.RawMessage(`{"flash":"saved"}`))
+}
+
+// POSTCount is /count
+func (PageIndex) POSTCount(sse datapages.SSE) error {
+	if err := sse.PatchSignalsIfMissing(struct {
+		Toast toast `json:"toast"`
+	}{}); err != nil {
+		return err
+	}
+	return sse.PatchSignals(struct {
+		Count int `json:"count"`
+	}{Count: 1})
+}
+
+// PageDynamic is /dynamic
+type PageDynamic struct{ App *App }
+
+func (PageDynamic) GET(r *http.Request) (body templ.Component, err error) {
+	return dynamicPage(), nil
+}
+
+// POSTPatch is /dynamic/patch
+//
+// Patches signals the linter can't tell.
+func (PageDynamic) POSTPatch(sse datapages.SSE) error {
+	return sse.PatchSignals(map[string]any{"anything": 1})
+}
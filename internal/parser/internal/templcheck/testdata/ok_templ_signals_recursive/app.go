@@ -0,0 +1,46 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/a-h/templ"
+
+	"github.com/romshark/datapages"
+)
+
+type App struct{}
+
+// Item is a list, the next item is an item again.
+type Item struct {
+	Value string `json:"value"`
+	Next  *Item  `json:"next"`
+}
+
+// Chain embeds itself, which adds no fields.
+type Chain struct {
+	*Chain
+	Name string `json:"name"`
+}
+
+// PageIndex is /
+type PageIndex struct{ App *App }
+
+func (PageIndex) GET(r *http.Request) (body templ.Component, err error) {
+	return pageIndex(), nil
+}
+
+// POSTSave is /save
+//
+// Reads and patches signals whose types contain themselves.
+func (PageIndex) POSTSave(
+	r *http.Request,
+	sse datapages.SSE,
+	signals struct {
+		List  Item  `json:"list"`
+		Chain Chain `json:"chain"`
+	},
+) error {
+	return sse.PatchSignals(struct {
+		Head *Item `json:"head"`
+	}{Head: &signals.List})
+}
@@ -166,6 +166,16 @@ func IsCloseSessionType(expr ast.Expr, info *types.Info) bool {
 	return isNamedFromPkg(expr, info, datapagesPkgPath, "CloseSession")
 }
 
+// IsDeferDispatchType reports whether expr resolves to datapages.DeferDispatch.
+func IsDeferDispatchType(expr ast.Expr, info *types.Info) bool {
+	return isNamedFromPkg(expr, info, datapagesPkgPath, "DeferDispatch")
+}
+
+// IsRerenderType reports whether expr resolves to datapages.Rerender.
+func IsRerenderType(expr ast.Expr, info *types.Info) bool {
+	return isNamedFromPkg(expr, info, datapagesPkgPath, "Rerender")
+}
+
 // IsEnableBgStreamType reports whether expr resolves to
 // datapages.EnableBackgroundStreaming.
 func IsEnableBgStreamType(expr ast.Expr, info *types.Info) bool {
@@ -211,6 +221,12 @@ func SessionDataType(expr ast.Expr, info *types.Info) (data types.Type, ok bool)
 	return namedTypeArg(expr, info, "Session")
 }
 
+// TabStateType returns the T type argument of datapages.TabState[T].
+// ok is false if expr isn't an instantiation of datapages.TabState.
+func TabStateType(expr ast.Expr, info *types.Info) (state types.Type, ok bool) {
+	return namedTypeArg(expr, info, "TabState")
+}
+
 // namedTypeArg returns the single type argument of the datapages generic type
 // name that expr resolves to.
 func namedTypeArg(
@@ -20,6 +20,7 @@ type App struct {
 
 	RecoverError        *RecoverError // Nullable.
 	GlobalHeadGenerator *GlobalHead   // Nullable.
+	StreamDrain         *StreamDrain  // Nullable.
 
 	Session *SessionType // Nullable.
 
@@ -60,6 +61,13 @@ type RecoverError struct {
 	OrderedInputs []string
 }
 
+// StreamDrain is the (*App).StreamDrain hook, which runs on every open
+// stream as the server begins to shut down. It takes the datapages.SSE
+// of the stream only.
+type StreamDrain struct {
+	Expr ast.Expr
+}
+
 // SessionType is the datapages.Session[Data] instantiation the application uses.
 // All handlers must agree on the same one.
 type SessionType struct {
@@ -83,23 +91,38 @@ type Page struct {
 
 	PageSpecialization PageSpecialization
 
-	GET           *HandlerGET
-	Actions       []*Handler
-	StreamOpen    *Handler
-	StreamClose   *Handler
-	EventHandlers []*EventHandler
-	Embeds        []*AbstractPage
+	GET               *HandlerGET
+	Actions           []*Handler
+	StreamOpen        *Handler
+	StreamClose       *Handler
+	AuthorizeWildcard *Handler // Decides whether a stream gets the wildcard handlers.
+	EventHandlers     []*EventHandler
+	Embeds            []*AbstractPage
+
+	// TabState is the datapages.TabState[T] instantiation the handlers of
+	// the page take, nil when none does. A page with one is stateful:
+	// its GET hands out a tab instance ID that its stream and actions
+	// send back.
+	TabState *TabStateType
+}
+
+// TabStateType is the datapages.TabState[T] instantiation a page uses.
+// All handlers of the page must agree on the same one.
+type TabStateType struct {
+	Expr  ast.Expr
+	State Type // The T type argument.
 }
 
 type AbstractPage struct {
 	Expr     ast.Expr
 	TypeName string
 
-	Methods       []*Handler
-	StreamOpen    *Handler
-	StreamClose   *Handler
-	EventHandlers []*EventHandler
-	Embeds        []*AbstractPage
+	Methods           []*Handler
+	StreamOpen        *Handler
+	StreamClose       *Handler
+	AuthorizeWildcard *Handler
+	EventHandlers     []*EventHandler
+	Embeds            []*AbstractPage
 }
 
 type TemplComponent struct {
@@ -124,6 +147,7 @@ type Handler struct {
 	InputStreamID *Input
 	InputSSE      *Input
 	InputSession  *Input
+	InputTabState *Input
 	InputPath     *Input
 	InputQuery    *Input
 	InputSignals  *Input
@@ -137,6 +161,7 @@ type Handler struct {
 	OutputRedirect       *Output
 	OutputNewSession     *Output
 	OutputCloseSession   *Output
+	OutputDeferDispatch  *Output
 	OutputEnableBgStream *Output
 	OutputDisableRefresh *Output
 	OutputErr            *Output
@@ -154,13 +179,19 @@ type EventHandler struct {
 	Name          string
 	EventTypeName string
 
+	// Wildcard is true for a handler marked //datapages:wildcard, which
+	// receives the event for every value of its subject fields.
+	Wildcard bool
+
 	InputEvent    *Input
 	InputSSE      *Input
 	InputStreamID *Input
 	InputSession  *Input
+	InputTabState *Input
 	OrderedInputs []*Input // Inputs in user-defined order.
 
-	OutputErr *Output
+	OutputRerender *Output // Nullable.
+	OutputErr      *Output
 }
 
 // InputKind constants identify handler input parameter kinds.
@@ -169,6 +200,7 @@ const (
 	InputKindStreamID = "streamID"
 	InputKindSSE      = "sse"
 	InputKindSession  = "session"
+	InputKindTabState = "tabState"
 	InputKindPath     = "path"
 	InputKindQuery    = "query"
 	InputKindSignals  = "signals"
@@ -184,6 +216,8 @@ const (
 	OutputKindRedirect       = "redirect"
 	OutputKindNewSession     = "newSession"
 	OutputKindCloseSession   = "closeSession"
+	OutputKindDeferDispatch  = "deferDispatch"
+	OutputKindRerender       = "rerender"
 	OutputKindEnableBgStream = "enableBackgroundStreaming"
 	OutputKindDisableRefresh = "disableRefreshAfterHidden"
 	OutputKindErr            = "err"
@@ -257,6 +291,26 @@ type SubjectField struct {
 	SignalName string      // e.g. "instance_id" (from signal:"instance_id" tag)
 }
 
+// JSONKind is the kind of value a field encodes to
+// when encoding/json encodes it by its kind alone.
+type JSONKind uint8
+
+const (
+	JSONKindString JSONKind = iota + 1 // a string
+	JSONKindBool                       // true or false
+	JSONKindInt                        // a signed integer
+	JSONKindUint                       // an unsigned integer
+)
+
+// JSONField is an event field the generated server encodes
+// to JSON without reflection.
+type JSONField struct {
+	FieldName string   // e.g. "Title"
+	Key       string   // e.g. "title" (the json tag name, else the field name)
+	Kind      JSONKind // e.g. JSONKindString
+	OmitEmpty bool     // the json tag has the omitempty option
+}
+
 type Event struct {
 	Expr ast.Expr
 
@@ -264,6 +318,12 @@ type Event struct {
 	Subject  string
 
 	SubjectFields []SubjectField
+
+	// DirectJSON is true when encoding/json encodes every field of the event
+	// type by its kind alone, which JSONFields then list in definition order.
+	// The generated server encodes such an event without reflection.
+	DirectJSON bool
+	JSONFields []JSONField
 }
 
 // HasSubjectUser reports whether the event has a datapages.SubjectUser subject field.
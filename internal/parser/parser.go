@@ -60,6 +60,8 @@ func Parse(appPackagePath string) (app *model.App, errs Errors) {
 	collectSessionType(&ctx, &errs)
 	validateEventsNeedSession(&ctx, &errs)
 	flattenPages(&ctx, &errs)
+	collectTabStates(&ctx, &errs)
+	validateWildcardHandlers(&ctx, &errs)
 	validateRequiredHandlers(&ctx, &errs)
 	finalizePages(&ctx)
 	assignSpecialPages(&ctx, &errs)
@@ -217,6 +219,7 @@ func collectSessionType(ctx *parseCtx, errs *Errors) {
 		}
 		noteHandler(p.StreamOpen)
 		noteHandler(p.StreamClose)
+		noteHandler(p.AuthorizeWildcard)
 		for _, h := range p.Actions {
 			noteHandler(h)
 		}
@@ -272,6 +275,59 @@ func noteSessionType(
 	}
 }
 
+// collectTabStates records on each page the datapages.TabState instantiation
+// its handlers take. The state belongs to the tab showing one page, hence
+// App-level actions, which no page owns, and the error pages, which are
+// rendered in place of others, can't take it.
+func collectTabStates(ctx *parseCtx, errs *Errors) {
+	info := ctx.pkg.TypesInfo
+	for _, h := range ctx.app.Actions {
+		if in := h.InputTabState; in != nil {
+			errs.ErrAt(ctx.pkg.Fset.Position(in.Type.TypeExpr.Pos()),
+				fmt.Errorf("%w: App.%s", ErrTabStateNotOnPage, h.Name))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(ctx.pages)) {
+		pg := ctx.pages[name]
+		var inputs []*model.Input
+		if pg.GET != nil && pg.GET.InputTabState != nil {
+			inputs = append(inputs, pg.GET.InputTabState)
+		}
+		for _, h := range pg.Actions {
+			if h.InputTabState != nil {
+				inputs = append(inputs, h.InputTabState)
+			}
+		}
+		for _, eh := range pg.EventHandlers {
+			if eh.InputTabState != nil {
+				inputs = append(inputs, eh.InputTabState)
+			}
+		}
+		for _, in := range inputs {
+			pos := ctx.pkg.Fset.Position(in.Type.TypeExpr.Pos())
+			if pg.PageSpecialization == model.PageTypeError404 ||
+				pg.PageSpecialization == model.PageTypeError500 {
+				errs.ErrAt(pos, fmt.Errorf("%w: %s", ErrTabStateErrorPage, name))
+				continue
+			}
+			state, _ := typecheck.TabStateType(in.Type.TypeExpr, info)
+			if pg.TabState == nil {
+				pg.TabState = &model.TabStateType{
+					Expr:  in.Type.TypeExpr,
+					State: model.Type{Resolved: state},
+				}
+				continue
+			}
+			if !types.Identical(pg.TabState.State.Resolved, state) {
+				errs.ErrAt(pos, fmt.Errorf("%w: %s uses %s and %s",
+					ErrTabStateTypeConflict, name,
+					types.TypeString(pg.TabState.State.Resolved, nil),
+					types.TypeString(state, nil)))
+			}
+		}
+	}
+}
+
 func firstPassEventType(
 	ctx *parseCtx, errs *Errors, name string, ts *ast.TypeSpec,
 ) {
@@ -390,11 +446,14 @@ func firstPassEventType(
 	ctx.eventSubjects[subj] = name
 	ctx.eventClaims = append(ctx.eventClaims, claim)
 
+	jsonFields, directJSON := eventJSONFields(ctx.pkg.TypesInfo.TypeOf(ts.Type))
 	ctx.app.Events = append(ctx.app.Events, &model.Event{
 		Expr:          ts.Name,
 		TypeName:      name,
 		Subject:       subj,
 		SubjectFields: subjectFields,
+		DirectJSON:    directJSON,
+		JSONFields:    jsonFields,
 	})
 }
 
@@ -593,7 +652,8 @@ func thirdPassMethods(ctx *parseCtx, errs *Errors) {
 			}
 			recv := structinspect.ReceiverTypeName(fd.Recv.List[0].Type)
 
-			// App hooks: (*App).Head, (*App).RecoverError, and App-level actions.
+			// App hooks: (*App).Head, (*App).RecoverError, (*App).StreamDrain,
+			// and App-level actions.
 			if recv == "App" {
 				switch fd.Name.Name {
 				case "Head":
@@ -677,6 +737,21 @@ func thirdPassMethods(ctx *parseCtx, errs *Errors) {
 						Expr:          fd.Name,
 						OrderedInputs: ordered,
 					}
+				case "StreamDrain":
+					info := ctx.pkg.TypesInfo
+					pos := ctx.pkg.Fset.Position(fd.Name.Pos())
+					results := fd.Type.Results
+					var params []*ast.Field
+					if fd.Type.Params != nil {
+						params = expandFieldList(fd.Type.Params.List)
+					}
+					if results == nil || results.NumFields() != 1 ||
+						!typecheck.IsError(info.TypeOf(results.List[0].Type)) ||
+						len(params) != 1 || !typecheck.IsSSEParam(params[0].Type, info) {
+						errs.ErrAt(pos, ErrAppStreamDrainInvalidSignature)
+						continue
+					}
+					ctx.app.StreamDrain = &model.StreamDrain{Expr: fd.Name}
 				default:
 					kind, suffix := methodkind.Classify(fd.Name.Name)
 					if kind.IsAction() {
@@ -727,6 +802,18 @@ func thirdPassMethods(ctx *parseCtx, errs *Errors) {
 			switch kind {
 			case methodkind.StreamOpenHook, methodkind.StreamCloseHook:
 				validateAndAttachStreamHook(ctx, errs, recv, fd, pg, ap, kind)
+			case methodkind.AuthorizeWildcardHook:
+				h, herr := parseAuthorizeWildcard(
+					recv, fd, ctx.pkg.TypesInfo, ctx.pkg.Fset,
+				)
+				if herr != nil {
+					reportErrorsWithFset(errs, ctx.pkg.Fset, pos, herr)
+				}
+				if pg != nil {
+					pg.AuthorizeWildcard = h
+				} else {
+					ap.AuthorizeWildcard = h
+				}
 			case methodkind.EventHandler:
 				if err := validate.EventHandlerMethodName(fd.Name.Name); err != nil {
 					errs.ErrAt(pos,
@@ -822,14 +909,15 @@ func validateAndAttachEventHandler(
 			fmt.Errorf("%w: %s.%s", ErrSignatureEvHandMissingSSE, recv, fd.Name.Name))
 	}
 
-	// What is left after the event, the SSE, the session and the stream ID,
-	// each of which was matched by type already, is unsupported.
+	// What is left after the event, the SSE, the session, the tab state and
+	// the stream ID, each of which was matched by type already, is unsupported.
 	if params != nil {
 		for _, f := range params.List {
 			switch {
 			case typecheck.IsEventType(f.Type, ctx.pkg.TypesInfo, evName):
 			case typecheck.IsSSEParam(f.Type, ctx.pkg.TypesInfo):
 			case paramvalidation.IsSessionParam(f, ctx.pkg.TypesInfo):
+			case paramvalidation.IsTabStateParam(f, ctx.pkg.TypesInfo):
 			case typecheck.IsStreamIDType(f.Type, ctx.pkg.TypesInfo):
 			default:
 				p := f.Type.Pos()
@@ -843,8 +931,8 @@ func validateAndAttachEventHandler(
 		}
 	}
 
-	// OnXXX must return exactly one result of type error.
-	if !eventHandlerReturnsOnlyError(fd, ctx.pkg.TypesInfo) {
+	// OnXXX must return error, optionally preceded by datapages.Rerender.
+	if !eventHandlerResultsValid(fd, ctx.pkg.TypesInfo) {
 		retPos := pos
 		if fd.Type.Results != nil {
 			retPos = ctx.pkg.Fset.Position(fd.Type.Results.Pos())
@@ -897,31 +985,39 @@ func validateAndAttachStreamHook(
 	}
 }
 
-func eventHandlerReturnsOnlyError(fd *ast.FuncDecl, info *types.Info) bool {
-	if fd == nil || fd.Type == nil || fd.Type.Results == nil {
-		return false
-	}
-	results := fd.Type.Results.List
-	if len(results) == 0 {
-		return false
+func eventHandlerResultsValid(fd *ast.FuncDecl, info *types.Info) bool {
+	results := resultTypes(fd)
+
+	// The last result must be `error`, a datapages.Rerender may precede it.
+	switch len(results) {
+	case 1:
+		return typecheck.IsError(info.TypeOf(results[0]))
+	case 2:
+		return typecheck.IsRerenderType(results[0], info) &&
+			typecheck.IsError(info.TypeOf(results[1]))
 	}
+	return false
+}
 
-	// Count actual result values (a single field can declare multiple named results).
-	total := 0
-	for _, f := range results {
-		n := len(f.Names)
-		if n == 0 {
-			n = 1
-		}
-		total += n
+// returnsOnlyError reports whether fd returns exactly one result of type error.
+func returnsOnlyError(fd *ast.FuncDecl, info *types.Info) bool {
+	results := resultTypes(fd)
+	return len(results) == 1 && typecheck.IsError(info.TypeOf(results[0]))
+}
+
+// resultTypes returns the type of every result of fd,
+// a single field can declare multiple named results.
+func resultTypes(fd *ast.FuncDecl) []ast.Expr {
+	if fd == nil || fd.Type == nil || fd.Type.Results == nil {
+		return nil
 	}
-	if total != 1 {
-		return false
+	var results []ast.Expr
+	for _, f := range fd.Type.Results.List {
+		for range max(len(f.Names), 1) {
+			results = append(results, f.Type)
+		}
 	}
-
-	// The sole result type must be `error`.
-	t := info.TypeOf(results[0].Type)
-	return typecheck.IsError(t)
+	return results
 }
 
 func attachHTTPHandler(
@@ -1084,6 +1180,8 @@ func flattenPage(ctx *parseCtx, errs *Errors, pg *model.Page) {
 	streamOpenOwnerPos := token.NoPos
 	streamClosedOwner := ""
 	streamClosedOwnerPos := token.NoPos
+	authorizeOwner := ""
+	authorizeOwnerPos := token.NoPos
 
 	if pg.GET != nil {
 		ownedMethods["GET"] = true
@@ -1107,6 +1205,12 @@ func flattenPage(ctx *parseCtx, errs *Errors, pg *model.Page) {
 			streamClosedOwnerPos = pg.StreamClose.Expr.Pos()
 		}
 	}
+	if pg.AuthorizeWildcard != nil {
+		authorizeOwner = "page"
+		if pg.AuthorizeWildcard.Expr != nil {
+			authorizeOwnerPos = pg.AuthorizeWildcard.Expr.Pos()
+		}
+	}
 	for _, h := range pg.EventHandlers {
 		if h.EventTypeName != "" {
 			handledEvents[h.EventTypeName] = "page"
@@ -1270,6 +1374,37 @@ func flattenPage(ctx *parseCtx, errs *Errors, pg *model.Page) {
 			}
 		}
 
+		if ap.AuthorizeWildcard != nil {
+			switch authorizeOwner {
+			case "":
+				authorizeOwner = ap.TypeName
+				if ap.AuthorizeWildcard.Expr != nil {
+					authorizeOwnerPos = ap.AuthorizeWildcard.Expr.Pos()
+				}
+				pg.AuthorizeWildcard = ap.AuthorizeWildcard
+			case "page", ap.TypeName:
+				// Page-owned or already inherited from the same abstract wins.
+			default:
+				pos := ctx.pkg.Fset.Position(pg.Expr.Pos())
+				if it.embedPos != token.NoPos {
+					pos = ctx.pkg.Fset.Position(it.embedPos)
+				}
+				prevPos := token.Position{}
+				if authorizeOwnerPos != token.NoPos {
+					prevPos = ctx.pkg.Fset.Position(authorizeOwnerPos)
+				}
+				errs.ErrAt(pos, fmt.Errorf(
+					"%w: %s inherits %s and %s which both "+
+						"define AuthorizeWildcard (previous at %s)",
+					ErrAuthorizeWildcardDuplicateEmbed,
+					pg.TypeName,
+					authorizeOwner,
+					ap.TypeName,
+					prevPos,
+				))
+			}
+		}
+
 		for _, h := range ap.EventHandlers {
 			ev := h.EventTypeName
 			if ev == "" {
@@ -1319,6 +1454,52 @@ func flattenPage(ctx *parseCtx, errs *Errors, pg *model.Page) {
 	}
 }
 
+// validateWildcardHandlers checks the pages once they have inherited what
+// they embed. A wildcard handler receives the events of every user and every
+// signal value, a page has to say which streams get them.
+func validateWildcardHandlers(ctx *parseCtx, errs *Errors) {
+	for _, name := range slices.Sorted(maps.Keys(ctx.pages)) {
+		pg := ctx.pages[name]
+		wildcard := false
+		for _, h := range pg.EventHandlers {
+			if !h.Wildcard {
+				continue
+			}
+			wildcard = true
+			pos := ctx.pkg.Fset.Position(h.Expr.Pos())
+			i := slices.IndexFunc(ctx.app.Events, func(e *model.Event) bool {
+				return e.TypeName == h.EventTypeName
+			})
+			if i >= 0 && !ctx.app.Events[i].HasSubjectFields() {
+				errs.ErrAt(pos, fmt.Errorf("%w: %s.On%s handles %s",
+					ErrEvHandWildcardNoSubject, name, h.Name, h.EventTypeName))
+			}
+			if pg.AuthorizeWildcard == nil {
+				errs.ErrAt(pos, fmt.Errorf("%w: %s.On%s",
+					ErrEvHandWildcardUnauthorized, name, h.Name))
+			}
+		}
+		if !wildcard && pg.AuthorizeWildcard != nil {
+			errs.ErrAt(ctx.pkg.Fset.Position(pg.AuthorizeWildcard.Expr.Pos()),
+				fmt.Errorf("%w: %s", ErrAuthorizeWildcardUnused, name))
+		}
+	}
+}
+
+// hasWildcardDirective reports whether a method comment carries
+// the //datapages:wildcard line.
+func hasWildcardDirective(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if strings.TrimSpace(c.Text) == "//datapages:wildcard" {
+			return true
+		}
+	}
+	return false
+}
+
 func validateRequiredHandlers(ctx *parseCtx, errs *Errors) {
 	// Every page type must have a GET handler.
 	for _, name := range slices.Sorted(maps.Keys(ctx.pages)) {
@@ -1376,7 +1557,8 @@ func validateRouteConflicts(ctx *parseCtx, errs *Errors) {
 
 // pageHasStream reports whether the page is served an SSE stream of its own.
 func pageHasStream(p *model.Page) bool {
-	return len(p.EventHandlers) > 0 || p.StreamOpen != nil || p.StreamClose != nil
+	return len(p.EventHandlers) > 0 || p.StreamOpen != nil || p.StreamClose != nil ||
+		p.TabState != nil
 }
 
 // registerRoute reports what ServeMux says about a pattern.
@@ -1420,6 +1602,7 @@ func parseEventHandler(
 		Expr:          fd.Name,
 		Name:          name,
 		EventTypeName: eventTypeName,
+		Wildcard:      hasWildcardDirective(fd.Doc),
 	}
 
 	// Match parameters by name/type in any order.
@@ -1441,13 +1624,30 @@ func parseEventHandler(
 			h.InputSession = parseInput(f, f.Type, info)
 			h.InputSession.Kind = model.InputKindSession
 			h.OrderedInputs = append(h.OrderedInputs, h.InputSession)
+		case paramvalidation.IsTabStateParam(f, info):
+			h.InputTabState = parseInput(f, f.Type, info)
+			h.InputTabState.Kind = model.InputKindTabState
+			h.OrderedInputs = append(h.OrderedInputs, h.InputTabState)
 		}
 	}
 
 	if fd.Type.Results != nil && len(fd.Type.Results.List) > 0 {
+		results := fd.Type.Results.List
+		last := results[len(results)-1]
 		h.OutputErr = &model.Output{
 			Kind: model.OutputKindErr,
-			Type: makeType(fd.Type.Results.List[0].Type, info),
+			Type: makeType(last.Type, info),
+		}
+		if first := results[0]; typecheck.IsRerenderType(first.Type, info) {
+			h.OutputRerender = &model.Output{
+				Expr: first.Type,
+				Kind: model.OutputKindRerender,
+				Type: makeType(first.Type, info),
+			}
+			if len(first.Names) > 0 {
+				h.OutputRerender.Expr = first.Names[0]
+				h.OutputRerender.Name = first.Names[0].Name
+			}
 		}
 	}
 
@@ -1604,7 +1804,7 @@ func parseStreamHook(
 	}
 	// Stream hooks may return error or nothing.
 	noReturn := fd.Type.Results == nil || len(fd.Type.Results.List) == 0
-	if !noReturn && !eventHandlerReturnsOnlyError(fd, info) {
+	if !noReturn && !returnsOnlyError(fd, info) {
 		retPos := fset.Position(fd.Type.Results.Pos())
 		return h, &positionedError{
 			pos: retPos,
@@ -1622,6 +1822,72 @@ func parseStreamHook(
 	return h, nil
 }
 
+// parseAuthorizeWildcard parses the AuthorizeWildcard hook, which takes the
+// request and optionally the session and returns (bool, error).
+func parseAuthorizeWildcard(
+	recv string, fd *ast.FuncDecl, info *types.Info, fset *token.FileSet,
+) (*model.Handler, error) {
+	h := &model.Handler{
+		Expr: fd.Name,
+		Name: fd.Name.Name,
+	}
+
+	var unsupErrs []error
+	if fd.Type.Params != nil {
+		for _, f := range expandFieldList(fd.Type.Params.List) {
+			switch {
+			case typecheck.IsPtrToNetHTTPReq(f.Type, info) && h.InputRequest == nil:
+				h.InputRequest = parseInput(f, f.Type, info)
+				h.InputRequest.Kind = model.InputKindRequest
+				h.OrderedInputs = append(h.OrderedInputs, h.InputRequest)
+			case paramvalidation.IsSessionParam(f, info) && h.InputSession == nil:
+				h.InputSession = parseInput(f, f.Type, info)
+				h.InputSession.Kind = model.InputKindSession
+				h.OrderedInputs = append(h.OrderedInputs, h.InputSession)
+			default:
+				p := f.Type.Pos()
+				if len(f.Names) > 0 {
+					p = f.Names[0].Pos()
+				}
+				unsupErrs = append(unsupErrs, &positionedError{
+					pos: fset.Position(p),
+					err: unsupportedInputError(f, h, info, recv, fd.Name.Name),
+				})
+			}
+		}
+	}
+	if h.InputRequest == nil {
+		return h, fmt.Errorf("%w in %s.%s",
+			ErrSignatureMissingReq, recv, fd.Name.Name)
+	}
+	if len(unsupErrs) > 0 {
+		return h, errors.Join(unsupErrs...)
+	}
+
+	var results []*ast.Field
+	if fd.Type.Results != nil {
+		results = expandFieldList(fd.Type.Results.List)
+	}
+	if len(results) != 2 ||
+		info.TypeOf(results[0].Type) != types.Typ[types.Bool] ||
+		!typecheck.IsError(info.TypeOf(results[1].Type)) {
+		retPos := fset.Position(fd.Name.Pos())
+		if fd.Type.Results != nil {
+			retPos = fset.Position(fd.Type.Results.Pos())
+		}
+		return h, &positionedError{
+			pos: retPos,
+			err: fmt.Errorf("%w: %s.%s",
+				ErrSignatureAuthorizeWildcard, recv, fd.Name.Name),
+		}
+	}
+	h.OutputErr = &model.Output{
+		Kind: model.OutputKindErr,
+		Type: makeType(results[1].Type, info),
+	}
+	return h, nil
+}
+
 func loadPackage(appPackagePath string) (*packages.Package, error) {
 	cfg := &packages.Config{
 		Mode: packages.NeedName |
@@ -1891,8 +2157,12 @@ func typeCandidates(
 	}
 
 	isSession := typecheck.IsSessionType(f.Type, info)
+	_, isTabState := typecheck.TabStateType(f.Type, info)
 	isUint64 := gotypes.IsUint64(t)
-	isStruct := isStructType(t)
+	// The path, query and signals wrappers all carry a plain struct,
+	// hence a plain struct suggests wrapping. A named type with
+	// a more specific match, Session for one, is not a candidate.
+	isPlainStruct := isStructType(t) && !isSession && !isTabState
 
 	type candidate struct {
 		name     string
@@ -1902,12 +2172,10 @@ func typeCandidates(
 	all := []candidate{
 		{"datapages.StreamID", h.InputStreamID != nil, isUint64},
 		{"datapages.Session[Data]", h.InputSession != nil, isSession},
-		// The path, query and signals wrappers all carry a plain struct,
-		// hence a plain struct suggests wrapping. A named type with
-		// a more specific match, Session for one, is not a candidate.
-		{"datapages.Path[...]", h.InputPath != nil, isStruct && !isSession},
-		{"datapages.Query[...]", h.InputQuery != nil, isStruct && !isSession},
-		{"datapages.Signals[...]", h.InputSignals != nil, isStruct && !isSession},
+		{"datapages.TabState[T]", h.InputTabState != nil, isTabState},
+		{"datapages.Path[...]", h.InputPath != nil, isPlainStruct},
+		{"datapages.Query[...]", h.InputQuery != nil, isPlainStruct},
+		{"datapages.Signals[...]", h.InputSignals != nil, isPlainStruct},
 	}
 
 	var names []string
@@ -2046,6 +2314,16 @@ func parseHandler(
 			h.InputSession.Kind = model.InputKindSession
 			h.OrderedInputs = append(h.OrderedInputs, h.InputSession)
 
+		case paramvalidation.IsTabStateParam(f, info):
+			if h.InputTabState != nil {
+				unsupErrs = append(unsupErrs,
+					fieldErr(unsupportedInputError(f, h, info, recv, fd.Name.Name)))
+				continue
+			}
+			h.InputTabState = parseInput(f, f.Type, info)
+			h.InputTabState.Kind = model.InputKindTabState
+			h.OrderedInputs = append(h.OrderedInputs, h.InputTabState)
+
 		case paramvalidation.IsPathParam(f, info):
 			if h.InputPath != nil {
 				unsupErrs = append(unsupErrs,
@@ -2213,6 +2491,21 @@ func parseHandler(
 				out.Kind = model.OutputKindCloseSession
 				h.OutputCloseSession = out
 
+			case typecheck.IsDeferDispatchType(r.Type, info):
+				if !kind.IsAction() {
+					return h, nil, retErr(fmt.Errorf("%w in %s.%s",
+						ErrDeferDispatchNotAction, recv, fd.Name.Name))
+				}
+				if h.OutputDeferDispatch != nil {
+					return h, nil, dup()
+				}
+				out.Kind = model.OutputKindDeferDispatch
+				h.OutputDeferDispatch = out
+
+			case typecheck.IsRerenderType(r.Type, info):
+				return h, nil, retErr(fmt.Errorf("%w in %s.%s",
+					ErrRerenderNotEventHandler, recv, fd.Name.Name))
+
 			case typecheck.IsEnableBgStreamType(r.Type, info):
 				if kind != methodkind.GETHandler {
 					return h, nil, retErr(fmt.Errorf("%w in %s.%s",
@@ -2332,5 +2625,5 @@ func actionIsUnderPage(page, action string) bool {
 
 // checkTemplFiles delegates to the templcheck subpackage.
 func checkTemplFiles(ctx *parseCtx, errs *Errors) {
-	templcheck.Check(ctx.pkg, ctx.app, errs.ErrAt)
+	templcheck.Check(ctx.pkg, ctx.app, errs.ErrAt, errs.WarnAt)
 }
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"go/token"
 	"go/types"
+	"reflect"
 	"strings"
 
 	"github.com/romshark/datapages/internal/parser/internal/typecheck"
+	"github.com/romshark/datapages/internal/parser/model"
 	"github.com/romshark/datapages/internal/structtag"
 )
 
@@ -35,6 +37,125 @@ func init() {
 	_textUnmarshalerIface = makeIface("UnmarshalText")
 }
 
+// _jsonMarshalerIface and _textMarshalerIface are the synthetic counterparts
+// of json.Marshaler and encoding.TextMarshaler.
+var _jsonMarshalerIface, _textMarshalerIface *types.Interface
+
+func init() {
+	byteSlice := types.NewSlice(types.Typ[types.Byte])
+	errType := types.Universe.Lookup("error").Type()
+	makeIface := func(methodName string) *types.Interface {
+		sig := types.NewSignatureType(
+			nil, nil, nil, nil,
+			types.NewTuple(
+				types.NewVar(token.NoPos, nil, "", byteSlice),
+				types.NewVar(token.NoPos, nil, "", errType),
+			),
+			false,
+		)
+		iface := types.NewInterfaceType(
+			[]*types.Func{types.NewFunc(token.NoPos, nil, methodName, sig)}, nil,
+		)
+		iface.Complete()
+		return iface
+	}
+	_jsonMarshalerIface = makeIface("MarshalJSON")
+	_textMarshalerIface = makeIface("MarshalText")
+}
+
+// implementsMarshaler reports whether t (or *t) implements json.Marshaler
+// or encoding.TextMarshaler.
+func implementsMarshaler(t *types.Named) bool {
+	for _, typ := range []types.Type{t, types.NewPointer(t)} {
+		if types.Implements(typ, _jsonMarshalerIface) ||
+			types.Implements(typ, _textMarshalerIface) {
+			return true
+		}
+	}
+	return false
+}
+
+// eventJSONFields returns the fields of event type t when encoding/json
+// encodes every one of them by its kind alone, which makes it safe to encode
+// the event without reflection. ok is false when any field needs
+// encoding/json: it's embedded, unexported, of a composite or floating-point
+// kind, marshals itself, has a tag option other than omitempty or a key that
+// would need escaping, or shares its key with another field.
+func eventJSONFields(t types.Type) (fields []model.JSONField, ok bool) {
+	if t == nil {
+		return nil, false
+	}
+	st, ok := t.Underlying().(*types.Struct)
+	if !ok {
+		return nil, false
+	}
+	seen := make(map[string]bool, st.NumFields())
+	for i := range st.NumFields() {
+		f, tag := st.Field(i), st.Tag(i)
+		if structtag.JSONTagExcluded(tag) {
+			continue
+		}
+		if f.Embedded() || !f.Exported() {
+			return nil, false
+		}
+		// An alias marshals as the type it stands for.
+		if named, isNamed := types.Unalias(f.Type()).(*types.Named); isNamed &&
+			implementsMarshaler(named) {
+			return nil, false
+		}
+		basic, isBasic := f.Type().Underlying().(*types.Basic)
+		if !isBasic {
+			return nil, false
+		}
+		jf := model.JSONField{FieldName: f.Name(), Key: f.Name()}
+		switch info := basic.Info(); {
+		case info&types.IsString != 0:
+			jf.Kind = model.JSONKindString
+		case info&types.IsBoolean != 0:
+			jf.Kind = model.JSONKindBool
+		case info&types.IsUnsigned != 0:
+			jf.Kind = model.JSONKindUint
+		case info&types.IsInteger != 0:
+			jf.Kind = model.JSONKindInt
+		default:
+			return nil, false
+		}
+		if v, has := reflect.StructTag(tag).Lookup("json"); has {
+			name, opts, _ := strings.Cut(v, ",")
+			switch opts {
+			case "":
+			case "omitempty":
+				jf.OmitEmpty = true
+			default:
+				return nil, false
+			}
+			if name != "" {
+				jf.Key = name
+			}
+		}
+		if !isPlainJSONKey(jf.Key) || seen[jf.Key] {
+			return nil, false
+		}
+		seen[jf.Key] = true
+		fields = append(fields, jf)
+	}
+	return fields, true
+}
+
+// isPlainJSONKey reports whether encoding/json writes key as it is,
+// neither escaping it nor rejecting it as a tag name.
+func isPlainJSONKey(key string) bool {
+	for _, c := range []byte(key) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '_', c == '-', c == '.', c == '$':
+		default:
+			return false
+		}
+	}
+	return key != ""
+}
+
 // implementsUnmarshaler reports whether t (or *t) implements json.Unmarshaler
 // or encoding.TextUnmarshaler.
 func implementsUnmarshaler(t *types.Named) bool {
@@ -85,6 +206,7 @@ func validateEventType(
 	if t == nil {
 		return
 	}
+	t = types.Unalias(t)
 	if visited[t] {
 		return
 	}
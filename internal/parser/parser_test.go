@@ -44,6 +44,7 @@ func TestParse_Minimal(t *testing.T) {
 	require.Nil(app.PageError500)
 	require.Nil(app.RecoverError)
 	require.Nil(app.GlobalHeadGenerator)
+	require.Nil(app.StreamDrain)
 }
 
 func TestParse_Basic(t *testing.T) {
@@ -425,6 +426,82 @@ func TestParse_ErrStreamHooks(t *testing.T) {
 	)
 }
 
+func TestParse_StreamDrain(t *testing.T) {
+	app, err := parse(t, "stream_drain")
+	requireParseErrors(t, err /*none*/)
+	require.NotNil(t, app.StreamDrain)
+	requireExprLineCol(t, app, app.StreamDrain.Expr, "app.go", 18, 13)
+}
+
+func TestParse_Rerender(t *testing.T) {
+	require := require.New(t)
+	app, err := parse(t, "rerender")
+	requireParseErrors(t, err /*none*/)
+
+	p := findPage(app, "PageIndex")
+	require.NotNil(p)
+	require.Len(p.EventHandlers, 2)
+
+	placed := p.EventHandlers[0]
+	require.Equal("Placed", placed.Name)
+	require.NotNil(placed.OutputRerender)
+	require.Equal(model.OutputKindRerender, placed.OutputRerender.Kind)
+	require.Equal("rerender", placed.OutputRerender.Name)
+	require.NotNil(placed.OutputErr)
+
+	canceled := p.EventHandlers[1]
+	require.Equal("Canceled", canceled.Name)
+	require.Nil(canceled.OutputRerender)
+	require.NotNil(canceled.OutputErr)
+}
+
+func TestParse_TabState(t *testing.T) {
+	require := require.New(t)
+	app, err := parse(t, "tab_state")
+	requireParseErrors(t, err /*none*/)
+
+	require.Nil(app.PageIndex.TabState)
+
+	p := findPage(app, "PageEditor")
+	require.NotNil(p)
+	require.NotNil(p.TabState)
+	require.Equal("datapagestest/fixture/tab_state.Draft",
+		p.TabState.State.Resolved.String())
+
+	require.NotNil(p.GET.InputTabState)
+	require.Equal(model.InputKindTabState, p.GET.InputTabState.Kind)
+	require.Equal("tab", p.GET.InputTabState.Name)
+
+	require.Len(p.Actions, 1)
+	a := p.Actions[0]
+	require.NotNil(a.InputTabState)
+	require.Equal(a.InputTabState, a.OrderedInputs[0], "declaration order")
+
+	require.Len(p.EventHandlers, 1)
+	require.NotNil(p.EventHandlers[0].InputTabState)
+	require.Equal(model.InputKindTabState, p.EventHandlers[0].InputTabState.Kind)
+
+	// Only an action takes the state, which still makes the page stateful.
+	p = findPage(app, "PageCounter")
+	require.NotNil(p)
+	require.NotNil(p.TabState)
+	require.Equal("int", p.TabState.State.Resolved.String())
+	require.Nil(p.GET.InputTabState)
+}
+
+func TestParse_ErrTabState(t *testing.T) {
+	_, err := parse(t, "err_tab_state")
+	require.NotZero(t, err.Error())
+
+	requireParseErrors(
+		t, err,
+		parser.ErrTabStateTypeConflict,      // PageIndex.POSTSave
+		parser.ErrTabStateNotOnPage,         // App.POSTSignOut
+		parser.ErrTabStateErrorPage,         // PageError404.GET
+		parser.ErrSignatureUnsupportedInput, // PageIndex.StreamOpen
+	)
+}
+
 func TestParse_ErrUnsupportedMethod(t *testing.T) {
 	_, err := parse(t, "err_unsupported_method")
 	require.NotZero(t, err.Error())
@@ -614,6 +691,7 @@ func TestParse_ErrEventHandler(t *testing.T) {
 		parser.ErrSignatureEvHandReturnMustBeError,
 		parser.ErrSignatureEvHandReturnMustBeError,
 		parser.ErrSignatureEvHandReturnMustBeError,
+		parser.ErrSignatureEvHandReturnMustBeError, // OnEventRerender: Rerender after error
 	)
 }
 
@@ -778,6 +856,90 @@ func TestParse_SignalSubjectFields(t *testing.T) {
 	}
 }
 
+func TestParse_EventJSONFields(t *testing.T) {
+	app, errs := parse(t, "event_json")
+	require := require.New(t)
+	requireParseErrors(t, errs)
+	require.NotNil(app)
+
+	events := map[string]*model.Event{}
+	for _, e := range app.Events {
+		events[e.TypeName] = e
+	}
+
+	for name, tc := range map[string]struct {
+		directJSON bool
+		jsonFields []model.JSONField
+	}{
+		"EventDirect": {
+			directJSON: true,
+			jsonFields: []model.JSONField{
+				// An untagged subject field is keyed by its name.
+				{FieldName: "Room", Key: "Room", Kind: model.JSONKindString},
+				{FieldName: "Title", Key: "title", Kind: model.JSONKindString},
+				{FieldName: "Draft", Key: "draft", Kind: model.JSONKindBool, OmitEmpty: true},
+				{FieldName: "Delta", Key: "delta", Kind: model.JSONKindInt},
+				{FieldName: "Count", Key: "count", Kind: model.JSONKindUint, OmitEmpty: true},
+			},
+		},
+		"EventEmpty":      {directJSON: true},
+		"EventFloat":      {},
+		"EventTime":       {},
+		"EventSlice":      {},
+		"EventStringOpt":  {},
+		"EventEscapedKey": {},
+		// An alias of a type that marshals itself marshals itself too.
+		"EventAliasText": {},
+		"EventAliasJSON": {},
+	} {
+		e, ok := events[name]
+		require.True(ok, "missing event: %s", name)
+		require.Equal(tc.directJSON, e.DirectJSON, "event %s DirectJSON", name)
+		require.Equal(tc.jsonFields, e.JSONFields, "event %s JSONFields", name)
+	}
+}
+
+func TestParse_WildcardHandler(t *testing.T) {
+	app, errs := parse(t, "wildcard_handler")
+	require := require.New(t)
+	requireParseErrors(t, errs)
+	require.NotNil(app)
+
+	pages := map[string]*model.Page{}
+	for _, p := range app.Pages {
+		pages[p.TypeName] = p
+	}
+
+	index := pages["PageIndex"]
+	require.NotNil(index)
+	require.Nil(index.AuthorizeWildcard)
+	require.Len(index.EventHandlers, 1)
+	require.False(index.EventHandlers[0].Wildcard)
+
+	// The hook is inherited from the embedded Admin.
+	dashboard := pages["PageDashboard"]
+	require.NotNil(dashboard)
+	require.NotNil(dashboard.AuthorizeWildcard)
+	require.NotNil(dashboard.AuthorizeWildcard.InputRequest)
+	require.NotNil(dashboard.AuthorizeWildcard.InputSession)
+	require.NotNil(dashboard.AuthorizeWildcard.OutputErr)
+	require.Len(dashboard.EventHandlers, 1)
+	require.True(dashboard.EventHandlers[0].Wildcard)
+}
+
+func TestParse_ErrWildcardHandler(t *testing.T) {
+	_, err := parse(t, "err_wildcard_handler")
+	require.NotZero(t, err.Error())
+
+	requireParseErrors(
+		t, err,
+		parser.ErrEvHandWildcardUnauthorized,
+		parser.ErrEvHandWildcardNoSubject,
+		parser.ErrAuthorizeWildcardUnused,
+		parser.ErrSignatureAuthorizeWildcard,
+	)
+}
+
 func TestParse_ErrEmbedDuplicateEventHandler(t *testing.T) {
 	_, err := parse(t, "err_embed_duplicate_event_handler")
 	require.NotZero(t, err.Error())
@@ -942,6 +1104,15 @@ func TestParse_Signals(t *testing.T) {
 	}
 }
 
+// TestParse_SignalsRecursive covers signal types that contain themselves,
+// which a tree or a list of signals does.
+func TestParse_SignalsRecursive(t *testing.T) {
+	app, err := parse(t, "signals_recursive")
+	requireParseErrors(t, err /*none*/)
+	require.NotNil(t, app)
+	require.NotNil(t, app.PageIndex.GET.InputSignals)
+}
+
 func TestParse_Dispatch(t *testing.T) {
 	app, err := parse(t, "dispatch")
 	require := require.New(t)
@@ -950,7 +1121,7 @@ func TestParse_Dispatch(t *testing.T) {
 
 	p := app.PageIndex
 	require.NotNil(p)
-	require.Len(p.Actions, 3)
+	require.Len(p.Actions, 4)
 
 	// POSTSingle - single event dispatch
 	{
@@ -983,6 +1154,19 @@ func TestParse_Dispatch(t *testing.T) {
 		require.Len(a.InputDispatches, 1)
 		require.Equal("EventFoo", a.InputDispatches[0].EventTypeName)
 	}
+
+	// POSTDeferred - events held back until the handler succeeded
+	{
+		a := findAction(p.Actions, "Deferred")
+		require.NotNil(a)
+		require.Len(a.InputDispatches, 1)
+		require.NotNil(a.OutputDeferDispatch)
+		require.Equal(model.OutputKindDeferDispatch, a.OutputDeferDispatch.Kind)
+		require.Equal("deferDispatch", a.OutputDeferDispatch.Name)
+	}
+	for _, name := range []string{"Single", "Multi", "WithSignals"} {
+		require.Nil(findAction(p.Actions, name).OutputDeferDispatch, name)
+	}
 }
 
 func TestParse_ErrDispatch(t *testing.T) {
@@ -1254,6 +1438,8 @@ func TestParse_ErrGETOptions(t *testing.T) {
 		t, err,
 		parser.ErrEnableBgStreamNotGET,
 		parser.ErrDisableRefreshNotGET,
+		parser.ErrDeferDispatchNotAction,
+		parser.ErrRerenderNotEventHandler,
 		parser.ErrSignatureUnsupportedOutput,
 		parser.ErrSignatureUnsupportedOutput,
 	)
@@ -1271,6 +1457,7 @@ func TestParse_ErrSignals(t *testing.T) {
 		parser.ErrSignalsFieldMissingTag,
 		parser.ErrSignalsFieldDuplicateTag,
 		parser.ErrQueryReflectSignalNotInSignals,
+		parser.ErrQueryReflectSignalOption,
 	)
 }
 
@@ -1446,6 +1633,9 @@ func TestParse_ErrorPositions(t *testing.T) {
 		"err_recover_error_return": {
 			{parser.ErrAppRecoverErrorInvalidSignature, "app.go", 20, 13},
 		},
+		"err_stream_drain": {
+			{parser.ErrAppStreamDrainInvalidSignature, "app.go", 20, 13},
+		},
 		"err_dispatch": {
 			{parser.ErrSignatureUnsupportedInput, "app.go", 34, 2},
 			{parser.ErrSignatureUnsupportedInput, "app.go", 47, 2},
@@ -1502,6 +1692,7 @@ func TestParse_ErrorPositions(t *testing.T) {
 			{parser.ErrSignalsFieldMissingTag, "app.go", 55, 3},
 			{parser.ErrSignalsFieldDuplicateTag, "app.go", 71, 3},
 			{parser.ErrQueryReflectSignalNotInSignals, "app.go", 85, 2},
+			{parser.ErrQueryReflectSignalOption, "app.go", 104, 2},
 		},
 		"err_unsupported_output": {
 			{parser.ErrSignatureUnsupportedOutput, "app.go", 27, 30},
@@ -1516,6 +1707,7 @@ func TestParse_ErrorPositions(t *testing.T) {
 			{parser.ErrSignatureEvHandReturnMustBeError, "app.go", 100, 3},
 			{parser.ErrSignatureEvHandReturnMustBeError, "app.go", 109, 3},
 			{parser.ErrSignatureEvHandReturnMustBeError, "app.go", 120, 3},
+			{parser.ErrSignatureEvHandReturnMustBeError, "app.go", 132, 3},
 		},
 	} {
 		t.Run(name, func(t *testing.T) {
@@ -67,3 +67,16 @@ func (PageIndex) POSTWithSignals(
 	_ = signals
 	return dispatch.Dispatch(EventFoo{Data: "hello"})
 }
+
+// POSTDeferred is /deferred
+//
+// Action that holds its events back until it succeeded.
+func (PageIndex) POSTDeferred(
+	r *http.Request,
+	dispatch datapages.Dispatcher[EventFoo],
+) (deferDispatch datapages.DeferDispatch, err error) {
+	if err := dispatch.Dispatch(EventFoo{Data: "hello"}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -122,3 +122,13 @@ func (PageIndex) OnEventFuzz(
 	_ = sse
 	return 0
 }
+
+// EventRerender is "rerender"
+type EventRerender struct{}
+
+func (PageIndex) OnEventRerender(
+	event EventRerender,
+	sse datapages.SSE,
+) (err error, rerender datapages.Rerender) { /* ErrEvHandReturnMustBeError */
+	return nil, false
+}
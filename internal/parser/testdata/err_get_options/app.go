@@ -37,6 +37,30 @@ func (PageIndex) POSTBadRefresh(
 	return false, nil
 }
 
+/* ErrDeferDispatchNotAction: not an action handler */
+
+// PageBadDefer is /bad-defer
+type PageBadDefer struct{ App *App }
+
+func (PageBadDefer) GET(
+	r *http.Request,
+) (
+	body datapages.Component,
+	deferDispatch datapages.DeferDispatch,
+	err error,
+) {
+	return body, false, nil
+}
+
+/* ErrRerenderNotEventHandler: not an event handler */
+
+// POSTBadRerender is /bad-defer/rerender
+func (PageBadDefer) POSTBadRerender(
+	r *http.Request,
+) (rerender datapages.Rerender, err error) {
+	return false, nil
+}
+
 // PageBadType is /bad-type
 type PageBadType struct{ App *App }
 
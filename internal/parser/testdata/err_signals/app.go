@@ -93,3 +93,22 @@ func (PageBadReflect) GET(
 	_ = signals
 	return body, err
 }
+
+// PageBadReflectOption is /bad-reflect-option
+type PageBadReflectOption struct{ App *App }
+
+/* ErrQueryReflectSignalOption */
+
+func (PageBadReflectOption) GET(
+	r *http.Request,
+	query datapages.Query[struct {
+		Term string `query:"t" reflectsignal:"term,pop"`
+	}],
+	signals datapages.Signals[struct {
+		Term string `json:"term"`
+	}],
+) (body datapages.Component, err error) {
+	_ = query
+	_ = signals
+	return body, err
+}
@@ -0,0 +1,20 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/romshark/datapages"
+)
+
+type App struct{}
+
+// PageIndex is /
+type PageIndex struct{ App *App }
+
+func (PageIndex) GET(r *http.Request) (body datapages.Component, err error) {
+	return nil, nil
+}
+
+/* ErrAppStreamDrainInvalidSignature */
+
+func (*App) StreamDrain(r *http.Request, sse datapages.SSE) error { return nil }
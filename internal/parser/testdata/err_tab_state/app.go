@@ -0,0 +1,60 @@
+//nolint:all
+
+package app
+
+import (
+	"net/http"
+
+	"github.com/romshark/datapages"
+)
+
+type App struct{}
+
+// PageIndex is /
+type PageIndex struct{ App *App }
+
+func (PageIndex) GET(
+	r *http.Request, tab datapages.TabState[string],
+) (body datapages.Component, err error) {
+	return nil, nil
+}
+
+/* ErrTabStateTypeConflict: different T than GET */
+
+// POSTSave is /save
+func (PageIndex) POSTSave(r *http.Request, tab datapages.TabState[int]) error {
+	return nil
+}
+
+/* ErrTabStateNotOnPage */
+
+// POSTSignOut is /sign-out
+func (*App) POSTSignOut(r *http.Request, tab datapages.TabState[int]) error {
+	return nil
+}
+
+// PageError404 is /not-found
+type PageError404 struct{ App *App }
+
+/* ErrTabStateErrorPage */
+
+func (PageError404) GET(
+	r *http.Request, tab datapages.TabState[int],
+) (body datapages.Component, err error) {
+	return nil, nil
+}
+
+// EventSaved is "saved"
+type EventSaved struct{}
+
+/* ErrSignatureUnsupportedInput: stream hooks take no tab state */
+
+func (PageIndex) StreamOpen(
+	r *http.Request, streamID datapages.StreamID, tab datapages.TabState[string],
+) error {
+	return nil
+}
+
+func (PageIndex) OnSaved(event EventSaved, sse datapages.SSE) error {
+	return nil
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/romshark/datapages"
+)
+
+type App struct{}
+
+// EventPlain is "plain"
+type EventPlain struct {
+	Data string `json:"data"`
+}
+
+// EventRoom is "room"
+type EventRoom struct {
+	Room datapages.Subject `json:"room"`
+}
+
+// PageIndex is /
+type PageIndex struct{ App *App }
+
+func (PageIndex) GET(r *http.Request) (body datapages.Component, err error) {
+	return nil, nil
+}
+
+/* ErrEvHandWildcardUnauthorized */
+
+//datapages:wildcard
+func (PageIndex) OnRoom(event EventRoom, sse datapages.SSE) error {
+	return nil
+}
+
+// PagePlain is /plain
+type PagePlain struct{ App *App }
+
+func (PagePlain) GET(r *http.Request) (body datapages.Component, err error) {
+	return nil, nil
+}
+
+func (PagePlain) AuthorizeWildcard(r *http.Request) (bool, error) {
+	return true, nil
+}
+
+/* ErrEvHandWildcardNoSubject */
+
+//datapages:wildcard
+func (PagePlain) OnPlain(event EventPlain, sse datapages.SSE) error {
+	return nil
+}
+
+// PageUnused is /unused
+type PageUnused struct{ App *App }
+
+func (PageUnused) GET(r *http.Request) (body datapages.Component, err error) {
+	return nil, nil
+}
+
+/* ErrAuthorizeWildcardUnused */
+
+func (PageUnused) AuthorizeWildcard(r *http.Request) (bool, error) {
+	return true, nil
+}
+
+func (PageUnused) OnRoom(event EventRoom, sse datapages.SSE) error {
+	return nil
+}
+
+// PageBadReturn is /bad-return
+type PageBadReturn struct{ App *App }
+
+func (PageBadReturn) GET(r *http.Request) (body datapages.Component, err error) {
+	return nil, nil
+}
+
+/* ErrSignatureAuthorizeWildcard */
+
+func (PageBadReturn) AuthorizeWildcard(r *http.Request) error {
+	return nil
+}
+
+//datapages:wildcard
+func (PageBadReturn) OnRoom(event EventRoom, sse datapages.SSE) error {
+	return nil
+}
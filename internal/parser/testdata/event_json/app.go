@@ -0,0 +1,105 @@
+//nolint:all
+package app
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/romshark/datapages"
+)
+
+type App struct{}
+
+// PageIndex is /
+type PageIndex struct{ App *App }
+
+func (PageIndex) GET(r *http.Request) (body datapages.Component, err error) {
+	return body, err
+}
+
+type Count uint16
+
+// EventDirect is "direct"
+type EventDirect struct {
+	Room datapages.Subject
+
+	Title   string `json:"title"`
+	Draft   bool   `json:"draft,omitempty"`
+	Delta   int64  `json:"delta"`
+	Count   Count  `json:"count,omitempty"`
+	Ignored string `json:"-"`
+}
+
+// EventEmpty is "empty"
+type EventEmpty struct{}
+
+// EventFloat is "float"
+type EventFloat struct {
+	Value float64 `json:"value"`
+}
+
+// EventTime is "time"
+type EventTime struct {
+	At time.Time `json:"at"`
+}
+
+// EventSlice is "slice"
+type EventSlice struct {
+	IDs []string `json:"ids"`
+}
+
+// EventStringOpt is "string-opt"
+type EventStringOpt struct {
+	N int `json:"n,string"`
+}
+
+// Level marshals itself as text.
+type Level int
+
+func (l Level) MarshalText() ([]byte, error) { return []byte("level"), nil }
+
+func (l *Level) UnmarshalText([]byte) error { return nil }
+
+type LevelAlias = Level
+
+// EventAliasText is "alias-text"
+type EventAliasText struct {
+	Level LevelAlias `json:"level"`
+}
+
+// Stamp marshals itself as JSON. Its field would be reported were it walked into.
+type Stamp struct{ sec int64 }
+
+func (s Stamp) MarshalJSON() ([]byte, error) { return []byte("0"), nil }
+
+func (s *Stamp) UnmarshalJSON([]byte) error { return nil }
+
+type StampAlias = Stamp
+
+// EventAliasJSON is "alias-json"
+type EventAliasJSON struct {
+	At StampAlias `json:"at"`
+}
+
+// EventEscapedKey is "escaped-key"
+type EventEscapedKey struct {
+	Text string `json:"a<b"`
+}
+
+func (PageIndex) OnDirect(sse datapages.SSE, event EventDirect) error { return nil }
+
+func (PageIndex) OnEmpty(sse datapages.SSE, event EventEmpty) error { return nil }
+
+func (PageIndex) OnFloat(sse datapages.SSE, event EventFloat) error { return nil }
+
+func (PageIndex) OnTime(sse datapages.SSE, event EventTime) error { return nil }
+
+func (PageIndex) OnSlice(sse datapages.SSE, event EventSlice) error { return nil }
+
+func (PageIndex) OnStringOpt(sse datapages.SSE, event EventStringOpt) error { return nil }
+
+func (PageIndex) OnAliasText(sse datapages.SSE, event EventAliasText) error { return nil }
+
+func (PageIndex) OnAliasJSON(sse datapages.SSE, event EventAliasJSON) error { return nil }
+
+func (PageIndex) OnEscapedKey(sse datapages.SSE, event EventEscapedKey) error { return nil }
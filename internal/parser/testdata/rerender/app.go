@@ -0,0 +1,32 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/romshark/datapages"
+)
+
+type App struct{}
+
+// PageIndex is /
+type PageIndex struct{ App *App }
+
+func (PageIndex) GET(r *http.Request) (body datapages.Component, err error) {
+	return nil, nil
+}
+
+// EventPlaced is "placed"
+type EventPlaced struct{}
+
+// EventCanceled is "canceled"
+type EventCanceled struct{}
+
+func (PageIndex) OnPlaced(
+	event EventPlaced, sse datapages.SSE,
+) (rerender datapages.Rerender, err error) {
+	return true, nil
+}
+
+func (PageIndex) OnCanceled(event EventCanceled, sse datapages.SSE) error {
+	return nil
+}
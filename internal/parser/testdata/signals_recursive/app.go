@@ -0,0 +1,42 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/romshark/datapages"
+)
+
+type App struct{}
+
+// Node is a tree, its children are nodes again.
+type Node struct {
+	Label    string `json:"label"`
+	Children []Node `json:"children"`
+}
+
+// Item is a list, the next item is an item again.
+type Item struct {
+	Value string `json:"value"`
+	Next  *Item  `json:"next"`
+}
+
+// Chain embeds itself, which adds no fields.
+type Chain struct {
+	*Chain
+	Name string `json:"name"`
+}
+
+// PageIndex is /
+type PageIndex struct{ App *App }
+
+func (PageIndex) GET(
+	r *http.Request,
+	signals datapages.Signals[struct {
+		Tree  Node  `json:"tree"`
+		List  *Item `json:"list"`
+		Chain Chain `json:"chain"`
+	}],
+) (body datapages.Component, err error) {
+	_ = signals
+	return body, err
+}
@@ -0,0 +1,20 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/romshark/datapages"
+)
+
+type App struct{}
+
+// PageIndex is /
+type PageIndex struct{ App *App }
+
+func (PageIndex) GET(r *http.Request) (body datapages.Component, err error) {
+	return nil, nil
+}
+
+func (*App) StreamDrain(sse datapages.SSE) error {
+	return sse.PatchSignals(map[string]any{"restarting": true})
+}
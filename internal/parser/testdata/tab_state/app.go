@@ -0,0 +1,59 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/romshark/datapages"
+)
+
+type App struct{}
+
+// PageIndex is /
+type PageIndex struct{ App *App }
+
+func (PageIndex) GET(r *http.Request) (body datapages.Component, err error) {
+	return nil, nil
+}
+
+type Draft struct {
+	Lines []string `json:"lines"`
+}
+
+// PageEditor is /editor
+type PageEditor struct{ App *App }
+
+func (PageEditor) GET(
+	r *http.Request, tab datapages.TabState[Draft],
+) (body datapages.Component, err error) {
+	return nil, nil
+}
+
+// POSTAddLine is /editor/add-line
+func (PageEditor) POSTAddLine(
+	tab datapages.TabState[Draft], r *http.Request,
+) error {
+	return nil
+}
+
+// EventSaved is "saved"
+type EventSaved struct{}
+
+func (PageEditor) OnSaved(
+	sse datapages.SSE, tab datapages.TabState[Draft], event EventSaved,
+) error {
+	return nil
+}
+
+// PageCounter is /counter
+type PageCounter struct{ App *App }
+
+func (PageCounter) GET(r *http.Request) (body datapages.Component, err error) {
+	return nil, nil
+}
+
+// POSTIncrement is /counter/increment
+func (PageCounter) POSTIncrement(
+	r *http.Request, tab datapages.TabState[int],
+) error {
+	return nil
+}
@@ -0,0 +1,58 @@
+// Package app has a dashboard that receives a user-addressed,
+// signal-scoped event for every user and every signal value.
+package app
+
+import (
+	"net/http"
+
+	"github.com/romshark/datapages"
+)
+
+type Session = datapages.Session[struct{}]
+
+type App struct{}
+
+// EventRoomUpdate is "room.update"
+type EventRoomUpdate struct {
+	Recipient datapages.SubjectUser `json:"recipient"`
+	Room      datapages.Subject     `json:"room" signal:"room_id"`
+
+	Data string `json:"data"`
+}
+
+// PageIndex is /
+type PageIndex struct{ App *App }
+
+func (PageIndex) GET(r *http.Request, session Session) (
+	body datapages.Component, err error,
+) {
+	return nil, nil
+}
+
+func (PageIndex) OnRoomUpdate(event EventRoomUpdate, sse datapages.SSE) error {
+	return nil
+}
+
+// Admin is embedded by the dashboard.
+type Admin struct{ App *App }
+
+func (Admin) AuthorizeWildcard(r *http.Request, session Session) (bool, error) {
+	return session.UserID() == "admin", nil
+}
+
+// PageDashboard is /dashboard
+type PageDashboard struct {
+	App *App
+	Admin
+}
+
+func (PageDashboard) GET(r *http.Request) (body datapages.Component, err error) {
+	return nil, nil
+}
+
+// OnRoomUpdate receives the updates of every room.
+//
+//datapages:wildcard
+func (PageDashboard) OnRoomUpdate(event EventRoomUpdate, sse datapages.SSE) error {
+	return nil
+}
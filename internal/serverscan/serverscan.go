@@ -43,10 +43,11 @@ const ServerTypeName = "Server"
 // DisableSessions is the name of the type that means "no sessions".
 const DisableSessions = "DisableSessions"
 
-// EnablePrometheus and DisablePrometheus are the names of the datapages types
-// the Metrics type argument is written with.
+// EnablePrometheus, EnableOTelMetrics and DisablePrometheus are the names of
+// the datapages types the Metrics type argument is written with.
 const (
 	EnablePrometheus  = "EnablePrometheus"
+	EnableOTelMetrics = "EnableOTelMetrics"
 	DisablePrometheus = "DisablePrometheus"
 )
 
@@ -82,8 +83,8 @@ type Call struct {
 	// SessionData is the SessionData type argument.
 	SessionData TypeArg
 
-	// Metrics is the Metrics type argument, datapages.EnablePrometheus or
-	// datapages.DisablePrometheus.
+	// Metrics is the Metrics type argument, datapages.EnablePrometheus,
+	// datapages.EnableOTelMetrics or datapages.DisablePrometheus.
 	Metrics TypeArg
 
 	// Server is the S type argument, the generated server type.
@@ -99,6 +100,9 @@ type Call struct {
 
 	// Prometheus is true when Metrics is datapages.EnablePrometheus.
 	Prometheus bool
+
+	// OTelMetrics is true when Metrics is datapages.EnableOTelMetrics.
+	OTelMetrics bool
 }
 
 // App is one app package of the module, with the calls that build a server
@@ -135,6 +139,11 @@ type App struct {
 	// Prometheus reports that the calls name datapages.EnablePrometheus,
 	// which is what the metrics instrumentation is generated for.
 	Prometheus bool
+
+	// OTelMetrics reports that the calls name datapages.EnableOTelMetrics,
+	// which is what the instrumentation recording through OpenTelemetry
+	// is generated for.
+	OTelMetrics bool
 }
 
 // Result is what a module was found to contain.
@@ -189,6 +198,7 @@ func Scan(moduleDir, modulePath string) (Result, error) {
 				HasSession:  c.HasSession,
 				Metrics:     c.Metrics,
 				Prometheus:  c.Prometheus,
+				OTelMetrics: c.OTelMetrics,
 			}
 			byImport[c.App.Import] = a
 			order = append(order, c.App.Import)
@@ -498,6 +508,7 @@ func scanFile(
 			return true
 		}
 		c.Prometheus = c.Metrics.Name == EnablePrometheus
+		c.OTelMetrics = c.Metrics.Name == EnableOTelMetrics
 		c.SessionOpt = hasOption(call, f, pkgFiles, dp, "WithSessionManager")
 		calls = append(calls, c)
 		return true
@@ -505,18 +516,18 @@ func scanFile(
 	return calls
 }
 
-// checkMetricsArg holds the Metrics type argument to datapages.EnablePrometheus and
-// datapages.DisablePrometheus. The compiler rejects anything else,
-// the scan runs before it and says so first.
+// checkMetricsArg holds the Metrics type argument to datapages.EnablePrometheus,
+// datapages.EnableOTelMetrics and datapages.DisablePrometheus.
+// The compiler rejects anything else, the scan runs before it and says so first.
 func checkMetricsArg(m TypeArg, pos token.Position, errs *Errors) bool {
-	if m.Import == DatapagesImport &&
-		(m.Name == EnablePrometheus || m.Name == DisablePrometheus) {
+	if m.Import == DatapagesImport && (m.Name == EnablePrometheus ||
+		m.Name == EnableOTelMetrics || m.Name == DisablePrometheus) {
 		return true
 	}
 	errs.addf(pos,
 		"datapages.NewServer names Metrics %s, "+
-			"which is neither datapages.%s nor datapages.%s",
-		m.Src, EnablePrometheus, DisablePrometheus)
+			"which is none of datapages.%s, datapages.%s and datapages.%s",
+		m.Src, EnablePrometheus, EnableOTelMetrics, DisablePrometheus)
 	errs.hint("fix: use datapages." + DisablePrometheus + " to generate no metrics")
 	return false
 }
@@ -289,8 +289,9 @@ func main() {
 				"cmd/server/main.go": mainGo("app",
 					"app.App, datapages.DisableSessions, app.Metrics, gen.Server", ""),
 			},
-			msg: "names Metrics app.Metrics, which is neither " +
-				"datapages.EnablePrometheus nor datapages.DisablePrometheus",
+			msg: "names Metrics app.Metrics, which is none of " +
+				"datapages.EnablePrometheus, datapages.EnableOTelMetrics " +
+				"and datapages.DisablePrometheus",
 		},
 		"one app with two metrics modes": {
 			files: map[string]string{
@@ -531,3 +532,20 @@ func TestScanPrometheusPerApp(t *testing.T) {
 	require.False(t, front.Prometheus)
 	require.Equal(t, "datapages.DisablePrometheus", front.Metrics.Src)
 }
+
+// TestScanOTelMetrics covers the third metrics mode,
+// which selects the OpenTelemetry instrumentation and not the Prometheus one.
+func TestScanOTelMetrics(t *testing.T) {
+	root := write(t, map[string]string{
+		"app/app.go":                  "package app\n",
+		"app/datapagesgen/app_gen.go": genPkg(true),
+		"cmd/server/main.go": mainGo("app",
+			"app.App, datapages.DisableSessions, datapages.EnableOTelMetrics, gen.Server",
+			", datapages.WithMeterProvider(nil)"),
+	})
+	r, err := serverscan.Scan(root, modulePath)
+	require.NoError(t, err)
+	require.True(t, r.Apps[0].OTelMetrics)
+	require.False(t, r.Apps[0].Prometheus)
+	require.Equal(t, "datapages.EnableOTelMetrics", r.Apps[0].Metrics.Src)
+}
@@ -22,9 +22,18 @@ func JSONTagValue(tag string) string {
 	return name
 }
 
-// ReflectSignalTagValue extracts the value from a `reflectsignal:"value"` struct tag.
+// ReflectSignalTagValue extracts the signal name from a `reflectsignal:"value"`
+// struct tag, stripping the option like ",push".
 func ReflectSignalTagValue(tag string) string {
-	return reflect.StructTag(tag).Get("reflectsignal")
+	name, _, _ := strings.Cut(reflect.StructTag(tag).Get("reflectsignal"), ",")
+	return name
+}
+
+// ReflectSignalTagOption extracts the option from a
+// `reflectsignal:"value,option"` struct tag, empty without one.
+func ReflectSignalTagOption(tag string) string {
+	_, opt, _ := strings.Cut(reflect.StructTag(tag).Get("reflectsignal"), ",")
+	return opt
 }
 
 // PathTagValue extracts the value from a `path:"value"` struct tag.
@@ -43,10 +43,16 @@ func TestTagValues(t *testing.T) {
 		path          string
 		query         string
 		reflectSignal string
+		reflectOption string
 	}{
 		"path":           {tag: `path:"id"`, path: "id"},
 		"query":          {tag: `query:"q"`, query: "q"},
 		"reflect signal": {tag: `reflectsignal:"count"`, reflectSignal: "count"},
+		"reflect signal option": {
+			tag:           `reflectsignal:"count,push"`,
+			reflectSignal: "count",
+			reflectOption: "push",
+		},
 		"all at once": {
 			tag:           `path:"id" query:"q" reflectsignal:"count"`,
 			path:          "id",
@@ -66,6 +72,8 @@ func TestTagValues(t *testing.T) {
 			require.Equal(t, td.query, structtag.QueryTagValue(td.tag))
 			require.Equal(t, td.reflectSignal,
 				structtag.ReflectSignalTagValue(td.tag))
+			require.Equal(t, td.reflectOption,
+				structtag.ReflectSignalTagOption(td.tag))
 		})
 	}
 }
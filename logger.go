@@ -0,0 +1,29 @@
+package datapages
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/romshark/datapages/runtime/reqlog"
+)
+
+// Logger returns the logger of the request ctx belongs to. It writes to the
+// logger of [WithLogger] and carries the request ID, the handler and,
+// when known, the ID of the user and of the stream as attributes:
+//
+//	func (p PageCart) POSTCheckout(r *http.Request) error {
+//		datapages.Logger(r.Context()).Info("checking out")
+//		// ...
+//	}
+//
+// In an event handler, ctx is the one of its [SSE] parameter and the logger
+// carries origin_request_id too: the ID of the request that dispatched the
+// event, which ties the handler's lines to that request's own.
+//
+// Without a request it returns [slog.Default].
+func Logger(ctx context.Context) *slog.Logger {
+	if l := reqlog.Logger(ctx); l != nil {
+		return l
+	}
+	return slog.Default()
+}
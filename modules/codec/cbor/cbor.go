@@ -0,0 +1,23 @@
+// Package cbor provides an event codec that encodes with CBOR (RFC 8949),
+// which is more compact than JSON and cheaper to decode.
+//
+// Struct fields are named by their cbor tag, or by their json tag when
+// there's none, so event types need no extra tags.
+package cbor
+
+import (
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/romshark/datapages/modules/codec"
+)
+
+var _ codec.Codec = Codec{}
+
+// Codec encodes events with CBOR.
+type Codec struct{}
+
+// Marshal implements codec.Codec.
+func (Codec) Marshal(v any) ([]byte, error) { return cbor.Marshal(v) }
+
+// Unmarshal implements codec.Codec.
+func (Codec) Unmarshal(data []byte, v any) error { return cbor.Unmarshal(data, v) }
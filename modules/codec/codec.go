@@ -0,0 +1,36 @@
+// Package codec defines how events are encoded into message payloads
+// and decoded back, and provides the default codec, JSON.
+//
+// A codec is set with datapages.WithEventCodec. Every instance publishing to
+// or subscribing on the same broker must use the same one, a payload is
+// decoded with the codec of the receiving instance.
+//
+// The built-in alternatives live in the subpackages.
+package codec
+
+import "encoding/json"
+
+// Codec encodes events into message payloads and decodes them back.
+// It must be safe for concurrent use.
+type Codec interface {
+	// Marshal encodes v, a value of an event type.
+	Marshal(v any) ([]byte, error)
+
+	// Unmarshal decodes data into v, a pointer to a zero value of an event type.
+	Unmarshal(data []byte, v any) error
+}
+
+var _ Codec = JSON{}
+
+// JSON is the default codec, which encodes with encoding/json.
+//
+// The generated server encodes an event type whose fields are all strings,
+// booleans and integers without reflection and without calling Marshal.
+// The payload is the same JSON, so instances still agree on it.
+type JSON struct{}
+
+// Marshal implements Codec.
+func (JSON) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal implements Codec.
+func (JSON) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
@@ -0,0 +1,60 @@
+package codec_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages/modules/codec"
+	"github.com/romshark/datapages/modules/codec/cbor"
+	"github.com/romshark/datapages/modules/codec/msgpack"
+)
+
+type event struct {
+	Room    string            `json:"room"`
+	Count   int               `json:"count"`
+	Tags    []string          `json:"tags"`
+	Attrs   map[string]string `json:"attrs,omitempty"`
+	Private string            `json:"-"`
+}
+
+// renamed decodes what event encodes to if the codec keys fields
+// by their json tag.
+type renamed struct {
+	Name string `json:"room"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for name, c := range map[string]codec.Codec{
+		"json":    codec.JSON{},
+		"cbor":    cbor.Codec{},
+		"msgpack": msgpack.Codec{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			in := event{
+				Room:    "r1",
+				Count:   42,
+				Tags:    []string{"a", "b"},
+				Attrs:   map[string]string{"k": "v"},
+				Private: "dropped",
+			}
+			data, err := c.Marshal(in)
+			require.NoError(t, err)
+
+			var out event
+			require.NoError(t, c.Unmarshal(data, &out))
+			in.Private = ""
+			require.Equal(t, in, out)
+
+			var r renamed
+			require.NoError(t, c.Unmarshal(data, &r))
+			require.Equal(t, "r1", r.Name)
+
+			require.Error(t, c.Unmarshal([]byte{0xc1}, &out))
+		})
+	}
+}
@@ -0,0 +1,40 @@
+// Package msgpack provides an event codec that encodes with MessagePack,
+// which is more compact than JSON and cheaper to decode.
+//
+// Struct fields are named by their json tag, so event types need no extra tags.
+package msgpack
+
+import (
+	"bytes"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/romshark/datapages/modules/codec"
+)
+
+var _ codec.Codec = Codec{}
+
+// Codec encodes events with MessagePack.
+type Codec struct{}
+
+// Marshal implements codec.Codec.
+func (Codec) Marshal(v any) ([]byte, error) {
+	var b bytes.Buffer
+	enc := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(enc)
+	enc.Reset(&b)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Unmarshal implements codec.Codec.
+func (Codec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.GetDecoder()
+	defer msgpack.PutDecoder(dec)
+	dec.Reset(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
@@ -10,24 +10,34 @@ package inmem
 import (
 	"bytes"
 	"context"
-	"slices"
+	"maps"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/romshark/datapages/modules/messaging"
 )
 
-var _ messaging.Broker = (*MessageBroker)(nil)
+var (
+	_ messaging.Broker          = (*MessageBroker)(nil)
+	_ messaging.HeaderPublisher = (*MessageBroker)(nil)
+	_ messaging.HeaderScheduler = (*MessageBroker)(nil)
+	_ messaging.Scheduler       = (*MessageBroker)(nil)
+)
 
 // MessageBroker is an in-memory message broker.
 type MessageBroker struct {
 	chanBuffer int
 	lock       sync.RWMutex
-	// subs holds subscriptions by literal subject, which is what most of them are.
-	subs map[string]map[*memSub]struct{}
-	// wildcards holds the subscriptions whose subject carries "*" or ">".
-	// A publish walks these; there are few, and only patterns are here.
-	wildcards map[string]map[*memSub]struct{}
+	// subs indexes the subscriptions by the tokens of their subjects,
+	// patterns included, so a publish doesn't walk every pattern.
+	subs trie
+
+	timersLock sync.Mutex
+	// timers holds the scheduled publishes that haven't fired yet.
+	// Close stops them.
+	timers map[*time.Timer]struct{}
+	closed bool
 }
 
 type memSub struct {
@@ -47,12 +57,63 @@ func New(chanBuffer int) *MessageBroker {
 	}
 	return &MessageBroker{
 		chanBuffer: chanBuffer,
-		subs:       make(map[string]map[*memSub]struct{}),
-		wildcards:  make(map[string]map[*memSub]struct{}),
+		timers:     make(map[*time.Timer]struct{}),
 	}
 }
 
+// Close stops the scheduled publishes that haven't fired yet.
 func (b *MessageBroker) Close() error {
+	b.timersLock.Lock()
+	defer b.timersLock.Unlock()
+	b.closed = true
+	for tm := range b.timers {
+		tm.Stop()
+	}
+	clear(b.timers)
+	return nil
+}
+
+// Schedule implements messaging.Scheduler with a timer per message.
+// The schedule lives in memory, a restart loses what hasn't been published yet.
+// After Close the message is dropped without error, like a publish
+// without subscribers.
+func (b *MessageBroker) Schedule(
+	ctx context.Context,
+	metrics messaging.Metrics,
+	t time.Time,
+	subject string,
+	data []byte,
+) error {
+	return b.ScheduleMessage(ctx, metrics, t, messaging.Message{
+		Subject: subject,
+		Data:    data,
+	})
+}
+
+// ScheduleMessage implements messaging.HeaderScheduler like Schedule,
+// the message is published with its headers when it's due.
+func (b *MessageBroker) ScheduleMessage(
+	_ context.Context,
+	metrics messaging.Metrics,
+	t time.Time,
+	msg messaging.Message,
+) error {
+	msg.Data = bytes.Clone(msg.Data)
+	msg.Header = maps.Clone(msg.Header)
+
+	b.timersLock.Lock()
+	defer b.timersLock.Unlock()
+	if b.closed {
+		return nil
+	}
+	var tm *time.Timer
+	tm = time.AfterFunc(time.Until(t), func() {
+		b.timersLock.Lock()
+		delete(b.timers, tm)
+		b.timersLock.Unlock()
+		_ = b.PublishMessage(context.Background(), metrics, msg)
+	})
+	b.timers[tm] = struct{}{}
 	return nil
 }
 
@@ -61,36 +122,32 @@ func (b *MessageBroker) Publish(
 	metrics messaging.Metrics,
 	subject string,
 	data []byte,
+) error {
+	return b.PublishMessage(ctx, metrics, messaging.Message{
+		Subject: subject,
+		Data:    data,
+	})
+}
+
+// PublishMessage implements messaging.HeaderPublisher.
+// The subscriptions receive a copy of msg.Header, all of them the same one.
+func (b *MessageBroker) PublishMessage(
+	_ context.Context,
+	metrics messaging.Metrics,
+	msg messaging.Message,
 ) error {
 	b.lock.RLock()
 	defer b.lock.RUnlock()
 
-	var matched []*memSub
-	for sub := range b.subs[subject] {
-		matched = append(matched, sub)
-	}
-	for pattern, subs := range b.wildcards {
-		if !Matches(pattern, subject) {
-			continue
-		}
-		for sub := range subs {
-			// A subscription may hold several patterns that match the same
-			// subject. It receives the message once.
-			if !slices.Contains(matched, sub) {
-				matched = append(matched, sub)
-			}
-		}
-	}
+	matched := dedup(b.subs.match(msg.Subject, nil))
 
 	if len(matched) == 0 {
 		return nil
 	}
 
-	msg := messaging.Message{
-		Subject: subject,
-		Data:    bytes.Clone(data),
-	}
-	metrics.OnPublish(subject)
+	msg.Data = bytes.Clone(msg.Data)
+	msg.Header = maps.Clone(msg.Header)
+	metrics.OnPublish(msg.Subject)
 
 	for _, sub := range matched {
 		select {
@@ -133,9 +190,25 @@ func Matches(pattern, subject string) bool {
 	}
 }
 
-// isPattern reports whether a subject carries a wildcard.
-func isPattern(subject string) bool {
-	return strings.ContainsAny(subject, "*>")
+// dedup removes the repeated subscriptions from matched. A subscription
+// may hold several subjects that match the same one; it receives the message
+// once. Only those are tracked, the rest can't repeat.
+func dedup(matched []*memSub) []*memSub {
+	var seen map[*memSub]struct{}
+	out := matched[:0]
+	for _, sub := range matched {
+		if len(sub.topics) > 1 {
+			if _, ok := seen[sub]; ok {
+				continue
+			}
+			if seen == nil {
+				seen = make(map[*memSub]struct{})
+			}
+			seen[sub] = struct{}{}
+		}
+		out = append(out, sub)
+	}
+	return out
 }
 
 func (b *MessageBroker) Subscribe(
@@ -149,16 +222,7 @@ func (b *MessageBroker) Subscribe(
 
 	b.lock.Lock()
 	for _, subject := range subjects {
-		into := b.subs
-		if isPattern(subject) {
-			into = b.wildcards
-		}
-		m, ok := into[subject]
-		if !ok {
-			m = make(map[*memSub]struct{})
-			into[subject] = m
-		}
-		m[sub] = struct{}{}
+		b.subs.insert(subject, sub)
 	}
 	b.lock.Unlock()
 
@@ -181,16 +245,7 @@ func (s *memSub) Close() {
 	b := s.broker
 	b.lock.Lock()
 	for _, subject := range s.topics {
-		from := b.subs
-		if isPattern(subject) {
-			from = b.wildcards
-		}
-		if m, ok := from[subject]; ok {
-			delete(m, s)
-			if len(m) == 0 {
-				delete(from, subject)
-			}
-		}
+		b.subs.remove(subject, s)
 	}
 	b.lock.Unlock()
 
@@ -2,6 +2,7 @@ package inmem_test
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
@@ -17,34 +18,112 @@ type noMetrics struct{}
 func (noMetrics) OnPublish(string)   {}
 func (noMetrics) OnDeliveryDropped() {}
 
+// matchTests is shared by TestMatches and TestDeliveryMatches,
+// the broker delivers by the same rules.
+var matchTests = map[string]struct {
+	pattern string
+	subject string
+	want    bool
+}{
+	"literal":                   {"a.b", "a.b", true},
+	"literal mismatch":          {"a.b", "a.c", false},
+	"star matches one token":    {"a.*", "a.b", true},
+	"star matches any value":    {"a.*", "a.zzz", true},
+	"star is exactly one token": {"a.*", "a.b.c", false},
+	"star in the middle":        {"a.*.c", "a.b.c", true},
+	"star needs a token":        {"a.*", "a", false},
+	"gt matches the rest":       {"a.>", "a.b.c", true},
+	"gt matches one token":      {"a.>", "a.b", true},
+	"gt needs a token":          {"a.>", "a", false},
+	"pattern longer":            {"a.b.c", "a.b", false},
+	"subject longer":            {"a.b", "a.b.c", false},
+	"star and gt":               {"a.*.>", "a.b.c.d", true},
+}
+
 func TestMatches(t *testing.T) {
-	tests := map[string]struct {
-		pattern string
-		subject string
-		want    bool
-	}{
-		"literal":                   {"a.b", "a.b", true},
-		"literal mismatch":          {"a.b", "a.c", false},
-		"star matches one token":    {"a.*", "a.b", true},
-		"star matches any value":    {"a.*", "a.zzz", true},
-		"star is exactly one token": {"a.*", "a.b.c", false},
-		"star in the middle":        {"a.*.c", "a.b.c", true},
-		"star needs a token":        {"a.*", "a", false},
-		"gt matches the rest":       {"a.>", "a.b.c", true},
-		"gt matches one token":      {"a.>", "a.b", true},
-		"gt needs a token":          {"a.>", "a", false},
-		"pattern longer":            {"a.b.c", "a.b", false},
-		"subject longer":            {"a.b", "a.b.c", false},
-		"star and gt":               {"a.*.>", "a.b.c.d", true},
+	for name, tt := range matchTests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.want, inmem.Matches(tt.pattern, tt.subject))
+		})
 	}
+}
 
-	for name, tt := range tests {
+// TestDeliveryMatches covers the subscription index the broker delivers by,
+// which has to agree with Matches.
+func TestDeliveryMatches(t *testing.T) {
+	for name, tt := range matchTests {
 		t.Run(name, func(t *testing.T) {
-			require.Equal(t, tt.want, inmem.Matches(tt.pattern, tt.subject))
+			b := inmem.New(messaging.DefaultBrokerChanBuffer)
+			t.Cleanup(func() { require.NoError(t, b.Close()) })
+
+			ctx := context.Background()
+			sub, err := b.Subscribe(ctx, noMetrics{}, tt.pattern)
+			require.NoError(t, err)
+			t.Cleanup(sub.Close)
+
+			require.NoError(t, b.Publish(ctx, noMetrics{}, tt.subject, nil))
+			require.Equal(t, tt.want, len(sub.C()) == 1)
 		})
 	}
 }
 
+// TestClosePrunesSubscription covers a subscription that shares its subjects
+// with another. Closing it must leave the other one receiving.
+func TestClosePrunesSubscription(t *testing.T) {
+	b := inmem.New(messaging.DefaultBrokerChanBuffer)
+	t.Cleanup(func() { require.NoError(t, b.Close()) })
+
+	ctx := context.Background()
+	closed, err := b.Subscribe(ctx, noMetrics{}, "a.b.c", "a.*.c", "a.>")
+	require.NoError(t, err)
+	kept, err := b.Subscribe(ctx, noMetrics{}, "a.*.c")
+	require.NoError(t, err)
+	t.Cleanup(kept.Close)
+	closed.Close()
+
+	require.NoError(t, b.Publish(ctx, noMetrics{}, "a.b.c", nil))
+	require.Len(t, kept.C(), 1)
+	_, ok := <-closed.C()
+	require.False(t, ok, "the closed subscription received a message")
+}
+
+// BenchmarkPublish publishes to one subscription next to n others that don't
+// match. The cost stays flat as n grows, whether the others subscribe to
+// literal subjects or to patterns.
+func BenchmarkPublish(b *testing.B) {
+	for _, n := range []int{100, 1_000, 10_000} {
+		for _, kind := range []string{"literal", "pattern"} {
+			b.Run(fmt.Sprintf("%s/%d", kind, n), func(b *testing.B) {
+				br := inmem.New(1)
+				b.Cleanup(func() { _ = br.Close() })
+
+				ctx := context.Background()
+				for i := range n {
+					subject := fmt.Sprintf("topic%d.room.said", i)
+					if kind == "pattern" {
+						subject = fmt.Sprintf("topic%d.*.said", i)
+					}
+					sub, err := br.Subscribe(ctx, noMetrics{}, subject)
+					if err != nil {
+						b.Fatal(err)
+					}
+					b.Cleanup(sub.Close)
+				}
+				sub, err := br.Subscribe(ctx, noMetrics{}, "chat.*.said")
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.Cleanup(sub.Close)
+
+				for b.Loop() {
+					_ = br.Publish(ctx, noMetrics{}, "chat.r1.said", nil)
+					<-sub.C()
+				}
+			})
+		}
+	}
+}
+
 // countingMetrics records how often delivery dropped a message.
 type countingMetrics struct{ dropped int }
 
@@ -148,3 +227,79 @@ func TestOneMessagePerSubscription(t *testing.T) {
 	case <-time.After(100 * time.Millisecond):
 	}
 }
+
+func TestSchedule(t *testing.T) {
+	b := inmem.New(messaging.DefaultBrokerChanBuffer)
+	t.Cleanup(func() { require.NoError(t, b.Close()) })
+
+	ctx := context.Background()
+	sub, err := b.Subscribe(ctx, noMetrics{}, "reminder")
+	require.NoError(t, err)
+	t.Cleanup(sub.Close)
+
+	start := time.Now()
+	require.NoError(t, b.Schedule(ctx, noMetrics{},
+		start.Add(50*time.Millisecond), "reminder", []byte("late")))
+	require.NoError(t, b.Schedule(ctx, noMetrics{},
+		start.Add(-time.Hour), "reminder", []byte("due")))
+
+	// A time in the past publishes right away.
+	msg := <-sub.C()
+	require.Equal(t, "due", string(msg.Data))
+
+	msg = <-sub.C()
+	require.Equal(t, "late", string(msg.Data))
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestCloseStopsSchedule(t *testing.T) {
+	b := inmem.New(messaging.DefaultBrokerChanBuffer)
+
+	ctx := context.Background()
+	sub, err := b.Subscribe(ctx, noMetrics{}, "reminder")
+	require.NoError(t, err)
+	t.Cleanup(sub.Close)
+
+	require.NoError(t, b.Schedule(ctx, noMetrics{},
+		time.Now().Add(50*time.Millisecond), "reminder", nil))
+	require.NoError(t, b.Close())
+
+	select {
+	case msg := <-sub.C():
+		t.Fatalf("a message scheduled before Close arrived: %s", msg.Subject)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPublishMessageHeader(t *testing.T) {
+	b := inmem.New(messaging.DefaultBrokerChanBuffer)
+	t.Cleanup(func() { require.NoError(t, b.Close()) })
+
+	ctx := context.Background()
+	sub, err := b.Subscribe(ctx, noMetrics{}, "note.*")
+	require.NoError(t, err)
+	t.Cleanup(sub.Close)
+
+	h := messaging.Header{"trace": "abc"}
+	require.NoError(t, b.PublishMessage(ctx, noMetrics{}, messaging.Message{
+		Subject: "note.one", Data: []byte("x"), Header: h,
+	}))
+	h["trace"] = "changed after publishing"
+
+	select {
+	case msg := <-sub.C():
+		require.Equal(t, "x", string(msg.Data))
+		require.Equal(t, messaging.Header{"trace": "abc"}, msg.Header)
+	case <-time.After(time.Second):
+		t.Fatal("no message arrived")
+	}
+
+	// A plain publish carries none.
+	require.NoError(t, b.Publish(ctx, noMetrics{}, "note.two", nil))
+	select {
+	case msg := <-sub.C():
+		require.Nil(t, msg.Header)
+	case <-time.After(time.Second):
+		t.Fatal("no message arrived")
+	}
+}
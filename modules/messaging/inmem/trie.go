@@ -0,0 +1,126 @@
+package inmem
+
+import "strings"
+
+// trie indexes subscriptions by the tokens of their subjects. A publish walks
+// it token by token, so finding the subscriptions of a subject costs
+// O(subject depth) for each "*" branch on the way, however many subscriptions
+// there are. Literal subjects and patterns live in the same trie.
+type trie struct {
+	root node
+}
+
+// node is a token of a subscribed subject.
+type node struct {
+	// literal holds the next tokens by value.
+	literal map[string]*node
+	// star is the next token when it's "*".
+	star *node
+	// subs holds the subscriptions whose subject ends here.
+	subs map[*memSub]struct{}
+	// rest holds the subscriptions whose subject ends here with ">".
+	rest map[*memSub]struct{}
+}
+
+// insert adds s under subject.
+func (t *trie) insert(subject string, s *memSub) {
+	n := &t.root
+	for {
+		tok, more, ok := strings.Cut(subject, ".")
+		if tok == ">" {
+			if n.rest == nil {
+				n.rest = make(map[*memSub]struct{})
+			}
+			n.rest[s] = struct{}{}
+			return
+		}
+		n = n.child(tok)
+		if !ok {
+			break
+		}
+		subject = more
+	}
+	if n.subs == nil {
+		n.subs = make(map[*memSub]struct{})
+	}
+	n.subs[s] = struct{}{}
+}
+
+// child returns the node of tok below n, adding it if it doesn't exist.
+func (n *node) child(tok string) *node {
+	if tok == "*" {
+		if n.star == nil {
+			n.star = new(node)
+		}
+		return n.star
+	}
+	c := n.literal[tok]
+	if c == nil {
+		if n.literal == nil {
+			n.literal = make(map[string]*node)
+		}
+		c = new(node)
+		n.literal[tok] = c
+	}
+	return c
+}
+
+// remove removes s from under subject and prunes the nodes it leaves empty.
+func (t *trie) remove(subject string, s *memSub) {
+	t.root.remove(subject, s)
+}
+
+// remove removes s from under the rest of subject below n
+// and reports whether n is left empty.
+func (n *node) remove(subject string, s *memSub) bool {
+	tok, more, ok := strings.Cut(subject, ".")
+	switch {
+	case tok == ">":
+		delete(n.rest, s)
+	case tok == "*":
+		if n.star != nil && n.descend(n.star, more, ok, s) {
+			n.star = nil
+		}
+	default:
+		if c := n.literal[tok]; c != nil && n.descend(c, more, ok, s) {
+			delete(n.literal, tok)
+		}
+	}
+	return len(n.literal) == 0 && n.star == nil && len(n.subs) == 0 && len(n.rest) == 0
+}
+
+// descend removes s from c, the node of the current token,
+// and reports whether c is left empty.
+func (n *node) descend(c *node, more string, ok bool, s *memSub) bool {
+	if ok {
+		return c.remove(more, s)
+	}
+	delete(c.subs, s)
+	return len(c.literal) == 0 && c.star == nil && len(c.subs) == 0 && len(c.rest) == 0
+}
+
+// match appends the subscriptions subject matches to into. A subscription
+// appears once for every one of its subjects that matches.
+func (t *trie) match(subject string, into []*memSub) []*memSub {
+	return t.root.match(subject, into)
+}
+
+func (n *node) match(subject string, into []*memSub) []*memSub {
+	// ">" matches the rest, of which there is at least one token.
+	for s := range n.rest {
+		into = append(into, s)
+	}
+	tok, more, ok := strings.Cut(subject, ".")
+	for _, c := range [2]*node{n.literal[tok], n.star} {
+		switch {
+		case c == nil:
+		case ok:
+			into = c.match(more, into)
+		default:
+			for s := range c.subs {
+				into = append(into, s)
+			}
+		}
+	}
+	return into
+}
@@ -0,0 +1,275 @@
+package messaging
+
+import (
+	"context"
+	"time"
+)
+
+// PublishFunc passes a message on to the next interceptor,
+// or to the broker after the last one.
+type PublishFunc func(ctx context.Context, msg Message) error
+
+// SubscribeFunc passes a subscription on to the next interceptor,
+// or to the broker after the last one.
+type SubscribeFunc func(ctx context.Context, subjects []string) (Subscription, error)
+
+// Interceptor wraps the calls to a broker for logging, tracing, tenant checks
+// or fault injection without touching the broker implementation.
+// Every field is optional, a nil one leaves its calls as they are.
+// [Intercept] installs a chain of them.
+//
+// Interceptors see subjects as they go on the wire,
+// the subject prefix of the server included.
+type Interceptor struct {
+	// Publish wraps every publish, scheduled ones included. It may change msg,
+	// add headers for example, before it passes it on to next,
+	// or return an error without calling next to refuse the publish.
+	Publish func(ctx context.Context, msg Message, next PublishFunc) error
+
+	// Subscribe wraps every subscribe. It may change the subjects before it
+	// passes them on to next, or return an error without calling next
+	// to refuse the subscription. [SubscribeMetrics] returns the metrics
+	// of the subscribe from ctx.
+	Subscribe func(
+		ctx context.Context, subjects []string, next SubscribeFunc,
+	) (Subscription, error)
+
+	// Deliver sees every message a subscription delivers before the stream
+	// does. ctx is the one the subscription was made with.
+	// It returns the message to deliver, or false to drop it.
+	Deliver func(ctx context.Context, msg Message) (Message, bool)
+}
+
+// Intercept returns b with chain installed, or b itself when chain is empty.
+// The first interceptor is the outermost: its Publish and Subscribe run first
+// and its Deliver sees a message first.
+//
+// The returned broker implements [Scheduler] and [HeaderScheduler] when b
+// implements Scheduler. A scheduled message goes through the Publish
+// interceptors when it's scheduled, not when it's published. Its headers
+// reach b through [ScheduleMessage], which drops them when b doesn't
+// implement HeaderScheduler.
+// InitStreams, Status and Ping are passed on to b when b implements
+// [StreamInitializer], [StatusNotifier] and [Pinger].
+func Intercept(b Broker, chain ...Interceptor) Broker {
+	if len(chain) == 0 {
+		return b
+	}
+	i := &intercepted{broker: b, chain: chain}
+	if s, ok := b.(Scheduler); ok {
+		return &interceptedScheduler{intercepted: i, scheduler: s}
+	}
+	return i
+}
+
+// InterceptPublisher returns p with the Publish interceptors of chain
+// installed, or p itself when chain is empty. It's [Intercept] for
+// a publisher that isn't a broker, one writing into a transaction for example.
+func InterceptPublisher(p Publisher, chain ...Interceptor) Publisher {
+	if len(chain) == 0 {
+		return p
+	}
+	return &interceptedPublisher{publisher: p, chain: chain}
+}
+
+var (
+	_ HeaderPublisher   = (*interceptedPublisher)(nil)
+	_ HeaderPublisher   = (*intercepted)(nil)
+	_ StatusNotifier    = (*intercepted)(nil)
+	_ Pinger            = (*intercepted)(nil)
+	_ StreamInitializer = (*intercepted)(nil)
+	_ Scheduler         = (*interceptedScheduler)(nil)
+	_ HeaderScheduler   = (*interceptedScheduler)(nil)
+)
+
+type intercepted struct {
+	broker Broker
+	chain  []Interceptor
+}
+
+// interceptedScheduler is what Intercept returns for a broker
+// that implements Scheduler.
+type interceptedScheduler struct {
+	*intercepted
+	scheduler Scheduler
+}
+
+// Unwrap returns the broker the interceptors are installed on.
+func (b *intercepted) Unwrap() Broker { return b.broker }
+
+// Status implements StatusNotifier, b counts as connected
+// when it doesn't implement it.
+func (b *intercepted) Status() (up bool, changed <-chan struct{}) {
+	return StatusOf(b.broker)
+}
+
+// Ping implements Pinger, see [Ping] for a broker that doesn't implement it.
+func (b *intercepted) Ping(ctx context.Context) error {
+	return Ping(ctx, b.broker)
+}
+
+// InitStreams implements StreamInitializer.
+func (b *intercepted) InitStreams(subjects []string) error {
+	if si, ok := b.broker.(StreamInitializer); ok {
+		return si.InitStreams(subjects)
+	}
+	return nil
+}
+
+func (b *intercepted) Publish(
+	ctx context.Context, metrics Metrics, subject string, data []byte,
+) error {
+	return b.PublishMessage(ctx, metrics, Message{Subject: subject, Data: data})
+}
+
+// PublishMessage implements HeaderPublisher.
+func (b *intercepted) PublishMessage(
+	ctx context.Context, metrics Metrics, msg Message,
+) error {
+	return b.publish(ctx, msg, func(ctx context.Context, msg Message) error {
+		return PublishMessage(ctx, b.broker, metrics, msg)
+	})
+}
+
+// Schedule implements Scheduler.
+func (b *interceptedScheduler) Schedule(
+	ctx context.Context, metrics Metrics, t time.Time, subject string, data []byte,
+) error {
+	return b.ScheduleMessage(ctx, metrics, t, Message{Subject: subject, Data: data})
+}
+
+// ScheduleMessage implements HeaderScheduler.
+func (b *interceptedScheduler) ScheduleMessage(
+	ctx context.Context, metrics Metrics, t time.Time, msg Message,
+) error {
+	return b.publish(ctx, msg, func(ctx context.Context, msg Message) error {
+		return ScheduleMessage(ctx, b.scheduler, metrics, t, msg)
+	})
+}
+
+// publish passes msg through the Publish interceptors on to last.
+func (b *intercepted) publish(ctx context.Context, msg Message, last PublishFunc) error {
+	return publishThrough(ctx, b.chain, msg, last)
+}
+
+// publishThrough passes msg through the Publish interceptors of chain
+// on to last.
+func publishThrough(
+	ctx context.Context, chain []Interceptor, msg Message, last PublishFunc,
+) error {
+	next := last
+	for i := len(chain) - 1; i >= 0; i-- {
+		if p := chain[i].Publish; p != nil {
+			n := next
+			next = func(ctx context.Context, msg Message) error { return p(ctx, msg, n) }
+		}
+	}
+	return next(ctx, msg)
+}
+
+// interceptedPublisher is what InterceptPublisher returns.
+type interceptedPublisher struct {
+	publisher Publisher
+	chain     []Interceptor
+}
+
+func (p *interceptedPublisher) Publish(
+	ctx context.Context, metrics Metrics, subject string, data []byte,
+) error {
+	return p.PublishMessage(ctx, metrics, Message{Subject: subject, Data: data})
+}
+
+// PublishMessage implements HeaderPublisher.
+func (p *interceptedPublisher) PublishMessage(
+	ctx context.Context, metrics Metrics, msg Message,
+) error {
+	return publishThrough(ctx, p.chain, msg, func(ctx context.Context, msg Message) error {
+		return PublishMessage(ctx, p.publisher, metrics, msg)
+	})
+}
+
+func (b *intercepted) Subscribe(
+	ctx context.Context, metrics Metrics, subjects ...string,
+) (Subscription, error) {
+	next := SubscribeFunc(func(
+		ctx context.Context, subjects []string,
+	) (Subscription, error) {
+		return b.broker.Subscribe(ctx, metrics, subjects...)
+	})
+	for i := len(b.chain) - 1; i >= 0; i-- {
+		if s := b.chain[i].Subscribe; s != nil {
+			n := next
+			next = func(ctx context.Context, subjects []string) (Subscription, error) {
+				return s(ctx, subjects, n)
+			}
+		}
+	}
+	sub, err := next(context.WithValue(ctx, metricsKey{}, metrics), subjects)
+	if err != nil {
+		return nil, err
+	}
+
+	var deliver []func(context.Context, Message) (Message, bool)
+	for _, ic := range b.chain {
+		if ic.Deliver != nil {
+			deliver = append(deliver, ic.Deliver)
+		}
+	}
+	if len(deliver) == 0 {
+		return sub, nil
+	}
+	in := sub.C()
+	s := &interceptedSub{
+		SubscriptionCloser: sub,
+		ch:                 make(chan Message, cap(in)),
+	}
+	go s.run(ctx, metrics, in, deliver)
+	return s, nil
+}
+
+type metricsKey struct{}
+
+// SubscribeMetrics returns the metrics of the subscribe the Subscribe of
+// an [Interceptor] was called for with ctx. An interceptor delivering the
+// messages of a subscription on its own reports the ones it drops to them.
+// It returns nil for any other ctx.
+func SubscribeMetrics(ctx context.Context) Metrics {
+	m, _ := ctx.Value(metricsKey{}).(Metrics)
+	return m
+}
+
+// interceptedSub passes what its subscription delivers
+// through the Deliver interceptors.
+type interceptedSub struct {
+	SubscriptionCloser
+	ch chan Message
+}
+
+func (s *interceptedSub) C() <-chan Message { return s.ch }
+
+// run delivers what arrives on in until the subscription is closed,
+// which closes in, and then closes s.ch.
+func (s *interceptedSub) run(
+	ctx context.Context,
+	metrics Metrics,
+	in <-chan Message,
+	deliver []func(context.Context, Message) (Message, bool),
+) {
+	defer close(s.ch)
+	for msg := range in {
+		ok := true
+		for _, d := range deliver {
+			if msg, ok = d(ctx, msg); !ok {
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		select {
+		case s.ch <- msg:
+		default: // Drop if the subscriber is slow, like the broker would.
+			metrics.OnDeliveryDropped()
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package messaging_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages/modules/messaging"
+	"github.com/romshark/datapages/modules/messaging/inmem"
+)
+
+type noMetrics struct{}
+
+func (noMetrics) OnPublish(string)   {}
+func (noMetrics) OnDeliveryDropped() {}
+
+func newBroker(t *testing.T) *inmem.MessageBroker {
+	t.Helper()
+	b := inmem.New(messaging.DefaultBrokerChanBuffer)
+	t.Cleanup(func() { require.NoError(t, b.Close()) })
+	return b
+}
+
+func subscribe(
+	t *testing.T, b messaging.Broker, subjects ...string,
+) messaging.Subscription {
+	t.Helper()
+	sub, err := b.Subscribe(context.Background(), noMetrics{}, subjects...)
+	require.NoError(t, err)
+	t.Cleanup(sub.Close)
+	return sub
+}
+
+func receive(t *testing.T, sub messaging.Subscription) messaging.Message {
+	t.Helper()
+	select {
+	case msg, ok := <-sub.C():
+		require.True(t, ok, "the subscription channel was closed")
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message arrived")
+		return messaging.Message{}
+	}
+}
+
+func requireNothing(t *testing.T, sub messaging.Subscription) {
+	t.Helper()
+	select {
+	case msg := <-sub.C():
+		t.Fatalf("unexpected message on %q", msg.Subject)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// setHeader returns an interceptor that sets key to value on every publish
+// and records the order it ran in.
+func setHeader(key, value string, order *[]string) messaging.Interceptor {
+	return messaging.Interceptor{
+		Publish: func(
+			ctx context.Context, msg messaging.Message, next messaging.PublishFunc,
+		) error {
+			*order = append(*order, key)
+			h := messaging.Header{key: value}
+			for k, v := range msg.Header {
+				h[k] = v
+			}
+			msg.Header = h
+			return next(ctx, msg)
+		},
+	}
+}
+
+func TestInterceptEmptyChain(t *testing.T) {
+	b := newBroker(t)
+	require.Same(t, b, messaging.Intercept(b))
+}
+
+func TestInterceptPublish(t *testing.T) {
+	var order []string
+	b := messaging.Intercept(newBroker(t),
+		setHeader("first", "1", &order),
+		messaging.Interceptor{}, // Leaves every call as it is.
+		setHeader("second", "2", &order),
+	)
+	sub := subscribe(t, b, "room.*")
+
+	require.NoError(t, b.Publish(context.Background(), noMetrics{}, "room.a", []byte("x")))
+
+	msg := receive(t, sub)
+	require.Equal(t, "room.a", msg.Subject)
+	require.Equal(t, "x", string(msg.Data))
+	require.Equal(t, messaging.Header{"first": "1", "second": "2"}, msg.Header)
+	require.Equal(t, []string{"first", "second"}, order)
+}
+
+func TestInterceptPublisher(t *testing.T) {
+	b := newBroker(t)
+	require.Same(t, messaging.Publisher(b), messaging.InterceptPublisher(b))
+
+	var order []string
+	p := messaging.InterceptPublisher(b,
+		setHeader("first", "1", &order),
+		setHeader("second", "2", &order),
+	)
+	sub := subscribe(t, b, "room.a")
+
+	require.NoError(t, p.Publish(context.Background(), noMetrics{}, "room.a", []byte("x")))
+
+	msg := receive(t, sub)
+	require.Equal(t, "x", string(msg.Data))
+	require.Equal(t, messaging.Header{"first": "1", "second": "2"}, msg.Header)
+	require.Equal(t, []string{"first", "second"}, order)
+}
+
+func TestInterceptRefusePublish(t *testing.T) {
+	errTenant := errors.New("wrong tenant")
+	b := messaging.Intercept(newBroker(t), messaging.Interceptor{
+		Publish: func(
+			ctx context.Context, msg messaging.Message, next messaging.PublishFunc,
+		) error {
+			if msg.Subject == "forbidden" {
+				return errTenant
+			}
+			return next(ctx, msg)
+		},
+	})
+	sub := subscribe(t, b, "forbidden", "allowed")
+
+	err := b.Publish(context.Background(), noMetrics{}, "forbidden", nil)
+	require.ErrorIs(t, err, errTenant)
+	requireNothing(t, sub)
+
+	require.NoError(t, b.Publish(context.Background(), noMetrics{}, "allowed", nil))
+	require.Equal(t, "allowed", receive(t, sub).Subject)
+}
+
+func TestInterceptSubscribe(t *testing.T) {
+	b := messaging.Intercept(newBroker(t), messaging.Interceptor{
+		Subscribe: func(
+			ctx context.Context, subjects []string, next messaging.SubscribeFunc,
+		) (messaging.Subscription, error) {
+			require.Equal(t, noMetrics{}, messaging.SubscribeMetrics(ctx))
+			return next(ctx, append(subjects, "extra"))
+		},
+	})
+	sub := subscribe(t, b, "asked")
+	require.Nil(t, messaging.SubscribeMetrics(context.Background()))
+
+	require.NoError(t, b.Publish(context.Background(), noMetrics{}, "extra", nil))
+	require.Equal(t, "extra", receive(t, sub).Subject)
+}
+
+func TestInterceptDeliver(t *testing.T) {
+	type ctxKey struct{}
+	var (
+		order  []string
+		gotCtx any
+	)
+	b := messaging.Intercept(newBroker(t),
+		messaging.Interceptor{
+			Deliver: func(
+				ctx context.Context, msg messaging.Message,
+			) (messaging.Message, bool) {
+				order = append(order, "first")
+				gotCtx = ctx.Value(ctxKey{})
+				return msg, msg.Subject != "dropped"
+			},
+		},
+		messaging.Interceptor{
+			Deliver: func(
+				_ context.Context, msg messaging.Message,
+			) (messaging.Message, bool) {
+				order = append(order, "second")
+				msg.Data = append([]byte("seen:"), msg.Data...)
+				return msg, true
+			},
+		},
+	)
+	ctx := context.WithValue(context.Background(), ctxKey{}, "stream")
+	sub, err := b.Subscribe(ctx, noMetrics{}, "dropped", "kept")
+	require.NoError(t, err)
+
+	require.NoError(t, b.Publish(ctx, noMetrics{}, "dropped", []byte("a")))
+	require.NoError(t, b.Publish(ctx, noMetrics{}, "kept", []byte("b")))
+
+	msg := receive(t, sub)
+	require.Equal(t, "kept", msg.Subject)
+	require.Equal(t, "seen:b", string(msg.Data))
+	require.Equal(t, []string{"first", "first", "second"}, order)
+	require.Equal(t, "stream", gotCtx, "Deliver didn't get the subscribe context")
+
+	sub.Close()
+	select {
+	case _, ok := <-sub.C():
+		require.False(t, ok, "a message arrived after Close")
+	case <-time.After(time.Second):
+		t.Fatal("Close didn't close the channel")
+	}
+}
+
+func TestInterceptSchedule(t *testing.T) {
+	var order []string
+	b := messaging.Intercept(newBroker(t), setHeader("k", "v", &order))
+	s, ok := b.(messaging.Scheduler)
+	require.True(t, ok, "the intercepted broker lost messaging.Scheduler")
+	sub := subscribe(t, b, "later")
+
+	require.NoError(t, s.Schedule(
+		context.Background(), noMetrics{}, time.Now(), "later", []byte("x"),
+	))
+	require.Equal(t, []string{"k"}, order, "scheduling didn't run the interceptor")
+
+	// The header the interceptor set goes out when the message is due.
+	msg := receive(t, sub)
+	require.Equal(t, "x", string(msg.Data))
+	require.Equal(t, messaging.Header{"k": "v"}, msg.Header)
+}
+
+// publishOnly is a broker that implements nothing optional.
+type publishOnly struct{ messaging.Broker }
+
+func TestInterceptWithoutScheduler(t *testing.T) {
+	b := messaging.Intercept(publishOnly{newBroker(t)}, messaging.Interceptor{})
+	_, ok := b.(messaging.Scheduler)
+	require.False(t, ok)
+
+	// Headers are dropped on a publisher that doesn't take them.
+	sub := subscribe(t, b, "plain")
+	require.NoError(t, messaging.PublishMessage(
+		context.Background(), b, noMetrics{}, messaging.Message{
+			Subject: "plain", Header: messaging.Header{"k": "v"},
+		},
+	))
+	require.Nil(t, receive(t, sub).Header)
+}
@@ -5,7 +5,11 @@
 // The built-in implementations live in the subpackages.
 package messaging
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
 // DefaultBrokerChanBuffer allows to decouple publisher/NATS callback from the consumer.
 // Buffer size should be enough to absorb short bursts without blocking delivery,
@@ -56,6 +60,45 @@ type StreamInitializer interface {
 	InitStreams(subjects []string) error
 }
 
+// Scheduler is an optional interface that message brokers can implement to
+// publish a message at a later time. It backs the DispatchAt and DispatchAfter
+// methods of the generated dispatchers, which return ErrNoScheduler
+// on a broker that doesn't implement it.
+type Scheduler interface {
+	// Schedule publishes data to subject at t, or right away when t isn't in
+	// the future. A nil error means the message is scheduled, not published.
+	// Whether a schedule survives a restart is up to the implementation.
+	//
+	// ctx carries cancelation and a deadline of the scheduling, not of the
+	// publish, which happens after ctx may be long gone.
+	Schedule(
+		ctx context.Context, metrics Metrics, t time.Time, subject string, data []byte,
+	) error
+}
+
+// HeaderScheduler is an optional interface that schedulers implement
+// to publish a message's headers along with its data when it's due.
+// [ScheduleMessage] drops the headers on a scheduler that doesn't.
+type HeaderScheduler interface {
+	// ScheduleMessage schedules msg like Schedule, headers included.
+	ScheduleMessage(ctx context.Context, metrics Metrics, t time.Time, msg Message) error
+}
+
+// ScheduleMessage schedules msg on s with its headers when s implements
+// HeaderScheduler, and without them when it doesn't.
+func ScheduleMessage(
+	ctx context.Context, s Scheduler, metrics Metrics, t time.Time, msg Message,
+) error {
+	if hs, ok := s.(HeaderScheduler); ok {
+		return hs.ScheduleMessage(ctx, metrics, t, msg)
+	}
+	return s.Schedule(ctx, metrics, t, msg.Subject, msg.Data)
+}
+
+// ErrNoScheduler is returned when a message is scheduled on a broker
+// that doesn't implement Scheduler.
+var ErrNoScheduler = errors.New("message broker doesn't implement messaging.Scheduler")
+
 // SubscriptionReader reads messages from an active subscription.
 type SubscriptionReader interface {
 	// C returns the channel to receive messages.
@@ -78,4 +121,32 @@ type Subscription interface {
 type Message struct {
 	Subject string
 	Data    []byte
+
+	// Header is the metadata published along with Data, such as trace context,
+	// the ID of the publisher or when it was published. It's nil when there's
+	// none. A message delivered to several subscriptions shares it with all of
+	// them, treat it as read-only.
+	Header Header
+}
+
+// Header holds the metadata of a [Message] by key.
+type Header map[string]string
+
+// HeaderPublisher is an optional interface that publishers implement
+// to send a message's headers along with its data.
+// [PublishMessage] drops the headers on a publisher that doesn't.
+type HeaderPublisher interface {
+	// PublishMessage sends msg like Publish, headers included.
+	PublishMessage(ctx context.Context, metrics Metrics, msg Message) error
+}
+
+// PublishMessage publishes msg to p with its headers when p implements
+// HeaderPublisher, and without them when it doesn't.
+func PublishMessage(
+	ctx context.Context, p Publisher, metrics Metrics, msg Message,
+) error {
+	if hp, ok := p.(HeaderPublisher); ok {
+		return hp.PublishMessage(ctx, metrics, msg)
+	}
+	return p.Publish(ctx, metrics, msg.Subject, msg.Data)
 }
@@ -5,11 +5,15 @@
 // connected when it's published and there's no replay. Datapages events drive
 // live UI updates, a lost one means a stale UI until the next render, which is
 // why the durability and the ack round trip of JetStream buy nothing here.
+//
+// [ScheduledBroker] adds scheduled publishing. Its schedule does outlive the
+// process, it's kept in a JetStream KV bucket.
 package natscore
 
 import (
 	"bytes"
 	"context"
+	"strings"
 	"sync"
 
 	"github.com/nats-io/nats.go"
@@ -17,17 +21,39 @@ import (
 	"github.com/romshark/datapages/modules/messaging"
 )
 
-var _ messaging.Broker = (*MessageBroker)(nil)
+var (
+	_ messaging.Broker          = (*MessageBroker)(nil)
+	_ messaging.HeaderPublisher = (*MessageBroker)(nil)
+	_ messaging.StatusNotifier  = (*MessageBroker)(nil)
+	_ messaging.Pinger          = (*MessageBroker)(nil)
+)
 
 type MessageBroker struct {
 	nc   *nats.Conn
 	conf Config
+	// prefix is what every subject starts with on the wire:
+	// conf.SubjectPrefix and the separator, or nothing.
+	prefix string
+
+	// status follows the connection once the first Status call
+	// started watching it.
+	status      messaging.ConnStatus
+	watchStatus sync.Once
 }
 
 type Config struct {
 	// ChanBuffer is how many messages a subscription buffers.
 	// Non-positive selects messaging.DefaultBrokerChanBuffer.
 	ChanBuffer int
+
+	// SubjectPrefix places every subject under "<SubjectPrefix>." on the wire,
+	// which keeps apps and environments sharing a NATS cluster from receiving
+	// each other's events. It's one or more subject tokens joined by dots,
+	// "staging" or "shop.staging" for example. Empty selects no prefix.
+	//
+	// The broker adds the prefix to what it publishes and subscribes to and
+	// strips it from what it delivers, the server and its metrics never see it.
+	SubjectPrefix string
 }
 
 type natsSub struct {
@@ -40,7 +66,11 @@ func New(nc *nats.Conn, conf Config) *MessageBroker {
 	if conf.ChanBuffer <= 0 {
 		conf.ChanBuffer = messaging.DefaultBrokerChanBuffer
 	}
-	return &MessageBroker{nc: nc, conf: conf}
+	b := &MessageBroker{nc: nc, conf: conf}
+	if conf.SubjectPrefix != "" {
+		b.prefix = conf.SubjectPrefix + "."
+	}
+	return b
 }
 
 // Publish implements messaging.Broker.
@@ -53,13 +83,89 @@ func (b *MessageBroker) Publish(
 	subject string,
 	data []byte,
 ) error {
-	if err := b.nc.Publish(subject, data); err != nil {
+	if err := b.nc.Publish(b.prefix+subject, data); err != nil {
 		return err
 	}
 	metrics.OnPublish(subject)
 	return nil
 }
 
+// PublishMessage implements messaging.HeaderPublisher.
+// The headers travel as NATS message headers, which needs a server
+// that supports them, any since 2.2. ctx is ignored like by Publish.
+func (b *MessageBroker) PublishMessage(
+	ctx context.Context,
+	metrics messaging.Metrics,
+	msg messaging.Message,
+) error {
+	if len(msg.Header) == 0 {
+		return b.Publish(ctx, metrics, msg.Subject, msg.Data)
+	}
+	m := nats.NewMsg(b.prefix + msg.Subject)
+	m.Data = msg.Data
+	for k, v := range msg.Header {
+		m.Header.Set(k, v)
+	}
+	if err := b.nc.PublishMsg(m); err != nil {
+		return err
+	}
+	metrics.OnPublish(msg.Subject)
+	return nil
+}
+
+// Status implements messaging.StatusNotifier. The broker is down from the
+// moment the connection is lost until it's reestablished, and for good once
+// it's closed. The connection's own handlers keep working,
+// the broker listens for the changes next to them.
+func (b *MessageBroker) Status() (up bool, changed <-chan struct{}) {
+	b.watchStatus.Do(b.startWatchingStatus)
+	return b.status.Status()
+}
+
+// Ping implements messaging.Pinger. It flushes the connection and waits
+// for the server to answer, which fails while the connection is down.
+func (b *MessageBroker) Ping(ctx context.Context) error {
+	return b.nc.FlushWithContext(ctx)
+}
+
+// startWatchingStatus follows the state of the connection in b.status until
+// the connection is closed.
+func (b *MessageBroker) startWatchingStatus() {
+	events := b.nc.StatusChanged()
+	// The listener is registered first, a change from here on is seen.
+	b.status.Set(b.nc.IsConnected())
+	if b.nc.IsClosed() {
+		b.nc.RemoveStatusListener(events)
+		return
+	}
+	go func() {
+		for s := range events {
+			// NATS drops an event no one is ready to take,
+			// the connection is asked rather than trusting the event.
+			b.status.Set(b.nc.IsConnected())
+			if s == nats.CLOSED {
+				b.nc.RemoveStatusListener(events)
+				return
+			}
+		}
+	}()
+}
+
+// header returns the headers of a received message,
+// nil when it has none.
+func header(m *nats.Msg) messaging.Header {
+	if len(m.Header) == 0 {
+		return nil
+	}
+	h := make(messaging.Header, len(m.Header))
+	for k, v := range m.Header {
+		if len(v) > 0 {
+			h[k] = v[0]
+		}
+	}
+	return h
+}
+
 func (b *MessageBroker) Subscribe(
 	_ context.Context, metrics messaging.Metrics, subjects ...string,
 ) (messaging.Subscription, error) {
@@ -90,7 +196,7 @@ func (b *MessageBroker) Subscribe(
 	}
 
 	for _, subject := range subjects {
-		sub, err := b.nc.Subscribe(subject, func(m *nats.Msg) {
+		sub, err := b.nc.Subscribe(b.prefix+subject, func(m *nats.Msg) {
 			// Registration is serialized with closeAll() so Add never races with Wait.
 			lock.Lock()
 			if closing {
@@ -106,8 +212,9 @@ func (b *MessageBroker) Subscribe(
 
 			select {
 			case ch <- messaging.Message{
-				Subject: m.Subject,
+				Subject: strings.TrimPrefix(m.Subject, b.prefix),
 				Data:    bytes.Clone(m.Data),
+				Header:  header(m),
 			}:
 			default: // drop if subscriber is slow
 				metrics.OnDeliveryDropped()
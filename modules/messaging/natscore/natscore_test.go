@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -182,6 +183,102 @@ func TestUnrelatedSubjectIsNotDelivered(t *testing.T) {
 	require.Equal(t, "unrelated.one", receive(t, sub).Subject)
 }
 
+// TestSubjectPrefix covers two deployments sharing the cluster under
+// different prefixes. Each one receives only its own events, without the
+// prefix, and the prefix is what goes on the wire.
+func TestSubjectPrefix(t *testing.T) {
+	staging := natscore.New(testConn, natscore.Config{SubjectPrefix: "shop.staging"})
+	prod := natscore.New(testConn, natscore.Config{SubjectPrefix: "shop.prod"})
+	m := new(testMetrics)
+	stagingSub := subscribe(t, staging, m, "prefixed.*")
+	prodSub := subscribe(t, prod, m, "prefixed.*")
+
+	wire, err := testConn.SubscribeSync("shop.>")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = wire.Unsubscribe() })
+	require.NoError(t, testConn.Flush())
+
+	publish(t, staging, m, "prefixed.one", "staging")
+
+	msg := receive(t, stagingSub)
+	require.Equal(t, "prefixed.one", msg.Subject)
+	require.Equal(t, "staging", string(msg.Data))
+
+	raw, err := wire.NextMsg(3 * time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "shop.staging.prefixed.one", raw.Subject)
+
+	select {
+	case msg := <-prodSub.C():
+		t.Fatalf("another deployment received %q", msg.Subject)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestHeader covers headers travelling as NATS message headers.
+func TestHeader(t *testing.T) {
+	b := natscore.New(testConn, natscore.Config{})
+	m := new(testMetrics)
+	sub := subscribe(t, b, m, "header.*")
+
+	wire, err := testConn.SubscribeSync("header.one")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = wire.Unsubscribe() })
+	require.NoError(t, testConn.Flush())
+
+	require.NoError(t, b.PublishMessage(context.Background(), m, messaging.Message{
+		Subject: "header.one",
+		Data:    []byte("payload"),
+		Header:  messaging.Header{"Trace": "abc", "Publisher": "p1"},
+	}))
+	require.NoError(t, testConn.Flush())
+
+	msg := receive(t, sub)
+	require.Equal(t, "payload", string(msg.Data))
+	require.Equal(t, messaging.Header{"Trace": "abc", "Publisher": "p1"}, msg.Header)
+	require.Equal(t, int64(1), m.published.Load())
+
+	raw, err := wire.NextMsg(3 * time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "abc", raw.Header.Get("Trace"))
+
+	// A plain publish carries none.
+	publish(t, b, m, "header.two", "plain")
+	require.Nil(t, receive(t, sub).Header)
+}
+
+// TestStatus covers a connection that goes away. The broker reports it down
+// and wakes whoever waits on the change.
+func TestStatus(t *testing.T) {
+	nc, err := nats.Connect(testConn.ConnectedUrl())
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+	b := natscore.New(nc, natscore.Config{})
+
+	up, changed := b.Status()
+	require.True(t, up)
+
+	nc.Close()
+	select {
+	case <-changed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("closing the connection changed nothing")
+	}
+	up, _ = b.Status()
+	require.False(t, up)
+}
+
+func TestPing(t *testing.T) {
+	nc, err := nats.Connect(testConn.ConnectedUrl())
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+	b := natscore.New(nc, natscore.Config{})
+
+	require.NoError(t, b.Ping(t.Context()))
+	nc.Close()
+	require.Error(t, b.Ping(t.Context()))
+}
+
 // TestDefaultBrokerChanBuffer covers a broker created without a buffer size.
 // Its subscriptions must buffer all the same.
 func TestDefaultBrokerChanBuffer(t *testing.T) {
@@ -259,3 +356,99 @@ func TestCloseIsIdempotent(t *testing.T) {
 	_, ok := <-sub.C()
 	require.False(t, ok, "the subscription channel stayed open after Close")
 }
+
+func newScheduled(t *testing.T, m messaging.Metrics) *natscore.ScheduledBroker {
+	t.Helper()
+	b, err := natscore.NewScheduled(testConn, natscore.Config{}, natscore.ScheduleConfig{
+		KVConfig: nats.KeyValueConfig{Bucket: "SCHEDULE_" + strings.ToUpper(t.Name())},
+		Metrics:  m,
+	})
+	require.NoError(t, err)
+	return b
+}
+
+// runScheduler runs the scheduler of b until the test ends.
+func runScheduler(t *testing.T, b *natscore.ScheduledBroker) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- b.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+}
+
+func TestSchedule(t *testing.T) {
+	m := new(testMetrics)
+	b := newScheduled(t, m)
+	sub := subscribe(t, b.MessageBroker, m, "reminder.one")
+	runScheduler(t, b)
+
+	ctx := context.Background()
+	start := time.Now()
+	require.NoError(t, b.Schedule(ctx, m,
+		start.Add(300*time.Millisecond), "reminder.one", []byte("late")))
+	require.NoError(t, b.Schedule(ctx, m,
+		start.Add(-time.Hour), "reminder.one", []byte("due")))
+
+	// A time in the past publishes right away.
+	require.Equal(t, "due", string(receive(t, sub).Data))
+	require.Equal(t, "late", string(receive(t, sub).Data))
+	require.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+	require.Equal(t, int64(2), m.published.Load())
+}
+
+// TestScheduleHeader covers headers kept in the schedule until the message
+// is due.
+func TestScheduleHeader(t *testing.T) {
+	m := new(testMetrics)
+	b := newScheduled(t, m)
+	sub := subscribe(t, b.MessageBroker, m, "reminder.header")
+	runScheduler(t, b)
+
+	require.NoError(t, b.ScheduleMessage(context.Background(), m,
+		time.Now().Add(100*time.Millisecond), messaging.Message{
+			Subject: "reminder.header",
+			Data:    []byte("x"),
+			Header:  messaging.Header{"Trace": "abc"},
+		}))
+	msg := receive(t, sub)
+	require.Equal(t, "x", string(msg.Data))
+	require.Equal(t, messaging.Header{"Trace": "abc"}, msg.Header)
+}
+
+// TestScheduleOutlivesTheProcess covers a schedule written while no scheduler
+// was running, which is what a restart looks like.
+func TestScheduleOutlivesTheProcess(t *testing.T) {
+	before := newScheduled(t, nil)
+	require.NoError(t, before.Schedule(context.Background(), new(testMetrics),
+		time.Now().Add(100*time.Millisecond), "reminder.two", []byte("x")))
+
+	// The publish is reported to the metrics of the broker that makes it.
+	m := new(testMetrics)
+	after := newScheduled(t, m)
+	sub := subscribe(t, after.MessageBroker, new(testMetrics), "reminder.two")
+	runScheduler(t, after)
+	require.Equal(t, "x", string(receive(t, sub).Data))
+	require.Equal(t, int64(1), m.published.Load())
+}
+
+// TestScheduleOnce covers two instances sharing the bucket.
+// Only one of them publishes each message.
+func TestScheduleOnce(t *testing.T) {
+	m := new(testMetrics)
+	first, second := newScheduled(t, m), newScheduled(t, m)
+	sub := subscribe(t, first.MessageBroker, m, "reminder.three")
+	runScheduler(t, first)
+	runScheduler(t, second)
+
+	require.NoError(t, first.Schedule(context.Background(), m,
+		time.Now().Add(100*time.Millisecond), "reminder.three", []byte("x")))
+	require.Equal(t, "x", string(receive(t, sub).Data))
+	select {
+	case msg := <-sub.C():
+		t.Fatalf("the message arrived twice: %s", msg.Subject)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
@@ -0,0 +1,265 @@
+package natscore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/romshark/datapages/modules/messaging"
+)
+
+var (
+	_ messaging.Scheduler       = (*ScheduledBroker)(nil)
+	_ messaging.HeaderScheduler = (*ScheduledBroker)(nil)
+)
+
+// DefaultScheduleBucket is the KV bucket NewScheduled keeps the schedule in
+// when ScheduleConfig.KVConfig names none.
+const DefaultScheduleBucket = "DATAPAGES_SCHEDULE"
+
+// ErrSchedulerRunning is returned by [ScheduledBroker.Run] when another Run
+// of the same broker hasn't returned yet.
+var ErrSchedulerRunning = errors.New("scheduler is already running")
+
+// ScheduleConfig configures the schedule of a [ScheduledBroker].
+type ScheduleConfig struct {
+	// KVConfig configures the bucket the schedule is kept in, which is created
+	// if it doesn't exist. An empty Bucket selects DefaultScheduleBucket.
+	KVConfig nats.KeyValueConfig
+
+	// Logger receives the errors Run recovers from.
+	// Nil selects slog.Default().
+	Logger *slog.Logger
+
+	// Metrics is what Run reports the publishes of the due messages to.
+	// Nil disables them.
+	Metrics messaging.Metrics
+}
+
+// ScheduledBroker is a MessageBroker that implements messaging.Scheduler.
+// It keeps the schedule in a NATS KV bucket, where it survives restarts,
+// and publishes the messages that are due while [ScheduledBroker.Run] runs.
+//
+// Every instance sharing the bucket may run Run. Each message is published
+// by the one instance that claims it first by deleting its key. An instance
+// that dies between the claim and the publish loses the message, which keeps
+// the delivery at most once like the rest of the broker.
+//
+// An entry keeps the subject as it goes on the wire, the subject prefix
+// included. Deployments under different prefixes may share the bucket.
+type ScheduledBroker struct {
+	*MessageBroker
+	kv      nats.KeyValue
+	logger  *slog.Logger
+	metrics messaging.Metrics
+	running atomic.Bool
+}
+
+// scheduled is the value of a schedule entry. The time is in the key,
+// the subject is the one on the wire.
+type scheduled struct {
+	Subject string           `json:"subject"`
+	Data    []byte           `json:"data"`
+	Header  messaging.Header `json:"header,omitempty"`
+}
+
+// NewScheduled creates a broker like New that schedules
+// messages in the KV bucket of schedConf.
+func NewScheduled(
+	nc *nats.Conn, conf Config, schedConf ScheduleConfig,
+) (*ScheduledBroker, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("creating JetStream context: %w", err)
+	}
+
+	kvConfig := schedConf.KVConfig
+	if kvConfig.Bucket == "" {
+		kvConfig.Bucket = DefaultScheduleBucket
+	}
+	kv, err := js.KeyValue(kvConfig.Bucket)
+	switch {
+	case errors.Is(err, nats.ErrBucketNotFound):
+		kv, err = js.CreateKeyValue(&kvConfig)
+		if err != nil {
+			return nil, fmt.Errorf("creating new KV bucket: %w", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("opening KV bucket: %w", err)
+	}
+
+	logger := schedConf.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	metrics := schedConf.Metrics
+	if metrics == nil {
+		metrics = noMetrics{}
+	}
+	return &ScheduledBroker{
+		MessageBroker: New(nc, conf), kv: kv, logger: logger, metrics: metrics,
+	}, nil
+}
+
+// Schedule implements messaging.Scheduler. It writes the message into the
+// schedule bucket, a Run on any instance sharing it publishes it at t.
+// Run reports the publish to ScheduleConfig.Metrics, metrics is unused.
+func (b *ScheduledBroker) Schedule(
+	ctx context.Context,
+	metrics messaging.Metrics,
+	t time.Time,
+	subject string,
+	data []byte,
+) error {
+	return b.ScheduleMessage(ctx, metrics, t, messaging.Message{
+		Subject: subject,
+		Data:    data,
+	})
+}
+
+// ScheduleMessage implements messaging.HeaderScheduler like Schedule.
+// The headers are kept in the schedule entry and travel as NATS message
+// headers when it's due, like with PublishMessage.
+func (b *ScheduledBroker) ScheduleMessage(
+	_ context.Context,
+	_ messaging.Metrics,
+	t time.Time,
+	msg messaging.Message,
+) error {
+	v, err := json.Marshal(scheduled{
+		Subject: b.prefix + msg.Subject, Data: msg.Data, Header: msg.Header,
+	})
+	if err != nil {
+		return fmt.Errorf("marshaling schedule entry: %w", err)
+	}
+	// The random suffix keeps messages scheduled for the same millisecond apart.
+	key := strconv.FormatInt(max(t.UnixMilli(), 0), 10) + "." + rand.Text()
+	if _, err := b.kv.Create(key, v); err != nil {
+		return fmt.Errorf("writing schedule entry: %w", err)
+	}
+	return nil
+}
+
+// Run publishes the scheduled messages when they're due until ctx is canceled,
+// which makes it return nil. Messages that came due while no Run was running
+// are published right away. Start it in a goroutine of its own:
+//
+//	go func() { _ = b.Run(ctx) }()
+func (b *ScheduledBroker) Run(ctx context.Context) error {
+	if !b.running.CompareAndSwap(false, true) {
+		return ErrSchedulerRunning
+	}
+	defer b.running.Store(false)
+
+	w, err := b.kv.WatchAll(nats.Context(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("watching schedule: %w", err)
+	}
+	defer func() { _ = w.Stop() }()
+
+	timers := make(map[string]*time.Timer)
+	defer func() {
+		for _, tm := range timers {
+			tm.Stop()
+		}
+	}()
+	due := make(chan nats.KeyValueEntry)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-w.Updates():
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return errors.New("schedule watcher stopped")
+			}
+			if e == nil {
+				continue // The entries scheduled before Run are replayed.
+			}
+			if tm, ok := timers[e.Key()]; ok {
+				tm.Stop()
+				delete(timers, e.Key())
+			}
+			if e.Operation() != nats.KeyValuePut {
+				continue // Claimed by an instance.
+			}
+			at, ok := parseScheduleKey(e.Key())
+			if !ok {
+				b.logger.Error("malformed schedule key", slog.String("key", e.Key()))
+				continue
+			}
+			timers[e.Key()] = time.AfterFunc(time.Until(at), func() {
+				select {
+				case due <- e:
+				case <-ctx.Done():
+				}
+			})
+		case e := <-due:
+			delete(timers, e.Key())
+			b.publishDue(ctx, e)
+		}
+	}
+}
+
+// publishDue claims and publishes the message of e.
+func (b *ScheduledBroker) publishDue(ctx context.Context, e nats.KeyValueEntry) {
+	// The delete fails when another instance claimed the entry first.
+	if err := b.kv.Delete(e.Key(), nats.LastRevision(e.Revision())); err != nil {
+		if !errors.Is(err, nats.ErrKeyRevisionMismatch) && ctx.Err() == nil {
+			b.logger.Error("claiming scheduled message",
+				slog.String("key", e.Key()), slog.Any("err", err))
+		}
+		return
+	}
+	var m scheduled
+	if err := json.Unmarshal(e.Value(), &m); err != nil {
+		b.logger.Error("unmarshaling scheduled message",
+			slog.String("key", e.Key()), slog.Any("err", err))
+		return
+	}
+	// The entry may be another deployment's, it's published as it was scheduled.
+	nm := nats.NewMsg(m.Subject)
+	nm.Data = m.Data
+	for k, v := range m.Header {
+		nm.Header.Set(k, v)
+	}
+	if err := b.nc.PublishMsg(nm); err != nil {
+		b.logger.Error("publishing scheduled message",
+			slog.String("subject", m.Subject), slog.Any("err", err))
+		return
+	}
+	b.metrics.OnPublish(strings.TrimPrefix(m.Subject, b.prefix))
+}
+
+// parseScheduleKey returns the time a schedule key was scheduled for.
+func parseScheduleKey(key string) (time.Time, bool) {
+	ms, _, ok := strings.Cut(key, ".")
+	if !ok {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(n), true
+}
+
+type noMetrics struct{}
+
+func (noMetrics) OnPublish(string)   {}
+func (noMetrics) OnDeliveryDropped() {}
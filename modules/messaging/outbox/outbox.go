@@ -0,0 +1,565 @@
+// Package outbox provides a message broker that publishes through a
+// transactional outbox table, which makes event publishing at-least-once.
+//
+// A dispatched event is written into the outbox table of a database/sql
+// database instead of being sent to the broker. Published through
+// [Broker.Tx], the row is written inside a transaction and commits or
+// rolls back with the change it announces. A relay, see [Broker.Run],
+// reads the rows in insertion order, publishes them to the underlying broker
+// and marks them done. A crash between the commit and the publish delays the
+// event until the relay runs again, it doesn't lose it.
+//
+// At-least-once means an event can arrive twice: when the process dies
+// between publishing a row and marking it done, the relay publishes it again.
+// Subscriptions are served by the underlying broker unchanged.
+//
+// The table must exist before the broker is used. For SQLite:
+//
+//	CREATE TABLE datapages_outbox (
+//		id              INTEGER PRIMARY KEY AUTOINCREMENT,
+//		subject         TEXT    NOT NULL,
+//		data            BLOB    NOT NULL,
+//		header          TEXT,
+//		created_at      INTEGER NOT NULL,
+//		attempts        INTEGER NOT NULL DEFAULT 0,
+//		next_attempt_at INTEGER NOT NULL DEFAULT 0,
+//		last_error      TEXT,
+//		published_at    INTEGER
+//	);
+//	CREATE INDEX datapages_outbox_pending ON datapages_outbox (published_at, id);
+//
+// [SQLiteSchema] returns these statements for any table name. Other databases
+// need the same columns with their own types, for PostgreSQL
+// "id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY" and "data BYTEA".
+// Times are stored as Unix milliseconds. The headers of a message are stored
+// as a JSON object in header, NULL when it has none. A table created without
+// the column needs it added:
+//
+//	ALTER TABLE datapages_outbox ADD COLUMN header TEXT;
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/romshark/datapages/modules/messaging"
+)
+
+var (
+	_ messaging.Broker            = (*Broker)(nil)
+	_ messaging.HeaderPublisher   = (*Broker)(nil)
+	_ messaging.StreamInitializer = (*Broker)(nil)
+	_ messaging.Pinger            = (*Broker)(nil)
+	_ messaging.HeaderPublisher   = txPublisher{}
+)
+
+const (
+	// DefaultTable is the outbox table a zero Config selects.
+	DefaultTable = "datapages_outbox"
+
+	// DefaultPollInterval is how often the relay looks for rows by default.
+	DefaultPollInterval = time.Second
+
+	// DefaultBatchSize is how many rows the relay reads at once by default.
+	DefaultBatchSize = 100
+
+	// DefaultRetryMin and DefaultRetryMax bound the backoff after a failed
+	// publish by default.
+	DefaultRetryMin = 100 * time.Millisecond
+	DefaultRetryMax = 30 * time.Second
+)
+
+// ErrRelayRunning is returned by [Broker.Run] when another Run of the same
+// broker hasn't returned yet.
+var ErrRelayRunning = errors.New("outbox relay is already running")
+
+// Config configures a [Broker]. The zero value selects the defaults.
+type Config struct {
+	// Table is the outbox table. Empty selects DefaultTable.
+	// It's put into the queries as is and must not come from user input.
+	Table string
+
+	// Placeholder renders the n-th query parameter, counting from 1.
+	// Nil selects PlaceholderQuestion, which SQLite and MySQL understand.
+	// PostgreSQL needs PlaceholderDollar.
+	Placeholder func(n int) string
+
+	// PollInterval is how often the relay looks for rows it wasn't told about.
+	// Non-positive selects DefaultPollInterval.
+	PollInterval time.Duration
+
+	// BatchSize is how many rows the relay reads at once.
+	// Non-positive selects DefaultBatchSize.
+	BatchSize int
+
+	// RetryMin is the backoff after the first failed publish of a row,
+	// which doubles with every further failure up to RetryMax.
+	// Non-positive values select DefaultRetryMin and DefaultRetryMax.
+	RetryMin, RetryMax time.Duration
+
+	// Metrics receives the outbox instrumentation callbacks. Nil disables them.
+	Metrics Metrics
+
+	// BrokerMetrics is what the relay publishes to the underlying broker with,
+	// the broker metrics a Publish without the outbox would have gone to.
+	// Nil disables them.
+	BrokerMetrics messaging.Metrics
+
+	// Logger receives the errors the relay recovers from.
+	// Nil selects slog.Default().
+	Logger *slog.Logger
+}
+
+// Metrics receives outbox instrumentation callbacks.
+type Metrics interface {
+	// OnStored is called for every row written into the outbox.
+	OnStored(subject string)
+
+	// OnRelayed is called for every row published to the underlying broker.
+	// lag is the time the row spent in the outbox.
+	OnRelayed(subject string, lag time.Duration)
+
+	// OnRelayFailed is called for every publish the underlying broker refused.
+	// The row is retried.
+	OnRelayFailed(subject string)
+}
+
+// PlaceholderQuestion renders every query parameter as "?".
+func PlaceholderQuestion(int) string { return "?" }
+
+// PlaceholderDollar renders the n-th query parameter as "$n".
+func PlaceholderDollar(n int) string { return "$" + strconv.Itoa(n) }
+
+// SQLiteSchema returns the statements that create the outbox table
+// named table in SQLite.
+func SQLiteSchema(table string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + table + ` (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	subject         TEXT    NOT NULL,
+	data            BLOB    NOT NULL,
+	header          TEXT,
+	created_at      INTEGER NOT NULL,
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at INTEGER NOT NULL DEFAULT 0,
+	last_error      TEXT,
+	published_at    INTEGER
+);
+CREATE INDEX IF NOT EXISTS ` + table + `_pending ON ` + table + ` (published_at, id);`
+}
+
+// Broker is a [messaging.Broker] whose Publish writes into an outbox table and
+// whose Subscribe is served by the underlying broker.
+type Broker struct {
+	db     *sql.DB
+	broker messaging.Broker
+	conf   Config
+
+	qInsert, qSelect, qDone, qFailed, qPending, qPrune string
+
+	wake    chan struct{}
+	running atomic.Bool
+	now     func() time.Time
+}
+
+// New creates a broker that writes published messages into the outbox table
+// of db and relays them to broker once [Broker.Run] is started.
+func New(db *sql.DB, broker messaging.Broker, conf Config) *Broker {
+	if conf.Table == "" {
+		conf.Table = DefaultTable
+	}
+	if conf.Placeholder == nil {
+		conf.Placeholder = PlaceholderQuestion
+	}
+	if conf.PollInterval <= 0 {
+		conf.PollInterval = DefaultPollInterval
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = DefaultBatchSize
+	}
+	if conf.RetryMin <= 0 {
+		conf.RetryMin = DefaultRetryMin
+	}
+	if conf.RetryMax <= 0 {
+		conf.RetryMax = DefaultRetryMax
+	}
+	if conf.Metrics == nil {
+		conf.Metrics = noMetrics{}
+	}
+	if conf.BrokerMetrics == nil {
+		conf.BrokerMetrics = noMetrics{}
+	}
+	if conf.Logger == nil {
+		conf.Logger = slog.Default()
+	}
+
+	t, p := conf.Table, conf.Placeholder
+	return &Broker{
+		db:     db,
+		broker: broker,
+		conf:   conf,
+		qInsert: "INSERT INTO " + t + " (subject, data, header, created_at) VALUES (" +
+			p(1) + ", " + p(2) + ", " + p(3) + ", " + p(4) + ")",
+		qSelect: "SELECT id, subject, data, header, created_at, attempts, next_attempt_at FROM " + t +
+			" WHERE published_at IS NULL AND id > " + p(1) + " ORDER BY id LIMIT " + p(2),
+		qDone: "UPDATE " + t + " SET published_at = " + p(1) +
+			" WHERE id = " + p(2),
+		qFailed: "UPDATE " + t + " SET attempts = attempts + 1, next_attempt_at = " +
+			p(1) + ", last_error = " + p(2) + " WHERE id = " + p(3),
+		qPending: "SELECT COUNT(*) FROM " + t + " WHERE published_at IS NULL",
+		qPrune: "DELETE FROM " + t +
+			" WHERE published_at IS NOT NULL AND published_at < " + p(1),
+		wake: make(chan struct{}, 1),
+		now:  time.Now,
+	}
+}
+
+// Publish implements messaging.Publisher. It writes the message into the
+// outbox table on its own and wakes the relay up, see [Broker.Tx] to write it
+// inside a transaction.
+//
+// A nil error means the row is written, not that any subscriber received it.
+// The relay reports the publish to Config.BrokerMetrics, metrics is unused.
+func (b *Broker) Publish(
+	ctx context.Context, metrics messaging.Metrics, subject string, data []byte,
+) error {
+	return b.PublishMessage(ctx, metrics, messaging.Message{Subject: subject, Data: data})
+}
+
+// PublishMessage implements messaging.HeaderPublisher like Publish.
+// The relay publishes the message with its headers.
+func (b *Broker) PublishMessage(
+	ctx context.Context, _ messaging.Metrics, msg messaging.Message,
+) error {
+	if err := b.insert(ctx, b.db, msg); err != nil {
+		return err
+	}
+	b.Notify()
+	return nil
+}
+
+// PublishTx writes the message into the outbox table inside tx. The relay
+// publishes it once tx is committed and never when it's rolled back.
+// Call [Broker.Notify] after the commit, the relay would otherwise find
+// the row at its next poll.
+func (b *Broker) PublishTx(
+	ctx context.Context, tx *sql.Tx, subject string, data []byte,
+) error {
+	return b.insert(ctx, tx, messaging.Message{Subject: subject, Data: data})
+}
+
+// Tx returns a publisher that writes into the outbox table inside tx,
+// see [Broker.PublishTx]. Pass it to datapages.DispatchTo to tie an event
+// to the transaction it announces:
+//
+//	tx, err := p.App.DB.BeginTx(r.Context(), nil)
+//	if err != nil {
+//		return err
+//	}
+//	defer tx.Rollback()
+//	// ... write the order ...
+//	err = datapages.DispatchTo(
+//		r.Context(), p.App.Outbox.Tx(tx), placed, EventOrderPlaced{ID: id},
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	if err := tx.Commit(); err != nil {
+//		return err
+//	}
+//	p.App.Outbox.Notify()
+func (b *Broker) Tx(tx *sql.Tx) messaging.Publisher {
+	return txPublisher{b: b, tx: tx}
+}
+
+type txPublisher struct {
+	b  *Broker
+	tx *sql.Tx
+}
+
+func (p txPublisher) Publish(
+	ctx context.Context, _ messaging.Metrics, subject string, data []byte,
+) error {
+	return p.b.PublishTx(ctx, p.tx, subject, data)
+}
+
+// PublishMessage implements messaging.HeaderPublisher like Publish.
+func (p txPublisher) PublishMessage(
+	ctx context.Context, _ messaging.Metrics, msg messaging.Message,
+) error {
+	return p.b.insert(ctx, p.tx, msg)
+}
+
+// execer is what *sql.DB and *sql.Tx have in common.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// insert writes one row with e.
+func (b *Broker) insert(ctx context.Context, e execer, msg messaging.Message) error {
+	data := msg.Data
+	if data == nil {
+		data = []byte{} // The data column is NOT NULL.
+	}
+	var header sql.NullString
+	if len(msg.Header) > 0 {
+		h, err := json.Marshal(msg.Header)
+		if err != nil {
+			return fmt.Errorf("encoding outbox row header: %w", err)
+		}
+		header = sql.NullString{String: string(h), Valid: true}
+	}
+	if _, err := e.ExecContext(
+		ctx, b.qInsert, msg.Subject, data, header, b.now().UnixMilli(),
+	); err != nil {
+		return fmt.Errorf("writing outbox row: %w", err)
+	}
+	b.conf.Metrics.OnStored(msg.Subject)
+	return nil
+}
+
+// Subscribe implements messaging.Subscriber by subscribing on the
+// underlying broker.
+func (b *Broker) Subscribe(
+	ctx context.Context, metrics messaging.Metrics, subjects ...string,
+) (messaging.Subscription, error) {
+	return b.broker.Subscribe(ctx, metrics, subjects...)
+}
+
+// InitStreams implements messaging.StreamInitializer. It passes the subjects
+// on to the underlying broker if that's a StreamInitializer too.
+func (b *Broker) InitStreams(subjects []string) error {
+	if si, ok := b.broker.(messaging.StreamInitializer); ok {
+		return si.InitStreams(subjects)
+	}
+	return nil
+}
+
+// Ping implements messaging.Pinger. It pings the database and the underlying
+// broker, see [messaging.Ping] for one that doesn't implement messaging.Pinger.
+// Both must be reachable, a message written but never relayed doesn't
+// reach anyone either.
+func (b *Broker) Ping(ctx context.Context) error {
+	if err := b.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("pinging outbox database: %w", err)
+	}
+	return messaging.Ping(ctx, b.broker)
+}
+
+// Notify wakes the relay up. Call it after committing a transaction that
+// published, the relay would otherwise find the rows at its next poll.
+func (b *Broker) Notify() {
+	select {
+	case b.wake <- struct{}{}:
+	default: // A wake-up is pending already.
+	}
+}
+
+// Pending returns how many rows the relay hasn't published yet.
+func (b *Broker) Pending(ctx context.Context) (int, error) {
+	var n int
+	if err := b.db.QueryRowContext(ctx, b.qPending).Scan(&n); err != nil {
+		return 0, fmt.Errorf("counting pending outbox rows: %w", err)
+	}
+	return n, nil
+}
+
+// Prune deletes the rows published before t and returns how many it deleted.
+// Published rows are kept until then, which leaves them for inspection.
+func (b *Broker) Prune(ctx context.Context, t time.Time) (int64, error) {
+	res, err := b.db.ExecContext(ctx, b.qPrune, t.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("pruning outbox: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// Run relays the outbox to the underlying broker until ctx is canceled.
+// Start it in a goroutine of its own, once per outbox table:
+//
+//	go func() { _ = ob.Run(ctx) }()
+//
+// Rows are published in insertion order. A publish the underlying broker
+// refuses is retried with exponential backoff, and the rows of the same
+// subject after it wait for it, which keeps every subject in order.
+// The rows of other subjects carry on.
+//
+// Two relays on one table publish every row twice and break the order,
+// which is why Run returns ErrRelayRunning while another one of the same
+// broker is running. Run returns nil once ctx is canceled.
+func (b *Broker) Run(ctx context.Context) error {
+	if !b.running.CompareAndSwap(false, true) {
+		return ErrRelayRunning
+	}
+	defer b.running.Store(false)
+
+	r := relay{b: b, blocked: make(map[string]time.Time)}
+	ticker := time.NewTicker(b.conf.PollInterval)
+	defer ticker.Stop()
+	for {
+		full, err := r.relayBatch(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			b.conf.Logger.Error("relaying outbox", slog.Any("err", err))
+		}
+		if full && err == nil {
+			// More rows may be waiting behind the batch.
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-b.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay is the state of one Run.
+type relay struct {
+	b *Broker
+	// blocked holds the subjects whose oldest pending row failed, and until
+	// when that row waits. Their later rows must not overtake it.
+	blocked map[string]time.Time
+	// after is the id of the last row of the previous batch of the pass,
+	// zero at the start of one. A pass pages through the whole outbox,
+	// the rows of blocked subjects would otherwise fill every batch.
+	after int64
+}
+
+type row struct {
+	id            int64
+	subject       string
+	data          []byte
+	header        messaging.Header
+	createdAt     int64
+	nextAttemptAt int64
+	attempts      int
+}
+
+// relayBatch publishes the next batch of pending rows of the pass. full
+// reports whether the batch was as large as BatchSize, in which case the pass
+// goes on with the rows after it.
+func (r *relay) relayBatch(ctx context.Context) (full bool, err error) {
+	b := r.b
+	rows, err := r.pending(ctx)
+	if err != nil {
+		r.after = 0
+		return false, err
+	}
+
+	now := b.now()
+	if r.after == 0 {
+		// Subjects are unblocked between passes only, a row of the pass
+		// after the failed one would overtake it otherwise.
+		for subject, until := range r.blocked {
+			if !until.After(now) {
+				delete(r.blocked, subject)
+			}
+		}
+	}
+	r.after = 0
+	if len(rows) == b.conf.BatchSize {
+		r.after = rows[len(rows)-1].id
+	}
+
+	for _, rw := range rows {
+		if _, ok := r.blocked[rw.subject]; ok {
+			continue
+		}
+		if until := time.UnixMilli(rw.nextAttemptAt); until.After(now) {
+			r.blocked[rw.subject] = until
+			continue
+		}
+
+		err := messaging.PublishMessage(ctx, b.broker, b.conf.BrokerMetrics, messaging.Message{
+			Subject: rw.subject, Data: rw.data, Header: rw.header,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, nil
+			}
+			b.conf.Metrics.OnRelayFailed(rw.subject)
+			until := now.Add(b.backoff(rw.attempts + 1))
+			r.blocked[rw.subject] = until
+			if _, err := b.db.ExecContext(
+				ctx, b.qFailed, until.UnixMilli(), err.Error(), rw.id,
+			); err != nil {
+				r.after = 0
+				return false, fmt.Errorf("recording failed outbox row %d: %w", rw.id, err)
+			}
+			continue
+		}
+
+		if _, err := b.db.ExecContext(
+			ctx, b.qDone, b.now().UnixMilli(), rw.id,
+		); err != nil {
+			// The row is published again by the next batch,
+			// which at-least-once permits.
+			r.after = 0
+			return false, fmt.Errorf("marking outbox row %d done: %w", rw.id, err)
+		}
+		b.conf.Metrics.OnRelayed(rw.subject, now.Sub(time.UnixMilli(rw.createdAt)))
+	}
+	return r.after != 0, nil
+}
+
+// pending reads the oldest rows not yet published.
+func (r *relay) pending(ctx context.Context) ([]row, error) {
+	b := r.b
+	rs, err := b.db.QueryContext(ctx, b.qSelect, r.after, b.conf.BatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("reading outbox: %w", err)
+	}
+	defer func() { _ = rs.Close() }()
+
+	var rows []row
+	for rs.Next() {
+		var rw row
+		var header sql.NullString
+		if err := rs.Scan(
+			&rw.id, &rw.subject, &rw.data, &header,
+			&rw.createdAt, &rw.attempts, &rw.nextAttemptAt,
+		); err != nil {
+			return nil, fmt.Errorf("scanning outbox row: %w", err)
+		}
+		if header.Valid {
+			if err := json.Unmarshal([]byte(header.String), &rw.header); err != nil {
+				// Failing the read would hold every row back.
+				b.conf.Logger.Error("decoding outbox row header, relaying without it",
+					slog.Int64("id", rw.id), slog.Any("err", err))
+				rw.header = nil
+			}
+		}
+		rows = append(rows, rw)
+	}
+	if err := rs.Err(); err != nil {
+		return nil, fmt.Errorf("reading outbox: %w", err)
+	}
+	return rows, nil
+}
+
+// backoff returns how long a row waits after its n-th failed publish.
+func (b *Broker) backoff(n int) time.Duration {
+	d := b.conf.RetryMin
+	for i := 1; i < n && d < b.conf.RetryMax; i++ {
+		d *= 2
+	}
+	return min(d, b.conf.RetryMax)
+}
+
+type noMetrics struct{}
+
+func (noMetrics) OnPublish(string)                {}
+func (noMetrics) OnDeliveryDropped()              {}
+func (noMetrics) OnStored(string)                 {}
+func (noMetrics) OnRelayed(string, time.Duration) {}
+func (noMetrics) OnRelayFailed(string)            {}
@@ -0,0 +1,382 @@
+package outbox_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"github.com/romshark/datapages/modules/messaging"
+	"github.com/romshark/datapages/modules/messaging/inmem"
+	"github.com/romshark/datapages/modules/messaging/outbox"
+)
+
+type noMetrics struct{}
+
+func (noMetrics) OnPublish(string)   {}
+func (noMetrics) OnDeliveryDropped() {}
+
+// publishMetrics records the subjects published.
+type publishMetrics struct {
+	mu        sync.Mutex
+	published []string
+}
+
+func (m *publishMetrics) OnPublish(subject string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.published = append(m.published, subject)
+}
+
+func (m *publishMetrics) OnDeliveryDropped() {}
+
+func (m *publishMetrics) subjects() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.published)
+}
+
+// countingMetrics counts the outbox callbacks.
+type countingMetrics struct {
+	mu                      sync.Mutex
+	stored, relayed, failed int
+}
+
+func (m *countingMetrics) OnStored(string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stored++
+}
+
+func (m *countingMetrics) OnRelayed(string, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.relayed++
+}
+
+func (m *countingMetrics) OnRelayFailed(string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed++
+}
+
+func (m *countingMetrics) counts() (stored, relayed, failed int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stored, m.relayed, m.failed
+}
+
+// flakyBroker refuses the first refuse publishes of subject.
+type flakyBroker struct {
+	messaging.Broker
+	subject string
+
+	mu     sync.Mutex
+	refuse int
+}
+
+var errRefused = errors.New("refused")
+
+func (b *flakyBroker) Publish(
+	ctx context.Context, metrics messaging.Metrics, subject string, data []byte,
+) error {
+	b.mu.Lock()
+	if subject == b.subject && b.refuse > 0 {
+		b.refuse--
+		b.mu.Unlock()
+		return errRefused
+	}
+	b.mu.Unlock()
+	return b.Broker.Publish(ctx, metrics, subject, data)
+}
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "outbox.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	// A single connection keeps SQLite from reporting the database as busy.
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(outbox.SQLiteSchema(outbox.DefaultTable))
+	require.NoError(t, err)
+	return db
+}
+
+// startRelay runs the relay of ob until the test ends.
+func startRelay(t *testing.T, ob *outbox.Broker) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- ob.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+}
+
+func subscribe(t *testing.T, b messaging.Broker, subjects ...string) messaging.Subscription {
+	t.Helper()
+	sub, err := b.Subscribe(context.Background(), noMetrics{}, subjects...)
+	require.NoError(t, err)
+	t.Cleanup(sub.Close)
+	return sub
+}
+
+func receive(t *testing.T, sub messaging.Subscription) messaging.Message {
+	t.Helper()
+	select {
+	case m := <-sub.C():
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return messaging.Message{}
+	}
+}
+
+func requireNothing(t *testing.T, sub messaging.Subscription, wait time.Duration) {
+	t.Helper()
+	select {
+	case m := <-sub.C():
+		t.Fatalf("unexpected message %q: %q", m.Subject, m.Data)
+	case <-time.After(wait):
+	}
+}
+
+func TestCommittedTxIsRelayed(t *testing.T) {
+	t.Parallel()
+
+	db := openDB(t)
+	ob := outbox.New(db, inmem.New(0), outbox.Config{})
+	sub := subscribe(t, ob, "order.placed")
+	startRelay(t, ob)
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	err = ob.PublishTx(ctx, tx, "order.placed", []byte("1"))
+	require.NoError(t, err)
+
+	// Nothing is relayed before the commit.
+	ob.Notify()
+	requireNothing(t, sub, 50*time.Millisecond)
+
+	require.NoError(t, tx.Commit())
+	ob.Notify()
+
+	m := receive(t, sub)
+	require.Equal(t, "order.placed", m.Subject)
+	require.Equal(t, []byte("1"), m.Data)
+}
+
+func TestRolledBackTxIsDropped(t *testing.T) {
+	t.Parallel()
+
+	db := openDB(t)
+	ob := outbox.New(db, inmem.New(0), outbox.Config{})
+	sub := subscribe(t, ob, "order.placed")
+	startRelay(t, ob)
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	err = ob.Tx(tx).Publish(ctx, noMetrics{}, "order.placed", []byte("1"))
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+	ob.Notify()
+
+	requireNothing(t, sub, 100*time.Millisecond)
+	n, err := ob.Pending(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
+
+func TestPublishWithoutTx(t *testing.T) {
+	t.Parallel()
+
+	db := openDB(t)
+	metrics := new(countingMetrics)
+	ob := outbox.New(db, inmem.New(0), outbox.Config{Metrics: metrics})
+	sub := subscribe(t, ob, "a")
+
+	ctx := context.Background()
+	require.NoError(t, ob.Publish(ctx, noMetrics{}, "a", []byte("1")))
+	require.NoError(t, ob.Publish(ctx, noMetrics{}, "a", []byte("2")))
+
+	// The rows wait in the outbox until the relay runs.
+	n, err := ob.Pending(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	startRelay(t, ob)
+	require.Equal(t, []byte("1"), receive(t, sub).Data)
+	require.Equal(t, []byte("2"), receive(t, sub).Data)
+
+	require.Eventually(t, func() bool {
+		n, err := ob.Pending(ctx)
+		return err == nil && n == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	stored, relayed, failed := metrics.counts()
+	require.Equal(t, 2, stored)
+	require.Equal(t, 2, relayed)
+	require.Zero(t, failed)
+
+	pruned, err := ob.Prune(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), pruned)
+}
+
+// TestHeaders covers the headers of a message, which the relay publishes
+// along with it, in a transaction and without one.
+func TestHeaders(t *testing.T) {
+	t.Parallel()
+
+	db := openDB(t)
+	ob := outbox.New(db, inmem.New(0), outbox.Config{})
+	sub := subscribe(t, ob, "a")
+	startRelay(t, ob)
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	require.NoError(t, messaging.PublishMessage(ctx, ob.Tx(tx), noMetrics{}, messaging.Message{
+		Subject: "a", Data: []byte("1"), Header: messaging.Header{"k": "tx"},
+	}))
+	require.NoError(t, tx.Commit())
+	require.NoError(t, messaging.PublishMessage(ctx, ob, noMetrics{}, messaging.Message{
+		Subject: "a", Data: []byte("2"), Header: messaging.Header{"k": "v"},
+	}))
+	require.NoError(t, ob.Publish(ctx, noMetrics{}, "a", []byte("3")))
+
+	m := receive(t, sub)
+	require.Equal(t, []byte("1"), m.Data)
+	require.Equal(t, messaging.Header{"k": "tx"}, m.Header)
+	m = receive(t, sub)
+	require.Equal(t, []byte("2"), m.Data)
+	require.Equal(t, messaging.Header{"k": "v"}, m.Header)
+	m = receive(t, sub)
+	require.Equal(t, []byte("3"), m.Data)
+	require.Nil(t, m.Header)
+}
+
+func TestRelayReportsBrokerMetrics(t *testing.T) {
+	t.Parallel()
+
+	// The row is written before a restart, which the relay of a new broker
+	// on the same table picks up.
+	db := openDB(t)
+	ctx := context.Background()
+	require.NoError(t, outbox.New(db, inmem.New(0), outbox.Config{}).
+		Publish(ctx, noMetrics{}, "a", []byte("1")))
+
+	metrics := new(publishMetrics)
+	ob := outbox.New(db, inmem.New(0), outbox.Config{BrokerMetrics: metrics})
+	sub := subscribe(t, ob, "a")
+	startRelay(t, ob)
+	receive(t, sub)
+	require.Equal(t, []string{"a"}, metrics.subjects())
+}
+
+func TestRetryKeepsSubjectOrder(t *testing.T) {
+	t.Parallel()
+
+	db := openDB(t)
+	metrics := new(countingMetrics)
+	broker := &flakyBroker{Broker: inmem.New(0), subject: "a", refuse: 2}
+	ob := outbox.New(db, broker, outbox.Config{
+		Metrics:      metrics,
+		PollInterval: 10 * time.Millisecond,
+		RetryMin:     20 * time.Millisecond,
+		RetryMax:     50 * time.Millisecond,
+	})
+	subA := subscribe(t, ob, "a")
+	subB := subscribe(t, ob, "b")
+
+	ctx := context.Background()
+	require.NoError(t, ob.Publish(ctx, noMetrics{}, "a", []byte("1")))
+	require.NoError(t, ob.Publish(ctx, noMetrics{}, "b", []byte("1")))
+	require.NoError(t, ob.Publish(ctx, noMetrics{}, "a", []byte("2")))
+	require.NoError(t, ob.Publish(ctx, noMetrics{}, "b", []byte("2")))
+	startRelay(t, ob)
+
+	// The refused subject doesn't hold up the other one.
+	require.Equal(t, []byte("1"), receive(t, subB).Data)
+	require.Equal(t, []byte("2"), receive(t, subB).Data)
+
+	// The row after the refused one waits for it.
+	require.Equal(t, []byte("1"), receive(t, subA).Data)
+	require.Equal(t, []byte("2"), receive(t, subA).Data)
+
+	require.Eventually(t, func() bool {
+		_, relayed, _ := metrics.counts()
+		return relayed == 4
+	}, 5*time.Second, 10*time.Millisecond)
+	_, _, failed := metrics.counts()
+	require.Equal(t, 2, failed)
+}
+
+func TestBlockedSubjectDoesNotStarveOthers(t *testing.T) {
+	t.Parallel()
+
+	db := openDB(t)
+	broker := &flakyBroker{Broker: inmem.New(0), subject: "a", refuse: 1000}
+	ob := outbox.New(db, broker, outbox.Config{
+		PollInterval: 10 * time.Millisecond,
+		BatchSize:    2,
+		RetryMin:     time.Minute,
+	})
+	subB := subscribe(t, ob, "b")
+
+	// The refused subject fills more than a batch ahead of the other one.
+	ctx := context.Background()
+	for range 5 {
+		require.NoError(t, ob.Publish(ctx, noMetrics{}, "a", nil))
+	}
+	require.NoError(t, ob.Publish(ctx, noMetrics{}, "b", []byte("1")))
+	startRelay(t, ob)
+
+	require.Equal(t, []byte("1"), receive(t, subB).Data)
+}
+
+func TestRunTwice(t *testing.T) {
+	t.Parallel()
+
+	ob := outbox.New(openDB(t), inmem.New(0), outbox.Config{})
+	sub := subscribe(t, ob, "a")
+	startRelay(t, ob)
+
+	// Once a message is relayed the first relay is known to be running.
+	require.NoError(t, ob.Publish(context.Background(), noMetrics{}, "a", nil))
+	receive(t, sub)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, ob.Run(ctx), outbox.ErrRelayRunning)
+}
+
+// downBroker is a broker that reports it's down.
+type downBroker struct {
+	messaging.Broker
+	messaging.ConnStatus
+}
+
+func TestPing(t *testing.T) {
+	t.Parallel()
+
+	db := openDB(t)
+	require.NoError(t, outbox.New(db, inmem.New(0), outbox.Config{}).Ping(t.Context()))
+
+	down := &downBroker{Broker: inmem.New(0)}
+	down.Set(false)
+	err := outbox.New(db, down, outbox.Config{}).Ping(t.Context())
+	require.ErrorIs(t, err, messaging.ErrBrokerUnavailable)
+
+	require.NoError(t, db.Close())
+	require.Error(t, outbox.New(db, inmem.New(0), outbox.Config{}).Ping(t.Context()))
+}
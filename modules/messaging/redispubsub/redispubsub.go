@@ -0,0 +1,517 @@
+// Package redispubsub provides a Redis Pub/Sub backed message broker
+// with fan-out delivery semantics.
+//
+// A subject is published to the Redis channel of the same name. A subscription
+// to a literal subject subscribes to its channel, one with "*" or ">" tokens
+// to a PSUBSCRIBE pattern. A Redis pattern matches more than a NATS one does,
+// "*" spans dots and matches nothing as well, the broker filters what arrives
+// by the exact NATS rules before it delivers.
+//
+// Delivery is at-most-once like with core NATS: a message reaches only the
+// subscribers that are subscribed when it's published, there's no replay,
+// and a subscription that doesn't keep up has the messages dropped that don't
+// fit its buffer.
+//
+// Redis Pub/Sub has no message headers. A message published with headers is
+// sent in an envelope the broker unwraps when it arrives, a message without
+// is sent as is, so other Redis clients can publish to the broker and
+// receive from it.
+package redispubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/romshark/datapages/modules/messaging"
+	"github.com/romshark/datapages/modules/messaging/inmem"
+)
+
+var (
+	_ messaging.Broker          = (*MessageBroker)(nil)
+	_ messaging.Pinger          = (*MessageBroker)(nil)
+	_ messaging.StatusNotifier  = (*MessageBroker)(nil)
+	_ messaging.HeaderPublisher = (*MessageBroker)(nil)
+)
+
+// ErrClosed is returned when subscribing on a closed broker.
+var ErrClosed = errors.New("broker closed")
+
+// reconnectWait is how long the receiver waits after a failed read
+// before it reads again, which reconnects.
+const reconnectWait = time.Second
+
+// envelopePrefix starts the payload of a message sent in an envelope,
+// which no JSON or text payload does.
+const envelopePrefix = "\x00datapages-envelope:"
+
+// envelope is the JSON following envelopePrefix.
+type envelope struct {
+	Header messaging.Header `json:"header,omitempty"`
+	Data   []byte           `json:"data"`
+}
+
+// MessageBroker is a Redis Pub/Sub backed message broker.
+//
+// All subscriptions of a broker share one Redis connection, which is opened
+// with the first subscription and subscribes to every channel and pattern
+// once, however many subscriptions hold it.
+type MessageBroker struct {
+	rdb    redis.UniversalClient
+	conf   Config
+	status messaging.ConnStatus
+
+	// ioLock orders the subscribes and unsubscribes sent to Redis like their
+	// bookkeeping under lock, which isn't held while they're sent.
+	ioLock sync.Mutex
+	lock   sync.Mutex
+	ps     *redis.PubSub // nil until the first subscription.
+	// channels holds what ps is subscribed to by key, see redisKey.
+	channels map[string]*channel
+	// pings holds the pending confirmations by ping payload.
+	// Redis answers the commands of a connection in order, the pong
+	// of a ping sent after a SUBSCRIBE confirms the subscription.
+	pings    map[string]chan struct{}
+	nextPing uint64
+	closed   bool
+	done     chan struct{} // Closed once the receiver has stopped.
+}
+
+// Config configures the broker.
+type Config struct {
+	// ChanBuffer is how many messages a subscription buffers.
+	// Non-positive selects messaging.DefaultBrokerChanBuffer.
+	ChanBuffer int
+}
+
+// channel is a Redis channel or pattern subscription
+// shared by the subscriptions that hold it.
+type channel struct {
+	name    string
+	pattern bool
+	subs    map[*redisSub]struct{}
+	// ready is closed once Redis confirmed the subscription.
+	ready chan struct{}
+}
+
+type redisSub struct {
+	broker   *MessageBroker
+	ch       chan messaging.Message
+	subjects []string
+	keys     []string // keys[i] is the key of subjects[i].
+	metrics  messaging.Metrics
+	closed   bool // Guarded by broker.lock.
+}
+
+// New creates a broker on rdb. The broker doesn't close rdb, [MessageBroker.Close]
+// only closes the connection the subscriptions share.
+func New(rdb redis.UniversalClient, conf Config) *MessageBroker {
+	if conf.ChanBuffer <= 0 {
+		conf.ChanBuffer = messaging.DefaultBrokerChanBuffer
+	}
+	return &MessageBroker{
+		rdb:      rdb,
+		conf:     conf,
+		channels: make(map[string]*channel),
+		pings:    make(map[string]chan struct{}),
+	}
+}
+
+// Close ends every subscription and closes the connection they share.
+// Subscribing after Close returns ErrClosed.
+func (b *MessageBroker) Close() error {
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return nil
+	}
+	b.closed = true
+	for _, c := range b.channels {
+		for s := range c.subs {
+			if !s.closed {
+				s.closeLocked()
+			}
+		}
+	}
+	clear(b.channels)
+	for _, ready := range b.pings {
+		close(ready)
+	}
+	clear(b.pings)
+	ps, done := b.ps, b.done
+	b.lock.Unlock()
+
+	if ps == nil {
+		return nil
+	}
+	err := ps.Close()
+	<-done
+	return err
+}
+
+// Ping implements messaging.Pinger. It pings Redis through the client
+// the broker publishes with.
+func (b *MessageBroker) Ping(ctx context.Context) error {
+	return b.rdb.Ping(ctx).Err()
+}
+
+// Status implements messaging.StatusNotifier. The broker is down from
+// a failed read of the connection the subscriptions share until it's
+// connected again, and up before the first subscription opened it.
+func (b *MessageBroker) Status() (up bool, changed <-chan struct{}) {
+	return b.status.Status()
+}
+
+// Publish implements messaging.Broker.
+func (b *MessageBroker) Publish(
+	ctx context.Context,
+	metrics messaging.Metrics,
+	subject string,
+	data []byte,
+) error {
+	return b.PublishMessage(ctx, metrics, messaging.Message{Subject: subject, Data: data})
+}
+
+// PublishMessage implements messaging.HeaderPublisher. The message is sent
+// in an envelope when it has headers, or when its data could be
+// mistaken for one.
+func (b *MessageBroker) PublishMessage(
+	ctx context.Context, metrics messaging.Metrics, msg messaging.Message,
+) error {
+	payload := msg.Data
+	if len(msg.Header) > 0 || bytes.HasPrefix(msg.Data, []byte(envelopePrefix)) {
+		env, err := json.Marshal(envelope{Header: msg.Header, Data: msg.Data})
+		if err != nil {
+			return err
+		}
+		payload = append([]byte(envelopePrefix), env...)
+	}
+	if err := b.rdb.Publish(ctx, msg.Subject, payload).Err(); err != nil {
+		return err
+	}
+	metrics.OnPublish(msg.Subject)
+	return nil
+}
+
+// Subscribe implements messaging.Broker. It returns once Redis confirmed
+// the subscription, a message published after that is received.
+func (b *MessageBroker) Subscribe(
+	ctx context.Context, metrics messaging.Metrics, subjects ...string,
+) (messaging.Subscription, error) {
+	s := &redisSub{
+		broker:   b,
+		ch:       make(chan messaging.Message, b.conf.ChanBuffer),
+		subjects: subjects,
+		keys:     make([]string, len(subjects)),
+		metrics:  metrics,
+	}
+
+	b.ioLock.Lock()
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		b.ioLock.Unlock()
+		return nil, ErrClosed
+	}
+	if b.ps == nil {
+		b.ps = b.rdb.Subscribe(context.Background())
+		b.done = make(chan struct{})
+		go b.receive(b.ps, b.done)
+	}
+
+	var names, patterns []string
+	var ready chan struct{}
+	wait := make([]chan struct{}, 0, len(subjects))
+	for i, subject := range subjects {
+		key, name, pattern := redisKey(subject)
+		s.keys[i] = key
+		c := b.channels[key]
+		if c == nil {
+			if ready == nil {
+				ready = make(chan struct{})
+			}
+			c = &channel{
+				name:    name,
+				pattern: pattern,
+				subs:    make(map[*redisSub]struct{}),
+				ready:   ready,
+			}
+			b.channels[key] = c
+			if pattern {
+				patterns = append(patterns, name)
+			} else {
+				names = append(names, name)
+			}
+		}
+		c.subs[s] = struct{}{}
+		wait = append(wait, c.ready)
+	}
+
+	var payload string
+	if ready != nil {
+		// The pong may arrive before ps.Ping returns.
+		payload = strconv.FormatUint(b.nextPing, 10)
+		b.nextPing++
+		b.pings[payload] = ready
+	}
+	ps := b.ps
+	b.lock.Unlock()
+
+	var err error
+	if ready != nil {
+		err = b.subscribe(ctx, ps, names, patterns, payload)
+	}
+	b.ioLock.Unlock()
+
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	for _, ready := range wait {
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			s.Close()
+			return nil, ctx.Err()
+		}
+	}
+
+	b.lock.Lock()
+	closed := s.closed
+	b.lock.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	return s, nil
+}
+
+// subscribe subscribes ps to the channels and patterns and pings it with
+// payload, whose pong confirms the subscription. The caller holds ioLock.
+func (b *MessageBroker) subscribe(
+	ctx context.Context, ps *redis.PubSub, names, patterns []string, payload string,
+) error {
+	err := func() error {
+		if len(names) > 0 {
+			if err := ps.Subscribe(ctx, names...); err != nil {
+				return err
+			}
+		}
+		if len(patterns) > 0 {
+			if err := ps.PSubscribe(ctx, patterns...); err != nil {
+				return err
+			}
+		}
+		return ps.Ping(ctx, payload)
+	}()
+	if err != nil {
+		b.lock.Lock()
+		if ready, ok := b.pings[payload]; ok {
+			delete(b.pings, payload)
+			close(ready)
+		}
+		b.lock.Unlock()
+	}
+	return err
+}
+
+// receive delivers what arrives on ps until ps is closed.
+func (b *MessageBroker) receive(ps *redis.PubSub, done chan struct{}) {
+	defer close(done)
+	ctx := context.Background()
+	lost := false
+	for {
+		msg, err := ps.Receive(ctx)
+		if err != nil {
+			b.lock.Lock()
+			closed := b.closed
+			b.lock.Unlock()
+			if closed {
+				return
+			}
+			// ps reconnects and subscribes again with the next read.
+			lost = true
+			b.status.Set(false)
+			time.Sleep(reconnectWait)
+			continue
+		}
+		if lost {
+			// The pings of the lost connection will never be answered. The
+			// connection they're sent on now has been subscribed again first.
+			lost = false
+			b.status.Set(true)
+			b.repingPending(ctx, ps)
+		}
+		switch m := msg.(type) {
+		case *redis.Message:
+			b.deliver(m)
+		case *redis.Pong:
+			b.lock.Lock()
+			if ready, ok := b.pings[m.Payload]; ok {
+				delete(b.pings, m.Payload)
+				close(ready)
+			}
+			b.lock.Unlock()
+		}
+	}
+}
+
+func (b *MessageBroker) repingPending(ctx context.Context, ps *redis.PubSub) {
+	b.lock.Lock()
+	payloads := make([]string, 0, len(b.pings))
+	for payload := range b.pings {
+		payloads = append(payloads, payload)
+	}
+	b.lock.Unlock()
+	for _, payload := range payloads {
+		_ = ps.Ping(ctx, payload)
+	}
+}
+
+// deliver passes m to the subscriptions of the channel or pattern it arrived
+// on whose subjects match it. Redis sends a message once for every pattern
+// that matches, a subscription takes it only from the first of its subjects
+// that does so it receives the message once.
+func (b *MessageBroker) deliver(m *redis.Message) {
+	key := "c:" + m.Channel
+	if m.Pattern != "" {
+		key = "p:" + m.Pattern
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	c := b.channels[key]
+	if c == nil {
+		return // Unsubscribed meanwhile.
+	}
+	msg := message(m)
+	for s := range c.subs {
+		if s.keyFor(m.Channel) != key {
+			continue
+		}
+		select {
+		case s.ch <- msg:
+		default: // Drop if subscriber is slow (matches NATS core semantics).
+			s.metrics.OnDeliveryDropped()
+		}
+	}
+}
+
+// message returns the message m carries, unwrapping its envelope.
+// A payload that merely looks like one is delivered as is.
+func message(m *redis.Message) messaging.Message {
+	msg := messaging.Message{Subject: m.Channel, Data: []byte(m.Payload)}
+	if env, ok := strings.CutPrefix(m.Payload, envelopePrefix); ok {
+		var e envelope
+		if json.Unmarshal([]byte(env), &e) == nil {
+			msg.Data, msg.Header = e.Data, e.Header
+		}
+	}
+	return msg
+}
+
+// keyFor returns the key of the first subject of s matching subject.
+func (s *redisSub) keyFor(subject string) string {
+	for i, pattern := range s.subjects {
+		if inmem.Matches(pattern, subject) {
+			return s.keys[i]
+		}
+	}
+	return ""
+}
+
+// redisKey returns what subject subscribes to: the channel of the same name
+// for a literal subject, and a pattern for one with wildcards. The key tells
+// the two apart, a pattern may be the name of a channel as well.
+func redisKey(subject string) (key, name string, pattern bool) {
+	if !hasWildcard(subject) {
+		return "c:" + subject, subject, false
+	}
+	var glob strings.Builder
+	for i, token := range strings.Split(subject, ".") {
+		if i > 0 {
+			glob.WriteByte('.')
+		}
+		if token == "*" || token == ">" {
+			glob.WriteByte('*')
+			continue
+		}
+		for _, r := range token {
+			switch r {
+			case '*', '?', '[', ']', '\\':
+				glob.WriteByte('\\')
+			}
+			glob.WriteRune(r)
+		}
+	}
+	return "p:" + glob.String(), glob.String(), true
+}
+
+func hasWildcard(subject string) bool {
+	for token := range strings.SplitSeq(subject, ".") {
+		if token == "*" || token == ">" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *redisSub) C() <-chan messaging.Message {
+	return s.ch
+}
+
+func (s *redisSub) Close() {
+	b := s.broker
+	b.ioLock.Lock()
+	defer b.ioLock.Unlock()
+	b.lock.Lock()
+	if s.closed {
+		b.lock.Unlock()
+		return
+	}
+	s.closeLocked()
+
+	var names, patterns []string
+	for _, key := range s.keys {
+		c := b.channels[key]
+		if c == nil {
+			continue // Another subject of s had the same key.
+		}
+		delete(c.subs, s)
+		if len(c.subs) > 0 {
+			continue
+		}
+		delete(b.channels, key)
+		if c.pattern {
+			patterns = append(patterns, c.name)
+		} else {
+			names = append(names, c.name)
+		}
+	}
+	ps := b.ps
+	b.lock.Unlock()
+
+	// An unsubscribe that fails leaves the subscription to Redis,
+	// what arrives on it has no channel to be delivered to.
+	ctx := context.Background()
+	if len(names) > 0 {
+		_ = ps.Unsubscribe(ctx, names...)
+	}
+	if len(patterns) > 0 {
+		_ = ps.PUnsubscribe(ctx, patterns...)
+	}
+}
+
+// closeLocked closes the channel of s.
+func (s *redisSub) closeLocked() {
+	s.closed = true
+	close(s.ch)
+}
@@ -0,0 +1,279 @@
+package redispubsub_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages/modules/messaging"
+	"github.com/romshark/datapages/modules/messaging/redispubsub"
+)
+
+// noMetrics is a Metrics that records nothing.
+type noMetrics struct{}
+
+func (noMetrics) OnPublish(string)   {}
+func (noMetrics) OnDeliveryDropped() {}
+
+type countingMetrics struct{ dropped atomic.Int64 }
+
+func (*countingMetrics) OnPublish(string)     {}
+func (m *countingMetrics) OnDeliveryDropped() { m.dropped.Add(1) }
+
+func newBroker(t *testing.T, conf redispubsub.Config) (*miniredis.Miniredis, *redispubsub.MessageBroker) {
+	t.Helper()
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { require.NoError(t, rdb.Close()) })
+	b := redispubsub.New(rdb, conf)
+	t.Cleanup(func() { require.NoError(t, b.Close()) })
+	return m, b
+}
+
+func subscribe(
+	t *testing.T, b *redispubsub.MessageBroker, subjects ...string,
+) messaging.Subscription {
+	t.Helper()
+	sub, err := b.Subscribe(t.Context(), noMetrics{}, subjects...)
+	require.NoError(t, err)
+	t.Cleanup(sub.Close)
+	return sub
+}
+
+func receive(t *testing.T, sub messaging.Subscription) messaging.Message {
+	t.Helper()
+	select {
+	case msg, ok := <-sub.C():
+		require.True(t, ok, "subscription closed")
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message")
+		return messaging.Message{}
+	}
+}
+
+// requireNothing publishes a marker on marker, which sub is subscribed to,
+// and requires it to be the next message. Messages published before it
+// are delivered before it.
+func requireNothing(
+	t *testing.T, b *redispubsub.MessageBroker, sub messaging.Subscription, marker string,
+) {
+	t.Helper()
+	require.NoError(t, b.Publish(t.Context(), noMetrics{}, marker, nil))
+	require.Equal(t, marker, receive(t, sub).Subject)
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	_, b := newBroker(t, redispubsub.Config{})
+	sub := subscribe(t, b, "a.b")
+
+	require.NoError(t, b.Publish(t.Context(), noMetrics{}, "a.b", []byte("x")))
+	msg := receive(t, sub)
+	require.Equal(t, "a.b", msg.Subject)
+	require.Equal(t, []byte("x"), msg.Data)
+	require.Nil(t, msg.Header)
+}
+
+// TestHeaders covers the envelope the headers are sent in, which is sent
+// for data that looks like one as well, and a message of another Redis
+// client, which arrives as it was published.
+func TestHeaders(t *testing.T) {
+	m, b := newBroker(t, redispubsub.Config{})
+	sub := subscribe(t, b, "a")
+
+	require.NoError(t, messaging.PublishMessage(t.Context(), b, noMetrics{}, messaging.Message{
+		Subject: "a", Data: []byte("x"), Header: messaging.Header{"k": "v"},
+	}))
+	msg := receive(t, sub)
+	require.Equal(t, []byte("x"), msg.Data)
+	require.Equal(t, messaging.Header{"k": "v"}, msg.Header)
+
+	tricky := []byte("\x00datapages-envelope:{}")
+	require.NoError(t, b.Publish(t.Context(), noMetrics{}, "a", tricky))
+	msg = receive(t, sub)
+	require.Equal(t, tricky, msg.Data)
+	require.Nil(t, msg.Header)
+
+	m.Publish("a", `{"plain":true}`)
+	msg = receive(t, sub)
+	require.Equal(t, []byte(`{"plain":true}`), msg.Data)
+	require.Nil(t, msg.Header)
+}
+
+func TestWildcardDelivery(t *testing.T) {
+	for name, tt := range map[string]struct {
+		pattern string
+		subject string
+		want    bool
+	}{
+		"star matches one token":    {"a.*", "a.b", true},
+		"star is exactly one token": {"a.*", "a.b.c", false},
+		"star in the middle":        {"a.*.c", "a.b.c", true},
+		"star in the middle spans":  {"a.*.c", "a.b.b.c", false},
+		"gt matches the rest":       {"a.>", "a.b.c", true},
+		"gt needs a token":          {"a.>", "a", false},
+		"glob characters literal":   {"a?[b]\\.*", "a?[b]\\.c", true},
+		"glob characters unmatched": {"a?[b]\\.*", "ax[b]\\.c", false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, b := newBroker(t, redispubsub.Config{})
+			sub := subscribe(t, b, tt.pattern, "marker")
+
+			require.NoError(t, b.Publish(t.Context(), noMetrics{}, tt.subject, nil))
+			if tt.want {
+				require.Equal(t, tt.subject, receive(t, sub).Subject)
+			}
+			requireNothing(t, b, sub, "marker")
+		})
+	}
+}
+
+// TestOneMessagePerSubscription covers subjects of a subscription matching
+// the same message, which Redis sends once for every one of them.
+func TestOneMessagePerSubscription(t *testing.T) {
+	_, b := newBroker(t, redispubsub.Config{})
+	both := subscribe(t, b, "room.*", "room.r1", "room.>", "marker")
+	scoped := subscribe(t, b, "room.r1", "marker")
+
+	require.NoError(t, b.Publish(t.Context(), noMetrics{}, "room.r1", nil))
+	for _, sub := range []messaging.Subscription{both, scoped} {
+		require.Equal(t, "room.r1", receive(t, sub).Subject)
+		requireNothing(t, b, sub, "marker")
+	}
+}
+
+func TestDrop(t *testing.T) {
+	_, b := newBroker(t, redispubsub.Config{ChanBuffer: 1})
+	var metrics countingMetrics
+	sub, err := b.Subscribe(t.Context(), &metrics, "a")
+	require.NoError(t, err)
+	t.Cleanup(sub.Close)
+
+	for range 3 {
+		require.NoError(t, b.Publish(t.Context(), noMetrics{}, "a", nil))
+	}
+	require.Eventually(t, func() bool {
+		return metrics.dropped.Load() == 2
+	}, time.Second, time.Millisecond)
+	require.Len(t, sub.C(), 1)
+}
+
+// TestSharedSubscription covers a channel and a pattern held by several
+// subscriptions. Redis stays subscribed until the last one closes.
+func TestSharedSubscription(t *testing.T) {
+	m, b := newBroker(t, redispubsub.Config{})
+	first := subscribe(t, b, "a", "b.*")
+	second := subscribe(t, b, "a", "b.*")
+	require.Equal(t, map[string]int{"a": 1}, m.PubSubNumSub("a"))
+	require.Equal(t, 1, m.PubSubNumPat())
+
+	first.Close()
+	_, ok := <-first.C()
+	require.False(t, ok)
+	require.NoError(t, b.Publish(t.Context(), noMetrics{}, "b.x", nil))
+	require.Equal(t, "b.x", receive(t, second).Subject)
+
+	second.Close()
+	require.Eventually(t, func() bool {
+		return m.PubSubNumSub("a")["a"] == 0 && m.PubSubNumPat() == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestClose(t *testing.T) {
+	_, b := newBroker(t, redispubsub.Config{})
+	sub, err := b.Subscribe(t.Context(), noMetrics{}, "a", "a.*")
+	require.NoError(t, err)
+
+	require.NoError(t, b.Close())
+	_, ok := <-sub.C()
+	require.False(t, ok)
+	sub.Close() // No-op.
+
+	_, err = b.Subscribe(t.Context(), noMetrics{}, "a")
+	require.ErrorIs(t, err, redispubsub.ErrClosed)
+}
+
+func TestSubscribeCanceled(t *testing.T) {
+	m, b := newBroker(t, redispubsub.Config{})
+	m.SetError("unavailable")
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	_, err := b.Subscribe(ctx, noMetrics{}, "a")
+	require.Error(t, err)
+
+	m.SetError("")
+	sub := subscribe(t, b, "a")
+	require.NoError(t, b.Publish(t.Context(), noMetrics{}, "a", nil))
+	require.Equal(t, "a", receive(t, sub).Subject)
+}
+
+// TestStatus covers the broker reporting the loss of its connection
+// and the reconnect.
+func TestStatus(t *testing.T) {
+	m, b := newBroker(t, redispubsub.Config{})
+	up, changed := b.Status()
+	require.True(t, up, "the broker is up before it's connected")
+	subscribe(t, b, "a")
+
+	m.Close()
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the lost connection wasn't reported")
+	}
+	up, changed = b.Status()
+	require.False(t, up)
+
+	require.NoError(t, m.Restart())
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the reconnect wasn't reported")
+	}
+	up, _ = b.Status()
+	require.True(t, up)
+}
+
+// TestReconnect covers a Redis restart, after which the broker subscribes
+// to the channels and patterns again.
+func TestReconnect(t *testing.T) {
+	m, b := newBroker(t, redispubsub.Config{})
+	sub := subscribe(t, b, "a", "b.*")
+
+	m.Close()
+	require.NoError(t, m.Restart())
+
+	for _, subject := range []string{"a", "b.c"} {
+		require.Eventually(t, func() bool {
+			_ = b.Publish(t.Context(), noMetrics{}, subject, nil)
+			select {
+			case msg := <-sub.C():
+				return msg.Subject == subject
+			case <-time.After(10 * time.Millisecond):
+				return false
+			}
+		}, 5*time.Second, time.Millisecond)
+	}
+
+	up, _ := b.Status()
+	require.True(t, up)
+
+	// Subscribing after the restart is confirmed by the new connection.
+	late := subscribe(t, b, "c")
+	require.NoError(t, b.Publish(t.Context(), noMetrics{}, "c", nil))
+	require.Equal(t, "c", receive(t, late).Subject)
+}
+
+func TestPing(t *testing.T) {
+	m, b := newBroker(t, redispubsub.Config{})
+	require.NoError(t, b.Ping(t.Context()))
+
+	m.Close()
+	require.Error(t, b.Ping(t.Context()))
+}
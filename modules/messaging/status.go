@@ -0,0 +1,96 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrBrokerUnavailable is returned when a message is dispatched while
+// the broker reports through [StatusNotifier] that it's down.
+// RecoverError receives it like any other error, and shows the visitor
+// that the action didn't go through.
+var ErrBrokerUnavailable = errors.New("message broker unavailable")
+
+// StatusNotifier is an optional interface that message brokers implement to
+// report whether they're connected. While a broker is down, the generated
+// server closes the streams it serves, which makes Datastar reconnect and
+// render the page afresh once it's back. Dispatching fails with
+// ErrBrokerUnavailable, and streams opening are refused with it.
+//
+// A broker that doesn't implement it counts as always connected.
+type StatusNotifier interface {
+	// Status reports whether the broker is connected and returns a channel
+	// that's closed on the next change of that.
+	Status() (up bool, changed <-chan struct{})
+}
+
+// ConnStatus implements StatusNotifier for brokers that learn of their
+// connection state from callbacks. The zero value is up.
+// It's safe for concurrent use.
+type ConnStatus struct {
+	lock sync.Mutex
+	down bool
+	// changed is closed by the next Set that changes the status.
+	// It's made on demand, nil when no one waits.
+	changed chan struct{}
+}
+
+var _ StatusNotifier = (*ConnStatus)(nil)
+
+// Status implements StatusNotifier.
+func (s *ConnStatus) Status() (up bool, changed <-chan struct{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.changed == nil {
+		s.changed = make(chan struct{})
+	}
+	return !s.down, s.changed
+}
+
+// Set records whether the broker is connected. Only a change wakes
+// whoever waits on the channel Status returned.
+func (s *ConnStatus) Set(up bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.down != up {
+		return
+	}
+	s.down = !up
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+}
+
+// StatusOf returns the Status of b when it implements StatusNotifier.
+// Otherwise b counts as connected for good and changed is nil,
+// which never becomes ready.
+func StatusOf(b any) (up bool, changed <-chan struct{}) {
+	if sn, ok := b.(StatusNotifier); ok {
+		return sn.Status()
+	}
+	return true, nil
+}
+
+// Pinger is an optional interface that message brokers implement to check
+// a round trip to the server behind them. The metrics server of a generated
+// server built with WithPrometheus calls it on every readiness probe.
+type Pinger interface {
+	// Ping returns an error when the broker can't reach its server
+	// before ctx is done.
+	Ping(ctx context.Context) error
+}
+
+// Ping returns the result of the Ping of b when it implements Pinger.
+// Otherwise it returns ErrBrokerUnavailable when [StatusOf] reports b down,
+// and nil when it doesn't.
+func Ping(ctx context.Context, b any) error {
+	if p, ok := b.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	if up, _ := StatusOf(b); !up {
+		return ErrBrokerUnavailable
+	}
+	return nil
+}
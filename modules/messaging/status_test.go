@@ -0,0 +1,89 @@
+package messaging_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages/modules/messaging"
+)
+
+// isClosed reports whether ch is closed without waiting.
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestConnStatus(t *testing.T) {
+	var s messaging.ConnStatus
+	up, changed := s.Status()
+	require.True(t, up, "the zero value is down")
+	require.NotNil(t, changed)
+
+	s.Set(true)
+	require.False(t, isClosed(changed), "a Set without a change woke the waiters")
+
+	s.Set(false)
+	require.True(t, isClosed(changed))
+	up, changed = s.Status()
+	require.False(t, up)
+	require.False(t, isClosed(changed))
+
+	s.Set(true)
+	require.True(t, isClosed(changed))
+	up, _ = s.Status()
+	require.True(t, up)
+}
+
+func TestStatusOf(t *testing.T) {
+	b := newBroker(t)
+	up, changed := messaging.StatusOf(b)
+	require.True(t, up, "a broker without StatusNotifier is down")
+	require.Nil(t, changed)
+
+	// The status of an intercepted broker is the one of the broker behind it.
+	sb := &statusBroker{Broker: b}
+	sb.Set(false)
+	up, _ = messaging.StatusOf(messaging.Intercept(sb, messaging.Interceptor{}))
+	require.False(t, up)
+	up, changed = messaging.StatusOf(messaging.Intercept(b, messaging.Interceptor{}))
+	require.True(t, up)
+	require.Nil(t, changed)
+}
+
+func TestPing(t *testing.T) {
+	ctx := context.Background()
+	b := newBroker(t)
+	require.NoError(t, messaging.Ping(ctx, b))
+
+	// Without Pinger the status decides.
+	sb := &statusBroker{Broker: b}
+	sb.Set(false)
+	require.ErrorIs(t, messaging.Ping(ctx, sb), messaging.ErrBrokerUnavailable)
+
+	errPing := errors.New("no route to host")
+	pb := &pingBroker{Broker: b, err: errPing}
+	require.ErrorIs(t, messaging.Ping(ctx, pb), errPing)
+	i := messaging.Intercept(pb, messaging.Interceptor{})
+	require.ErrorIs(t, messaging.Ping(ctx, i), errPing)
+	i = messaging.Intercept(sb, messaging.Interceptor{})
+	require.ErrorIs(t, messaging.Ping(ctx, i), messaging.ErrBrokerUnavailable)
+}
+
+type pingBroker struct {
+	messaging.Broker
+	err error
+}
+
+func (b *pingBroker) Ping(context.Context) error { return b.err }
+
+type statusBroker struct {
+	messaging.Broker
+	messaging.ConnStatus
+}
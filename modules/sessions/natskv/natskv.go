@@ -25,6 +25,8 @@ import (
 	"github.com/romshark/datapages/modules/sessions"
 )
 
+var _ sessions.Pinger = (*SessionManager[struct{}])(nil)
+
 // DefaultBucket is the default bucket name for the NATS KV Store based session manager.
 const DefaultBucket = "SESSIONS"
 
@@ -100,6 +102,7 @@ func New[Data any](
 
 	return &SessionManager[Data]{
 		conf:                  conf,
+		conn:                  conn,
 		kv:                    kv,
 		aeads:                 aeads,
 		sessionTokenGenerator: sessionTokenGenerator,
@@ -123,11 +126,25 @@ type Config struct {
 // SessionManager manages sessions backed by NATS KV.
 type SessionManager[Data any] struct {
 	conf                  Config
+	conn                  *nats.Conn
 	kv                    nats.KeyValue
 	aeads                 []cipher.AEAD // [0] is primary
 	sessionTokenGenerator SessionTokenGenerator
 }
 
+// Ping implements sessions.Pinger. It flushes the connection and waits for
+// the server to answer, then reads the status of the bucket, which fails when
+// it's gone. The second step is bound by the JetStream timeout, not ctx.
+func (s *SessionManager[Data]) Ping(ctx context.Context) error {
+	if err := s.conn.FlushWithContext(ctx); err != nil {
+		return err
+	}
+	if _, err := s.kv.Status(); err != nil {
+		return fmt.Errorf("reading KV bucket status: %w", err)
+	}
+	return nil
+}
+
 // kvRecord wraps session data with its encrypted token for storage.
 type kvRecord struct {
 	Token string          `json:"token"`
@@ -164,6 +164,21 @@ func TestNew(t *testing.T) {
 	})
 }
 
+func TestPing(t *testing.T) {
+	conn := setupNATS(t)
+	sm, err := natskv.New[testSession](conn, tokGen, natskv.Config{
+		EncryptionKey: validKey(),
+		KVConfig:      nats.KeyValueConfig{Bucket: "PING"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, sm.Ping(t.Context()))
+
+	js, err := conn.JetStream()
+	require.NoError(t, err)
+	require.NoError(t, js.DeleteKeyValue("PING"))
+	require.Error(t, sm.Ping(t.Context()), "the bucket is gone")
+}
+
 func TestSaveSession(t *testing.T) {
 	conn := setupNATS(t)
 	sm := newManager(t, conn, natskv.Config{
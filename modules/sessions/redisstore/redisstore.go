@@ -0,0 +1,574 @@
+// Package redisstore provides a session manager backed by Redis.
+//
+// A session is stored as JSON under "{KeyPrefix}session:{token}", the cookie
+// value is the token. The key expires with the session: at the ExpiresAt of
+// its record, or Config.TTL after its creation when the record has none.
+//
+// Every user has a sorted set "{KeyPrefix}user:{userID}" of the tokens of their
+// sessions by expiry time, which CloseAllUserSessions and UserSessions read.
+// It expires with the last of them.
+//
+// NotifyClosed learns about a session closed by another instance, or expired,
+// from keyspace notifications, which Redis sends only when notify-keyspace-events
+// enables them for keys ("K"), deletions ("g"), expiry ("x") and eviction ("e"):
+//
+//	CONFIG SET notify-keyspace-events Kgxe
+//
+// Without them, only the sessions closed by the same manager are reported.
+// Redis sends the expiry when it removes the key, which may lag behind
+// the TTL running out. Datapages rejects an expired session either way.
+package redisstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/romshark/datapages/modules/sessions"
+)
+
+// DefaultKeyPrefix is the default prefix of the keys of the session manager.
+const DefaultKeyPrefix = "datapages:"
+
+var (
+	// ErrSessionNotFound is returned when a session is not found.
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrEmptyUserID is returned when a userID is empty.
+	ErrEmptyUserID = errors.New("userID must not be empty")
+
+	// ErrClosed is returned by NotifyClosed after Close.
+	ErrClosed = errors.New("session manager closed")
+)
+
+var (
+	_ sessions.Manager[struct{}] = (*SessionManager[struct{}])(nil)
+	_ sessions.Pinger            = (*SessionManager[struct{}])(nil)
+)
+
+// reconnectWait is how long the receiver waits after a failed read
+// before it reads again, which reconnects.
+const reconnectWait = time.Second
+
+// writeScript stores a session and indexes it for its user.
+//
+// KEYS[1] is the session, KEYS[2] the index of its user.
+// ARGV[1] is the record, ARGV[2] the token, ARGV[3] the expiry
+// in Unix milliseconds or 0 for none, ARGV[4] the current time
+// in Unix milliseconds. ARGV[5] is "1" to only overwrite an existing
+// session, which keeps its expiry when ARGV[3] is 0.
+//
+// An index entry is scored by the expiry of its session, -1 for none.
+// Entries of expired sessions are pruned and the index expires with the
+// last of its sessions.
+var writeScript = redis.NewScript(`
+local at = tonumber(ARGV[3])
+local set = {'SET', KEYS[1], ARGV[1]}
+if ARGV[5] == '1' then
+	table.insert(set, 'XX')
+	if at == 0 then
+		table.insert(set, 'KEEPTTL')
+	end
+end
+if at > 0 then
+	table.insert(set, 'PXAT')
+	table.insert(set, ARGV[3])
+end
+if not redis.call(unpack(set)) then
+	return 0
+end
+if ARGV[5] == '1' and at == 0 then
+	return 0
+end
+local score = at
+if at == 0 then
+	score = -1
+end
+redis.call('ZADD', KEYS[2], score, ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[2], 0, ARGV[4])
+if redis.call('ZCOUNT', KEYS[2], -1, -1) > 0 then
+	redis.call('PERSIST', KEYS[2])
+else
+	local last = redis.call('ZRANGE', KEYS[2], -1, -1, 'WITHSCORES')
+	redis.call('PEXPIREAT', KEYS[2], last[2])
+end
+return 1
+`)
+
+// Config configures the session manager.
+type Config struct {
+	// KeyPrefix is what every key of the session manager starts with.
+	// Empty selects DefaultKeyPrefix.
+	KeyPrefix string
+
+	// TTL is how long a session whose record has no ExpiresAt lives
+	// after its creation. Zero keeps such sessions until they're closed.
+	TTL time.Duration
+}
+
+type watcher struct {
+	ctx context.Context
+	fn  func()
+}
+
+// SessionManager manages sessions backed by Redis.
+type SessionManager[Data any] struct {
+	rdb      *redis.Client
+	tokenGen sessions.TokenGenerator
+	conf     Config
+	// keyspace is the channel prefix of the keyspace notifications
+	// of the sessions, the token follows it.
+	keyspace string
+
+	lock     sync.Mutex
+	ps       *redis.PubSub                 // nil until the first NotifyClosed.
+	watchers map[string]map[uint64]watcher // token -> watcherID -> watcher
+	nextID   uint64
+	closed   bool
+	done     chan struct{} // Closed once the receiver has stopped.
+}
+
+// New creates a Redis backed session manager. Close stops the keyspace
+// notification subscription, it doesn't close rdb.
+func New[Data any](
+	rdb *redis.Client, tokenGen sessions.TokenGenerator, conf Config,
+) *SessionManager[Data] {
+	if conf.KeyPrefix == "" {
+		conf.KeyPrefix = DefaultKeyPrefix
+	}
+	return &SessionManager[Data]{
+		rdb:      rdb,
+		tokenGen: tokenGen,
+		conf:     conf,
+		keyspace: "__keyspace@" + strconv.Itoa(rdb.Options().DB) + "__:" +
+			conf.KeyPrefix + "session:",
+		watchers: make(map[string]map[uint64]watcher),
+	}
+}
+
+func (m *SessionManager[Data]) sessionKey(token string) string {
+	return m.conf.KeyPrefix + "session:" + token
+}
+
+func (m *SessionManager[Data]) userKey(userID string) string {
+	return m.conf.KeyPrefix + "user:" + userID
+}
+
+// Close stops the keyspace notification subscription.
+// The watchers registered by NotifyClosed are no longer called.
+func (m *SessionManager[Data]) Close() error {
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return nil
+	}
+	m.closed = true
+	clear(m.watchers)
+	ps, done := m.ps, m.done
+	m.lock.Unlock()
+
+	if ps == nil {
+		return nil
+	}
+	err := ps.Close()
+	<-done
+	return err
+}
+
+// Ping implements sessions.Pinger.
+func (m *SessionManager[Data]) Ping(ctx context.Context) error {
+	return m.rdb.Ping(ctx).Err()
+}
+
+// ReadSessionFromCookie returns the record associated with the cookie value.
+// The cookie value is the raw session token.
+func (m *SessionManager[Data]) ReadSessionFromCookie(cookieValue string) (
+	rec sessions.Record[Data], token string, ok bool, err error,
+) {
+	if cookieValue == "" {
+		return rec, "", false, nil
+	}
+	data, err := m.rdb.Get(context.Background(), m.sessionKey(cookieValue)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return rec, "", false, nil
+		}
+		return rec, "", false, fmt.Errorf("reading session: %w", err)
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return rec, "", false, nil
+	}
+	return rec, cookieValue, true, nil
+}
+
+// Session retrieves a session record by its token.
+func (m *SessionManager[Data]) Session(
+	ctx context.Context, token string,
+) (rec sessions.Record[Data], err error) {
+	data, err := m.rdb.Get(ctx, m.sessionKey(token)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return rec, ErrSessionNotFound
+		}
+		return rec, fmt.Errorf("getting session: %w", err)
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return rec, fmt.Errorf("unmarshaling session: %w", err)
+	}
+	return rec, nil
+}
+
+// CreateSession stores a new session and returns a token to be used as a cookie value.
+func (m *SessionManager[Data]) CreateSession(
+	ctx context.Context, rec sessions.Record[Data],
+) (string, error) {
+	if rec.UserID == "" {
+		return "", ErrEmptyUserID
+	}
+	token, err := m.tokenGen.Generate()
+	if err != nil {
+		return "", err
+	}
+	expiresAt := rec.ExpiresAt
+	if expiresAt.IsZero() && m.conf.TTL > 0 {
+		expiresAt = time.Now().Add(m.conf.TTL)
+	}
+	if err := m.write(ctx, token, rec, expiresAt, false); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// SaveSession overwrites the record for an existing token.
+// No-op if the session doesn't exist. The session keeps its expiry
+// unless the record has an ExpiresAt.
+func (m *SessionManager[Data]) SaveSession(
+	ctx context.Context, token string, rec sessions.Record[Data],
+) error {
+	if rec.UserID == "" {
+		return ErrEmptyUserID
+	}
+	return m.write(ctx, token, rec, rec.ExpiresAt, true)
+}
+
+func (m *SessionManager[Data]) write(
+	ctx context.Context, token string, rec sessions.Record[Data],
+	expiresAt time.Time, overwrite bool,
+) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshaling session: %w", err)
+	}
+	var at int64
+	if !expiresAt.IsZero() {
+		at = max(expiresAt.UnixMilli(), 1)
+	}
+	onlyOverwrite := "0"
+	if overwrite {
+		onlyOverwrite = "1"
+	}
+	err = writeScript.Run(ctx, m.rdb,
+		[]string{m.sessionKey(token), m.userKey(rec.UserID)},
+		data, token, at, time.Now().UnixMilli(), onlyOverwrite,
+	).Err()
+	if err != nil {
+		return fmt.Errorf("storing session: %w", err)
+	}
+	return nil
+}
+
+// CloseSession removes a session and notifies its watchers.
+// No-op and no error if the session doesn't exist.
+func (m *SessionManager[Data]) CloseSession(ctx context.Context, token string) error {
+	data, err := m.rdb.GetDel(ctx, m.sessionKey(token)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		return fmt.Errorf("deleting session: %w", err)
+	}
+	m.notify(token)
+
+	var rec struct{ UserID string }
+	if json.Unmarshal(data, &rec) != nil || rec.UserID == "" {
+		return nil // Left to UserSessions to prune.
+	}
+	if err := m.rdb.ZRem(ctx, m.userKey(rec.UserID), token).Err(); err != nil {
+		return fmt.Errorf("removing session from user index: %w", err)
+	}
+	return nil
+}
+
+// CloseAllUserSessions closes all sessions for a user.
+// Only sees sessions that exist at call time;
+// sessions created during the call are not closed.
+// If buffer is non-nil, appends tokens of closed sessions to it.
+func (m *SessionManager[Data]) CloseAllUserSessions(
+	ctx context.Context, buffer []string, userID string,
+) ([]string, error) {
+	if userID == "" {
+		return buffer, ErrEmptyUserID
+	}
+	userKey := m.userKey(userID)
+	tokens, err := m.rdb.ZRange(ctx, userKey, 0, -1).Result()
+	if err != nil {
+		return buffer, fmt.Errorf("reading user sessions: %w", err)
+	}
+	if len(tokens) == 0 {
+		return buffer, nil
+	}
+
+	dels := make([]*redis.IntCmd, len(tokens))
+	_, err = m.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, token := range tokens {
+			dels[i] = p.Del(ctx, m.sessionKey(token))
+		}
+		p.ZRem(ctx, userKey, toAny(tokens)...)
+		return nil
+	})
+	if err != nil {
+		return buffer, fmt.Errorf("deleting user sessions: %w", err)
+	}
+	for i, token := range tokens {
+		if dels[i].Val() == 0 {
+			continue // Expired or closed meanwhile.
+		}
+		m.notify(token)
+		if buffer != nil {
+			buffer = append(buffer, token)
+		}
+	}
+	return buffer, nil
+}
+
+// UserSessions returns an iterator over all current
+// sessions for a given user (snapshot, not streaming).
+// Yields (token, session) pairs.
+func (m *SessionManager[Data]) UserSessions(
+	ctx context.Context, userID string,
+) iter.Seq2[string, sessions.Record[Data]] {
+	return func(yield func(string, sessions.Record[Data]) bool) {
+		if userID == "" {
+			return
+		}
+		userKey := m.userKey(userID)
+		tokens, err := m.rdb.ZRange(ctx, userKey, 0, -1).Result()
+		if err != nil || len(tokens) == 0 {
+			return
+		}
+
+		gets := make([]*redis.StringCmd, len(tokens))
+		_, _ = m.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for i, token := range tokens {
+				gets[i] = p.Get(ctx, m.sessionKey(token))
+			}
+			return nil
+		})
+
+		var stale []any
+		for i, token := range tokens {
+			data, err := gets[i].Bytes()
+			if errors.Is(err, redis.Nil) {
+				stale = append(stale, token)
+				continue
+			}
+			var rec sessions.Record[Data]
+			if err != nil || json.Unmarshal(data, &rec) != nil {
+				continue
+			}
+			if !yield(token, rec) {
+				break
+			}
+		}
+		if len(stale) > 0 {
+			_ = m.rdb.ZRem(ctx, userKey, stale...).Err()
+		}
+	}
+}
+
+// NotifyClosed registers fn to be called when the session identified by token is closed.
+// If the session doesn't exist, fn is called immediately.
+// If ctx is already canceled, the watcher is not registered.
+// The watcher is automatically removed when ctx is canceled.
+func (m *SessionManager[Data]) NotifyClosed(
+	ctx context.Context, token string, fn func(),
+) error {
+	if err := m.subscribe(ctx); err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return ErrClosed
+	}
+	id := m.nextID
+	m.nextID++
+	ws := m.watchers[token]
+	if ws == nil {
+		ws = make(map[uint64]watcher)
+		m.watchers[token] = ws
+	}
+	ws[id] = watcher{ctx: ctx, fn: fn}
+	m.lock.Unlock()
+
+	// The watcher is registered before the check,
+	// a session closed from here on is seen.
+	n, err := m.rdb.Exists(ctx, m.sessionKey(token)).Result()
+	switch {
+	case err != nil:
+		m.take(token, id)
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("checking session: %w", err)
+	case n == 0:
+		if m.take(token, id) {
+			fn()
+		}
+		return nil
+	case ctx.Err() != nil:
+		m.take(token, id)
+		return nil
+	}
+
+	go func() {
+		<-ctx.Done()
+		m.take(token, id)
+	}()
+	return nil
+}
+
+// take removes the watcher of token with id
+// and reports whether it was still registered.
+func (m *SessionManager[Data]) take(token string, id uint64) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	ws := m.watchers[token]
+	if _, ok := ws[id]; !ok {
+		return false
+	}
+	delete(ws, id)
+	if len(ws) == 0 {
+		delete(m.watchers, token)
+	}
+	return true
+}
+
+// notify calls and removes the watchers of token.
+func (m *SessionManager[Data]) notify(token string) {
+	m.lock.Lock()
+	ws := m.watchers[token]
+	delete(m.watchers, token)
+	m.lock.Unlock()
+
+	for _, w := range ws {
+		if w.ctx.Err() == nil {
+			w.fn()
+		}
+	}
+}
+
+// subscribe subscribes to the keyspace notifications of the sessions,
+// unless that's done already, and returns once Redis confirmed it.
+func (m *SessionManager[Data]) subscribe(ctx context.Context) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	if m.ps != nil {
+		return nil
+	}
+	ps := m.rdb.PSubscribe(ctx, escapeGlob(m.keyspace)+"*")
+	if _, err := ps.Receive(ctx); err != nil {
+		_ = ps.Close()
+		return fmt.Errorf("subscribing to keyspace notifications: %w", err)
+	}
+	m.ps = ps
+	m.done = make(chan struct{})
+	go m.receive(ps, m.done)
+	return nil
+}
+
+// receive notifies the watchers of the sessions that Redis reports deleted,
+// expired or evicted until ps is closed.
+func (m *SessionManager[Data]) receive(ps *redis.PubSub, done chan struct{}) {
+	defer close(done)
+	ctx := context.Background()
+	lost := false
+	for {
+		msg, err := ps.Receive(ctx)
+		if err != nil {
+			m.lock.Lock()
+			closed := m.closed
+			m.lock.Unlock()
+			if closed {
+				return
+			}
+			// ps reconnects and subscribes again with the next read.
+			lost = true
+			time.Sleep(reconnectWait)
+			continue
+		}
+		if lost {
+			// What was closed while the connection was lost went unreported.
+			lost = false
+			m.recheck(ctx)
+		}
+		ev, ok := msg.(*redis.Message)
+		if !ok {
+			continue
+		}
+		switch ev.Payload {
+		case "del", "expired", "evicted":
+			m.notify(strings.TrimPrefix(ev.Channel, m.keyspace))
+		}
+	}
+}
+
+// recheck notifies the watchers of the sessions that no longer exist.
+func (m *SessionManager[Data]) recheck(ctx context.Context) {
+	m.lock.Lock()
+	tokens := make([]string, 0, len(m.watchers))
+	for token := range m.watchers {
+		tokens = append(tokens, token)
+	}
+	m.lock.Unlock()
+
+	for _, token := range tokens {
+		if n, err := m.rdb.Exists(ctx, m.sessionKey(token)).Result(); err == nil && n == 0 {
+			m.notify(token)
+		}
+	}
+}
+
+// escapeGlob escapes the characters of s that
+// a Redis pattern would interpret.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func toAny(s []string) []any {
+	a := make([]any, len(s))
+	for i, v := range s {
+		a[i] = v
+	}
+	return a
+}
@@ -0,0 +1,358 @@
+package redisstore_test
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages/modules/sessions"
+	"github.com/romshark/datapages/modules/sessions/redisstore"
+)
+
+type testSession struct {
+	Username string
+}
+
+var tokGen = sessions.DefaultTokenGenerator{}
+
+func newManager(
+	t *testing.T, conf redisstore.Config,
+) (*miniredis.Miniredis, *redisstore.SessionManager[testSession]) {
+	t.Helper()
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { require.NoError(t, rdb.Close()) })
+	s := redisstore.New[testSession](rdb, tokGen, conf)
+	t.Cleanup(func() { require.NoError(t, s.Close()) })
+	return m, s
+}
+
+func create(
+	t *testing.T, s *redisstore.SessionManager[testSession], rec sessions.Record[testSession],
+) string {
+	t.Helper()
+	token, err := s.CreateSession(t.Context(), rec)
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+	return token
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	_, s := newManager(t, redisstore.Config{})
+	rec := sessions.Record[testSession]{
+		UserID:   "u1",
+		IssuedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Data:     testSession{Username: "alice"},
+	}
+	token := create(t, s, rec)
+
+	got, gotToken, ok, err := s.ReadSessionFromCookie(token)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, token, gotToken)
+	require.Equal(t, rec, got)
+
+	got, err = s.Session(t.Context(), token)
+	require.NoError(t, err)
+	require.Equal(t, rec, got)
+}
+
+func TestCreateSessionEmptyUserID(t *testing.T) {
+	_, s := newManager(t, redisstore.Config{})
+	_, err := s.CreateSession(t.Context(), sessions.Record[testSession]{})
+	require.ErrorIs(t, err, redisstore.ErrEmptyUserID)
+}
+
+func TestReadSessionFromCookie(t *testing.T) {
+	m, s := newManager(t, redisstore.Config{})
+
+	for _, cookie := range []string{"", "unknown"} {
+		_, _, ok, err := s.ReadSessionFromCookie(cookie)
+		require.NoError(t, err)
+		require.False(t, ok)
+	}
+
+	require.NoError(t, m.Set(redisstore.DefaultKeyPrefix+"session:bad", "{"))
+	_, _, ok, err := s.ReadSessionFromCookie("bad")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	m.SetError("unavailable")
+	_, _, ok, err = s.ReadSessionFromCookie("unknown")
+	require.Error(t, err)
+	require.False(t, ok)
+}
+
+func TestSession(t *testing.T) {
+	_, s := newManager(t, redisstore.Config{})
+	_, err := s.Session(t.Context(), "unknown")
+	require.ErrorIs(t, err, redisstore.ErrSessionNotFound)
+}
+
+func TestKeyPrefix(t *testing.T) {
+	m, s := newManager(t, redisstore.Config{KeyPrefix: "app:"})
+	token := create(t, s, sessions.Record[testSession]{UserID: "u1"})
+	require.True(t, m.Exists("app:session:"+token))
+	require.True(t, m.Exists("app:user:u1"))
+}
+
+func TestSaveSession(t *testing.T) {
+	m, s := newManager(t, redisstore.Config{TTL: time.Hour})
+	token := create(t, s, sessions.Record[testSession]{UserID: "u1"})
+
+	m.FastForward(10 * time.Minute)
+	rec := sessions.Record[testSession]{UserID: "u1", Data: testSession{Username: "bob"}}
+	require.NoError(t, s.SaveSession(t.Context(), token, rec))
+	got, err := s.Session(t.Context(), token)
+	require.NoError(t, err)
+	require.Equal(t, rec, got)
+	// Without an ExpiresAt the session keeps its expiry.
+	require.InDelta(t, 50*time.Minute,
+		m.TTL(redisstore.DefaultKeyPrefix+"session:"+token), float64(time.Second))
+
+	// Saving a session that doesn't exist doesn't create it.
+	require.NoError(t, s.SaveSession(t.Context(), "unknown", rec))
+	_, err = s.Session(t.Context(), "unknown")
+	require.ErrorIs(t, err, redisstore.ErrSessionNotFound)
+
+	require.ErrorIs(t,
+		s.SaveSession(t.Context(), token, sessions.Record[testSession]{}),
+		redisstore.ErrEmptyUserID)
+}
+
+func TestExpiry(t *testing.T) {
+	m, s := newManager(t, redisstore.Config{TTL: time.Hour})
+	sessionKey := func(token string) string {
+		return redisstore.DefaultKeyPrefix + "session:" + token
+	}
+	userKey := redisstore.DefaultKeyPrefix + "user:u1"
+
+	byTTL := create(t, s, sessions.Record[testSession]{UserID: "u1"})
+	require.InDelta(t, time.Hour, m.TTL(sessionKey(byTTL)), float64(time.Second))
+	require.InDelta(t, time.Hour, m.TTL(userKey), float64(time.Second))
+
+	// The index expires with the last of the sessions.
+	long := create(t, s, sessions.Record[testSession]{
+		UserID: "u1", ExpiresAt: time.Now().Add(3 * time.Hour),
+	})
+	require.InDelta(t, 3*time.Hour, m.TTL(sessionKey(long)), float64(time.Second))
+	require.InDelta(t, 3*time.Hour, m.TTL(userKey), float64(time.Second))
+
+	short := create(t, s, sessions.Record[testSession]{
+		UserID: "u1", ExpiresAt: time.Now().Add(2 * time.Hour),
+	})
+	require.InDelta(t, 3*time.Hour, m.TTL(userKey), float64(time.Second))
+
+	m.FastForward(90 * time.Minute)
+	_, err := s.Session(t.Context(), byTTL)
+	require.ErrorIs(t, err, redisstore.ErrSessionNotFound)
+	require.ElementsMatch(t, []string{short, long}, slices.Collect(maps.Keys(
+		maps.Collect(s.UserSessions(t.Context(), "u1")),
+	)))
+	require.ElementsMatch(t, []string{short, long}, zrange(t, m, userKey))
+
+	m.FastForward(2 * time.Hour)
+	require.False(t, m.Exists(userKey))
+}
+
+// TestEternalSession covers a session without expiry,
+// which keeps the index from expiring.
+func TestEternalSession(t *testing.T) {
+	m, s := newManager(t, redisstore.Config{})
+	userKey := redisstore.DefaultKeyPrefix + "user:u1"
+
+	create(t, s, sessions.Record[testSession]{
+		UserID: "u1", ExpiresAt: time.Now().Add(time.Hour),
+	})
+	require.NotZero(t, m.TTL(userKey))
+
+	eternal := create(t, s, sessions.Record[testSession]{UserID: "u1"})
+	require.Zero(t, m.TTL(redisstore.DefaultKeyPrefix+"session:"+eternal))
+	require.Zero(t, m.TTL(userKey))
+}
+
+func zrange(t *testing.T, m *miniredis.Miniredis, key string) []string {
+	t.Helper()
+	members, err := m.ZMembers(key)
+	require.NoError(t, err)
+	slices.Sort(members)
+	return members
+}
+
+func TestCloseSession(t *testing.T) {
+	m, s := newManager(t, redisstore.Config{})
+	token := create(t, s, sessions.Record[testSession]{UserID: "u1"})
+	kept := create(t, s, sessions.Record[testSession]{UserID: "u1"})
+
+	require.NoError(t, s.CloseSession(t.Context(), token))
+	_, err := s.Session(t.Context(), token)
+	require.ErrorIs(t, err, redisstore.ErrSessionNotFound)
+	require.Equal(t, []string{kept}, zrange(t, m, redisstore.DefaultKeyPrefix+"user:u1"))
+
+	// No-op for a session that doesn't exist.
+	require.NoError(t, s.CloseSession(t.Context(), token))
+}
+
+func TestCloseAllUserSessions(t *testing.T) {
+	_, s := newManager(t, redisstore.Config{})
+	a := create(t, s, sessions.Record[testSession]{UserID: "u1"})
+	b := create(t, s, sessions.Record[testSession]{UserID: "u1"})
+	other := create(t, s, sessions.Record[testSession]{UserID: "u2"})
+
+	closed, err := s.CloseAllUserSessions(t.Context(), []string{}, "u1")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{a, b}, closed)
+
+	for _, token := range []string{a, b} {
+		_, err := s.Session(t.Context(), token)
+		require.ErrorIs(t, err, redisstore.ErrSessionNotFound)
+	}
+	_, err = s.Session(t.Context(), other)
+	require.NoError(t, err)
+	require.Empty(t, maps.Collect(s.UserSessions(t.Context(), "u1")))
+
+	closed, err = s.CloseAllUserSessions(t.Context(), nil, "u2")
+	require.NoError(t, err)
+	require.Nil(t, closed)
+
+	_, err = s.CloseAllUserSessions(t.Context(), nil, "")
+	require.ErrorIs(t, err, redisstore.ErrEmptyUserID)
+}
+
+func TestUserSessions(t *testing.T) {
+	m, s := newManager(t, redisstore.Config{})
+	recA := sessions.Record[testSession]{UserID: "u1", Data: testSession{Username: "a"}}
+	recB := sessions.Record[testSession]{UserID: "u1", Data: testSession{Username: "b"}}
+	a := create(t, s, recA)
+	b := create(t, s, recB)
+	stale := create(t, s, sessions.Record[testSession]{UserID: "u1"})
+	m.Del(redisstore.DefaultKeyPrefix + "session:" + stale)
+
+	require.Equal(t,
+		map[string]sessions.Record[testSession]{a: recA, b: recB},
+		maps.Collect(s.UserSessions(t.Context(), "u1")))
+	// The index entry of the session that's gone is pruned.
+	require.ElementsMatch(t, []string{a, b}, zrange(t, m, redisstore.DefaultKeyPrefix+"user:u1"))
+
+	n := 0
+	for range s.UserSessions(t.Context(), "u1") {
+		n++
+		break
+	}
+	require.Equal(t, 1, n)
+	require.Empty(t, maps.Collect(s.UserSessions(t.Context(), "")))
+}
+
+func notified(t *testing.T, calls *atomic.Int32, want int32) {
+	t.Helper()
+	require.Eventually(t, func() bool { return calls.Load() == want },
+		time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, want, calls.Load(), "called again")
+}
+
+func TestNotifyClosed(t *testing.T) {
+	t.Run("missing session", func(t *testing.T) {
+		_, s := newManager(t, redisstore.Config{})
+		var calls atomic.Int32
+		require.NoError(t, s.NotifyClosed(t.Context(), "unknown", func() { calls.Add(1) }))
+		require.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("closed by the manager", func(t *testing.T) {
+		_, s := newManager(t, redisstore.Config{})
+		token := create(t, s, sessions.Record[testSession]{UserID: "u1"})
+		var calls atomic.Int32
+		require.NoError(t, s.NotifyClosed(t.Context(), token, func() { calls.Add(1) }))
+		require.Zero(t, calls.Load())
+
+		require.NoError(t, s.CloseSession(t.Context(), token))
+		notified(t, &calls, 1)
+	})
+
+	t.Run("closed for the user", func(t *testing.T) {
+		_, s := newManager(t, redisstore.Config{})
+		token := create(t, s, sessions.Record[testSession]{UserID: "u1"})
+		var calls atomic.Int32
+		require.NoError(t, s.NotifyClosed(t.Context(), token, func() { calls.Add(1) }))
+
+		_, err := s.CloseAllUserSessions(t.Context(), nil, "u1")
+		require.NoError(t, err)
+		notified(t, &calls, 1)
+	})
+
+	// miniredis doesn't send keyspace notifications,
+	// the tests publish what Redis would.
+	for _, event := range []string{"del", "expired", "evicted"} {
+		t.Run("keyspace "+event, func(t *testing.T) {
+			m, s := newManager(t, redisstore.Config{})
+			token := create(t, s, sessions.Record[testSession]{UserID: "u1"})
+			var calls atomic.Int32
+			require.NoError(t, s.NotifyClosed(t.Context(), token, func() { calls.Add(1) }))
+
+			channel := "__keyspace@0__:" + redisstore.DefaultKeyPrefix + "session:" + token
+			m.Publish(channel, "set") // Not a closure.
+			m.Publish(channel, event)
+			notified(t, &calls, 1)
+		})
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		m, s := newManager(t, redisstore.Config{})
+		token := create(t, s, sessions.Record[testSession]{UserID: "u1"})
+		var calls atomic.Int32
+		ctx, cancel := context.WithCancel(t.Context())
+		require.NoError(t, s.NotifyClosed(ctx, token, func() { calls.Add(1) }))
+		cancel()
+
+		m.Publish("__keyspace@0__:"+redisstore.DefaultKeyPrefix+"session:"+token, "del")
+		require.NoError(t, s.CloseSession(t.Context(), token))
+		notified(t, &calls, 0)
+	})
+
+	t.Run("redis down", func(t *testing.T) {
+		m, s := newManager(t, redisstore.Config{})
+		m.SetError("unavailable")
+		err := s.NotifyClosed(t.Context(), "token", func() {})
+		require.Error(t, err)
+	})
+
+	t.Run("closed manager", func(t *testing.T) {
+		_, s := newManager(t, redisstore.Config{})
+		require.NoError(t, s.Close())
+		err := s.NotifyClosed(t.Context(), "token", func() {})
+		require.True(t, errors.Is(err, redisstore.ErrClosed))
+	})
+}
+
+// TestNotifyClosedReconnect covers a session deleted while the notification
+// subscription was down. The watcher learns about it once it's back.
+func TestNotifyClosedReconnect(t *testing.T) {
+	m, s := newManager(t, redisstore.Config{})
+	token := create(t, s, sessions.Record[testSession]{UserID: "u1"})
+	var calls atomic.Int32
+	require.NoError(t, s.NotifyClosed(t.Context(), token, func() { calls.Add(1) }))
+
+	m.Close()
+	m.Del(redisstore.DefaultKeyPrefix + "session:" + token)
+	require.NoError(t, m.Restart())
+
+	require.Eventually(t, func() bool { return calls.Load() == 1 },
+		5*time.Second, time.Millisecond)
+}
+
+func TestPing(t *testing.T) {
+	m, s := newManager(t, redisstore.Config{})
+	require.NoError(t, s.Ping(t.Context()))
+
+	m.Close()
+	require.Error(t, s.Ping(t.Context()))
+}
@@ -68,6 +68,14 @@ type CloseNotifier interface {
 	NotifyClosed(ctx context.Context, token string, fn func()) error
 }
 
+// Pinger is an optional interface that session managers implement to check
+// a round trip to the store behind them. The metrics server of a generated
+// server built with WithPrometheus calls it on every readiness probe.
+type Pinger interface {
+	// Ping returns an error when the store can't be reached before ctx is done.
+	Ping(ctx context.Context) error
+}
+
 // Manager stores and restores sessions.
 type Manager[Data any] interface {
 	Reader[Data]
@@ -0,0 +1,5 @@
+// Package tabstate defines the interface a store of per-tab state must
+// satisfy and the record the state of a tab is made of.
+//
+// The built-in implementations live in the subpackages.
+package tabstate
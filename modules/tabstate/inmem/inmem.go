@@ -0,0 +1,92 @@
+// Package inmem provides an in-memory store of per-tab state.
+//
+// It's the store a server uses without one configured, which is enough as
+// long as a single instance serves the application. The state is lost on
+// restart and a tab reconnecting to another instance finds none.
+// Several instances should share a store such as
+// github.com/romshark/datapages/modules/tabstate/natskv.
+package inmem
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/romshark/datapages/modules/tabstate"
+)
+
+var _ tabstate.Store = (*Store)(nil)
+
+// sweepEvery is the number of saves between the sweeps of expired records.
+const sweepEvery = 1024
+
+type entry struct {
+	rec      tabstate.Record
+	revision uint64
+}
+
+// Store is an in-memory store of per-tab state. It's safe for concurrent use.
+type Store struct {
+	lock    sync.Mutex
+	entries map[string]entry // tab -> entry
+	nextRev uint64
+	saves   int
+}
+
+// New creates a new in-memory store.
+func New() *Store {
+	return &Store{entries: make(map[string]entry)}
+}
+
+// Load implements tabstate.Store.
+func (s *Store) Load(_ context.Context, tab string) (
+	rec tabstate.Record, revision uint64, ok bool, err error,
+) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e, exists := s.entries[tab]
+	if !exists || e.rec.Expired(time.Now()) {
+		return rec, 0, false, nil
+	}
+	return e.rec, e.revision, true, nil
+}
+
+// Save implements tabstate.Store.
+func (s *Store) Save(
+	_ context.Context, tab string, rec tabstate.Record, revision uint64,
+) (uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+
+	e, exists := s.entries[tab]
+	if exists && e.rec.Expired(now) {
+		exists = false
+	}
+	switch {
+	case !exists && revision != 0,
+		exists && e.revision != revision:
+		return 0, tabstate.ErrConflict
+	}
+
+	s.nextRev++
+	s.entries[tab] = entry{rec: rec, revision: s.nextRev}
+
+	// Tabs that were closed leave their records behind until they expire.
+	if s.saves++; s.saves >= sweepEvery {
+		s.saves = 0
+		for k, e := range s.entries {
+			if e.rec.Expired(now) {
+				delete(s.entries, k)
+			}
+		}
+	}
+	return s.nextRev, nil
+}
+
+// Len returns the number of records held, expired ones included.
+func (s *Store) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.entries)
+}
@@ -0,0 +1,95 @@
+package inmem_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages/modules/tabstate"
+	"github.com/romshark/datapages/modules/tabstate/inmem"
+)
+
+func TestLoadMissing(t *testing.T) {
+	s := inmem.New()
+	_, rev, ok, err := s.Load(context.Background(), "tab")
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Zero(t, rev)
+}
+
+func TestSave(t *testing.T) {
+	ctx := context.Background()
+	s := inmem.New()
+
+	rev, err := s.Save(ctx, "tab", tabstate.Record{State: []byte(`1`)}, 0)
+	require.NoError(t, err)
+	require.NotZero(t, rev)
+
+	rec, got, ok, err := s.Load(ctx, "tab")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, rev, got)
+	require.Equal(t, []byte(`1`), rec.State)
+
+	rev2, err := s.Save(ctx, "tab", tabstate.Record{State: []byte(`2`)}, rev)
+	require.NoError(t, err)
+	require.NotEqual(t, rev, rev2)
+
+	rec, _, _, err = s.Load(ctx, "tab")
+	require.NoError(t, err)
+	require.Equal(t, []byte(`2`), rec.State)
+}
+
+func TestSaveConflict(t *testing.T) {
+	ctx := context.Background()
+	s := inmem.New()
+
+	_, err := s.Save(ctx, "tab", tabstate.Record{}, 7)
+	require.ErrorIs(t, err, tabstate.ErrConflict, "no record to update")
+
+	rev, err := s.Save(ctx, "tab", tabstate.Record{State: []byte(`1`)}, 0)
+	require.NoError(t, err)
+
+	_, err = s.Save(ctx, "tab", tabstate.Record{}, 0)
+	require.ErrorIs(t, err, tabstate.ErrConflict, "the record exists")
+
+	_, err = s.Save(ctx, "tab", tabstate.Record{}, rev+1)
+	require.ErrorIs(t, err, tabstate.ErrConflict, "stale revision")
+
+	rec, _, _, err := s.Load(ctx, "tab")
+	require.NoError(t, err)
+	require.Equal(t, []byte(`1`), rec.State, "a conflict changes nothing")
+}
+
+func TestExpired(t *testing.T) {
+	ctx := context.Background()
+	s := inmem.New()
+
+	_, err := s.Save(ctx, "tab", tabstate.Record{
+		State:     []byte(`1`),
+		ExpiresAt: time.Now().Add(-time.Second),
+	}, 0)
+	require.NoError(t, err)
+
+	_, _, ok, err := s.Load(ctx, "tab")
+	require.NoError(t, err)
+	require.False(t, ok, "an expired record is gone")
+
+	_, err = s.Save(ctx, "tab", tabstate.Record{State: []byte(`2`)}, 0)
+	require.NoError(t, err, "an expired record counts as none")
+}
+
+func TestSweep(t *testing.T) {
+	ctx := context.Background()
+	s := inmem.New()
+	past := time.Now().Add(-time.Second)
+	for i := range 2048 {
+		_, err := s.Save(ctx, strconv.Itoa(i),
+			tabstate.Record{ExpiresAt: past}, 0)
+		require.NoError(t, err)
+	}
+	require.Less(t, s.Len(), 2048, "expired records are swept")
+}
@@ -0,0 +1,169 @@
+// Package natskv provides a store of per-tab state
+// based on the NATS Key-Value Store.
+//
+// Each tab is a key of the bucket, named by its instance ID. Revisions are
+// those of the bucket, which gives every instance sharing it the same view
+// of which update came first.
+//
+// A record expires lazily: it's kept until it's loaded or saved after its
+// expiry, a record nobody asks for again stays. The TTL of the bucket,
+// nats.KeyValueConfig.TTL, drops those. It's counted from the last change of
+// a record, which is why it must be longer than any tab stays open without
+// its state changing.
+package natskv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/romshark/datapages/modules/tabstate"
+)
+
+var (
+	_ tabstate.Store  = (*Store)(nil)
+	_ tabstate.Pinger = (*Store)(nil)
+)
+
+// DefaultBucket is the default bucket name of the store.
+const DefaultBucket = "TABSTATE"
+
+// New creates a new NATS Key-Value store backed store of per-tab state.
+// The bucket is created unless it exists.
+func New(conn *nats.Conn, conf Config) (*Store, error) {
+	js, err := conn.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("creating JetStream context: %w", err)
+	}
+
+	kvConfig := conf.KVConfig
+	if kvConfig.Bucket == "" {
+		kvConfig.Bucket = DefaultBucket
+	}
+
+	// Try to get existing bucket first, create if not found.
+	// This avoids relying on specific error types from
+	// CreateKeyValue which can vary across NATS versions.
+	kv, err := js.KeyValue(kvConfig.Bucket)
+	switch {
+	case errors.Is(err, nats.ErrBucketNotFound):
+		kv, err = js.CreateKeyValue(&kvConfig)
+		if err != nil {
+			return nil, fmt.Errorf("creating new KV bucket: %w", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("opening KV bucket: %w", err)
+	}
+
+	return &Store{conn: conn, kv: kv}, nil
+}
+
+// Config configures the store.
+type Config struct {
+	KVConfig nats.KeyValueConfig
+}
+
+// Store keeps the state of tabs in NATS KV.
+type Store struct {
+	conn *nats.Conn
+	kv   nats.KeyValue
+}
+
+// Ping implements tabstate.Pinger. It flushes the connection and waits for
+// the server to answer, then reads the status of the bucket, which fails when
+// it's gone. The second step is bound by the JetStream timeout, not ctx.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := s.conn.FlushWithContext(ctx); err != nil {
+		return err
+	}
+	if _, err := s.kv.Status(); err != nil {
+		return fmt.Errorf("reading KV bucket status: %w", err)
+	}
+	return nil
+}
+
+// kvRecord is a tabstate.Record as it's stored.
+type kvRecord struct {
+	State     []byte    `json:"state,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	Owner     string    `json:"owner,omitempty"`
+}
+
+// Load implements tabstate.Store.
+func (s *Store) Load(_ context.Context, tab string) (
+	rec tabstate.Record, revision uint64, ok bool, err error,
+) {
+	rec, revision, ok, err = s.get(tab)
+	if !ok || err != nil || rec.Expired(time.Now()) {
+		return tabstate.Record{}, 0, false, err
+	}
+	return rec, revision, true, nil
+}
+
+// get returns the record of tab whether or not it expired.
+func (s *Store) get(tab string) (
+	rec tabstate.Record, revision uint64, ok bool, err error,
+) {
+	entry, err := s.kv.Get(tab)
+	switch {
+	case errors.Is(err, nats.ErrKeyNotFound):
+		return rec, 0, false, nil
+	case err != nil:
+		return rec, 0, false, fmt.Errorf("getting tab state: %w", err)
+	}
+	var kvRec kvRecord
+	if err := json.Unmarshal(entry.Value(), &kvRec); err != nil {
+		return rec, 0, false, fmt.Errorf("unmarshaling KV record: %w", err)
+	}
+	rec = tabstate.Record{
+		State: kvRec.State, ExpiresAt: kvRec.ExpiresAt, Owner: kvRec.Owner,
+	}
+	return rec, entry.Revision(), true, nil
+}
+
+// Save implements tabstate.Store.
+func (s *Store) Save(
+	_ context.Context, tab string, rec tabstate.Record, revision uint64,
+) (uint64, error) {
+	value, err := json.Marshal(kvRecord{
+		State: rec.State, ExpiresAt: rec.ExpiresAt, Owner: rec.Owner,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("marshaling KV record: %w", err)
+	}
+
+	if revision != 0 {
+		rev, err := s.kv.Update(tab, value, revision)
+		if errors.Is(err, nats.ErrKeyRevisionMismatch) {
+			return 0, tabstate.ErrConflict
+		}
+		if err != nil {
+			return 0, fmt.Errorf("updating tab state: %w", err)
+		}
+		return rev, nil
+	}
+
+	rev, err := s.kv.Create(tab, value)
+	if errors.Is(err, nats.ErrKeyExists) {
+		// An expired record counts as none and is replaced.
+		old, oldRev, ok, getErr := s.get(tab)
+		switch {
+		case getErr != nil:
+			return 0, getErr
+		case !ok || !old.Expired(time.Now()):
+			return 0, tabstate.ErrConflict
+		}
+		rev, err = s.kv.Update(tab, value, oldRev)
+		if errors.Is(err, nats.ErrKeyRevisionMismatch) {
+			return 0, tabstate.ErrConflict
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("creating tab state: %w", err)
+	}
+	return rev, nil
+}
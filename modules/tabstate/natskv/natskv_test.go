@@ -0,0 +1,106 @@
+package natskv_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+	natsctr "github.com/testcontainers/testcontainers-go/modules/nats"
+
+	"github.com/romshark/datapages/modules/tabstate"
+	"github.com/romshark/datapages/modules/tabstate/natskv"
+)
+
+func setupNATS(t *testing.T) *nats.Conn {
+	t.Helper()
+	ctx := context.Background()
+	ctr, err := natsctr.Run(ctx, "nats:latest")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, ctr.Terminate(ctx)) })
+
+	url, err := ctr.ConnectionString(ctx)
+	require.NoError(t, err)
+
+	// The testcontainers NATS module only waits for the port to be open,
+	// not for the server to be fully initialized. Use nats.RetryOnFailedConnect
+	// so the client keeps retrying until NATS is ready.
+	conn, err := nats.Connect(
+		url,
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(50),
+		nats.ReconnectWait(200*time.Millisecond),
+	)
+	require.NoError(t, err)
+	require.Eventually(t, conn.IsConnected, 10*time.Second, 100*time.Millisecond)
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	conn := setupNATS(t)
+	s, err := natskv.New(conn, natskv.Config{})
+	require.NoError(t, err)
+	require.NoError(t, s.Ping(ctx))
+
+	t.Run("missing", func(t *testing.T) {
+		_, _, ok, err := s.Load(ctx, "missing")
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("save and update", func(t *testing.T) {
+		rev, err := s.Save(ctx, "tab1", tabstate.Record{State: []byte(`1`)}, 0)
+		require.NoError(t, err)
+
+		rec, got, ok, err := s.Load(ctx, "tab1")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, rev, got)
+		require.Equal(t, []byte(`1`), rec.State)
+		require.True(t, rec.ExpiresAt.IsZero())
+
+		expires := time.Now().Add(time.Hour).Truncate(time.Second)
+		_, err = s.Save(ctx, "tab1",
+			tabstate.Record{State: []byte(`2`), ExpiresAt: expires}, rev)
+		require.NoError(t, err)
+
+		rec, _, _, err = s.Load(ctx, "tab1")
+		require.NoError(t, err)
+		require.Equal(t, []byte(`2`), rec.State)
+		require.True(t, expires.Equal(rec.ExpiresAt))
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		rev, err := s.Save(ctx, "tab2", tabstate.Record{State: []byte(`1`)}, 0)
+		require.NoError(t, err)
+
+		_, err = s.Save(ctx, "tab2", tabstate.Record{}, 0)
+		require.ErrorIs(t, err, tabstate.ErrConflict, "the record exists")
+
+		_, err = s.Save(ctx, "tab2", tabstate.Record{}, rev+100)
+		require.ErrorIs(t, err, tabstate.ErrConflict, "stale revision")
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		_, err := s.Save(ctx, "tab3", tabstate.Record{
+			State:     []byte(`1`),
+			ExpiresAt: time.Now().Add(-time.Second),
+		}, 0)
+		require.NoError(t, err)
+
+		_, _, ok, err := s.Load(ctx, "tab3")
+		require.NoError(t, err)
+		require.False(t, ok, "an expired record is gone")
+
+		_, err = s.Save(ctx, "tab3", tabstate.Record{State: []byte(`2`)}, 0)
+		require.NoError(t, err, "an expired record counts as none")
+
+		rec, _, ok, err := s.Load(ctx, "tab3")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []byte(`2`), rec.State)
+	})
+}
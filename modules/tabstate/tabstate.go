@@ -0,0 +1,56 @@
+package tabstate
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrConflict is returned by [Store.Save] when the record changed since the
+// revision it was given, or was created by someone else for revision 0.
+var ErrConflict = errors.New("tab state changed concurrently")
+
+// Record is what a store keeps for one tab.
+type Record struct {
+	// State is the encoded state of the tab.
+	State []byte
+
+	// ExpiresAt is the time the record is dropped at.
+	// The zero value never expires, which is what the record of a tab
+	// with an open stream is kept at.
+	ExpiresAt time.Time
+
+	// Owner identifies the stream that keeps the record from expiring,
+	// empty when none does. A tab that reconnects opens its new stream
+	// before the server notices the old one is gone, only the owner
+	// lets the record expire again when it closes.
+	Owner string
+}
+
+// Expired reports whether the record expired at now.
+func (r Record) Expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
+}
+
+// Store keeps the state of tabs under their instance IDs.
+// Several server instances sharing one store share the state of their tabs,
+// a tab reconnecting to another instance finds the state it had.
+type Store interface {
+	// Load returns the record of tab and its revision.
+	// Returns ok=false, err=nil when there is none or it expired.
+	Load(ctx context.Context, tab string) (rec Record, revision uint64, ok bool, err error)
+
+	// Save stores rec for tab if its revision is still revision and returns
+	// the new one. Revision 0 creates the record, which must not exist then,
+	// an expired record counts as none.
+	// Returns ErrConflict when the revision doesn't match.
+	Save(ctx context.Context, tab string, rec Record, revision uint64) (uint64, error)
+}
+
+// Pinger is an optional interface that stores implement to check a round
+// trip to what they are backed by. The metrics server of a generated server
+// built with WithPrometheus calls it on every readiness probe.
+type Pinger interface {
+	// Ping returns an error when the store can't be reached before ctx is done.
+	Ping(ctx context.Context) error
+}
@@ -1,19 +1,28 @@
 package datapages
 
 import (
+	"context"
 	"embed"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/romshark/datapages/modules/codec"
 	"github.com/romshark/datapages/modules/csrf"
+	"github.com/romshark/datapages/modules/messaging"
 	"github.com/romshark/datapages/modules/sessions"
+	"github.com/romshark/datapages/modules/tabstate"
+	"github.com/romshark/datapages/runtime/health"
 	"github.com/romshark/datapages/runtime/httpread"
 	"github.com/romshark/datapages/runtime/prom"
+	"github.com/romshark/datapages/runtime/subject"
 )
 
 // DefaultSessionCookieName is the name of the session cookie.
@@ -25,6 +34,9 @@ type ServerConfig struct {
 	// Logger receives what the server logs. Defaults to a JSON handler on stderr.
 	Logger *slog.Logger
 
+	// AccessLog configures the access log. Nil logs no requests.
+	AccessLog *AccessLogConfig
+
 	// Middleware wraps the router, in the order it was added.
 	Middleware []func(http.Handler) http.Handler
 
@@ -34,9 +46,33 @@ type ServerConfig struct {
 	// HTTPServer serves the application. Its Addr and Handler are always overwritten.
 	HTTPServer *http.Server
 
+	// Streams configures the heartbeat, the lifetime and the drain
+	// of the SSE streams.
+	Streams StreamsConfig
+
+	// DeferredTimeout bounds the resolution of each [Deferred] component.
+	// Zero selects 10 seconds.
+	DeferredTimeout time.Duration
+
+	// TabState configures how long the state of a tab outlives its stream
+	// and where it's kept, see [TabState].
+	TabState TabStateConfig
+
 	// MetricsServer serves the metrics endpoint.
 	MetricsServer *http.Server
 
+	// HealthServer serves the liveness and readiness probes
+	// on a server of their own.
+	HealthServer *http.Server
+
+	// Health serves the liveness and readiness probes of HealthServer
+	// and MetricsServer. Nil without [WithHealth] and [WithPrometheus].
+	Health *health.Checker
+
+	// ReadinessDelay is how long the shutdown waits between failing
+	// the readiness probe and closing the listeners.
+	ReadinessDelay time.Duration
+
 	// DatastarJS is the URL of the Datastar bundle the page shell loads.
 	DatastarJS string
 
@@ -56,6 +92,26 @@ type ServerConfig struct {
 	// A nil value leaves it on with the built-in defaults.
 	CSRF *CSRFConfig
 
+	// EventCodec encodes events into message payloads and decodes them back.
+	// Nil selects [codec.JSON].
+	EventCodec codec.Codec
+
+	// SubjectPrefix is the namespace every subject is placed under on the wire.
+	// Empty selects none.
+	SubjectPrefix string
+
+	// BrokerInterceptors wrap the calls to the message broker,
+	// the first one outermost. See [messaging.Intercept].
+	BrokerInterceptors []messaging.Interceptor
+
+	// TracerProvider provides the tracer of the spans the server starts.
+	// Nil traces nothing.
+	TracerProvider trace.TracerProvider
+
+	// MeterProvider provides the meter of the instruments
+	// of a server built with [EnableOTelMetrics].
+	MeterProvider metric.MeterProvider
+
 	// sessionManager is what [WithSessionManager] carries.
 	// ServerConfig is not generic, hence the manager travels as any and
 	// [NewServer] asserts it once to the type the application declares.
@@ -148,6 +204,33 @@ func WithLogger(l *slog.Logger) ServerOption {
 	}
 }
 
+// AccessLogConfig configures the access log.
+type AccessLogConfig struct {
+	// Level is the level of the access log lines. The zero value is
+	// slog.LevelInfo.
+	Level slog.Level
+
+	// Skip leaves the requests it returns true for out of the access log.
+	// Nil logs every request.
+	Skip func(r *http.Request) bool
+}
+
+// WithAccessLog logs a line per request once it's answered: its method,
+// route, handler, status, duration, request ID and, when known, the ID of
+// the user and of the stream. A stream is logged when it ends.
+// The lines go to the logger of [WithLogger].
+//
+// Every request has an ID, with or without the access log: the one in its
+// X-Request-ID header when that's up to 128 bytes of printable ASCII,
+// a generated one otherwise. The response carries it in X-Request-ID,
+// and [Logger] returns a logger with it inside every handler.
+func WithAccessLog(conf AccessLogConfig) ServerOption {
+	return func(c *ServerConfig) error {
+		c.AccessLog = &conf
+		return nil
+	}
+}
+
 // WithMiddleware adds custom middleware.
 //
 // They wrap the router in the order they are given, across calls too:
@@ -177,6 +260,135 @@ func WithHTTPServer(server *http.Server) ServerOption {
 	}
 }
 
+// StreamsConfig configures the SSE streams pages keep open for events.
+// The zero value sends no heartbeats and keeps a stream open for as long
+// as the client stays.
+type StreamsConfig struct {
+	// Heartbeat is how often a stream sends a heartbeat, an SSE comment
+	// the client skips. Proxies and load balancers cut connections
+	// idle for longer than their timeout, often 60 seconds, which a stream
+	// waiting for events would otherwise be.
+	//
+	// Optional. Zero sends none.
+	Heartbeat time.Duration
+
+	// MaxLifetime is how long a stream stays open at most. The stream is
+	// then closed and the client reconnects, possibly to another instance,
+	// which keeps a client from being pinned to one instance forever and
+	// spreads the load again after a deployment.
+	// Counted as the disconnect reason "ttl".
+	//
+	// Optional. Zero keeps a stream open for as long as the client stays.
+	MaxLifetime time.Duration
+
+	// MaxLifetimeJitter shortens the lifetime of each stream by a random
+	// duration up to it, so streams opened together don't all reconnect
+	// together. It must be shorter than MaxLifetime.
+	//
+	// Optional. Zero closes every stream after exactly MaxLifetime.
+	MaxLifetimeJitter time.Duration
+
+	// Retry is how long the client is told to wait before it reconnects
+	// a stream that reached its lifetime.
+	//
+	// Optional. Zero leaves it to the client.
+	Retry time.Duration
+
+	// DrainWindow spreads the disconnects of the open streams over a window
+	// when the server shuts down, each stream at a random moment of it,
+	// so their clients don't all reconnect to the remaining instances at
+	// once. Every stream is told to wait a random retry between Retry, or
+	// a second without one, and twice that. The window ends before the
+	// deadline of the context Shutdown was called with, leaving a tenth of
+	// the time until then for the streams to close.
+	// No new stream is opened once the shutdown began.
+	//
+	// The StreamDrain hook of the App, when it declares one, runs on every
+	// stream as the shutdown begins, which may tell the visitor the server
+	// is restarting.
+	//
+	// Optional. Zero disconnects every stream at once.
+	DrainWindow time.Duration
+
+	// Shared carries the streams of all tabs of a browser over one
+	// connection, which keeps a visitor with many tabs open from running
+	// into the limit of six connections per origin of HTTP/1.1.
+	// The tabs elect a leader, a shared worker or, where there are none,
+	// one of them, which opens the connection and adds and removes
+	// the streams of the tabs. Each stream is still served by its page,
+	// with its own StreamOpen and StreamClose, lifetime and drain.
+	// The streams of a connection subscribing to the same subjects share
+	// a broker subscription. In a browser that supports neither,
+	// each tab opens its own streams.
+	//
+	// Optional. False opens one connection per stream.
+	Shared bool
+}
+
+// WithStreams configures the heartbeat, the lifetime, the drain
+// and the sharing of the SSE streams.
+func WithStreams(conf StreamsConfig) ServerOption {
+	return func(c *ServerConfig) error {
+		switch {
+		case conf.Heartbeat < 0:
+			return errors.New("WithStreams: negative Heartbeat")
+		case conf.MaxLifetime < 0:
+			return errors.New("WithStreams: negative MaxLifetime")
+		case conf.MaxLifetimeJitter < 0:
+			return errors.New("WithStreams: negative MaxLifetimeJitter")
+		case conf.Retry < 0:
+			return errors.New("WithStreams: negative Retry")
+		case conf.DrainWindow < 0:
+			return errors.New("WithStreams: negative DrainWindow")
+		case conf.MaxLifetimeJitter > 0 && conf.MaxLifetimeJitter >= conf.MaxLifetime:
+			return errors.New("WithStreams: MaxLifetimeJitter not shorter than MaxLifetime")
+		}
+		c.Streams = conf
+		return nil
+	}
+}
+
+// WithDeferredTimeout sets how long a [Deferred] component may take
+// to resolve before it fails with [context.DeadlineExceeded].
+// The default is 10 seconds.
+func WithDeferredTimeout(d time.Duration) ServerOption {
+	return func(c *ServerConfig) error {
+		if d < 0 {
+			return errors.New("WithDeferredTimeout: negative timeout")
+		}
+		c.DeferredTimeout = d
+		return nil
+	}
+}
+
+// TabStateConfig configures the state of the tabs of stateful pages,
+// see [TabState].
+type TabStateConfig struct {
+	// TTL is how long the state of a tab outlives its stream, which is how
+	// long the tab may take to reconnect, and the last change of the state
+	// of a tab whose stream never opened.
+	//
+	// Optional. Zero selects 30 seconds.
+	TTL time.Duration
+
+	// Store keeps the state. A store shared by all instances of the server,
+	// such as modules/tabstate/natskv, lets a tab reconnect to another one.
+	//
+	// Optional. Nil keeps the state in memory.
+	Store tabstate.Store
+}
+
+// WithTabState configures the state of the tabs of stateful pages.
+func WithTabState(conf TabStateConfig) ServerOption {
+	return func(c *ServerConfig) error {
+		if conf.TTL < 0 {
+			return errors.New("WithTabState: negative TTL")
+		}
+		c.TabState = conf
+		return nil
+	}
+}
+
 // WithDatastarJS sets a custom URL for the Datastar JavaScript bundle.
 func WithDatastarJS(src string) ServerOption {
 	return func(c *ServerConfig) error {
@@ -267,6 +479,96 @@ func WithAssets(fsys embed.FS) ServerOption {
 	}
 }
 
+// WithEventCodec sets the codec events are encoded into message payloads with,
+// instead of the default [codec.JSON]. Every instance publishing to or
+// subscribing on the same broker must use the same codec.
+//
+// The built-in alternatives are
+// [github.com/romshark/datapages/modules/codec/cbor] and
+// [github.com/romshark/datapages/modules/codec/msgpack],
+// any [codec.Codec] works.
+func WithEventCodec(c codec.Codec) ServerOption {
+	return func(conf *ServerConfig) error {
+		if c == nil {
+			return errors.New("WithEventCodec: nil codec")
+		}
+		conf.EventCodec = c
+		return nil
+	}
+}
+
+// WithSubjectPrefix places every subject the server publishes and subscribes to
+// under "<prefix>.", which keeps apps and environments sharing a broker from
+// receiving each other's events. The prefix is one or more subject tokens
+// joined by dots, "staging" or "shop.staging" for example. An empty prefix
+// selects none, so a deployment can pass its setting on whether it has one.
+//
+// The generated subject constants stay as they are: the server adds the prefix
+// on the way to the broker, InitStreams included, and strips it from what
+// arrives and from what the metrics count.
+// [github.com/romshark/datapages/modules/messaging/natscore.Config] has the
+// same setting for a broker shared by servers that don't set this option.
+func WithSubjectPrefix(prefix string) ServerOption {
+	return func(c *ServerConfig) error {
+		if prefix != "" && !subject.IsNamespace(prefix) {
+			return fmt.Errorf("WithSubjectPrefix: invalid prefix: %q", prefix)
+		}
+		c.SubjectPrefix = prefix
+		return nil
+	}
+}
+
+// WithBrokerInterceptors installs a chain of interceptors around the message
+// broker, which see every publish, subscribe and delivered message of the
+// server. The first one is the outermost. Calling it again appends
+// to the chain.
+//
+// Interceptors add logging, tracing, tenant checks or fault injection without
+// changing the broker. A message carries what they add as headers to the
+// brokers implementing [messaging.HeaderPublisher], which the built-in
+// in-memory and NATS brokers do.
+func WithBrokerInterceptors(chain ...messaging.Interceptor) ServerOption {
+	return func(c *ServerConfig) error {
+		c.BrokerInterceptors = append(c.BrokerInterceptors, chain...)
+		return nil
+	}
+}
+
+// WithTracerProvider traces the server with OpenTelemetry: it starts a span
+// for every page load, action, stream open and close hook and event handler
+// call, named by the page or App type and the method, "PageIndex.GET" or
+// "PageIndex.OnMessageSent" for example.
+//
+// A dispatched event carries the span context of the action that dispatched it
+// in its message header, the span of an event handler receiving it links to
+// that span, on this instance or on another one sharing the broker. A broker
+// not implementing [messaging.HeaderPublisher] loses the link.
+func WithTracerProvider(tp trace.TracerProvider) ServerOption {
+	return func(c *ServerConfig) error {
+		if tp == nil {
+			return errors.New("WithTracerProvider: nil tracer provider")
+		}
+		c.TracerProvider = tp
+		return nil
+	}
+}
+
+// WithMeterProvider records the metrics of the server through the
+// OpenTelemetry API, on instruments of its own created from mp.
+// Two servers in one process don't share them,
+// the provider aggregates what both record.
+//
+// Required by a server built with [EnableOTelMetrics], rejected by any other.
+func WithMeterProvider(mp metric.MeterProvider) ServerOption {
+	return func(c *ServerConfig) error {
+		if mp == nil {
+			return errors.New("WithMeterProvider: nil meter provider")
+		}
+		c.MeterProvider = mp
+		return nil
+	}
+}
+
 // PrometheusConfig configures the Prometheus metrics endpoint.
 type PrometheusConfig struct {
 	// Host is the address the metrics server listens on,
@@ -284,17 +586,118 @@ type PrometheusConfig struct {
 
 	// Collectors are user-defined metrics to register.
 	Collectors []prometheus.Collector
+
+	// ReadinessChecks are checked by /readyz next to the message broker
+	// and the session store.
+	ReadinessChecks []ReadinessCheck
+
+	// ReadinessDelay is how long the shutdown keeps serving after /readyz
+	// has begun to fail, for the load balancers to notice before the
+	// listeners close. It's spent within the deadline of the shutdown,
+	// ahead of the drain of the streams. Set it to the period of the
+	// readiness probe times its failure threshold.
+	//
+	// Optional. Zero closes the listeners right away.
+	ReadinessDelay time.Duration
+
+	// Pprof serves the handlers of net/http/pprof under /debug/pprof/
+	// on the metrics server.
+	// Profiles reveal the internals of the process,
+	// keep the metrics server off the public network when it's on.
+	Pprof bool
+}
+
+// ReadinessCheck is a dependency of the application that /readyz checks.
+type ReadinessCheck struct {
+	// Name is what /readyz reports the check as, "database" for example.
+	Name string
+
+	// Check returns nil when the dependency is usable. It's called on every
+	// readiness probe and must return once ctx is done.
+	Check func(ctx context.Context) error
+}
+
+// HealthConfig configures the probes of [WithHealth].
+type HealthConfig struct {
+	// Host is the address the probe server listens on,
+	// for example "127.0.0.1:8081" or ":8081".
+	Host string
+
+	// ReadinessChecks are checked by /readyz next to the message broker
+	// and the session store.
+	ReadinessChecks []ReadinessCheck
+
+	// ReadinessDelay is what [PrometheusConfig.ReadinessDelay] is.
+	ReadinessDelay time.Duration
 }
 
-// WithPrometheus starts a dedicated HTTP server exposing /metrics.
+// WithHealth starts a dedicated HTTP server exposing the liveness probe
+// /healthz and the readiness probe /readyz, which answer as those of
+// [WithPrometheus] do. Unlike it, it goes with any metrics instrumentation,
+// none included. Given both, the probes of either server check the same.
+func WithHealth(conf HealthConfig) ServerOption {
+	return func(c *ServerConfig) error {
+		if conf.Host == "" {
+			return errors.New("WithHealth: empty host address")
+		}
+		if err := addHealthChecks(c, conf.ReadinessChecks, conf.ReadinessDelay); err != nil {
+			return fmt.Errorf("WithHealth: %w", err)
+		}
+		mux := http.NewServeMux()
+		c.Health.Register(mux)
+		c.HealthServer = &http.Server{
+			Addr:    conf.Host,
+			Handler: mux,
+		}
+		return nil
+	}
+}
+
+// addHealthChecks adds checks to the health checker of c, creating it when
+// there's none yet, and sets the readiness delay unless zero.
+func addHealthChecks(c *ServerConfig, checks []ReadinessCheck, delay time.Duration) error {
+	for i, rc := range checks {
+		if rc.Name == "" {
+			return fmt.Errorf("readiness check %d: empty name", i)
+		}
+		if rc.Check == nil {
+			return fmt.Errorf("readiness check %q: nil check", rc.Name)
+		}
+	}
+	if delay < 0 {
+		return errors.New("negative ReadinessDelay")
+	}
+	if c.Health == nil {
+		c.Health = &health.Checker{}
+	}
+	for _, rc := range checks {
+		c.Health.Add(rc.Name, rc.Check)
+	}
+	if delay > 0 {
+		c.ReadinessDelay = delay
+	}
+	return nil
+}
+
+// WithPrometheus starts a dedicated HTTP server exposing /metrics,
+// the liveness probe /healthz and the readiness probe /readyz.
+// /healthz answers 200 as long as the process serves requests.
+// /readyz answers 503 once the shutdown begins, or while the message broker,
+// the session store or one of conf.ReadinessChecks fails its check.
+// The broker and the store are checked when they implement
+// [messaging.Pinger] and [sessions.Pinger], and a broker that implements
+// neither that nor [messaging.StatusNotifier] counts as ready.
 //
-// Required by a server built with [EnablePrometheus],
-// rejected by one built with [DisablePrometheus].
+// Required by a server built with [EnablePrometheus], rejected by any other.
+// [WithHealth] serves the probes without it.
 func WithPrometheus(conf PrometheusConfig) ServerOption {
 	return func(c *ServerConfig) error {
 		if conf.Host == "" {
 			return errors.New("prometheus host address must not be empty")
 		}
+		if err := addHealthChecks(c, conf.ReadinessChecks, conf.ReadinessDelay); err != nil {
+			return err
+		}
 
 		// Defaults
 		if conf.Registerer == nil {
@@ -322,6 +725,12 @@ func WithPrometheus(conf PrometheusConfig) ServerOption {
 		mux := http.NewServeMux()
 		mux.Handle("/metrics", h)
 
+		c.Health.Register(mux)
+
+		if conf.Pprof {
+			prom.RegisterPprof(mux)
+		}
+
 		c.MetricsServer = &http.Server{
 			Addr:    conf.Host,
 			Handler: mux,
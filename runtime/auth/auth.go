@@ -15,6 +15,7 @@ import (
 	"github.com/romshark/datapages/modules/csrf"
 	"github.com/romshark/datapages/modules/sessions"
 	"github.com/romshark/datapages/runtime/httpread"
+	"github.com/romshark/datapages/runtime/reqlog"
 	"github.com/romshark/datapages/runtime/subject"
 )
 
@@ -156,6 +157,7 @@ func (m *Manager[Data]) ReadSession(w http.ResponseWriter, r *http.Request) (
 		return datapages.Session[Data]{}, "", true
 	}
 	m.sessionRead("valid")
+	reqlog.SetUser(r.Context(), sess.UserID())
 
 	if !m.CheckCSRF(w, r, token) {
 		return sess, token, false
@@ -164,6 +166,29 @@ func (m *Manager[Data]) ReadSession(w http.ResponseWriter, r *http.Request) (
 	return sess, token, true
 }
 
+// SessionUserID returns the user ID of the session r carries, "" for a guest
+// and for a session that's stale or expired. Unlike ReadSession it neither
+// clears the cookie nor checks the CSRF token, it's for a handler that has
+// done that already or doesn't need to.
+// ok is false when the request was answered and the handler must not run.
+func (m *Manager[Data]) SessionUserID(w http.ResponseWriter, r *http.Request) (
+	userID string, ok bool,
+) {
+	cookieVal, found := httpread.CookieValue(r, m.conf.Cookie.Name)
+	if !found {
+		return "", true
+	}
+	rec, _, ok, err := m.sessions.ReadSessionFromCookie(cookieVal)
+	if err != nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return "", false
+	}
+	if !ok || (!rec.ExpiresAt.IsZero() && !time.Now().Before(rec.ExpiresAt)) {
+		return "", true
+	}
+	return rec.UserID, true
+}
+
 // CheckCSRF answers r and returns false when the request carries no valid
 // CSRF token. A read method, a guest and disabled protection all pass.
 func (m *Manager[Data]) CheckCSRF(
@@ -0,0 +1,276 @@
+// Package deferred streams the components of a page that are slow to
+// resolve after the rest of the page, see datapages.Deferred.
+//
+// Application code must not import this package.
+package deferred
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout bounds the resolution of a deferred component
+// when the server configures no timeout of its own.
+const DefaultTimeout = 10 * time.Second
+
+// IDPrefix starts the id of the element a placeholder is rendered in.
+const IDPrefix = "datapages-deferred-"
+
+// Component is a datapages.Component, which this package can't import.
+type Component interface {
+	Render(ctx context.Context, w io.Writer) error
+}
+
+// Func resolves a deferred component.
+type Func func(ctx context.Context) (Component, error)
+
+// OnError reports a component that failed to resolve or render with the id
+// of the element its placeholder is rendered in, which stays in the page.
+// It may write its own feedback to w, the page the placeholder is written to.
+type OnError func(w io.Writer, id string, err error)
+
+// Queue collects the deferred components a page renders until they're
+// resolved after it. It's safe for concurrent use.
+type Queue struct {
+	timeout time.Duration
+	onErr   OnError
+	inPlace bool // The components are resolved where they're rendered.
+	ids     *atomic.Int64
+
+	lock      sync.Mutex
+	fragments []fragment
+}
+
+type fragment struct {
+	id string
+	fn Func
+}
+
+type ctxKey struct{}
+
+// Start returns ctx carrying a new queue the body of a page is rendered
+// with, and the queue. Each component is resolved within timeout, one that
+// fails is reported to onErr. It returns a nil queue when ctx was made by
+// [InPlace], and the components are resolved where they're rendered.
+func Start(ctx context.Context, timeout time.Duration, onErr OnError) (context.Context, *Queue) {
+	q := &Queue{timeout: timeout, onErr: onErr, ids: new(atomic.Int64)}
+	if p, ok := ctx.Value(ctxKey{}).(*Queue); ok && p.inPlace {
+		q.inPlace = true
+		return context.WithValue(ctx, ctxKey{}, q), nil
+	}
+	return context.WithValue(ctx, ctxKey{}, q), q
+}
+
+// InPlace returns ctx resolving every deferred component where it's rendered,
+// for a page no one streams the components of afterwards, or its head.
+// The components keep the timeout and the onErr of the queue of ctx.
+func InPlace(ctx context.Context) context.Context {
+	q := &Queue{inPlace: true, ids: new(atomic.Int64)}
+	if p, ok := ctx.Value(ctxKey{}).(*Queue); ok {
+		q.timeout, q.onErr, q.ids = p.timeout, p.onErr, p.ids
+	}
+	return context.WithValue(ctx, ctxKey{}, q)
+}
+
+// New returns the component rendering placeholder in place of the
+// component fn resolves to, which the queue of the context it's rendered
+// with resolves after the page. Without a queue, fn is called right away
+// and the component it resolves to is rendered instead of placeholder.
+// One that fails then renders placeholder and is reported to onErr
+// of the queue, or fails the render when there's none.
+func New(placeholder Component, fn Func) Component {
+	return component{placeholder: placeholder, fn: fn}
+}
+
+type component struct {
+	placeholder Component
+	fn          Func
+}
+
+func (c component) Render(ctx context.Context, w io.Writer) error {
+	q, _ := ctx.Value(ctxKey{}).(*Queue)
+	if q == nil || q.inPlace {
+		return c.renderInPlace(ctx, w, q)
+	}
+	return c.renderPlaceholder(ctx, w, q.add(c.fn))
+}
+
+// renderInPlace resolves the component and renders it, or its placeholder
+// when it fails and q has an onErr to report it to. q may be nil.
+func (c component) renderInPlace(ctx context.Context, w io.Writer, q *Queue) error {
+	if q == nil {
+		q = &Queue{inPlace: true, ids: new(atomic.Int64)}
+	}
+	html, err := q.resolve(ctx, c.fn)
+	if err == nil {
+		_, err = w.Write(html)
+		return err
+	}
+	if q.onErr == nil {
+		return err
+	}
+	id := q.newID()
+	if err := c.renderPlaceholder(ctx, w, id); err != nil {
+		return err
+	}
+	q.onErr(w, id, err)
+	return nil
+}
+
+func (c component) renderPlaceholder(ctx context.Context, w io.Writer, id string) error {
+	if _, err := io.WriteString(w, `<div id="`+id+`" style="display:contents">`); err != nil {
+		return err
+	}
+	if c.placeholder != nil {
+		if err := c.placeholder.Render(ctx, w); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "</div>")
+	return err
+}
+
+func (q *Queue) add(fn Func) string {
+	id := q.newID()
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.fragments = append(q.fragments, fragment{id: id, fn: fn})
+	return id
+}
+
+// newID returns the id of the next placeholder, unique across the page
+// including its head and its components resolved in place.
+func (q *Queue) newID() string {
+	return IDPrefix + strconv.FormatInt(q.ids.Add(1), 10)
+}
+
+// Len returns the number of components queued. Zero for a nil queue.
+func (q *Queue) Len() int {
+	if q == nil {
+		return 0
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.fragments)
+}
+
+type result struct {
+	id   string
+	html []byte
+	err  error
+}
+
+// Resolve flushes what w holds so far and resolves the queued components
+// concurrently, each within the timeout of the queue. Every component is
+// written to w as it's resolved, in a template followed by the script that
+// puts it in place of its placeholder, and w is flushed again. A component
+// that fails to resolve or render, or outlasts the timeout, is reported to
+// onErr of the queue instead. Resolve returns once every component
+// was written or reported, or ctx is done.
+func (q *Queue) Resolve(ctx context.Context, w http.ResponseWriter) {
+	q.lock.Lock()
+	fragments := q.fragments
+	q.fragments = nil
+	q.lock.Unlock()
+	if len(fragments) == 0 {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	_ = rc.Flush()
+
+	results := make(chan result, len(fragments))
+	for _, f := range fragments {
+		go func() {
+			html, err := q.resolve(ctx, f.fn)
+			results <- result{id: f.id, html: html, err: err}
+		}()
+	}
+	for range fragments {
+		var r result
+		select {
+		case r = <-results:
+		case <-ctx.Done():
+			return
+		}
+		if r.err != nil {
+			if q.onErr != nil {
+				q.onErr(w, r.id, r.err)
+			}
+		} else if err := writeFragment(w, r.id, r.html); err != nil {
+			return // The client is gone.
+		}
+		_ = rc.Flush()
+	}
+}
+
+// resolve calls fn and renders the component it resolves to within the
+// timeout of q. It gives up with context.DeadlineExceeded once the timeout
+// is over, even when fn or the component ignores ctx and is still running.
+// A panic is returned as an error.
+func (q *Queue) resolve(ctx context.Context, fn Func) ([]byte, error) {
+	timeout := q.timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- result{err: fmt.Errorf("panic: %v", p)}
+			}
+		}()
+		html, err := q.render(ctx, fn)
+		done <- result{html: html, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.html, r.err
+	case <-ctx.Done(): // The timer of the component.
+		return nil, ctx.Err()
+	}
+}
+
+// render calls fn and renders the component it resolves to. A deferred
+// component inside it is resolved in place, nothing would stream it.
+func (q *Queue) render(ctx context.Context, fn Func) ([]byte, error) {
+	c, err := fn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err // fn didn't honor its deadline.
+	}
+	var buf bytes.Buffer
+	if c != nil {
+		if err := c.Render(InPlace(context.WithValue(ctx, ctxKey{}, q)), &buf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func writeFragment(w io.Writer, id string, html []byte) error {
+	if _, err := io.WriteString(w, "<template>"); err != nil {
+		return err
+	}
+	if _, err := w.Write(html); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, `</template><script>(s=>{`+
+		`const t=s.previousElementSibling;`+
+		`document.getElementById("`+id+`")?.replaceWith(t.content);`+
+		`t.remove();s.remove()})(document.currentScript)</script>`)
+	return err
+}
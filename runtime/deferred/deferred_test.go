@@ -0,0 +1,139 @@
+package deferred_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages/runtime/deferred"
+)
+
+type text string
+
+func (t text) Render(_ context.Context, w io.Writer) error {
+	_, err := io.WriteString(w, string(t))
+	return err
+}
+
+func resolved(c deferred.Component, err error) deferred.Func {
+	return func(context.Context) (deferred.Component, error) { return c, err }
+}
+
+func TestResolve(t *testing.T) {
+	errFailed := errors.New("failed")
+	stuck := make(chan struct{})
+	defer close(stuck)
+	page := func(ctx context.Context, w io.Writer) {
+		for _, c := range []deferred.Component{
+			deferred.New(text("loading"), resolved(text("<p>a</p>"), nil)),
+			deferred.New(nil, resolved(nil, errFailed)),
+			deferred.New(text("slow"), func(ctx context.Context) (deferred.Component, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}),
+			deferred.New(text("stuck"), func(context.Context) (deferred.Component, error) {
+				<-stuck // Ignores its deadline.
+				return text("late"), nil
+			}),
+		} {
+			require.NoError(t, c.Render(ctx, w))
+		}
+	}
+
+	errs := map[string]error{}
+	ctx, q := deferred.Start(context.Background(), 50*time.Millisecond,
+		func(_ io.Writer, id string, err error) { errs[id] = err })
+	w := httptest.NewRecorder()
+	page(ctx, w)
+	require.Equal(t, 4, q.Len())
+	require.Equal(t, `<div id="datapages-deferred-1" style="display:contents">loading</div>`+
+		`<div id="datapages-deferred-2" style="display:contents"></div>`+
+		`<div id="datapages-deferred-3" style="display:contents">slow</div>`+
+		`<div id="datapages-deferred-4" style="display:contents">stuck</div>`,
+		w.Body.String())
+
+	w.Body.Reset()
+	q.Resolve(context.Background(), w)
+	require.True(t, w.Flushed)
+	require.Equal(t, `<template><p>a</p></template><script>(s=>{`+
+		`const t=s.previousElementSibling;`+
+		`document.getElementById("datapages-deferred-1")?.replaceWith(t.content);`+
+		`t.remove();s.remove()})(document.currentScript)</script>`, w.Body.String())
+	require.ErrorIs(t, errs["datapages-deferred-2"], errFailed)
+	require.ErrorIs(t, errs["datapages-deferred-3"], context.DeadlineExceeded)
+	require.ErrorIs(t, errs["datapages-deferred-4"], context.DeadlineExceeded)
+	require.Len(t, errs, 3)
+	require.Zero(t, q.Len())
+}
+
+func TestInPlace(t *testing.T) {
+	ctx, q := deferred.Start(deferred.InPlace(context.Background()), time.Second, nil)
+	require.Nil(t, q)
+	require.Zero(t, q.Len())
+
+	var b strings.Builder
+	inner := deferred.New(text("inner loading"), resolved(text("inner"), nil))
+	outer := deferred.New(text("loading"), resolved(inner, nil))
+	require.NoError(t, outer.Render(ctx, &b))
+	require.Equal(t, "inner", b.String())
+}
+
+// TestResolveNested covers a deferred component inside a deferred component,
+// which is resolved with it as nothing streams it afterwards.
+func TestResolveNested(t *testing.T) {
+	ctx, q := deferred.Start(context.Background(), time.Second,
+		func(_ io.Writer, id string, err error) {
+			t.Errorf("unexpected error for %s: %v", id, err)
+		})
+	inner := deferred.New(text("inner loading"), resolved(text("inner"), nil))
+	require.NoError(t, deferred.New(nil, resolved(inner, nil)).Render(ctx, io.Discard))
+
+	w := httptest.NewRecorder()
+	q.Resolve(context.Background(), w)
+	require.Contains(t, w.Body.String(), "<template>inner</template>")
+}
+
+// TestInPlaceError covers a component resolved in place that fails or
+// outlasts the timeout, which leaves its placeholder for onErr to write into
+// instead of failing the render of the page.
+func TestInPlaceError(t *testing.T) {
+	stuck := make(chan struct{})
+	defer close(stuck)
+	errFailed := errors.New("failed")
+	errs := map[string]error{}
+	ctx, q := deferred.Start(deferred.InPlace(context.Background()), 50*time.Millisecond,
+		func(w io.Writer, id string, err error) {
+			errs[id] = err
+			_, _ = io.WriteString(w, "<p>error</p>")
+		})
+	require.Nil(t, q)
+
+	var b strings.Builder
+	for _, c := range []deferred.Component{
+		deferred.New(text("loading"), resolved(text("a"), nil)),
+		deferred.New(text("loading"), resolved(nil, errFailed)),
+		deferred.New(text("stuck"), func(context.Context) (deferred.Component, error) {
+			<-stuck // Ignores its deadline.
+			return text("late"), nil
+		}),
+	} {
+		require.NoError(t, c.Render(ctx, &b))
+	}
+	require.Equal(t, `a`+
+		`<div id="datapages-deferred-1" style="display:contents">loading</div><p>error</p>`+
+		`<div id="datapages-deferred-2" style="display:contents">stuck</div><p>error</p>`,
+		b.String())
+	require.ErrorIs(t, errs["datapages-deferred-1"], errFailed)
+	require.ErrorIs(t, errs["datapages-deferred-2"], context.DeadlineExceeded)
+
+	// Without a page to report it to, the error fails the render.
+	err := deferred.New(text("loading"), resolved(nil, errFailed)).
+		Render(context.Background(), io.Discard)
+	require.ErrorIs(t, err, errFailed)
+}
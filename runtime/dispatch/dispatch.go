@@ -0,0 +1,122 @@
+// Package dispatch publishes and schedules the events of the generated
+// dispatchers, and holds the events an action dispatched until the action
+// succeeded. It backs the handlers that return datapages.DeferDispatch.
+//
+// Application code must not import this package.
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/romshark/datapages/modules/messaging"
+	"github.com/romshark/datapages/runtime/reqlog"
+	"github.com/romshark/datapages/runtime/tracing"
+)
+
+// Deferred collects publishes in the order they were dispatched and sends
+// them once the handler has returned. Nothing reaches the broker before
+// [Deferred.Publish], so a handler that fails or panics leaves no trace there.
+//
+// The zero value is empty and ready to use. It's safe for concurrent use,
+// a handler may dispatch from goroutines it waits for.
+type Deferred struct {
+	mu   sync.Mutex
+	msgs []message
+}
+
+type message struct {
+	ctx      context.Context
+	deadline time.Time
+	at       time.Time
+	subject  string
+	data     []byte
+}
+
+// Add holds one publish back. ctx is the context it was dispatched with,
+// whose values Publish hands to the broker. Its cancelation isn't: the
+// handler has committed to the events by the time they're sent, a client
+// hanging up then mustn't lose them. The deadline of ctx still bounds
+// the publish. A non-zero at schedules the publish for that time
+// once it's sent, see [Publish].
+func (d *Deferred) Add(ctx context.Context, at time.Time, subject string, data []byte) {
+	deadline, _ := ctx.Deadline()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.msgs = append(d.msgs, message{
+		ctx: context.WithoutCancel(ctx), deadline: deadline,
+		at: at, subject: subject, data: data,
+	})
+}
+
+// Drop empties d without sending anything and returns
+// how many publishes it held back.
+func (d *Deferred) Drop() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := len(d.msgs)
+	d.msgs = nil
+	return n
+}
+
+// Publish sends every publish held back to p in dispatch order and empties d.
+// It stops at the first one p refuses: the ones after it are dropped rather
+// than sent out of order.
+func (d *Deferred) Publish(p messaging.Publisher, metrics messaging.Metrics) error {
+	d.mu.Lock()
+	msgs := d.msgs
+	d.msgs = nil
+	d.mu.Unlock()
+
+	for _, m := range msgs {
+		if err := m.publish(p, metrics); err != nil {
+			return fmt.Errorf("publishing subject %q: %w", m.subject, err)
+		}
+	}
+	return nil
+}
+
+// publish sends m to p within the deadline it was dispatched with.
+func (m message) publish(p messaging.Publisher, metrics messaging.Metrics) error {
+	ctx := m.ctx
+	if !m.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, m.deadline)
+		defer cancel()
+	}
+	return Publish(ctx, p, metrics, m.at, m.subject, m.data)
+}
+
+// Publish publishes data to subject on p when at is zero.
+// Otherwise it schedules the publish for at, which p must support by
+// implementing messaging.Scheduler, or Publish returns messaging.ErrNoScheduler.
+// It returns messaging.ErrBrokerUnavailable while p reports
+// through messaging.StatusNotifier that it's down.
+//
+// A publish carries the span context and the request ID of ctx in its header,
+// the event handlers receiving it link their spans to it and log the ID.
+// A scheduled publish carries them too when p implements
+// messaging.HeaderScheduler.
+func Publish(
+	ctx context.Context, p messaging.Publisher, metrics messaging.Metrics,
+	at time.Time, subject string, data []byte,
+) error {
+	if up, _ := messaging.StatusOf(p); !up {
+		return messaging.ErrBrokerUnavailable
+	}
+	msg := messaging.Message{
+		Subject: subject,
+		Data:    data,
+		Header:  reqlog.MessageHeaderOf(ctx, tracing.Header(ctx, nil)),
+	}
+	if at.IsZero() {
+		return messaging.PublishMessage(ctx, p, metrics, msg)
+	}
+	s, ok := p.(messaging.Scheduler)
+	if !ok {
+		return messaging.ErrNoScheduler
+	}
+	return messaging.ScheduleMessage(ctx, s, metrics, at, msg)
+}
@@ -0,0 +1,251 @@
+package dispatch_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/romshark/datapages/modules/messaging"
+	"github.com/romshark/datapages/runtime/dispatch"
+	"github.com/romshark/datapages/runtime/reqlog"
+)
+
+// recordingPublisher records what it was asked to publish and refuses
+// the subject in refuse.
+type recordingPublisher struct {
+	refuse    string
+	published []string
+}
+
+var errRefused = errors.New("refused")
+
+func (p *recordingPublisher) Publish(
+	_ context.Context, _ messaging.Metrics, subject string, data []byte,
+) error {
+	if subject == p.refuse {
+		return errRefused
+	}
+	p.published = append(p.published, subject+"="+string(data))
+	return nil
+}
+
+// schedulingPublisher is a recordingPublisher that schedules too.
+type schedulingPublisher struct {
+	recordingPublisher
+	scheduled []string
+}
+
+func (p *schedulingPublisher) Schedule(
+	_ context.Context, _ messaging.Metrics, t time.Time, subject string, data []byte,
+) error {
+	p.scheduled = append(p.scheduled, t.Format(time.TimeOnly)+" "+subject+"="+string(data))
+	return nil
+}
+
+type noMetrics struct{}
+
+func (noMetrics) OnPublish(string)   {}
+func (noMetrics) OnDeliveryDropped() {}
+
+func TestDeferredPublishesInOrder(t *testing.T) {
+	t.Parallel()
+
+	var d dispatch.Deferred
+	ctx := context.Background()
+	d.Add(ctx, time.Time{}, "a", []byte("1"))
+	d.Add(ctx, time.Time{}, "b", []byte("2"))
+	d.Add(ctx, time.Time{}, "a", []byte("3"))
+
+	p := &recordingPublisher{}
+	require.NoError(t, d.Publish(p, noMetrics{}))
+	require.Equal(t, []string{"a=1", "b=2", "a=3"}, p.published)
+
+	// A second publish has nothing left to send.
+	require.NoError(t, d.Publish(p, noMetrics{}))
+	require.Len(t, p.published, 3)
+}
+
+func TestDeferredStopsAtFirstRefusal(t *testing.T) {
+	t.Parallel()
+
+	var d dispatch.Deferred
+	ctx := context.Background()
+	d.Add(ctx, time.Time{}, "a", []byte("1"))
+	d.Add(ctx, time.Time{}, "b", []byte("2"))
+	d.Add(ctx, time.Time{}, "c", []byte("3"))
+
+	p := &recordingPublisher{refuse: "b"}
+	err := d.Publish(p, noMetrics{})
+	require.ErrorIs(t, err, errRefused)
+	require.ErrorContains(t, err, `publishing subject "b"`)
+	require.Equal(t, []string{"a=1"}, p.published,
+		"a message after the refused one was sent out of order")
+}
+
+// ctxPublisher records the error of the context of each publish.
+type ctxPublisher struct{ errs []error }
+
+func (p *ctxPublisher) Publish(
+	ctx context.Context, _ messaging.Metrics, _ string, _ []byte,
+) error {
+	p.errs = append(p.errs, ctx.Err())
+	return nil
+}
+
+func TestDeferredOutlivesCancelation(t *testing.T) {
+	t.Parallel()
+
+	var d dispatch.Deferred
+	ctx, cancel := context.WithCancel(context.Background())
+	d.Add(ctx, time.Time{}, "a", []byte("1"))
+	past, cancelPast := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancelPast()
+	d.Add(past, time.Time{}, "b", []byte("2"))
+	cancel() // The client hung up after the handler returned.
+
+	p := &ctxPublisher{}
+	require.NoError(t, d.Publish(p, noMetrics{}))
+	require.Equal(t, []error{nil, context.DeadlineExceeded}, p.errs,
+		"the deadline of the request still bounds the publish")
+}
+
+func TestDeferredDrop(t *testing.T) {
+	t.Parallel()
+
+	var d dispatch.Deferred
+	d.Add(context.Background(), time.Time{}, "a", []byte("1"))
+	d.Add(context.Background(), time.Time{}, "b", []byte("2"))
+	require.Equal(t, 2, d.Drop())
+	require.Zero(t, d.Drop())
+
+	p := &recordingPublisher{}
+	require.NoError(t, d.Publish(p, noMetrics{}))
+	require.Empty(t, p.published)
+}
+
+func TestPublishSchedules(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	at := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	p := &schedulingPublisher{}
+	require.NoError(t, dispatch.Publish(ctx, p, noMetrics{}, time.Time{}, "a", []byte("1")))
+	require.NoError(t, dispatch.Publish(ctx, p, noMetrics{}, at, "b", []byte("2")))
+	require.Equal(t, []string{"a=1"}, p.published)
+	require.Equal(t, []string{"15:04:05 b=2"}, p.scheduled)
+
+	// A broker that can't schedule refuses instead of publishing right away.
+	err := dispatch.Publish(ctx, &recordingPublisher{}, noMetrics{}, at, "b", nil)
+	require.ErrorIs(t, err, messaging.ErrNoScheduler)
+}
+
+func TestDeferredSchedules(t *testing.T) {
+	t.Parallel()
+
+	var d dispatch.Deferred
+	ctx := context.Background()
+	at := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	d.Add(ctx, time.Time{}, "a", []byte("1"))
+	d.Add(ctx, at, "b", []byte("2"))
+
+	p := &schedulingPublisher{}
+	require.NoError(t, d.Publish(p, noMetrics{}))
+	require.Equal(t, []string{"a=1"}, p.published)
+	require.Equal(t, []string{"15:04:05 b=2"}, p.scheduled)
+}
+
+func TestPublishBrokerDown(t *testing.T) {
+	t.Parallel()
+
+	p := &struct {
+		schedulingPublisher
+		messaging.ConnStatus
+	}{}
+	p.Set(false)
+	ctx := context.Background()
+	at := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	err := dispatch.Publish(ctx, p, noMetrics{}, time.Time{}, "a", []byte("1"))
+	require.ErrorIs(t, err, messaging.ErrBrokerUnavailable)
+	err = dispatch.Publish(ctx, p, noMetrics{}, at, "a", []byte("2"))
+	require.ErrorIs(t, err, messaging.ErrBrokerUnavailable)
+	require.Empty(t, p.published)
+	require.Empty(t, p.scheduled)
+
+	p.Set(true)
+	require.NoError(t, dispatch.Publish(ctx, p, noMetrics{}, time.Time{}, "a", []byte("3")))
+	require.Equal(t, []string{"a=3"}, p.published)
+}
+
+// headerPublisher records the header of what it publishes.
+type headerPublisher struct {
+	recordingPublisher
+	headers []messaging.Header
+}
+
+func (p *headerPublisher) PublishMessage(
+	ctx context.Context, metrics messaging.Metrics, msg messaging.Message,
+) error {
+	p.headers = append(p.headers, msg.Header)
+	return p.Publish(ctx, metrics, msg.Subject, msg.Data)
+}
+
+func TestPublishCarriesSpanContext(t *testing.T) {
+	t.Parallel()
+
+	tp := sdktrace.NewTracerProvider()
+	ctx, span := tp.Tracer("test").Start(context.Background(), "action")
+	defer span.End()
+
+	p := &headerPublisher{}
+	require.NoError(t, dispatch.Publish(ctx, p, noMetrics{}, time.Time{}, "a", []byte("1")))
+	require.NoError(t, dispatch.Publish(
+		context.Background(), p, noMetrics{}, time.Time{}, "b", []byte("2"),
+	))
+	require.Equal(t, []string{"a=1", "b=2"}, p.published)
+
+	sc := span.SpanContext()
+	require.Equal(t, messaging.Header{
+		"traceparent": "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-01",
+	}, p.headers[0])
+	require.Nil(t, p.headers[1], "no span, no header")
+}
+
+func TestPublishCarriesRequestID(t *testing.T) {
+	t.Parallel()
+
+	ctx := reqlog.NewContext(context.Background(), reqlog.New(slog.Default(), "req-1"))
+	p := &headerPublisher{}
+	require.NoError(t, dispatch.Publish(ctx, p, noMetrics{}, time.Time{}, "a", []byte("1")))
+	require.Equal(t, messaging.Header{reqlog.MessageHeader: "req-1"}, p.headers[0])
+}
+
+// headerScheduler records the header of what it schedules.
+type headerScheduler struct {
+	schedulingPublisher
+	headers []messaging.Header
+}
+
+func (p *headerScheduler) ScheduleMessage(
+	ctx context.Context, metrics messaging.Metrics, t time.Time, msg messaging.Message,
+) error {
+	p.headers = append(p.headers, msg.Header)
+	return p.Schedule(ctx, metrics, t, msg.Subject, msg.Data)
+}
+
+func TestScheduleCarriesRequestID(t *testing.T) {
+	t.Parallel()
+
+	ctx := reqlog.NewContext(context.Background(), reqlog.New(slog.Default(), "req-1"))
+	at := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	p := &headerScheduler{}
+	require.NoError(t, dispatch.Publish(ctx, p, noMetrics{}, at, "a", []byte("1")))
+	require.Equal(t, []string{"15:04:05 a=1"}, p.scheduled)
+	require.Equal(t, []messaging.Header{{reqlog.MessageHeader: "req-1"}}, p.headers)
+}
@@ -0,0 +1,115 @@
+// Package eventcache decodes a message once for every stream of the process
+// that receives it. A broker hands each subscription its own copy of a
+// message, and each stream used to decode its copy on its own.
+//
+// Application code must not import this package.
+package eventcache
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/romshark/datapages/modules/codec"
+	"github.com/romshark/datapages/modules/messaging"
+)
+
+// DefaultSize is how many messages a Cache keeps by default.
+// The streams of one process receive a message within moments of each other,
+// so the cache only needs to hold the messages of a short burst.
+const DefaultSize = 256
+
+// Cache holds the decoded values of the most recent messages,
+// keyed by subject, payload and the type they were decoded into.
+// It's safe for concurrent use.
+type Cache struct {
+	lock sync.Mutex
+	// entries holds the entries by subject and type, then by payload.
+	entries map[target]map[string]*entry
+	// ring holds the keys of the entries in insertion order.
+	// The oldest one is evicted to make room.
+	ring []key
+	next int
+}
+
+// target is what a payload is decoded for: the events of one subject
+// may be decoded into different types, by the pages of different packages.
+type target struct {
+	subject string
+	typ     reflect.Type
+}
+
+type key struct {
+	target
+	data string
+}
+
+type entry struct {
+	once sync.Once
+	v    any
+	err  error
+}
+
+// New creates a cache that keeps the last size messages.
+// A non-positive size selects DefaultSize.
+func New(size int) *Cache {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &Cache{
+		entries: make(map[target]map[string]*entry),
+		ring:    make([]key, size),
+	}
+}
+
+// Decode returns msg decoded into a T by c. The first stream to ask for a
+// message decodes it, the others receive the same value. Equal payloads on
+// the same subject decode into equal values, which is what makes the cache
+// safe to key by content.
+//
+// Every stream receives a copy of the value, which it may modify.
+// The copy is shallow: slices, maps and pointers in it are the same for every
+// stream and are read-only, the event handlers must not modify what they
+// point to.
+func Decode[T any](cache *Cache, c codec.Codec, msg messaging.Message) (T, error) {
+	e := cache.entry(target{msg.Subject, reflect.TypeFor[T]()}, msg.Data)
+	e.once.Do(func() {
+		var v T
+		e.err = c.Unmarshal(msg.Data, &v)
+		e.v = v
+	})
+	if e.err != nil {
+		var zero T
+		return zero, e.err
+	}
+	return e.v.(T), nil
+}
+
+func (c *Cache) entry(t target, data []byte) *entry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	byTarget := c.entries[t]
+	if e := byTarget[string(data)]; e != nil {
+		return e
+	}
+
+	if old := c.ring[c.next]; old.typ != nil {
+		if m := c.entries[old.target]; m != nil {
+			delete(m, old.data)
+			if len(m) == 0 {
+				delete(c.entries, old.target)
+			}
+		}
+	}
+	if byTarget == nil {
+		byTarget = make(map[string]*entry)
+		c.entries[t] = byTarget
+	}
+	k := key{target: t, data: string(data)}
+	c.ring[c.next] = k
+	c.next = (c.next + 1) % len(c.ring)
+
+	e := new(entry)
+	byTarget[k.data] = e
+	return e
+}
@@ -0,0 +1,137 @@
+package eventcache_test
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages/modules/codec"
+	"github.com/romshark/datapages/modules/messaging"
+	"github.com/romshark/datapages/runtime/eventcache"
+)
+
+type event struct {
+	N int `json:"n"`
+}
+
+// countingCodec counts the payloads it decodes.
+type countingCodec struct {
+	codec.JSON
+	decoded atomic.Int64
+}
+
+func (c *countingCodec) Unmarshal(data []byte, v any) error {
+	c.decoded.Add(1)
+	return c.JSON.Unmarshal(data, v)
+}
+
+func TestDecodeOncePerMessage(t *testing.T) {
+	cache := eventcache.New(0)
+	c := new(countingCodec)
+	msg := messaging.Message{Subject: "tick", Data: []byte(`{"n":1}`)}
+
+	// Every stream has its own copy of the message.
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Go(func() {
+			own := messaging.Message{Subject: msg.Subject, Data: []byte(string(msg.Data))}
+			v, err := eventcache.Decode[event](cache, c, own)
+			require.NoError(t, err)
+			require.Equal(t, event{N: 1}, v)
+		})
+	}
+	wg.Wait()
+	require.Equal(t, int64(1), c.decoded.Load())
+
+	// The same payload on another subject is another event.
+	_, err := eventcache.Decode[event](cache, c,
+		messaging.Message{Subject: "tock", Data: msg.Data})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), c.decoded.Load())
+}
+
+// TestDecodeByType covers a payload of one subject decoded into two types,
+// as the pages of two packages declaring their own event type would.
+func TestDecodeByType(t *testing.T) {
+	type other struct {
+		N string `json:"n"`
+	}
+	cache := eventcache.New(0)
+	c := new(countingCodec)
+	msg := messaging.Message{Subject: "tick", Data: []byte(`{"n":1}`)}
+
+	v, err := eventcache.Decode[event](cache, c, msg)
+	require.NoError(t, err)
+	require.Equal(t, event{N: 1}, v)
+	_, err = eventcache.Decode[other](cache, c, msg)
+	require.Error(t, err, "the value of another type was reused")
+	require.Equal(t, int64(2), c.decoded.Load())
+}
+
+// TestDecodeCopies covers the streams modifying the value they received,
+// each its own copy of it, which -race checks.
+func TestDecodeCopies(t *testing.T) {
+	type tagged struct {
+		N    int      `json:"n"`
+		Tags []string `json:"tags"`
+	}
+	cache := eventcache.New(0)
+	msg := messaging.Message{Subject: "tick", Data: []byte(`{"n":1,"tags":["a"]}`)}
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Go(func() {
+			v, err := eventcache.Decode[tagged](cache, codec.JSON{}, msg)
+			require.NoError(t, err)
+			require.Equal(t, 1, v.N)
+			require.Equal(t, []string{"a"}, v.Tags)
+			v.N = i // A wildcard handler sets the values of its subject.
+		})
+	}
+	wg.Wait()
+}
+
+func TestDecodeError(t *testing.T) {
+	cache := eventcache.New(0)
+	c := new(countingCodec)
+	msg := messaging.Message{Subject: "tick", Data: []byte(`{"n":`)}
+
+	for range 2 {
+		_, err := eventcache.Decode[event](cache, c, msg)
+		require.Error(t, err)
+	}
+	require.Equal(t, int64(1), c.decoded.Load())
+}
+
+func TestEviction(t *testing.T) {
+	cache := eventcache.New(2)
+	c := new(countingCodec)
+	msg := func(n int) messaging.Message {
+		return messaging.Message{Subject: "tick", Data: []byte(`{"n":` + strconv.Itoa(n) + `}`)}
+	}
+
+	for n := range 3 {
+		v, err := eventcache.Decode[event](cache, c, msg(n))
+		require.NoError(t, err)
+		require.Equal(t, n, v.N)
+	}
+	require.Equal(t, int64(3), c.decoded.Load())
+
+	// 1 and 2 are still cached, 0 was evicted.
+	_, _ = eventcache.Decode[event](cache, c, msg(2))
+	_, _ = eventcache.Decode[event](cache, c, msg(1))
+	require.Equal(t, int64(3), c.decoded.Load())
+	_, _ = eventcache.Decode[event](cache, c, msg(0))
+	require.Equal(t, int64(4), c.decoded.Load())
+}
+
+func BenchmarkDecode(b *testing.B) {
+	cache := eventcache.New(0)
+	msg := messaging.Message{Subject: "tick", Data: []byte(`{"n":1}`)}
+	for b.Loop() {
+		_, _ = eventcache.Decode[event](cache, codec.JSON{}, msg)
+	}
+}
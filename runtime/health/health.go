@@ -0,0 +1,102 @@
+// Package health serves the liveness and readiness endpoints of a generated
+// server, on its metrics server or on a server of their own.
+//
+// Application code must not import this package.
+package health
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Timeout bounds the checks of one readiness probe.
+const Timeout = 5 * time.Second
+
+// Check returns nil when what it checks is usable.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker holds the readiness checks and whether the server is shutting down.
+// The zero value has no checks and is ready. It's safe for concurrent use.
+type Checker struct {
+	lock     sync.Mutex
+	checks   []namedCheck
+	draining atomic.Bool
+}
+
+// Add adds check under name, /readyz reports it by that name.
+func (c *Checker) Add(name string, check Check) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Drain fails every readiness probe from here on. The server calls it as
+// soon as its shutdown begins, so load balancers stop sending it traffic
+// while the open requests finish.
+func (c *Checker) Drain() { c.draining.Store(true) }
+
+// Register registers /healthz and /readyz on mux.
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", c.serveLive)
+	mux.HandleFunc("GET /readyz", c.serveReady)
+}
+
+// serveLive answers the liveness probe. A process that answers is alive,
+// its dependencies are what readiness is for.
+func (c *Checker) serveLive(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// serveReady runs the checks concurrently and answers 503 when the server is
+// shutting down or any of them fails. The body has a line per check.
+func (c *Checker) serveReady(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if c.draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("shutting down\n"))
+		return
+	}
+
+	c.lock.Lock()
+	checks := c.checks
+	c.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(r.Context(), Timeout)
+	defer cancel()
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, ch := range checks {
+		wg.Go(func() { errs[i] = ch.check(ctx) })
+	}
+	wg.Wait()
+
+	var b strings.Builder
+	status := http.StatusOK
+	for i, ch := range checks {
+		b.WriteString(ch.name)
+		if errs[i] != nil {
+			status = http.StatusServiceUnavailable
+			b.WriteString(": ")
+			b.WriteString(errs[i].Error())
+		} else {
+			b.WriteString(": ok")
+		}
+		b.WriteByte('\n')
+	}
+	if status == http.StatusOK {
+		b.WriteString("ok\n")
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(b.String()))
+}
@@ -0,0 +1,68 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages/runtime/health"
+)
+
+func probe(t *testing.T, c *health.Checker, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	mux := http.NewServeMux()
+	c.Register(mux)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestReady(t *testing.T) {
+	t.Parallel()
+	var c health.Checker
+	require.Equal(t, "ok\n", probe(t, &c, "/readyz").Body.String())
+
+	c.Add("broker", func(ctx context.Context) error {
+		_, ok := ctx.Deadline()
+		require.True(t, ok, "a check runs with a deadline")
+		return nil
+	})
+	w := probe(t, &c, "/readyz")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "broker: ok\nok\n", w.Body.String())
+}
+
+func TestNotReady(t *testing.T) {
+	t.Parallel()
+	var c health.Checker
+	c.Add("broker", func(context.Context) error { return nil })
+	c.Add("sessions", func(context.Context) error { return errors.New("unreachable") })
+
+	w := probe(t, &c, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, "broker: ok\nsessions: unreachable\n", w.Body.String())
+}
+
+// TestDrain covers the shutdown: readiness fails before anything it checks
+// does, liveness carries on.
+func TestDrain(t *testing.T) {
+	t.Parallel()
+	var c health.Checker
+	c.Add("broker", func(context.Context) error {
+		t.Error("a draining server checks nothing")
+		return nil
+	})
+	c.Drain()
+
+	w := probe(t, &c, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, "shutting down\n", w.Body.String())
+
+	w = probe(t, &c, "/healthz")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "ok\n", w.Body.String())
+}
@@ -5,17 +5,27 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
 	"net"
 	"net/http"
 	"os"
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sync/errgroup"
 
 	"github.com/romshark/datapages"
+	"github.com/romshark/datapages/modules/tabstate"
+	"github.com/romshark/datapages/modules/tabstate/inmem"
+	"github.com/romshark/datapages/runtime/deferred"
+	"github.com/romshark/datapages/runtime/health"
+	"github.com/romshark/datapages/runtime/internal/httpstat"
+	"github.com/romshark/datapages/runtime/reqlog"
+	"github.com/romshark/datapages/runtime/streammux"
+	"github.com/romshark/datapages/runtime/tabs"
 )
 
 const (
@@ -42,13 +52,30 @@ type Core struct {
 
 	shutdownCh   chan struct{} // Closed when shutting down.
 	shutdownOnce sync.Once
-	runCancel    context.CancelFunc
+	// shutdownCalled is set by the first call of Shutdown,
+	// shutdownDone is closed once it returned.
+	shutdownCalled atomic.Bool
+	shutdownDone   chan struct{}
+	runCancel      context.CancelFunc
+	// baseCancel ends the requests still running once the shutdown is done.
+	baseCancel context.CancelFunc
+	// drainUntil is when the drain of the streams must be done by,
+	// the zero time for no deadline. Set before shutdownCh is closed.
+	drainUntil time.Time
 
 	httpServer    *http.Server
 	metricsServer *http.Server
+	healthServer  *http.Server
+	health        *health.Checker
+	readyDelay    time.Duration
 	mux           *http.ServeMux
 	handler       http.Handler
 	logger        *slog.Logger
+	accessLog     *datapages.AccessLogConfig
+	streams       datapages.StreamsConfig
+	deferredTTL   time.Duration
+	tabStore      tabstate.Store
+	tabTTL        time.Duration
 	middleware    []func(http.Handler) http.Handler
 	outermost     func(http.Handler) http.Handler
 	assetsFS      http.FileSystem
@@ -63,15 +90,33 @@ func NewCore(cfg datapages.ServerConfig, assetsURLPrefix string) *Core {
 	c := &Core{
 		assetsURLPrefix: assetsURLPrefix,
 		shutdownCh:      make(chan struct{}),
+		shutdownDone:    make(chan struct{}),
 		mux:             http.NewServeMux(),
 		middleware:      cfg.Middleware,
 		outermost:       cfg.OutermostMiddleware,
 		metricsServer:   cfg.MetricsServer,
+		healthServer:    cfg.HealthServer,
+		health:          cfg.Health,
+		readyDelay:      cfg.ReadinessDelay,
 		assetsFS:        cfg.AssetsFS,
 		datastarJSSrc:   cfg.DatastarJS,
 		logger:          cfg.Logger,
+		accessLog:       cfg.AccessLog,
+		streams:         cfg.Streams,
+		deferredTTL:     cfg.DeferredTimeout,
+		tabStore:        cfg.TabState.Store,
+		tabTTL:          cfg.TabState.TTL,
 		httpServer:      cfg.HTTPServer,
 	}
+	if c.tabStore == nil {
+		c.tabStore = inmem.New()
+	}
+	if c.tabTTL == 0 {
+		c.tabTTL = tabs.DefaultTTL
+	}
+	if p, ok := c.tabStore.(tabstate.Pinger); ok {
+		c.AddReadinessCheck("tabstate", p.Ping)
+	}
 	if c.httpServer == nil {
 		c.httpServer = &http.Server{
 			// Time to read request headers + body
@@ -97,7 +142,16 @@ func (c *Core) Build() {
 	if c.datastarJSSrc == "" {
 		c.datastarJSSrc = DefaultDatastarJSSrc
 	}
-	c.htmlPrefix = `<!DOCTYPE html><html><head><meta charset="UTF-8"/>
+	c.htmlPrefix = `<!DOCTYPE html><html><head><meta charset="UTF-8"/>`
+	if c.streams.Shared {
+		// The streams are routed through the leader before Datastar opens any.
+		m := streammux.New(c, c.streams.Heartbeat, c.shutdownCh)
+		c.mux.HandleFunc("GET "+streammux.Path+"{$}", m.ServeStream)
+		c.mux.HandleFunc("POST "+streammux.Path+"{$}", m.ServeControl)
+		c.mux.HandleFunc("GET "+streammux.WorkerPath+"{$}", streammux.ServeWorker)
+		c.htmlPrefix += streammux.Script
+	}
+	c.htmlPrefix += `
 		<script type="module" src="` + c.datastarJSSrc + `"></script>`
 
 	if c.logger == nil {
@@ -118,9 +172,11 @@ func (c *Core) Build() {
 	for _, h := range c.middleware {
 		c.handler = h(c.handler)
 	}
+	c.handler = c.logRequests(c.handler)
 	if c.outermost != nil {
 		c.handler = c.outermost(c.handler)
 	}
+	c.handler = c.identifyRequests(c.handler)
 	c.httpServer.Handler = c
 }
 
@@ -135,6 +191,160 @@ func (c *Core) LogErr(msg string, err error) {
 	c.logger.Error(msg, slog.Any("err", err))
 }
 
+// RequestLogger is the logger of the request ctx belongs to,
+// see datapages.Logger, or the server's logger without one.
+func (c *Core) RequestLogger(ctx context.Context) *slog.Logger {
+	if l := reqlog.Logger(ctx); l != nil {
+		return l
+	}
+	return c.logger
+}
+
+// LogErrCtx logs err under msg to the [Core.RequestLogger] of ctx.
+func (c *Core) LogErrCtx(ctx context.Context, msg string, err error) {
+	c.RequestLogger(ctx).Error(msg, slog.Any("err", err))
+}
+
+// identifyRequests gives every request an ID and the log entry carrying it.
+// It wraps the outermost middleware, which reads the route the request
+// matched from the entry.
+func (c *Core) identifyRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(reqlog.Header)
+		if !reqlog.ValidID(id) {
+			id = reqlog.NewID()
+		}
+		w.Header().Set(reqlog.Header, id)
+		e := reqlog.New(c.logger, id)
+		next.ServeHTTP(w, r.WithContext(reqlog.NewContext(r.Context(), e)))
+	})
+}
+
+// logRequests records the route the request matched on its log entry
+// and writes the access log when it's on. It runs inside the outermost
+// middleware and outside the application's, which log with the ID too.
+func (c *Core) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := reqlog.FromContext(r.Context())
+
+		if c.accessLog == nil ||
+			(c.accessLog.Skip != nil && c.accessLog.Skip(r)) {
+			next.ServeHTTP(w, r)
+			e.SetRoute(r.Pattern)
+			return
+		}
+
+		start := time.Now()
+		rw := httpstat.NewWriter(w)
+		next.ServeHTTP(rw, r)
+		e.SetRoute(r.Pattern)
+
+		attrs := append(e.Attrs(),
+			slog.String("method", r.Method),
+			slog.String("route", httpstat.Route(r)),
+			slog.Int("status", rw.Status),
+			slog.Duration("duration", time.Since(start)),
+		)
+		c.logger.Log(r.Context(), c.accessLog.Level, "request", attrs...)
+	})
+}
+
+// StreamTimers returns the timers of a stream opening now: heartbeat
+// receives every [datapages.StreamsConfig.Heartbeat], expired once the
+// stream reached its lifetime. Either is nil when not configured.
+// stop releases them.
+func (c *Core) StreamTimers() (heartbeat, expired <-chan time.Time, stop func()) {
+	var ticker *time.Ticker
+	var timer *time.Timer
+	if c.streams.Heartbeat > 0 {
+		ticker = time.NewTicker(c.streams.Heartbeat)
+		heartbeat = ticker.C
+	}
+	if c.streams.MaxLifetime > 0 {
+		timer = time.NewTimer(c.StreamLifetime())
+		expired = timer.C
+	}
+	return heartbeat, expired, func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// StreamLifetime returns the lifetime of a stream opening now,
+// [datapages.StreamsConfig.MaxLifetime] shortened by a random jitter.
+// Zero when streams live for as long as their client stays.
+func (c *Core) StreamLifetime() time.Duration {
+	if c.streams.MaxLifetimeJitter <= 0 {
+		return c.streams.MaxLifetime
+	}
+	return c.streams.MaxLifetime - rand.N(c.streams.MaxLifetimeJitter+1)
+}
+
+// StreamRetry is how long a client is told to wait before it reconnects
+// a stream that reached its lifetime. Zero leaves it to the client.
+func (c *Core) StreamRetry() time.Duration { return c.streams.Retry }
+
+// DeferredTimeout bounds the resolution of each deferred component.
+func (c *Core) DeferredTimeout() time.Duration {
+	if c.deferredTTL <= 0 {
+		return deferred.DefaultTimeout
+	}
+	return c.deferredTTL
+}
+
+// Tab returns the state of the tab with the instance ID id
+// that belongs to the session of user, "" for a guest.
+func (c *Core) Tab(id, user string) tabs.Tab {
+	return tabs.Tab{Store: c.tabStore, ID: id, User: user, TTL: c.tabTTL}
+}
+
+// Draining reports whether the shutdown began, after which
+// no new stream is opened.
+func (c *Core) Draining() bool {
+	select {
+	case <-c.shutdownCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// DrainTimer returns the channel receiving when a stream draining since
+// now is to disconnect: at a random moment of the
+// [datapages.StreamsConfig.DrainWindow], immediately without one.
+func (c *Core) DrainTimer() <-chan time.Time {
+	window := c.streams.DrainWindow
+	if !c.drainUntil.IsZero() {
+		// A tenth of the time left is for the streams to close.
+		if left := time.Until(c.drainUntil) * 9 / 10; left < window {
+			window = left
+		}
+	}
+	if window <= 0 {
+		return time.After(0)
+	}
+	return time.After(rand.N(window))
+}
+
+// DrainRetry returns the retry hint of a stream the drain disconnects:
+// a random duration between [datapages.StreamsConfig.Retry], or a second
+// without one, and twice that. Zero without a drain window and a retry,
+// which leaves it to the client.
+func (c *Core) DrainRetry() time.Duration {
+	if c.streams.DrainWindow <= 0 && c.streams.Retry <= 0 {
+		return 0
+	}
+	base := c.streams.Retry
+	if base <= 0 {
+		base = time.Second
+	}
+	return base + rand.N(base+1)
+}
+
 // ShutdownCh is closed when the shutdown begins.
 func (c *Core) ShutdownCh() <-chan struct{} { return c.shutdownCh }
 
@@ -147,6 +357,14 @@ func (c *Core) AssetsFS() http.FileSystem { return c.assetsFS }
 // MetricsEnabled reports whether a metrics server is configured.
 func (c *Core) MetricsEnabled() bool { return c.metricsServer != nil }
 
+// AddReadinessCheck adds check to the readiness probe under name.
+// No-op without probes, see [datapages.WithHealth].
+func (c *Core) AddReadinessCheck(name string, check func(ctx context.Context) error) {
+	if c.health != nil {
+		c.health.Add(name, check)
+	}
+}
+
 // TLSEnabled reports whether the server listens for HTTPS connections.
 func (c *Core) TLSEnabled() bool { return c.enabledTLS }
 
@@ -167,7 +385,9 @@ func (c *Core) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // ListenAndServe starts the HTTP server.
 //
-// The provided context controls graceful shutdown.
+// The provided context controls graceful shutdown: once it's done the server
+// shuts down within 10 seconds, unless [Core.Shutdown] was called,
+// whose context bounds the shutdown instead.
 func (c *Core) ListenAndServe(ctx context.Context, addr string) error {
 	c.httpServer.Addr = addr
 	c.enabledTLS = false
@@ -201,7 +421,11 @@ func (c *Core) listenAndServe(
 
 	g, ctx := errgroup.WithContext(ctx)
 
-	c.httpServer.BaseContext = func(net.Listener) context.Context { return ctx }
+	// The requests outlive the start of the shutdown, which the streams
+	// drain in. They end with it at the latest.
+	base, baseCancel := context.WithCancel(context.WithoutCancel(ctx))
+	c.baseCancel = baseCancel
+	c.httpServer.BaseContext = func(net.Listener) context.Context { return base }
 
 	// Main frontend server
 	g.Go(func() error {
@@ -226,9 +450,29 @@ func (c *Core) listenAndServe(
 		})
 	}
 
+	// Probe server
+	if c.healthServer != nil {
+		c.healthServer.BaseContext = func(net.Listener) context.Context {
+			return ctx
+		}
+		g.Go(func() error {
+			err := c.healthServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return fmt.Errorf("health server failed: %w", err)
+			}
+			return nil
+		})
+	}
+
 	// Coordinated shutdown
 	g.Go(func() error {
 		<-ctx.Done()
+		if !c.shutdownCalled.CompareAndSwap(false, true) {
+			// Shutdown was called, which canceled ctx. It shuts
+			// the server down by the deadline of its own context.
+			<-c.shutdownDone
+			return nil
+		}
 
 		shutdownCtx, cancel := context.WithTimeout(
 			context.Background(), 10*time.Second,
@@ -243,22 +487,64 @@ func (c *Core) listenAndServe(
 }
 
 // Shutdown gracefully shuts down all server components.
+// The readiness probe fails from the start, the metrics and the health
+// server keep answering it until the application server is done.
+// The server goes on as before for the readiness delay of
+// [datapages.HealthConfig] so the load balancers notice,
+// then the listeners close.
+// The streams drain over [datapages.StreamsConfig.DrainWindow] before
+// the deadline of ctx, the requests still running when ctx ends
+// are canceled.
 func (c *Core) Shutdown(ctx context.Context) error {
+	c.shutdownCalled.Store(true)
+	first := false
 	c.shutdownOnce.Do(func() {
+		first = true
 		c.logger.Info("server shutdown initiated")
+		if c.health != nil {
+			c.health.Drain()
+			c.awaitReadinessDelay(ctx)
+		}
 		if c.runCancel != nil {
 			c.runCancel()
 		}
+		if deadline, ok := ctx.Deadline(); ok {
+			c.drainUntil = deadline
+		}
 		close(c.shutdownCh)
 	})
-	var errs []error
-	if c.metricsServer != nil {
-		if err := c.metricsServer.Shutdown(ctx); err != nil {
-			errs = append(errs, err)
-		}
+	if first {
+		defer close(c.shutdownDone)
 	}
+	var errs []error
 	if err := c.httpServer.Shutdown(ctx); err != nil {
 		errs = append(errs, err)
 	}
+	if c.baseCancel != nil {
+		c.baseCancel()
+	}
+	for _, srv := range []*http.Server{c.metricsServer, c.healthServer} {
+		if srv == nil {
+			continue
+		}
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
 	return errors.Join(errs...)
 }
+
+// awaitReadinessDelay waits out the readiness delay, or until ctx is done.
+func (c *Core) awaitReadinessDelay(ctx context.Context) {
+	if c.readyDelay <= 0 {
+		return
+	}
+	c.logger.Info("awaiting readiness propagation",
+		slog.Duration("delay", c.readyDelay))
+	t := time.NewTimer(c.readyDelay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
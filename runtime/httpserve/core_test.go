@@ -1,17 +1,29 @@
 package httpserve_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 
 	"github.com/romshark/datapages"
+	"github.com/romshark/datapages/modules/tabstate/inmem"
 	"github.com/romshark/datapages/runtime/httpserve"
+	"github.com/romshark/datapages/runtime/internal/httpstat"
+	"github.com/romshark/datapages/runtime/reqlog"
+	"github.com/romshark/datapages/runtime/streammux"
+	"github.com/romshark/datapages/runtime/tabs"
 )
 
 // echoPath answers with the path it was routed with.
@@ -98,6 +110,46 @@ func TestMiddlewareOrder(t *testing.T) {
 	require.Equal(t, []string{"outermost", "first", "second", "handler"}, order)
 }
 
+// TestOutermostSeesRoute covers that the outermost middleware learns the
+// route the request matched, also when the application's middleware routed
+// a copy of the request, and that the request the server got is left as is.
+func TestOutermostSeesRoute(t *testing.T) {
+	t.Parallel()
+
+	var route string
+	c := httpserve.NewCore(datapages.ServerConfig{
+		Middleware: []func(http.Handler) http.Handler{
+			func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Query().Has("copy") {
+						r = r.WithContext(r.Context())
+					}
+					next.ServeHTTP(w, r)
+				})
+			},
+		},
+		OutermostMiddleware: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r)
+				route = httpstat.Route(r)
+			})
+		},
+	}, "")
+	c.Mux().HandleFunc("GET /items/{id}/", func(_ http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("copy") {
+			reqlog.SetHandler(r, "PageItem.GET")
+		}
+	})
+	c.Build()
+
+	for _, path := range []string{"/items/1/", "/items/1/?copy"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		c.ServeHTTP(httptest.NewRecorder(), req)
+		require.Equal(t, "GET /items/{id}/", route, path)
+		require.Empty(t, req.Pattern, path)
+	}
+}
+
 func TestBuildDefaults(t *testing.T) {
 	t.Parallel()
 
@@ -123,6 +175,96 @@ func TestDatastarJSOption(t *testing.T) {
 	require.Contains(t, c.HTMLPrefix(), `src="/static/ds.js"`)
 }
 
+func TestSharedStreams(t *testing.T) {
+	t.Parallel()
+
+	c := httpserve.NewCore(datapages.ServerConfig{}, "")
+	c.Build()
+	require.NotContains(t, c.HTMLPrefix(), streammux.Script)
+	require.Equal(t, http.StatusNotFound, serve(t, c, streammux.WorkerPath).Code)
+
+	c = httpserve.NewCore(datapages.ServerConfig{
+		Streams: datapages.StreamsConfig{Shared: true},
+	}, "")
+	c.Build()
+	// The script wraps fetch before Datastar opens any stream.
+	prefix := c.HTMLPrefix()
+	require.Less(t,
+		strings.Index(prefix, streammux.Script),
+		strings.Index(prefix, httpserve.DefaultDatastarJSSrc))
+	require.True(t, strings.HasPrefix(prefix, "<!DOCTYPE html>"))
+	w := serve(t, c, strings.TrimSuffix(streammux.WorkerPath, "/"))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/javascript; charset=utf-8", w.Header().Get("Content-Type"))
+}
+
+func TestStreamTimers(t *testing.T) {
+	t.Parallel()
+
+	c := httpserve.NewCore(datapages.ServerConfig{}, "")
+	heartbeat, expired, stop := c.StreamTimers()
+	stop()
+	require.Nil(t, heartbeat)
+	require.Nil(t, expired, "a stream without a lifetime must never expire")
+
+	c = httpserve.NewCore(datapages.ServerConfig{
+		Streams: datapages.StreamsConfig{
+			Heartbeat:         time.Millisecond,
+			MaxLifetime:       time.Hour,
+			MaxLifetimeJitter: 10 * time.Minute,
+			Retry:             3 * time.Second,
+		},
+	}, "")
+	for range 100 {
+		l := c.StreamLifetime()
+		require.GreaterOrEqual(t, l, 50*time.Minute)
+		require.LessOrEqual(t, l, time.Hour)
+	}
+	require.Equal(t, 3*time.Second, c.StreamRetry())
+
+	heartbeat, expired, stop = c.StreamTimers()
+	defer stop()
+	require.NotNil(t, expired)
+	select {
+	case <-heartbeat:
+	case <-time.After(time.Second):
+		t.Fatal("no heartbeat")
+	}
+}
+
+// TestDrain covers the drain window ending before the deadline of
+// the shutdown, and the jittered retry hints.
+func TestDrain(t *testing.T) {
+	t.Parallel()
+
+	c := httpserve.NewCore(datapages.ServerConfig{}, "")
+	require.Zero(t, c.DrainRetry(), "without a drain the retry is the client's")
+
+	c = httpserve.NewCore(datapages.ServerConfig{
+		Streams: datapages.StreamsConfig{
+			DrainWindow: time.Hour,
+			Retry:       2 * time.Second,
+		},
+	}, "")
+	c.Build()
+	require.False(t, c.Draining())
+	for range 100 {
+		r := c.DrainRetry()
+		require.GreaterOrEqual(t, r, 2*time.Second)
+		require.LessOrEqual(t, r, 4*time.Second)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, c.Shutdown(ctx))
+	require.True(t, c.Draining())
+	select {
+	case <-c.DrainTimer():
+	case <-time.After(time.Second):
+		t.Fatal("the drain window outlasted the deadline of the shutdown")
+	}
+}
+
 // TestShutdownEndsListenAndServe covers that the context ends the server
 // and that ShutdownCh reports it.
 func TestShutdownEndsListenAndServe(t *testing.T) {
@@ -151,3 +293,305 @@ func TestShutdownEndsListenAndServe(t *testing.T) {
 	}
 	<-c.ShutdownCh()
 }
+
+// TestShutdownOutlastsListenAndServe covers a Shutdown with a deadline
+// further than the 10 seconds ListenAndServe shuts down in on its own,
+// which the requests still running keep their context for.
+func TestShutdownOutlastsListenAndServe(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits out the shutdown of ListenAndServe")
+	}
+	t.Parallel()
+
+	c := httpserve.NewCore(datapages.ServerConfig{
+		Streams: datapages.StreamsConfig{DrainWindow: 15 * time.Second},
+	}, "")
+	started, release := make(chan struct{}), make(chan struct{})
+	canceled := make(chan struct{})
+	c.Mux().HandleFunc("/", func(_ http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			close(canceled)
+		}
+	})
+	c.Build()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	served := make(chan error, 1)
+	go func() { served <- c.ListenAndServe(context.Background(), addr) }()
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	go func() {
+		resp, err := http.Get("http://" + addr + "/")
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- c.Shutdown(ctx) }()
+
+	select {
+	case <-canceled:
+		t.Fatal("the request was canceled before the deadline of Shutdown")
+	case <-time.After(11 * time.Second):
+	}
+	close(release)
+	require.NoError(t, <-shutdown)
+	require.NoError(t, <-served)
+}
+
+func TestReadiness(t *testing.T) {
+	t.Parallel()
+
+	var cfg datapages.ServerConfig
+	require.NoError(t, datapages.WithPrometheus(datapages.PrometheusConfig{
+		Host: "127.0.0.1:0",
+	})(&cfg))
+	c := httpserve.NewCore(cfg, "")
+	c.Build()
+
+	probe := func(path string) int {
+		w := httptest.NewRecorder()
+		cfg.MetricsServer.Handler.ServeHTTP(w,
+			httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+	require.Equal(t, http.StatusOK, probe("/readyz"))
+
+	var down atomic.Bool
+	c.AddReadinessCheck("broker", func(context.Context) error {
+		if down.Load() {
+			return errors.New("unreachable")
+		}
+		return nil
+	})
+	require.Equal(t, http.StatusOK, probe("/readyz"))
+	down.Store(true)
+	require.Equal(t, http.StatusServiceUnavailable, probe("/readyz"))
+	down.Store(false)
+
+	// Readiness fails from the start of the shutdown, liveness doesn't.
+	require.NoError(t, c.Shutdown(context.Background()))
+	require.Equal(t, http.StatusServiceUnavailable, probe("/readyz"))
+	require.Equal(t, http.StatusOK, probe("/healthz"))
+}
+
+// TestHealthServer covers the probes served without a metrics server,
+// which the checks of the core reach all the same.
+func TestHealthServer(t *testing.T) {
+	t.Parallel()
+
+	var cfg datapages.ServerConfig
+	require.NoError(t, datapages.WithHealth(datapages.HealthConfig{
+		Host: "127.0.0.1:0",
+	})(&cfg))
+	require.Nil(t, cfg.MetricsServer)
+	c := httpserve.NewCore(cfg, "")
+	c.Build()
+	require.False(t, c.MetricsEnabled())
+
+	probe := func(path string) int {
+		w := httptest.NewRecorder()
+		cfg.HealthServer.Handler.ServeHTTP(w,
+			httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+	require.Equal(t, http.StatusOK, probe("/readyz"))
+	c.AddReadinessCheck("broker", func(context.Context) error {
+		return errors.New("unreachable")
+	})
+	require.Equal(t, http.StatusServiceUnavailable, probe("/readyz"))
+	require.Equal(t, http.StatusOK, probe("/healthz"))
+}
+
+// TestHealthAndPrometheus covers both options at once:
+// the probes of either server check the same.
+func TestHealthAndPrometheus(t *testing.T) {
+	t.Parallel()
+
+	var cfg datapages.ServerConfig
+	fail := func(context.Context) error { return errors.New("down") }
+	require.NoError(t, datapages.WithHealth(datapages.HealthConfig{
+		Host:            "127.0.0.1:0",
+		ReadinessChecks: []datapages.ReadinessCheck{{Name: "db", Check: fail}},
+	})(&cfg))
+	require.NoError(t, datapages.WithPrometheus(datapages.PrometheusConfig{
+		Host:     "127.0.0.1:0",
+		Gatherer: prometheus.NewRegistry(),
+	})(&cfg))
+	httpserve.NewCore(cfg, "").Build()
+
+	for _, srv := range []*http.Server{cfg.HealthServer, cfg.MetricsServer} {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		require.Contains(t, w.Body.String(), "db")
+	}
+}
+
+// TestReadinessDelay covers the order of the shutdown: readiness fails first,
+// the server goes on as before for the delay, then the drain begins.
+func TestReadinessDelay(t *testing.T) {
+	t.Parallel()
+
+	var cfg datapages.ServerConfig
+	require.NoError(t, datapages.WithPrometheus(datapages.PrometheusConfig{
+		Host:           "127.0.0.1:0",
+		ReadinessDelay: 200 * time.Millisecond,
+	})(&cfg))
+	c := httpserve.NewCore(cfg, "")
+	c.Mux().Handle("/", echoPath())
+	c.Build()
+
+	probe := func(path string) int {
+		w := httptest.NewRecorder()
+		cfg.MetricsServer.Handler.ServeHTTP(w,
+			httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- c.Shutdown(context.Background()) }()
+
+	require.Eventually(t, func() bool {
+		return probe("/readyz") == http.StatusServiceUnavailable
+	}, time.Second, time.Millisecond)
+	select {
+	case <-c.ShutdownCh():
+		t.Fatal("the drain began before the readiness delay was over")
+	default:
+	}
+	require.False(t, c.Draining())
+	require.Equal(t, "/a/", serve(t, c, "/a").Body.String(),
+		"the server stopped serving within the readiness delay")
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return")
+	}
+	require.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	<-c.ShutdownCh()
+
+	// The deadline of the shutdown bounds the delay.
+	require.NoError(t, datapages.WithPrometheus(datapages.PrometheusConfig{
+		Host:           "127.0.0.1:0",
+		ReadinessDelay: time.Hour,
+	})(&cfg))
+	c = httpserve.NewCore(cfg, "")
+	c.Build()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.NoError(t, c.Shutdown(ctx))
+}
+
+// pingStore is an in-memory tab state store that fails its pings when down.
+type pingStore struct {
+	*inmem.Store
+	down atomic.Bool
+}
+
+func (s *pingStore) Ping(context.Context) error {
+	if s.down.Load() {
+		return errors.New("unreachable")
+	}
+	return nil
+}
+
+func TestTabState(t *testing.T) {
+	t.Parallel()
+
+	c := httpserve.NewCore(datapages.ServerConfig{}, "")
+	tab := c.Tab("t1", "alice")
+	require.Equal(t, "t1", tab.ID)
+	require.Equal(t, "alice", tab.User)
+	require.Equal(t, tabs.DefaultTTL, tab.TTL)
+	require.NotNil(t, tab.Store)
+
+	store := &pingStore{Store: inmem.New()}
+	var cfg datapages.ServerConfig
+	require.NoError(t, datapages.WithPrometheus(datapages.PrometheusConfig{
+		Host: "127.0.0.1:0",
+	})(&cfg))
+	require.NoError(t, datapages.WithTabState(datapages.TabStateConfig{
+		TTL: time.Minute, Store: store,
+	})(&cfg))
+	c = httpserve.NewCore(cfg, "")
+	c.Build()
+	require.Equal(t, time.Minute, c.Tab("t1", "").TTL)
+	require.Same(t, store, c.Tab("t1", "").Store)
+
+	probe := func() int {
+		w := httptest.NewRecorder()
+		cfg.MetricsServer.Handler.ServeHTTP(w,
+			httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w.Code
+	}
+	require.Equal(t, http.StatusOK, probe())
+	store.down.Store(true)
+	require.Equal(t, http.StatusServiceUnavailable, probe(),
+		"a store that pings is a readiness check")
+}
+
+func TestAccessLog(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	var inHandler string
+	c := httpserve.NewCore(datapages.ServerConfig{
+		Logger: slog.New(slog.NewJSONHandler(&buf, nil)),
+		AccessLog: &datapages.AccessLogConfig{
+			Skip: func(r *http.Request) bool { return r.URL.Path == "/skip/" },
+		},
+	}, "")
+	c.Mux().HandleFunc("GET /items/{id}/", func(w http.ResponseWriter, r *http.Request) {
+		reqlog.SetHandler(r, "PageItem.GET")
+		inHandler = reqlog.ID(r.Context())
+		w.WriteHeader(http.StatusTeapot)
+	})
+	c.Mux().HandleFunc("GET /skip/", func(http.ResponseWriter, *http.Request) {})
+	c.Build()
+
+	// A valid incoming ID is kept.
+	req := httptest.NewRequest(http.MethodGet, "/items/1/", nil)
+	req.Header.Set("X-Request-ID", "edge-42")
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, req)
+	require.Equal(t, "edge-42", w.Header().Get("X-Request-ID"))
+	require.Equal(t, "edge-42", inHandler)
+
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	require.Equal(t, "request", line["msg"])
+	require.Equal(t, "edge-42", line["request_id"])
+	require.Equal(t, "PageItem.GET", line["handler"])
+	require.Equal(t, "GET /items/{id}/", line["route"])
+	require.Equal(t, float64(http.StatusTeapot), line["status"])
+
+	// An invalid one is replaced.
+	req = httptest.NewRequest(http.MethodGet, "/skip/", nil)
+	req.Header.Set("X-Request-ID", "two words")
+	buf.Reset()
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, req)
+	require.True(t, reqlog.ValidID(w.Header().Get("X-Request-ID")))
+	require.NotEqual(t, "two words", w.Header().Get("X-Request-ID"))
+	require.Zero(t, buf.Len(), "a skipped request was logged")
+}
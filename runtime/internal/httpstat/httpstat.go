@@ -0,0 +1,66 @@
+// Package httpstat holds what the middleware measuring and logging
+// requests needs to know of a request once it was served:
+// the status code it was answered with and the route it matched.
+package httpstat
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/romshark/datapages/runtime/reqlog"
+)
+
+// Writer records the status code the handler wrote.
+type Writer struct {
+	http.ResponseWriter
+	Status int
+}
+
+// NewWriter returns w recording the status code,
+// [http.StatusOK] until the handler writes one.
+func NewWriter(w http.ResponseWriter) *Writer {
+	return &Writer{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (w *Writer) WriteHeader(code int) {
+	w.Status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *Writer) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("http.Hijacker not supported")
+}
+
+func (w *Writer) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Route is the route r matched, or its path when it matched none.
+// The mux sets the route on the request it routes, which is a copy of r
+// when a middleware in between made one. The route is then taken from
+// the log entry of r.
+func Route(r *http.Request) string {
+	if p := r.Pattern; p != "" {
+		return p
+	}
+	if e := reqlog.FromContext(r.Context()); e != nil {
+		if p := e.Route(); p != "" {
+			return p
+		}
+	}
+	return r.URL.Path
+}
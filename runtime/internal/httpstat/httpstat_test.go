@@ -0,0 +1,39 @@
+package httpstat_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages/runtime/internal/httpstat"
+)
+
+func TestWriter(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	w := httpstat.NewWriter(rec)
+	require.Equal(t, http.StatusOK, w.Status, "the status of a handler writing none")
+
+	w.WriteHeader(http.StatusTeapot)
+	w.Flush()
+	require.Equal(t, http.StatusTeapot, w.Status)
+	require.Equal(t, http.StatusTeapot, rec.Code)
+	require.True(t, rec.Flushed, "the flush didn't reach the underlying writer")
+
+	_, _, err := w.Hijack()
+	require.Error(t, err, "the recorder can't be hijacked")
+	require.ErrorIs(t, w.Push("/x", nil), http.ErrNotSupported)
+}
+
+func TestRoute(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/post/1", nil)
+	require.Equal(t, "/post/1", httpstat.Route(r), "a request matching no route")
+
+	r.Pattern = "GET /post/{id}"
+	require.Equal(t, "GET /post/{id}", httpstat.Route(r))
+}
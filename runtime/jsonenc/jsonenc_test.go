@@ -0,0 +1,62 @@
+package jsonenc_test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages/runtime/jsonenc"
+)
+
+func marshal(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	require.NoError(t, err)
+	return string(b)
+}
+
+// requireRoundTrip requires the JSON of s to decode back into what
+// encoding/json makes of s, which replaces invalid UTF-8.
+func requireRoundTrip(t *testing.T, s string, encoded []byte) {
+	t.Helper()
+	var want, got string
+	require.NoError(t, json.Unmarshal([]byte(marshal(t, s)), &want))
+	require.NoError(t, json.Unmarshal(encoded, &got), "%s", encoded)
+	require.Equal(t, want, got, "%q", s)
+}
+
+func TestAppendString(t *testing.T) {
+	for _, s := range []string{
+		"", "plain", `a"b`, `a\b`, "a\nb\rc\td", "\b\f", "\x00\x1f\x7f",
+		"<script>&</script>", "ä€😀", "\u2028\u2029", "\xff", "a\xc3", "\xed\xa0\x80",
+	} {
+		requireRoundTrip(t, s, jsonenc.AppendString(nil, s))
+	}
+}
+
+func TestAppendNumbers(t *testing.T) {
+	for _, v := range []int64{0, 1, -1, math.MinInt64, math.MaxInt64} {
+		require.Equal(t, marshal(t, v), string(jsonenc.AppendInt(nil, v)))
+	}
+	for _, v := range []uint64{0, 1, math.MaxUint64} {
+		require.Equal(t, marshal(t, v), string(jsonenc.AppendUint(nil, v)))
+	}
+	for _, v := range []bool{true, false} {
+		require.Equal(t, marshal(t, v), string(jsonenc.AppendBool(nil, v)))
+	}
+}
+
+func FuzzAppendString(f *testing.F) {
+	for _, seed := range []string{"shoes", `a"b`, "<a>", "\xff", "\u2029", "\x00"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		b := jsonenc.AppendString(nil, s)
+		requireRoundTrip(t, s, b)
+		for _, c := range []byte("<>&") {
+			require.NotContains(t, string(b), string(c), "not escaped for HTML")
+		}
+	})
+}
@@ -0,0 +1,238 @@
+// Package otelmetrics holds the metrics of a generated server built with
+// datapages.EnableOTelMetrics, recorded through the OpenTelemetry API.
+//
+// The instruments mirror those of package prom, but rather than being
+// global they belong to the [Metrics] of one server, created from the meter
+// provider that server was given.
+//
+// Application code must not import this package.
+package otelmetrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+
+	"github.com/romshark/datapages/runtime/internal/httpstat"
+)
+
+// MeterName is the instrumentation scope of the instruments
+// of a generated server.
+const MeterName = "github.com/romshark/datapages"
+
+// Metrics is the instruments of one server.
+type Metrics struct {
+	httpRequests          metric.Int64Counter
+	httpRequestDuration   metric.Float64Histogram
+	httpInFlight          metric.Int64UpDownCounter
+	errorsRecovered       metric.Int64Counter
+	errorsNotRecovered    metric.Int64Counter
+	sseStreams            metric.Int64UpDownCounter
+	sseConnectionDuration metric.Float64Histogram
+	sseDisconnects        metric.Int64Counter
+	handlerDuration       metric.Float64Histogram
+	eventHandlerDuration  metric.Float64Histogram
+	eventHandlerErrors    metric.Int64Counter
+	dispatchDuration      metric.Float64Histogram
+	brokerPublishes       metric.Int64Counter
+	brokerDropped         metric.Int64Counter
+	sessionCreations      metric.Int64Counter
+	sessionClosures       metric.Int64Counter
+	sessionReads          metric.Int64Counter
+}
+
+// New creates the instruments of a server on the meter of mp.
+func New(mp metric.MeterProvider) (*Metrics, error) {
+	if mp == nil {
+		return nil, errors.New("nil meter provider")
+	}
+	m := mp.Meter(MeterName)
+	var x Metrics
+	var errs []error
+	counter := func(name, desc string) metric.Int64Counter {
+		c, err := m.Int64Counter(name, metric.WithDescription(desc))
+		errs = append(errs, err)
+		return c
+	}
+	upDown := func(name, desc string) metric.Int64UpDownCounter {
+		c, err := m.Int64UpDownCounter(name, metric.WithDescription(desc))
+		errs = append(errs, err)
+		return c
+	}
+	seconds := func(name, desc string) metric.Float64Histogram {
+		h, err := m.Float64Histogram(name,
+			metric.WithDescription(desc), metric.WithUnit("s"))
+		errs = append(errs, err)
+		return h
+	}
+
+	x.httpRequests = counter("datapages.http.requests",
+		"HTTP requests by method, route and status")
+	x.httpRequestDuration = seconds("datapages.http.request.duration",
+		"HTTP request latency by method and route")
+	x.httpInFlight = upDown("datapages.http.in_flight_requests",
+		"Current in-flight HTTP requests")
+	x.errorsRecovered = counter("datapages.internal_errors.recovered",
+		"Internal errors recovered without HTTP failure")
+	x.errorsNotRecovered = counter("datapages.internal_errors.not_recovered",
+		"Internal errors that could not be recovered and "+
+			"resulted in an HTTP error response")
+	x.sseStreams = upDown("datapages.sse.streams",
+		"Active SSE streams by page")
+	x.sseConnectionDuration = seconds("datapages.sse.connection.duration",
+		"SSE connection lifetime")
+	x.sseDisconnects = counter("datapages.sse.disconnects",
+		"SSE disconnects by reason")
+	x.handlerDuration = seconds("datapages.handler.duration",
+		"Duration of page, action and stream hook handlers by page and handler")
+	x.eventHandlerDuration = seconds("datapages.event_handler.duration",
+		"Duration of event handlers by page, handler and event kind")
+	x.eventHandlerErrors = counter("datapages.event_handler.errors",
+		"Event handler errors by page, handler and event kind")
+	x.dispatchDuration = seconds("datapages.dispatch.duration",
+		"Duration of event dispatches by event kind")
+	x.brokerPublishes = counter("datapages.event_broker.publishes",
+		"Published events by kind")
+	x.brokerDropped = counter("datapages.event_broker.deliveries_dropped",
+		"Dropped broker deliveries due to slow consumers")
+	x.sessionCreations = counter("datapages.session.creations",
+		"Session creations by result")
+	x.sessionClosures = counter("datapages.session.closures",
+		"Session closures by result")
+	x.sessionReads = counter("datapages.session.reads",
+		"Session reads from cookie by result")
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return &x, nil
+}
+
+// bg is what the methods record with. They are called where a server built
+// with datapages.EnablePrometheus calls the functions of package prom,
+// which take no context.
+var bg = context.Background()
+
+func attrs(kv ...attribute.KeyValue) metric.MeasurementOption {
+	return metric.WithAttributes(kv...)
+}
+
+// SSEConnectionOpened counts a stream of page the server just accepted.
+func (m *Metrics) SSEConnectionOpened(page string) {
+	m.sseStreams.Add(bg, 1, attrs(attribute.String("page", page)))
+}
+
+// SSEConnectionClosed counts down the stream of page the server just let go.
+func (m *Metrics) SSEConnectionClosed(page string) {
+	m.sseStreams.Add(bg, -1, attrs(attribute.String("page", page)))
+}
+
+// SSEDisconnect counts why a stream ended.
+// reason is "close", "client", "shutdown", "broker" or "ttl".
+func (m *Metrics) SSEDisconnect(reason string) {
+	m.sseDisconnects.Add(bg, 1, attrs(attribute.String("reason", reason)))
+}
+
+// SSEConnectionDuration records how long a stream was open.
+func (m *Metrics) SSEConnectionDuration(since time.Time) {
+	m.sseConnectionDuration.Record(bg, time.Since(since).Seconds())
+}
+
+// HandlerDuration records how long the handler of page ran,
+// "PageIndex" and "GET" for example. Deferred at the start of the handler,
+// since is when it started.
+func (m *Metrics) HandlerDuration(page, handler string, since time.Time) {
+	m.handlerDuration.Record(bg, time.Since(since).Seconds(), attrs(
+		attribute.String("page", page),
+		attribute.String("handler", handler),
+	))
+}
+
+// EventHandlerDuration records how long the event handler of page
+// handling an event of kind event ran.
+func (m *Metrics) EventHandlerDuration(
+	page, handler, event string, since time.Time,
+) {
+	m.eventHandlerDuration.Record(bg, time.Since(since).Seconds(), attrs(
+		attribute.String("page", page),
+		attribute.String("handler", handler),
+		attribute.String("event", event),
+	))
+}
+
+// EventHandlerError counts an error the event handler of page returned
+// handling an event of kind event.
+func (m *Metrics) EventHandlerError(page, handler, event string) {
+	m.eventHandlerErrors.Add(bg, 1, attrs(
+		attribute.String("page", page),
+		attribute.String("handler", handler),
+		attribute.String("event", event),
+	))
+}
+
+// DispatchDuration records how long dispatching an event of kind event took,
+// encoding and publishing it. Deferred at the start of the dispatch.
+func (m *Metrics) DispatchDuration(event string, since time.Time) {
+	m.dispatchDuration.Record(bg, time.Since(since).Seconds(),
+		attrs(attribute.String("event", event)))
+}
+
+// SessionRead counts a session lookup. outcome is "none", "error", "stale",
+// "expired" or "valid". It implements auth.Metrics.
+func (m *Metrics) SessionRead(outcome string) {
+	m.sessionReads.Add(bg, 1, attrs(attribute.String("result", outcome)))
+}
+
+// SessionCreated counts a session issued. outcome is "success" or "error".
+// It implements auth.Metrics.
+func (m *Metrics) SessionCreated(outcome string) {
+	m.sessionCreations.Add(bg, 1, attrs(attribute.String("result", outcome)))
+}
+
+// SessionClosed counts a session removed. outcome is "success" or "error".
+// It implements auth.Metrics.
+func (m *Metrics) SessionClosed(outcome string) {
+	m.sessionClosures.Add(bg, 1, attrs(attribute.String("result", outcome)))
+}
+
+// InternalErrorRecovered counts an error the application answered itself.
+func (m *Metrics) InternalErrorRecovered() { m.errorsRecovered.Add(bg, 1) }
+
+// InternalErrorNotRecovered counts an error the generated server answered.
+func (m *Metrics) InternalErrorNotRecovered() { m.errorsNotRecovered.Add(bg, 1) }
+
+// BrokerPublish counts an event published, by the kind of its subject.
+func (m *Metrics) BrokerPublish(subjectKind string) {
+	m.brokerPublishes.Add(bg, 1, attrs(attribute.String("kind", subjectKind)))
+}
+
+// BrokerDeliveryDropped counts an event a subscriber never received.
+func (m *Metrics) BrokerDeliveryDropped() { m.brokerDropped.Add(bg, 1) }
+
+// Middleware measures every request. It must be the outermost middleware
+// of the chain, otherwise it misses the work of the ones before it.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx := r.Context()
+
+		m.httpInFlight.Add(ctx, 1)
+		defer m.httpInFlight.Add(ctx, -1)
+
+		rw := httpstat.NewWriter(w)
+		next.ServeHTTP(rw, r)
+
+		method := semconv.HTTPRequestMethodKey.String(r.Method)
+		route := semconv.HTTPRouteKey.String(httpstat.Route(r))
+		m.httpRequests.Add(ctx, 1, attrs(
+			method, route, semconv.HTTPResponseStatusCodeKey.Int(rw.Status),
+		))
+		m.httpRequestDuration.Record(ctx, time.Since(start).Seconds(),
+			attrs(method, route))
+	})
+}
@@ -0,0 +1,167 @@
+package otelmetrics_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/romshark/datapages/runtime/auth"
+	"github.com/romshark/datapages/runtime/otelmetrics"
+)
+
+var _ auth.Metrics = (*otelmetrics.Metrics)(nil)
+
+func newMetrics(t *testing.T) (*otelmetrics.Metrics, *sdkmetric.ManualReader) {
+	t.Helper()
+	r := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(r))
+	t.Cleanup(func() { require.NoError(t, mp.Shutdown(context.Background())) })
+	m, err := otelmetrics.New(mp)
+	require.NoError(t, err)
+	return m, r
+}
+
+// find returns the data point of the instrument name with attrs,
+// summing a counter and counting the samples of a histogram.
+func find(
+	t *testing.T, r *sdkmetric.ManualReader, name string, attrs ...attribute.KeyValue,
+) (float64, bool) {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, r.Collect(context.Background(), &rm))
+	want := attribute.NewSet(attrs...)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			switch d := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, p := range d.DataPoints {
+					if p.Attributes.Equals(&want) {
+						return float64(p.Value), true
+					}
+				}
+			case metricdata.Histogram[float64]:
+				for _, p := range d.DataPoints {
+					if p.Attributes.Equals(&want) {
+						return float64(p.Count), true
+					}
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+func TestNilMeterProvider(t *testing.T) {
+	t.Parallel()
+	_, err := otelmetrics.New(nil)
+	require.Error(t, err)
+}
+
+func TestInstruments(t *testing.T) {
+	t.Parallel()
+	m, r := newMetrics(t)
+
+	m.SSEConnectionOpened("PageIndex")
+	m.SSEConnectionOpened("PageIndex")
+	m.SSEConnectionClosed("PageIndex")
+	m.SSEDisconnect("client")
+	m.HandlerDuration("PagePost", "POSTSave", time.Now())
+	m.EventHandlerDuration("PageIndex", "OnNote", "EventNote", time.Now())
+	m.EventHandlerError("PageIndex", "OnNote", "EventNote")
+	m.DispatchDuration("EventNote", time.Now())
+	m.BrokerPublish("note")
+	m.SessionRead("valid")
+
+	page := attribute.String("page", "PageIndex")
+	for _, tt := range []struct {
+		name  string
+		attrs []attribute.KeyValue
+		want  float64
+	}{
+		{"datapages.sse.streams", []attribute.KeyValue{page}, 1},
+		{"datapages.sse.disconnects", []attribute.KeyValue{
+			attribute.String("reason", "client"),
+		}, 1},
+		{"datapages.handler.duration", []attribute.KeyValue{
+			attribute.String("page", "PagePost"),
+			attribute.String("handler", "POSTSave"),
+		}, 1},
+		{"datapages.event_handler.duration", []attribute.KeyValue{
+			page,
+			attribute.String("handler", "OnNote"),
+			attribute.String("event", "EventNote"),
+		}, 1},
+		{"datapages.event_handler.errors", []attribute.KeyValue{
+			page,
+			attribute.String("handler", "OnNote"),
+			attribute.String("event", "EventNote"),
+		}, 1},
+		{"datapages.dispatch.duration", []attribute.KeyValue{
+			attribute.String("event", "EventNote"),
+		}, 1},
+		{"datapages.event_broker.publishes", []attribute.KeyValue{
+			attribute.String("kind", "note"),
+		}, 1},
+		{"datapages.session.reads", []attribute.KeyValue{
+			attribute.String("result", "valid"),
+		}, 1},
+	} {
+		v, ok := find(t, r, tt.name, tt.attrs...)
+		require.True(t, ok, tt.name)
+		require.Equal(t, tt.want, v, tt.name)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+	m, r := newMetrics(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /post/{id}", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	h := m.Middleware(mux)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/post/1", nil))
+
+	method := attribute.String("http.request.method", "GET")
+	route := attribute.String("http.route", "GET /post/{id}")
+	v, ok := find(t, r, "datapages.http.requests",
+		method, route, attribute.Int("http.response.status_code", http.StatusTeapot))
+	require.True(t, ok)
+	require.Equal(t, 1.0, v)
+	v, ok = find(t, r, "datapages.http.request.duration", method, route)
+	require.True(t, ok)
+	require.Equal(t, 1.0, v)
+	v, ok = find(t, r, "datapages.http.in_flight_requests")
+	require.True(t, ok)
+	require.Zero(t, v)
+}
+
+// TestServersDontCollide covers two servers sharing a meter provider,
+// which package prom, registering its collectors once per process, can't do.
+func TestServersDontCollide(t *testing.T) {
+	t.Parallel()
+	r := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(r))
+	a, err := otelmetrics.New(mp)
+	require.NoError(t, err)
+	b, err := otelmetrics.New(mp)
+	require.NoError(t, err)
+
+	a.BrokerPublish("note")
+	b.BrokerPublish("note")
+	v, ok := find(t, r, "datapages.event_broker.publishes",
+		attribute.String("kind", "note"))
+	require.True(t, ok)
+	require.Equal(t, 2.0, v)
+}
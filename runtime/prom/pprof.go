@@ -0,0 +1,27 @@
+package prom
+
+import (
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+)
+
+// PprofPrefix is the path the profiles are served under.
+const PprofPrefix = "/debug/pprof/"
+
+// RegisterPprof serves the handlers of net/http/pprof on mux under
+// [PprofPrefix]. The profiles of runtime/pprof get a route of their own,
+// the index serves the ones registered later.
+//
+// Importing net/http/pprof registers the same handlers on
+// http.DefaultServeMux, an application serving that mux exposes them there.
+func RegisterPprof(mux *http.ServeMux) {
+	mux.HandleFunc(PprofPrefix, pprof.Index)
+	mux.HandleFunc(PprofPrefix+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(PprofPrefix+"profile", pprof.Profile)
+	mux.HandleFunc(PprofPrefix+"symbol", pprof.Symbol)
+	mux.HandleFunc(PprofPrefix+"trace", pprof.Trace)
+	for _, p := range runtimepprof.Profiles() {
+		mux.Handle(PprofPrefix+p.Name(), pprof.Handler(p.Name()))
+	}
+}
@@ -5,15 +5,14 @@
 package prom
 
 import (
-	"bufio"
-	"errors"
-	"net"
 	"net/http"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/romshark/datapages/runtime/internal/httpstat"
 )
 
 var (
@@ -110,7 +109,59 @@ var (
 			Name:      "disconnects_total",
 			Help:      "SSE disconnects by reason",
 		},
-		[]string{"reason"}, // "ttl" | "client" | "shutdown"
+		[]string{"reason"}, // "close" | "client" | "shutdown" | "broker"
+	)
+
+	// Per-handler metrics, labeled by the page or App type and the method
+	// as the application declares them: page="PagePost", handler="POSTSave".
+	mHandlerDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "datapages",
+			Subsystem: "handler",
+			Name:      "duration_seconds",
+			Help: "Duration of page, action and stream hook handlers " +
+				"by page and handler",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"page", "handler"},
+	)
+	mEventHandlerDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "datapages",
+			Subsystem: "event_handler",
+			Name:      "duration_seconds",
+			Help:      "Duration of event handlers by page, handler and event kind",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"page", "handler", "event"},
+	)
+	mEventHandlerErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "datapages",
+			Subsystem: "event_handler",
+			Name:      "errors_total",
+			Help:      "Event handler errors by page, handler and event kind",
+		},
+		[]string{"page", "handler", "event"},
+	)
+	mDispatchDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "datapages",
+			Subsystem: "dispatch",
+			Name:      "duration_seconds",
+			Help:      "Duration of event dispatches by event kind",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"event"},
+	)
+	mSSEStreams = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "datapages",
+			Subsystem: "sse",
+			Name:      "streams",
+			Help:      "Active SSE streams by page",
+		},
+		[]string{"page"},
 	)
 
 	mSessionCreations = prometheus.NewCounterVec(
@@ -156,6 +207,11 @@ func Register(r prometheus.Registerer) {
 			mSSEConnections,
 			mSSEConnectionDuration,
 			mSSEDisconnects,
+			mSSEStreams,
+			mHandlerDuration,
+			mEventHandlerDuration,
+			mEventHandlerErrors,
+			mDispatchDuration,
 			mBrokerEventPublishes,
 			mBrokerDeliveriesDropped,
 			mSessionCreations,
@@ -165,14 +221,20 @@ func Register(r prometheus.Registerer) {
 	})
 }
 
-// SSEConnectionOpened counts a stream the server just accepted.
-func SSEConnectionOpened() { mSSEConnections.Inc() }
+// SSEConnectionOpened counts a stream of page the server just accepted.
+func SSEConnectionOpened(page string) {
+	mSSEConnections.Inc()
+	mSSEStreams.WithLabelValues(page).Inc()
+}
 
-// SSEConnectionClosed counts down the stream the server just let go.
-func SSEConnectionClosed() { mSSEConnections.Dec() }
+// SSEConnectionClosed counts down the stream of page the server just let go.
+func SSEConnectionClosed(page string) {
+	mSSEConnections.Dec()
+	mSSEStreams.WithLabelValues(page).Dec()
+}
 
 // SSEDisconnect counts why a stream ended.
-// reason is "close", "client" or "shutdown".
+// reason is "close", "client", "shutdown", "broker" or "ttl".
 func SSEDisconnect(reason string) {
 	mSSEDisconnects.WithLabelValues(reason).Inc()
 }
@@ -182,6 +244,33 @@ func SSEConnectionDuration(since time.Time) {
 	mSSEConnectionDuration.Observe(time.Since(since).Seconds())
 }
 
+// HandlerDuration records how long the handler of page ran,
+// "PageIndex" and "GET" for example. Deferred at the start of the handler,
+// since is when it started.
+func HandlerDuration(page, handler string, since time.Time) {
+	mHandlerDuration.WithLabelValues(page, handler).
+		Observe(time.Since(since).Seconds())
+}
+
+// EventHandlerDuration records how long the event handler of page
+// handling an event of kind event ran.
+func EventHandlerDuration(page, handler, event string, since time.Time) {
+	mEventHandlerDuration.WithLabelValues(page, handler, event).
+		Observe(time.Since(since).Seconds())
+}
+
+// EventHandlerError counts an error the event handler of page returned
+// handling an event of kind event.
+func EventHandlerError(page, handler, event string) {
+	mEventHandlerErrors.WithLabelValues(page, handler, event).Inc()
+}
+
+// DispatchDuration records how long dispatching an event of kind event took,
+// encoding and publishing it. Deferred at the start of the dispatch.
+func DispatchDuration(event string, since time.Time) {
+	mDispatchDuration.WithLabelValues(event).Observe(time.Since(since).Seconds())
+}
+
 // SessionRead counts a session lookup. outcome is "none", "error", "stale",
 // "expired" or "valid".
 func SessionRead(outcome string) {
@@ -220,45 +309,6 @@ func BrokerPublish(subjectKind string) {
 // BrokerDeliveryDropped counts an event a subscriber never received.
 func BrokerDeliveryDropped() { mBrokerDeliveriesDropped.Inc() }
 
-// statusRW records the status code the handler wrote.
-type statusRW struct {
-	http.ResponseWriter
-	status int
-}
-
-func (w *statusRW) WriteHeader(code int) {
-	w.status = code
-	w.ResponseWriter.WriteHeader(code)
-}
-
-func (w *statusRW) Flush() {
-	if f, ok := w.ResponseWriter.(http.Flusher); ok {
-		f.Flush()
-	}
-}
-
-func (w *statusRW) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
-		return h.Hijack()
-	}
-	return nil, nil, errors.New("http.Hijacker not supported")
-}
-
-func (w *statusRW) Push(target string, opts *http.PushOptions) error {
-	if p, ok := w.ResponseWriter.(http.Pusher); ok {
-		return p.Push(target, opts)
-	}
-	return http.ErrNotSupported
-}
-
-// routeLabel is the route a request matched, or its path when it matched none.
-func routeLabel(r *http.Request) string {
-	if p := r.Pattern; p != "" {
-		return p
-	}
-	return r.URL.Path
-}
-
 // Middleware measures every request. It must be the outermost middleware
 // of the chain, otherwise it misses the work of the ones before it.
 func Middleware(next http.Handler) http.Handler {
@@ -268,12 +318,12 @@ func Middleware(next http.Handler) http.Handler {
 		mInFlightRequests.Inc()
 		defer mInFlightRequests.Dec()
 
-		rw := &statusRW{ResponseWriter: w, status: http.StatusOK}
+		rw := httpstat.NewWriter(w)
 		next.ServeHTTP(rw, r)
 
-		path := routeLabel(r)
+		path := httpstat.Route(r)
 		mHTTPRequestsTotal.
-			WithLabelValues(r.Method, path, strconv.Itoa(rw.status)).Inc()
+			WithLabelValues(r.Method, path, strconv.Itoa(rw.Status)).Inc()
 		mHTTPRequestDuration.
 			WithLabelValues(r.Method, path).Observe(time.Since(start).Seconds())
 	})
@@ -41,6 +41,40 @@ func gather(t *testing.T, name string) string {
 	return b.String()
 }
 
+// value returns the value of the metric name with labels, the number of
+// observations for a histogram.
+func value(t *testing.T, name string, labels map[string]string) float64 {
+	t.Helper()
+	metrics, err := registry.Gather()
+	require.NoError(t, err)
+	for _, f := range metrics {
+		if f.GetName() != name {
+			continue
+		}
+	METRICS:
+		for _, m := range f.GetMetric() {
+			if len(m.GetLabel()) != len(labels) {
+				continue
+			}
+			for _, l := range m.GetLabel() {
+				if labels[l.GetName()] != l.GetValue() {
+					continue METRICS
+				}
+			}
+			switch {
+			case m.Gauge != nil:
+				return m.GetGauge().GetValue()
+			case m.Counter != nil:
+				return m.GetCounter().GetValue()
+			default:
+				return float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	t.Fatalf("no metric %s%v", name, labels)
+	return 0
+}
+
 func TestMiddleware(t *testing.T) {
 	h := prom.Middleware(http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
@@ -56,10 +90,12 @@ func TestMiddleware(t *testing.T) {
 }
 
 func TestCounters(t *testing.T) {
-	prom.SSEConnectionOpened()
+	prom.SSEConnectionOpened("PageIndex")
+	prom.SSEConnectionOpened("PageIndex")
+	prom.SSEConnectionOpened("PageChat")
 	prom.SSEDisconnect("client")
 	prom.SSEConnectionDuration(time.Now())
-	prom.SSEConnectionClosed()
+	prom.SSEConnectionClosed("PageIndex")
 	prom.SessionRead("valid")
 	prom.SessionCreated("success")
 	prom.SessionClosed("error")
@@ -75,6 +111,28 @@ func TestCounters(t *testing.T) {
 	require.Contains(t, gather(t, "datapages_event_broker_publishes_by_kind_total"), "public")
 	require.NotEmpty(t, gather(t, "datapages_internal_errors_recovered_total"))
 	require.NotEmpty(t, gather(t, "datapages_sse_connection_duration_seconds"))
+
+	require.Equal(t, 1.0, value(t, "datapages_sse_streams",
+		map[string]string{"page": "PageIndex"}))
+	require.Equal(t, 1.0, value(t, "datapages_sse_streams",
+		map[string]string{"page": "PageChat"}))
+}
+
+func TestHandlerMetrics(t *testing.T) {
+	prom.HandlerDuration("PagePost", "POSTSave", time.Now())
+	prom.EventHandlerDuration("PagePost", "OnSaved", "EventSaved", time.Now())
+	prom.EventHandlerError("PagePost", "OnSaved", "EventSaved")
+	prom.DispatchDuration("EventSaved", time.Now())
+
+	handler := map[string]string{
+		"page": "PagePost", "handler": "OnSaved", "event": "EventSaved",
+	}
+	require.Equal(t, 1.0, value(t, "datapages_handler_duration_seconds",
+		map[string]string{"page": "PagePost", "handler": "POSTSave"}))
+	require.Equal(t, 1.0, value(t, "datapages_event_handler_duration_seconds", handler))
+	require.Equal(t, 1.0, value(t, "datapages_event_handler_errors_total", handler))
+	require.Equal(t, 1.0, value(t, "datapages_dispatch_duration_seconds",
+		map[string]string{"event": "EventSaved"}))
 }
 
 // TestAuthMetricsImplementsAuth covers that the counters satisfy what the
@@ -86,3 +144,35 @@ func TestAuthMetricsImplementsAuth(t *testing.T) {
 	m.SessionClosed("success")
 	require.Contains(t, gather(t, "datapages_session_reads_total"), "valid")
 }
+
+func TestPprof(t *testing.T) {
+	mux := http.NewServeMux()
+	prom.RegisterPprof(mux)
+	get := func(target string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	w := get(prom.PprofPrefix)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `heap?debug=1`)
+
+	w = get(prom.PprofPrefix + "goroutine?debug=1")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "goroutine profile:")
+
+	w = get(prom.PprofPrefix + "heap?gc=1")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/octet-stream", w.Header().Get("Content-Type"))
+	require.NotEmpty(t, w.Body.Bytes())
+
+	require.Equal(t, http.StatusNotFound, get(prom.PprofPrefix+"nonexistent").Code)
+	require.NotEmpty(t, get(prom.PprofPrefix+"cmdline").Body.String())
+	require.Equal(t, "num_symbols: 1\n", get(prom.PprofPrefix+"symbol").Body.String())
+
+	w = get(prom.PprofPrefix + "profile?seconds=1")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotEmpty(t, w.Body.Bytes())
+}
@@ -0,0 +1,221 @@
+// Package reqlog carries the request ID and the logger of a request through
+// its context, and the request ID across the message broker to the event
+// handlers the request's dispatches cause.
+//
+// Application code must not import this package, datapages.Logger
+// returns the logger of a handler.
+package reqlog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/romshark/datapages/modules/messaging"
+)
+
+// Header is the HTTP header the request ID is read from and answered in.
+const Header = "X-Request-ID"
+
+// MessageHeader is the message header the request ID travels in
+// from a dispatch to the event handlers receiving it.
+const MessageHeader = "request-id"
+
+// maxIDLen bounds the length of a request ID taken from a request.
+const maxIDLen = 128
+
+// NewID returns a random request ID of 32 hex digits.
+func NewID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// ValidID reports whether id may be taken over from a request:
+// it's no longer than 128 bytes of printable ASCII without spaces.
+// A client controls the header, anything else could forge log lines.
+func ValidID(id string) bool {
+	if id == "" || len(id) > maxIDLen {
+		return false
+	}
+	for i := range len(id) {
+		if c := id[i]; c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Entry is what the server knows of a request for its log lines.
+// The handler, the user and the stream are learned as the request
+// is served. It's safe for concurrent use.
+type Entry struct {
+	base *slog.Logger
+
+	lock     sync.Mutex
+	id       string
+	originID string // The request ID of the dispatch of an event.
+	handler  string
+	pattern  string
+	userID   string
+	streamID uint64
+	logger   *slog.Logger // Built on demand, nil when stale.
+}
+
+// New returns the entry of the request id logging to base.
+func New(base *slog.Logger, id string) *Entry {
+	return &Entry{base: base, id: id}
+}
+
+type ctxKey struct{}
+
+// NewContext returns ctx carrying e.
+func NewContext(ctx context.Context, e *Entry) context.Context {
+	return context.WithValue(ctx, ctxKey{}, e)
+}
+
+// FromContext returns the entry of ctx, nil when it carries none.
+func FromContext(ctx context.Context) *Entry {
+	e, _ := ctx.Value(ctxKey{}).(*Entry)
+	return e
+}
+
+// Logger returns the logger of the entry of ctx, nil when it carries none.
+func Logger(ctx context.Context) *slog.Logger {
+	if e := FromContext(ctx); e != nil {
+		return e.Logger()
+	}
+	return nil
+}
+
+// ID returns the request ID of ctx, empty when it carries none.
+func ID(ctx context.Context) string {
+	if e := FromContext(ctx); e != nil {
+		return e.id
+	}
+	return ""
+}
+
+// SetHandler records that the handler name, "PageIndex.GET" for example,
+// serves r, and the route r matched. No-op when r carries no entry.
+func SetHandler(r *http.Request, name string) {
+	if e := FromContext(r.Context()); e != nil {
+		e.lock.Lock()
+		defer e.lock.Unlock()
+		e.handler, e.pattern, e.logger = name, r.Pattern, nil
+	}
+}
+
+// SetUser records the ID of the user ctx is served for.
+// No-op when ctx carries no entry or userID is empty.
+func SetUser(ctx context.Context, userID string) {
+	if e := FromContext(ctx); e != nil && userID != "" {
+		e.lock.Lock()
+		defer e.lock.Unlock()
+		e.userID, e.logger = userID, nil
+	}
+}
+
+// SetStream records the ID of the stream ctx serves.
+// No-op when ctx carries no entry.
+func SetStream(ctx context.Context, streamID uint64) {
+	if e := FromContext(ctx); e != nil {
+		e.lock.Lock()
+		defer e.lock.Unlock()
+		e.streamID, e.logger = streamID, nil
+	}
+}
+
+// Event returns ctx carrying the entry of the event handler name, "PageIndex.OnPosted"
+// for example, handling msg on the stream of ctx. The entry keeps the request
+// ID, the user and the stream of the stream, and carries the request ID
+// msg was dispatched in as origin_request_id when its header has one.
+// It returns ctx as is when ctx carries no entry.
+func Event(ctx context.Context, name string, msg messaging.Message) context.Context {
+	stream := FromContext(ctx)
+	if stream == nil {
+		return ctx
+	}
+	stream.lock.Lock()
+	e := &Entry{
+		base:     stream.base,
+		id:       stream.id,
+		originID: msg.Header[MessageHeader],
+		handler:  name,
+		userID:   stream.userID,
+		streamID: stream.streamID,
+	}
+	stream.lock.Unlock()
+	return NewContext(ctx, e)
+}
+
+// MessageHeaderOf returns header with the request ID of ctx added,
+// creating it when nil. It returns header as is when ctx carries no entry.
+func MessageHeaderOf(ctx context.Context, header messaging.Header) messaging.Header {
+	id := ID(ctx)
+	if id == "" {
+		return header
+	}
+	if header == nil {
+		header = make(messaging.Header, 1)
+	}
+	header[MessageHeader] = id
+	return header
+}
+
+// Logger returns the logger of the request, which carries its ID and
+// what is known of it so far as attributes.
+func (e *Entry) Logger() *slog.Logger {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.logger == nil {
+		e.logger = e.base.With(e.attrsLocked()...)
+	}
+	return e.logger
+}
+
+// Route returns the route the handler of the request was registered with,
+// empty before a handler ran.
+func (e *Entry) Route() string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.pattern
+}
+
+// SetRoute records the route the request matched
+// unless its handler did already.
+func (e *Entry) SetRoute(pattern string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.pattern == "" {
+		e.pattern = pattern
+	}
+}
+
+// Attrs returns the attributes the logger of the request carries.
+func (e *Entry) Attrs() []any {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.attrsLocked()
+}
+
+func (e *Entry) attrsLocked() []any {
+	attrs := make([]any, 0, 5)
+	attrs = append(attrs, slog.String("request_id", e.id))
+	if e.originID != "" {
+		attrs = append(attrs, slog.String("origin_request_id", e.originID))
+	}
+	if e.handler != "" {
+		attrs = append(attrs, slog.String("handler", e.handler))
+	}
+	if e.userID != "" {
+		attrs = append(attrs, slog.String("user_id", e.userID))
+	}
+	if e.streamID != 0 {
+		attrs = append(attrs, slog.Uint64("stream_id", e.streamID))
+	}
+	return attrs
+}
@@ -0,0 +1,88 @@
+package reqlog_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages/modules/messaging"
+	"github.com/romshark/datapages/runtime/reqlog"
+)
+
+// lastLine returns the attributes of the last line buf holds.
+func lastLine(t *testing.T, buf *bytes.Buffer) map[string]any {
+	t.Helper()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var m map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &m))
+	return m
+}
+
+func TestValidID(t *testing.T) {
+	require.True(t, reqlog.ValidID("0af7651916cd43dd"))
+	require.True(t, reqlog.ValidID(reqlog.NewID()))
+	require.False(t, reqlog.ValidID(""))
+	require.False(t, reqlog.ValidID("a b"))
+	require.False(t, reqlog.ValidID("a\nb"))
+	require.False(t, reqlog.ValidID(strings.Repeat("a", 129)))
+}
+
+func TestLogger(t *testing.T) {
+	require.Nil(t, reqlog.Logger(context.Background()))
+
+	var buf bytes.Buffer
+	e := reqlog.New(slog.New(slog.NewJSONHandler(&buf, nil)), "req-1")
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(reqlog.NewContext(r.Context(), e))
+	r.Pattern = "GET /{$}"
+
+	reqlog.Logger(r.Context()).Info("first")
+	require.Equal(t, "req-1", lastLine(t, &buf)["request_id"])
+	require.NotContains(t, lastLine(t, &buf), "handler")
+
+	// What's learned later reaches the logger.
+	reqlog.SetHandler(r, "PageIndex.GET")
+	reqlog.SetUser(r.Context(), "u1")
+	reqlog.SetStream(r.Context(), 7)
+	reqlog.Logger(r.Context()).Info("second")
+	line := lastLine(t, &buf)
+	require.Equal(t, "PageIndex.GET", line["handler"])
+	require.Equal(t, "u1", line["user_id"])
+	require.Equal(t, 7.0, line["stream_id"])
+	require.Equal(t, "GET /{$}", e.Route())
+	e.SetRoute("GET /other/{$}")
+	require.Equal(t, "GET /{$}", e.Route(), "the route of the handler was replaced")
+}
+
+func TestEvent(t *testing.T) {
+	var buf bytes.Buffer
+	stream := reqlog.New(slog.New(slog.NewJSONHandler(&buf, nil)), "stream-req")
+	ctx := reqlog.NewContext(context.Background(), stream)
+	reqlog.SetUser(ctx, "u1")
+	reqlog.SetStream(ctx, 3)
+
+	action := reqlog.NewContext(ctx, reqlog.New(slog.Default(), "action-req"))
+	msg := messaging.Message{
+		Subject: "posted",
+		Header:  reqlog.MessageHeaderOf(action, nil),
+	}
+	ectx := reqlog.Event(ctx, "PageIndex.OnPosted", msg)
+	reqlog.Logger(ectx).Info("handled")
+	line := lastLine(t, &buf)
+	require.Equal(t, "stream-req", line["request_id"])
+	require.Equal(t, "action-req", line["origin_request_id"])
+	require.Equal(t, "PageIndex.OnPosted", line["handler"])
+	require.Equal(t, "u1", line["user_id"])
+	require.Equal(t, 3.0, line["stream_id"])
+
+	// Without an entry there's nothing to carry.
+	require.Nil(t, reqlog.MessageHeaderOf(context.Background(), nil))
+	bg := context.Background()
+	require.Equal(t, bg, reqlog.Event(bg, "PageIndex.OnPosted", msg))
+}
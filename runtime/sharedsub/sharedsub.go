@@ -0,0 +1,182 @@
+// Package sharedsub lets the streams of a group subscribing to the same
+// subjects share one broker subscription, see datapages.StreamsConfig.Shared.
+//
+// Application code must not import this package.
+package sharedsub
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/romshark/datapages/modules/messaging"
+)
+
+// Group is the streams sharing subscriptions, those of one connection.
+// The zero value is ready to use.
+type Group struct {
+	lock sync.Mutex
+	subs map[string]*shared
+}
+
+type ctxKey struct{}
+
+// NewContext returns ctx subscribing as a member of g.
+func NewContext(ctx context.Context, g *Group) context.Context {
+	return context.WithValue(ctx, ctxKey{}, g)
+}
+
+// Interceptor shares the subscriptions of the members of a group that
+// subscribe to the same subjects. Other subscriptions pass through.
+//
+// It must be the outermost interceptor, those after it see the subscribe
+// of the first member only. A message a member is too slow to take is
+// dropped for it, like the broker would, and reported to the metrics
+// the member subscribed with.
+func Interceptor() messaging.Interceptor {
+	return messaging.Interceptor{Subscribe: subscribe}
+}
+
+func subscribe(
+	ctx context.Context, subjects []string, next messaging.SubscribeFunc,
+) (messaging.Subscription, error) {
+	g, ok := ctx.Value(ctxKey{}).(*Group)
+	if !ok {
+		return next(ctx, subjects)
+	}
+	return g.subscribe(ctx, subjects, next)
+}
+
+// shared is a broker subscription the members of a group share.
+type shared struct {
+	key     string
+	sub     messaging.Subscription
+	done    chan struct{} // Closed once the last member left.
+	members map[*member]struct{}
+}
+
+// member is the share of a stream in a shared subscription.
+type member struct {
+	g       *Group
+	s       *shared
+	ch      chan messaging.Message
+	metrics messaging.Metrics // Nil when the subscribe had none.
+	once    sync.Once
+}
+
+func (m *member) C() <-chan messaging.Message { return m.ch }
+
+func (m *member) Close() { m.once.Do(func() { m.g.leave(m) }) }
+
+// subscribe returns a member of the subscription of g to subjects,
+// subscribing with next when g has none yet. The subscription is made with
+// ctx of the first member, which it outlives until the last member leaves.
+func (g *Group) subscribe(
+	ctx context.Context, subjects []string, next messaging.SubscribeFunc,
+) (messaging.Subscription, error) {
+	key := strings.Join(subjects, "\x00")
+	metrics := messaging.SubscribeMetrics(ctx)
+	g.lock.Lock()
+	if s := g.subs[key]; s != nil {
+		m := s.join(g, metrics)
+		g.lock.Unlock()
+		return m, nil
+	}
+	g.lock.Unlock()
+
+	sub, err := next(context.WithoutCancel(ctx), subjects)
+	if err != nil {
+		return nil, err
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if s := g.subs[key]; s != nil {
+		// Another member subscribed meanwhile.
+		sub.Close()
+		return s.join(g, metrics), nil
+	}
+	s := &shared{
+		key:     key,
+		sub:     sub,
+		done:    make(chan struct{}),
+		members: map[*member]struct{}{},
+	}
+	if g.subs == nil {
+		g.subs = map[string]*shared{}
+	}
+	g.subs[key] = s
+	m := s.join(g, metrics)
+	go g.fanOut(s)
+	return m, nil
+}
+
+// join adds a member to s. g.lock must be held.
+func (s *shared) join(g *Group, metrics messaging.Metrics) *member {
+	m := &member{
+		g:       g,
+		s:       s,
+		ch:      make(chan messaging.Message, messaging.DefaultBrokerChanBuffer),
+		metrics: metrics,
+	}
+	s.members[m] = struct{}{}
+	return m
+}
+
+// leave removes m from its subscription, which is closed when m was the
+// last member.
+func (g *Group) leave(m *member) {
+	g.lock.Lock()
+	s := m.s
+	if _, ok := s.members[m]; !ok {
+		// The subscription was closed by the broker.
+		g.lock.Unlock()
+		return
+	}
+	delete(s.members, m)
+	close(m.ch)
+	last := len(s.members) == 0
+	if last {
+		delete(g.subs, s.key)
+		close(s.done)
+	}
+	g.lock.Unlock()
+	if last {
+		s.sub.Close()
+	}
+}
+
+// fanOut delivers what the subscription of s receives to its members until
+// the last one left, or until the broker closes it, which closes them.
+func (g *Group) fanOut(s *shared) {
+	in := s.sub.C()
+	for {
+		select {
+		case <-s.done:
+			return
+		case msg, ok := <-in:
+			g.lock.Lock()
+			if !ok {
+				if g.subs[s.key] == s {
+					delete(g.subs, s.key)
+				}
+				for m := range s.members {
+					delete(s.members, m)
+					close(m.ch)
+				}
+				g.lock.Unlock()
+				return
+			}
+			for m := range s.members {
+				select {
+				case m.ch <- msg:
+				default: // Drop if the member is slow, like the broker would.
+					if m.metrics != nil {
+						m.metrics.OnDeliveryDropped()
+					}
+				}
+			}
+			g.lock.Unlock()
+		}
+	}
+}
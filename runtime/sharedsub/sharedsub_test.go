@@ -0,0 +1,168 @@
+package sharedsub_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages/modules/messaging"
+	"github.com/romshark/datapages/modules/messaging/inmem"
+	"github.com/romshark/datapages/runtime/sharedsub"
+)
+
+type noMetrics struct{}
+
+func (noMetrics) OnPublish(string)   {}
+func (noMetrics) OnDeliveryDropped() {}
+
+// newBroker returns a broker sharing the subscriptions of groups and
+// the number of subscriptions that reached b.
+func newBroker(t *testing.T) (messaging.Broker, *atomic.Int32) {
+	t.Helper()
+	b := inmem.New(messaging.DefaultBrokerChanBuffer)
+	t.Cleanup(func() { require.NoError(t, b.Close()) })
+	var n atomic.Int32
+	return messaging.Intercept(b, sharedsub.Interceptor(), messaging.Interceptor{
+		Subscribe: func(
+			ctx context.Context, subjects []string, next messaging.SubscribeFunc,
+		) (messaging.Subscription, error) {
+			n.Add(1)
+			return next(ctx, subjects)
+		},
+	}), &n
+}
+
+func subscribe(
+	t *testing.T, ctx context.Context, b messaging.Broker, subjects ...string,
+) messaging.Subscription {
+	t.Helper()
+	sub, err := b.Subscribe(ctx, noMetrics{}, subjects...)
+	require.NoError(t, err)
+	return sub
+}
+
+func receive(t *testing.T, sub messaging.Subscription) string {
+	t.Helper()
+	select {
+	case msg, ok := <-sub.C():
+		require.True(t, ok, "the subscription channel was closed")
+		return string(msg.Data)
+	case <-time.After(time.Second):
+		t.Fatal("no message arrived")
+		return ""
+	}
+}
+
+func publish(t *testing.T, b messaging.Broker, subject, data string) {
+	t.Helper()
+	require.NoError(t, b.Publish(context.Background(), noMetrics{}, subject, []byte(data)))
+}
+
+func TestShare(t *testing.T) {
+	t.Parallel()
+
+	b, subscribes := newBroker(t)
+	g1, g2 := new(sharedsub.Group), new(sharedsub.Group)
+	ctx1 := sharedsub.NewContext(context.Background(), g1)
+
+	a := subscribe(t, ctx1, b, "x", "y")
+	c := subscribe(t, ctx1, b, "x", "y")
+	require.Equal(t, int32(1), subscribes.Load(), "a group shares a subscription")
+
+	other := subscribe(t, ctx1, b, "x")
+	foreign := subscribe(t, sharedsub.NewContext(context.Background(), g2), b, "x", "y")
+	plain := subscribe(t, context.Background(), b, "x", "y")
+	require.Equal(t, int32(4), subscribes.Load())
+
+	publish(t, b, "x", "1")
+	for _, sub := range []messaging.Subscription{a, c, other, foreign, plain} {
+		require.Equal(t, "1", receive(t, sub))
+	}
+
+	// The subscription outlives a member leaving.
+	a.Close()
+	a.Close()
+	_, ok := <-a.C()
+	require.False(t, ok, "the channel of a closed member is closed")
+	publish(t, b, "y", "2")
+	require.Equal(t, "2", receive(t, c))
+
+	// The last member leaving closes it.
+	c.Close()
+	c = subscribe(t, ctx1, b, "x", "y")
+	require.Equal(t, int32(5), subscribes.Load())
+	publish(t, b, "x", "3")
+	require.Equal(t, "3", receive(t, c))
+
+	for _, sub := range []messaging.Subscription{c, other, foreign, plain} {
+		sub.Close()
+	}
+}
+
+type countingMetrics struct{ dropped atomic.Int32 }
+
+func (*countingMetrics) OnPublish(string)     {}
+func (m *countingMetrics) OnDeliveryDropped() { m.dropped.Add(1) }
+
+// TestDrop covers a member too slow to take the messages,
+// which are dropped and reported to the metrics it subscribed with.
+func TestDrop(t *testing.T) {
+	t.Parallel()
+
+	b, _ := newBroker(t)
+	ctx := sharedsub.NewContext(context.Background(), new(sharedsub.Group))
+	var metrics countingMetrics
+	sub, err := b.Subscribe(ctx, &metrics, "x")
+	require.NoError(t, err)
+	defer sub.Close()
+
+	for range messaging.DefaultBrokerChanBuffer {
+		publish(t, b, "x", "")
+	}
+	require.Eventually(t, func() bool {
+		return len(sub.C()) == messaging.DefaultBrokerChanBuffer
+	}, time.Second, time.Millisecond)
+	for range 3 {
+		publish(t, b, "x", "")
+	}
+	require.Eventually(t, func() bool {
+		return metrics.dropped.Load() == 3
+	}, time.Second, time.Millisecond)
+}
+
+// fakeSub is a subscription the test closes the channel of,
+// the way a broker does when the connection is lost.
+type fakeSub struct{ ch chan messaging.Message }
+
+func (s fakeSub) C() <-chan messaging.Message { return s.ch }
+func (fakeSub) Close()                        {}
+
+func TestBrokerCloses(t *testing.T) {
+	t.Parallel()
+
+	upstream := fakeSub{ch: make(chan messaging.Message)}
+	b := messaging.Intercept(inmem.New(messaging.DefaultBrokerChanBuffer),
+		sharedsub.Interceptor(), messaging.Interceptor{
+			Subscribe: func(
+				context.Context, []string, messaging.SubscribeFunc,
+			) (messaging.Subscription, error) {
+				return upstream, nil
+			},
+		})
+	ctx := sharedsub.NewContext(context.Background(), new(sharedsub.Group))
+	a, c := subscribe(t, ctx, b, "x"), subscribe(t, ctx, b, "x")
+
+	close(upstream.ch)
+	for _, sub := range []messaging.Subscription{a, c} {
+		select {
+		case _, ok := <-sub.C():
+			require.False(t, ok)
+		case <-time.After(time.Second):
+			t.Fatal("the member wasn't closed")
+		}
+		sub.Close()
+	}
+}
@@ -0,0 +1,145 @@
+package sse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"html"
+	"io"
+	"strings"
+
+	"github.com/romshark/datapages"
+)
+
+// NewInline returns a [datapages.SSE] writing to w, the body of a page
+// that is still being streamed, instead of to an event stream.
+// Each call writes HTML the browser applies as it parses it: elements in
+// a template followed by the script placing them, scripts as they are and
+// signals in a hidden element Datastar reads them from once it runs.
+// Elements are put in place rather than morphed, the page was never shown
+// with them.
+func NewInline(ctx context.Context, w io.Writer) datapages.SSE {
+	return inline{ctx: ctx, w: w}
+}
+
+type inline struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (s inline) Context() context.Context { return s.ctx }
+
+// placeScript places the content of the template before the script s in mode m,
+// into the elements matching the selector q or, without one, each element
+// in place of the element carrying its id. With v it does so in
+// a view transition if the browser supports them.
+const placeScript = `(s=>{const t=s.previousElementSibling;t.remove();s.remove();` +
+	`const p=(e,x)=>({outer:()=>e.replaceWith(x),replace:()=>e.replaceWith(x),` +
+	`inner:()=>e.replaceChildren(x),prepend:()=>e.prepend(x),append:()=>e.append(x),` +
+	`before:()=>e.before(x),after:()=>e.after(x)})[m]();` +
+	`const f=()=>{` +
+	`if(q){document.querySelectorAll(q).forEach(e=>p(e,t.content.cloneNode(true)));return}` +
+	`for(const x of [...t.content.children]){const e=x.id&&document.getElementById(x.id);if(e)p(e,x)}};` +
+	`v&&document.startViewTransition?document.startViewTransition(f):f()` +
+	`})(document.currentScript)`
+
+func (s inline) PatchElement(c datapages.Component, opts ...datapages.PatchOption) error {
+	o := datapages.NewPatchOptions(opts...)
+	if o.Mode == "" {
+		o.Mode = datapages.PatchModeOuter
+	}
+	var b bytes.Buffer
+	b.WriteString("<template>")
+	if err := c.Render(s.ctx, &b); err != nil {
+		return err
+	}
+	b.WriteString("</template><script>{const m=")
+	b.Write(jsString(string(o.Mode)))
+	b.WriteString(",q=")
+	b.Write(jsString(o.Selector))
+	if o.ViewTransition {
+		b.WriteString(",v=true")
+	} else {
+		b.WriteString(",v=false")
+	}
+	b.WriteString(";" + placeScript + "}</script>")
+	_, err := s.w.Write(b.Bytes())
+	return err
+}
+
+func (s inline) PatchElementAt(
+	c datapages.Component, selectorCSS string, mode datapages.PatchMode,
+	opts ...datapages.PatchOption,
+) error {
+	return s.PatchElement(c, append(
+		[]datapages.PatchOption{datapages.PatchSelector(selectorCSS), mode}, opts...,
+	)...)
+}
+
+func (s inline) RemoveElement(selectorCSS string) error {
+	return s.ExecuteScript(
+		"document.querySelectorAll(" + string(jsString(selectorCSS)) +
+			").forEach(e=>e.remove())",
+	)
+}
+
+func (s inline) ExecuteScript(script string) error {
+	// The script can't end the element it's written in.
+	script = strings.ReplaceAll(script, "</script", `<\/script`)
+	_, err := io.WriteString(s.w, "<script>"+script+"</script>")
+	return err
+}
+
+func (s inline) PatchSignals(v any) error {
+	return s.writeSignals("data-signals", v)
+}
+
+func (s inline) PatchSignalsIfMissing(v any) error {
+	return s.writeSignals("data-signals__ifmissing", v)
+}
+
+func (s inline) writeSignals(attr string, v any) error {
+	j, err := marshalSignals(v)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(s.w,
+		"<div hidden "+attr+`="`+html.EscapeString(string(j))+`"></div>`)
+	return err
+}
+
+func (s inline) Redirect(target string) error {
+	return s.ExecuteScript(
+		"setTimeout(()=>window.location.href=" + string(jsString(target)) + ")",
+	)
+}
+
+func (s inline) RedirectReplace(target string) error {
+	return s.ExecuteScript(redirectReplaceScript(target))
+}
+
+func (s inline) PushURL(url string) error {
+	return s.ExecuteScript(historyScript("pushState", url))
+}
+
+func (s inline) ReplaceURL(url string) error {
+	return s.ExecuteScript(historyScript("replaceState", url))
+}
+
+func (s inline) Prefetch(urls ...string) error {
+	j, err := json.Marshal(map[string]any{
+		"prefetch": []any{map[string]any{"source": "list", "urls": urls}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(s.w, `<script type="speculationrules">`+string(j)+"</script>")
+	return err
+}
+
+// jsString returns s as a JavaScript string literal,
+// which json.Marshal keeps free of HTML by escaping <, > and &.
+func jsString(s string) []byte {
+	j, _ := json.Marshal(s)
+	return j
+}
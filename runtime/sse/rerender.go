@@ -0,0 +1,100 @@
+package sse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/starfederation/datastar-go/datastar"
+
+	"github.com/romshark/datapages/runtime/deferred"
+)
+
+// Rerender runs get, the generated GET handler of a page, for the page the
+// stream request r was opened from and morphs the <body> it renders into
+// the page of the client of g.
+//
+// Deferred components of the page are resolved as it's rendered.
+// A redirect get answers with navigates the client, a cookie it sets reloads
+// the page, which a stream can't set it for. Any other status than
+// 200 OK is an error and leaves the page as it is.
+func Rerender(
+	ctx context.Context, g *datastar.ServerSentEventGenerator,
+	r *http.Request, get http.HandlerFunc,
+) error {
+	rec := &pageRecorder{header: make(http.Header), status: http.StatusOK}
+	// Nothing streams the deferred components of the page after it,
+	// the page must be complete when it's morphed.
+	get(rec, PageRequest(deferred.InPlace(ctx), r))
+
+	switch {
+	case len(rec.header.Values("Set-Cookie")) > 0:
+		return g.ExecuteScript("window.location.reload()")
+	case rec.status >= 300 && rec.status < 400 && rec.header.Get("Location") != "":
+		return g.Redirect(rec.header.Get("Location"))
+	case rec.status != http.StatusOK:
+		return fmt.Errorf("GET answered %d", rec.status)
+	}
+
+	html := rec.body.String()
+	start := strings.Index(html, "<body")
+	end := strings.LastIndex(html, "</body>")
+	if start < 0 || end < start {
+		return errors.New("GET rendered no body")
+	}
+	return g.PatchElements(
+		html[start:end+len("</body>")], datastar.WithSelector("body"),
+	)
+}
+
+// PageRequest returns the GET request of the page the stream request r
+// was opened from, carrying ctx. It keeps the path values and the cookies of r.
+// The query is the one of the Referer header when it names the page on
+// the same host, the page is requested without one otherwise.
+// The request isn't a Datastar request, it carries no signals.
+func PageRequest(ctx context.Context, r *http.Request) *http.Request {
+	p := r.Clone(ctx)
+	p.Header.Del("Datastar-Request")
+
+	path := r.URL.Path
+	if s, ok := strings.CutSuffix(path, "_$/anon/"); ok {
+		path = s
+	} else {
+		path = strings.TrimSuffix(path, "_$/")
+	}
+	if path = strings.TrimSuffix(path, "/"); path == "" {
+		path = "/"
+	}
+	p.URL.Path, p.URL.RawPath, p.URL.RawQuery = path, "", ""
+	if ref, err := url.Parse(r.Referer()); err == nil && ref.Host == r.Host &&
+		strings.TrimSuffix(ref.Path, "/") == strings.TrimSuffix(path, "/") {
+		p.URL.RawQuery = ref.RawQuery
+	}
+	p.RequestURI = p.URL.RequestURI()
+	return p
+}
+
+// pageRecorder buffers the response of a GET handler.
+type pageRecorder struct {
+	header http.Header
+	status int
+	wrote  bool
+	body   bytes.Buffer
+}
+
+func (r *pageRecorder) Header() http.Header { return r.header }
+
+func (r *pageRecorder) WriteHeader(status int) {
+	if !r.wrote {
+		r.status, r.wrote = status, true
+	}
+}
+
+func (r *pageRecorder) Write(b []byte) (int, error) {
+	r.wrote = true
+	return r.body.Write(b)
+}
@@ -21,40 +21,46 @@ func New(g *datastar.ServerSentEventGenerator) datapages.SSE {
 	return wrapper{g: g}
 }
 
+// NewContext is [New] with ctx as the Context of the result. ctx must be
+// derived from the context of g, an event handler gets one carrying its logger.
+func NewContext(ctx context.Context, g *datastar.ServerSentEventGenerator) datapages.SSE {
+	return wrapper{g: g, ctx: ctx}
+}
+
 type wrapper struct {
-	g *datastar.ServerSentEventGenerator
+	g   *datastar.ServerSentEventGenerator
+	ctx context.Context // Nil for the context of g.
 }
 
-func (s wrapper) Context() context.Context { return s.g.Context() }
+func (s wrapper) Context() context.Context {
+	if s.ctx != nil {
+		return s.ctx
+	}
+	return s.g.Context()
+}
 
-func (s wrapper) PatchElement(c datapages.Component) error {
-	return s.g.PatchElementTempl(c)
+func (s wrapper) PatchElement(c datapages.Component, opts ...datapages.PatchOption) error {
+	o := datapages.NewPatchOptions(opts...)
+	var dopts []datastar.PatchElementOption
+	if o.Selector != "" {
+		dopts = append(dopts, datastar.WithSelector(o.Selector))
+	}
+	if o.Mode != "" {
+		dopts = append(dopts, datastar.WithMode(datastar.ElementPatchMode(o.Mode)))
+	}
+	if o.ViewTransition {
+		dopts = append(dopts, datastar.WithViewTransitions())
+	}
+	return s.g.PatchElementTempl(c, dopts...)
 }
 
 func (s wrapper) PatchElementAt(
 	c datapages.Component, selectorCSS string, mode datapages.PatchMode,
+	opts ...datapages.PatchOption,
 ) error {
-	switch mode {
-	case datapages.PatchModeOuter, datapages.PatchModeInner,
-		datapages.PatchModeReplace, datapages.PatchModePrepend,
-		datapages.PatchModeAppend, datapages.PatchModeBefore,
-		datapages.PatchModeAfter:
-	default:
-		mode = "" // Not a PatchMode constant, patch in the default mode.
-	}
-	switch {
-	case selectorCSS == "" && mode == "":
-		return s.g.PatchElementTempl(c)
-	case mode == "":
-		return s.g.PatchElementTempl(c, datastar.WithSelector(selectorCSS))
-	case selectorCSS == "":
-		return s.g.PatchElementTempl(
-			c, datastar.WithMode(datastar.ElementPatchMode(mode)),
-		)
-	}
-	return s.g.PatchElementTempl(c,
-		datastar.WithSelector(selectorCSS),
-		datastar.WithMode(datastar.ElementPatchMode(mode)))
+	return s.PatchElement(c, append(
+		[]datapages.PatchOption{datapages.PatchSelector(selectorCSS), mode}, opts...,
+	)...)
 }
 
 // removeElementModeDataline is the mode line of a removal event.
@@ -107,6 +113,28 @@ func (s wrapper) Redirect(target string) error {
 	return s.g.Redirect(target)
 }
 
+func (s wrapper) RedirectReplace(target string) error {
+	return s.g.ExecuteScript(redirectReplaceScript(target))
+}
+
+func (s wrapper) PushURL(url string) error {
+	return s.g.ExecuteScript(historyScript("pushState", url))
+}
+
+func (s wrapper) ReplaceURL(url string) error {
+	return s.g.ExecuteScript(historyScript("replaceState", url))
+}
+
+// redirectReplaceScript navigates to target, replacing the history entry.
+func redirectReplaceScript(target string) string {
+	return "setTimeout(()=>window.location.replace(" + string(jsString(target)) + "))"
+}
+
+// historyScript calls the window.history method m to show url.
+func historyScript(m, url string) string {
+	return "window.history." + m + "(null,''," + string(jsString(url)) + ")"
+}
+
 func (s wrapper) Prefetch(urls ...string) error {
 	return s.g.Prefetch(urls...)
 }
@@ -1,11 +1,16 @@
 package sse_test
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/a-h/templ"
 	"github.com/starfederation/datastar-go/datastar"
@@ -80,6 +85,32 @@ func TestPatchElement(t *testing.T) {
 			},
 			omit: []string{"data: mode", "data: selector"},
 		},
+		"options": {
+			call: func(s datapages.SSE) error {
+				return s.PatchElement(element,
+					datapages.PatchSelector("#t"),
+					datapages.PatchModePrepend,
+					datapages.PatchViewTransition)
+			},
+			want: []string{
+				"data: selector #t", "data: mode prepend", "data: useViewTransition true",
+			},
+		},
+		"last option applies": {
+			call: func(s datapages.SSE) error {
+				return s.PatchElementAt(element, "#t", datapages.PatchModeAppend,
+					datapages.PatchSelector("#u"), datapages.PatchModeInner)
+			},
+			want: []string{"data: selector #u", "data: mode inner"},
+			omit: []string{"#t", "append"},
+		},
+		"unknown mode option is ignored": {
+			call: func(s datapages.SSE) error {
+				return s.PatchElement(element,
+					datapages.PatchModeInner, datapages.PatchMode("sideways"))
+			},
+			want: []string{"data: mode inner"},
+		},
 	} {
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
@@ -177,9 +208,211 @@ func TestScriptAndRedirect(t *testing.T) {
 	})
 	require.Contains(t, got, "/next/")
 
+	got = frame(t, func(g *datastar.ServerSentEventGenerator) error {
+		return sse.New(g).RedirectReplace("/next/")
+	})
+	require.Contains(t, got, `setTimeout(()=>window.location.replace("/next/"))`)
+
+	got = frame(t, func(g *datastar.ServerSentEventGenerator) error {
+		return sse.New(g).PushURL("/?q=1")
+	})
+	require.Contains(t, got, `window.history.pushState(null,'',"/?q=1")`)
+
+	got = frame(t, func(g *datastar.ServerSentEventGenerator) error {
+		return sse.New(g).ReplaceURL("/?q=1")
+	})
+	require.Contains(t, got, `window.history.replaceState(null,'',"/?q=1")`)
+
 	got = frame(t, func(g *datastar.ServerSentEventGenerator) error {
 		return sse.New(g).Prefetch("/a/", "/b/")
 	})
 	require.Contains(t, got, "/a/")
 	require.Contains(t, got, "/b/")
 }
+
+func TestNewContext(t *testing.T) {
+	type key struct{}
+	w := &recorder{h: make(http.Header)}
+	g := datastar.NewSSE(w, httptest.NewRequest(http.MethodGet, "/_$/", nil))
+	require.Equal(t, g.Context(), sse.New(g).Context())
+
+	ctx := context.WithValue(g.Context(), key{}, "event")
+	require.Equal(t, "event", sse.NewContext(ctx, g).Context().Value(key{}))
+}
+
+func TestHeartbeatAndExpire(t *testing.T) {
+	t.Parallel()
+
+	w := &recorder{h: make(http.Header)}
+	g, sw := sse.NewSSE(w, httptest.NewRequest(http.MethodGet, "/_$/", nil))
+	require.Empty(t, w.h.Get("Content-Encoding"))
+	require.NoError(t, sse.Heartbeat(sw))
+	require.NoError(t, sse.Expire(sw, 0))
+	require.NoError(t, g.PatchElementTempl(element))
+	require.NoError(t, sse.Expire(sw, 5*time.Second))
+
+	got := w.buf.String()
+	require.True(t, strings.HasPrefix(got, ": heartbeat\n\nevent: datastar-patch-elements\n"),
+		"a heartbeat is a comment, the zero retry sends nothing: %q", got)
+	require.True(t, strings.HasSuffix(got, "\n\nretry: 5000\n\n"),
+		"the retry hint is a frame of its own: %q", got)
+	require.Equal(t, 1, strings.Count(got, "event:"))
+}
+
+// TestWriterCompresses covers the heartbeats of a compressed stream,
+// which must be compressed with the events, written from goroutines of their own.
+func TestWriterCompresses(t *testing.T) {
+	t.Parallel()
+
+	w := &recorder{h: make(http.Header)}
+	r := httptest.NewRequest(http.MethodGet, "/_$/", nil)
+	r.Header.Set("Accept-Encoding", "deflate, gzip;q=1.0")
+	g, sw := sse.NewSSE(w, r)
+	require.Equal(t, "gzip", w.h.Get("Content-Encoding"))
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() { require.NoError(t, sse.Heartbeat(sw)) })
+		wg.Go(func() { require.NoError(t, g.PatchElementTempl(element)) })
+	}
+	wg.Wait()
+
+	zr, err := gzip.NewReader(strings.NewReader(w.buf.String()))
+	require.NoError(t, err)
+	// The stream is never closed, the reader runs out of input after the last flush.
+	got, _ := io.ReadAll(zr)
+	require.Equal(t, 10, strings.Count(string(got), ": heartbeat\n\n"))
+	require.Equal(t, 10, strings.Count(string(got), "event: datastar-patch-elements\n"))
+}
+
+func TestRerender(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		get     http.HandlerFunc
+		want    []string
+		wantErr string
+	}{
+		"body": {
+			get: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`<html><head></head><body data-x="1"><p>` +
+					r.URL.Query().Get("q") + `</p></body></html>`))
+			},
+			want: []string{
+				"event: datastar-patch-elements",
+				"data: selector body",
+				`data: elements <body data-x="1"><p>v</p></body>`,
+			},
+		},
+		"redirect": {
+			get: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "/login/", http.StatusSeeOther)
+			},
+			want: []string{"event: datastar-patch-elements", "/login/"},
+		},
+		"cookie reloads": {
+			get: func(w http.ResponseWriter, r *http.Request) {
+				http.SetCookie(w, &http.Cookie{Name: "s", Value: "1"})
+				http.Redirect(w, r, "/login/", http.StatusSeeOther)
+			},
+			want: []string{"window.location.reload()"},
+		},
+		"error": {
+			get: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "boom", http.StatusInternalServerError)
+			},
+			wantErr: "GET answered 500",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			w := &recorder{h: make(http.Header)}
+			req := httptest.NewRequest(http.MethodGet, "/_$/", nil)
+			req.Header.Set("Referer", "http://example.com/?q=v")
+			err := sse.Rerender(
+				context.Background(), datastar.NewSSE(w, req), req, tc.get,
+			)
+			if tc.wantErr != "" {
+				require.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			for _, s := range tc.want {
+				require.Contains(t, w.buf.String(), s)
+			}
+		})
+	}
+}
+
+func TestPageRequest(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		target, referer string
+		wantURI         string
+	}{
+		"index":            {"/_$/", "", "/"},
+		"query":            {"/post/a/_$/", "http://example.com/post/a?x=1", "/post/a?x=1"},
+		"anonymous stream": {"/post/a/_$/anon/", "http://example.com/post/a/?x=1", "/post/a?x=1"},
+		"other page":       {"/post/a/_$/", "http://example.com/post/b?x=1", "/post/a"},
+		"other host":       {"/_$/", "http://evil.example/?x=1", "/"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := httptest.NewRequest(http.MethodGet, tc.target+"?datastar=%7B%7D", nil)
+			r.Header.Set("Datastar-Request", "true")
+			if tc.referer != "" {
+				r.Header.Set("Referer", tc.referer)
+			}
+			p := sse.PageRequest(context.Background(), r)
+			require.Equal(t, tc.wantURI, p.RequestURI)
+			require.Equal(t, tc.wantURI, p.URL.RequestURI())
+			require.Empty(t, p.Header.Get("Datastar-Request"))
+		})
+	}
+}
+
+func TestInline(t *testing.T) {
+	var b strings.Builder
+	s := sse.NewInline(context.Background(), &b)
+
+	require.NoError(t, s.PatchElement(element))
+	got := b.String()
+	require.True(t, strings.HasPrefix(got, `<template><div id="out">x</div></template><script>`))
+	require.Contains(t, got, `{const m="outer",q="",v=false;`)
+
+	b.Reset()
+	require.NoError(t, s.PatchElementAt(element, "#list > li", datapages.PatchModeAppend))
+	require.Contains(t, b.String(), `{const m="append",q="#list \u003e li",v=false;`,
+		"the selector is HTML-safe")
+
+	b.Reset()
+	require.NoError(t, s.PatchElement(element, datapages.PatchViewTransition))
+	require.Contains(t, b.String(), `{const m="outer",q="",v=true;`)
+
+	b.Reset()
+	require.NoError(t, s.ExecuteScript(`alert("</script>")`))
+	require.Equal(t, `<script>alert("<\/script>")</script>`, b.String())
+
+	b.Reset()
+	require.NoError(t, s.PatchSignalsIfMissing(map[string]int{"n": 1}))
+	require.Equal(t, `<div hidden data-signals__ifmissing="{&#34;n&#34;:1}"></div>`, b.String())
+
+	b.Reset()
+	require.NoError(t, s.Redirect(`/a"b`))
+	require.Equal(t, `<script>setTimeout(()=>window.location.href="/a\"b")</script>`, b.String())
+
+	b.Reset()
+	require.NoError(t, s.RedirectReplace("/a"))
+	require.Equal(t, `<script>setTimeout(()=>window.location.replace("/a"))</script>`, b.String())
+
+	b.Reset()
+	require.NoError(t, s.ReplaceURL("/?q=</script>"))
+	require.Equal(t, `<script>window.history.replaceState(null,'',"/?q=\u003c/script\u003e")</script>`,
+		b.String())
+
+	b.Reset()
+	require.NoError(t, s.Prefetch("/next"))
+	require.Equal(t, `<script type="speculationrules">`+
+		`{"prefetch":[{"source":"list","urls":["/next"]}]}</script>`, b.String())
+}
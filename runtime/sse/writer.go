@@ -0,0 +1,149 @@
+package sse
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CAFxX/httpcompression"
+	"github.com/CAFxX/httpcompression/contrib/andybalholm/brotli"
+	"github.com/CAFxX/httpcompression/contrib/compress/gzip"
+	"github.com/CAFxX/httpcompression/contrib/compress/zlib"
+	"github.com/CAFxX/httpcompression/contrib/klauspost/zstd"
+	"github.com/starfederation/datastar-go/datastar"
+)
+
+// compressor is a content encoding a stream may be compressed with.
+type compressor struct {
+	encoding string
+	provider httpcompression.CompressorProvider
+}
+
+// compressors are the encodings of datastar.WithCompression in its order
+// of preference, the server's.
+var compressors = func() []compressor {
+	br, _ := brotli.New(brotli.Options{Quality: brotli.DefaultCompression})
+	zs, _ := zstd.New()
+	gz, _ := gzip.New(gzip.Options{Level: gzip.DefaultCompression})
+	fl, _ := zlib.New(zlib.Options{Level: zlib.DefaultCompression})
+	return []compressor{
+		{brotli.Encoding, br},
+		{zstd.Encoding, zs},
+		{gzip.Encoding, gz},
+		{zlib.Encoding, fl},
+	}
+}()
+
+// Writer is the response of a stream, which its Datastar generator writes
+// the events to and [Heartbeat] and [Expire] write beside them. It compresses
+// what it's given itself, datastar.WithCompression would compress the events
+// only. It's safe for concurrent use.
+type Writer struct {
+	mu sync.Mutex
+	w  http.ResponseWriter
+	rc *http.ResponseController
+	c  io.WriteCloser // Nil when uncompressed.
+}
+
+// NewSSE upgrades w to a stream, as datastar.NewSSE with
+// datastar.WithCompression does, and returns the generator of its events
+// and the writer they go through.
+func NewSSE(
+	w http.ResponseWriter, r *http.Request,
+) (*datastar.ServerSentEventGenerator, *Writer) {
+	sw := &Writer{w: w, rc: http.NewResponseController(w)}
+	accepted := r.Header.Get("Accept-Encoding")
+	for _, c := range compressors {
+		if acceptsEncoding(accepted, c.encoding) {
+			w.Header().Set("Content-Encoding", c.encoding)
+			sw.c = c.provider.Get(w)
+			break
+		}
+	}
+	return datastar.NewSSE(sw, r), sw
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header accepted
+// names encoding, parameters aside.
+func acceptsEncoding(accepted, encoding string) bool {
+	for part := range strings.SplitSeq(accepted, ",") {
+		token, _, _ := strings.Cut(part, ";")
+		if strings.TrimSpace(token) == encoding {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Writer) Header() http.Header { return w.w.Header() }
+
+func (w *Writer) WriteHeader(code int) { w.w.WriteHeader(code) }
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.write(p)
+}
+
+// Flush sends what was written to the client.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flush()
+}
+
+// FlushError is [Writer.Flush] for http.ResponseController.
+func (w *Writer) FlushError() error { return w.Flush() }
+
+// Unwrap returns the response w writes to, for http.ResponseController.
+func (w *Writer) Unwrap() http.ResponseWriter { return w.w }
+
+// send writes the frame p and flushes it, in one go between the events.
+func (w *Writer) send(p []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.write(p); err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+func (w *Writer) write(p []byte) (int, error) {
+	if w.c != nil {
+		return w.c.Write(p)
+	}
+	return w.w.Write(p)
+}
+
+func (w *Writer) flush() error {
+	if f, ok := w.c.(httpcompression.Flusher); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+	return w.rc.Flush()
+}
+
+// heartbeat is the comment a heartbeat sends. The client skips it,
+// a comment is never dispatched.
+var heartbeat = []byte(": heartbeat\n\n")
+
+// Heartbeat sends a heartbeat comment on w, which keeps proxies from cutting
+// a stream that has nothing to send as idle.
+func Heartbeat(w *Writer) error {
+	return w.send(heartbeat)
+}
+
+// Expire tells the client of w to wait retry before it reconnects,
+// ahead of the server closing the stream. It sends a frame with the retry
+// field only, which sets the delay and dispatches nothing. No-op for a zero
+// retry, which leaves the delay to the client.
+func Expire(w *Writer, retry time.Duration) error {
+	if retry <= 0 {
+		return nil
+	}
+	return w.send([]byte("retry: " + strconv.FormatInt(retry.Milliseconds(), 10) + "\n\n"))
+}
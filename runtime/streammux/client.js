@@ -0,0 +1,121 @@
+// The page side: fetch answers the streams Datastar opens with those the
+// leader carries. The leader is a shared worker or, where there are none,
+// the page holding the leader lock, which the others reach over
+// a broadcast channel. Without either, streams are fetched as they are.
+const WORKER = "/_$/mux/worker/", CHANNEL = "datapages-mux"
+const LEADER_LOCK = "datapages-mux-leader", PAGE_LOCK = "datapages-mux-page:"
+const STREAM = /\/_\$\/(anon\/)?$/
+const rnd = () => btoa(String.fromCharCode(...crypto.getRandomValues(new Uint8Array(12))))
+const page = rnd()
+const streams = new Map() // key -> {resolve, reject, ctl}
+const enc = new TextEncoder()
+let send = null
+
+const recv = m => {
+	const s = streams.get(m.key)
+	if (!s) return
+	switch (m.t) {
+	case "head": {
+		const empty = [101, 204, 205, 304].includes(m.status)
+		const body = empty ? null : new ReadableStream({start: c => { s.ctl = c }})
+		s.resolve(new Response(body, {status: m.status, headers: {"Content-Type": m.type}}))
+		if (empty) streams.delete(m.key)
+		return
+	}
+	case "data":
+		if (s.ctl) s.ctl.enqueue(enc.encode(m.data))
+		return
+	case "end":
+		streams.delete(m.key)
+		if (s.ctl) s.ctl.close()
+		else s.resolve(new Response(null, {status: 204}))
+		return
+	case "fail":
+		fail(m.key)
+	}
+}
+// fail ends the stream key as a lost connection, which Datastar retries.
+const fail = key => {
+	const s = streams.get(key)
+	if (!s) return
+	streams.delete(key)
+	const err = new TypeError("stream lost")
+	if (s.ctl) s.ctl.error(err)
+	else s.reject(err)
+}
+// reset fails every stream, which reopens them with the current leader.
+const reset = () => {
+	for (const key of [...streams.keys()]) {
+		send({t: "close", page, key})
+		fail(key)
+	}
+}
+const open = (url, instance, signal) => new Promise((resolve, reject) => {
+	const key = rnd()
+	streams.set(key, {resolve, reject, ctl: null})
+	signal?.addEventListener("abort", () => {
+		const s = streams.get(key)
+		if (!s) return
+		streams.delete(key)
+		send({t: "close", page, key})
+		const err = signal.reason ?? new DOMException("aborted", "AbortError")
+		if (s.ctl) s.ctl.error(err)
+		else reject(err)
+	}, {once: true})
+	if (signal?.aborted) return
+	send({t: "open", page, key, url, instance})
+})
+
+if (globalThis.SharedWorker) {
+	const port = new SharedWorker(WORKER, {name: CHANNEL}).port
+	port.onmessage = e => recv(e.data)
+	port.start()
+	send = m => port.postMessage(m)
+} else if (globalThis.BroadcastChannel && navigator.locks) {
+	const bc = new BroadcastChannel(CHANNEL)
+	let leader = null, release = null
+	const deliver = (to, m) => to === page ? recv(m) : bc.postMessage({...m, page: to})
+	send = m => leader ? leader.handle(m, m.page) : bc.postMessage(m)
+	bc.onmessage = e => {
+		const m = e.data
+		if (m.t === "leader") reset()
+		else if (["open", "close", "bye"].includes(m.t)) leader?.handle(m, m.page)
+		else if (m.page === page) recv(m)
+	}
+	const elect = () => navigator.locks.request(LEADER_LOCK, () => new Promise(r => {
+		release = r
+		leader = dpMuxLeader(deliver)
+		bc.postMessage({t: "leader"})
+		reset()
+	}))
+	elect()
+	addEventListener("pagehide", () => {
+		if (!leader) return
+		// A page kept in the back-forward cache must not hold on to the lead.
+		leader.stop()
+		leader = null
+		release()
+	})
+	addEventListener("pageshow", e => e.persisted && elect())
+}
+
+if (send) {
+	navigator.locks?.request(PAGE_LOCK + page, () => new Promise(() => {}))
+	addEventListener("pagehide", () => {
+		reset()
+		send({t: "bye", page})
+	})
+	const o = globalThis.fetch.bind(globalThis)
+	globalThis.fetch = (i, init = {}) => {
+		// A Request isn't copied, which would consume its body.
+		const req = i instanceof Request ? i : null
+		const method = (init.method || req?.method || "GET").toUpperCase()
+		const h = new Headers(init.headers || req?.headers)
+		const u = new URL(req ? req.url : i, location.href)
+		if (method !== "GET" || h.get("Datastar-Request") !== "true" ||
+			u.origin !== location.origin || !STREAM.test(u.pathname)
+		) return o(i, init)
+		return open(u.pathname + u.search, h.get("Datapages-Instance") || "",
+			init.signal || req?.signal)
+	}
+}
@@ -0,0 +1,129 @@
+// dpMuxLeader returns the leader, which keeps the connection and carries the
+// streams of the pages over it. A page sends it {t:"open",page,key,url,instance}
+// and {t:"close",page,key} for a stream and {t:"bye",page} when it's gone.
+// deliver(to,msg) sends a page {t:"head",key,status,type}, {t:"data",key,data},
+// {t:"end",key} and {t:"fail",key}, to being what handle was called with.
+function dpMuxLeader(deliver) {
+	const PATH = "/_$/mux/", EVENT = "datapages-mux", LOCK = "datapages-mux-page:"
+	const streams = new Map() // key -> {page, to}
+	let conn = "", ac = null, queue = [], idle = 0
+
+	const drop = (key, msg) => {
+		const s = streams.get(key)
+		if (!s) return
+		streams.delete(key)
+		if (msg) deliver(s.to, msg)
+		if (!streams.size) {
+			// A page navigating away opens the streams of the next one shortly.
+			clearTimeout(idle)
+			idle = setTimeout(() => !streams.size && ac && ac.abort(), 10000)
+		}
+	}
+	const post = ctl =>
+		fetch(PATH, {
+			method: "POST",
+			headers: {"Content-Type": "application/json"},
+			body: JSON.stringify({...ctl, conn}),
+		}).then(r => r.ok, () => false).then(ok => {
+			if (!ok && ctl.op === "add") drop(ctl.tab, {t: "fail", key: ctl.tab})
+		})
+	const control = ctl => {
+		if (conn) return post(ctl)
+		if (ctl.op !== "add") {
+			queue = queue.filter(q => q.tab !== ctl.tab)
+			return
+		}
+		queue.push(ctl)
+		connect()
+	}
+	const frame = f => {
+		if (f.conn) {
+			conn = f.conn
+			queue.splice(0).forEach(post)
+			return
+		}
+		const s = streams.get(f.tab)
+		if (!s) return
+		if (f.status) deliver(s.to, {t: "head", key: f.tab, status: f.status, type: f.type || ""})
+		if (f.data) deliver(s.to, {t: "data", key: f.tab, data: f.data})
+		if (f.end) drop(f.tab, {t: "end", key: f.tab})
+	}
+	const lost = () => {
+		ac = null
+		conn = ""
+		queue = []
+		for (const key of [...streams.keys()]) drop(key, {t: "fail", key})
+	}
+	const connect = () => {
+		if (ac) return
+		const c = ac = new AbortController()
+		fetch(PATH, {headers: {Accept: "text/event-stream"}, cache: "no-store", signal: c.signal})
+			.then(async r => {
+				if (!r.ok) return
+				const rd = r.body.pipeThrough(new TextDecoderStream()).getReader()
+				let buf = "", ev = "", data = []
+				for (;;) {
+					const {done, value} = await rd.read()
+					if (done) return
+					buf += value
+					let i
+					while ((i = buf.indexOf("\n")) >= 0) {
+						const line = buf.slice(0, i).replace(/\r$/, "")
+						buf = buf.slice(i + 1)
+						if (line === "") {
+							if (ev === EVENT && data.length) frame(JSON.parse(data.join("\n")))
+							ev = ""
+							data = []
+						} else if (line.startsWith("event:")) ev = line.slice(6).trim()
+						else if (line.startsWith("data:")) data.push(line.slice(5).replace(/^ /, ""))
+					}
+				}
+			})
+			.catch(() => {})
+			.finally(() => ac === c && lost())
+	}
+
+	// The streams of a page that died without saying goodbye are removed
+	// once the lock it holds for its lifetime is released.
+	if (navigator.locks) setInterval(() => {
+		if (!streams.size) return
+		navigator.locks.query().then(q => {
+			const live = new Set(q.held.map(l => l.name))
+			for (const [key, s] of [...streams]) {
+				if (!live.has(LOCK + s.page)) {
+					drop(key)
+					control({op: "remove", tab: key})
+				}
+			}
+		}, () => {})
+	}, 5000)
+
+	return {
+		handle(m, to) {
+			switch (m.t) {
+			case "open":
+				if (streams.has(m.key)) return
+				clearTimeout(idle)
+				streams.set(m.key, {page: m.page, to})
+				control({op: "add", tab: m.key, url: m.url, instance: m.instance})
+				return
+			case "close":
+				if (!streams.has(m.key)) return
+				drop(m.key)
+				control({op: "remove", tab: m.key})
+				return
+			case "bye":
+				for (const [key, s] of [...streams]) {
+					if (s.page !== m.page) continue
+					drop(key)
+					control({op: "remove", tab: key})
+				}
+			}
+		},
+		stop() {
+			const c = ac
+			if (c) c.abort()
+			lost()
+		},
+	}
+}
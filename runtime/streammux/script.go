@@ -0,0 +1,32 @@
+package streammux
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+var (
+	//go:embed leader.js
+	leaderJS string
+
+	//go:embed client.js
+	clientJS string
+
+	//go:embed worker.js
+	workerJS string
+)
+
+// Script is the script the page shell runs before Datastar, which routes
+// the streams of the page through the leader.
+var Script = "<script>(()=>{\n" + leaderJS + clientJS + "})()</script>"
+
+// worker is the script of the shared worker, the leader of the pages
+// connected to it.
+var worker = leaderJS + workerJS
+
+// ServeWorker serves the script of the shared worker.
+func ServeWorker(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write([]byte(worker))
+}
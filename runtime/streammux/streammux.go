@@ -0,0 +1,418 @@
+// Package streammux serves the SSE streams of all tabs of a browser over
+// one connection, see datapages.StreamsConfig.Shared.
+//
+// The page shell elects a leader among the tabs of an origin, a shared worker
+// or one of the tabs. The leader keeps the connection open, adds and removes
+// the streams of the tabs through control requests and routes what
+// the server writes on them to the tab the stream belongs to. Each stream is
+// served by the handler of its page like one opened by the tab itself.
+//
+// Application code must not import this package.
+package streammux
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/starfederation/datastar-go/datastar"
+
+	"github.com/romshark/datapages/runtime/reqlog"
+	"github.com/romshark/datapages/runtime/sharedsub"
+	"github.com/romshark/datapages/runtime/tabs"
+)
+
+const (
+	// Path is what the leader opens the connection on and posts
+	// its control requests to.
+	Path = "/_$/mux/"
+
+	// WorkerPath serves the script of the shared worker.
+	WorkerPath = "/_$/mux/worker/"
+
+	// Event is the type of the events the connection carries frames in.
+	Event datastar.EventType = "datapages-mux"
+
+	// MaxStreams bounds the streams a connection carries.
+	MaxStreams = 64
+
+	// maxControlBytes bounds the body of a control request. The URL of
+	// a stream carries the signals of its page in the query.
+	maxControlBytes = 64 << 10
+
+	// maxKeyLen bounds the key the leader identifies a stream by.
+	maxKeyLen = 64
+)
+
+// frame is the data of an [Event]. The first one of a connection carries its
+// ID only. Those of a stream carry its key and the head of its response,
+// a chunk of its body or its end, in that order. One with neither is
+// a heartbeat.
+type frame struct {
+	Conn   string `json:"conn,omitempty"`
+	Tab    string `json:"tab,omitempty"`
+	Status int    `json:"status,omitempty"`
+	Type   string `json:"type,omitempty"`
+	Data   string `json:"data,omitempty"`
+	End    bool   `json:"end,omitempty"`
+}
+
+// control is the body of a control request.
+type control struct {
+	// Conn is the ID of the connection, which only the leader holding it
+	// was told and which authorizes the request.
+	Conn string `json:"conn"`
+
+	// Op is "add" or "remove".
+	Op string `json:"op"`
+
+	// Tab is the key of the stream, unique within the connection.
+	Tab string `json:"tab"`
+
+	// URL is the path and query of the stream to add.
+	URL string `json:"url,omitempty"`
+
+	// Instance is the tab instance ID of a stateful page, see [tabs.Header].
+	Instance string `json:"instance,omitempty"`
+}
+
+// Mux serves the connections of leaders and the streams they carry.
+// It's safe for concurrent use.
+type Mux struct {
+	handler   http.Handler
+	heartbeat time.Duration
+	shutdown  <-chan struct{}
+
+	lock  sync.Mutex
+	conns map[string]*conn
+}
+
+// New returns a mux serving the streams the leaders add with handler.
+// A connection sends a heartbeat every heartbeat, none when zero.
+// Once shutdown is closed, no stream is added and a connection ends
+// once the streams it carries have drained.
+func New(
+	handler http.Handler, heartbeat time.Duration, shutdown <-chan struct{},
+) *Mux {
+	return &Mux{
+		handler:   handler,
+		heartbeat: heartbeat,
+		shutdown:  shutdown,
+		conns:     map[string]*conn{},
+	}
+}
+
+// conn is the connection of a leader.
+type conn struct {
+	id  string
+	ctx context.Context
+	sse *datastar.ServerSentEventGenerator
+	wg  sync.WaitGroup
+	// ended receives when a stream ended.
+	ended chan struct{}
+	// subs are the broker subscriptions the streams share.
+	subs sharedsub.Group
+
+	lock sync.Mutex
+	// closed is set once the connection is done, after which nothing
+	// is written to it.
+	closed  bool
+	streams map[string]context.CancelFunc
+}
+
+func (m *Mux) draining() bool {
+	select {
+	case <-m.shutdown:
+		return true
+	default:
+		return false
+	}
+}
+
+// ServeStream serves the connection of a leader.
+func (m *Mux) ServeStream(w http.ResponseWriter, r *http.Request) {
+	if m.draining() {
+		http.Error(w,
+			http.StatusText(http.StatusServiceUnavailable),
+			http.StatusServiceUnavailable)
+		return
+	}
+	c := &conn{
+		id:      tabs.NewID(),
+		ctx:     r.Context(),
+		ended:   make(chan struct{}, 1),
+		streams: map[string]context.CancelFunc{},
+	}
+	c.sse = datastar.NewSSE(w, r, datastar.WithCompression())
+
+	m.lock.Lock()
+	m.conns[c.id] = c
+	m.lock.Unlock()
+	defer func() {
+		m.lock.Lock()
+		delete(m.conns, c.id)
+		m.lock.Unlock()
+		// The handlers of the streams write to w, which they must be done
+		// with before this returns.
+		c.lock.Lock()
+		c.closed = true
+		for _, cancel := range c.streams {
+			cancel()
+		}
+		c.lock.Unlock()
+		c.wg.Wait()
+	}()
+
+	if err := c.send(frame{Conn: c.id}); err != nil {
+		return
+	}
+	var heartbeat <-chan time.Time
+	if m.heartbeat > 0 {
+		t := time.NewTicker(m.heartbeat)
+		defer t.Stop()
+		heartbeat = t.C
+	}
+	shutdown := m.shutdown
+	for {
+		select {
+		case <-heartbeat:
+			if c.send(frame{}) != nil {
+				return
+			}
+		case <-shutdown:
+			// The streams drain on their own, the connection with them.
+			shutdown = nil
+			if c.len() == 0 {
+				return
+			}
+		case <-c.ended:
+			if shutdown == nil && c.len() == 0 {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// ServeControl adds a stream to a connection or removes one from it.
+func (m *Mux) ServeControl(w http.ResponseWriter, r *http.Request) {
+	var ctl control
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxControlBytes)).
+		Decode(&ctl)
+	if err != nil || ctl.Tab == "" || len(ctl.Tab) > maxKeyLen {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	m.lock.Lock()
+	c := m.conns[ctl.Conn]
+	m.lock.Unlock()
+	if c == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	switch ctl.Op {
+	case "remove":
+		c.remove(ctl.Tab)
+		w.WriteHeader(http.StatusNoContent)
+	case "add":
+		u, ok := streamURL(ctl.URL)
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if m.draining() {
+			http.Error(w,
+				http.StatusText(http.StatusServiceUnavailable),
+				http.StatusServiceUnavailable)
+			return
+		}
+		if status := m.add(c, ctl.Tab, streamRequest(c, r, u, ctl.Instance)); status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	}
+}
+
+// streamURL returns the URL of the stream s names, a path ending
+// in a stream route and a query, and whether it is one.
+func streamURL(s string) (*url.URL, bool) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil ||
+		!strings.HasPrefix(u.Path, "/") || strings.HasPrefix(u.Path, "//") {
+		return nil, false
+	}
+	if !strings.HasSuffix(u.Path, "/_$/") && !strings.HasSuffix(u.Path, "/_$/anon/") {
+		return nil, false
+	}
+	return &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery}, true
+}
+
+// streamRequest returns the request of the stream at u the control request r
+// adds to c. It carries the cookies and the client of r, and ends with c
+// or when it's removed.
+func streamRequest(c *conn, r *http.Request, u *url.URL, instance string) *http.Request {
+	req := r.Clone(sharedsub.NewContext(c.ctx, &c.subs))
+	req.Method = http.MethodGet
+	req.URL = u
+	req.RequestURI = u.RequestURI()
+	req.Pattern = ""
+	req.Body = http.NoBody
+	req.ContentLength = 0
+	req.Header.Del("Content-Type")
+	req.Header.Del("Content-Length")
+	// The stream is written to the connection, which compresses it already.
+	req.Header.Del("Accept-Encoding")
+	req.Header.Del(reqlog.Header)
+	req.Header.Del(tabs.Header)
+	if instance != "" {
+		req.Header.Set(tabs.Header, instance)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Datastar-Request", "true")
+	return req
+}
+
+// add serves req as the stream key of c. It returns the status to refuse it
+// with, zero when it's added.
+func (m *Mux) add(c *conn, key string, req *http.Request) int {
+	ctx, cancel := context.WithCancel(req.Context())
+	c.lock.Lock()
+	switch {
+	case c.closed:
+		c.lock.Unlock()
+		cancel()
+		return http.StatusNotFound
+	case c.streams[key] != nil:
+		c.lock.Unlock()
+		cancel()
+		return http.StatusConflict
+	case len(c.streams) >= MaxStreams:
+		c.lock.Unlock()
+		cancel()
+		return http.StatusTooManyRequests
+	}
+	c.streams[key] = cancel
+	c.wg.Add(1)
+	c.lock.Unlock()
+
+	go func() {
+		defer c.wg.Done()
+		tw := &tabWriter{c: c, key: key, header: http.Header{}}
+		m.handler.ServeHTTP(tw, req.WithContext(ctx))
+		tw.end()
+		c.lock.Lock()
+		delete(c.streams, key)
+		c.lock.Unlock()
+		cancel()
+		select {
+		case c.ended <- struct{}{}:
+		default:
+		}
+	}()
+	return 0
+}
+
+// remove ends the stream key, the handler of which then returns.
+func (c *conn) remove(key string) {
+	c.lock.Lock()
+	cancel := c.streams[key]
+	c.lock.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+func (c *conn) len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.streams)
+}
+
+// send writes f to the connection. It's a no-op once the connection is done.
+func (c *conn) send(f frame) error {
+	c.lock.Lock()
+	closed := c.closed
+	c.lock.Unlock()
+	if closed {
+		return nil
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return c.sse.Send(Event, []string{string(b)})
+}
+
+// tabWriter writes the response of a stream to the connection:
+// its head once it's known, what was written each time it's flushed
+// and what's left with its end.
+type tabWriter struct {
+	c      *conn
+	key    string
+	header http.Header
+
+	lock   sync.Mutex
+	status int // Zero until the head is sent.
+	buf    []byte
+}
+
+func (w *tabWriter) Header() http.Header { return w.header }
+
+func (w *tabWriter) WriteHeader(code int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.writeHeader(code)
+}
+
+func (w *tabWriter) writeHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	_ = w.c.send(frame{
+		Tab:    w.key,
+		Status: code,
+		Type:   w.header.Get("Content-Type"),
+	})
+}
+
+func (w *tabWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.writeHeader(http.StatusOK)
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+func (w *tabWriter) Flush() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.writeHeader(http.StatusOK)
+	w.flush()
+}
+
+func (w *tabWriter) flush() {
+	if len(w.buf) == 0 {
+		return
+	}
+	_ = w.c.send(frame{Tab: w.key, Data: string(w.buf)})
+	w.buf = w.buf[:0]
+}
+
+// end sends what's left of the response and its end.
+func (w *tabWriter) end() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.writeHeader(http.StatusOK)
+	w.flush()
+	_ = w.c.send(frame{Tab: w.key, End: true})
+}
@@ -0,0 +1,299 @@
+package streammux_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/starfederation/datastar-go/datastar"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages/modules/messaging"
+	"github.com/romshark/datapages/modules/messaging/inmem"
+	"github.com/romshark/datapages/runtime/sharedsub"
+	"github.com/romshark/datapages/runtime/streammux"
+	"github.com/romshark/datapages/runtime/tabs"
+)
+
+type noMetrics struct{}
+
+func (noMetrics) OnPublish(string)   {}
+func (noMetrics) OnDeliveryDropped() {}
+
+type frame struct {
+	Conn   string `json:"conn"`
+	Tab    string `json:"tab"`
+	Status int    `json:"status"`
+	Type   string `json:"type"`
+	Data   string `json:"data"`
+	End    bool   `json:"end"`
+}
+
+type fixture struct {
+	srv        *httptest.Server
+	broker     messaging.Broker
+	subscribes atomic.Int32 // Subscriptions reaching the broker.
+	closed     chan string  // Receives the instance of each stream that ended.
+	shutdown   chan struct{}
+}
+
+// newFixture serves a mux whose streams patch what's published on "page"
+// until their request ends.
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	f := &fixture{
+		closed:   make(chan string, 8),
+		shutdown: make(chan struct{}),
+	}
+	b := inmem.New(messaging.DefaultBrokerChanBuffer)
+	t.Cleanup(func() { require.NoError(t, b.Close()) })
+	f.broker = messaging.Intercept(b, sharedsub.Interceptor(), messaging.Interceptor{
+		Subscribe: func(
+			ctx context.Context, subjects []string, next messaging.SubscribeFunc,
+		) (messaging.Subscription, error) {
+			f.subscribes.Add(1)
+			return next(ctx, subjects)
+		},
+	})
+
+	pages := http.NewServeMux()
+	pages.HandleFunc("GET /page/_$/{$}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Datastar-Request") != "true" {
+			http.Error(w, "not a datastar request", http.StatusNotAcceptable)
+			return
+		}
+		sub, err := f.broker.Subscribe(r.Context(), noMetrics{}, "page")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer func() {
+			sub.Close()
+			f.closed <- r.Header.Get(tabs.Header)
+		}()
+		sse := datastar.NewSSE(w, r)
+		for {
+			select {
+			case msg, ok := <-sub.C():
+				if !ok {
+					return
+				}
+				_ = sse.PatchElements(string(msg.Data))
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+
+	m := streammux.New(pages, 0, f.shutdown)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+streammux.Path+"{$}", m.ServeStream)
+	mux.HandleFunc("POST "+streammux.Path+"{$}", m.ServeControl)
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+// open opens a connection and returns its ID and its frames.
+func (f *fixture) open(t *testing.T) (string, <-chan frame) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.srv.URL+streammux.Path, nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	frames := make(chan frame, 64)
+	go func() {
+		defer close(frames)
+		defer resp.Body.Close()
+		s := bufio.NewScanner(resp.Body)
+		var event string
+		for s.Scan() {
+			line := s.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: ") && event == string(streammux.Event):
+				var fr frame
+				if json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &fr) == nil {
+					frames <- fr
+				}
+			}
+		}
+	}()
+	first := next(t, frames)
+	require.NotEmpty(t, first.Conn)
+	return first.Conn, frames
+}
+
+func next(t *testing.T, frames <-chan frame) frame {
+	t.Helper()
+	select {
+	case fr, ok := <-frames:
+		require.True(t, ok, "the connection ended")
+		return fr
+	case <-time.After(2 * time.Second):
+		t.Fatal("no frame arrived")
+		return frame{}
+	}
+}
+
+func (f *fixture) control(t *testing.T, body map[string]string) int {
+	t.Helper()
+	b, err := json.Marshal(body)
+	require.NoError(t, err)
+	resp, err := http.Post(f.srv.URL+streammux.Path, "application/json", bytes.NewReader(b))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	return resp.StatusCode
+}
+
+func (f *fixture) publish(t *testing.T, data string) {
+	t.Helper()
+	require.NoError(t, f.broker.Publish(context.Background(), noMetrics{}, "page", []byte(data)))
+}
+
+func TestStreams(t *testing.T) {
+	t.Parallel()
+
+	f := newFixture(t)
+	conn, frames := f.open(t)
+	instance := tabs.NewID()
+
+	for _, tab := range []string{"a", "b"} {
+		require.Equal(t, http.StatusNoContent, f.control(t, map[string]string{
+			"conn": conn, "op": "add", "tab": tab,
+			"url": "/page/_$/?datastar=%7B%7D", "instance": instance,
+		}))
+	}
+	heads := map[string]frame{}
+	for range 2 {
+		fr := next(t, frames)
+		heads[fr.Tab] = fr
+	}
+	for _, tab := range []string{"a", "b"} {
+		require.Equal(t, http.StatusOK, heads[tab].Status, tab)
+		require.Equal(t, "text/event-stream", heads[tab].Type, tab)
+	}
+	// The streams subscribing to the same subjects share a subscription.
+	require.Equal(t, int32(1), f.subscribes.Load())
+
+	f.publish(t, `<div id="x">1</div>`)
+	got := map[string]string{}
+	for range 2 {
+		fr := next(t, frames)
+		got[fr.Tab] = fr.Data
+	}
+	for _, tab := range []string{"a", "b"} {
+		require.Contains(t, got[tab], "event: datastar-patch-elements\n", tab)
+		require.Contains(t, got[tab], `data: elements <div id="x">1</div>`, tab)
+		require.True(t, strings.HasSuffix(got[tab], "\n\n"), tab)
+	}
+
+	require.Equal(t, http.StatusNoContent, f.control(t, map[string]string{
+		"conn": conn, "op": "remove", "tab": "a",
+	}))
+	require.Equal(t, frame{Tab: "a", End: true}, next(t, frames))
+	select {
+	case got := <-f.closed:
+		require.Equal(t, instance, got)
+	case <-time.After(time.Second):
+		t.Fatal("the stream didn't end")
+	}
+
+	// The other stream keeps the subscription.
+	f.publish(t, `<div id="x">2</div>`)
+	fr := next(t, frames)
+	require.Equal(t, "b", fr.Tab)
+	require.Contains(t, fr.Data, `<div id="x">2</div>`)
+}
+
+func TestControlRefused(t *testing.T) {
+	t.Parallel()
+
+	f := newFixture(t)
+	conn, frames := f.open(t)
+	add := func(conn, tab, url string) map[string]string {
+		return map[string]string{"conn": conn, "op": "add", "tab": tab, "url": url}
+	}
+
+	require.Equal(t, http.StatusNotFound,
+		f.control(t, add("unknown", "a", "/page/_$/")))
+	for _, url := range []string{
+		"", "/page/", "page/_$/", "//evil.example/_$/", "https://evil.example/_$/",
+	} {
+		require.Equal(t, http.StatusBadRequest, f.control(t, add(conn, "a", url)), url)
+	}
+	require.Equal(t, http.StatusBadRequest, f.control(t, add(conn, "", "/page/_$/")))
+	require.Equal(t, http.StatusBadRequest, f.control(t, map[string]string{
+		"conn": conn, "op": "replace", "tab": "a",
+	}))
+
+	require.Equal(t, http.StatusNoContent, f.control(t, add(conn, "a", "/page/_$/")))
+	require.Equal(t, http.StatusConflict, f.control(t, add(conn, "a", "/page/_$/")))
+	require.Equal(t, http.StatusOK, next(t, frames).Status)
+
+	// A stream the page refuses ends with the response it's refused with.
+	require.Equal(t, http.StatusNoContent, f.control(t, add(conn, "b", "/other/_$/")))
+	head := next(t, frames)
+	require.Equal(t, "b", head.Tab)
+	require.Equal(t, http.StatusNotFound, head.Status)
+	require.Contains(t, next(t, frames).Data, "404 page not found")
+	require.Equal(t, frame{Tab: "b", End: true}, next(t, frames))
+}
+
+func TestShutdown(t *testing.T) {
+	t.Parallel()
+
+	f := newFixture(t)
+	conn, frames := f.open(t)
+	require.Equal(t, http.StatusNoContent, f.control(t, map[string]string{
+		"conn": conn, "op": "add", "tab": "a", "url": "/page/_$/",
+	}))
+	require.Equal(t, http.StatusOK, next(t, frames).Status)
+
+	close(f.shutdown)
+	require.Equal(t, http.StatusServiceUnavailable, f.control(t, map[string]string{
+		"conn": conn, "op": "add", "tab": "b", "url": "/page/_$/",
+	}))
+
+	// The connection ends once its last stream did.
+	require.Equal(t, http.StatusNoContent, f.control(t, map[string]string{
+		"conn": conn, "op": "remove", "tab": "a",
+	}))
+	require.Equal(t, frame{Tab: "a", End: true}, next(t, frames))
+	select {
+	case _, ok := <-frames:
+		require.False(t, ok, "unexpected frame")
+	case <-time.After(2 * time.Second):
+		t.Fatal("the connection didn't end")
+	}
+}
+
+func TestScript(t *testing.T) {
+	t.Parallel()
+
+	require.True(t, strings.HasPrefix(streammux.Script, "<script>"))
+	require.Contains(t, streammux.Script, `"`+streammux.Path+`"`)
+	require.Contains(t, streammux.Script, `"`+streammux.WorkerPath+`"`)
+	require.Contains(t, streammux.Script, `"`+string(streammux.Event)+`"`)
+	require.Contains(t, streammux.Script, `"`+tabs.Header+`"`)
+	require.Equal(t, 1, strings.Count(streammux.Script, "</script>"))
+
+	w := httptest.NewRecorder()
+	streammux.ServeWorker(w, httptest.NewRequest(http.MethodGet, streammux.WorkerPath, nil))
+	require.Equal(t, "text/javascript; charset=utf-8", w.Header().Get("Content-Type"))
+	require.Contains(t, w.Body.String(), "onconnect")
+	require.Contains(t, w.Body.String(), `"`+streammux.Path+`"`)
+}
@@ -0,0 +1,6 @@
+// The shared worker leading the pages connected to it.
+const leader = dpMuxLeader((port, m) => port.postMessage(m))
+onconnect = e => {
+	const port = e.ports[0]
+	port.onmessage = m => leader.handle(m.data, port)
+}
@@ -26,3 +26,41 @@ func IsToken(v string) bool {
 // Prefix returns the subject prefix an event with subject fields publishes
 // under. "messaging.sent" becomes "messaging.sent.".
 func Prefix(s string) string { return s + Sep }
+
+// IsNamespace reports whether ns may be put in front of every subject:
+// one or more tokens joined by Sep.
+func IsNamespace(ns string) bool {
+	for tok := range strings.SplitSeq(ns, Sep) {
+		if !IsToken(tok) {
+			return false
+		}
+	}
+	return true
+}
+
+// InNamespace returns subjects placed under ns, which is either empty or what
+// Prefix returned for a namespace. An empty ns returns subjects itself.
+func InNamespace(ns string, subjects []string) []string {
+	if ns == "" {
+		return subjects
+	}
+	out := make([]string, len(subjects))
+	for i, s := range subjects {
+		out[i] = ns + s
+	}
+	return out
+}
+
+// Values returns the n tokens s carries after prefix, which is what Prefix
+// returned. It reports false for a subject of another shape.
+func Values(s, prefix string, n int) ([]string, bool) {
+	rest, ok := strings.CutPrefix(s, prefix)
+	if !ok {
+		return nil, false
+	}
+	v := strings.Split(rest, Sep)
+	if len(v) != n {
+		return nil, false
+	}
+	return v, true
+}
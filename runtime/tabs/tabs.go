@@ -0,0 +1,180 @@
+// Package tabs identifies the browser tabs of stateful pages and keeps
+// their state in a tabstate.Store, see datapages.TabState.
+//
+// Application code must not import this package.
+package tabs
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/romshark/datapages/modules/tabstate"
+)
+
+// Header is the HTTP header a stateful page is answered with the ID of its
+// tab in, and that its stream and actions send the ID back in.
+const Header = "Datapages-Instance"
+
+// DefaultTTL is how long the state of a tab outlives its stream
+// when the server configures no TTL of its own.
+const DefaultTTL = 30 * time.Second
+
+// idLen is the length of an ID, 16 random bytes in unpadded base64url.
+const idLen = 22
+
+// maxAttempts bounds the attempts of an update losing to concurrent ones.
+const maxAttempts = 16
+
+// ErrInvalidID is returned by [FromRequest] for a header that carries
+// no ID made by [NewID].
+var ErrInvalidID = errors.New("invalid tab instance ID")
+
+// NewID returns a random tab ID.
+func NewID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:]) // Never fails.
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+// FromRequest returns the tab ID r carries in [Header], "" without one.
+func FromRequest(r *http.Request) (string, error) {
+	id := r.Header.Get(Header)
+	if id == "" {
+		return "", nil
+	}
+	if len(id) != idLen {
+		return "", ErrInvalidID
+	}
+	if _, err := base64.RawURLEncoding.DecodeString(id); err != nil {
+		return "", ErrInvalidID
+	}
+	return id, nil
+}
+
+type ctxKey struct{}
+
+// NewRerenderContext returns ctx for the GET re-rendering the page of the
+// stream of the tab id, which the stream read from its request already.
+// The GET renders the page of that tab instead of a new one's.
+func NewRerenderContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// RerenderID returns the ID of the tab a GET re-renders the page of,
+// false for a page load, which is a new tab.
+func RerenderID(ctx context.Context) (id string, ok bool) {
+	id, ok = ctx.Value(ctxKey{}).(string)
+	return id, ok
+}
+
+// Tab is the state of one tab in a store.
+type Tab struct {
+	Store tabstate.Store
+	ID    string
+
+	// User is the user ID of the session the tab belongs to, empty for
+	// a guest. The state is kept apart by user, the ID of a tab reaching
+	// the server with another session finds none.
+	User string
+
+	// TTL is how long the state outlives the stream of the tab,
+	// or the last update of a tab that has none.
+	TTL time.Duration
+}
+
+// Load returns the state of the tab, nil when it has none.
+func (t Tab) Load(ctx context.Context) ([]byte, error) {
+	rec, _, ok, err := t.Store.Load(ctx, t.key())
+	if err != nil || !ok {
+		return nil, err
+	}
+	return rec.State, nil
+}
+
+// Update replaces the state of the tab with what fn returns for the current
+// one, nil when it has none. fn is called again when another update came
+// first. The state of a tab without a stream expires after TTL from now.
+func (t Tab) Update(
+	ctx context.Context, fn func(state []byte) ([]byte, error),
+) error {
+	return t.modify(ctx, func(rec *tabstate.Record) (bool, error) {
+		state, err := fn(rec.State)
+		if err != nil {
+			return false, err
+		}
+		rec.State = state
+		if rec.Owner == "" {
+			rec.ExpiresAt = time.Now().Add(t.TTL)
+		}
+		return true, nil
+	})
+}
+
+// Keep keeps the state of the tab from expiring while the stream it returns
+// the owner ID of is open, creating an empty one when the tab has none.
+func (t Tab) Keep(ctx context.Context) (owner string, err error) {
+	owner = NewID()
+	err = t.modify(ctx, func(rec *tabstate.Record) (bool, error) {
+		rec.ExpiresAt, rec.Owner = time.Time{}, owner
+		return true, nil
+	})
+	return owner, err
+}
+
+// Release lets the state of the tab expire after TTL once the stream
+// of owner closed. It's a no-op when another stream took over the tab.
+func (t Tab) Release(ctx context.Context, owner string) error {
+	return t.modify(ctx, func(rec *tabstate.Record) (bool, error) {
+		if rec.Owner != owner {
+			return false, nil
+		}
+		rec.ExpiresAt, rec.Owner = time.Now().Add(t.TTL), ""
+		return true, nil
+	})
+}
+
+// key is what the state of the tab is stored under, the ID followed by
+// the hash of the user, which keeps the key valid whatever the user ID holds.
+func (t Tab) key() string {
+	if t.User == "" {
+		return t.ID
+	}
+	h := sha256.Sum256([]byte(t.User))
+	return t.ID + "." + base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// modify applies fn to the record of the tab, an empty one when it has none,
+// and saves it unless fn reports no change. It starts over when another
+// change came first.
+func (t Tab) modify(
+	ctx context.Context, fn func(rec *tabstate.Record) (changed bool, err error),
+) error {
+	for range maxAttempts {
+		rec, revision, ok, err := t.Store.Load(ctx, t.key())
+		if err != nil {
+			return fmt.Errorf("loading tab state: %w", err)
+		}
+		if !ok {
+			rec, revision = tabstate.Record{}, 0
+		}
+		changed, err := fn(&rec)
+		if err != nil || !changed {
+			return err
+		}
+		_, err = t.Store.Save(ctx, t.key(), rec, revision)
+		if errors.Is(err, tabstate.ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("saving tab state: %w", err)
+		}
+		return nil
+	}
+	return tabstate.ErrConflict
+}
@@ -0,0 +1,165 @@
+package tabs_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/romshark/datapages/modules/tabstate"
+	"github.com/romshark/datapages/modules/tabstate/inmem"
+	"github.com/romshark/datapages/runtime/tabs"
+)
+
+func TestFromRequest(t *testing.T) {
+	id := tabs.NewID()
+	require.NotEqual(t, id, tabs.NewID())
+
+	for _, tc := range []struct {
+		name, header, want string
+		err                error
+	}{
+		{name: "none"},
+		{name: "valid", header: id, want: id},
+		{name: "too short", header: id[1:], err: tabs.ErrInvalidID},
+		{name: "not base64url", header: "!" + id[1:], err: tabs.ErrInvalidID},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tc.header != "" {
+				r.Header.Set(tabs.Header, tc.header)
+			}
+			got, err := tabs.FromRequest(r)
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func load(t *testing.T, s tabstate.Store, id string) (tabstate.Record, bool) {
+	t.Helper()
+	rec, _, ok, err := s.Load(context.Background(), id)
+	require.NoError(t, err)
+	return rec, ok
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	s := inmem.New()
+	tab := tabs.Tab{Store: s, ID: tabs.NewID(), TTL: time.Minute}
+
+	state, err := tab.Load(ctx)
+	require.NoError(t, err)
+	require.Nil(t, state)
+
+	require.NoError(t, tab.Update(ctx, func(state []byte) ([]byte, error) {
+		require.Nil(t, state)
+		return []byte(`1`), nil
+	}))
+	rec, ok := load(t, s, tab.ID)
+	require.True(t, ok)
+	require.Equal(t, []byte(`1`), rec.State)
+	require.False(t, rec.ExpiresAt.IsZero(), "a tab without a stream expires")
+
+	errFailed := errors.New("failed")
+	err = tab.Update(ctx, func([]byte) ([]byte, error) { return nil, errFailed })
+	require.ErrorIs(t, err, errFailed)
+	state, err = tab.Load(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte(`1`), state, "a failed update changes nothing")
+}
+
+// TestUserKeepsTabsApart covers the ID of a tab used with another session,
+// which finds none of its state.
+func TestUserKeepsTabsApart(t *testing.T) {
+	ctx := context.Background()
+	s := inmem.New()
+	id := tabs.NewID()
+	alice := tabs.Tab{Store: s, ID: id, User: "alice", TTL: time.Minute}
+	require.NoError(t, alice.Update(ctx, func([]byte) ([]byte, error) {
+		return []byte(`1`), nil
+	}))
+
+	for _, other := range []string{"", "bob"} {
+		tab := tabs.Tab{Store: s, ID: id, User: other, TTL: time.Minute}
+		state, err := tab.Load(ctx)
+		require.NoError(t, err)
+		require.Nil(t, state, "user %q read the state of alice", other)
+		require.NoError(t, tab.Update(ctx, func([]byte) ([]byte, error) {
+			return []byte(`2`), nil
+		}))
+	}
+
+	state, err := alice.Load(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte(`1`), state, "another user changed the state")
+}
+
+// TestUpdateConflict covers an update that loses to another one
+// and is applied again to what that one left.
+func TestUpdateConflict(t *testing.T) {
+	ctx := context.Background()
+	tab := tabs.Tab{Store: inmem.New(), ID: tabs.NewID(), TTL: time.Minute}
+
+	calls := 0
+	require.NoError(t, tab.Update(ctx, func(state []byte) ([]byte, error) {
+		calls++
+		if calls == 1 {
+			require.NoError(t, tab.Update(ctx, func([]byte) ([]byte, error) {
+				return []byte(`other`), nil
+			}))
+		}
+		return append(state, '!'), nil
+	}))
+	require.Equal(t, 2, calls)
+	state, err := tab.Load(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte(`other!`), state)
+}
+
+func TestKeepRelease(t *testing.T) {
+	ctx := context.Background()
+	s := inmem.New()
+	tab := tabs.Tab{Store: s, ID: tabs.NewID(), TTL: time.Minute}
+
+	first, err := tab.Keep(ctx)
+	require.NoError(t, err)
+	rec, ok := load(t, s, tab.ID)
+	require.True(t, ok, "keeping a tab creates its record")
+	require.True(t, rec.ExpiresAt.IsZero())
+
+	require.NoError(t, tab.Update(ctx, func([]byte) ([]byte, error) {
+		return []byte(`1`), nil
+	}))
+	rec, _ = load(t, s, tab.ID)
+	require.True(t, rec.ExpiresAt.IsZero(), "an update keeps a kept tab")
+
+	// The tab reconnects before the server noticed the first stream is gone.
+	second, err := tab.Keep(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tab.Release(ctx, first))
+	rec, _ = load(t, s, tab.ID)
+	require.True(t, rec.ExpiresAt.IsZero(), "the old stream doesn't release the tab")
+
+	require.NoError(t, tab.Release(ctx, second))
+	rec, ok = load(t, s, tab.ID)
+	require.True(t, ok)
+	require.Equal(t, []byte(`1`), rec.State)
+	require.WithinDuration(t, time.Now().Add(time.Minute), rec.ExpiresAt, 5*time.Second)
+}
+
+func TestReleaseExpires(t *testing.T) {
+	ctx := context.Background()
+	s := inmem.New()
+	tab := tabs.Tab{Store: s, ID: tabs.NewID(), TTL: 10 * time.Millisecond}
+
+	owner, err := tab.Keep(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tab.Release(ctx, owner))
+	time.Sleep(20 * time.Millisecond)
+	_, ok := load(t, s, tab.ID)
+	require.False(t, ok, "the state expired after the TTL")
+}
@@ -0,0 +1,121 @@
+// Package tracing starts the OpenTelemetry spans of a generated server and
+// carries their context across the message broker.
+//
+// A server without datapages.WithTracerProvider has a nil tracer. Every
+// function takes that as "don't trace" and costs next to nothing then.
+//
+// Application code must not import this package.
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/romshark/datapages/modules/messaging"
+)
+
+// TracerName is the instrumentation scope of the spans of a generated server.
+const TracerName = "github.com/romshark/datapages"
+
+// propagator carries the span context in the W3C traceparent
+// and tracestate headers of a message.
+var propagator = propagation.TraceContext{}
+
+// noopSpan is what the functions return when there's no tracer.
+var noopSpan = trace.SpanFromContext(context.Background())
+
+// Tracer is what a generated server starts its spans with.
+// Nil traces nothing.
+type Tracer = trace.Tracer
+
+// NewTracer returns the tracer of tp, or nil when tp is nil.
+func NewTracer(tp trace.TracerProvider) Tracer {
+	if tp == nil {
+		return nil
+	}
+	return tp.Tracer(TracerName)
+}
+
+// StartRequest starts the server span of the handler name serving r.
+// It returns r with the span in its context, which is what the handler
+// passes on, so what it dispatches links to the span.
+// With a nil t it returns r as is.
+func StartRequest(t trace.Tracer, r *http.Request, name string) (*http.Request, trace.Span) {
+	if t == nil {
+		return r, noopSpan
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("http.request.method", r.Method),
+		attribute.String("url.path", r.URL.Path),
+	}
+	if r.Pattern != "" {
+		attrs = append(attrs, attribute.String("http.route", r.Pattern))
+	}
+	ctx, span := t.Start(r.Context(), name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...))
+	return r.WithContext(ctx), span
+}
+
+// StartEvent starts the consumer span of the event handler name receiving msg.
+// A stream receives the message long after the request that published it
+// has ended, so the span is the root of a trace of its own. It links to the
+// span the message was published in when msg carries one in its header.
+// It returns ctx with the span in it, which is what the handler is passed,
+// so what it dispatches links to the span.
+// With a nil t it returns ctx as is and a span that records nothing.
+func StartEvent(
+	t trace.Tracer, ctx context.Context, name string, msg messaging.Message,
+) (context.Context, trace.Span) {
+	if t == nil {
+		return ctx, noopSpan
+	}
+	opts := []trace.SpanStartOption{
+		trace.WithNewRoot(),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.destination.name", msg.Subject),
+		),
+	}
+	if msg.Header != nil {
+		remote := trace.SpanContextFromContext(
+			propagator.Extract(ctx, propagation.MapCarrier(msg.Header)),
+		)
+		if remote.IsValid() {
+			opts = append(opts, trace.WithLinks(trace.Link{SpanContext: remote}))
+		}
+	}
+	return t.Start(ctx, name, opts...)
+}
+
+// Fail marks span as failed with err.
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// FailRequest marks the span in the context of r as failed with err.
+// It does nothing when r isn't traced.
+func FailRequest(r *http.Request, err error) {
+	if span := trace.SpanFromContext(r.Context()); span.IsRecording() {
+		Fail(span, err)
+	}
+}
+
+// Header returns header with the span context of ctx added, creating it
+// when nil. It returns header as is when ctx carries no span.
+func Header(ctx context.Context, header messaging.Header) messaging.Header {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return header
+	}
+	if header == nil {
+		header = make(messaging.Header, 2)
+	}
+	propagator.Inject(ctx, propagation.MapCarrier(header))
+	return header
+}
@@ -0,0 +1,132 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/romshark/datapages/modules/messaging"
+	"github.com/romshark/datapages/runtime/tracing"
+)
+
+func newTracer(t *testing.T) (tracing.Tracer, *tracetest.SpanRecorder) {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	t.Cleanup(func() { require.NoError(t, tp.Shutdown(context.Background())) })
+	return tracing.NewTracer(tp), rec
+}
+
+func TestNilTracer(t *testing.T) {
+	t.Parallel()
+
+	require.Nil(t, tracing.NewTracer(nil))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r2, span := tracing.StartRequest(nil, r, "PageIndex.GET")
+	require.Same(t, r, r2)
+	require.False(t, span.IsRecording())
+	tracing.FailRequest(r2, errors.New("boom")) // No-op.
+	span.End()
+
+	ctx, span := tracing.StartEvent(nil, context.Background(), "PageIndex.OnTick",
+		messaging.Message{Subject: "tick"})
+	require.Equal(t, context.Background(), ctx)
+	require.False(t, span.IsRecording())
+	require.Nil(t, tracing.Header(context.Background(), nil))
+}
+
+func TestStartRequest(t *testing.T) {
+	t.Parallel()
+	tracer, rec := newTracer(t)
+
+	r := httptest.NewRequest("POST", "/note", nil)
+	r.Pattern = "POST /note"
+	r, span := tracing.StartRequest(tracer, r, "PageIndex.POSTNote")
+	require.Equal(t, span.SpanContext(), trace.SpanContextFromContext(r.Context()))
+	tracing.FailRequest(r, errors.New("boom"))
+	span.End()
+
+	ended := rec.Ended()
+	require.Len(t, ended, 1)
+	s := ended[0]
+	require.Equal(t, "PageIndex.POSTNote", s.Name())
+	require.Equal(t, trace.SpanKindServer, s.SpanKind())
+	require.ElementsMatch(t, []attribute.KeyValue{
+		attribute.String("http.request.method", "POST"),
+		attribute.String("url.path", "/note"),
+		attribute.String("http.route", "POST /note"),
+	}, s.Attributes())
+	require.Equal(t, codes.Error, s.Status().Code)
+	require.Equal(t, "boom", s.Status().Description)
+	require.Len(t, s.Events(), 1, "the error is recorded")
+}
+
+// TestEventLinksToDispatch covers the way of the span context from the
+// action dispatching an event to the event handler receiving it.
+func TestEventLinksToDispatch(t *testing.T) {
+	t.Parallel()
+	tracer, rec := newTracer(t)
+
+	ctx, action := tracer.Start(context.Background(), "PageIndex.POSTNote")
+	msg := messaging.Message{
+		Subject: "note",
+		Header:  tracing.Header(ctx, messaging.Header{"x": "y"}),
+	}
+	action.End()
+	require.Equal(t, "y", msg.Header["x"], "the header is added to")
+
+	// The stream's context carries a span of its own, which the
+	// event span must not become a child of.
+	streamCtx, stream := tracer.Start(context.Background(), "stream")
+	defer stream.End()
+	ctx, span := tracing.StartEvent(tracer, streamCtx, "PageIndex.OnNote", msg)
+	require.Equal(t, span.SpanContext(), trace.SpanContextFromContext(ctx))
+
+	// What the event handler dispatches links to its span.
+	next := tracing.Header(ctx, nil)
+	_, nextSpan := tracing.StartEvent(tracer, context.Background(),
+		"PageIndex.OnNext", messaging.Message{Subject: "next", Header: next})
+	nextSpan.End()
+	tracing.Fail(span, errors.New("boom"))
+	span.End()
+
+	ended := rec.Ended()
+	require.Len(t, ended, 3)
+	require.Equal(t, "PageIndex.OnNext", ended[1].Name())
+	require.Len(t, ended[1].Links(), 1)
+	require.Equal(t, span.SpanContext().SpanID(), ended[1].Links()[0].SpanContext.SpanID())
+	s := ended[2]
+	require.Equal(t, "PageIndex.OnNote", s.Name())
+	require.Equal(t, trace.SpanKindConsumer, s.SpanKind())
+	require.False(t, s.Parent().IsValid(), "a root span")
+	require.NotEqual(t, stream.SpanContext().TraceID(), s.SpanContext().TraceID())
+	require.Len(t, s.Links(), 1)
+	require.Equal(t, action.SpanContext().TraceID(), s.Links()[0].SpanContext.TraceID())
+	require.Equal(t, action.SpanContext().SpanID(), s.Links()[0].SpanContext.SpanID())
+	require.Equal(t, []attribute.KeyValue{
+		attribute.String("messaging.destination.name", "note"),
+	}, s.Attributes())
+	require.Equal(t, codes.Error, s.Status().Code)
+}
+
+func TestEventWithoutHeader(t *testing.T) {
+	t.Parallel()
+	tracer, rec := newTracer(t)
+
+	_, span := tracing.StartEvent(tracer, context.Background(), "PageIndex.OnNote",
+		messaging.Message{Subject: "note", Header: messaging.Header{"x": "y"}})
+	span.End()
+
+	ended := rec.Ended()
+	require.Len(t, ended, 1)
+	require.Empty(t, ended[0].Links())
+}
@@ -8,8 +8,10 @@ import (
 	"os"
 	"reflect"
 
+	"github.com/romshark/datapages/modules/codec"
 	"github.com/romshark/datapages/modules/messaging"
 	"github.com/romshark/datapages/modules/sessions"
+	"github.com/romshark/datapages/runtime/sharedsub"
 )
 
 // IsDevMode returns true when in the development environment.
@@ -25,13 +27,18 @@ type DisableSessions struct{}
 // [NewServer] require [WithPrometheus].
 type EnablePrometheus struct{}
 
-// DisablePrometheus disables Prometheus code generation and hence makes
-// [NewServer] reject [WithPrometheus].
+// EnableOTelMetrics enables OpenTelemetry metrics code generation and hence
+// makes [NewServer] require [WithMeterProvider]. The server records what
+// [EnablePrometheus] would count, without serving a scrape endpoint.
+type EnableOTelMetrics struct{}
+
+// DisablePrometheus disables metrics code generation and hence makes
+// [NewServer] reject [WithPrometheus] and [WithMeterProvider].
 type DisablePrometheus struct{}
 
 // MetricsMode constrains the Metrics type argument of [NewServer].
 type MetricsMode interface {
-	EnablePrometheus | DisablePrometheus
+	EnablePrometheus | EnableOTelMetrics | DisablePrometheus
 }
 
 // Server serves a generated application returned by [NewServer].
@@ -67,7 +74,8 @@ type ServerInitializer[App, SessionData any] interface {
 // NewServer creates the server S generated for application App.
 // S is the Server type of the generated package, SessionData is the session
 // payload type the application declares or [DisableSessions] when it declares
-// none, Metrics is [EnablePrometheus] or [DisablePrometheus]:
+// none, Metrics is [EnablePrometheus], [EnableOTelMetrics] or
+// [DisablePrometheus]:
 //
 //	s, err := datapages.NewServer[
 //		app.App,
@@ -103,6 +111,16 @@ func NewServer[App, SessionData any, Metrics MetricsMode, S any, PS interface {
 			return nil, fmt.Errorf("applying server option: %w", err)
 		}
 	}
+	if cfg.EventCodec == nil {
+		cfg.EventCodec = codec.JSON{}
+	}
+	interceptors := cfg.BrokerInterceptors
+	if cfg.Streams.Shared {
+		interceptors = append(
+			[]messaging.Interceptor{sharedsub.Interceptor()}, interceptors...,
+		)
+	}
+	broker = messaging.Intercept(broker, interceptors...)
 	sessions, err := asSessionManager[SessionData](cfg.sessionManager)
 	if err != nil {
 		return nil, err
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -102,6 +103,79 @@ func TestNewServer(t *testing.T) {
 	require.Equal(t, "/ds.js", srv.cfg.DatastarJS)
 }
 
+// TestNewServerHealth covers the probes of a server without a metrics server.
+func TestNewServerHealth(t *testing.T) {
+	s, err := datapages.NewServer[
+		testApp,
+		datapages.DisableSessions,
+		datapages.DisablePrometheus,
+		testServer,
+	](
+		new(testApp), inmem.New(1),
+		datapages.WithHealth(datapages.HealthConfig{Host: ":8081"}),
+	)
+	require.NoError(t, err)
+	srv, ok := s.(*testServer)
+	require.True(t, ok)
+	require.NotNil(t, srv.cfg.Health)
+	require.Equal(t, ":8081", srv.cfg.HealthServer.Addr)
+	require.Nil(t, srv.cfg.MetricsServer)
+}
+
+// TestNewServerSubjectPrefix covers that an empty subject prefix
+// selects none instead of failing.
+func TestNewServerSubjectPrefix(t *testing.T) {
+	for prefix, want := range map[string]string{
+		"shop.staging": "shop.staging",
+		"":             "",
+	} {
+		s, err := datapages.NewServer[
+			testApp,
+			datapages.DisableSessions,
+			datapages.DisablePrometheus,
+			testServer,
+		](
+			new(testApp), inmem.New(1),
+			datapages.WithSubjectPrefix("staging"),
+			datapages.WithSubjectPrefix(prefix),
+		)
+		require.NoError(t, err, prefix)
+		srv, ok := s.(*testServer)
+		require.True(t, ok)
+		require.Equal(t, want, srv.cfg.SubjectPrefix, prefix)
+	}
+}
+
+func TestNewServerBrokerInterceptors(t *testing.T) {
+	var published []string
+	record := messaging.Interceptor{
+		Publish: func(
+			ctx context.Context, msg messaging.Message, next messaging.PublishFunc,
+		) error {
+			published = append(published, msg.Subject)
+			return next(ctx, msg)
+		},
+	}
+	broker := inmem.New(1)
+	s, err := datapages.NewServer[
+		testApp,
+		datapages.DisableSessions,
+		datapages.DisablePrometheus,
+		testServer,
+	](
+		new(testApp), broker, datapages.WithBrokerInterceptors(record),
+	)
+	require.NoError(t, err)
+	srv, ok := s.(*testServer)
+	require.True(t, ok)
+	require.NotEqual(t, broker, srv.broker, "the broker wasn't intercepted")
+
+	require.NoError(t, srv.broker.Publish(
+		context.Background(), noMetrics{}, "subject", nil,
+	))
+	require.Equal(t, []string{"subject"}, published)
+}
+
 func TestNewServerSessions(t *testing.T) {
 	m := new(testSessionManager)
 	s, err := datapages.NewServer[
@@ -160,6 +234,121 @@ func TestNewServerErr(t *testing.T) {
 			](app, broker, datapages.WithMiddleware(nil))
 			return err
 		}, "applying server option: WithMiddleware: nil middleware at index 0"},
+		"nil event codec": {func() error {
+			_, err := datapages.NewServer[
+				testApp,
+				datapages.DisableSessions,
+				datapages.DisablePrometheus,
+				testServer,
+			](app, broker, datapages.WithEventCodec(nil))
+			return err
+		}, "applying server option: WithEventCodec: nil codec"},
+		"invalid subject prefix": {func() error {
+			_, err := datapages.NewServer[
+				testApp,
+				datapages.DisableSessions,
+				datapages.DisablePrometheus,
+				testServer,
+			](app, broker, datapages.WithSubjectPrefix("staging."))
+			return err
+		}, `applying server option: WithSubjectPrefix: invalid prefix: "staging."`},
+		"nil tracer provider": {func() error {
+			_, err := datapages.NewServer[
+				testApp,
+				datapages.DisableSessions,
+				datapages.DisablePrometheus,
+				testServer,
+			](app, broker, datapages.WithTracerProvider(nil))
+			return err
+		}, "applying server option: WithTracerProvider: nil tracer provider"},
+		"nil meter provider": {func() error {
+			_, err := datapages.NewServer[
+				testApp,
+				datapages.DisableSessions,
+				datapages.EnableOTelMetrics,
+				testServer,
+			](app, broker, datapages.WithMeterProvider(nil))
+			return err
+		}, "applying server option: WithMeterProvider: nil meter provider"},
+		"unnamed readiness check": {func() error {
+			_, err := datapages.NewServer[
+				testApp,
+				datapages.DisableSessions,
+				datapages.EnablePrometheus,
+				testServer,
+			](app, broker, datapages.WithPrometheus(datapages.PrometheusConfig{
+				Host: "127.0.0.1:0",
+				ReadinessChecks: []datapages.ReadinessCheck{{
+					Check: func(context.Context) error { return nil },
+				}},
+			}))
+			return err
+		}, "applying server option: readiness check 0: empty name"},
+		"health without host": {func() error {
+			_, err := datapages.NewServer[
+				testApp,
+				datapages.DisableSessions,
+				datapages.EnableOTelMetrics,
+				testServer,
+			](app, broker, datapages.WithHealth(datapages.HealthConfig{}))
+			return err
+		}, "applying server option: WithHealth: empty host address"},
+		"negative readiness delay": {func() error {
+			_, err := datapages.NewServer[
+				testApp,
+				datapages.DisableSessions,
+				datapages.EnablePrometheus,
+				testServer,
+			](app, broker, datapages.WithPrometheus(datapages.PrometheusConfig{
+				Host:           "127.0.0.1:0",
+				ReadinessDelay: -time.Second,
+			}))
+			return err
+		}, "applying server option: negative ReadinessDelay"},
+		"negative heartbeat": {func() error {
+			_, err := datapages.NewServer[
+				testApp,
+				datapages.DisableSessions,
+				datapages.DisablePrometheus,
+				testServer,
+			](app, broker, datapages.WithStreams(datapages.StreamsConfig{
+				Heartbeat: -time.Second,
+			}))
+			return err
+		}, "applying server option: WithStreams: negative Heartbeat"},
+		"jitter beyond lifetime": {func() error {
+			_, err := datapages.NewServer[
+				testApp,
+				datapages.DisableSessions,
+				datapages.DisablePrometheus,
+				testServer,
+			](app, broker, datapages.WithStreams(datapages.StreamsConfig{
+				MaxLifetime:       time.Minute,
+				MaxLifetimeJitter: time.Minute,
+			}))
+			return err
+		}, "applying server option: WithStreams: " +
+			"MaxLifetimeJitter not shorter than MaxLifetime"},
+		"negative deferred timeout": {func() error {
+			_, err := datapages.NewServer[
+				testApp,
+				datapages.DisableSessions,
+				datapages.DisablePrometheus,
+				testServer,
+			](app, broker, datapages.WithDeferredTimeout(-time.Second))
+			return err
+		}, "applying server option: WithDeferredTimeout: negative timeout"},
+		"negative tab state TTL": {func() error {
+			_, err := datapages.NewServer[
+				testApp,
+				datapages.DisableSessions,
+				datapages.DisablePrometheus,
+				testServer,
+			](app, broker, datapages.WithTabState(datapages.TabStateConfig{
+				TTL: -time.Second,
+			}))
+			return err
+		}, "applying server option: WithTabState: negative TTL"},
 		"failing init": {func() error {
 			_, err := datapages.NewServer[
 				testApp,
@@ -251,3 +440,8 @@ func (*testSessionManager) NotifyClosed(context.Context, string, func()) error {
 }
 
 func (*testSessionManager) CloseSession(context.Context, string) error { return nil }
+
+type noMetrics struct{}
+
+func (noMetrics) OnPublish(string)   {}
+func (noMetrics) OnDeliveryDropped() {}